@@ -15,16 +15,23 @@
 package zoekt
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc64"
 	"math"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
+
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -33,6 +40,7 @@ const (
 	sliceHeaderBytes  uint64 = 24
 	stringHeaderBytes uint64 = 16
 	pointerSize       uint64 = 8
+	timeBytes         uint64 = 24
 )
 
 // FileMatch contains all the matches within a file.
@@ -54,6 +62,18 @@ type FileMatch struct {
 	// Commit SHA1 (hex) of the (sub)repo holding the file.
 	Version string `json:",omitempty"`
 
+	// CommitDate is the committer date of Version. It is zero for shards
+	// built before it was recorded.
+	CommitDate time.Time
+
+	// LastModified is the date of the last commit which changed the file. It
+	// is only recorded if the indexer was asked to, see
+	// index.Document.ModTime.
+	LastModified time.Time
+
+	// IndexTime is the time the shard holding the file was built.
+	IndexTime time.Time
+
 	// Detected language of the result.
 	Language string
 
@@ -84,6 +104,92 @@ type FileMatch struct {
 	// RepositoryID is a Sourcegraph extension. This is the ID of Repository in
 	// Sourcegraph.
 	RepositoryID uint32 `json:",omitempty"`
+
+	// Truncated is set if only a prefix of the file was indexed, because it
+	// is larger than the indexer's size limit. Matches beyond the prefix are
+	// not found, and LineCount and FileSize describe the prefix.
+	Truncated bool `json:",omitempty"`
+
+	// Generated is set if the file looks generated or minified, see
+	// index.IsGeneratedFile, or the repository declares it as generated.
+	// Generated files rank lower, and generated:no excludes them.
+	Generated bool `json:",omitempty"`
+
+	// BranchMatches is only set if SearchOptions.GroupByBranch is set and the
+	// file differs between the matching branches. It holds the matches of
+	// each version of the file, best first. The other fields describe the
+	// first one, except for Branches, which lists all matching branches.
+	BranchMatches []BranchMatch `json:",omitempty"`
+
+	// DuplicateRepositories is only set if SearchOptions.DedupByChecksum is
+	// set. It lists the other repositories which contain a file with the same
+	// content, whose matches were collapsed into this one.
+	DuplicateRepositories []string `json:",omitempty"`
+
+	// MatchCount is the number of matches in the file. It can be larger than
+	// the number of matches in LineMatches or ChunkMatches, which
+	// SearchOptions.MaxMatchesPerFile and the display limits truncate, so
+	// that clients can show eg. "87 matches (showing 10)".
+	MatchCount int `json:",omitempty"`
+
+	// LineCount and FileSize are the number of lines and bytes of the file.
+	LineCount int `json:",omitempty"`
+	FileSize  int `json:",omitempty"`
+}
+
+// BranchMatch holds the matches within one version of a file, which is the
+// same on all of Branches. See SearchOptions.GroupByBranch.
+type BranchMatch struct {
+	Branches []string
+
+	// Commit SHA1 (hex) of the (sub)repo holding this version of the file.
+	Version string `json:",omitempty"`
+
+	LineMatches  []LineMatch  `json:",omitempty"`
+	ChunkMatches []ChunkMatch `json:",omitempty"`
+
+	// Only set if requested
+	Content []byte `json:",omitempty"`
+
+	// Checksum of the content.
+	Checksum []byte
+
+	// Ranking; the higher, the better.
+	Score float64 `json:",omitempty"`
+}
+
+func (m *BranchMatch) sizeBytes() (sz uint64) {
+	// Score
+	sz += 8
+
+	// Version
+	sz += stringHeaderBytes + uint64(len(m.Version))
+
+	// Branches
+	sz += sliceHeaderBytes
+	for _, s := range m.Branches {
+		sz += stringHeaderBytes + uint64(len(s))
+	}
+
+	// LineMatches
+	sz += sliceHeaderBytes
+	for _, lm := range m.LineMatches {
+		sz += lm.sizeBytes()
+	}
+
+	// ChunkMatches
+	sz += sliceHeaderBytes
+	for _, cm := range m.ChunkMatches {
+		sz += cm.sizeBytes()
+	}
+
+	// Content
+	sz += sliceHeaderBytes + uint64(len(m.Content))
+
+	// Checksum
+	sz += sliceHeaderBytes + uint64(len(m.Checksum))
+
+	return
 }
 
 func (m *FileMatch) sizeBytes() (sz uint64) {
@@ -102,6 +208,9 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 		sz += stringHeaderBytes + uint64(len(s))
 	}
 
+	// CommitDate, LastModified, IndexTime
+	sz += 3 * timeBytes
+
 	// Branches
 	sz += sliceHeaderBytes
 	for _, s := range m.Branches {
@@ -126,12 +235,30 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 	// RepositoryPriority
 	sz += 8
 
+	// MatchCount, LineCount, FileSize
+	sz += 3 * 8
+
+	// Truncated, Generated
+	sz += 2
+
 	// Content
 	sz += sliceHeaderBytes + uint64(len(m.Content))
 
 	// Checksum
 	sz += sliceHeaderBytes + uint64(len(m.Checksum))
 
+	// BranchMatches
+	sz += sliceHeaderBytes
+	for _, bm := range m.BranchMatches {
+		sz += bm.sizeBytes()
+	}
+
+	// DuplicateRepositories
+	sz += sliceHeaderBytes
+	for _, s := range m.DuplicateRepositories {
+		sz += stringHeaderBytes + uint64(len(s))
+	}
+
 	return
 }
 
@@ -238,10 +365,13 @@ type Location struct {
 	LineNumber uint32
 	// 1-based column number (in runes) from the beginning of line
 	Column uint32
+	// 1-based column number in UTF-16 code units from the beginning of line.
+	// Only set if SearchOptions.OffsetEncoding is OffsetEncodingUTF16.
+	ColumnUTF16 uint32 `json:",omitempty"`
 }
 
 func (l *Location) sizeBytes() uint64 {
-	return 3 * 4
+	return 4 * 4
 }
 
 // LineMatch holds the matches within a single line in a file.
@@ -307,10 +437,15 @@ type Symbol struct {
 	Kind       string
 	Parent     string
 	ParentKind string
+
+	// Signature is the signature of the symbol as reported by ctags, eg the
+	// parameter list of a function. It is empty for shards indexed before
+	// signatures were stored.
+	Signature string
 }
 
 func (s *Symbol) sizeBytes() uint64 {
-	return 4*stringHeaderBytes + uint64(len(s.Sym)+len(s.Kind)+len(s.Parent)+len(s.ParentKind))
+	return 5*stringHeaderBytes + uint64(len(s.Sym)+len(s.Kind)+len(s.Parent)+len(s.ParentKind)+len(s.Signature))
 }
 
 // LineFragmentMatch a segment of matching text within a line.
@@ -324,6 +459,16 @@ type LineFragmentMatch struct {
 	// Number bytes that match.
 	MatchLength int
 
+	// LineOffset and MatchLength in runes. Only set if
+	// SearchOptions.OffsetEncoding is OffsetEncodingRunes.
+	LineOffsetRunes  int `json:",omitempty"`
+	MatchLengthRunes int `json:",omitempty"`
+
+	// LineOffset and MatchLength in UTF-16 code units. Only set if
+	// SearchOptions.OffsetEncoding is OffsetEncodingUTF16.
+	LineOffsetUTF16  int `json:",omitempty"`
+	MatchLengthUTF16 int `json:",omitempty"`
+
 	SymbolInfo *Symbol
 }
 
@@ -337,6 +482,9 @@ func (lfm *LineFragmentMatch) sizeBytes() (sz uint64) {
 	// MatchLength
 	sz += 8
 
+	// LineOffsetRunes, MatchLengthRunes, LineOffsetUTF16, MatchLengthUTF16
+	sz += 4 * 8
+
 	// SymbolInfo
 	sz += pointerSize
 	if lfm.SymbolInfo != nil {
@@ -399,6 +547,18 @@ type Stats struct {
 	// gathered enough matches.
 	FilesSkipped int
 
+	// Candidate files which were not evaluated because the file or its
+	// repository has been tombstoned.
+	FilesSkippedTombstoned int
+
+	// Candidate files whose content was not indexed because it exceeded the
+	// maximum file size. Only their file names are searchable.
+	FilesSkippedSize int
+
+	// Candidate files whose content was not indexed because it looked like
+	// binary data. Only their file names are searchable.
+	FilesSkippedBinary int
+
 	// Shards that we scanned to find matches.
 	ShardsScanned int
 
@@ -437,7 +597,7 @@ type Stats struct {
 }
 
 func (s *Stats) sizeBytes() (sz uint64) {
-	sz = 16 * 8 // This assumes we are running on a 64-bit architecture
+	sz = 19 * 8 // This assumes we are running on a 64-bit architecture
 	sz += 1     // FlushReason
 
 	return
@@ -451,6 +611,9 @@ func (s *Stats) Add(o Stats) {
 	s.FilesConsidered += o.FilesConsidered
 	s.FilesLoaded += o.FilesLoaded
 	s.FilesSkipped += o.FilesSkipped
+	s.FilesSkippedTombstoned += o.FilesSkippedTombstoned
+	s.FilesSkippedSize += o.FilesSkippedSize
+	s.FilesSkippedBinary += o.FilesSkippedBinary
 	s.MatchCount += o.MatchCount
 	s.NgramMatches += o.NgramMatches
 	s.NgramLookups += o.NgramLookups
@@ -483,6 +646,9 @@ func (s *Stats) Zero() bool {
 		s.FilesConsidered > 0 ||
 		s.FilesLoaded > 0 ||
 		s.FilesSkipped > 0 ||
+		s.FilesSkippedTombstoned > 0 ||
+		s.FilesSkippedSize > 0 ||
+		s.FilesSkippedBinary > 0 ||
 		s.MatchCount > 0 ||
 		s.NgramMatches > 0 ||
 		s.NgramLookups > 0 ||
@@ -515,6 +681,38 @@ func (p *Progress) sizeBytes() uint64 {
 	return 2 * 8
 }
 
+// SearchProgress reports how far a streaming search got, see
+// SearchOptions.ProgressInterval. Unlike Stats, it is not a delta: each report
+// covers the whole search so far.
+type SearchProgress struct {
+	// ShardsScanned is the number of shards whose search finished, out of
+	// ShardsTotal shards selected for the query.
+	ShardsScanned int
+	ShardsTotal   int
+
+	// MatchCount is the number of matches found so far.
+	MatchCount int
+
+	// BytesLoaded is the amount of content and index I/O so far.
+	BytesLoaded int64
+}
+
+// Add adds the progress of another search to p, eg. to report the progress
+// of searching several backends.
+func (p *SearchProgress) Add(o SearchProgress) {
+	p.ShardsScanned += o.ShardsScanned
+	p.ShardsTotal += o.ShardsTotal
+	p.MatchCount += o.MatchCount
+	p.BytesLoaded += o.BytesLoaded
+}
+
+func (p *SearchProgress) sizeBytes() uint64 {
+	if p == nil {
+		return pointerSize
+	}
+	return pointerSize + 4*8
+}
+
 // SearchResult contains search matches and extra data
 type SearchResult struct {
 	Stats
@@ -522,6 +720,11 @@ type SearchResult struct {
 	// Do not encode this as we cannot encode -Inf in JSON
 	Progress `json:"-"`
 
+	// SearchProgress is only set on progress events, which carry nothing
+	// else. Streaming searches send them every
+	// SearchOptions.ProgressInterval.
+	SearchProgress *SearchProgress `json:",omitempty"`
+
 	Files []FileMatch
 
 	// RepoURLs holds a repo => template string map.
@@ -530,6 +733,10 @@ type SearchResult struct {
 	// FragmentNames holds a repo => template string map, for
 	// the line number fragment.
 	LineFragments map[string]string
+
+	// Suggestions are rewrites of a query without results which have
+	// results, best first. See SearchOptions.MaxSuggestions.
+	Suggestions []Suggestion `json:",omitempty"`
 }
 
 // SizeBytes is a best-effort estimate of the size of SearchResult in memory.
@@ -538,6 +745,7 @@ type SearchResult struct {
 func (sr *SearchResult) SizeBytes() (sz uint64) {
 	sz += sr.Stats.sizeBytes()
 	sz += sr.Progress.sizeBytes()
+	sz += sr.SearchProgress.sizeBytes()
 
 	// Files
 	sz += sliceHeaderBytes
@@ -559,6 +767,12 @@ func (sr *SearchResult) SizeBytes() (sz uint64) {
 		sz += stringHeaderBytes + uint64(len(v))
 	}
 
+	// Suggestions
+	sz += sliceHeaderBytes
+	for _, s := range sr.Suggestions {
+		sz += 2*stringHeaderBytes + uint64(len(s.Pattern)+len(s.Replacement))
+	}
+
 	return
 }
 
@@ -567,6 +781,23 @@ func (sr *SearchResult) SizeBytes() (sz uint64) {
 type RepositoryBranch struct {
 	Name    string
 	Version string
+
+	// CommitDate is the committer date of Version.
+	CommitDate time.Time
+
+	// Description is a free-form description of the branch, eg. its
+	// purpose. Unlike the other fields, it can be updated without
+	// reindexing.
+	Description string `json:",omitempty"`
+}
+
+// BranchVersionsEqual returns true if a and b are the same branches at the
+// same versions, ie. they have the same content. Descriptions are ignored.
+func BranchVersionsEqual(a, b []RepositoryBranch) bool {
+	return slices.EqualFunc(a, b, func(x, y RepositoryBranch) bool {
+		x.Description, y.Description = "", ""
+		return reflect.DeepEqual(x, y)
+	})
 }
 
 func (r RepositoryBranch) String() string {
@@ -642,6 +873,43 @@ type Repository struct {
 	// FileTombstones is a set of file paths that should be ignored across all branches
 	// in this shard.
 	FileTombstones map[string]struct{} `json:",omitempty"`
+
+	// License is the lowercase SPDX license expression of the license
+	// detected in the root of the repository, eg. "apache-2.0" or
+	// "mit or apache-2.0". If the indexed branches carry different licenses,
+	// their expressions are combined with "and". It is empty if no license
+	// was recognized.
+	License string `json:",omitempty"`
+
+	// Shallow is true if the repository was indexed from a shallow clone. Its
+	// history may be truncated and branches missing from the clone were not
+	// indexed.
+	Shallow bool `json:",omitempty"`
+
+	// State is set if the repository has no shards because it could not be
+	// indexed, eg. because it is empty or failed to clone. It is one of the
+	// index.RepoState values. Such repositories are listed, but have no
+	// documents to search.
+	State string `json:",omitempty"`
+
+	// Description is a short human readable description of the repository,
+	// and Homepage the URL of its website. Both are set by the mirror
+	// commands from the code host, through the zoekt.description and
+	// zoekt.homepage git config options.
+	Description string `json:",omitempty"`
+	Homepage    string `json:",omitempty"`
+
+	// Languages counts the files and bytes per language of the documents in
+	// this shard, computed when the shard is built. Generated files, skipped
+	// files and commits are not counted. List reports them summed up across
+	// the shards of a repository in RepoStats.Languages instead.
+	Languages map[string]LanguageStats `json:",omitempty"`
+}
+
+// LanguageStats is the number of files of a language and their size.
+type LanguageStats struct {
+	Files int
+	Bytes int64
 }
 
 func (r *Repository) UnmarshalJSON(data []byte) error {
@@ -667,14 +935,21 @@ func (r *Repository) UnmarshalJSON(data []byte) error {
 
 	// Sourcegraph indexserver doesn't set repo.Rank, so we set it here. Setting it
 	// on read instead of during indexing allows us to avoid a complete reindex.
-	//
-	// Prefer "latestCommitDate" over "priority" for ranking. We keep priority for
-	// backwards compatibility.
-	if _, ok := repo.RawConfig["latestCommitDate"]; ok {
+	r.setRank()
+	return nil
+}
+
+// setRank sets the rank of r from its RawConfig, unless it is already set.
+//
+// Prefer "latestCommitDate" over "priority" for ranking. We keep priority for
+// backwards compatibility.
+func (r *Repository) setRank() {
+	if _, ok := r.RawConfig["latestCommitDate"]; ok {
 		// We use the number of months since 1970 as a simple measure of repo freshness.
 		// It is monotonically increasing and stable across re-indexes and restarts.
-		r.Rank = monthsSince1970(repo.LatestCommitDate)
-	} else if v, ok := repo.RawConfig["priority"]; ok {
+		r.Rank = monthsSince1970(r.LatestCommitDate)
+	} else if v, ok := r.RawConfig["priority"]; ok {
+		var err error
 		r.priority, err = strconv.ParseFloat(v, 64)
 		if err != nil {
 			r.priority = 0
@@ -690,8 +965,6 @@ func (r *Repository) UnmarshalJSON(data []byte) error {
 			r.Rank = uint16(r.priority / (5000.0 + r.priority) * math.MaxUint16)
 		}
 	}
-
-	return nil
 }
 
 func (r *Repository) GetPriority() float64 {
@@ -717,7 +990,10 @@ func monthsSince1970(t time.Time) uint16 {
 // computed while indexing so can't be synthesized from x.
 //
 // Note: We ignore RawConfig fields which are duplicated into Repository:
-// name and id.
+// name and id. A changed priority recomputes the rank.
+//
+// Note: Branch descriptions are only merged if set in x, like RawConfig keys
+// are never removed.
 func (r *Repository) MergeMutable(x *Repository) (mutated bool, err error) {
 	if r.ID != x.ID {
 		// Sourcegraph: strange behaviour may occur if ID changes but names don't.
@@ -728,10 +1004,18 @@ func (r *Repository) MergeMutable(x *Repository) (mutated bool, err error) {
 		// changes.
 		return mutated, errors.New("Name is immutable")
 	}
-	if !reflect.DeepEqual(r.Branches, x.Branches) {
+	if !BranchVersionsEqual(r.Branches, x.Branches) {
 		// Need a reindex if content changing.
 		return mutated, errors.New("Branches is immutable")
 	}
+	for i, b := range x.Branches {
+		if b.Description != "" && r.Branches[i].Description != b.Description {
+			mutated = true
+			r.Branches[i].Description = b.Description
+		}
+	}
+
+	priorityChanged := false
 
 	for k, v := range x.RawConfig {
 		// We ignore name and id since they are encoded into the repository.
@@ -744,9 +1028,14 @@ func (r *Repository) MergeMutable(x *Repository) (mutated bool, err error) {
 		}
 		if r.RawConfig[k] != v {
 			mutated = true
+			priorityChanged = priorityChanged || k == "priority"
 			r.RawConfig[k] = v
 		}
 	}
+	if priorityChanged {
+		r.Rank = 0
+		r.setRank()
+	}
 
 	if r.URL != x.URL {
 		mutated = true
@@ -823,6 +1112,11 @@ type RepoStats struct {
 	// OtherBranchesNewLinesCount is the number of newlines "\n" in all branches
 	// except the default branch.
 	OtherBranchesNewLinesCount uint64
+
+	// Languages counts the files and bytes per language, see
+	// Repository.Languages. Repositories indexed with delta builds still count
+	// the old versions of changed files until their next full build.
+	Languages map[string]LanguageStats `json:",omitempty"`
 }
 
 func (s *RepoStats) Add(o *RepoStats) {
@@ -837,6 +1131,18 @@ func (s *RepoStats) Add(o *RepoStats) {
 	s.NewLinesCount += o.NewLinesCount
 	s.DefaultBranchNewLinesCount += o.DefaultBranchNewLinesCount
 	s.OtherBranchesNewLinesCount += o.OtherBranchesNewLinesCount
+
+	// We add up in place, so s must not share its Languages with other
+	// RepoStats, eg. the ones of a shard.
+	if len(o.Languages) > 0 && s.Languages == nil {
+		s.Languages = make(map[string]LanguageStats, len(o.Languages))
+	}
+	for lang, ls := range o.Languages {
+		sum := s.Languages[lang]
+		sum.Files += ls.Files
+		sum.Bytes += ls.Bytes
+		s.Languages[lang] = sum
+	}
 }
 
 type RepoListEntry struct {
@@ -962,6 +1268,14 @@ type SearchOptions struct {
 	// once we have this many matches across shards.
 	TotalMaxMatchCount int
 
+	// AdaptiveShardMaxMatchCount replaces the uniform ShardMaxMatchCount by a
+	// per shard limit. TotalMaxMatchCount is divided among the shards
+	// proportionally to their estimated number of matches, based on the
+	// frequency of the query's ngrams, capped at ShardMaxMatchCount. This improves the
+	// diversity of results for broad queries on skewed corpora, where a few
+	// large shards would otherwise use up the total budget.
+	AdaptiveShardMaxMatchCount bool
+
 	// Maximum number of matches: skip processing documents for a repository in
 	// a shard once we have found ShardRepoMaxMatchCount.
 	//
@@ -969,6 +1283,19 @@ type SearchOptions struct {
 	// be set to 1 to find all repositories containing a result.
 	ShardRepoMaxMatchCount int
 
+	// MaxMatchesPerFile, if set, limits the matches of each file. A shard
+	// stops gathering the matches of a file once it has this many, in the
+	// order they appear in the file, so files with thousands of matches, eg.
+	// generated files, don't load the content of all of them. The score of
+	// the file only considers the kept matches.
+	MaxMatchesPerFile int
+
+	// MaxFilesPerRepo, if set, limits the matching files of each repository.
+	// Shards skip the remaining documents of a repository once it has this
+	// many matching files, and the files of repositories split across shards
+	// are truncated to the best ranked ones, like MaxDocDisplayCount.
+	MaxFilesPerRepo int
+
 	// Abort the search after this much time has passed.
 	MaxWallTime time.Duration
 
@@ -977,6 +1304,12 @@ type SearchOptions struct {
 	// be sent and then the behaviour will revert to the normal streaming.
 	FlushWallTime time.Duration
 
+	// ProgressInterval, if non-zero, makes StreamSearch send a progress event
+	// every ProgressInterval while shards are searched, eg. so that a UI can
+	// show a progress bar for slow searches. A progress event is a
+	// SearchResult with only SearchProgress set.
+	ProgressInterval time.Duration
+
 	// Truncates the number of documents (i.e. files) after collating and
 	// sorting the results.
 	MaxDocDisplayCount int
@@ -984,10 +1317,20 @@ type SearchOptions struct {
 	// Truncates the number of matchs after collating and sorting the results.
 	MaxMatchDisplayCount int
 
+	// MaxSuggestions, if set, makes Search return up to this many
+	// suggestions in SearchResult.Suggestions if the query has no results,
+	// eg. for a misspelled identifier. Suggestions are only computed for
+	// searches without results and each one is searched to check that it
+	// has results, so they cost about as much as the search itself.
+	MaxSuggestions int
+
 	// If set to a number greater than zero then up to this many number
 	// of context lines will be added before and after each matched line.
 	// Note that the included context lines might contain matches and
 	// it's up to the consumer of the result to remove those lines.
+	//
+	// LineMatches carry the context in Before and After. ChunkMatches include
+	// it in Content, and chunks whose context overlaps are merged.
 	NumContextLines int
 
 	// If true, ChunkMatches will be returned in each FileMatch rather than LineMatches
@@ -999,9 +1342,10 @@ type SearchOptions struct {
 	// and computes an approximation to BM25. When enabled, BM25 scoring is used for
 	// the overall FileMatch score, as well as individual LineMatch and ChunkMatch scores.
 	//
-	// The calculation of IDF assumes that Zoekt visits all documents containing any
-	// of the query terms during evaluation. This is true, for example, if all query
-	// terms are ORed together.
+	// The calculation of IDF uses the document frequencies stored in shards built
+	// with index.Options.DocumentFrequencies. Otherwise it assumes that Zoekt visits
+	// all documents containing any of the query terms during evaluation. This is
+	// true, for example, if all query terms are ORed together.
 	//
 	// When enabled, all other scoring signals are ignored, including document ranks.
 	UseBM25Scoring bool
@@ -1015,6 +1359,191 @@ type SearchOptions struct {
 
 	// SpanContext is the opentracing span context, if it exists, from the zoekt client
 	SpanContext map[string]string
+
+	// If true, results for the same file on different branches are merged
+	// into one FileMatch, whose BranchMatches hold the matches of each version
+	// of the file. Versions with the same content are merged as well. Stats
+	// still count each version. When streaming, this only merges results of
+	// the same event.
+	GroupByBranch bool
+
+	// If true, files with the same content in different repositories, eg. in
+	// forks, are collapsed into the best ranked FileMatch, whose
+	// DuplicateRepositories lists the other repositories. Files are compared
+	// by their Checksum. Copies within the same repository are kept, and
+	// Stats still count every copy. When streaming, this only collapses
+	// results of the same event.
+	DedupByChecksum bool
+
+	// RecencyHalfLife, if set, ranks recently modified files higher. Files
+	// get a boost which halves every RecencyHalfLife since their
+	// FileMatch.LastModified, or CommitDate if the index doesn't record it.
+	// It has no effect with UseBM25Scoring.
+	RecencyHalfLife time.Duration
+
+	// PathBoosts multiply the score of the files whose path matches their
+	// pattern, eg. to demote vendored code or tests, or to boost
+	// documentation. If several match, the one with the longest pattern
+	// applies. boost: atoms of the query are added to them, see
+	// query.PathBoost for the pattern syntax.
+	PathBoosts []query.PathBoost `json:",omitempty"`
+
+	// MaxEstimatedCost, if set, rejects queries whose estimated cost summed
+	// over all searched shards exceeds it with a QueryTooExpensiveError,
+	// before any shard is searched. The cost is in the unit of
+	// ShardExplanation.Cost, roughly the number of bytes read.
+	MaxEstimatedCost int64
+
+	// RepoFilter, if set, restricts the search to the repositories whose IDs
+	// it contains. Shards without any of these repositories are skipped
+	// before they are searched. Unlike query.RepoIDs it is set by the server,
+	// eg. to enforce which repositories a user may see, so it is not part of
+	// the JSON API.
+	RepoFilter *roaring.Bitmap `json:"-"`
+
+	// Fields, if set, limits the optional parts of each FileMatch which are
+	// returned, eg. FieldFileName for clients which only list the matching
+	// files. Shards skip the work for the omitted parts, like assembling the
+	// content around the matches. If zero, all fields are returned.
+	Fields FileMatchFields `json:",omitempty"`
+
+	// OffsetEncoding, if set, makes the matches report their columns and
+	// offsets in runes or UTF-16 code units as well as in bytes, so that
+	// clients such as editors speaking LSP or JavaScript frontends can
+	// highlight matches without decoding the content.
+	OffsetEncoding OffsetEncoding `json:",omitempty"`
+}
+
+// OffsetEncoding is a unit in which matches report their positions in
+// addition to bytes, see SearchOptions.OffsetEncoding. In JSON it is one of
+// "bytes", "runes" or "utf-16".
+type OffsetEncoding int
+
+const (
+	// OffsetEncodingBytes only reports byte offsets, and Location.Column in
+	// runes.
+	OffsetEncodingBytes OffsetEncoding = iota
+
+	// OffsetEncodingRunes also sets LineFragmentMatch.LineOffsetRunes and
+	// MatchLengthRunes. Location.Column is always in runes.
+	OffsetEncodingRunes
+
+	// OffsetEncodingUTF16 also sets LineFragmentMatch.LineOffsetUTF16,
+	// MatchLengthUTF16 and Location.ColumnUTF16. Runes outside of the Basic
+	// Multilingual Plane, eg. emoji, count twice.
+	OffsetEncodingUTF16
+)
+
+var offsetEncodingNames = []string{
+	OffsetEncodingBytes: "bytes",
+	OffsetEncodingRunes: "runes",
+	OffsetEncodingUTF16: "utf-16",
+}
+
+func (e OffsetEncoding) String() string {
+	if e < 0 || int(e) >= len(offsetEncodingNames) {
+		return fmt.Sprintf("OffsetEncoding(%d)", int(e))
+	}
+	return offsetEncodingNames[e]
+}
+
+func (e OffsetEncoding) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+func (e *OffsetEncoding) UnmarshalText(text []byte) error {
+	for i, name := range offsetEncodingNames {
+		if name == string(text) {
+			*e = OffsetEncoding(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown offset encoding %q, want one of bytes, runes or utf-16", text)
+}
+
+// FileMatchFields is a set of optional parts of a FileMatch, see
+// SearchOptions.Fields. The file name, repository, branches, version and
+// score of a FileMatch are always returned.
+//
+// In JSON it is a comma separated list of the field names, eg.
+// "matches,checksum".
+type FileMatchFields uint32
+
+const (
+	// FieldFileName is the file name, which is always returned. Fields set
+	// to just FieldFileName only returns the always returned parts.
+	FieldFileName FileMatchFields = 1 << iota
+
+	// FieldMatches are the LineMatches or ChunkMatches. Without them, files
+	// are ranked without the scores of their matching lines.
+	FieldMatches
+
+	// FieldChecksum is FileMatch.Checksum. It is also returned if
+	// SearchOptions.GroupByBranch or DedupByChecksum need it.
+	FieldChecksum
+
+	// FieldStats are FileMatch.MatchCount, LineCount and FileSize.
+	FieldStats
+)
+
+var fileMatchFieldNames = []struct {
+	field FileMatchFields
+	name  string
+}{
+	{FieldFileName, "filename"},
+	{FieldMatches, "matches"},
+	{FieldChecksum, "checksum"},
+	{FieldStats, "stats"},
+}
+
+// Has returns true if f includes all of fields. A zero f includes all
+// fields.
+func (f FileMatchFields) Has(fields FileMatchFields) bool {
+	return f == 0 || f&fields == fields
+}
+
+func (f FileMatchFields) String() string {
+	var names []string
+	for _, fn := range fileMatchFieldNames {
+		if f&fn.field != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFileMatchFields parses a comma separated list of field names, see
+// FileMatchFields.
+func ParseFileMatchFields(s string) (FileMatchFields, error) {
+	var f FileMatchFields
+	if s == "" {
+		return f, nil
+	}
+nextName:
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		for _, fn := range fileMatchFieldNames {
+			if fn.name == name {
+				f |= fn.field
+				continue nextName
+			}
+		}
+		return 0, fmt.Errorf("unknown field %q, want one of filename, matches, checksum or stats", name)
+	}
+	return f, nil
+}
+
+func (f FileMatchFields) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *FileMatchFields) UnmarshalText(text []byte) error {
+	parsed, err := ParseFileMatchFields(string(text))
+	if err != nil {
+		return err
+	}
+	*f = parsed
+	return nil
 }
 
 func (o *SearchOptions) SetDefaults() {
@@ -1066,19 +1595,46 @@ func (s *SearchOptions) String() string {
 	addInt("ShardMaxMatchCount", s.ShardMaxMatchCount)
 	addInt("TotalMaxMatchCount", s.TotalMaxMatchCount)
 	addInt("ShardRepoMaxMatchCount", s.ShardRepoMaxMatchCount)
+	addInt("MaxMatchesPerFile", s.MaxMatchesPerFile)
+	addInt("MaxFilesPerRepo", s.MaxFilesPerRepo)
 	addInt("MaxDocDisplayCount", s.MaxDocDisplayCount)
 	addInt("MaxMatchDisplayCount", s.MaxMatchDisplayCount)
+	addInt("MaxSuggestions", s.MaxSuggestions)
 	addInt("NumContextLines", s.NumContextLines)
+	if s.MaxEstimatedCost != 0 {
+		add("MaxEstimatedCost", strconv.FormatInt(s.MaxEstimatedCost, 10))
+	}
+	if s.RepoFilter != nil {
+		add("RepoFilter", strconv.FormatUint(s.RepoFilter.GetCardinality(), 10))
+	}
+	if s.Fields != 0 {
+		add("Fields", s.Fields.String())
+	}
+	if s.OffsetEncoding != OffsetEncodingBytes {
+		add("OffsetEncoding", s.OffsetEncoding.String())
+	}
+	if len(s.PathBoosts) > 0 {
+		boosts := make([]string, len(s.PathBoosts))
+		for i, b := range s.PathBoosts {
+			boosts[i] = b.Pattern + ":" + strconv.FormatFloat(b.Boost, 'g', -1, 64)
+		}
+		add("PathBoosts", strings.Join(boosts, ","))
+	}
 
 	addDuration("MaxWallTime", s.MaxWallTime)
 	addDuration("FlushWallTime", s.FlushWallTime)
+	addDuration("ProgressInterval", s.ProgressInterval)
+	addDuration("RecencyHalfLife", s.RecencyHalfLife)
 
 	addBool("EstimateDocCount", s.EstimateDocCount)
+	addBool("AdaptiveShardMaxMatchCount", s.AdaptiveShardMaxMatchCount)
 	addBool("Whole", s.Whole)
 	addBool("ChunkMatches", s.ChunkMatches)
 	addBool("UseBM25Scoring", s.UseBM25Scoring)
 	addBool("Trace", s.Trace)
 	addBool("DebugScore", s.DebugScore)
+	addBool("GroupByBranch", s.GroupByBranch)
+	addBool("DedupByChecksum", s.DedupByChecksum)
 
 	for k, v := range s.SpanContext {
 		add("SpanContext."+k, strconv.Quote(v))
@@ -1102,8 +1658,346 @@ func (f SenderFunc) Send(result *SearchResult) {
 	f(result)
 }
 
+// ListSender is the interface that wraps the Send method for the batches of
+// StreamList.
+type ListSender interface {
+	Send(*RepoList)
+}
+
+// ListSenderFunc is an adapter to allow the use of ordinary functions as
+// ListSender.
+type ListSenderFunc func(list *RepoList)
+
+func (f ListSenderFunc) Send(list *RepoList) {
+	f(list)
+}
+
 // Streamer adds the method StreamSearch to the Searcher interface.
 type Streamer interface {
 	Searcher
 	StreamSearch(ctx context.Context, q query.Q, opts *SearchOptions, sender Sender) (err error)
 }
+
+// ListStreamer is implemented by searchers which can send the result of List
+// in batches.
+type ListStreamer interface {
+	// StreamList is like List, but sends the repositories in batches instead
+	// of all at once. Each batch carries the Crashes and Stats which were not
+	// sent with an earlier batch, so adding up the batches yields the result
+	// of List.
+	StreamList(ctx context.Context, q query.Q, opts *ListOptions, sender ListSender) (err error)
+}
+
+// StreamList sends the repositories matching q to sender. If s implements
+// ListStreamer they are sent in batches, otherwise the result of List is sent
+// as a single batch.
+func StreamList(ctx context.Context, s Searcher, q query.Q, opts *ListOptions, sender ListSender) error {
+	if ls, ok := s.(ListStreamer); ok {
+		return ls.StreamList(ctx, q, opts, sender)
+	}
+
+	rl, err := s.List(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(rl)
+	return nil
+}
+
+// MaxBatchSize is the maximum number of queries BatchSearch evaluates in one
+// call. Every query of a batch is still evaluated on its own, so a batch costs
+// about as much as the same number of searches.
+const MaxBatchSize = 64
+
+// BatchSearcher is implemented by searchers which evaluate several queries in
+// one call, eg. to share a scheduler slot between them.
+type BatchSearcher interface {
+	// BatchSearch returns one result per query in qs, in the same order.
+	BatchSearch(ctx context.Context, qs []query.Q, opts *SearchOptions) ([]*SearchResult, error)
+}
+
+// BatchSearch evaluates all queries in qs. If s implements BatchSearcher it
+// evaluates the batch, otherwise the queries are searched one after another.
+// BatchSearch is a convenience for callers with many queries; it doesn't make
+// them cheaper. It returns an error if qs has more than MaxBatchSize queries.
+func BatchSearch(ctx context.Context, s Searcher, qs []query.Q, opts *SearchOptions) ([]*SearchResult, error) {
+	if len(qs) > MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d queries exceeds the maximum of %d", len(qs), MaxBatchSize)
+	}
+
+	if bs, ok := s.(BatchSearcher); ok {
+		return bs.BatchSearch(ctx, qs, opts)
+	}
+
+	results := make([]*SearchResult, 0, len(qs))
+	for _, q := range qs {
+		sr, err := s.Search(ctx, q, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, sr)
+	}
+	return results, nil
+}
+
+// ContentChecksum returns the checksum of content as stored for each document
+// in a shard, see FileMatch.Checksum.
+func ContentChecksum(content []byte) []byte {
+	return binary.BigEndian.AppendUint64(nil, crc64.Checksum(content, crc64ISOTable))
+}
+
+var crc64ISOTable = crc64.MakeTable(crc64.ISO)
+
+// FindDuplicates returns all documents whose content is identical to content,
+// eg. to find where a file was copied to. If q is not nil, only documents
+// matching q are considered. Candidates are found by their checksum, see
+// query.Checksum, so no extra index data is needed, and then compared with
+// content to rule out collisions. Documents which were not indexed, eg.
+// because they are binary or too large, are never found.
+func FindDuplicates(ctx context.Context, s Searcher, q query.Q, content []byte, opts *SearchOptions) ([]FileMatch, error) {
+	var dq query.Q = &query.Checksum{Prefix: hex.EncodeToString(ContentChecksum(content))}
+	if q != nil {
+		dq = query.NewAnd(q, dq)
+	}
+
+	var searchOpts SearchOptions
+	if opts != nil {
+		searchOpts = *opts
+	}
+	searchOpts.Whole = true
+
+	sr, err := s.Search(ctx, dq, &searchOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileMatch
+	for _, f := range sr.Files {
+		if !bytes.Equal(f.Content, content) {
+			continue
+		}
+		if opts == nil || !opts.Whole {
+			f.Content = nil
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// DocumentRequest identifies a document to fetch with FetchDocument.
+type DocumentRequest struct {
+	Repository string
+	FileName   string
+
+	// Branch selects the version of the document on this branch. If it is
+	// empty, the version on the first indexed branch which has the document,
+	// usually HEAD, is returned.
+	Branch string
+}
+
+// Document is a whole document as it is stored in the index, so that clients
+// can show a file without access to the repository it was indexed from.
+type Document struct {
+	Repository   string
+	RepositoryID uint32
+	FileName     string
+
+	// Branches are the branches which have this version of the document.
+	Branches []string
+
+	// Version is the commit of the requested branch, or of the first of
+	// Branches.
+	Version  string
+	Language string
+	Checksum []byte
+	Content  []byte
+
+	// Symbols are the symbol definitions found in Content, ordered by their
+	// offset. Only searchers which implement DocumentFetcher return them.
+	Symbols []DocumentSymbol
+}
+
+// DocumentSymbol is a symbol definition in Document.Content.
+type DocumentSymbol struct {
+	// Start and End are the byte offsets of the definition in
+	// Document.Content.
+	Start, End uint32
+
+	Symbol
+}
+
+// ErrDocumentNotFound is returned by FetchDocument if no indexed document
+// matches the request.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// DocumentFetcher is implemented by searchers which can look up a document
+// in their shards directly instead of searching for it.
+type DocumentFetcher interface {
+	FetchDocument(ctx context.Context, req *DocumentRequest) (*Document, error)
+}
+
+// FetchDocument returns the document req identifies. If s implements
+// DocumentFetcher the document is looked up directly, otherwise it is
+// searched for by its repository and file name, and returned without its
+// symbols.
+func FetchDocument(ctx context.Context, s Searcher, req *DocumentRequest) (*Document, error) {
+	if df, ok := s.(DocumentFetcher); ok {
+		return df.FetchDocument(ctx, req)
+	}
+
+	qs := []query.Q{
+		query.NewRepoSet(req.Repository),
+		&query.FileNameSet{Set: map[string]struct{}{req.FileName: {}}},
+	}
+	if req.Branch != "" {
+		qs = append(qs, &query.Branch{Pattern: req.Branch, Exact: true})
+	}
+	sr, err := s.Search(ctx, query.NewAnd(qs...), &SearchOptions{Whole: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Files) == 0 {
+		return nil, ErrDocumentNotFound
+	}
+
+	f := &sr.Files[0]
+	for i := range sr.Files {
+		if slices.Contains(sr.Files[i].Branches, "HEAD") {
+			f = &sr.Files[i]
+			break
+		}
+	}
+	return &Document{
+		Repository:   f.Repository,
+		RepositoryID: f.RepositoryID,
+		FileName:     f.FileName,
+		Branches:     f.Branches,
+		Version:      f.Version,
+		Language:     f.Language,
+		Checksum:     f.Checksum,
+		Content:      f.Content,
+	}, nil
+}
+
+// ErrExplainNotSupported is returned by Explain if a searcher can't explain
+// how it evaluates queries, eg. because it forwards them to other servers.
+var ErrExplainNotSupported = errors.New("searcher does not support explaining queries")
+
+// Explainer is implemented by searchers which can explain how they evaluate a
+// query without running it.
+type Explainer interface {
+	Explain(ctx context.Context, q query.Q, opts *SearchOptions) (*Explanation, error)
+}
+
+// Explain explains how s evaluates q. It returns ErrExplainNotSupported if s
+// does not implement Explainer.
+func Explain(ctx context.Context, s Searcher, q query.Q, opts *SearchOptions) (*Explanation, error) {
+	if e, ok := s.(Explainer); ok {
+		return e.Explain(ctx, q, opts)
+	}
+	return nil, ErrExplainNotSupported
+}
+
+// Suggestion is a rewrite of a query without results: every substring
+// Pattern is replaced by Replacement.
+type Suggestion struct {
+	Pattern     string
+	Replacement string
+}
+
+// Apply returns q with the substrings matching s.Pattern replaced by
+// s.Replacement. Like case:auto, the replaced substrings are case sensitive
+// if the replacement contains upper case characters.
+func (s Suggestion) Apply(q query.Q) query.Q {
+	return query.Map(q, func(q query.Q) query.Q {
+		sq, ok := q.(*query.Substring)
+		if !ok || sq.Pattern != s.Pattern {
+			return q
+		}
+		cp := *sq
+		cp.Pattern = s.Replacement
+		cp.CaseSensitive = s.Replacement != strings.ToLower(s.Replacement)
+		return &cp
+	})
+}
+
+// Suggester is implemented by shards which can suggest rewrites of a query,
+// see SearchOptions.MaxSuggestions. The suggestions are candidates ordered
+// best first, which may have no results when searched.
+type Suggester interface {
+	Suggest(ctx context.Context, q query.Q, opts *SearchOptions) ([]Suggestion, error)
+}
+
+// CostEstimator is implemented by shards which can estimate the cost of a
+// query before they search it, see SearchOptions.MaxEstimatedCost.
+type CostEstimator interface {
+	EstimateCost(ctx context.Context, q query.Q, opts *SearchOptions) (int64, error)
+}
+
+// QueryTooExpensiveError is returned by Search if the estimated cost of a
+// query exceeds SearchOptions.MaxEstimatedCost.
+type QueryTooExpensiveError struct {
+	// Cost is the estimated cost, which may be a lower bound since
+	// estimation stops once MaxCost is exceeded.
+	Cost    int64
+	MaxCost int64
+}
+
+func (e *QueryTooExpensiveError) Error() string {
+	return fmt.Sprintf("query is too expensive to run (estimated cost %d exceeds the limit of %d): "+
+		"use longer search terms, add repo: or file: filters, or give regular expressions a literal prefix", e.Cost, e.MaxCost)
+}
+
+// Explanation describes how a searcher evaluates a query.
+type Explanation struct {
+	// Query is the query after the rewrites which apply to all shards, such
+	// as the evaluation of type:repo queries.
+	Query string
+
+	// Shards explains the evaluation of the query in each shard.
+	Shards []ShardExplanation
+}
+
+// ShardExplanation describes how a shard evaluates a query.
+type ShardExplanation struct {
+	// Shard describes the shard, usually its file name.
+	Shard string
+
+	Repositories []string
+
+	// Query is the query after it was simplified for this shard. For
+	// example, repo: atoms are replaced by true or false.
+	Query string
+
+	// Skipped is true if the shard is not searched. SkipReason says why.
+	Skipped    bool
+	SkipReason string `json:",omitempty"`
+
+	// Atoms are the leaves of the match tree which find and match
+	// documents.
+	Atoms []AtomExplanation `json:",omitempty"`
+
+	// ScansAllDocuments is true if no atom narrows down the documents with
+	// the ngram index, so every document in the shard is visited.
+	ScansAllDocuments bool
+
+	// Cost is a rough estimate of the number of bytes read to evaluate the
+	// query: the size of the posting lists of the ngram atoms, or the size of
+	// all content if every document is scanned, weighted by the complexity of
+	// the regular expressions. It is only useful to compare queries and shards
+	// with each other, and with SearchOptions.MaxEstimatedCost.
+	Cost int64
+}
+
+// AtomExplanation describes a leaf of a match tree.
+type AtomExplanation struct {
+	// Atom describes the atom, eg. substr("foo").
+	Atom string
+
+	// Method describes how the atom finds candidate documents, eg. "ngram".
+	Method string
+
+	// PostingBytes is the size of the posting lists the atom reads. It is
+	// zero for atoms which don't use the ngram index.
+	PostingBytes int64 `json:",omitempty"`
+}
@@ -17,11 +17,14 @@ package zoekt // import "github.com/sourcegraph/zoekt"
 import (
 	"math/rand"
 	"reflect"
+	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 func FileMatchFromProto(p *proto.FileMatch) FileMatch {
@@ -35,22 +38,37 @@ func FileMatchFromProto(p *proto.FileMatch) FileMatch {
 		chunkMatches[i] = ChunkMatchFromProto(chunkMatch)
 	}
 
+	branchMatches := make([]BranchMatch, len(p.GetBranchMatches()))
+	for i, branchMatch := range p.GetBranchMatches() {
+		branchMatches[i] = BranchMatchFromProto(branchMatch)
+	}
+
 	return FileMatch{
-		Score:              p.GetScore(),
-		Debug:              p.GetDebug(),
-		FileName:           string(p.GetFileName()), // Note: 🚨Warning, this filename may be a non-UTF8 string.
-		Repository:         p.GetRepository(),
-		Branches:           p.GetBranches(),
-		LineMatches:        lineMatches,
-		ChunkMatches:       chunkMatches,
-		RepositoryID:       p.GetRepositoryId(),
-		RepositoryPriority: p.GetRepositoryPriority(),
-		Content:            p.GetContent(),
-		Checksum:           p.GetChecksum(),
-		Language:           p.GetLanguage(),
-		SubRepositoryName:  p.GetSubRepositoryName(),
-		SubRepositoryPath:  p.GetSubRepositoryPath(),
-		Version:            p.GetVersion(),
+		Score:                 p.GetScore(),
+		Debug:                 p.GetDebug(),
+		FileName:              string(p.GetFileName()), // Note: 🚨Warning, this filename may be a non-UTF8 string.
+		Repository:            p.GetRepository(),
+		Branches:              p.GetBranches(),
+		LineMatches:           lineMatches,
+		ChunkMatches:          chunkMatches,
+		RepositoryID:          p.GetRepositoryId(),
+		RepositoryPriority:    p.GetRepositoryPriority(),
+		Content:               p.GetContent(),
+		Checksum:              p.GetChecksum(),
+		Language:              p.GetLanguage(),
+		SubRepositoryName:     p.GetSubRepositoryName(),
+		SubRepositoryPath:     p.GetSubRepositoryPath(),
+		Version:               p.GetVersion(),
+		CommitDate:            timeFromProto(p.GetCommitDate()),
+		LastModified:          timeFromProto(p.GetLastModified()),
+		IndexTime:             timeFromProto(p.GetIndexTime()),
+		BranchMatches:         branchMatches,
+		DuplicateRepositories: p.GetDuplicateRepositories(),
+		MatchCount:            int(p.GetMatchCount()),
+		LineCount:             int(p.GetLineCount()),
+		FileSize:              int(p.GetFileSize()),
+		Truncated:             p.GetTruncated(),
+		Generated:             p.GetGenerated(),
 	}
 }
 
@@ -65,22 +83,81 @@ func (m *FileMatch) ToProto() *proto.FileMatch {
 		chunkMatches[i] = cm.ToProto()
 	}
 
+	branchMatches := make([]*proto.BranchMatch, len(m.BranchMatches))
+	for i, bm := range m.BranchMatches {
+		branchMatches[i] = bm.ToProto()
+	}
+
 	return &proto.FileMatch{
-		Score:              m.Score,
-		Debug:              m.Debug,
-		FileName:           []byte(m.FileName),
-		Repository:         m.Repository,
-		Branches:           m.Branches,
-		LineMatches:        lineMatches,
-		ChunkMatches:       chunkMatches,
-		RepositoryId:       m.RepositoryID,
-		RepositoryPriority: m.RepositoryPriority,
-		Content:            m.Content,
-		Checksum:           m.Checksum,
-		Language:           m.Language,
-		SubRepositoryName:  m.SubRepositoryName,
-		SubRepositoryPath:  m.SubRepositoryPath,
-		Version:            m.Version,
+		Score:                 m.Score,
+		Debug:                 m.Debug,
+		FileName:              []byte(m.FileName),
+		Repository:            m.Repository,
+		Branches:              m.Branches,
+		LineMatches:           lineMatches,
+		ChunkMatches:          chunkMatches,
+		RepositoryId:          m.RepositoryID,
+		RepositoryPriority:    m.RepositoryPriority,
+		Content:               m.Content,
+		Checksum:              m.Checksum,
+		Language:              m.Language,
+		SubRepositoryName:     m.SubRepositoryName,
+		SubRepositoryPath:     m.SubRepositoryPath,
+		Version:               m.Version,
+		CommitDate:            timeToProto(m.CommitDate),
+		LastModified:          timeToProto(m.LastModified),
+		IndexTime:             timeToProto(m.IndexTime),
+		BranchMatches:         branchMatches,
+		DuplicateRepositories: m.DuplicateRepositories,
+		MatchCount:            int64(m.MatchCount),
+		LineCount:             int64(m.LineCount),
+		FileSize:              int64(m.FileSize),
+		Truncated:             m.Truncated,
+		Generated:             m.Generated,
+	}
+}
+
+func BranchMatchFromProto(p *proto.BranchMatch) BranchMatch {
+	lineMatches := make([]LineMatch, len(p.GetLineMatches()))
+	for i, lineMatch := range p.GetLineMatches() {
+		lineMatches[i] = LineMatchFromProto(lineMatch)
+	}
+
+	chunkMatches := make([]ChunkMatch, len(p.GetChunkMatches()))
+	for i, chunkMatch := range p.GetChunkMatches() {
+		chunkMatches[i] = ChunkMatchFromProto(chunkMatch)
+	}
+
+	return BranchMatch{
+		Branches:     p.GetBranches(),
+		Version:      p.GetVersion(),
+		LineMatches:  lineMatches,
+		ChunkMatches: chunkMatches,
+		Content:      p.GetContent(),
+		Checksum:     p.GetChecksum(),
+		Score:        p.GetScore(),
+	}
+}
+
+func (m *BranchMatch) ToProto() *proto.BranchMatch {
+	lineMatches := make([]*proto.LineMatch, len(m.LineMatches))
+	for i, lm := range m.LineMatches {
+		lineMatches[i] = lm.ToProto()
+	}
+
+	chunkMatches := make([]*proto.ChunkMatch, len(m.ChunkMatches))
+	for i, cm := range m.ChunkMatches {
+		chunkMatches[i] = cm.ToProto()
+	}
+
+	return &proto.BranchMatch{
+		Branches:     m.Branches,
+		Version:      m.Version,
+		LineMatches:  lineMatches,
+		ChunkMatches: chunkMatches,
+		Content:      m.Content,
+		Checksum:     m.Checksum,
+		Score:        m.Score,
 	}
 }
 
@@ -146,17 +223,19 @@ func (r *Range) ToProto() *proto.Range {
 
 func LocationFromProto(p *proto.Location) Location {
 	return Location{
-		ByteOffset: p.GetByteOffset(),
-		LineNumber: p.GetLineNumber(),
-		Column:     p.GetColumn(),
+		ByteOffset:  p.GetByteOffset(),
+		LineNumber:  p.GetLineNumber(),
+		Column:      p.GetColumn(),
+		ColumnUTF16: p.GetColumnUtf16(),
 	}
 }
 
 func (l *Location) ToProto() *proto.Location {
 	return &proto.Location{
-		ByteOffset: l.ByteOffset,
-		LineNumber: l.LineNumber,
-		Column:     l.Column,
+		ByteOffset:  l.ByteOffset,
+		LineNumber:  l.LineNumber,
+		Column:      l.Column,
+		ColumnUtf16: l.ColumnUTF16,
 	}
 }
 
@@ -210,6 +289,7 @@ func SymbolFromProto(p *proto.SymbolInfo) *Symbol {
 		Kind:       p.GetKind(),
 		Parent:     p.GetParent(),
 		ParentKind: p.GetParentKind(),
+		Signature:  p.GetSignature(),
 	}
 }
 
@@ -223,24 +303,33 @@ func (s *Symbol) ToProto() *proto.SymbolInfo {
 		Kind:       s.Kind,
 		Parent:     s.Parent,
 		ParentKind: s.ParentKind,
+		Signature:  s.Signature,
 	}
 }
 
 func LineFragmentMatchFromProto(p *proto.LineFragmentMatch) LineFragmentMatch {
 	return LineFragmentMatch{
-		LineOffset:  int(p.GetLineOffset()),
-		Offset:      p.GetOffset(),
-		MatchLength: int(p.GetMatchLength()),
-		SymbolInfo:  SymbolFromProto(p.GetSymbolInfo()),
+		LineOffset:       int(p.GetLineOffset()),
+		Offset:           p.GetOffset(),
+		MatchLength:      int(p.GetMatchLength()),
+		LineOffsetRunes:  int(p.GetLineOffsetRunes()),
+		MatchLengthRunes: int(p.GetMatchLengthRunes()),
+		LineOffsetUTF16:  int(p.GetLineOffsetUtf16()),
+		MatchLengthUTF16: int(p.GetMatchLengthUtf16()),
+		SymbolInfo:       SymbolFromProto(p.GetSymbolInfo()),
 	}
 }
 
 func (lfm *LineFragmentMatch) ToProto() *proto.LineFragmentMatch {
 	return &proto.LineFragmentMatch{
-		LineOffset:  int64(lfm.LineOffset),
-		Offset:      lfm.Offset,
-		MatchLength: int64(lfm.MatchLength),
-		SymbolInfo:  lfm.SymbolInfo.ToProto(),
+		LineOffset:       int64(lfm.LineOffset),
+		Offset:           lfm.Offset,
+		MatchLength:      int64(lfm.MatchLength),
+		LineOffsetRunes:  int64(lfm.LineOffsetRunes),
+		MatchLengthRunes: int64(lfm.MatchLengthRunes),
+		LineOffsetUtf16:  int64(lfm.LineOffsetUTF16),
+		MatchLengthUtf16: int64(lfm.MatchLengthUTF16),
+		SymbolInfo:       lfm.SymbolInfo.ToProto(),
 	}
 }
 
@@ -286,51 +375,57 @@ func (fr FlushReason) Generate(rand *rand.Rand, size int) reflect.Value {
 
 func StatsFromProto(p *proto.Stats) Stats {
 	return Stats{
-		ContentBytesLoaded:    p.GetContentBytesLoaded(),
-		IndexBytesLoaded:      p.GetIndexBytesLoaded(),
-		Crashes:               int(p.GetCrashes()),
-		Duration:              p.GetDuration().AsDuration(),
-		FileCount:             int(p.GetFileCount()),
-		ShardFilesConsidered:  int(p.GetShardFilesConsidered()),
-		FilesConsidered:       int(p.GetFilesConsidered()),
-		FilesLoaded:           int(p.GetFilesLoaded()),
-		FilesSkipped:          int(p.GetFilesSkipped()),
-		ShardsScanned:         int(p.GetShardsScanned()),
-		ShardsSkipped:         int(p.GetShardsSkipped()),
-		ShardsSkippedFilter:   int(p.GetShardsSkippedFilter()),
-		MatchCount:            int(p.GetMatchCount()),
-		NgramMatches:          int(p.GetNgramMatches()),
-		NgramLookups:          int(p.GetNgramLookups()),
-		Wait:                  p.GetWait().AsDuration(),
-		MatchTreeConstruction: p.GetMatchTreeConstruction().AsDuration(),
-		MatchTreeSearch:       p.GetMatchTreeSearch().AsDuration(),
-		RegexpsConsidered:     int(p.GetRegexpsConsidered()),
-		FlushReason:           FlushReasonFromProto(p.GetFlushReason()),
+		ContentBytesLoaded:     p.GetContentBytesLoaded(),
+		IndexBytesLoaded:       p.GetIndexBytesLoaded(),
+		Crashes:                int(p.GetCrashes()),
+		Duration:               p.GetDuration().AsDuration(),
+		FileCount:              int(p.GetFileCount()),
+		ShardFilesConsidered:   int(p.GetShardFilesConsidered()),
+		FilesConsidered:        int(p.GetFilesConsidered()),
+		FilesLoaded:            int(p.GetFilesLoaded()),
+		FilesSkipped:           int(p.GetFilesSkipped()),
+		FilesSkippedTombstoned: int(p.GetFilesSkippedTombstoned()),
+		FilesSkippedSize:       int(p.GetFilesSkippedSize()),
+		FilesSkippedBinary:     int(p.GetFilesSkippedBinary()),
+		ShardsScanned:          int(p.GetShardsScanned()),
+		ShardsSkipped:          int(p.GetShardsSkipped()),
+		ShardsSkippedFilter:    int(p.GetShardsSkippedFilter()),
+		MatchCount:             int(p.GetMatchCount()),
+		NgramMatches:           int(p.GetNgramMatches()),
+		NgramLookups:           int(p.GetNgramLookups()),
+		Wait:                   p.GetWait().AsDuration(),
+		MatchTreeConstruction:  p.GetMatchTreeConstruction().AsDuration(),
+		MatchTreeSearch:        p.GetMatchTreeSearch().AsDuration(),
+		RegexpsConsidered:      int(p.GetRegexpsConsidered()),
+		FlushReason:            FlushReasonFromProto(p.GetFlushReason()),
 	}
 }
 
 func (s *Stats) ToProto() *proto.Stats {
 	return &proto.Stats{
-		ContentBytesLoaded:    s.ContentBytesLoaded,
-		IndexBytesLoaded:      s.IndexBytesLoaded,
-		Crashes:               int64(s.Crashes),
-		Duration:              durationpb.New(s.Duration),
-		FileCount:             int64(s.FileCount),
-		ShardFilesConsidered:  int64(s.ShardFilesConsidered),
-		FilesConsidered:       int64(s.FilesConsidered),
-		FilesLoaded:           int64(s.FilesLoaded),
-		FilesSkipped:          int64(s.FilesSkipped),
-		ShardsScanned:         int64(s.ShardsScanned),
-		ShardsSkipped:         int64(s.ShardsSkipped),
-		ShardsSkippedFilter:   int64(s.ShardsSkippedFilter),
-		MatchCount:            int64(s.MatchCount),
-		NgramMatches:          int64(s.NgramMatches),
-		NgramLookups:          int64(s.NgramLookups),
-		Wait:                  durationpb.New(s.Wait),
-		MatchTreeConstruction: durationpb.New(s.MatchTreeConstruction),
-		MatchTreeSearch:       durationpb.New(s.MatchTreeSearch),
-		RegexpsConsidered:     int64(s.RegexpsConsidered),
-		FlushReason:           s.FlushReason.ToProto(),
+		ContentBytesLoaded:     s.ContentBytesLoaded,
+		IndexBytesLoaded:       s.IndexBytesLoaded,
+		Crashes:                int64(s.Crashes),
+		Duration:               durationpb.New(s.Duration),
+		FileCount:              int64(s.FileCount),
+		ShardFilesConsidered:   int64(s.ShardFilesConsidered),
+		FilesConsidered:        int64(s.FilesConsidered),
+		FilesLoaded:            int64(s.FilesLoaded),
+		FilesSkipped:           int64(s.FilesSkipped),
+		FilesSkippedTombstoned: int64(s.FilesSkippedTombstoned),
+		FilesSkippedSize:       int64(s.FilesSkippedSize),
+		FilesSkippedBinary:     int64(s.FilesSkippedBinary),
+		ShardsScanned:          int64(s.ShardsScanned),
+		ShardsSkipped:          int64(s.ShardsSkipped),
+		ShardsSkippedFilter:    int64(s.ShardsSkippedFilter),
+		MatchCount:             int64(s.MatchCount),
+		NgramMatches:           int64(s.NgramMatches),
+		NgramLookups:           int64(s.NgramLookups),
+		Wait:                   durationpb.New(s.Wait),
+		MatchTreeConstruction:  durationpb.New(s.MatchTreeConstruction),
+		MatchTreeSearch:        durationpb.New(s.MatchTreeSearch),
+		RegexpsConsidered:      int64(s.RegexpsConsidered),
+		FlushReason:            s.FlushReason.ToProto(),
 	}
 }
 
@@ -348,6 +443,30 @@ func (p *Progress) ToProto() *proto.Progress {
 	}
 }
 
+func SearchProgressFromProto(p *proto.SearchProgress) *SearchProgress {
+	if p == nil {
+		return nil
+	}
+	return &SearchProgress{
+		ShardsScanned: int(p.GetShardsScanned()),
+		ShardsTotal:   int(p.GetShardsTotal()),
+		MatchCount:    int(p.GetMatchCount()),
+		BytesLoaded:   p.GetBytesLoaded(),
+	}
+}
+
+func (p *SearchProgress) ToProto() *proto.SearchProgress {
+	if p == nil {
+		return nil
+	}
+	return &proto.SearchProgress{
+		ShardsScanned: int64(p.ShardsScanned),
+		ShardsTotal:   int64(p.ShardsTotal),
+		MatchCount:    int64(p.MatchCount),
+		BytesLoaded:   p.BytesLoaded,
+	}
+}
+
 func SearchResultFromStreamProto(p *proto.StreamSearchResponse, repoURLs, lineFragments map[string]string) *SearchResult {
 	if p == nil {
 		return nil
@@ -366,14 +485,22 @@ func SearchResultFromProto(p *proto.SearchResponse, repoURLs, lineFragments map[
 		files[i] = FileMatchFromProto(file)
 	}
 
+	suggestions := make([]Suggestion, len(p.GetSuggestions()))
+	for i, s := range p.GetSuggestions() {
+		suggestions[i] = Suggestion{Pattern: s.GetPattern(), Replacement: s.GetReplacement()}
+	}
+
 	return &SearchResult{
-		Stats:    StatsFromProto(p.GetStats()),
-		Progress: ProgressFromProto(p.GetProgress()),
+		Stats:          StatsFromProto(p.GetStats()),
+		Progress:       ProgressFromProto(p.GetProgress()),
+		SearchProgress: SearchProgressFromProto(p.GetSearchProgress()),
 
 		Files: files,
 
 		RepoURLs:      repoURLs,
 		LineFragments: lineFragments,
+
+		Suggestions: suggestions,
 	}
 }
 
@@ -387,11 +514,19 @@ func (sr *SearchResult) ToProto() *proto.SearchResponse {
 		files[i] = file.ToProto()
 	}
 
+	suggestions := make([]*proto.Suggestion, len(sr.Suggestions))
+	for i, s := range sr.Suggestions {
+		suggestions[i] = &proto.Suggestion{Pattern: s.Pattern, Replacement: s.Replacement}
+	}
+
 	return &proto.SearchResponse{
-		Stats:    sr.Stats.ToProto(),
-		Progress: sr.Progress.ToProto(),
+		Stats:          sr.Stats.ToProto(),
+		Progress:       sr.Progress.ToProto(),
+		SearchProgress: sr.SearchProgress.ToProto(),
 
 		Files: files,
+
+		Suggestions: suggestions,
 	}
 }
 
@@ -405,16 +540,36 @@ func (sr *SearchResult) ToStreamProto() *proto.StreamSearchResponse {
 
 func RepositoryBranchFromProto(p *proto.RepositoryBranch) RepositoryBranch {
 	return RepositoryBranch{
-		Name:    p.GetName(),
-		Version: p.GetVersion(),
+		Name:        p.GetName(),
+		Version:     p.GetVersion(),
+		CommitDate:  timeFromProto(p.GetCommitDate()),
+		Description: p.GetDescription(),
 	}
 }
 
 func (r *RepositoryBranch) ToProto() *proto.RepositoryBranch {
 	return &proto.RepositoryBranch{
-		Name:    r.Name,
-		Version: r.Version,
+		Name:        r.Name,
+		Version:     r.Version,
+		CommitDate:  timeToProto(r.CommitDate),
+		Description: r.Description,
+	}
+}
+
+// timeToProto leaves unset times out of the message, so that they stay
+// unset on the other side.
+func timeToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timeFromProto(p *timestamppb.Timestamp) time.Time {
+	if p == nil {
+		return time.Time{}
 	}
+	return p.AsTime()
 }
 
 func RepositoryFromProto(p *proto.Repository) Repository {
@@ -453,6 +608,12 @@ func RepositoryFromProto(p *proto.Repository) Repository {
 		Tombstone:            p.GetTombstone(),
 		LatestCommitDate:     p.GetLatestCommitDate().AsTime(),
 		FileTombstones:       fileTombstones,
+		License:              p.GetLicense(),
+		Shallow:              p.GetShallow(),
+		State:                p.GetState(),
+		Description:          p.GetDescription(),
+		Homepage:             p.GetHomepage(),
+		Languages:            languageStatsFromProto(p.GetLanguages()),
 	}
 }
 
@@ -495,7 +656,29 @@ func (r *Repository) ToProto() *proto.Repository {
 		Tombstone:            r.Tombstone,
 		LatestCommitDate:     timestamppb.New(r.LatestCommitDate),
 		FileTombstones:       fileTombstones,
+		License:              r.License,
+		Shallow:              r.Shallow,
+		State:                r.State,
+		Description:          r.Description,
+		Homepage:             r.Homepage,
+		Languages:            languageStatsToProto(r.Languages),
+	}
+}
+
+func languageStatsFromProto(p map[string]*proto.LanguageStats) map[string]LanguageStats {
+	languages := make(map[string]LanguageStats, len(p))
+	for lang, ls := range p {
+		languages[lang] = LanguageStats{Files: int(ls.GetFiles()), Bytes: ls.GetBytes()}
 	}
+	return languages
+}
+
+func languageStatsToProto(languages map[string]LanguageStats) map[string]*proto.LanguageStats {
+	p := make(map[string]*proto.LanguageStats, len(languages))
+	for lang, ls := range languages {
+		p[lang] = &proto.LanguageStats{Files: int64(ls.Files), Bytes: ls.Bytes}
+	}
+	return p
 }
 
 func IndexMetadataFromProto(p *proto.IndexMetadata) IndexMetadata {
@@ -548,6 +731,7 @@ func RepoStatsFromProto(p *proto.RepoStats) RepoStats {
 		NewLinesCount:              p.GetNewLinesCount(),
 		DefaultBranchNewLinesCount: p.GetDefaultBranchNewLinesCount(),
 		OtherBranchesNewLinesCount: p.GetOtherBranchesNewLinesCount(),
+		Languages:                  languageStatsFromProto(p.GetLanguages()),
 	}
 }
 
@@ -561,6 +745,7 @@ func (s *RepoStats) ToProto() *proto.RepoStats {
 		NewLinesCount:              s.NewLinesCount,
 		DefaultBranchNewLinesCount: s.DefaultBranchNewLinesCount,
 		OtherBranchesNewLinesCount: s.OtherBranchesNewLinesCount,
+		Languages:                  languageStatsToProto(s.Languages),
 	}
 }
 
@@ -690,21 +875,122 @@ func SearchOptionsFromProto(p *proto.SearchOptions) *SearchOptions {
 	}
 
 	return &SearchOptions{
-		EstimateDocCount:       p.GetEstimateDocCount(),
-		Whole:                  p.GetWhole(),
-		ShardMaxMatchCount:     int(p.GetShardMaxMatchCount()),
-		TotalMaxMatchCount:     int(p.GetTotalMaxMatchCount()),
-		ShardRepoMaxMatchCount: int(p.GetShardRepoMaxMatchCount()),
-		MaxWallTime:            p.GetMaxWallTime().AsDuration(),
-		FlushWallTime:          p.GetFlushWallTime().AsDuration(),
-		MaxDocDisplayCount:     int(p.GetMaxDocDisplayCount()),
-		MaxMatchDisplayCount:   int(p.GetMaxMatchDisplayCount()),
-		NumContextLines:        int(p.GetNumContextLines()),
-		ChunkMatches:           p.GetChunkMatches(),
-		Trace:                  p.GetTrace(),
-		DebugScore:             p.GetDebugScore(),
-		UseBM25Scoring:         p.GetUseBm25Scoring(),
+		EstimateDocCount:           p.GetEstimateDocCount(),
+		Whole:                      p.GetWhole(),
+		ShardMaxMatchCount:         int(p.GetShardMaxMatchCount()),
+		TotalMaxMatchCount:         int(p.GetTotalMaxMatchCount()),
+		AdaptiveShardMaxMatchCount: p.GetAdaptiveShardMaxMatchCount(),
+		ShardRepoMaxMatchCount:     int(p.GetShardRepoMaxMatchCount()),
+		MaxWallTime:                p.GetMaxWallTime().AsDuration(),
+		FlushWallTime:              p.GetFlushWallTime().AsDuration(),
+		ProgressInterval:           p.GetProgressInterval().AsDuration(),
+		MaxDocDisplayCount:         int(p.GetMaxDocDisplayCount()),
+		MaxMatchDisplayCount:       int(p.GetMaxMatchDisplayCount()),
+		MaxSuggestions:             int(p.GetMaxSuggestions()),
+		NumContextLines:            int(p.GetNumContextLines()),
+		ChunkMatches:               p.GetChunkMatches(),
+		Trace:                      p.GetTrace(),
+		DebugScore:                 p.GetDebugScore(),
+		UseBM25Scoring:             p.GetUseBm25Scoring(),
+		RepoFilter:                 repoFilterFromProto(p.GetRepoFilter()),
+		GroupByBranch:              p.GetGroupByBranch(),
+		RecencyHalfLife:            p.GetRecencyHalfLife().AsDuration(),
+		DedupByChecksum:            p.GetDedupByChecksum(),
+		MaxEstimatedCost:           p.GetMaxEstimatedCost(),
+		MaxMatchesPerFile:          int(p.GetMaxMatchesPerFile()),
+		MaxFilesPerRepo:            int(p.GetMaxFilesPerRepo()),
+		Fields:                     fileMatchFieldsFromProto(p.GetFields()),
+		PathBoosts:                 pathBoostsFromProto(p.GetPathBoosts()),
+		OffsetEncoding:             offsetEncodingFromProto(p.GetOffsetEncoding()),
+	}
+}
+
+func pathBoostsFromProto(p []*proto.PathBoost) []query.PathBoost {
+	if len(p) == 0 {
+		return nil
+	}
+	boosts := make([]query.PathBoost, len(p))
+	for i, b := range p {
+		boosts[i] = *query.PathBoostFromProto(b)
+	}
+	return boosts
+}
+
+func pathBoostsToProto(boosts []query.PathBoost) []*proto.PathBoost {
+	if len(boosts) == 0 {
+		return nil
+	}
+	p := make([]*proto.PathBoost, len(boosts))
+	for i := range boosts {
+		p[i] = boosts[i].ToProto()
 	}
+	return p
+}
+
+var fileMatchFieldsProto = map[FileMatchFields]proto.SearchOptions_FileMatchField{
+	FieldFileName: proto.SearchOptions_FILE_MATCH_FIELD_FILE_NAME,
+	FieldMatches:  proto.SearchOptions_FILE_MATCH_FIELD_MATCHES,
+	FieldChecksum: proto.SearchOptions_FILE_MATCH_FIELD_CHECKSUM,
+	FieldStats:    proto.SearchOptions_FILE_MATCH_FIELD_STATS,
+}
+
+// fileMatchFieldsFromProto ignores unknown fields.
+func fileMatchFieldsFromProto(p []proto.SearchOptions_FileMatchField) FileMatchFields {
+	var fields FileMatchFields
+	for _, pf := range p {
+		for f, v := range fileMatchFieldsProto {
+			if v == pf {
+				fields |= f
+			}
+		}
+	}
+	return fields
+}
+
+func fileMatchFieldsToProto(fields FileMatchFields) []proto.SearchOptions_FileMatchField {
+	var p []proto.SearchOptions_FileMatchField
+	for _, fn := range fileMatchFieldNames {
+		if fields&fn.field != 0 {
+			p = append(p, fileMatchFieldsProto[fn.field])
+		}
+	}
+	return p
+}
+
+// offsetEncodingFromProto falls back to bytes for unknown encodings.
+func offsetEncodingFromProto(p proto.SearchOptions_OffsetEncoding) OffsetEncoding {
+	switch p {
+	case proto.SearchOptions_OFFSET_ENCODING_RUNES:
+		return OffsetEncodingRunes
+	case proto.SearchOptions_OFFSET_ENCODING_UTF16:
+		return OffsetEncodingUTF16
+	default:
+		return OffsetEncodingBytes
+	}
+}
+
+func offsetEncodingToProto(e OffsetEncoding) proto.SearchOptions_OffsetEncoding {
+	switch e {
+	case OffsetEncodingRunes:
+		return proto.SearchOptions_OFFSET_ENCODING_RUNES
+	case OffsetEncodingUTF16:
+		return proto.SearchOptions_OFFSET_ENCODING_UTF16
+	default:
+		return proto.SearchOptions_OFFSET_ENCODING_BYTES_UNSPECIFIED
+	}
+}
+
+// repoFilterFromProto returns nil if p is nil. A filter which fails to
+// decode allows no repositories, rather than all of them.
+func repoFilterFromProto(p *proto.RepoIds) *roaring.Bitmap {
+	if p == nil {
+		return nil
+	}
+	ids, err := query.RepoIDsFromProto(p)
+	if err != nil {
+		return roaring.NewBitmap()
+	}
+	return ids.Repos
 }
 
 func (s *SearchOptions) ToProto() *proto.SearchOptions {
@@ -713,19 +999,120 @@ func (s *SearchOptions) ToProto() *proto.SearchOptions {
 	}
 
 	return &proto.SearchOptions{
-		EstimateDocCount:       s.EstimateDocCount,
-		Whole:                  s.Whole,
-		ShardMaxMatchCount:     int64(s.ShardMaxMatchCount),
-		TotalMaxMatchCount:     int64(s.TotalMaxMatchCount),
-		ShardRepoMaxMatchCount: int64(s.ShardRepoMaxMatchCount),
-		MaxWallTime:            durationpb.New(s.MaxWallTime),
-		FlushWallTime:          durationpb.New(s.FlushWallTime),
-		MaxDocDisplayCount:     int64(s.MaxDocDisplayCount),
-		MaxMatchDisplayCount:   int64(s.MaxMatchDisplayCount),
-		NumContextLines:        int64(s.NumContextLines),
-		ChunkMatches:           s.ChunkMatches,
-		Trace:                  s.Trace,
-		DebugScore:             s.DebugScore,
-		UseBm25Scoring:         s.UseBM25Scoring,
+		EstimateDocCount:           s.EstimateDocCount,
+		Whole:                      s.Whole,
+		ShardMaxMatchCount:         int64(s.ShardMaxMatchCount),
+		TotalMaxMatchCount:         int64(s.TotalMaxMatchCount),
+		AdaptiveShardMaxMatchCount: s.AdaptiveShardMaxMatchCount,
+		ShardRepoMaxMatchCount:     int64(s.ShardRepoMaxMatchCount),
+		MaxWallTime:                durationpb.New(s.MaxWallTime),
+		FlushWallTime:              durationpb.New(s.FlushWallTime),
+		ProgressInterval:           durationpb.New(s.ProgressInterval),
+		MaxDocDisplayCount:         int64(s.MaxDocDisplayCount),
+		MaxMatchDisplayCount:       int64(s.MaxMatchDisplayCount),
+		MaxSuggestions:             int64(s.MaxSuggestions),
+		NumContextLines:            int64(s.NumContextLines),
+		ChunkMatches:               s.ChunkMatches,
+		Trace:                      s.Trace,
+		DebugScore:                 s.DebugScore,
+		UseBm25Scoring:             s.UseBM25Scoring,
+		RepoFilter:                 repoFilterToProto(s.RepoFilter),
+		GroupByBranch:              s.GroupByBranch,
+		RecencyHalfLife:            durationpb.New(s.RecencyHalfLife),
+		DedupByChecksum:            s.DedupByChecksum,
+		MaxEstimatedCost:           s.MaxEstimatedCost,
+		MaxMatchesPerFile:          int64(s.MaxMatchesPerFile),
+		MaxFilesPerRepo:            int64(s.MaxFilesPerRepo),
+		Fields:                     fileMatchFieldsToProto(s.Fields),
+		PathBoosts:                 pathBoostsToProto(s.PathBoosts),
+		OffsetEncoding:             offsetEncodingToProto(s.OffsetEncoding),
+	}
+}
+
+func repoFilterToProto(bm *roaring.Bitmap) *proto.RepoIds {
+	if bm == nil {
+		return nil
+	}
+	return (&query.RepoIDs{Repos: bm}).ToProto()
+}
+
+func DocumentRequestFromProto(p *proto.FetchDocumentRequest) *DocumentRequest {
+	return &DocumentRequest{
+		Repository: p.GetRepository(),
+		FileName:   p.GetFileName(),
+		Branch:     p.GetBranch(),
+	}
+}
+
+func (r *DocumentRequest) ToProto() *proto.FetchDocumentRequest {
+	return &proto.FetchDocumentRequest{
+		Repository: r.Repository,
+		FileName:   r.FileName,
+		Branch:     r.Branch,
+	}
+}
+
+func DocumentFromProto(p *proto.Document) *Document {
+	if p == nil {
+		return nil
+	}
+
+	symbols := make([]DocumentSymbol, len(p.GetSymbols()))
+	for i, s := range p.GetSymbols() {
+		symbols[i] = DocumentSymbolFromProto(s)
+	}
+
+	return &Document{
+		Repository:   p.GetRepository(),
+		RepositoryID: p.GetRepositoryId(),
+		FileName:     p.GetFileName(),
+		Branches:     p.GetBranches(),
+		Version:      p.GetVersion(),
+		Language:     p.GetLanguage(),
+		Checksum:     p.GetChecksum(),
+		Content:      p.GetContent(),
+		Symbols:      symbols,
+	}
+}
+
+func (d *Document) ToProto() *proto.Document {
+	if d == nil {
+		return nil
+	}
+
+	symbols := make([]*proto.DocumentSymbol, len(d.Symbols))
+	for i, s := range d.Symbols {
+		symbols[i] = s.ToProto()
+	}
+
+	return &proto.Document{
+		Repository:   d.Repository,
+		RepositoryId: d.RepositoryID,
+		FileName:     d.FileName,
+		Branches:     d.Branches,
+		Version:      d.Version,
+		Language:     d.Language,
+		Checksum:     d.Checksum,
+		Content:      d.Content,
+		Symbols:      symbols,
+	}
+}
+
+func DocumentSymbolFromProto(p *proto.DocumentSymbol) DocumentSymbol {
+	s := DocumentSymbol{
+		Start: p.GetStart(),
+		End:   p.GetEnd(),
+	}
+	if sym := SymbolFromProto(p.GetSymbolInfo()); sym != nil {
+		s.Symbol = *sym
+	}
+	return s
+}
+
+func (s *DocumentSymbol) ToProto() *proto.DocumentSymbol {
+	return &proto.DocumentSymbol{
+		Start:      s.Start,
+		End:        s.End,
+		SymbolInfo: s.Symbol.ToProto(),
 	}
 }
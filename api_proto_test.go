@@ -25,9 +25,11 @@ import (
 	"testing/quick"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	webproto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/query"
 	"google.golang.org/protobuf/proto"
 
 	fuzz "github.com/AdaLogics/go-fuzz-headers"
@@ -100,6 +102,28 @@ func TestProtoRoundtrip(t *testing.T) {
 		}
 	})
 
+	t.Run("Document", func(t *testing.T) {
+		f := func(f1 *Document) bool {
+			p1 := f1.ToProto()
+			f2 := DocumentFromProto(p1)
+			return reflect.DeepEqual(f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("DocumentRequest", func(t *testing.T) {
+		f := func(f1 DocumentRequest) bool {
+			p1 := f1.ToProto()
+			f2 := DocumentRequestFromProto(p1)
+			return reflect.DeepEqual(&f1, f2)
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("FlushReson", func(t *testing.T) {
 		f := func(f1 FlushReason) bool {
 			p1 := f1.ToProto()
@@ -238,6 +262,7 @@ func TestProtoRoundtrip(t *testing.T) {
 						Branches:       []RepositoryBranch{},
 						SubRepoMap:     map[string]*Repository{},
 						FileTombstones: map[string]struct{}{},
+						Languages:      map[string]LanguageStats{},
 					},
 				},
 				CommitURLTemplate:    "committemplate",
@@ -255,6 +280,9 @@ func TestProtoRoundtrip(t *testing.T) {
 				FileTombstones: map[string]struct{}{
 					"test1": {},
 				},
+				Languages: map[string]LanguageStats{
+					"Go": {Files: 2, Bytes: 100},
+				},
 			},
 			IndexMetadata: IndexMetadata{
 				IndexFormatVersion:    32,
@@ -277,6 +305,9 @@ func TestProtoRoundtrip(t *testing.T) {
 				NewLinesCount:              8,
 				DefaultBranchNewLinesCount: 9,
 				OtherBranchesNewLinesCount: 10,
+				Languages: map[string]LanguageStats{
+					"Go": {Files: 2, Bytes: 100},
+				},
 			},
 		}
 
@@ -339,7 +370,12 @@ func TestProtoRoundtrip(t *testing.T) {
 			}
 			p1 := f1.ToProto()
 			f2 := SearchOptionsFromProto(p1)
-			if diff := cmp.Diff(f1, f2); diff != "" {
+			if diff := cmp.Diff(f1, f2, cmp.Comparer(func(a, b *roaring.Bitmap) bool {
+				if a == nil || b == nil {
+					return a == b
+				}
+				return a.Equals(b)
+			})); diff != "" {
 				fmt.Printf("got diff: %s", diff)
 				return false
 			}
@@ -351,6 +387,55 @@ func TestProtoRoundtrip(t *testing.T) {
 	})
 }
 
+func (FileMatch) Generate(rng *rand.Rand, _ int) reflect.Value {
+	var f FileMatch
+	v := FileMatch{
+		FileName:           gen(f.FileName, rng),
+		Repository:         gen(f.Repository, rng),
+		SubRepositoryName:  gen(f.SubRepositoryName, rng),
+		SubRepositoryPath:  gen(f.SubRepositoryPath, rng),
+		Version:            gen(f.Version, rng),
+		CommitDate:         genTime(rng),
+		LastModified:       genTime(rng),
+		IndexTime:          genTime(rng),
+		Language:           gen(f.Language, rng),
+		Debug:              gen(f.Debug, rng),
+		Branches:           gen(f.Branches, rng),
+		LineMatches:        gen(f.LineMatches, rng),
+		ChunkMatches:       gen(f.ChunkMatches, rng),
+		Content:            gen(f.Content, rng),
+		Checksum:           gen(f.Checksum, rng),
+		Score:              gen(f.Score, rng),
+		RepositoryPriority: gen(f.RepositoryPriority, rng),
+		RepositoryID:       gen(f.RepositoryID, rng),
+		BranchMatches:      gen(f.BranchMatches, rng),
+		MatchCount:         gen(f.MatchCount, rng),
+		LineCount:          gen(f.LineCount, rng),
+		FileSize:           gen(f.FileSize, rng),
+		Truncated:          gen(f.Truncated, rng),
+	}
+	return reflect.ValueOf(v)
+}
+
+func (RepositoryBranch) Generate(rng *rand.Rand, _ int) reflect.Value {
+	var b RepositoryBranch
+	return reflect.ValueOf(RepositoryBranch{
+		Name:        gen(b.Name, rng),
+		Version:     gen(b.Version, rng),
+		CommitDate:  genTime(rng),
+		Description: gen(b.Description, rng),
+	})
+}
+
+// genTime returns a time which survives the round trip through a proto
+// timestamp, or the zero time.
+func genTime(rng *rand.Rand) time.Time {
+	if rng.Intn(4) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(rng.Int63n(1<<32), rng.Int63n(1e9)).UTC()
+}
+
 func (*IndexMetadata) Generate(r *rand.Rand, _ int) reflect.Value {
 	indexTime := time.Now().Add(time.Duration(r.Int63n(1000)) * time.Hour)
 	var i IndexMetadata
@@ -386,6 +471,50 @@ func (*Repository) Generate(rng *rand.Rand, _ int) reflect.Value {
 		Tombstone:            gen(r.Tombstone, rng),
 		LatestCommitDate:     latestCommitDate,
 		FileTombstones:       gen(r.FileTombstones, rng),
+		License:              gen(r.License, rng),
+		Shallow:              gen(r.Shallow, rng),
+		State:                gen(r.State, rng),
+		Description:          gen(r.Description, rng),
+		Homepage:             gen(r.Homepage, rng),
+		Languages:            gen(r.Languages, rng),
+	}
+	return reflect.ValueOf(v)
+}
+
+func (*SearchOptions) Generate(rng *rand.Rand, _ int) reflect.Value {
+	var o SearchOptions
+	v := &SearchOptions{
+		EstimateDocCount:           gen(o.EstimateDocCount, rng),
+		Whole:                      gen(o.Whole, rng),
+		ShardMaxMatchCount:         gen(o.ShardMaxMatchCount, rng),
+		TotalMaxMatchCount:         gen(o.TotalMaxMatchCount, rng),
+		AdaptiveShardMaxMatchCount: gen(o.AdaptiveShardMaxMatchCount, rng),
+		ShardRepoMaxMatchCount:     gen(o.ShardRepoMaxMatchCount, rng),
+		MaxWallTime:                gen(o.MaxWallTime, rng),
+		FlushWallTime:              gen(o.FlushWallTime, rng),
+		ProgressInterval:           gen(o.ProgressInterval, rng),
+		MaxDocDisplayCount:         gen(o.MaxDocDisplayCount, rng),
+		MaxMatchDisplayCount:       gen(o.MaxMatchDisplayCount, rng),
+		MaxSuggestions:             gen(o.MaxSuggestions, rng),
+		NumContextLines:            gen(o.NumContextLines, rng),
+		ChunkMatches:               gen(o.ChunkMatches, rng),
+		UseBM25Scoring:             gen(o.UseBM25Scoring, rng),
+		Trace:                      gen(o.Trace, rng),
+		DebugScore:                 gen(o.DebugScore, rng),
+		RecencyHalfLife:            gen(o.RecencyHalfLife, rng),
+		MaxEstimatedCost:           gen(o.MaxEstimatedCost, rng),
+		MaxMatchesPerFile:          gen(o.MaxMatchesPerFile, rng),
+		MaxFilesPerRepo:            gen(o.MaxFilesPerRepo, rng),
+		Fields:                     FileMatchFields(rng.Intn(1 << len(fileMatchFieldNames))),
+		OffsetEncoding:             OffsetEncoding(rng.Intn(len(offsetEncodingNames))),
+	}
+	// An empty filter allows no repositories, so it must survive the round
+	// trip as well.
+	if rng.Intn(3) > 0 {
+		v.RepoFilter = roaring.BitmapOf(gen([]uint32{}, rng)...)
+	}
+	for range rng.Intn(3) {
+		v.PathBoosts = append(v.PathBoosts, query.PathBoost{Pattern: gen("", rng), Boost: gen(0.0, rng)})
 	}
 	return reflect.ValueOf(v)
 }
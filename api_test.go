@@ -17,12 +17,16 @@ package zoekt // import "github.com/sourcegraph/zoekt"
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt/query"
 )
 
 /*
@@ -86,16 +90,17 @@ func benchmarkEncoding(data interface{}) func(*testing.B) {
 
 func TestSizeBytesSearchResult(t *testing.T) {
 	sr := SearchResult{
-		Stats:    Stats{},    // 129 bytes
-		Progress: Progress{}, // 16 bytes
-		Files: []FileMatch{{ // 24 bytes + 460 bytes
+		Stats:          Stats{},    // 153 bytes
+		Progress:       Progress{}, // 16 bytes
+		SearchProgress: nil,        // 8 bytes
+		Files: []FileMatch{{ // 24 bytes + 538 bytes
 			Score:       0,   // 8 bytes
 			Debug:       "",  // 16 bytes
 			FileName:    "",  // 16 bytes
 			Repository:  "",  // 16 bytes
 			Branches:    nil, // 24 bytes
 			LineMatches: nil, // 24 bytes
-			ChunkMatches: []ChunkMatch{{ // 24 bytes + 208 bytes (see TestSizeByteChunkMatches)
+			ChunkMatches: []ChunkMatch{{ // 24 bytes + 236 bytes (see TestSizeByteChunkMatches)
 				Content:      []byte("foo"),
 				ContentStart: Location{},
 				FileName:     false,
@@ -104,20 +109,31 @@ func TestSizeBytesSearchResult(t *testing.T) {
 				Score:        0,
 				DebugScore:   "",
 			}},
-			RepositoryID:       0,   // 4 bytes
-			RepositoryPriority: 0,   // 8 bytes
-			Content:            nil, // 24 bytes
-			Checksum:           nil, // 24 bytes
-			Language:           "",  // 16 bytes
-			SubRepositoryName:  "",  // 16 bytes
-			SubRepositoryPath:  "",  // 16 bytes
-			Version:            "",  // 16 bytes
+			RepositoryID:          0,           // 4 bytes
+			RepositoryPriority:    0,           // 8 bytes
+			Content:               nil,         // 24 bytes
+			Checksum:              nil,         // 24 bytes
+			Language:              "",          // 16 bytes
+			SubRepositoryName:     "",          // 16 bytes
+			SubRepositoryPath:     "",          // 16 bytes
+			Version:               "",          // 16 bytes
+			CommitDate:            time.Time{}, // 24 bytes
+			LastModified:          time.Time{}, // 24 bytes
+			IndexTime:             time.Time{}, // 24 bytes
+			BranchMatches:         nil,         // 24 bytes
+			DuplicateRepositories: nil,         // 24 bytes
+			Truncated:             false,       // 1 byte
+			Generated:             false,       // 1 byte
 		}},
 		RepoURLs:      nil, // 48 bytes
 		LineFragments: nil, // 48 bytes
+		Suggestions: []Suggestion{{ // 24 bytes + 37 bytes
+			Pattern:     "fo",
+			Replacement: "foo",
+		}},
 	}
 
-	var wantBytes uint64 = 725
+	var wantBytes uint64 = 992
 	if sr.SizeBytes() != wantBytes {
 		t.Fatalf("want %d, got %d", wantBytes, sr.SizeBytes())
 	}
@@ -126,15 +142,15 @@ func TestSizeBytesSearchResult(t *testing.T) {
 func TestSizeBytesChunkMatches(t *testing.T) {
 	cm := ChunkMatch{
 		Content:      []byte("foo"), // 24 + 3 bytes
-		ContentStart: Location{},    // 12 bytes
+		ContentStart: Location{},    // 16 bytes
 		FileName:     false,         // 1 byte
-		Ranges:       []Range{{}},   // 24 bytes (slice header) + 24 bytes (content)
-		SymbolInfo:   []*Symbol{{}}, // 24 bytes (slice header) + 4 * 16 bytes (string header) + 8 bytes (pointer)
+		Ranges:       []Range{{}},   // 24 bytes (slice header) + 32 bytes (content)
+		SymbolInfo:   []*Symbol{{}}, // 24 bytes (slice header) + 5 * 16 bytes (string header) + 8 bytes (pointer)
 		Score:        0,             // 8 byte
 		DebugScore:   "",            // 16 bytes (string header)
 	}
 
-	var wantBytes uint64 = 208
+	var wantBytes uint64 = 236
 	if cm.sizeBytes() != wantBytes {
 		t.Fatalf("want %d, got %d", wantBytes, cm.sizeBytes())
 	}
@@ -146,10 +162,10 @@ func TestMatchSize(t *testing.T) {
 		size int
 	}{{
 		v:    FileMatch{},
-		size: 256,
+		size: 400,
 	}, {
 		v:    ChunkMatch{},
-		size: 120,
+		size: 128,
 	}}
 	for _, c := range cases {
 		got := reflect.TypeOf(c.v).Size()
@@ -180,11 +196,20 @@ func TestSearchOptions_String(t *testing.T) {
 			f.SetInt(1)
 		case reflect.Int64:
 			f.SetInt(1)
+		case reflect.Uint32:
+			// Only uint32 is Fields
+			f.SetUint(uint64(FieldMatches))
 		case reflect.Float64:
 			f.SetFloat(1)
 		case reflect.Map:
 			// Only map is SpanContext
 			f.Set(reflect.ValueOf(map[string]string{"key": "value"}))
+		case reflect.Ptr:
+			// Only pointer is RepoFilter
+			f.Set(reflect.ValueOf(roaring.BitmapOf(1)))
+		case reflect.Slice:
+			// Only slice is PathBoosts
+			f.Set(reflect.ValueOf([]query.PathBoost{{Pattern: "vendor/", Boost: 0.5}}))
 		default:
 			t.Fatalf("add support for %s field (%s)", f.Kind(), name)
 		}
@@ -218,10 +243,23 @@ func TestSearchOptions_String(t *testing.T) {
 		// healthz options
 		Opts: SearchOptions{ShardMaxMatchCount: 1, TotalMaxMatchCount: 1, MaxDocDisplayCount: 1},
 		Want: "zoekt.SearchOptions{ ShardMaxMatchCount=1 TotalMaxMatchCount=1 MaxDocDisplayCount=1 }",
+	}, {
+		Opts: SearchOptions{TotalMaxMatchCount: 100, AdaptiveShardMaxMatchCount: true},
+		Want: "zoekt.SearchOptions{ TotalMaxMatchCount=100 AdaptiveShardMaxMatchCount }",
 	}, {
 		// zoekt-webserver defaults
 		Opts: webDefaults,
 		Want: "zoekt.SearchOptions{ ShardMaxMatchCount=100000 TotalMaxMatchCount=1000000 MaxWallTime=10s }",
+	}, {
+		// An empty filter allows no repositories.
+		Opts: SearchOptions{RepoFilter: roaring.New()},
+		Want: "zoekt.SearchOptions{ RepoFilter=0 }",
+	}, {
+		Opts: SearchOptions{Fields: FieldFileName | FieldStats},
+		Want: "zoekt.SearchOptions{ Fields=filename,stats }",
+	}, {
+		Opts: SearchOptions{PathBoosts: []query.PathBoost{{Pattern: "vendor/", Boost: 0.1}, {Pattern: "docs/", Boost: 2}}},
+		Want: "zoekt.SearchOptions{ PathBoosts=vendor/:0.1,docs/:2 }",
 	}}
 
 	for _, tc := range cases {
@@ -232,6 +270,40 @@ func TestSearchOptions_String(t *testing.T) {
 	}
 }
 
+func TestFileMatchFields(t *testing.T) {
+	f, err := ParseFileMatchFields("matches, stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := FieldMatches | FieldStats; f != want {
+		t.Fatalf("got %s, want %s", f, want)
+	}
+	if !f.Has(FieldMatches) || f.Has(FieldChecksum) || f.Has(FieldMatches|FieldChecksum) {
+		t.Errorf("%s: Has is wrong", f)
+	}
+	if !FileMatchFields(0).Has(FieldChecksum) {
+		t.Error("zero fields should include all fields")
+	}
+	if _, err := ParseFileMatchFields("content"); err == nil {
+		t.Error("got no error for an unknown field")
+	}
+
+	b, err := json.Marshal(SearchOptions{Fields: FieldFileName | FieldChecksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"Fields":"filename,checksum"`; !strings.Contains(string(b), want) {
+		t.Errorf("got JSON %s, want it to contain %s", b, want)
+	}
+	var opts SearchOptions
+	if err := json.Unmarshal(b, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Fields != FieldFileName|FieldChecksum {
+		t.Errorf("got fields %s after a JSON round trip", opts.Fields)
+	}
+}
+
 func TestRepositoryMergeMutable(t *testing.T) {
 	a := Repository{
 		ID:   0,
@@ -348,6 +420,51 @@ func TestRepositoryMergeMutable(t *testing.T) {
 			t.Fatalf("got different LineFragmentTemplate, %s vs %s", a.LineFragmentTemplate, b.LineFragmentTemplate)
 		}
 	})
+	t.Run("different Branch description", func(t *testing.T) {
+		b := a
+		b.Branches = []RepositoryBranch{
+			{
+				Name:        "branchName",
+				Version:     "branchVersion",
+				Description: "description",
+			},
+		}
+		mutated, err := a.MergeMutable(&b)
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		if !mutated {
+			t.Fatalf("want mutated=true, got false")
+		}
+		if a.Branches[0].Description != "description" {
+			t.Fatalf("got Branch description %q, want %q", a.Branches[0].Description, "description")
+		}
+
+		// An unset description leaves the description as it is.
+		b.Branches = []RepositoryBranch{{Name: "branchName", Version: "branchVersion"}}
+		if mutated, err := a.MergeMutable(&b); err != nil || mutated {
+			t.Fatalf("got mutated=%t, err %v for an unset description", mutated, err)
+		}
+	})
+	t.Run("different priority", func(t *testing.T) {
+		c := a
+		c.RawConfig = map[string]string{"priority": "10"}
+		c.setRank()
+		oldRank := c.Rank
+
+		b := c
+		b.RawConfig = map[string]string{"priority": "100"}
+		mutated, err := c.MergeMutable(&b)
+		if err != nil {
+			t.Fatalf("got err %v", err)
+		}
+		if !mutated {
+			t.Fatalf("want mutated=true, got false")
+		}
+		if c.GetPriority() != 100 || c.Rank <= oldRank {
+			t.Fatalf("got priority %f and rank %d, want priority 100 and rank above %d", c.GetPriority(), c.Rank, oldRank)
+		}
+	})
 	t.Run("all same", func(t *testing.T) {
 		b := a
 		mutated, err := a.MergeMutable(&b)
@@ -363,6 +480,23 @@ func TestRepositoryMergeMutable(t *testing.T) {
 	})
 }
 
+func TestRepoStatsAdd(t *testing.T) {
+	shard1 := RepoStats{Shards: 1, Languages: map[string]LanguageStats{"Go": {Files: 1, Bytes: 10}}}
+	shard2 := RepoStats{Shards: 1, Languages: map[string]LanguageStats{"Go": {Files: 2, Bytes: 20}, "C": {Files: 1, Bytes: 5}}}
+
+	var agg RepoStats
+	agg.Add(&shard1)
+	agg.Add(&shard2)
+
+	want := RepoStats{Shards: 2, Languages: map[string]LanguageStats{"Go": {Files: 3, Bytes: 30}, "C": {Files: 1, Bytes: 5}}}
+	if !reflect.DeepEqual(agg, want) {
+		t.Errorf("got %+v, want %+v", agg, want)
+	}
+	if got := shard1.Languages["Go"]; got != (LanguageStats{Files: 1, Bytes: 10}) {
+		t.Errorf("Add changed the languages of the first shard: got %+v", got)
+	}
+}
+
 func TestMonthsSince1970(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -5,9 +5,15 @@
 //	zoekt-archive-index -incremental -commit b57cb1605fd11ba2ecfa7f68992b4b9cc791934d -name github.com/gorilla/mux -strip_components 1 https://codeload.github.com/gorilla/mux/legacy.tar.gz/b57cb1605fd11ba2ecfa7f68992b4b9cc791934d
 //
 //	zoekt-archive-index -branch master https://github.com/gorilla/mux/commit/b57cb1605fd11ba2ecfa7f68992b4b9cc791934d
+//
+// The archive can be piped in, eg. from git archive. gzip and zstd
+// compression is detected:
+//
+//	git archive HEAD | zoekt-archive-index -stdin -format tar -name myrepo -branch main
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 
@@ -25,6 +31,8 @@ func main() {
 		urlRaw = flag.String("url", "", "The repository URL for the archive")
 		branch = flag.String("branch", "", "The branch name for the archive")
 		commit = flag.String("commit", "", "The commit sha for the archive. If incremental this will avoid updating shards already at commit")
+		stdin  = flag.Bool("stdin", false, "Read the archive from stdin instead of a URL or file. Equivalent to passing - as the archive location.")
+		format = flag.String("format", "", "The format of the archive, tar or zip. If empty, it is detected. Compressed tar files are always detected.")
 		strip  = flag.Int("strip_components", 0, "Remove the specified number of leading path elements. Pathnames with fewer elements will be silently skipped.")
 
 		downloadLimitMbps = flag.Int64("download-limit-mbps", 0, "If non-zero, limit archive downloads to specified amount in megabits per second")
@@ -36,15 +44,24 @@ func main() {
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	if len(flag.Args()) != 1 {
-		log.Fatal("expected argument for archive location")
+	var archiveURL string
+	if *stdin {
+		if len(flag.Args()) != 0 {
+			log.Fatal("unexpected archive location with -stdin")
+		}
+		archiveURL = "-"
+	} else {
+		if len(flag.Args()) != 1 {
+			log.Fatal("expected argument for archive location")
+		}
+		archiveURL = flag.Args()[0]
 	}
-	archiveURL := flag.Args()[0]
 	bopts := cmd.OptionsFromFlags()
 	opts := archive.Options{
 		Incremental: *incremental,
 
 		Archive: archiveURL,
+		Format:  *format,
 		Name:    *name,
 		RepoURL: *urlRaw,
 		Branch:  *branch,
@@ -55,7 +72,7 @@ func main() {
 	// Sourcegraph specific: Limit HTTP traffic
 	limitHTTPDefaultClient(*downloadLimitMbps)
 
-	if err := archive.Index(opts, *bopts); err != nil {
+	if err := archive.Index(context.Background(), opts, *bopts); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type status int
+
+const (
+	statusOK status = iota
+	statusWarn
+	statusFail
+)
+
+func (s status) String() string {
+	switch s {
+	case statusOK:
+		return "OK"
+	case statusWarn:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+// finding is the outcome of a check. Hint tells the operator what to do about
+// warnings and failures.
+type finding struct {
+	Check   string
+	Status  status
+	Message string
+	Hint    string
+}
+
+func ok(check, format string, args ...any) finding {
+	return finding{Check: check, Status: statusOK, Message: fmt.Sprintf(format, args...)}
+}
+
+func warn(check, hint, format string, args ...any) finding {
+	return finding{Check: check, Status: statusWarn, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+func fail(check, hint, format string, args ...any) finding {
+	return finding{Check: check, Status: statusFail, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+// checkIndexDir checks that dir exists, is writable and contains shards.
+// Temporary files left behind by crashed builds are reported as well.
+func checkIndexDir(dir string) []finding {
+	const check = "index dir"
+
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return []finding{fail(check, "create it or pass the directory the indexserver writes to with -index", "%s does not exist", dir)}
+	} else if err != nil {
+		return []finding{fail(check, "check the permissions of its parent directories", "stat %s: %v", dir, err)}
+	} else if !fi.IsDir() {
+		return []finding{fail(check, "pass the index directory, not a shard, with -index", "%s is not a directory", dir)}
+	}
+
+	var findings []finding
+
+	f, err := os.CreateTemp(dir, "zoekt-doctor-*.tmp")
+	if err != nil {
+		findings = append(findings, fail(check, "the indexserver runs as a user which must be able to write to the index directory", "%s is not writable: %v", dir, err))
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+		findings = append(findings, ok(check, "%s is writable", dir))
+	}
+
+	shards, _ := filepath.Glob(filepath.Join(dir, "*.zoekt"))
+	if len(shards) == 0 {
+		findings = append(findings, warn(check, "the webserver has nothing to search until the indexserver has built shards", "%s contains no shards", dir))
+	} else {
+		findings = append(findings, ok(check, "%s contains %d shards", dir, len(shards)))
+	}
+
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*.zoekt*.tmp"))
+	if len(tmps) > 0 {
+		findings = append(findings, warn(check, "these are left behind by interrupted builds; remove them if no indexer is running", "%d temporary shard files, eg. %s", len(tmps), filepath.Base(tmps[0])))
+	}
+
+	return findings
+}
+
+// checkShards loads a random sample of up to n shards in dir and searches
+// them.
+func checkShards(dir string, n int) []finding {
+	const check = "shard integrity"
+
+	shards, _ := filepath.Glob(filepath.Join(dir, "*.zoekt"))
+	if len(shards) == 0 {
+		return nil
+	}
+
+	sort.Strings(shards)
+	rand.Shuffle(len(shards), func(i, j int) { shards[i], shards[j] = shards[j], shards[i] })
+	if len(shards) > n {
+		shards = shards[:n]
+	}
+
+	var findings []finding
+	for _, path := range shards {
+		if err := checkShard(path); err != nil {
+			findings = append(findings, fail(check, "delete the shard so it is rebuilt, and check the disk for errors", "%s: %v", filepath.Base(path), err))
+		}
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, ok(check, "%d sampled shards load and search", len(shards)))
+	}
+	return findings
+}
+
+func checkShard(path string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("crashed: %v", e)
+		}
+	}()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	indexFile, err := index.NewIndexFile(f)
+	if err != nil {
+		return err
+	}
+
+	s, err := index.NewSearcher(indexFile)
+	if err != nil {
+		indexFile.Close()
+		return err
+	}
+	// Closing the searcher closes indexFile.
+	defer s.Close()
+
+	_, err = s.Search(context.Background(), &query.Substring{Pattern: "zoekt"}, &zoekt.SearchOptions{
+		ShardMaxMatchCount: 100,
+		Whole:              true,
+	})
+	return err
+}
+
+// checkCTags checks the ctags binaries the indexer uses, which it looks up the
+// same way as index.Options.SetDefaults.
+func checkCTags() []finding {
+	var findings []finding
+	for _, c := range []struct {
+		env, bin string
+		required bool
+	}{
+		{"CTAGS_COMMAND", "universal-ctags", true},
+		{"SCIP_CTAGS_COMMAND", "scip-ctags", false},
+	} {
+		check := c.bin
+		path := os.Getenv(c.env)
+		if path == "" {
+			path, _ = exec.LookPath(c.bin)
+		}
+
+		if path == "" {
+			if c.required {
+				findings = append(findings, warn(check, fmt.Sprintf("install %s or set %s; without it shards have no symbols and ranking suffers", c.bin, c.env), "not found"))
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+		cancel()
+		if err != nil {
+			findings = append(findings, fail(check, fmt.Sprintf("fix or replace the binary, or unset %s", c.env), "%s --version: %v", path, err))
+			continue
+		}
+		findings = append(findings, ok(check, "%s: %s", path, firstLine(out)))
+	}
+	return findings
+}
+
+// checkGit checks that git is installed and, if url is set, that the remote
+// is reachable with the credentials of the current user.
+func checkGit(url string, timeout time.Duration) []finding {
+	const check = "git"
+
+	git, err := exec.LookPath("git")
+	if err != nil {
+		return []finding{fail(check, "install git; the indexserver uses it to clone and fetch repositories", "not found")}
+	}
+
+	out, err := exec.Command(git, "--version").CombinedOutput()
+	if err != nil {
+		return []finding{fail(check, "fix or reinstall git", "git --version: %v", err)}
+	}
+	findings := []finding{ok(check, "%s", firstLine(out))}
+
+	if url == "" {
+		return findings
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, git, "ls-remote", "--heads", url)
+	// Fail instead of waiting for credentials on the terminal.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		findings = append(findings, fail(check, "check the URL, network access and the git credentials of this user", "git ls-remote %s: %v: %s", url, err, firstLine(out)))
+	} else {
+		findings = append(findings, ok(check, "%s is reachable", url))
+	}
+	return findings
+}
+
+// checkWebserver checks the health and metrics endpoints of the webserver at
+// addr.
+func checkWebserver(client *http.Client, addr string) []finding {
+	const check = "webserver"
+
+	addr = strings.TrimSuffix(addr, "/")
+	var findings []finding
+
+	if _, err := get(client, addr+"/healthz"); err != nil {
+		findings = append(findings, fail(check, "check that zoekt-webserver is running and listening on this address", "%v", err))
+	} else {
+		findings = append(findings, ok(check, "%s/healthz is healthy", addr))
+	}
+
+	return append(findings, checkMetrics(client, addr+"/metrics")...)
+}
+
+// checkMetrics checks that url serves Prometheus metrics.
+func checkMetrics(client *http.Client, url string) []finding {
+	const check = "metrics"
+
+	body, err := get(client, url)
+	if err != nil {
+		return []finding{fail(check, "metrics are needed for alerting; check the address and that the process is running", "%v", err)}
+	}
+
+	n := 0
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		if line := sc.Text(); line != "" && !strings.HasPrefix(line, "#") {
+			n++
+		}
+	}
+	if n == 0 {
+		return []finding{warn(check, "check that the address is a Prometheus metrics endpoint", "%s serves no samples", url)}
+	}
+	return []finding{ok(check, "%s serves %d samples", url, n)}
+}
+
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("GET %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s: %s", url, resp.Status, firstLine(b))
+	}
+	return string(b), nil
+}
+
+func firstLine(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+// Command zoekt-doctor checks a zoekt deployment for common
+// misconfigurations and prints actionable findings.
+//
+// It checks the index directory, loads a sample of shards, looks for the
+// ctags and git binaries the indexer needs and, if their addresses are given,
+// probes the webserver and further metrics endpoints. It exits with status 1
+// if any check fails.
+//
+// Usage:
+//
+//	zoekt-doctor [-index DIR] [-webserver URL] [-git_url URL] [-metrics URL,...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func main() {
+	indexDir := flag.String("index", index.DefaultDir, "the index directory to check.")
+	sample := flag.Int("shard_sample", 10, "the number of shards to load and search.")
+	webserver := flag.String("webserver", "", "the address of zoekt-webserver, eg. http://localhost:6070. If empty, the webserver is not checked.")
+	gitURL := flag.String("git_url", "", "the clone URL of a sample repository to check git connectivity with.")
+	metrics := flag.String("metrics", "", "comma separated list of further metrics endpoints to check, eg. of the indexserver.")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of network checks.")
+	flag.Parse()
+
+	var findings []finding
+	findings = append(findings, checkIndexDir(*indexDir)...)
+	findings = append(findings, checkShards(*indexDir, *sample)...)
+	findings = append(findings, checkCTags()...)
+	findings = append(findings, checkGit(*gitURL, *timeout)...)
+
+	client := &http.Client{Timeout: *timeout}
+	if *webserver != "" {
+		findings = append(findings, checkWebserver(client, *webserver)...)
+	}
+	for _, u := range strings.Split(*metrics, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			findings = append(findings, checkMetrics(client, u)...)
+		}
+	}
+
+	if report(os.Stdout, findings) {
+		os.Exit(1)
+	}
+}
+
+// report prints findings to w. It returns true if any check failed.
+func report(w io.Writer, findings []finding) (failed bool) {
+	var warnings, failures int
+	for _, f := range findings {
+		fmt.Fprintf(w, "[%-4s] %-16s %s\n", f.Status, f.Check, f.Message)
+		if f.Hint != "" {
+			fmt.Fprintf(w, "       %-16s -> %s\n", "", f.Hint)
+		}
+
+		switch f.Status {
+		case statusWarn:
+			warnings++
+		case statusFail:
+			failures++
+		}
+	}
+	fmt.Fprintf(w, "\n%d checks, %d warnings, %d failures\n", len(findings), warnings, failures)
+	return failures > 0
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func statuses(findings []finding) []status {
+	var s []status
+	for _, f := range findings {
+		s = append(s, f.Status)
+	}
+	return s
+}
+
+func hasStatus(findings []finding, want status) bool {
+	for _, f := range findings {
+		if f.Status == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckIndexDir(t *testing.T) {
+	dir := t.TempDir()
+
+	if got := checkIndexDir(filepath.Join(dir, "missing")); !hasStatus(got, statusFail) {
+		t.Errorf("missing dir: got %v, want a failure", got)
+	}
+
+	if got := checkIndexDir(dir); !hasStatus(got, statusWarn) {
+		t.Errorf("empty dir: got %v, want a warning", got)
+	}
+
+	copyShard(t, filepath.Join("..", "..", "testdata", "shards", "repo_v16.00000.zoekt"), filepath.Join(dir, "repo_v16.00000.zoekt"))
+	if got := checkIndexDir(dir); hasStatus(got, statusWarn) || hasStatus(got, statusFail) {
+		t.Errorf("dir with shard: got %v, want no warnings", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "repo_v16.00000.zoekt.123.tmp"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := checkIndexDir(dir); !hasStatus(got, statusWarn) {
+		t.Errorf("dir with temporary shard: got %v, want a warning", got)
+	}
+}
+
+func TestCheckShards(t *testing.T) {
+	dir := t.TempDir()
+	copyShard(t, filepath.Join("..", "..", "testdata", "shards", "repo_v16.00000.zoekt"), filepath.Join(dir, "repo_v16.00000.zoekt"))
+
+	if got := checkShards(dir, 10); fmt.Sprint(statuses(got)) != "[OK]" {
+		t.Fatalf("got %v, want OK", got)
+	}
+
+	// A truncated shard is reported.
+	b, err := os.ReadFile(filepath.Join(dir, "repo_v16.00000.zoekt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken_v16.00000.zoekt"), b[:len(b)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := checkShards(dir, 10)
+	if len(got) != 1 || got[0].Status != statusFail || !strings.Contains(got[0].Message, "broken_v16.00000.zoekt") {
+		t.Fatalf("got %v, want a failure for the broken shard", got)
+	}
+}
+
+func TestCheckWebserver(t *testing.T) {
+	healthy := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			if !healthy {
+				http.Error(w, "not ready", http.StatusServiceUnavailable)
+			}
+		case "/metrics":
+			fmt.Fprintln(w, "# TYPE zoekt_shards_loaded gauge")
+			fmt.Fprintln(w, "zoekt_shards_loaded 3")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	if got := checkWebserver(ts.Client(), ts.URL+"/"); fmt.Sprint(statuses(got)) != "[OK OK]" {
+		t.Errorf("healthy: got %v, want OK", got)
+	}
+
+	healthy = false
+	if got := checkWebserver(ts.Client(), ts.URL); fmt.Sprint(statuses(got)) != "[FAIL OK]" {
+		t.Errorf("unhealthy: got %v, want a failed health check", got)
+	}
+
+	if got := checkMetrics(ts.Client(), ts.URL+"/missing"); !hasStatus(got, statusFail) {
+		t.Errorf("missing metrics: got %v, want a failure", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	var buf bytes.Buffer
+	failed := report(&buf, []finding{
+		ok("git", "git version 2.39"),
+		warn("universal-ctags", "install it", "not found"),
+	})
+	if failed {
+		t.Errorf("got failed, want no failure for warnings")
+	}
+
+	want := `[OK  ] git              git version 2.39
+[WARN] universal-ctags  not found
+                        -> install it
+
+2 checks, 1 warnings, 0 failures
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func copyShard(t *testing.T, src, dst string) {
+	t.Helper()
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -16,6 +16,10 @@
 // zoekt-indexserver, it's designed for a "push-based" indexing model. The server
 // * listens to indexing commands
 // * reindexes specified repositories
+//
+// Indexing is asynchronous: POST /index queues a job and returns its ID, and
+// GET /status/{id} reports the state and phase of the job. GET /repos lists
+// the indexed repositories, and DELETE /repo/{id} removes one of them.
 package main
 
 import (
@@ -30,12 +34,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/sourcegraph/zoekt/internal/webhook"
 )
 
 func loggedRun(cmd *exec.Cmd) error {
@@ -59,6 +66,16 @@ type Options struct {
 	repoDir      string
 	indexDir     string
 	listen       string
+
+	// concurrency is the number of index jobs which run at the same time.
+	concurrency int
+
+	// queueFile is where the job queue is persisted across restarts.
+	queueFile string
+
+	// webhookURLs are the endpoints notified when index jobs start and
+	// finish.
+	webhookURLs []string
 }
 
 func (o *Options) createMissingDirectories() {
@@ -83,10 +100,17 @@ var executeCmd = func(ctx context.Context, name string, arg ...string) error {
 	return err
 }
 
-func indexRepository(opts Options, req indexRequest) (map[string]any, error) {
+// indexRepository clones, fetches and indexes the repository of req. onPhase,
+// if non-nil, is called when each of these phases starts.
+func indexRepository(opts Options, req indexRequest, onPhase func(jobPhase)) error {
 	ctx, cancel := context.WithTimeout(context.Background(), opts.indexTimeout)
 	defer cancel()
 
+	if onPhase == nil {
+		onPhase = func(jobPhase) {}
+	}
+
+	onPhase(phaseClone)
 	args := []string{}
 	args = append(args, "-dest", opts.repoDir)
 	args = append(args, "-name", strconv.FormatUint(uint64(req.RepoID), 10))
@@ -94,14 +118,15 @@ func indexRepository(opts Options, req indexRequest) (map[string]any, error) {
 	args = append(args, req.CloneURL)
 	err := executeCmd(ctx, "zoekt-git-clone", args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	gitRepoPath, err := filepath.Abs(filepath.Join(opts.repoDir, fmt.Sprintf("%d.git", req.RepoID)))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	onPhase(phaseFetch)
 	args = []string{
 		"-C",
 		gitRepoPath,
@@ -109,27 +134,21 @@ func indexRepository(opts Options, req indexRequest) (map[string]any, error) {
 	}
 	err = executeCmd(ctx, "git", args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	onPhase(phaseIndex)
 	args = []string{
 		"-index", opts.indexDir,
 		gitRepoPath,
 	}
-	err = executeCmd(ctx, "zoekt-git-index", args...)
-	if err != nil {
-		return nil, err
-	}
-
-	response := map[string]any{
-		"Success": true,
-	}
-
-	return response, nil
+	return executeCmd(ctx, "zoekt-git-index", args...)
 }
 
 type indexServer struct {
 	opts                 Options
+	queue                *jobQueue
+	webhooks             *webhook.Notifier
 	promRegistry         *prometheus.Registry
 	metricsRequestsTotal *prometheus.CounterVec
 }
@@ -154,18 +173,58 @@ func (s *indexServer) serveIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := indexRepository(s.opts, req)
-	if err != nil {
-		s.respondWithError(w, r.Method, route, err)
+	j := s.queue.enqueue(req)
+
+	response := map[string]any{
+		"Success": true,
+		"JobID":   j.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(response)
+
+	s.incrementRequestsTotal(r.Method, route, http.StatusAccepted)
+}
+
+func (s *indexServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	route := "status"
+	j, ok := s.queue.get(strings.TrimPrefix(r.URL.Path, "/status/"))
+	if !ok {
+		http.Error(w, "unknown job", http.StatusNotFound)
+		s.incrementRequestsTotal(r.Method, route, http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(response)
+	_ = json.NewEncoder(w).Encode(j)
 
 	s.incrementRequestsTotal(r.Method, route, http.StatusOK)
 }
 
+// runWorker runs the jobs of s.queue, one at a time.
+func (s *indexServer) runWorker() {
+	for {
+		j := s.queue.next()
+		s.webhooks.Notify(webhook.Event{Type: webhook.IndexStarted, RepoID: j.Request.RepoID})
+
+		start := time.Now()
+		err := indexRepository(s.opts, j.Request, func(p jobPhase) {
+			s.queue.setPhase(j.ID, p)
+		})
+		e := webhook.Event{
+			Type:            webhook.IndexSucceeded,
+			RepoID:          j.Request.RepoID,
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if err != nil {
+			log.Printf("index job %s for repo %d failed: %v", j.ID, j.Request.RepoID, err)
+			e.Type, e.Error = webhook.IndexFailed, err.Error()
+		}
+		s.queue.finish(j.ID, err)
+		s.webhooks.Notify(e)
+	}
+}
+
 func (s *indexServer) serveTruncate(w http.ResponseWriter, r *http.Request) {
 	route := "truncate"
 	err := emptyDirectory(s.opts.repoDir)
@@ -184,6 +243,9 @@ func (s *indexServer) serveTruncate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The queue may be persisted in one of the directories we just emptied.
+	s.queue.save()
+
 	response := map[string]any{
 		"Success": true,
 	}
@@ -235,7 +297,10 @@ func (s *indexServer) startIndexingApi() {
 	http.HandleFunc("/", s.serveHealthCheck)
 	http.HandleFunc("/metrics", s.serveMetrics)
 	http.HandleFunc("/index", s.serveIndex)
+	http.HandleFunc("/status/", s.serveStatus)
 	http.HandleFunc("/truncate", s.serveTruncate)
+	http.HandleFunc("/repos", s.serveRepos)
+	http.HandleFunc("/repo/", s.serveDeleteRepo)
 
 	if err := http.ListenAndServe(s.opts.listen, nil); err != nil {
 		log.Fatal(err)
@@ -264,6 +329,9 @@ func parseOptions() Options {
 	indexDir := flag.String("index_dir", "", "directory holding index shards.")
 	timeout := flag.Duration("index_timeout", time.Hour, "kill index job after this much time.")
 	listen := flag.String("listen", ":6060", "listen on this address.")
+	concurrency := flag.Int("concurrency", 1, "number of repositories to index concurrently.")
+	queueFile := flag.String("queue_file", "", "file to persist queued index jobs in across restarts. Defaults to queue.json in -repo_dir.")
+	webhookURLs := flag.String("webhook_urls", "", "comma separated list of URLs to POST JSON events to when index jobs start, succeed or fail. Failed deliveries are retried with exponential backoff.")
 	flag.Parse()
 
 	if *repoDir == "" {
@@ -275,11 +343,22 @@ func parseOptions() Options {
 		*indexDir = filepath.Join(*repoDir, "index")
 	}
 
+	if *concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+
+	if *queueFile == "" {
+		*queueFile = filepath.Join(*repoDir, "queue.json")
+	}
+
 	return Options{
 		repoDir:      *repoDir,
 		indexDir:     *indexDir,
 		indexTimeout: *timeout,
 		listen:       *listen,
+		concurrency:  *concurrency,
+		queueFile:    *queueFile,
+		webhookURLs:  webhook.ParseEndpoints(*webhookURLs),
 	}
 }
 
@@ -287,10 +366,20 @@ func main() {
 	opts := parseOptions()
 	opts.createMissingDirectories()
 
+	queue, err := newJobQueue(opts.queueFile)
+	if err != nil {
+		log.Fatalf("failed to load index queue %s: %v", opts.queueFile, err)
+	}
+
 	server := indexServer{
-		opts: opts,
+		opts:     opts,
+		queue:    queue,
+		webhooks: webhook.New(webhook.Options{Endpoints: opts.webhookURLs}),
 	}
 
 	server.initMetrics()
+	for i := 0; i < opts.concurrency; i++ {
+		go server.runWorker()
+	}
 	server.startIndexingApi()
 }
@@ -88,7 +88,7 @@ func TestIndexRepository(t *testing.T) {
 		RepoID:   100,
 	}
 
-	_, err := indexRepository(opts, req)
+	err := indexRepository(opts, req, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -129,7 +129,7 @@ func TestIndexRepositoryWhenErr(t *testing.T) {
 		RepoID:   100,
 	}
 
-	_, err := indexRepository(opts, req)
+	err := indexRepository(opts, req, nil)
 
 	if err == nil {
 		t.Errorf("Error is empty, when it should be present")
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobRunning   jobState = "running"
+	jobSucceeded jobState = "succeeded"
+	jobFailed    jobState = "failed"
+)
+
+// jobPhase is the step of indexRepository a running job is in.
+type jobPhase string
+
+const (
+	phaseClone jobPhase = "clone"
+	phaseFetch jobPhase = "fetch"
+	phaseIndex jobPhase = "index"
+)
+
+// maxFinishedJobs is the number of finished jobs we keep around so that
+// clients can look up their status.
+const maxFinishedJobs = 1000
+
+type job struct {
+	ID      string
+	Request indexRequest
+	State   jobState
+	Phase   jobPhase `json:",omitempty"`
+	Error   string   `json:",omitempty"`
+
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+}
+
+// jobQueue is a FIFO queue of index jobs. At most one job per repository
+// runs at a time. If path is set, the queue is written to path on every
+// change and read back by newJobQueue, so that queued jobs survive restarts.
+type jobQueue struct {
+	path string
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     map[string]*job
+	pending  []string          // IDs of queued jobs, oldest first
+	finished []string          // IDs of finished jobs, oldest first
+	running  map[uint32]string // repository ID => ID of its running job
+
+	// seq counts the changes to the queue, see persist.
+	seq uint64
+
+	// persistMu serializes writes to path. persistedSeq is the seq of the
+	// last snapshot written.
+	persistMu    sync.Mutex
+	persistedSeq uint64
+}
+
+type persistedQueue struct {
+	Jobs []*job
+}
+
+func newJobQueue(path string) (*jobQueue, error) {
+	q := &jobQueue{
+		path:    path,
+		jobs:    map[string]*job{},
+		running: map[uint32]string{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	if path == "" {
+		return q, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var p persistedQueue
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(p.Jobs, func(i, j int) bool {
+		return p.Jobs[i].Created.Before(p.Jobs[j].Created)
+	})
+
+	for _, j := range p.Jobs {
+		switch j.State {
+		case jobQueued, jobRunning:
+			// Jobs which were running when we stopped are started from
+			// scratch.
+			j.State = jobQueued
+			j.Phase = ""
+			j.Started = time.Time{}
+			q.pending = append(q.pending, j.ID)
+		default:
+			q.finished = append(q.finished, j.ID)
+		}
+		q.jobs[j.ID] = j
+	}
+
+	if len(q.pending) > 0 {
+		log.Printf("resuming %d queued index jobs from %s", len(q.pending), path)
+	}
+
+	return q, nil
+}
+
+// enqueue adds a job for req to the queue. If there already is a queued job
+// for the same request, that job is returned instead. A request for a
+// repository which is being indexed is coalesced into a single queued job,
+// which runs once the running job finished.
+func (q *jobQueue) enqueue(req indexRequest) job {
+	q.mu.Lock()
+	for _, id := range q.pending {
+		if j := q.jobs[id]; j.Request == req {
+			q.mu.Unlock()
+			return *j
+		}
+	}
+
+	j := &job{
+		ID:      newJobID(),
+		Request: req,
+		State:   jobQueued,
+		Created: time.Now(),
+	}
+	q.jobs[j.ID] = j
+	q.pending = append(q.pending, j.ID)
+	q.cond.Broadcast()
+	queued := *j
+	q.mu.Unlock()
+
+	q.persist()
+	return queued
+}
+
+// next blocks until a job is queued whose repository is not being indexed,
+// marks it as running and returns it.
+func (q *jobQueue) next() job {
+	q.mu.Lock()
+	i := q.runnable()
+	for i < 0 {
+		q.cond.Wait()
+		i = q.runnable()
+	}
+
+	j := q.jobs[q.pending[i]]
+	q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+	q.running[j.Request.RepoID] = j.ID
+
+	j.State = jobRunning
+	j.Started = time.Now()
+	running := *j
+	q.mu.Unlock()
+
+	q.persist()
+	return running
+}
+
+// runnable returns the index in q.pending of the oldest job whose repository
+// is not being indexed, or -1. It must be called with q.mu held.
+func (q *jobQueue) runnable() int {
+	for i, id := range q.pending {
+		if _, ok := q.running[q.jobs[id].Request.RepoID]; !ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *jobQueue) setPhase(id string, phase jobPhase) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if ok {
+		j.Phase = phase
+	}
+	q.mu.Unlock()
+
+	if ok {
+		q.persist()
+	}
+}
+
+// finish marks the running job id as succeeded, or as failed if err is
+// non-nil.
+func (q *jobQueue) finish(id string, err error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return
+	}
+
+	j.State = jobSucceeded
+	if err != nil {
+		j.State = jobFailed
+		j.Error = err.Error()
+	}
+	j.Finished = time.Now()
+
+	if q.running[j.Request.RepoID] == id {
+		delete(q.running, j.Request.RepoID)
+		// A job for the same repository may be runnable now.
+		q.cond.Broadcast()
+	}
+
+	q.finished = append(q.finished, id)
+	for len(q.finished) > maxFinishedJobs {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+	q.mu.Unlock()
+
+	q.persist()
+}
+
+// get returns the job with the given ID.
+func (q *jobQueue) get(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// save writes the queue to q.path.
+func (q *jobQueue) save() {
+	q.persist()
+}
+
+// persist writes the queue to q.path. It must be called without q.mu held,
+// so that the file system doesn't block the queue: we only take a snapshot
+// under q.mu. Snapshots are numbered, so a slow write never replaces a newer
+// snapshot, and a change which is already covered by a newer write is
+// skipped. Failures are logged, since the in-memory queue is still intact.
+func (q *jobQueue) persist() {
+	if q.path == "" {
+		return
+	}
+
+	q.mu.Lock()
+	q.seq++
+	seq := q.seq
+	p := persistedQueue{Jobs: make([]*job, 0, len(q.jobs))}
+	for _, j := range q.jobs {
+		cp := *j
+		p.Jobs = append(p.Jobs, &cp)
+	}
+	q.mu.Unlock()
+
+	sort.SliceStable(p.Jobs, func(i, j int) bool {
+		return p.Jobs[i].Created.Before(p.Jobs[j].Created)
+	})
+
+	q.persistMu.Lock()
+	defer q.persistMu.Unlock()
+	if seq <= q.persistedSeq {
+		return
+	}
+
+	b, err := json.Marshal(&p)
+	if err != nil {
+		log.Printf("failed to marshal index queue: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(q.path), 0o755); err != nil {
+		log.Printf("failed to persist index queue: %v", err)
+		return
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		log.Printf("failed to persist index queue: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		log.Printf("failed to persist index queue: %v", err)
+		return
+	}
+	q.persistedSeq = seq
+}
+
+func newJobID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := newJobQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := q.enqueue(indexRequest{CloneURL: "https://example.com/a.git", RepoID: 1})
+	b := q.enqueue(indexRequest{CloneURL: "https://example.com/b.git", RepoID: 2})
+	c := q.enqueue(indexRequest{CloneURL: "https://example.com/c.git", RepoID: 3})
+
+	// Queuing the same request again returns the queued job.
+	if got := q.enqueue(b.Request); got.ID != b.ID {
+		t.Fatalf("got new job %s for duplicate request, want %s", got.ID, b.ID)
+	}
+
+	if got := q.next(); got.ID != a.ID || got.State != jobRunning {
+		t.Fatalf("got job %s in state %s, want running job %s", got.ID, got.State, a.ID)
+	}
+	q.finish(a.ID, nil)
+
+	if got := q.next(); got.ID != b.ID {
+		t.Fatalf("got job %s, want %s", got.ID, b.ID)
+	}
+	q.setPhase(b.ID, phaseFetch)
+
+	if got, _ := q.get(b.ID); got.Phase != phaseFetch {
+		t.Fatalf("got phase %q, want %q", got.Phase, phaseFetch)
+	}
+
+	// Restart: the running job b is queued again before c, and a keeps its
+	// status.
+	q, err = newJobQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := q.get(a.ID); !ok || got.State != jobSucceeded {
+		t.Fatalf("got job %+v, want succeeded job %s", got, a.ID)
+	}
+	for _, want := range []job{b, c} {
+		got := q.next()
+		if got.ID != want.ID || got.Phase != "" {
+			t.Fatalf("got job %s in phase %q, want %s in no phase", got.ID, got.Phase, want.ID)
+		}
+	}
+}
+
+func TestJobQueue_SameRepo(t *testing.T) {
+	q, err := newJobQueue("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := indexRequest{CloneURL: "https://example.com/a.git", RepoID: 1}
+	a := q.enqueue(req)
+	if got := q.next(); got.ID != a.ID {
+		t.Fatalf("got job %s, want %s", got.ID, a.ID)
+	}
+
+	// While a is running, requests for the same repository are coalesced into
+	// one queued job.
+	b := q.enqueue(req)
+	if b.ID == a.ID {
+		t.Fatal("got running job for request, want a new queued job")
+	}
+	if got := q.enqueue(req); got.ID != b.ID {
+		t.Fatalf("got job %s for duplicate request, want %s", got.ID, b.ID)
+	}
+	c := q.enqueue(indexRequest{CloneURL: "https://example.com/c.git", RepoID: 3})
+
+	// b waits for a to finish, so another worker gets c.
+	if got := q.next(); got.ID != c.ID {
+		t.Fatalf("got job %s, want %s", got.ID, c.ID)
+	}
+
+	next := make(chan job)
+	go func() { next <- q.next() }()
+	select {
+	case got := <-next:
+		t.Fatalf("got job %s while job %s of the same repository is running", got.ID, a.ID)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	q.finish(a.ID, nil)
+	if got := <-next; got.ID != b.ID {
+		t.Fatalf("got job %s, want %s", got.ID, b.ID)
+	}
+}
+
+func TestServeIndexAsync(t *testing.T) {
+	release := make(chan struct{})
+	executeCmd = func(ctx context.Context, name string, arg ...string) error {
+		if name == "zoekt-git-index" {
+			<-release
+			return errors.New("index failed")
+		}
+		return nil
+	}
+
+	q, err := newJobQueue("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &indexServer{
+		opts:  Options{indexTimeout: time.Minute, repoDir: "/repo_dir", indexDir: "/index_dir"},
+		queue: q,
+	}
+	s.initMetrics()
+	go s.runWorker()
+
+	w := httptest.NewRecorder()
+	s.serveIndex(w, httptest.NewRequest("POST", "/index", strings.NewReader(`{"CloneURL": "https://example.com/a.git", "RepoID": 1}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusAccepted)
+	}
+	var resp struct {
+		Success bool
+		JobID   string
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success || resp.JobID == "" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	status := func() job {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.serveStatus(w, httptest.NewRequest("GET", "/status/"+resp.JobID, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var j job
+		if err := json.NewDecoder(w.Body).Decode(&j); err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+
+	waitFor := func(cond func(job) bool) job {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			j := status()
+			if cond(j) {
+				return j
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for job, last status %+v", j)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitFor(func(j job) bool { return j.State == jobRunning && j.Phase == phaseIndex })
+	close(release)
+
+	j := waitFor(func(j job) bool { return j.State == jobFailed })
+	if j.Error != "index failed" {
+		t.Fatalf("got error %q, want %q", j.Error, "index failed")
+	}
+
+	w = httptest.NewRecorder()
+	s.serveStatus(w, httptest.NewRequest("GET", "/status/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d for unknown job, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// repoShard is a shard holding an indexed repository.
+type repoShard struct {
+	Path      string
+	SizeBytes int64
+
+	// Compound is set if the shard holds other repositories too.
+	Compound bool
+}
+
+// indexedRepo is a repository in the index directory, as listed by GET
+// /repos.
+type indexedRepo struct {
+	RepoID      uint32
+	Name        string
+	LastIndexed time.Time
+	SizeBytes   int64
+	Shards      []repoShard
+}
+
+// indexedRepos returns the alive repositories of the shards in the index
+// directory by ID.
+func (s *indexServer) indexedRepos() (map[uint32]*indexedRepo, error) {
+	fns, err := filepath.Glob(filepath.Join(s.opts.indexDir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[uint32]*indexedRepo{}
+	for _, fn := range fns {
+		repos, md, err := index.ReadMetadataPathAlive(fn)
+		if err != nil {
+			log.Printf("ReadMetadataPathAlive(%s): %v", fn, err)
+			continue
+		}
+		fi, err := os.Stat(fn)
+		if err != nil {
+			log.Printf("stat %s: %v", fn, err)
+			continue
+		}
+
+		sh := repoShard{
+			Path:      fn,
+			SizeBytes: fi.Size(),
+			Compound:  len(repos) > 1 || strings.HasPrefix(filepath.Base(fn), "compound-"),
+		}
+		for _, repo := range repos {
+			r, ok := res[repo.ID]
+			if !ok {
+				r = &indexedRepo{RepoID: repo.ID, Name: repo.Name}
+				res[repo.ID] = r
+			}
+			r.Shards = append(r.Shards, sh)
+			r.SizeBytes += sh.SizeBytes
+			if md.IndexTime.After(r.LastIndexed) {
+				r.LastIndexed = md.IndexTime
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *indexServer) serveRepos(w http.ResponseWriter, r *http.Request) {
+	route := "repos"
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		s.incrementRequestsTotal(r.Method, route, http.StatusMethodNotAllowed)
+		return
+	}
+
+	repos, err := s.indexedRepos()
+	if err != nil {
+		s.respondWithError(w, r.Method, route, err)
+		return
+	}
+
+	list := make([]*indexedRepo, 0, len(repos))
+	for _, repo := range repos {
+		list = append(list, repo)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RepoID < list[j].RepoID })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+
+	s.incrementRequestsTotal(r.Method, route, http.StatusOK)
+}
+
+// deleteResult reports what DELETE /repo/{id} removed.
+type deleteResult struct {
+	Success bool
+	RepoID  uint32
+
+	// Clone is the removed clone of the repository, if there was one.
+	Clone string `json:",omitempty"`
+
+	// RemovedShards are the deleted shards, TombstonedShards the compound
+	// shards in which the repository is now tombstoned.
+	RemovedShards    []string `json:",omitempty"`
+	TombstonedShards []string `json:",omitempty"`
+}
+
+// deleteRepository removes the clone and the shards of the repository with
+// the given ID. Shards shared with other repositories are kept, with the
+// repository tombstoned in them.
+func (s *indexServer) deleteRepository(repoID uint32) (*deleteResult, error) {
+	res := &deleteResult{RepoID: repoID}
+
+	clone := filepath.Join(s.opts.repoDir, fmt.Sprintf("%d.git", repoID))
+	if _, err := os.Stat(clone); err == nil {
+		if err := os.RemoveAll(clone); err != nil {
+			return nil, fmt.Errorf("removing clone %s: %w", clone, err)
+		}
+		res.Clone = clone
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	repos, err := s.indexedRepos()
+	if err != nil {
+		return nil, err
+	}
+	repo, ok := repos[repoID]
+	if !ok {
+		return res, nil
+	}
+	for _, sh := range repo.Shards {
+		if sh.Compound {
+			if err := index.SetTombstone(sh.Path, repoID); err != nil {
+				return nil, fmt.Errorf("tombstoning repository %d in %s: %w", repoID, sh.Path, err)
+			}
+			res.TombstonedShards = append(res.TombstonedShards, sh.Path)
+			continue
+		}
+
+		paths, err := index.IndexFilePaths(sh.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil {
+				return nil, err
+			}
+		}
+		res.RemovedShards = append(res.RemovedShards, sh.Path)
+	}
+	return res, nil
+}
+
+func (s *indexServer) serveDeleteRepo(w http.ResponseWriter, r *http.Request) {
+	route := "repo"
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		s.incrementRequestsTotal(r.Method, route, http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/repo/"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid repository ID", http.StatusBadRequest)
+		s.incrementRequestsTotal(r.Method, route, http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.deleteRepository(uint32(id))
+	if err != nil {
+		s.respondWithError(w, r.Method, route, fmt.Errorf("failed to delete repository %d: %w", id, err))
+		return
+	}
+	if res.Clone == "" && len(res.RemovedShards) == 0 && len(res.TombstonedShards) == 0 {
+		http.Error(w, "unknown repository", http.StatusNotFound)
+		s.incrementRequestsTotal(r.Method, route, http.StatusNotFound)
+		return
+	}
+
+	res.Success = true
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+
+	s.incrementRequestsTotal(r.Method, route, http.StatusOK)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// buildShards indexes a repository with each of ids into dir and returns the
+// paths of their shards.
+func buildShards(t *testing.T, dir string, ids ...uint32) []string {
+	t.Helper()
+
+	var fns []string
+	for _, id := range ids {
+		opts := index.Options{
+			IndexDir:              dir,
+			RepositoryDescription: zoekt.Repository{ID: id, Name: fmt.Sprintf("repo%d", id)},
+		}
+		opts.SetDefaults()
+		b, err := index.NewBuilder(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFile("F", []byte(fmt.Sprintf("content of repo %d", id))); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		fns = append(fns, opts.FindAllShards()...)
+	}
+	return fns
+}
+
+// buildCompoundShard merges the shards of repositories with ids into a
+// compound shard in dir.
+func buildCompoundShard(t *testing.T, dir string, ids ...uint32) string {
+	t.Helper()
+
+	var files []index.IndexFile
+	fns := buildShards(t, dir, ids...)
+	for _, fn := range fns {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		iFile, err := index.NewIndexFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer iFile.Close()
+		files = append(files, iFile)
+	}
+
+	tmpName, dstName, err := index.Merge(dir, files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range fns {
+		if err := os.Remove(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dstName
+}
+
+func TestServeRepos(t *testing.T) {
+	repoDir, indexDir := t.TempDir(), t.TempDir()
+	simple := buildShards(t, indexDir, 1)
+	compound := buildCompoundShard(t, indexDir, 2, 3)
+	if err := os.MkdirAll(filepath.Join(repoDir, "1.git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &indexServer{opts: Options{repoDir: repoDir, indexDir: indexDir}}
+	s.initMetrics()
+
+	list := func() []indexedRepo {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.serveRepos(w, httptest.NewRequest("GET", "/repos", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var repos []indexedRepo
+		if err := json.NewDecoder(w.Body).Decode(&repos); err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}
+
+	deleteRepo := func(id string, wantCode int) deleteResult {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.serveDeleteRepo(w, httptest.NewRequest("DELETE", "/repo/"+id, nil))
+		if w.Code != wantCode {
+			t.Fatalf("got status %d deleting repository %s, want %d", w.Code, id, wantCode)
+		}
+		var res deleteResult
+		if wantCode == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res
+	}
+
+	repos := list()
+	if len(repos) != 3 {
+		t.Fatalf("got %d repositories, want 3: %+v", len(repos), repos)
+	}
+	for i, r := range repos {
+		if want := uint32(i + 1); r.RepoID != want || r.Name != fmt.Sprintf("repo%d", want) {
+			t.Errorf("got repository %d %q, want %d", r.RepoID, r.Name, want)
+		}
+		if len(r.Shards) != 1 || r.SizeBytes == 0 || r.SizeBytes != r.Shards[0].SizeBytes {
+			t.Errorf("got shards %+v with size %d for repository %d", r.Shards, r.SizeBytes, r.RepoID)
+		}
+		if r.Shards[0].Compound != (r.RepoID != 1) {
+			t.Errorf("got compound %t for repository %d", r.Shards[0].Compound, r.RepoID)
+		}
+	}
+
+	res := deleteRepo("1", http.StatusOK)
+	if !res.Success || res.Clone != filepath.Join(repoDir, "1.git") || len(res.RemovedShards) != 1 || res.RemovedShards[0] != simple[0] {
+		t.Errorf("unexpected result deleting repository 1: %+v", res)
+	}
+	if _, err := os.Stat(simple[0]); !os.IsNotExist(err) {
+		t.Errorf("shard %s still exists", simple[0])
+	}
+
+	res = deleteRepo("2", http.StatusOK)
+	if res.Clone != "" || len(res.TombstonedShards) != 1 || res.TombstonedShards[0] != compound {
+		t.Errorf("unexpected result deleting repository 2: %+v", res)
+	}
+	if _, err := os.Stat(compound); err != nil {
+		t.Errorf("compound shard was removed: %v", err)
+	}
+
+	if repos := list(); len(repos) != 1 || repos[0].RepoID != 3 {
+		t.Errorf("got repositories %+v after deleting, want only 3", repos)
+	}
+
+	deleteRepo("1", http.StatusNotFound)
+	deleteRepo("abc", http.StatusBadRequest)
+}
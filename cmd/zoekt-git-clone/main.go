@@ -35,6 +35,8 @@ func main() {
 	dest := flag.String("dest", "", "destination directory")
 	nameFlag := flag.String("name", "", "name of repository")
 	repoIDFlag := flag.Uint("repoid", 0, "id of repository")
+	depth := flag.Int("depth", 0, "if positive, create a shallow clone with this many commits per branch")
+	filter := flag.String("filter", "", "create a partial clone with this object filter, eg. blob:none. Index it with zoekt-git-index -fetch_missing_blobs.")
 	flag.Parse()
 
 	if *dest == "" {
@@ -68,9 +70,12 @@ func main() {
 		config["zoekt.repoid"] = strconv.FormatUint(uint64(repoID), 10)
 	}
 
-	destRepo, err := gitindex.CloneRepo(destDir, filepath.Base(name), u.String(), config)
+	destRepo, err := gitindex.CloneRepoWithOptions(destDir, filepath.Base(name), u.String(), config, gitindex.CloneOptions{
+		Depth:  *depth,
+		Filter: *filter,
+	})
 	if err != nil {
-		log.Fatalf("CloneRepo: %v", err)
+		log.Fatalf("CloneRepoWithOptions: %v", err)
 	}
 	if destRepo != "" {
 		fmt.Println(destRepo)
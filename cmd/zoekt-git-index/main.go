@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -35,8 +36,20 @@ import (
 func run() int {
 	allowMissing := flag.Bool("allow_missing_branches", false, "allow missing branches.")
 	submodules := flag.Bool("submodules", true, "if set to false, do not recurse into submodules")
+	fetchSubmodules := flag.Bool("fetch_submodules", false, "clone submodules and fetch submodule commits which are missing from -repo_cache into it. Otherwise they are skipped.")
+	submoduleFetchDepth := flag.Int("submodule_fetch_depth", 1, "number of commits to fetch per submodule commit with -fetch_submodules")
+	submodulePointers := flag.Bool("submodule_pointers", false, "index each submodule which is not recursed into as a file holding its pinned commit")
+	symlinkTargets := flag.Bool("symlink_targets", false, "index each symlink as a file holding its target resolved against the repository root")
+	indexHistory := flag.Bool("index_history", false, "also index the commits of the indexed branches, searchable with commit:, author:, before: and after:")
+	historyLimit := flag.Int("history_limit", 10000, "maximum number of commits to index per branch with -index_history, 0 for all")
+	fileModTimes := flag.Bool("file_mod_times", false, "record the date of the last commit which changed each file, for display and recency ranking. Walks up to -history_limit commits per branch.")
 	branchesStr := flag.String("branches", "HEAD", "git branches to index.")
 	branchPrefix := flag.String("prefix", "refs/heads/", "prefix for branch names")
+	unshallow := flag.Bool("unshallow", false, "if the repository is a shallow clone, fetch branches missing from it before indexing")
+	unshallowDepth := flag.Int("unshallow_depth", 1, "number of commits to fetch per missing branch with -unshallow")
+	fetchMissingBlobs := flag.Bool("fetch_missing_blobs", false, "if the repository is a partial clone, eg. cloned with --filter=blob:none, fetch the blobs to index which are missing from it. Otherwise they are skipped.")
+	disableGitAttributes := flag.Bool("disable_gitattributes", false, "ignore .gitattributes. Otherwise files marked linguist-generated or linguist-vendored rank lower")
+	gitAttributesExportIgnore := flag.Bool("gitattributes_export_ignore", false, "skip files marked export-ignore in .gitattributes")
 
 	incremental := flag.Bool("incremental", true, "only index changed repositories")
 	repoCacheDir := flag.String("repo_cache", "", "directory holding bare git repos, named by URL. "+
@@ -44,7 +57,7 @@ func run() int {
 		"It also affects name if the indexed repository is under this directory.")
 	isDelta := flag.Bool("delta", false, "whether we should use delta build")
 	deltaShardNumberFallbackThreshold := flag.Uint64("delta_threshold", 0, "upper limit on the number of preexisting shards that can exist before attempting a delta build (0 to disable fallback behavior)")
-	languageMap := flag.String("language_map", "", "a mapping between a language and its ctags processor (a:0,b:3).")
+	languageMap := flag.String("language_map", "", "a mapping between a language and its ctags processor, one of no, universal, scip or tree-sitter (eg. go:scip,tsx:tree-sitter). tree-sitter needs a binary built with -tags treesitter.")
 
 	cpuProfile := flag.String("cpu_profile", "", "write cpu profile to `file`")
 
@@ -99,6 +112,9 @@ func run() int {
 		gitRepos[repoDir] = name
 	}
 
+	opts.DisableGitAttributes = *disableGitAttributes
+	opts.GitAttributesExportIgnore = *gitAttributesExportIgnore
+
 	opts.LanguageMap = make(ctags.LanguageMap)
 	for _, mapping := range strings.Split(*languageMap, ",") {
 		m := strings.Split(mapping, ":")
@@ -125,15 +141,25 @@ func run() int {
 			BranchPrefix:                      *branchPrefix,
 			Incremental:                       *incremental,
 			Submodules:                        *submodules,
+			FetchSubmodules:                   *fetchSubmodules,
+			SubmoduleFetchDepth:               *submoduleFetchDepth,
+			SubmodulePointers:                 *submodulePointers,
+			SymlinkTargets:                    *symlinkTargets,
+			IndexHistory:                      *indexHistory,
+			HistoryLimit:                      *historyLimit,
+			FileModTimes:                      *fileModTimes,
 			RepoCacheDir:                      *repoCacheDir,
 			AllowMissingBranch:                *allowMissing,
+			Unshallow:                         *unshallow,
+			UnshallowDepth:                    *unshallowDepth,
+			FetchMissingBlobs:                 *fetchMissingBlobs,
 			BuildOptions:                      *opts,
 			Branches:                          branches,
 			RepoDir:                           dir,
 			DeltaShardNumberFallbackThreshold: *deltaShardNumberFallbackThreshold,
 		}
 
-		if _, err := gitindex.IndexGitRepo(gitOpts); err != nil {
+		if _, err := gitindex.IndexGitRepo(context.Background(), gitOpts); err != nil {
 			log.Printf("indexGitRepo(%s, delta=%t): %v", dir, gitOpts.BuildOptions.IsDelta, err)
 			exitStatus = 1
 		}
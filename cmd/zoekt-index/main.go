@@ -16,11 +16,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"runtime/pprof"
 	"strings"
 
@@ -29,38 +31,11 @@ import (
 	"go.uber.org/automaxprocs/maxprocs"
 )
 
-type fileInfo struct {
-	name string
-	size int64
-}
-
-type fileAggregator struct {
-	ignoreDirs map[string]struct{}
-	sizeMax    int64
-	sink       chan fileInfo
-}
-
-func (a *fileAggregator) add(path string, info os.FileInfo, err error) error {
-	if err != nil {
-		return err
-	}
-
-	if info.IsDir() {
-		base := filepath.Base(path)
-		if _, ok := a.ignoreDirs[base]; ok {
-			return filepath.SkipDir
-		}
-	}
-
-	if info.Mode().IsRegular() {
-		a.sink <- fileInfo{path, info.Size()}
-	}
-	return nil
-}
-
 func main() {
 	cpuProfile := flag.String("cpu_profile", "", "write cpu profile to file")
 	ignoreDirs := flag.String("ignore_dirs", ".git,.hg,.svn", "comma separated list of directories to ignore.")
+	readWorkers := flag.Int("read_workers", 0, "number of goroutines reading files. Defaults to GOMAXPROCS.")
+	readBufferMB := flag.Int("read_buffer_mb", 256, "maximum size in megabytes of the file contents read ahead of indexing.")
 	flag.Parse()
 
 	if flag.NArg() == 0 {
@@ -95,15 +70,28 @@ func main() {
 			}
 		}
 	}
+	if *readWorkers <= 0 {
+		*readWorkers = runtime.GOMAXPROCS(0)
+	}
+	if *readBufferMB <= 0 {
+		log.Fatal("-read_buffer_mb must be positive")
+	}
+
 	for _, arg := range flag.Args() {
 		opts.RepositoryDescription.Source = arg
-		if err := indexArg(arg, *opts, ignoreDirMap); err != nil {
+		r := &fileReader{
+			ignoreDirs:  ignoreDirMap,
+			opts:        opts,
+			workers:     *readWorkers,
+			bufferBytes: int64(*readBufferMB) << 20,
+		}
+		if err := indexArg(arg, *opts, r); err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-func indexArg(arg string, opts index.Options, ignore map[string]struct{}) error {
+func indexArg(arg string, opts index.Options, r *fileReader) error {
 	dir, err := filepath.Abs(filepath.Clean(arg))
 	if err != nil {
 		return err
@@ -118,39 +106,8 @@ func indexArg(arg string, opts index.Options, ignore map[string]struct{}) error
 	// we returning the first call to builder.Finish.
 	defer builder.Finish() // nolint:errcheck
 
-	comm := make(chan fileInfo, 100)
-	agg := fileAggregator{
-		ignoreDirs: ignore,
-		sink:       comm,
-		sizeMax:    int64(opts.SizeMax),
-	}
-
-	go func() {
-		if err := filepath.Walk(dir, agg.add); err != nil {
-			log.Fatal(err)
-		}
-		close(comm)
-	}()
-
-	for f := range comm {
-		displayName := strings.TrimPrefix(f.name, dir+"/")
-		if f.size > int64(opts.SizeMax) && !opts.IgnoreSizeMax(displayName) {
-			if err := builder.Add(index.Document{
-				Name:       displayName,
-				SkipReason: fmt.Sprintf("document size %d larger than limit %d", f.size, opts.SizeMax),
-			}); err != nil {
-				return err
-			}
-			continue
-		}
-		content, err := os.ReadFile(f.name)
-		if err != nil {
-			return err
-		}
-
-		if err := builder.AddFile(displayName, content); err != nil {
-			return err
-		}
+	if err := r.read(context.Background(), dir, builder.Add); err != nil {
+		return err
 	}
 
 	return builder.Finish()
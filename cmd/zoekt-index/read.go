@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// fileReader walks a directory and reads its files with several goroutines,
+// so that reading overlaps with indexing. Documents are passed on in the
+// order of the walk, so the shards don't depend on the number of workers.
+type fileReader struct {
+	ignoreDirs map[string]struct{}
+	opts       *index.Options
+
+	// workers is the number of goroutines reading files.
+	workers int
+
+	// bufferBytes bounds the size of the contents which were read, but not
+	// yet passed on. A file larger than bufferBytes is read on its own.
+	bufferBytes int64
+}
+
+// readJob is a file which is read by a worker. done is closed once doc or
+// err is set.
+type readJob struct {
+	path   string
+	doc    index.Document
+	err    error
+	weight int64
+	done   chan struct{}
+
+	// limit, if non-zero, is the size of the prefix of a large file which
+	// is read, see index.Options.TruncateLargeFiles.
+	limit int
+}
+
+// read walks dir and calls add with the document of every file, in walk
+// order. It stops at the first error of the walk, of reading a file or of
+// add.
+func (r *fileReader) read(ctx context.Context, dir string, add func(index.Document) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	buffer := semaphore.NewWeighted(r.bufferBytes)
+
+	// ordered has the jobs in walk order, while jobs has the ones which wait
+	// for a worker.
+	ordered := make(chan *readJob, 4*r.workers)
+	jobs := make(chan *readJob, r.workers)
+
+	g.Go(func() error {
+		defer close(ordered)
+		defer close(jobs)
+		return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if _, ok := r.ignoreDirs[filepath.Base(path)]; ok {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			job := &readJob{
+				path: path,
+				doc:  index.Document{Name: strings.TrimPrefix(path, dir+"/")},
+				done: make(chan struct{}),
+			}
+			if !r.opts.IncludePath(job.doc.Name) {
+				return nil
+			}
+			read := info.Size() <= int64(r.opts.SizeMax) || r.opts.IgnoreSizeMax(job.doc.Name) || r.opts.ExpandArchive(job.doc.Name, int(info.Size()))
+			if !read && r.opts.TruncateSize() > 0 {
+				read, job.limit = true, r.opts.TruncateSize()
+			}
+			switch {
+			case r.opts.NameOnly(job.doc.Name):
+				// The builder only indexes the name.
+				read = false
+				close(job.done)
+			case read:
+				size := info.Size()
+				if job.limit > 0 {
+					size = int64(job.limit)
+				}
+				job.weight = min(size, r.bufferBytes)
+				if err := buffer.Acquire(ctx, job.weight); err != nil {
+					return err
+				}
+			default:
+				job.doc.SkipReason = fmt.Sprintf("document size %d larger than limit %d", info.Size(), r.opts.SizeMax)
+				close(job.done)
+			}
+
+			select {
+			case ordered <- job:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if read {
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	})
+
+	for i := 0; i < r.workers; i++ {
+		g.Go(func() error {
+			for job := range jobs {
+				if err := ctx.Err(); err != nil {
+					job.err = err
+				} else if job.limit > 0 {
+					job.doc.Content, job.err = readPrefix(job.path, job.limit)
+					job.doc.Content = index.TruncateContent(job.doc.Content, job.limit)
+					job.doc.Truncated = true
+				} else {
+					job.doc.Content, job.err = os.ReadFile(job.path)
+				}
+				close(job.done)
+			}
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		for job := range ordered {
+			select {
+			case <-job.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if job.err != nil {
+				return job.err
+			}
+			if err := add(job.doc); err != nil {
+				return err
+			}
+			buffer.Release(job.weight)
+		}
+		return nil
+	})
+
+	return g.Wait()
+}
+
+// readPrefix returns the first limit bytes of the file at path.
+func readPrefix(path string, limit int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, limit)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestFileReader(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".git/HEAD":   "ref: refs/heads/main\n",
+		"big.txt":     "0123456789abcdef",
+		"big.min.js":  "0123456789abcdef",
+		"lib/a.go":    "package lib\n",
+		"lib/b/b.go":  "package b\n",
+		"main.go":     "package main\n",
+		"zz/empty.go": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := &index.Options{SizeMax: 15, LargeFiles: []string{"*.min.js"}}
+	want := []index.Document{
+		{Name: "big.min.js", Content: []byte("0123456789abcdef")},
+		{Name: "big.txt", SkipReason: "document size 16 larger than limit 15"},
+		{Name: "lib/a.go", Content: []byte("package lib\n")},
+		{Name: "lib/b/b.go", Content: []byte("package b\n")},
+		{Name: "main.go", Content: []byte("package main\n")},
+		{Name: "zz/empty.go", Content: []byte{}},
+	}
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			// The buffer holds less than two files, so files are read
+			// ahead one at a time.
+			r := &fileReader{ignoreDirs: map[string]struct{}{".git": {}}, opts: opts, workers: workers, bufferBytes: 16}
+
+			var got []index.Document
+			err := r.read(context.Background(), dir, func(doc index.Document) error {
+				got = append(got, doc)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(want, got); d != "" {
+				t.Errorf("documents mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+
+	r := &fileReader{opts: opts, workers: 2, bufferBytes: 1}
+	errStop := errors.New("stop")
+	err := r.read(context.Background(), dir, func(doc index.Document) error {
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("got %v, want the error of add", err)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/gitindex"
+)
+
+// apiServer serves an HTTP API to manage the repositories of the
+// indexserver. All requests must carry the token as "Authorization: Bearer
+// <token>".
+//
+//	GET    /api/repos          lists the repositories and when they were last indexed.
+//	POST   /api/repos          adds the repository {"URL": "https://host/org/repo"}.
+//	POST   /api/reindex/{name} fetches and reindexes a repository.
+//	GET    /api/reindex/{name} returns the status of the last reindex of a repository.
+//	DELETE /api/repos/{name}   removes a repository, its clone and its shards.
+//
+// A reindex request for a repository which is still being fetched or waits
+// to be indexed returns the pending reindex instead of starting another one.
+//
+// Added and removed repositories are persisted in the mirror config as
+// entries with a GitURL. The next mirror run, which starts when the mirror
+// config changes, clones added repositories. Removing a repository which was
+// mirrored by another entry, eg. a GitHub organization, only lasts until the
+// next mirror run, unless it is excluded from that entry.
+type apiServer struct {
+	// repoDir is absolute, like the sources of the shards in indexDir.
+	repoDir      string
+	indexDir     string
+	configFile   string
+	token        []byte
+	pendingRepos chan<- string
+
+	// mu serializes changes to the mirror config and the repositories.
+	mu sync.Mutex
+
+	// jobsMu protects jobs, the last reindex of each repository by the
+	// directory of its clone.
+	jobsMu sync.Mutex
+	jobs   map[string]*reindexJob
+}
+
+// The states of a reindexJob.
+const (
+	reindexFetching = "fetching"
+	reindexQueued   = "queued"
+	reindexDone     = "done"
+	reindexFailed   = "failed"
+)
+
+type reindexJob struct {
+	Name     string
+	State    string
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+
+	// queued is when the job was queued for indexing. Only index runs which
+	// started after it finish the job.
+	queued time.Time
+}
+
+type apiRepo struct {
+	Name        string
+	URL         string     `json:",omitempty"`
+	LastIndexed *time.Time `json:",omitempty"`
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/repos", s.serveList)
+	mux.HandleFunc("POST /api/repos", s.serveAdd)
+	mux.HandleFunc("POST /api/reindex/{name...}", s.serveReindex)
+	mux.HandleFunc("GET /api/reindex/{name...}", s.serveReindexStatus)
+	mux.HandleFunc("DELETE /api/repos/{name...}", s.serveRemove)
+	return s.authenticate(mux)
+}
+
+func (s *apiServer) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="zoekt-indexserver"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) serveList(w http.ResponseWriter, r *http.Request) {
+	dirs, err := gitindex.FindGitRepos(s.repoDir)
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	shards, err := s.shards()
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+
+	repos := []apiRepo{}
+	for _, dir := range dirs {
+		repo := apiRepo{
+			Name: s.repoName(dir),
+			URL:  originURL(dir),
+		}
+		if sh, ok := shards[dir]; ok {
+			repo.LastIndexed = &sh.lastIndexed
+		}
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func (s *apiServer) serveAdd(w http.ResponseWriter, r *http.Request) {
+	var req struct{ URL string }
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	name, err := cloneName(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.cloneDir(name)); err == nil {
+		http.Error(w, fmt.Sprintf("repository %s already exists", name), http.StatusConflict)
+		return
+	}
+
+	cfg, err := readConfigFileEntries(s.configFile)
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	if slices.ContainsFunc(cfg, func(c configFileEntry) bool { return c.GitURL == req.URL }) {
+		http.Error(w, fmt.Sprintf("repository %s is already in the mirror config", req.URL), http.StatusConflict)
+		return
+	}
+
+	entry, err := newConfigFileEntry(ConfigEntry{GitURL: req.URL})
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	cfg = append(cfg, entry)
+	if err := writeConfigFile(s.configFile, cfg); err != nil {
+		s.serveError(w, err)
+		return
+	}
+	log.Printf("api: added %s to mirror config", req.URL)
+
+	writeJSON(w, http.StatusAccepted, apiRepo{Name: name, URL: req.URL})
+}
+
+func (s *apiServer) serveReindex(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	dir, ok := s.existingRepoDir(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown repository %s", name), http.StatusNotFound)
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	if j, ok := s.jobs[dir]; ok && (j.State == reindexFetching || j.State == reindexQueued) {
+		writeJSON(w, http.StatusAccepted, *j)
+		return
+	}
+
+	log.Printf("api: reindexing %s", name)
+	j := &reindexJob{Name: name, State: reindexFetching, Started: time.Now()}
+	if s.jobs == nil {
+		s.jobs = map[string]*reindexJob{}
+	}
+	s.jobs[dir] = j
+	go func() {
+		fetchGitRepo(dir)
+
+		s.jobsMu.Lock()
+		j.State = reindexQueued
+		j.queued = time.Now()
+		s.jobsMu.Unlock()
+
+		s.pendingRepos <- dir
+	}()
+
+	writeJSON(w, http.StatusAccepted, *j)
+}
+
+func (s *apiServer) serveReindexStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	dir, ok := s.existingRepoDir(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown repository %s", name), http.StatusNotFound)
+		return
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	j, ok := s.jobs[dir]
+	if !ok {
+		http.Error(w, fmt.Sprintf("repository %s was not reindexed", name), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, *j)
+}
+
+// indexed finishes the queued reindex of the repository cloned at dir, if the
+// index run which finished started after it was queued.
+func (s *apiServer) indexed(dir string, started time.Time, err error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	j, ok := s.jobs[dir]
+	if !ok || j.State != reindexQueued || started.Before(j.queued) {
+		return
+	}
+
+	now := time.Now()
+	j.Finished = &now
+	j.State = reindexDone
+	if err != nil {
+		j.State = reindexFailed
+		j.Error = err.Error()
+	}
+}
+
+func (s *apiServer) serveRemove(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, ok := s.existingRepoDir(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown repository %s", name), http.StatusNotFound)
+		return
+	}
+	u := originURL(dir)
+
+	// Remove the repository from the mirror config first, so the next mirror
+	// run does not clone it again.
+	cfg, err := readConfigFileEntries(s.configFile)
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	if keep := slices.DeleteFunc(slices.Clone(cfg), func(c configFileEntry) bool {
+		return c.GitURL != "" && (c.GitURL == u || clonesAs(c.GitURL, name))
+	}); len(keep) != len(cfg) {
+		if err := writeConfigFile(s.configFile, keep); err != nil {
+			s.serveError(w, err)
+			return
+		}
+	}
+
+	shards, err := s.shards()
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	var paths []string
+	if sh, ok := shards[dir]; ok {
+		paths = sh.paths
+	}
+	for _, fn := range paths {
+		fns, err := index.IndexFilePaths(fn)
+		if err != nil {
+			s.serveError(w, err)
+			return
+		}
+		for _, p := range fns {
+			if err := os.Remove(p); err != nil {
+				s.serveError(w, err)
+				return
+			}
+		}
+	}
+
+	// A concurrent index job may still write a shard for dir, which
+	// deleteOrphanIndexes removes once the clone is gone.
+	if err := os.RemoveAll(dir); err != nil {
+		s.serveError(w, err)
+		return
+	}
+	log.Printf("api: removed %s and %d shard(s)", name, len(paths))
+
+	writeJSON(w, http.StatusOK, apiRepo{Name: name, URL: u})
+}
+
+func (s *apiServer) serveError(w http.ResponseWriter, err error) {
+	log.Printf("api: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// cloneDir returns the directory of the clone of the repository with the
+// given name.
+func (s *apiServer) cloneDir(name string) string {
+	return filepath.Join(s.repoDir, name+".git")
+}
+
+// existingRepoDir returns the directory of the clone of the repository with
+// the given name, if it exists.
+func (s *apiServer) existingRepoDir(name string) (string, bool) {
+	if !filepath.IsLocal(name) {
+		return "", false
+	}
+	dir := s.cloneDir(name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	return dir, true
+}
+
+// repoName returns the name of the repository cloned at dir. Like
+// zoekt-git-index with -repo_cache, this is the path of the clone relative
+// to the repository directory.
+func (s *apiServer) repoName(dir string) string {
+	name, err := filepath.Rel(s.repoDir, dir)
+	if err != nil {
+		name = dir
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(name, "/.git"), ".git")
+}
+
+// clonesAs returns true if cloneURL is cloned as the repository with the
+// given name.
+func clonesAs(cloneURL, name string) bool {
+	n, err := cloneName(cloneURL)
+	return err == nil && n == name
+}
+
+type repoShards struct {
+	paths       []string
+	lastIndexed time.Time
+}
+
+// shards returns the shards in the index directory by the clone directory
+// of their repository.
+func (s *apiServer) shards() (map[string]*repoShards, error) {
+	fns, err := filepath.Glob(filepath.Join(s.indexDir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]*repoShards{}
+	for _, fn := range fns {
+		repos, md, err := index.ReadMetadataPath(fn)
+		if err != nil {
+			log.Printf("api: ReadMetadataPath(%s): %v", fn, err)
+			continue
+		}
+
+		// TODO support compound shards in zoekt-indexserver
+		if len(repos) != 1 {
+			continue
+		}
+
+		sh, ok := res[repos[0].Source]
+		if !ok {
+			sh = &repoShards{}
+			res[repos[0].Source] = sh
+		}
+		sh.paths = append(sh.paths, fn)
+		if md.IndexTime.After(sh.lastIndexed) {
+			sh.lastIndexed = md.IndexTime
+		}
+	}
+	return res, nil
+}
+
+// cloneName returns the name under which zoekt-git-clone clones u.
+func cloneName(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("URL %q has no host", u)
+	}
+
+	name := strings.TrimSuffix(filepath.Join(parsed.Host, parsed.Path), ".git")
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("URL %q has an invalid path", u)
+	}
+	return name, nil
+}
+
+// originURL returns the URL of the origin remote of the repository at dir,
+// or "" if it has none.
+func originURL(dir string) string {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return ""
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestAPI(t *testing.T) {
+	repoDir := t.TempDir()
+	indexDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.json")
+
+	if err := os.WriteFile(configFile, []byte(`[{"GithubOrg": "apache", "FutureOption": {"Enabled": true}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A clone with a shard, and one which was not indexed yet.
+	indexed := filepath.Join(repoDir, "example.com/org/indexed.git")
+	for _, dir := range []string{indexed, filepath.Join(repoDir, "example.com/org/new.git")} {
+		cmd := exec.Command("/bin/sh", "-euxc", `git init --bare "$1" && git --git-dir "$1" remote add origin "https://$2"`,
+			"sh", dir, strings.TrimSuffix(strings.TrimPrefix(dir, repoDir+"/"), ".git"))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+	}
+	b, err := index.NewBuilder(index.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name:   "example.com/org/indexed",
+			Source: indexed,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("README", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	pendingRepos := make(chan string, 1)
+	s := &apiServer{
+		repoDir:      repoDir,
+		indexDir:     indexDir,
+		configFile:   configFile,
+		token:        []byte("secret"),
+		pendingRepos: pendingRepos,
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	do := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	list := func() []apiRepo {
+		t.Helper()
+		code, body := do("secret", "GET", "/api/repos", "")
+		if code != http.StatusOK {
+			t.Fatalf("list: got %d: %s", code, body)
+		}
+		var repos []apiRepo
+		if err := json.Unmarshal([]byte(body), &repos); err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}
+
+	config := func() []ConfigEntry {
+		t.Helper()
+		cfg, err := readConfigURL(configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			if code, _ := do(token, "GET", "/api/repos", ""); code != http.StatusUnauthorized {
+				t.Errorf("token %q: got %d, want %d", token, code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		repos := list()
+		if len(repos) != 2 || repos[0].LastIndexed == nil || repos[1].LastIndexed != nil {
+			t.Fatalf("got %+v, want indexed and new repo", repos)
+		}
+		if since := time.Since(*repos[0].LastIndexed); since < 0 || since > time.Hour {
+			t.Errorf("got last indexed %v", repos[0].LastIndexed)
+		}
+		repos[0].LastIndexed = nil
+		want := []apiRepo{
+			{Name: "example.com/org/indexed", URL: "https://example.com/org/indexed"},
+			{Name: "example.com/org/new", URL: "https://example.com/org/new"},
+		}
+		if d := cmp.Diff(want, repos); d != "" {
+			t.Errorf("mismatch (-want +got):\n%s", d)
+		}
+	})
+
+	t.Run("add", func(t *testing.T) {
+		for _, tc := range []struct {
+			body string
+			want int
+		}{
+			{`{"URL": "https://github.com/sourcegraph/zoekt.git"}`, http.StatusAccepted},
+			{`{"URL": "https://github.com/sourcegraph/zoekt.git"}`, http.StatusConflict},
+			{`{"URL": "https://example.com/org/new"}`, http.StatusConflict},
+			{`{"URL": "/no/host"}`, http.StatusBadRequest},
+			{`{"Repo": "github.com/sourcegraph/zoekt"}`, http.StatusBadRequest},
+		} {
+			if code, body := do("secret", "POST", "/api/repos", tc.body); code != tc.want {
+				t.Errorf("%s: got %d, want %d: %s", tc.body, code, tc.want, body)
+			}
+		}
+
+		want := []ConfigEntry{
+			{GithubOrg: "apache"},
+			{GitURL: "https://github.com/sourcegraph/zoekt.git"},
+		}
+		if d := cmp.Diff(want, config()); d != "" {
+			t.Errorf("config mismatch (-want +got):\n%s", d)
+		}
+
+		// Fields unknown to ConfigEntry survive rewriting the config.
+		if b, err := os.ReadFile(configFile); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(string(b), `"FutureOption"`) {
+			t.Errorf("config lost unknown fields:\n%s", b)
+		}
+	})
+
+	t.Run("reindex", func(t *testing.T) {
+		if code, body := do("secret", "POST", "/api/reindex/example.com/org/unknown", ""); code != http.StatusNotFound {
+			t.Errorf("got %d, want %d: %s", code, http.StatusNotFound, body)
+		}
+		if code, body := do("secret", "GET", "/api/reindex/example.com/org/new", ""); code != http.StatusNotFound {
+			t.Errorf("got %d, want %d: %s", code, http.StatusNotFound, body)
+		}
+
+		reindex := func() reindexJob {
+			t.Helper()
+			code, body := do("secret", "POST", "/api/reindex/example.com/org/new", "")
+			if code != http.StatusAccepted {
+				t.Fatalf("got %d, want %d: %s", code, http.StatusAccepted, body)
+			}
+			var j reindexJob
+			if err := json.Unmarshal([]byte(body), &j); err != nil {
+				t.Fatal(err)
+			}
+			return j
+		}
+		status := func() reindexJob {
+			t.Helper()
+			code, body := do("secret", "GET", "/api/reindex/example.com/org/new", "")
+			if code != http.StatusOK {
+				t.Fatalf("got %d, want %d: %s", code, http.StatusOK, body)
+			}
+			var j reindexJob
+			if err := json.Unmarshal([]byte(body), &j); err != nil {
+				t.Fatal(err)
+			}
+			return j
+		}
+
+		dir := filepath.Join(repoDir, "example.com/org/new.git")
+		first := reindex()
+		if got := <-pendingRepos; got != dir {
+			t.Errorf("got pending repo %s", got)
+		}
+
+		// A second request while the first waits to be indexed joins it.
+		if j := reindex(); !j.Started.Equal(first.Started) || j.State != reindexQueued {
+			t.Errorf("got job %+v, want queued job %+v", j, first)
+		}
+		select {
+		case got := <-pendingRepos:
+			t.Fatalf("got second pending repo %s", got)
+		default:
+		}
+
+		// An index run which started before the job was queued doesn't
+		// finish it.
+		s.indexed(dir, first.Started, nil)
+		if j := status(); j.State != reindexQueued {
+			t.Errorf("got state %s, want %s", j.State, reindexQueued)
+		}
+
+		s.indexed(dir, time.Now(), nil)
+		if j := status(); j.State != reindexDone || j.Finished == nil {
+			t.Errorf("got job %+v, want it done", j)
+		}
+
+		if j := reindex(); j.Started.Equal(first.Started) {
+			t.Errorf("got finished job %+v, want a new one", j)
+		}
+		<-pendingRepos
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if code, body := do("secret", "DELETE", "/api/repos/example.com/org/indexed", ""); code != http.StatusOK {
+			t.Fatalf("got %d, want %d: %s", code, http.StatusOK, body)
+		}
+		if code, body := do("secret", "DELETE", "/api/repos/../outside", ""); code != http.StatusNotFound {
+			t.Errorf("got %d, want %d: %s", code, http.StatusNotFound, body)
+		}
+
+		if _, err := os.Stat(indexed); !os.IsNotExist(err) {
+			t.Errorf("clone was not removed: %v", err)
+		}
+		if shards, _ := filepath.Glob(filepath.Join(indexDir, "*")); len(shards) != 0 {
+			t.Errorf("shards were not removed: %v", shards)
+		}
+		if repos := list(); len(repos) != 1 || repos[0].Name != "example.com/org/new" {
+			t.Errorf("got %+v, want only the new repo", repos)
+		}
+	})
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/objstore"
+)
+
+// Backups copy the shards of the index directory to a bucket, see
+// objstore.Open. A backup consists of a manifest, which lists the shards and
+// their .meta sidecars with their SHA-256, and one object per distinct file
+// content named after its hash. Backups are incremental: content which an
+// earlier backup stored is not uploaded again.
+const (
+	backupManifestPrefix = "manifest-"
+	backupBlobPrefix     = "blob-"
+)
+
+// lastBackup is the time of the last successful backup, in Unix nanoseconds.
+var lastBackup atomic.Int64
+
+var (
+	metricBackupAge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "index_backup_last_success_age_seconds",
+		Help: "Seconds since the last successful backup of the index directory. Infinite if there is none.",
+	}, func() float64 {
+		last := lastBackup.Load()
+		if last == 0 {
+			return math.Inf(1)
+		}
+		return time.Since(time.Unix(0, last)).Seconds()
+	})
+	metricBackupFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "index_backup_failures_total",
+		Help: "The number of backups of the index directory which failed.",
+	})
+	metricBackupUploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "index_backup_uploaded_bytes_total",
+		Help: "The number of bytes uploaded by backups of the index directory.",
+	})
+)
+
+// backupManifest lists the files of a backup.
+type backupManifest struct {
+	Time  time.Time
+	Files []backupFile
+}
+
+type backupFile struct {
+	// Name is the name of the file in the index directory.
+	Name    string
+	Size    int64
+	ModTime time.Time
+
+	// SHA256 is the hex encoded hash of the content, which is stored in
+	// the object backupBlobPrefix + SHA256.
+	SHA256 string
+}
+
+func manifestKey(t time.Time) string {
+	// The fixed width format sorts like the times.
+	return backupManifestPrefix + t.UTC().Format("20060102T150405.000000000Z") + ".json"
+}
+
+// periodicBackup backs up indexDir to bucket every interval and keeps the
+// newest retention backups.
+func periodicBackup(bucket objstore.Bucket, indexDir string, interval time.Duration, retention int) {
+	// Pick up where the previous process left off, so that the age metric
+	// survives restarts.
+	if m, _, err := latestBackup(context.Background(), bucket); err != nil {
+		log.Printf("backup: reading the latest backup of %s: %v", bucket, err)
+	} else if m != nil {
+		lastBackup.Store(m.Time.UnixNano())
+	}
+
+	t := time.NewTicker(interval)
+	for {
+		<-t.C
+		start := time.Now()
+		m, err := backup(context.Background(), bucket, indexDir, retention)
+		if err != nil {
+			metricBackupFailures.Inc()
+			log.Printf("backup of %s to %s failed: %v", indexDir, bucket, err)
+			continue
+		}
+		lastBackup.Store(m.Time.UnixNano())
+		log.Printf("backed up %d files of %s to %s in %s", len(m.Files), indexDir, bucket, time.Since(start))
+	}
+}
+
+// backup backs up the shards of indexDir to bucket. It uploads the content
+// which no earlier backup stored, verifies it, writes the manifest and then
+// removes the backups beyond the newest retention ones.
+func backup(ctx context.Context, bucket objstore.Bucket, indexDir string, retention int) (*backupManifest, error) {
+	objects, err := bucket.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, o := range objects {
+		stored[o.Key] = true
+	}
+
+	// Files which did not change since the latest backup keep their hash,
+	// so we don't read all shards every time.
+	latest, _, err := latestBackup(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]backupFile{}
+	if latest != nil {
+		for _, f := range latest.Files {
+			known[f.Name] = f
+		}
+	}
+
+	shards, err := filepath.Glob(filepath.Join(indexDir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+	m := &backupManifest{Time: time.Now()}
+	var uploaded []backupFile
+	for _, shard := range shards {
+		paths, err := index.IndexFilePaths(shard)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			f, upload, err := backupOne(ctx, bucket, p, known, stored)
+			if errors.Is(err, os.ErrNotExist) {
+				// The shard was deleted since we listed it.
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+			m.Files = append(m.Files, f)
+			stored[backupBlobPrefix+f.SHA256] = true
+			if upload {
+				uploaded = append(uploaded, f)
+			}
+		}
+	}
+
+	// Content which earlier backups stored was verified by them.
+	for _, f := range uploaded {
+		if err := verifyBackupFile(ctx, bucket, f); err != nil {
+			return nil, fmt.Errorf("verifying %s: %w", f.Name, err)
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.Put(ctx, manifestKey(m.Time), bytes.NewReader(b), int64(len(b))); err != nil {
+		return nil, err
+	}
+
+	if err := pruneBackups(ctx, bucket, retention); err != nil {
+		return nil, fmt.Errorf("pruning backups: %w", err)
+	}
+	return m, nil
+}
+
+// backupOne uploads the content of the file at path unless it is stored
+// already. It reports whether it uploaded the content.
+func backupOne(ctx context.Context, bucket objstore.Bucket, path string, known map[string]backupFile, stored map[string]bool) (backupFile, bool, error) {
+	// Shards are replaced by renaming, so the content we read from f is
+	// consistent even if the shard is replaced meanwhile.
+	f, err := os.Open(path)
+	if err != nil {
+		return backupFile{}, false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return backupFile{}, false, err
+	}
+
+	bf := backupFile{Name: filepath.Base(path), Size: fi.Size(), ModTime: fi.ModTime()}
+	if k, ok := known[bf.Name]; ok && k.Size == bf.Size && k.ModTime.Equal(bf.ModTime) && stored[backupBlobPrefix+k.SHA256] {
+		bf.SHA256 = k.SHA256
+		return bf, false, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return backupFile{}, false, err
+	}
+	bf.SHA256 = hex.EncodeToString(h.Sum(nil))
+	if stored[backupBlobPrefix+bf.SHA256] {
+		return bf, false, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return backupFile{}, false, err
+	}
+	if err := bucket.Put(ctx, backupBlobPrefix+bf.SHA256, f, bf.Size); err != nil {
+		return backupFile{}, false, err
+	}
+	metricBackupUploadedBytes.Add(float64(bf.Size))
+	return bf, true, nil
+}
+
+// verifyBackupFile restores f to a temporary directory and checks that its
+// content has the hash of the manifest and, for shards, that it opens.
+func verifyBackupFile(ctx context.Context, bucket objstore.Bucket, f backupFile) error {
+	dir, err := os.MkdirTemp("", "zoekt-backup-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, f.Name)
+	if err := restoreBackupFile(ctx, bucket, f, path); err != nil {
+		return err
+	}
+	if filepath.Ext(path) != ".zoekt" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ifile, err := index.NewIndexFile(file)
+	if err != nil {
+		return err
+	}
+	s, err := index.NewSearcher(ifile)
+	if err != nil {
+		ifile.Close()
+		return err
+	}
+	s.Close()
+	return nil
+}
+
+// restoreBackupFile downloads the content of f to path and checks its hash.
+func restoreBackupFile(ctx context.Context, bucket objstore.Bucket, f backupFile, path string) error {
+	r, err := bucket.Get(ctx, backupBlobPrefix+f.SHA256)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); n != f.Size || got != f.SHA256 {
+		return fmt.Errorf("restored %d bytes with SHA-256 %s, want %d bytes with %s", n, got, f.Size, f.SHA256)
+	}
+	return nil
+}
+
+// manifestKeys returns the keys of the manifests in objects, oldest first.
+func manifestKeys(objects []objstore.Object) []string {
+	var keys []string
+	for _, o := range objects {
+		if strings.HasPrefix(o.Key, backupManifestPrefix) {
+			keys = append(keys, o.Key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func readManifest(ctx context.Context, bucket objstore.Bucket, key string) (*backupManifest, error) {
+	r, err := bucket.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var m backupManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return &m, nil
+}
+
+// latestBackup returns the manifest of the newest backup in bucket and its
+// key, or nil if there is none.
+func latestBackup(ctx context.Context, bucket objstore.Bucket) (*backupManifest, string, error) {
+	objects, err := bucket.List(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	keys := manifestKeys(objects)
+	if len(keys) == 0 {
+		return nil, "", nil
+	}
+	key := keys[len(keys)-1]
+	m, err := readManifest(ctx, bucket, key)
+	return m, key, err
+}
+
+// pruneBackups removes the manifests beyond the newest retention ones, and
+// then the content no remaining manifest refers to.
+func pruneBackups(ctx context.Context, bucket objstore.Bucket, retention int) error {
+	objects, err := bucket.List(ctx)
+	if err != nil {
+		return err
+	}
+	keys := manifestKeys(objects)
+	if len(keys) > retention {
+		for _, key := range keys[:len(keys)-retention] {
+			if err := bucket.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		keys = keys[len(keys)-retention:]
+	}
+
+	referenced := map[string]bool{}
+	for _, key := range keys {
+		m, err := readManifest(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+		for _, f := range m.Files {
+			referenced[backupBlobPrefix+f.SHA256] = true
+		}
+	}
+	for _, o := range objects {
+		if strings.HasPrefix(o.Key, backupBlobPrefix) && !referenced[o.Key] {
+			if err := bucket.Delete(ctx, o.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/objstore"
+)
+
+// countingBucket counts the objects put into the bucket, and corrupts the
+// content it returns if corrupt is set.
+type countingBucket struct {
+	objstore.Bucket
+	puts    int
+	corrupt bool
+}
+
+func (b *countingBucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	b.puts++
+	return b.Bucket.Put(ctx, key, r, size)
+}
+
+func (b *countingBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := b.Bucket.Get(ctx, key)
+	if err != nil || !b.corrupt {
+		return r, err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	content[len(content)/2] ^= 0xff
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func buildShard(t *testing.T, indexDir, name, content string) {
+	t.Helper()
+	b, err := index.NewBuilder(index.Options{
+		IndexDir:              indexDir,
+		RepositoryDescription: zoekt.Repository{Name: name},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("README", []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	indexDir := t.TempDir()
+	bucketDir := t.TempDir()
+	dir, err := objstore.Open("file://" + bucketDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := &countingBucket{Bucket: dir}
+
+	keys := func() (manifests, blobs int) {
+		t.Helper()
+		objects, err := bucket.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objects {
+			switch {
+			case strings.HasPrefix(o.Key, backupManifestPrefix):
+				manifests++
+			case strings.HasPrefix(o.Key, backupBlobPrefix):
+				blobs++
+			}
+		}
+		return manifests, blobs
+	}
+
+	buildShard(t, indexDir, "repo-a", "hello")
+	buildShard(t, indexDir, "repo-b", "world")
+	shards, err := filepath.Glob(filepath.Join(indexDir, "*.zoekt"))
+	if err != nil || len(shards) != 2 {
+		t.Fatalf("got shards %v, %v, want 2", shards, err)
+	}
+	if err := os.WriteFile(shards[0]+".meta", []byte(`{"Name":"repo-a"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := backup(ctx, bucket, indexDir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 3 || bucket.puts != 4 {
+		t.Fatalf("got %d files in the manifest and %d objects put, want 3 files and 3 blobs and a manifest", len(m.Files), bucket.puts)
+	}
+
+	// Nothing changed, so only the manifest is put.
+	bucket.puts = 0
+	if _, err := backup(ctx, bucket, indexDir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.puts != 1 {
+		t.Errorf("got %d objects put for an unchanged index, want only the manifest", bucket.puts)
+	}
+
+	// The content of repo-b is only referenced by the two older backups, so
+	// it is removed along with the oldest one.
+	if err := os.Remove(shards[1]); err != nil {
+		t.Fatal(err)
+	}
+	buildShard(t, indexDir, "repo-c", "again")
+	bucket.puts = 0
+	latest, err := backup(ctx, bucket, indexDir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket.puts != 2 {
+		t.Errorf("got %d objects put, want the new shard and the manifest", bucket.puts)
+	}
+	if manifests, blobs := keys(); manifests != 2 || blobs != 4 {
+		t.Errorf("got %d manifests and %d blobs, want 2 and 4", manifests, blobs)
+	}
+	bucket.puts = 0
+	if _, err := backup(ctx, bucket, indexDir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if manifests, blobs := keys(); manifests != 2 || blobs != 3 {
+		t.Errorf("got %d manifests and %d blobs after pruning, want 2 and 3", manifests, blobs)
+	}
+
+	// Every file of the latest backup restores.
+	restoreDir := t.TempDir()
+	for _, f := range latest.Files {
+		if err := restoreBackupFile(ctx, bucket, f, filepath.Join(restoreDir, f.Name)); err != nil {
+			t.Errorf("restoring %s: %v", f.Name, err)
+		}
+	}
+
+	// A backup whose content doesn't restore fails before its manifest is
+	// written.
+	buildShard(t, indexDir, "repo-d", "corrupt")
+	bucket.corrupt = true
+	if _, err := backup(ctx, bucket, indexDir, 2); err == nil {
+		t.Fatal("expected verification to fail")
+	}
+	bucket.corrupt = false
+	m, key, err := latestBackup(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == "" || len(m.Files) != 3 {
+		t.Errorf("got latest backup %s with %d files, want the one before the failure", key, len(m.Files))
+	}
+}
@@ -31,28 +31,38 @@ import (
 )
 
 type ConfigEntry struct {
-	GithubUser             string
-	GithubOrg              string
-	BitBucketServerProject string
-	GitHubURL              string
-	GitilesURL             string
-	CGitURL                string
-	BitBucketServerURL     string
-	DisableTLS             bool
-	CredentialPath         string
-	ProjectType            string
-	Name                   string
-	Exclude                string
-	GitLabURL              string
-	OnlyPublic             bool
-	GerritApiURL           string
-	Topics                 []string
-	ExcludeTopics          []string
-	Active                 bool
-	NoArchived             bool
-	GerritFetchMetaConfig  bool
-	GerritRepoNameFormat   string
-	ExcludeUserRepos       bool
+	GithubUser             string   `json:",omitempty"`
+	GithubOrg              string   `json:",omitempty"`
+	BitBucketServerProject string   `json:",omitempty"`
+	GitHubURL              string   `json:",omitempty"`
+	GitilesURL             string   `json:",omitempty"`
+	CGitURL                string   `json:",omitempty"`
+	BitBucketServerURL     string   `json:",omitempty"`
+	DisableTLS             bool     `json:",omitempty"`
+	CredentialPath         string   `json:",omitempty"`
+	ProjectType            string   `json:",omitempty"`
+	Name                   string   `json:",omitempty"`
+	Exclude                string   `json:",omitempty"`
+	GitLabURL              string   `json:",omitempty"`
+	OnlyPublic             bool     `json:",omitempty"`
+	GerritApiURL           string   `json:",omitempty"`
+	Topics                 []string `json:",omitempty"`
+	ExcludeTopics          []string `json:",omitempty"`
+	Active                 bool     `json:",omitempty"`
+	NoArchived             bool     `json:",omitempty"`
+	GerritFetchMetaConfig  bool     `json:",omitempty"`
+	GerritRepoNameFormat   string   `json:",omitempty"`
+	ExcludeUserRepos       bool     `json:",omitempty"`
+
+	// GitHubAppID and GitHubAppInstallationID make zoekt-mirror-github
+	// authenticate as an installation of a GitHub App. CredentialPath then
+	// holds the private key of the app.
+	GitHubAppID             string `json:",omitempty"`
+	GitHubAppInstallationID string `json:",omitempty"`
+
+	// GitURL is the clone URL of a single repository. The indexserver API
+	// adds entries of this kind.
+	GitURL string `json:",omitempty"`
 }
 
 func randomize(entries []ConfigEntry) []ConfigEntry {
@@ -98,6 +108,73 @@ func readConfigURL(u string) ([]ConfigEntry, error) {
 	return result, nil
 }
 
+// configFileEntry is an entry of a local mirror config. The API rewrites the
+// config from the raw entries, so that it keeps fields which ConfigEntry
+// doesn't know, eg. those of a newer version.
+type configFileEntry struct {
+	ConfigEntry
+	raw json.RawMessage
+}
+
+// readConfigFileEntries reads the local mirror config at path.
+func readConfigFileEntries(path string) ([]configFileEntry, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	entries := make([]configFileEntry, 0, len(raw))
+	for _, r := range raw {
+		e := configFileEntry{raw: r}
+		if err := json.Unmarshal(r, &e.ConfigEntry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// newConfigFileEntry returns the config file entry of c.
+func newConfigFileEntry(c ConfigEntry) (configFileEntry, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return configFileEntry{}, err
+	}
+	return configFileEntry{ConfigEntry: c, raw: raw}, nil
+}
+
+// writeConfigFile atomically replaces the mirror config at path with cfg.
+func writeConfigFile(path string, cfg []configFileEntry) error {
+	raw := make([]json.RawMessage, 0, len(cfg))
+	for _, e := range cfg {
+		raw = append(raw, e.raw)
+	}
+	body, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(body, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 func watchFile(path string) (<-chan struct{}, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -167,7 +244,7 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 	cfg = randomize(cfg)
 	for _, c := range cfg {
 		var cmd *exec.Cmd
-		if c.GitHubURL != "" || c.GithubUser != "" || c.GithubOrg != "" {
+		if c.GitHubURL != "" || c.GithubUser != "" || c.GithubOrg != "" || c.GitHubAppID != "" {
 			cmd = exec.Command("zoekt-mirror-github",
 				"-dest", repoDir, "-delete")
 			if c.GitHubURL != "" {
@@ -184,7 +261,12 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 			if c.Exclude != "" {
 				cmd.Args = append(cmd.Args, "-exclude", c.Exclude)
 			}
-			if c.CredentialPath != "" {
+			if c.GitHubAppID != "" {
+				cmd.Args = append(cmd.Args,
+					"-app_id", c.GitHubAppID,
+					"-app_installation_id", c.GitHubAppInstallationID,
+					"-app_private_key", c.CredentialPath)
+			} else if c.CredentialPath != "" {
 				cmd.Args = append(cmd.Args, "-token", c.CredentialPath)
 			}
 			for _, topic := range c.Topics {
@@ -275,12 +357,15 @@ func executeMirror(cfg []ConfigEntry, repoDir string, pendingRepos chan<- string
 				cmd.Args = append(cmd.Args, "-repo-name-format", c.GerritRepoNameFormat)
 			}
 			cmd.Args = append(cmd.Args, c.GerritApiURL)
+		} else if c.GitURL != "" {
+			cmd = exec.Command("zoekt-git-clone",
+				"-dest", repoDir, c.GitURL)
 		} else {
 			log.Printf("executeMirror: ignoring config, because it does not contain any valid repository definition: %v", c)
 			continue
 		}
 
-		stdout, _ := loggedRun(cmd)
+		stdout, _, _ := loggedRun(cmd)
 
 		for _, fn := range bytes.Split(stdout, []byte{'\n'}) {
 			if len(fn) == 0 {
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -30,25 +31,28 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"github.com/sourcegraph/zoekt/index"
 	"github.com/sourcegraph/zoekt/internal/gitindex"
+	"github.com/sourcegraph/zoekt/internal/objstore"
 )
 
 const day = time.Hour * 24
 
-func loggedRun(cmd *exec.Cmd) (out, err []byte) {
+func loggedRun(cmd *exec.Cmd) (out, errOut []byte, err error) {
 	outBuf := &bytes.Buffer{}
 	errBuf := &bytes.Buffer{}
 	cmd.Stdout = outBuf
 	cmd.Stderr = errBuf
 
 	log.Printf("run %v", cmd.Args)
-	if err := cmd.Run(); err != nil {
+	if err = cmd.Run(); err != nil {
 		log.Printf("command %s failed: %v\nOUT: %s\nERR: %s",
 			cmd.Args, err, outBuf.String(), errBuf.String())
 	}
 
-	return outBuf.Bytes(), errBuf.Bytes()
+	return outBuf.Bytes(), errBuf.Bytes(), err
 }
 
 type Options struct {
@@ -61,6 +65,14 @@ type Options struct {
 	mirrorConfigFile string
 	maxLogAge        time.Duration
 	indexTimeout     time.Duration
+	apiListen        string
+	apiTokenFile     string
+	apiSSLCert       string
+	apiSSLKey        string
+	backupURL        string
+	backupInterval   time.Duration
+	backupRetention  int
+	metricsListen    string
 }
 
 func (o *Options) validate() {
@@ -75,6 +87,9 @@ func (o *Options) validate() {
 	if o.indexFlagsStr != "" {
 		o.indexFlags = strings.Split(o.indexFlagsStr, " ")
 	}
+	if o.backupURL != "" && (o.backupInterval <= 0 || o.backupRetention < 1) {
+		log.Fatal("-backup_interval must be positive and -backup_retention at least 1")
+	}
 }
 
 func (o *Options) defineFlags() {
@@ -88,6 +103,14 @@ func (o *Options) defineFlags() {
 	flag.Float64Var(&o.cpuFraction, "cpu_fraction", 0.25,
 		"use this fraction of the cores for indexing.")
 	flag.StringVar(&o.indexFlagsStr, "git_index_flags", "", "space separated list of flags passed through to zoekt-git-index (e.g. -git_index_flags='-symbols=false -submodules=false'")
+	flag.StringVar(&o.apiListen, "api_listen", "", "serve the API to manage repositories on this address. Disabled if empty.")
+	flag.StringVar(&o.apiTokenFile, "api_token_file", "", "file holding the bearer token which API requests must carry. Required with -api_listen.")
+	flag.StringVar(&o.apiSSLCert, "api_ssl_cert", "", "set path to SSL .pem holding the certificate of the API.")
+	flag.StringVar(&o.apiSSLKey, "api_ssl_key", "", "set path to SSL .pem holding the key of the API.")
+	flag.StringVar(&o.backupURL, "backup_url", "", "back up the index shards to this bucket, one of s3://BUCKET/PREFIX, gs://BUCKET/PREFIX or file:///DIR. Disabled if empty. See objstore.Open for credentials.")
+	flag.DurationVar(&o.backupInterval, "backup_interval", day, "back up the index shards this often.")
+	flag.IntVar(&o.backupRetention, "backup_retention", 7, "keep this many backups.")
+	flag.StringVar(&o.metricsListen, "metrics_listen", "", "serve Prometheus metrics at /metrics on this address. Disabled if empty.")
 }
 
 // periodicFetch runs git-fetch every once in a while. Results are
@@ -139,10 +162,15 @@ func fetchGitRepo(dir string) bool {
 }
 
 // indexPendingRepos consumes the directories on the repos channel and
-// indexes them, sequentially.
-func indexPendingRepos(indexDir, repoDir string, opts *Options, repos <-chan string) {
+// indexes them, sequentially. If indexed is non-nil, it is called after each
+// index run with the time the run started.
+func indexPendingRepos(indexDir, repoDir string, opts *Options, repos <-chan string, indexed func(dir string, started time.Time, err error)) {
 	for dir := range repos {
-		indexPendingRepo(dir, indexDir, repoDir, opts)
+		started := time.Now()
+		err := indexPendingRepo(dir, indexDir, repoDir, opts)
+		if indexed != nil {
+			indexed(dir, started, err)
+		}
 
 		// Failures (eg. timeout) will leave temp files
 		// around. We have to clean them, or they will fill up the indexing volume.
@@ -156,7 +184,7 @@ func indexPendingRepos(indexDir, repoDir string, opts *Options, repos <-chan str
 	}
 }
 
-func indexPendingRepo(dir, indexDir, repoDir string, opts *Options) {
+func indexPendingRepo(dir, indexDir, repoDir string, opts *Options) error {
 	ctx, cancel := context.WithTimeout(context.Background(), opts.indexTimeout)
 	defer cancel()
 	args := []string{
@@ -169,7 +197,8 @@ func indexPendingRepo(dir, indexDir, repoDir string, opts *Options) {
 	args = append(args, opts.indexFlags...)
 	args = append(args, dir)
 	cmd := exec.CommandContext(ctx, "zoekt-git-index", args...)
-	loggedRun(cmd)
+	_, _, err := loggedRun(cmd)
+	return err
 }
 
 // deleteLogs deletes old logs.
@@ -248,6 +277,50 @@ func deleteOrphanIndexes(indexDir, repoDir string, watchInterval time.Duration)
 	}
 }
 
+func newAPIServer(opts *Options, repoDir, indexDir string, pendingRepos chan<- string) *apiServer {
+	if opts.apiTokenFile == "" {
+		log.Fatal("must set -api_token_file with -api_listen")
+	}
+	if isHTTP(opts.mirrorConfigFile) {
+		log.Fatal("-api_listen requires a local -mirror_config file")
+	}
+	token, err := os.ReadFile(opts.apiTokenFile)
+	if err != nil {
+		log.Fatalf("ReadFile(%s): %v", opts.apiTokenFile, err)
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		log.Fatalf("API token file %s is empty", opts.apiTokenFile)
+	}
+	repoDir, err = filepath.Abs(repoDir)
+	if err != nil {
+		log.Fatalf("Abs(%s): %v", repoDir, err)
+	}
+
+	return &apiServer{
+		repoDir:      repoDir,
+		indexDir:     indexDir,
+		configFile:   opts.mirrorConfigFile,
+		token:        token,
+		pendingRepos: pendingRepos,
+	}
+}
+
+func serveAPI(opts *Options, s *apiServer) {
+	log.Printf("serving API on %s", opts.apiListen)
+	if opts.apiSSLCert != "" || opts.apiSSLKey != "" {
+		log.Fatal(http.ListenAndServeTLS(opts.apiListen, opts.apiSSLCert, opts.apiSSLKey, s.handler()))
+	}
+	log.Fatal(http.ListenAndServe(opts.apiListen, s.handler()))
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("serving metrics on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
 func main() {
 	var opts Options
 	opts.defineFlags()
@@ -263,8 +336,8 @@ func main() {
 
 	// Automatically prepend our own path at the front, to minimize
 	// required configuration.
-	if l, err := os.Readlink("/proc/self/exe"); err == nil {
-		os.Setenv("PATH", filepath.Dir(l)+":"+os.Getenv("PATH"))
+	if l, err := os.Executable(); err == nil {
+		os.Setenv("PATH", filepath.Dir(l)+string(filepath.ListSeparator)+os.Getenv("PATH"))
 	}
 
 	logDir := filepath.Join(*dataDir, "logs")
@@ -288,9 +361,25 @@ func main() {
 	}
 
 	pendingRepos := make(chan string, 10)
+	var indexed func(string, time.Time, error)
+	if opts.apiListen != "" {
+		s := newAPIServer(&opts, repoDir, *indexDir, pendingRepos)
+		indexed = s.indexed
+		go serveAPI(&opts, s)
+	}
+	if opts.backupURL != "" {
+		bucket, err := objstore.Open(opts.backupURL)
+		if err != nil {
+			log.Fatalf("objstore.Open(%s): %v", opts.backupURL, err)
+		}
+		go periodicBackup(bucket, *indexDir, opts.backupInterval, opts.backupRetention)
+	}
+	if opts.metricsListen != "" {
+		go serveMetrics(opts.metricsListen)
+	}
 	go periodicMirrorFile(repoDir, &opts, pendingRepos)
 	go deleteLogsLoop(logDir, opts.maxLogAge)
 	go deleteOrphanIndexes(*indexDir, repoDir, opts.fetchInterval)
-	go indexPendingRepos(*indexDir, repoDir, &opts, pendingRepos)
+	go indexPendingRepos(*indexDir, repoDir, &opts, pendingRepos, indexed)
 	periodicFetch(repoDir, *indexDir, &opts, pendingRepos)
 }
@@ -11,10 +11,32 @@
 // limitations under the License.
 
 // Command zoekt-merge-index merges a set of index shards into a compound shard.
+//
+// Usage:
+//
+//	zoekt-merge-index merge [-max_write_mbps MiB] [-tmp_dir DIR] SHARD... | -
+//	zoekt-merge-index merge [-max_write_mbps MiB] -plan PLAN_FILE
+//	zoekt-merge-index plan [-target_size MiB] [-min_age DAYS] INDEX_DIR
+//	zoekt-merge-index explode COMPOUND_SHARD
+//	zoekt-merge-index verify [-quarantine] INDEX_DIR
+//
+// With -tmp_dir, merge builds the compound shard in DIR and leaves the input
+// shards in place, so that the caller can swap it in while holding its own
+// locks. -max_write_mbps throttles writing the compound shard.
+//
+// plan prints the compound shards merge would create for INDEX_DIR as JSON,
+// including their expected memory savings, without merging anything. The
+// output can be reviewed and then passed to merge -plan.
+//
+// verify checks the table of contents and the content checksums of every
+// shard in INDEX_DIR and prints the corrupt shards as JSON. With -quarantine
+// they are moved aside, so that the indexserver rebuilds them. verify exits
+// with status 1 if any shard is corrupt.
 package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -26,25 +48,8 @@ import (
 
 // merge merges the input shards into a compound shard in dstDir. It returns the
 // full path to the compound shard. The input shards are removed on success.
-func merge(dstDir string, names []string) (string, error) {
-	var files []index.IndexFile
-	for _, fn := range names {
-		f, err := os.Open(fn)
-		if err != nil {
-			return "", nil
-		}
-		defer f.Close()
-
-		indexFile, err := index.NewIndexFile(f)
-		if err != nil {
-			return "", err
-		}
-		defer indexFile.Close()
-
-		files = append(files, indexFile)
-	}
-
-	tmpName, dstName, err := index.Merge(dstDir, files...)
+func merge(dstDir string, names []string, opts index.MergeOptions) (string, error) {
+	tmpName, dstName, err := build(dstDir, names, opts)
 	if err != nil {
 		return "", err
 	}
@@ -71,7 +76,47 @@ func merge(dstDir string, names []string) (string, error) {
 	return dstName, nil
 }
 
-func mergeCmd(paths []string) (string, error) {
+// stage merges the input shards into a compound shard in tmpDir and returns
+// its full path. Unlike merge, it leaves the input shards in place. The caller
+// swaps the compound shard in by renaming it to the directory of the input
+// shards before removing them, so tmpDir should be on the same file system.
+func stage(tmpDir string, names []string, opts index.MergeOptions) (string, error) {
+	tmpName, dstName, err := build(tmpDir, names, opts)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpName, dstName); err != nil {
+		return "", fmt.Errorf("zoekt-merge-index: failed to rename compound shard: %w", err)
+	}
+	return dstName, nil
+}
+
+// build writes the compound shard of the input shards to a temporary file in
+// dir, see index.Merge.
+func build(dir string, names []string, opts index.MergeOptions) (tmpName, dstName string, _ error) {
+	var files []index.IndexFile
+	for _, fn := range names {
+		f, err := os.Open(fn)
+		if err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+
+		indexFile, err := index.NewIndexFile(f)
+		if err != nil {
+			return "", "", err
+		}
+		defer indexFile.Close()
+
+		files = append(files, indexFile)
+	}
+
+	return index.MergeWithOptions(dir, opts, files...)
+}
+
+// mergeCmd merges paths, or the paths on stdin if paths is "-". If tmpDir is
+// set, the compound shard is staged in tmpDir, see stage.
+func mergeCmd(paths []string, tmpDir string, opts index.MergeOptions) (string, error) {
 	if paths[0] == "-" {
 		paths = []string{}
 		scanner := bufio.NewScanner(os.Stdin)
@@ -84,7 +129,10 @@ func mergeCmd(paths []string) (string, error) {
 		log.Printf("merging %d paths from stdin", len(paths))
 	}
 
-	return merge(filepath.Dir(paths[0]), paths)
+	if tmpDir != "" {
+		return stage(tmpDir, paths, opts)
+	}
+	return merge(filepath.Dir(paths[0]), paths, opts)
 }
 
 // explode splits the input shard into individual shards and places them in dstDir.
@@ -145,15 +193,57 @@ func explodeCmd(path string) error {
 func main() {
 	switch subCommand := os.Args[1]; subCommand {
 	case "merge":
-		compoundShardPath, err := mergeCmd(os.Args[2:])
+		fs := flag.NewFlagSet("merge", flag.ExitOnError)
+		plan := fs.String("plan", "", "merge the compound shards of this plan, see the plan subcommand, instead of SHARD...")
+		tmpDir := fs.String("tmp_dir", "", "build the compound shard in this directory and print its path there, leaving the input shards in place for the caller to swap in. It should be on the same file system as the input shards.")
+		maxWriteMBps := fs.Int64("max_write_mbps", 0, "write the compound shard with at most this many MiB/s, so that merging doesn't starve searches of IO. 0 means no limit.")
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: zoekt-merge-index merge [-max_write_mbps MiB] [-tmp_dir DIR] SHARD... | -\n       zoekt-merge-index merge [-max_write_mbps MiB] -plan PLAN_FILE\n")
+			fs.PrintDefaults()
+		}
+		_ = fs.Parse(os.Args[2:])
+
+		opts := index.MergeOptions{MaxWriteBytesPerSec: *maxWriteMBps * 1024 * 1024}
+		if *plan != "" {
+			if fs.NArg() != 0 || *tmpDir != "" {
+				fs.Usage()
+				os.Exit(2)
+			}
+			compoundShardPaths, err := mergePlanCmd(*plan, opts)
+			for _, p := range compoundShardPaths {
+				fmt.Println(p)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		compoundShardPath, err := mergeCmd(fs.Args(), *tmpDir, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Println(compoundShardPath)
+	case "plan":
+		if err := planCmd(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 	case "explode":
 		if err := explodeCmd(os.Args[2]); err != nil {
 			log.Fatal(err)
 		}
+	case "verify":
+		corrupt, err := verifyCmd(os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if corrupt {
+			os.Exit(1)
+		}
 	default:
 		log.Fatalf("unknown subcommand %s", subCommand)
 	}
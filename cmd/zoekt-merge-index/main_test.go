@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
 	"github.com/sourcegraph/zoekt/internal/shards"
 	"github.com/sourcegraph/zoekt/query"
 	"github.com/stretchr/testify/require"
@@ -24,7 +25,7 @@ func TestMerge(t *testing.T) {
 	t.Log(testShards)
 
 	dir := t.TempDir()
-	cs, err := merge(dir, testShards)
+	cs, err := merge(dir, testShards, index.MergeOptions{})
 	require.NoError(t, err)
 	// The name of the compound shard is based on the merged repos, so it should be
 	// stable
@@ -57,7 +58,7 @@ func TestExplode(t *testing.T) {
 	t.Log(testShards)
 
 	dir := t.TempDir()
-	_, err = merge(dir, testShards)
+	_, err = merge(dir, testShards, index.MergeOptions{})
 	require.NoError(t, err)
 
 	cs, err := filepath.Glob(filepath.Join(dir, "compound-*.zoekt"))
@@ -144,3 +145,34 @@ func copyFile(src, dst string) (err error) {
 	}
 	return d.Close()
 }
+
+func TestStage(t *testing.T) {
+	v16Shards, err := filepath.Glob("../../testdata/shards/repo*_v16.*.zoekt")
+	require.NoError(t, err)
+	sort.Strings(v16Shards)
+
+	dir := t.TempDir()
+	testShards, err := copyTestShards(dir, v16Shards)
+	require.NoError(t, err)
+
+	tmpDir := filepath.Join(dir, ".merge")
+	cs, err := stage(tmpDir, testShards, index.MergeOptions{MaxWriteBytesPerSec: 1 << 30})
+	require.NoError(t, err)
+	require.Equal(t, tmpDir, filepath.Dir(cs))
+
+	// The input shards are left for the caller to swap.
+	for _, s := range testShards {
+		_, err := os.Stat(s)
+		require.NoError(t, err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(tmpDir)
+	require.NoError(t, err)
+	defer ss.Close()
+
+	q, err := query.Parse("hello")
+	require.NoError(t, err)
+	result, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/merging"
+)
+
+// mergePlan lists the compound shards proposed by the plan subcommand. It is
+// written as JSON so that operators can review it before passing it to merge
+// -plan.
+type mergePlan struct {
+	IndexDir        string
+	TargetSizeBytes int64
+	MinAgeDays      int
+
+	Compounds []plannedCompound
+
+	// Excluded is the number of shards which are not eligible for merging,
+	// and Leftover the number of eligible shards which are not part of any
+	// compound because together they don't reach the target size.
+	Excluded int
+	Leftover int
+
+	// MemorySavingsBytes is the sum of the savings of all compounds.
+	MemorySavingsBytes int
+}
+
+type plannedCompound struct {
+	Shards    []plannedShard
+	SizeBytes int64
+
+	// MemoryBytes is the heap memory the shards use when loaded separately,
+	// MergedMemoryBytes an estimate for the compound shard, see
+	// index.EstimateMergeMemory.
+	MemoryBytes        int
+	MergedMemoryBytes  int
+	MemorySavingsBytes int
+}
+
+// plannedShard identifies a shard by its path, size and modification time.
+// merge -plan skips compounds whose shards changed since the plan was made.
+type plannedShard struct {
+	Path       string
+	Repository string
+	SizeBytes  int64
+	ModTime    time.Time
+}
+
+// changed returns true if the shard on disk is not the one that was planned.
+func (s plannedShard) changed() bool {
+	fi, err := os.Stat(s.Path)
+	return err != nil || fi.Size() != s.SizeBytes || !fi.ModTime().Equal(s.ModTime)
+}
+
+// planMerge groups the shards in dir which are eligible for merging into
+// compounds of at least targetSizeBytes, the same way
+// zoekt-sourcegraph-indexserver does, see merging.Eligible.
+func planMerge(dir string, targetSizeBytes int64, minAgeDays int) (*mergePlan, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	p := &mergePlan{
+		IndexDir:        dir,
+		TargetSizeBytes: targetSizeBytes,
+		MinAgeDays:      minAgeDays,
+	}
+
+	var c plannedCompound
+	for _, path := range paths {
+		shard, ok := eligibleShard(path, minAgeDays)
+		if !ok {
+			p.Excluded++
+			continue
+		}
+
+		c.Shards = append(c.Shards, shard)
+		c.SizeBytes += shard.SizeBytes
+		if c.SizeBytes >= targetSizeBytes && len(c.Shards) > 1 {
+			p.Compounds = append(p.Compounds, c)
+			c = plannedCompound{}
+		}
+	}
+	p.Leftover = len(c.Shards)
+
+	for i := range p.Compounds {
+		c := &p.Compounds[i]
+		if err := estimateMemory(c); err != nil {
+			return nil, err
+		}
+		p.MemorySavingsBytes += c.MemorySavingsBytes
+	}
+
+	return p, nil
+}
+
+// eligibleShard returns the shard at path if it can be merged.
+func eligibleShard(path string, minAgeDays int) (plannedShard, bool) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return plannedShard{}, false
+	}
+
+	repo, ok, err := merging.Eligible(path, minAgeDays)
+	if err != nil {
+		log.Printf("plan: failed to read metadata of %s: %v", path, err)
+	}
+	if !ok {
+		return plannedShard{}, false
+	}
+
+	return plannedShard{
+		Path:       path,
+		Repository: repo.Name,
+		SizeBytes:  fi.Size(),
+		ModTime:    fi.ModTime(),
+	}, true
+}
+
+func estimateMemory(c *plannedCompound) error {
+	var files []index.IndexFile
+	for _, s := range c.Shards {
+		f, err := os.Open(s.Path)
+		if err != nil {
+			closeAll(files)
+			return err
+		}
+		defer f.Close()
+
+		indexFile, err := index.NewIndexFile(f)
+		if err != nil {
+			closeAll(files)
+			return err
+		}
+
+		files = append(files, indexFile)
+	}
+
+	// EstimateMergeMemory closes files.
+	separate, merged, err := index.EstimateMergeMemory(files...)
+	if err != nil {
+		return err
+	}
+	c.MemoryBytes = separate
+	c.MergedMemoryBytes = merged
+	c.MemorySavingsBytes = separate - merged
+	return nil
+}
+
+func closeAll(files []index.IndexFile) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func planCmd(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	targetSize := fs.Int64("target_size", 1000, "the target size of compound shards in MiB")
+	minAge := fs.Int("min_age", 7, "the time since the last commit in days. Shards with newer commits are excluded from merging.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: zoekt-merge-index plan [flags] INDEX_DIR\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	p, err := planMerge(fs.Arg(0), *targetSize*1024*1024, *minAge)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// mergePlanCmd merges the compounds of the plan in path. It returns the paths
+// of the new compound shards. Compounds whose shards changed since the plan
+// was made are skipped.
+func mergePlanCmd(path string, opts index.MergeOptions) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p mergePlan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("zoekt-merge-index: invalid plan %s: %w", path, err)
+	}
+
+	var compoundShards []string
+	for i, c := range p.Compounds {
+		var names []string
+		for _, s := range c.Shards {
+			if s.changed() {
+				names = nil
+				break
+			}
+			names = append(names, s.Path)
+		}
+		if len(names) < 2 {
+			log.Printf("skipping compound %d: its shards changed since the plan was made", i)
+			continue
+		}
+
+		cs, err := merge(p.IndexDir, names, opts)
+		if err != nil {
+			return compoundShards, fmt.Errorf("compound %d: %w", i, err)
+		}
+		compoundShards = append(compoundShards, cs)
+	}
+	return compoundShards, nil
+}
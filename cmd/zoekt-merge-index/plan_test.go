@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestPlan(t *testing.T) {
+	v16Shards, err := filepath.Glob("../../testdata/shards/repo*_v16.*.zoekt")
+	require.NoError(t, err)
+	sort.Strings(v16Shards)
+
+	dir := t.TempDir()
+	testShards, err := copyTestShards(dir, v16Shards)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, planCmd([]string{"-target_size", "0", "-min_age", "0", dir}, &buf))
+
+	var p mergePlan
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &p))
+	require.Len(t, p.Compounds, 1)
+
+	c := p.Compounds[0]
+	var paths, repos []string
+	for _, s := range c.Shards {
+		paths = append(paths, s.Path)
+		repos = append(repos, s.Repository)
+	}
+	require.Equal(t, testShards, paths)
+	require.Equal(t, []string{"repo2", "repo"}, repos)
+	require.Greater(t, c.MemorySavingsBytes, 0)
+	require.Equal(t, c.MemoryBytes-c.MergedMemoryBytes, c.MemorySavingsBytes)
+	require.Equal(t, c.MemorySavingsBytes, p.MemorySavingsBytes)
+
+	// plan does not touch the index.
+	for _, s := range testShards {
+		require.FileExists(t, s)
+	}
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, os.WriteFile(planFile, buf.Bytes(), 0o600))
+
+	cs, err := mergePlanCmd(planFile, index.MergeOptions{})
+	require.NoError(t, err)
+	require.Len(t, cs, 1)
+	require.FileExists(t, cs[0])
+	for _, s := range testShards {
+		require.NoFileExists(t, s)
+	}
+
+	// The shards of the plan are gone, so merging it again does nothing.
+	cs, err = mergePlanCmd(planFile, index.MergeOptions{})
+	require.NoError(t, err)
+	require.Empty(t, cs)
+}
+
+func TestPlan_ChangedShard(t *testing.T) {
+	v16Shards, err := filepath.Glob("../../testdata/shards/repo*_v16.*.zoekt")
+	require.NoError(t, err)
+	sort.Strings(v16Shards)
+
+	dir := t.TempDir()
+	testShards, err := copyTestShards(dir, v16Shards)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, planCmd([]string{"-target_size", "0", "-min_age", "0", dir}, &buf))
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, os.WriteFile(planFile, buf.Bytes(), 0o600))
+
+	// A shard which was rewritten with the same size is detected by its
+	// modification time.
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(testShards[0], later, later))
+
+	cs, err := mergePlanCmd(planFile, index.MergeOptions{})
+	require.NoError(t, err)
+	require.Empty(t, cs)
+	for _, s := range testShards {
+		require.FileExists(t, s)
+	}
+}
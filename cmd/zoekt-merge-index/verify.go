@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sourcegraph/zoekt/internal/verify"
+)
+
+// verifyCmd checks the shards of an index directory and prints the corrupt
+// ones as JSON. It returns true if any shard is corrupt.
+func verifyCmd(args []string, w io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	quarantine := fs.Bool("quarantine", false, "move corrupt shards to "+verify.QuarantineDirName+" in INDEX_DIR, so that the indexserver rebuilds them.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: zoekt-merge-index verify [-quarantine] INDEX_DIR\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := verify.Dir(fs.Arg(0), *quarantine)
+	if err != nil {
+		return false, err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return len(r.Corrupt) > 0, enc.Encode(r)
+}
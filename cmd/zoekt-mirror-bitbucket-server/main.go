@@ -242,6 +242,7 @@ func cloneRepos(destDir string, host string, repos []bitbucketv1.Repository, pas
 			"zoekt.web-url-type": "bitbucket-server",
 			"zoekt.web-url":      r.Links.Self[0].Href,
 			"zoekt.name":         filepath.Join(host, fullName),
+			"zoekt.description":  r.Description,
 		}
 
 		httpsCloneUrl := ""
@@ -200,6 +200,7 @@ func main() {
 			"zoekt.gerrit-host":    anonymousURL(rootURL),
 			"zoekt.archived":       marshalBool(v.State == "READ_ONLY"),
 			"zoekt.public":         marshalBool(v.State != "HIDDEN"),
+			"zoekt.description":    v.Description,
 		}
 
 		for _, wl := range v.WebLinks {
@@ -261,6 +261,8 @@ func cloneRepos(destDir string, repos []*gitea.Repository) error {
 			"zoekt.web-url-type": "gitea",
 			"zoekt.web-url":      r.HTMLURL,
 			"zoekt.name":         filepath.Join(host.Hostname(), r.FullName),
+			"zoekt.description":  r.Description,
+			"zoekt.homepage":     r.Website,
 
 			"zoekt.gitea-stars":       strconv.Itoa(r.Stars),
 			"zoekt.gitea-watchers":    strconv.Itoa(r.Watchers),
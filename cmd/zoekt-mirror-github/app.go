@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v27/github"
+	"golang.org/x/oauth2"
+)
+
+// appTokenSource hands out installation access tokens of a GitHub App. The
+// tokens expire after an hour, so wrap it in oauth2.ReuseTokenSourceWithExpiry
+// to refresh them during long mirror runs.
+type appTokenSource struct {
+	// client authenticates as the app itself, see appTransport.
+	client         *github.Client
+	installationID int64
+}
+
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	t, _, err := s.client.Apps.CreateInstallationToken(context.Background(), s.installationID)
+	if err != nil {
+		return nil, fmt.Errorf("creating installation token: %w", err)
+	}
+	return &oauth2.Token{
+		AccessToken: t.GetToken(),
+		TokenType:   "token",
+		Expiry:      t.GetExpiresAt(),
+	}, nil
+}
+
+// appTransport authenticates requests as a GitHub App with a JSON Web Token
+// signed by the private key of the app.
+type appTransport struct {
+	appID string
+	key   *rsa.PrivateKey
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := appJWT(t.appID, t.key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// appJWT returns a JSON Web Token for the app which is valid for 9 minutes.
+// GitHub rejects tokens valid for more than 10 minutes, and it is backdated by
+// a minute to allow for clock drift.
+func appJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// readAppKey reads the PEM encoded private key of a GitHub App, as downloaded
+// from its settings page.
+func readAppKey(path string) (*rsa.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// newAppTokenSource returns a token source for the installation of a GitHub
+// App. newClient creates a GitHub API client from an HTTP client.
+func newAppTokenSource(appID, installationID, keyPath string, newClient func(*http.Client) (*github.Client, error)) (oauth2.TokenSource, error) {
+	if _, err := strconv.ParseInt(appID, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid app ID %q: %w", appID, err)
+	}
+	id, err := strconv.ParseInt(installationID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid installation ID %q: %w", installationID, err)
+	}
+	key, err := readAppKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newClient(&http.Client{Transport: &appTransport{appID: appID, key: key}})
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh the token well before it expires, so that a clone which starts
+	// right before the expiry doesn't fail.
+	return oauth2.ReuseTokenSourceWithExpiry(nil, &appTokenSource{client: client, installationID: id}, 5*time.Minute), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-github/v27/github"
+)
+
+// lister returns a page of repositories. cursor is "" for the first page
+// and otherwise the next cursor returned for the previous page, which is ""
+// after the last page.
+type lister func(ctx context.Context, cursor string) (repos []*github.Repository, next string, err error)
+
+// checkpoint records how far a mirror run got, so that a run which died
+// midway, eg. on rate limits, can continue with --resume instead of listing
+// and cloning everything again.
+type checkpoint struct {
+	// Cursor is the cursor of the first page which wasn't fully processed,
+	// see lister.
+	Cursor string
+
+	// Repos are the HTML URLs of the repositories mirrored from the
+	// processed pages, which --delete must keep.
+	Repos []string
+}
+
+// checkpointPath returns the path of the checkpoint file in destDir of runs
+// which list the repositories of owner with api. Runs with different
+// cursors don't share a checkpoint.
+func checkpointPath(destDir, api, owner string) string {
+	if owner == "" {
+		owner = "-"
+	}
+	return filepath.Join(destDir, fmt.Sprintf(".zoekt-mirror-github-%s-%s.json", api, filepath.Base(owner)))
+}
+
+// loadCheckpoint returns the checkpoint at path, or an empty one if there is
+// none.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &checkpoint{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+func (cp *checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// mirror lists the repositories page by page starting at cp.Cursor, and
+// clones the ones include accepts. After each page cp is saved to path, and
+// once all pages are processed path is removed. It returns the HTML URLs of
+// all mirrored repositories, including the ones of cp.
+func mirror(ctx context.Context, list lister, include func(*github.Repository) bool, clone func([]*github.Repository) error, cp *checkpoint, path string) ([]string, error) {
+	if cp.Cursor != "" {
+		log.Printf("resuming with %d repositories mirrored before", len(cp.Repos))
+	}
+	for {
+		repos, next, err := list(ctx, cp.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		included := repos[:0]
+		for _, r := range repos {
+			if include(r) {
+				included = append(included, r)
+			}
+		}
+		if err := clone(included); err != nil {
+			return nil, err
+		}
+		for _, r := range included {
+			cp.Repos = append(cp.Repos, r.GetHTMLURL())
+		}
+
+		if next == "" {
+			break
+		}
+		cp.Cursor = next
+		if err := cp.save(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return cp.Repos, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v27/github"
+)
+
+// graphQLRepoFields are the fields of the repositories we fetch with the
+// GraphQL API, the ones cloneRepos and the filters use.
+const graphQLRepoFields = `
+pageInfo { hasNextPage endCursor }
+nodes {
+  name
+  nameWithOwner
+  url
+  description
+  homepageUrl
+  stargazerCount
+  forkCount
+  watchers { totalCount }
+  isArchived
+  isFork
+  isPrivate
+  repositoryTopics(first: 100) { nodes { topic { name } } }
+}`
+
+// graphQLRepo is a repository in the response to graphQLRepoFields.
+type graphQLRepo struct {
+	Name           string
+	NameWithOwner  string
+	URL            string
+	Description    string
+	HomepageURL    string
+	StargazerCount int
+	ForkCount      int
+	Watchers       struct{ TotalCount int }
+	IsArchived     bool
+	IsFork         bool
+	IsPrivate      bool
+
+	RepositoryTopics struct {
+		Nodes []struct{ Topic struct{ Name string } }
+	}
+}
+
+// toGitHub converts r to the repository of the REST API, so that the rest of
+// the mirror doesn't care which API listed it.
+func (r *graphQLRepo) toGitHub() *github.Repository {
+	var topics []string
+	for _, n := range r.RepositoryTopics.Nodes {
+		topics = append(topics, n.Topic.Name)
+	}
+	return &github.Repository{
+		Name:             github.String(r.Name),
+		FullName:         github.String(r.NameWithOwner),
+		HTMLURL:          github.String(r.URL),
+		CloneURL:         github.String(r.URL + ".git"),
+		Description:      github.String(r.Description),
+		Homepage:         github.String(r.HomepageURL),
+		StargazersCount:  github.Int(r.StargazerCount),
+		WatchersCount:    github.Int(r.StargazerCount),
+		SubscribersCount: github.Int(r.Watchers.TotalCount),
+		ForksCount:       github.Int(r.ForkCount),
+		Archived:         github.Bool(r.IsArchived),
+		Fork:             github.Bool(r.IsFork),
+		Private:          github.Bool(r.IsPrivate),
+		Topics:           topics,
+	}
+}
+
+// graphQLLister returns a lister of the repositories owned by the user or
+// organization login with the GraphQL API at endpoint, 100 per request
+// instead of the 30 of the REST API by default. If login is empty, it lists
+// the repositories of the authenticated user, like the REST API.
+func graphQLLister(hc *http.Client, endpoint, login string) lister {
+	var q string
+	vars := map[string]any{}
+	if login != "" {
+		q = `query($login: String!, $cursor: String) {
+  repositoryOwner(login: $login) {
+    repositories(first: 100, after: $cursor, ownerAffiliations: [OWNER], orderBy: {field: NAME, direction: ASC}) {` + graphQLRepoFields + `}
+  }
+}`
+		vars["login"] = login
+	} else {
+		q = `query($cursor: String) {
+  repositoryOwner: viewer {
+    repositories(first: 100, after: $cursor, orderBy: {field: NAME, direction: ASC}) {` + graphQLRepoFields + `}
+  }
+}`
+	}
+
+	return func(ctx context.Context, cursor string) ([]*github.Repository, string, error) {
+		vars["cursor"] = nil
+		if cursor != "" {
+			vars["cursor"] = cursor
+		}
+
+		var data struct {
+			RepositoryOwner *struct {
+				Repositories struct {
+					PageInfo struct {
+						HasNextPage bool
+						EndCursor   string
+					}
+					Nodes []graphQLRepo
+				}
+			}
+		}
+		for attempt := 0; ; attempt++ {
+			err := graphQL(ctx, hc, endpoint, q, vars, &data)
+			if err == nil {
+				break
+			}
+			var gerr *graphQLError
+			if !errors.As(err, &gerr) || gerr.Type != "RATE_LIMITED" || attempt >= rateLimitRetries {
+				return nil, "", err
+			}
+			// Rate limited GraphQL responses are 200 OK, so the transport
+			// only waited if no requests remained, see rateLimitTransport.
+			wait := min(time.Minute<<attempt, time.Hour)
+			log.Printf("hit the rate limit of the GitHub GraphQL API, retrying in %v", wait)
+			if err := sleepContext(ctx, wait); err != nil {
+				return nil, "", err
+			}
+		}
+		if data.RepositoryOwner == nil {
+			return nil, "", fmt.Errorf("GitHub user or organization %q not found", login)
+		}
+
+		conn := data.RepositoryOwner.Repositories
+		repos := make([]*github.Repository, len(conn.Nodes))
+		for i := range conn.Nodes {
+			repos[i] = conn.Nodes[i].toGitHub()
+		}
+		if !conn.PageInfo.HasNextPage {
+			return repos, "", nil
+		}
+		return repos, conn.PageInfo.EndCursor, nil
+	}
+}
+
+// graphQLError is the first error of a GraphQL response.
+type graphQLError struct {
+	Type    string
+	Message string
+}
+
+func (e *graphQLError) Error() string {
+	if e.Type == "" {
+		return "GraphQL: " + e.Message
+	}
+	return fmt.Sprintf("GraphQL: %s: %s", e.Type, e.Message)
+}
+
+// graphQL sends the query q with vars to endpoint and decodes the data of
+// the response into data.
+func graphQL(ctx context.Context, hc *http.Client, endpoint, q string, vars map[string]any, data any) error {
+	body, err := json.Marshal(map[string]any{"query": q, "variables": vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: %s", endpoint, resp.Status)
+	}
+
+	var res struct {
+		Data   json.RawMessage
+		Errors []*graphQLError
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("decoding GraphQL response: %w", err)
+	}
+	if len(res.Errors) > 0 {
+		return res.Errors[0]
+	}
+	return json.Unmarshal(res.Data, data)
+}
+
+// graphQLEndpoint returns the GraphQL endpoint of github.com, or of the
+// GitHub Enterprise instance at githubURL if it is set.
+func graphQLEndpoint(githubURL string) string {
+	if githubURL == "" {
+		return "https://api.github.com/graphql"
+	}
+	return strings.TrimSuffix(githubURL, "/") + "/api/graphql"
+}
@@ -16,6 +16,17 @@
 // and clones them. It is strongly recommended to get a personal API token from
 // https://github.com/settings/tokens, save the token in a file, and point the
 // --token option to it.
+//
+// Alternatively, it authenticates as a GitHub App installation with the
+// --app_id, --app_installation_id and --app_private_key options. The
+// installation tokens are refreshed during the run and also used to clone the
+// repositories. Without --org or --user it mirrors the repositories of the
+// installation.
+//
+// Requests which hit the rate limits of the GitHub API are retried once the
+// limits reset. The progress of a run is checkpointed in --dest after every
+// page of repositories, so that a run which failed midway continues where it
+// stopped with --resume.
 package main
 
 import (
@@ -23,6 +34,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -46,12 +58,6 @@ func (f *topicsFlag) Set(value string) error {
 	return nil
 }
 
-type reposFilters struct {
-	topics        []string
-	excludeTopics []string
-	noArchived    *bool
-}
-
 func main() {
 	dest := flag.String("dest", "", "destination directory")
 	githubURL := flag.String("url", "", "GitHub Enterprise url. If not set github.com will be used as the host.")
@@ -60,6 +66,9 @@ func main() {
 	token := flag.String("token",
 		filepath.Join(os.Getenv("HOME"), ".github-token"),
 		"file holding API token.")
+	appID := flag.String("app_id", "", "authenticate as the GitHub App with this ID instead of with --token.")
+	appInstallationID := flag.String("app_installation_id", "", "ID of the installation of the GitHub App set by --app_id.")
+	appPrivateKey := flag.String("app_private_key", "", "file holding the PEM encoded private key of the GitHub App set by --app_id.")
 	forks := flag.Bool("forks", false, "also mirror forks.")
 	deleteRepos := flag.Bool("delete", false, "delete missing repos")
 	namePattern := flag.String("name", "", "only clone repos whose name matches the given regexp.")
@@ -69,19 +78,29 @@ func main() {
 	excludeTopics := topicsFlag{}
 	flag.Var(&excludeTopics, "exclude_topic", "don't clone repos whose have one of given topics. You can add multiple topics by setting this more than once.")
 	noArchived := flag.Bool("no_archived", false, "mirror only projects that are not archived")
+	depth := flag.Int("depth", 0, "if positive, create shallow clones with this many commits per branch")
+	cloneFilter := flag.String("filter", "", "create partial clones with this object filter, eg. blob:none. Index them with zoekt-git-index -fetch_missing_blobs.")
+	resume := flag.Bool("resume", false, "continue after the last page of repositories the previous run with the same --org or --user fully processed, if it failed midway.")
+	useGraphQL := flag.Bool("graphql", false, "list repositories with the GraphQL API, which needs far fewer requests than the REST API for large organizations. Requires a token.")
 
 	flag.Parse()
 
 	if *dest == "" {
 		log.Fatal("must set --dest")
 	}
-	if *githubURL == "" && *org == "" && *user == "" {
+	if *githubURL == "" && *org == "" && *user == "" && *appID == "" {
 		log.Fatal("must set either --org or --user when github.com is used as host")
 	}
+	if *appID != "" && (*appInstallationID == "" || *appPrivateKey == "") {
+		log.Fatal("must set --app_installation_id and --app_private_key with --app_id")
+	}
+	if *useGraphQL && *appID != "" && *org == "" && *user == "" {
+		log.Fatal("--graphql can't list the repositories of an installation, set --org or --user")
+	}
 
 	var host string
 	var apiBaseURL string
-	var client *github.Client
+	var newClient func(*http.Client) (*github.Client, error)
 	if *githubURL != "" {
 		rootURL, err := url.Parse(*githubURL)
 		if err != nil {
@@ -93,105 +112,128 @@ func main() {
 			log.Fatal(err)
 		}
 		apiBaseURL = rootURL.ResolveReference(apiPath).String()
-		client, err = github.NewEnterpriseClient(apiBaseURL, apiBaseURL, nil)
-		if err != nil {
-			log.Fatal(err)
+		newClient = func(hc *http.Client) (*github.Client, error) {
+			return github.NewEnterpriseClient(apiBaseURL, apiBaseURL, hc)
 		}
 	} else {
 		host = "github.com"
 		apiBaseURL = "https://github.com/"
-		client = github.NewClient(nil)
+		newClient = func(hc *http.Client) (*github.Client, error) {
+			return github.NewClient(hc), nil
+		}
 	}
 	destDir := filepath.Join(*dest, host)
 	if err := os.MkdirAll(destDir, 0o755); err != nil {
 		log.Fatal(err)
 	}
 
-	if *token != "" {
+	var ts oauth2.TokenSource
+	var creds gitindex.Credentials
+	if *appID != "" {
+		var err error
+		ts, err = newAppTokenSource(*appID, *appInstallationID, *appPrivateKey, newClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Installation tokens also grant access to the private repositories
+		// of the installation, so git needs them to clone.
+		creds = func() (string, string, error) {
+			t, err := ts.Token()
+			if err != nil {
+				return "", "", err
+			}
+			return "x-access-token", t.AccessToken, nil
+		}
+	} else if *token != "" {
 		content, err := os.ReadFile(*token)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		ts := oauth2.StaticTokenSource(
+		ts = oauth2.StaticTokenSource(
 			&oauth2.Token{
 				AccessToken: strings.TrimSpace(string(content)),
 			})
-		tc := oauth2.NewClient(context.Background(), ts)
-		if *githubURL != "" {
-			client, err = github.NewEnterpriseClient(apiBaseURL, apiBaseURL, tc)
-			if err != nil {
-				log.Fatal(err)
-			}
-		} else {
-			client = github.NewClient(tc)
-		}
 	}
 
-	reposFilters := reposFilters{
-		topics:        topics,
-		excludeTopics: excludeTopics,
-		noArchived:    noArchived,
+	hc := &http.Client{Transport: newRateLimitTransport(nil)}
+	if ts != nil {
+		hc = oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, hc), ts)
+	} else if *useGraphQL {
+		log.Fatal("--graphql requires a token")
 	}
-	var repos []*github.Repository
-	var err error
-	if *org != "" {
-		repos, err = getOrgRepos(client, *org, reposFilters)
-	} else if *user != "" {
-		repos, err = getUserRepos(client, *user, reposFilters)
-	} else {
-		log.Printf("no user or org specified, cloning all repos.")
-		repos, err = getUserRepos(client, "", reposFilters)
+	client, err := newClient(hc)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	if !*forks {
-		trimmed := repos[:0]
-		for _, r := range repos {
-			if r.Fork == nil || !*r.Fork {
-				trimmed = append(trimmed, r)
-			}
+	include := func(r *github.Repository) bool {
+		if r.GetFork() && !*forks {
+			return false
 		}
-		repos = trimmed
+		return matchesTopics(r, topics, excludeTopics, *noArchived) && filter.Include(r.GetName())
 	}
 
-	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
-	if err != nil {
-		log.Fatal(err)
+	var list lister
+	owner := *org + *user
+	api := "rest"
+	if *useGraphQL {
+		api = "graphql"
+		list = graphQLLister(hc, graphQLEndpoint(*githubURL), owner)
+	} else if *org != "" {
+		list = restLister(func(ctx context.Context, opt github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			return client.Repositories.ListByOrg(ctx, *org, &github.RepositoryListByOrgOptions{ListOptions: opt})
+		})
+	} else if *user != "" {
+		list = restLister(func(ctx context.Context, opt github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			return client.Repositories.List(ctx, *user, &github.RepositoryListOptions{ListOptions: opt})
+		})
+	} else if *appID != "" {
+		api = "installation"
+		list = restLister(func(ctx context.Context, opt github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			return client.Apps.ListRepos(ctx, &opt)
+		})
+	} else {
+		log.Printf("no user or org specified, cloning all repos.")
+		list = restLister(func(ctx context.Context, opt github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			return client.Repositories.List(ctx, "", &github.RepositoryListOptions{ListOptions: opt})
+		})
 	}
 
-	{
-		trimmed := repos[:0]
-		for _, r := range repos {
-			if filter.Include(*r.Name) {
-				trimmed = append(trimmed, r)
-			}
+	cpPath := checkpointPath(destDir, api, owner)
+	cp := &checkpoint{}
+	if *resume {
+		if cp, err = loadCheckpoint(cpPath); err != nil {
+			log.Fatal(err)
 		}
-		repos = trimmed
 	}
 
-	if err := cloneRepos(destDir, repos); err != nil {
-		log.Fatalf("cloneRepos: %v", err)
+	cloneOpts := gitindex.CloneOptions{Credentials: creds, Depth: *depth, Filter: *cloneFilter}
+	repos, err := mirror(context.Background(), list, include, func(repos []*github.Repository) error {
+		return cloneRepos(destDir, repos, cloneOpts)
+	}, cp, cpPath)
+	if err != nil {
+		log.Fatalf("mirroring failed, continue with --resume: %v", err)
 	}
 
 	if *deleteRepos {
-		if err := deleteStaleRepos(*dest, filter, repos, *org+*user); err != nil {
+		if err := deleteStaleRepos(*dest, filter, repos, owner); err != nil {
 			log.Fatalf("deleteStaleRepos: %v", err)
 		}
 	}
 }
 
-func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []*github.Repository, user string) error {
-	var baseURL string
-	if len(repos) > 0 {
-		baseURL = *repos[0].HTMLURL
-	} else {
+// deleteStaleRepos deletes the clones of user's repositories which are not
+// in repos, the HTML URLs of the mirrored repositories.
+func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []string, user string) error {
+	if len(repos) == 0 {
 		return nil
 	}
-	u, err := url.Parse(baseURL)
+	u, err := url.Parse(repos[0])
 	if err != nil {
 		return err
 	}
@@ -199,7 +241,7 @@ func deleteStaleRepos(destDir string, filter *gitindex.Filter, repos []*github.R
 
 	names := map[string]struct{}{}
 	for _, r := range repos {
-		u, err := url.Parse(*r.HTMLURL)
+		u, err := url.Parse(r)
 		if err != nil {
 			return err
 		}
@@ -225,61 +267,37 @@ func hasIntersection(s1, s2 []string) bool {
 	return false
 }
 
-func filterRepositories(repos []*github.Repository, include []string, exclude []string, noArchived bool) (filteredRepos []*github.Repository) {
-	for _, repo := range repos {
-		if noArchived && *repo.Archived {
-			continue
-		}
-		if (len(include) == 0 || hasIntersection(include, repo.Topics)) &&
-			!hasIntersection(exclude, repo.Topics) {
-			filteredRepos = append(filteredRepos, repo)
-		}
+// matchesTopics returns true if repo has one of the topics of include, if
+// any, and none of exclude, and isn't archived if noArchived is set.
+func matchesTopics(repo *github.Repository, include []string, exclude []string, noArchived bool) bool {
+	if noArchived && repo.GetArchived() {
+		return false
 	}
-	return
+	return (len(include) == 0 || hasIntersection(include, repo.Topics)) &&
+		!hasIntersection(exclude, repo.Topics)
 }
 
-func getOrgRepos(client *github.Client, org string, reposFilters reposFilters) ([]*github.Repository, error) {
-	var allRepos []*github.Repository
-	opt := &github.RepositoryListByOrgOptions{}
-	for {
-		repos, resp, err := client.Repositories.ListByOrg(context.Background(), org, opt)
-		if err != nil {
-			return nil, err
-		}
-		if len(repos) == 0 {
-			break
-		}
-
-		opt.Page = resp.NextPage
-		repos = filterRepositories(repos, reposFilters.topics, reposFilters.excludeTopics, *reposFilters.noArchived)
-		allRepos = append(allRepos, repos...)
-		if resp.NextPage == 0 {
-			break
+// restLister returns a lister which pages through list with the REST API.
+// The cursors are page numbers.
+func restLister(list func(context.Context, github.ListOptions) ([]*github.Repository, *github.Response, error)) lister {
+	return func(ctx context.Context, cursor string) ([]*github.Repository, string, error) {
+		opt := github.ListOptions{PerPage: 100}
+		if cursor != "" {
+			page, err := strconv.Atoi(cursor)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid page %q", cursor)
+			}
+			opt.Page = page
 		}
-	}
-	return allRepos, nil
-}
-
-func getUserRepos(client *github.Client, user string, reposFilters reposFilters) ([]*github.Repository, error) {
-	var allRepos []*github.Repository
-	opt := &github.RepositoryListOptions{}
-	for {
-		repos, resp, err := client.Repositories.List(context.Background(), user, opt)
+		repos, resp, err := list(ctx, opt)
 		if err != nil {
-			return nil, err
-		}
-		if len(repos) == 0 {
-			break
+			return nil, "", err
 		}
-
-		opt.Page = resp.NextPage
-		repos = filterRepositories(repos, reposFilters.topics, reposFilters.excludeTopics, *reposFilters.noArchived)
-		allRepos = append(allRepos, repos...)
-		if resp.NextPage == 0 {
-			break
+		if len(repos) == 0 || resp.NextPage == 0 {
+			return repos, "", nil
 		}
+		return repos, strconv.Itoa(resp.NextPage), nil
 	}
-	return allRepos, nil
 }
 
 func itoa(p *int) string {
@@ -289,7 +307,7 @@ func itoa(p *int) string {
 	return ""
 }
 
-func cloneRepos(destDir string, repos []*github.Repository) error {
+func cloneRepos(destDir string, repos []*github.Repository, cloneOpts gitindex.CloneOptions) error {
 	for _, r := range repos {
 		host, err := url.Parse(*r.HTMLURL)
 		if err != nil {
@@ -300,6 +318,8 @@ func cloneRepos(destDir string, repos []*github.Repository) error {
 			"zoekt.web-url-type": "github",
 			"zoekt.web-url":      *r.HTMLURL,
 			"zoekt.name":         filepath.Join(host.Hostname(), *r.FullName),
+			"zoekt.description":  r.GetDescription(),
+			"zoekt.homepage":     r.GetHomepage(),
 
 			"zoekt.github-stars":       itoa(r.StargazersCount),
 			"zoekt.github-watchers":    itoa(r.WatchersCount),
@@ -310,7 +330,7 @@ func cloneRepos(destDir string, repos []*github.Repository) error {
 			"zoekt.fork":     marshalBool(r.Fork != nil && *r.Fork),
 			"zoekt.public":   marshalBool(r.Private == nil || !*r.Private),
 		}
-		dest, err := gitindex.CloneRepo(destDir, *r.FullName, *r.CloneURL, config)
+		dest, err := gitindex.CloneRepoWithOptions(destDir, *r.FullName, *r.CloneURL, config, cloneOpts)
 		if err != nil {
 			return err
 		}
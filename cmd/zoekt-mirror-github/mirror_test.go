@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-github/v27/github"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	reset := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+
+	for _, tc := range []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		wantCode  int
+		wantWaits []time.Duration
+	}{{
+		name: "primary",
+		responses: []func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", reset)
+				w.WriteHeader(http.StatusForbidden)
+			},
+			func(w http.ResponseWriter) {},
+		},
+		wantCode:  http.StatusOK,
+		wantWaits: []time.Duration{time.Minute + time.Second},
+	}, {
+		name: "secondary with Retry-After",
+		responses: []func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusForbidden)
+			},
+			func(w http.ResponseWriter) {},
+		},
+		wantCode:  http.StatusOK,
+		wantWaits: []time.Duration{30 * time.Second},
+	}, {
+		name: "secondary without headers",
+		responses: []func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = io.WriteString(w, `{"message": "You have exceeded a secondary rate limit."}`)
+			},
+			func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			func(w http.ResponseWriter) {},
+		},
+		wantCode:  http.StatusOK,
+		wantWaits: []time.Duration{time.Minute, 2 * time.Minute},
+	}, {
+		name: "forbidden",
+		responses: []func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = io.WriteString(w, `{"message": "Must have admin rights to Repository."}`)
+			},
+		},
+		wantCode: http.StatusForbidden,
+	}, {
+		name: "few remaining",
+		responses: []func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.Header().Set("X-RateLimit-Remaining", "9")
+				w.Header().Set("X-RateLimit-Reset", reset)
+			},
+		},
+		wantCode:  http.StatusOK,
+		wantWaits: []time.Duration{(time.Minute + time.Second) / 10},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			i := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if body, _ := io.ReadAll(r.Body); string(body) != "body" {
+					t.Errorf("request %d has body %q", i, body)
+				}
+				tc.responses[i](w)
+				i++
+			}))
+			defer srv.Close()
+
+			var waits []time.Duration
+			rt := newRateLimitTransport(nil)
+			rt.now = func() time.Time { return now }
+			rt.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			resp, err := (&http.Client{Transport: rt}).Post(srv.URL, "text/plain", strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.wantCode)
+			}
+			if i != len(tc.responses) {
+				t.Errorf("sent %d requests, want %d", i, len(tc.responses))
+			}
+			if d := cmp.Diff(tc.wantWaits, waits); d != "" {
+				t.Errorf("waits mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestGraphQLLister(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string
+			Variables map[string]any
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Variables["login"] != "acme" {
+			t.Errorf("got login %v, want acme", req.Variables["login"])
+		}
+
+		repo, next := "a", `"hasNextPage": true, "endCursor": "c1"`
+		if req.Variables["cursor"] == "c1" {
+			repo, next = "b", `"hasNextPage": false, "endCursor": "c2"`
+		}
+		fmt.Fprintf(w, `{"data": {"repositoryOwner": {"repositories": {
+			"pageInfo": {%s},
+			"nodes": [{"name": %q, "nameWithOwner": "acme/%s", "url": "https://github.com/acme/%s",
+				"stargazerCount": 3, "watchers": {"totalCount": 2}, "isArchived": true,
+				"repositoryTopics": {"nodes": [{"topic": {"name": "go"}}]}}]}}}}`, next, repo, repo, repo)
+	}))
+	defer srv.Close()
+
+	list := graphQLLister(srv.Client(), srv.URL, "acme")
+	repos, next, err := list(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "c1" || len(repos) != 1 {
+		t.Fatalf("got %d repos and cursor %q, want 1 and c1", len(repos), next)
+	}
+	want := &github.Repository{
+		Name:             github.String("a"),
+		FullName:         github.String("acme/a"),
+		HTMLURL:          github.String("https://github.com/acme/a"),
+		CloneURL:         github.String("https://github.com/acme/a.git"),
+		Description:      github.String(""),
+		Homepage:         github.String(""),
+		StargazersCount:  github.Int(3),
+		WatchersCount:    github.Int(3),
+		SubscribersCount: github.Int(2),
+		ForksCount:       github.Int(0),
+		Archived:         github.Bool(true),
+		Fork:             github.Bool(false),
+		Private:          github.Bool(false),
+		Topics:           []string{"go"},
+	}
+	if d := cmp.Diff(want, repos[0]); d != "" {
+		t.Errorf("repo mismatch (-want +got):\n%s", d)
+	}
+
+	repos, next, err = list(context.Background(), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "" || len(repos) != 1 || repos[0].GetName() != "b" {
+		t.Fatalf("got repos %v and cursor %q, want b and the last page", repos, next)
+	}
+}
+
+func TestMirrorResume(t *testing.T) {
+	pages := map[string][]string{"": {"a", "skip"}, "2": {"b"}, "3": {"c"}}
+	next := map[string]string{"": "2", "2": "3"}
+	failAt := "3"
+	list := func(ctx context.Context, cursor string) ([]*github.Repository, string, error) {
+		if cursor == failAt {
+			return nil, "", errors.New("rate limited")
+		}
+		var repos []*github.Repository
+		for _, name := range pages[cursor] {
+			repos = append(repos, &github.Repository{Name: github.String(name), HTMLURL: github.String("https://github.com/acme/" + name)})
+		}
+		return repos, next[cursor], nil
+	}
+	include := func(r *github.Repository) bool { return r.GetName() != "skip" }
+	var cloned []string
+	clone := func(repos []*github.Repository) error {
+		for _, r := range repos {
+			cloned = append(cloned, r.GetName())
+		}
+		return nil
+	}
+
+	path := checkpointPath(t.TempDir(), "rest", "acme")
+	if _, err := mirror(context.Background(), list, include, clone, &checkpoint{}, path); err == nil {
+		t.Fatal("mirror succeeded despite the failing page")
+	}
+
+	failAt = ""
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, err := mirror(context.Background(), list, include, clone, cp, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"a", "b", "c"}, cloned); d != "" {
+		t.Errorf("cloned mismatch (-want +got):\n%s", d)
+	}
+	wantRepos := []string{"https://github.com/acme/a", "https://github.com/acme/b", "https://github.com/acme/c"}
+	if d := cmp.Diff(wantRepos, repos); d != "" {
+		t.Errorf("repos mismatch (-want +got):\n%s", d)
+	}
+
+	// The checkpoint is removed after a complete run.
+	if cp, err := loadCheckpoint(path); err != nil || cp.Cursor != "" {
+		t.Errorf("got checkpoint %+v, %v after a complete run", cp, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*")); len(matches) != 0 {
+		t.Errorf("left files %v", matches)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rateLimitRetries is how often a request is retried after hitting a
+	// rate limit before giving up.
+	rateLimitRetries = 8
+
+	// rateLimitLow is the number of remaining requests below which requests
+	// are spread over the time until the rate limit resets.
+	rateLimitLow = 100
+)
+
+// rateLimitTransport waits for the rate limits of the GitHub API instead of
+// failing. Requests which hit the primary or a secondary rate limit are
+// retried once the limit resets, as told by the Retry-After and
+// X-RateLimit-* headers, or after an exponential backoff if the response
+// doesn't say. When few requests remain, the remaining ones are spread until
+// the limit resets, so that long runs slow down rather than die midway.
+type rateLimitTransport struct {
+	base http.RoundTripper
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimitTransport(base http.RoundTripper) *rateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &rateLimitTransport{base: base, now: time.Now, sleep: sleepContext}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		wait, limited := t.retryAfter(resp, attempt)
+		if !limited {
+			if wait > 0 {
+				log.Printf("%s of %s GitHub API requests remain, waiting %v", resp.Header.Get("X-RateLimit-Remaining"), resp.Header.Get("X-RateLimit-Limit"), wait)
+				if err := t.sleep(req.Context(), wait); err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
+			}
+			return resp, nil
+		}
+		if attempt >= rateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		log.Printf("hit the rate limit of the GitHub API on %s, retrying in %v", req.URL.Path, wait)
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter returns how long to wait after resp and whether resp hit a rate
+// limit, in which case the request must be retried. Otherwise the wait
+// slows down requests when few remain.
+func (t *rateLimitTransport) retryAfter(resp *http.Response, attempt int) (time.Duration, bool) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	hasRemaining := err == nil
+	untilReset := time.Duration(0)
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		// A second of slack for clock skew.
+		untilReset = max(time.Unix(reset, 0).Sub(t.now())+time.Second, 0)
+	}
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		switch {
+		case !hasRemaining || remaining >= rateLimitLow:
+			return 0, false
+		case remaining == 0:
+			return untilReset, false
+		default:
+			return untilReset / time.Duration(remaining+1), false
+		}
+	}
+
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if hasRemaining && remaining == 0 {
+		return untilReset, true
+	}
+	// Secondary rate limits don't always say how long to wait. A 403 may
+	// also deny access, so only retry if the body mentions a rate limit.
+	if resp.StatusCode == http.StatusForbidden {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil || !strings.Contains(strings.ToLower(string(body)), "rate limit") {
+			return 0, false
+		}
+	}
+	return min(time.Minute<<attempt, time.Hour), true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
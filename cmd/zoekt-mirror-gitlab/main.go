@@ -11,6 +11,7 @@
 // limitations under the License.
 
 // Command zoekt-mirror-gitlab fetches all repos for a user from gitlab.
+// With --group it fetches the repos of groups and their subgroups instead.
 //
 // It is recommended to use a gitlab personal access token:
 // https://docs.gitlab.com/ce/user/profile/personal_access_tokens.html. This
@@ -21,9 +22,13 @@
 //	machine gitlab.com
 //	login oauth
 //	password <personal access token>
+//
+// A group can have its own token with --group=<group>=<token file>. That
+// token is also used to clone the repos of the group.
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -38,18 +43,128 @@ import (
 	gitlab "github.com/xanzy/go-gitlab"
 )
 
+type topicsFlag []string
+
+func (f *topicsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *topicsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// group is a group to mirror, with the file holding its API token if it
+// doesn't use the one of --token.
+type group struct {
+	path      string
+	tokenFile string
+}
+
+type groupsFlag []group
+
+func (f *groupsFlag) String() string {
+	var groups []string
+	for _, g := range *f {
+		groups = append(groups, g.path)
+	}
+	return strings.Join(groups, ",")
+}
+
+func (f *groupsFlag) Set(value string) error {
+	path, tokenFile, _ := strings.Cut(value, "=")
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return errors.New("group must not be empty")
+	}
+	*f = append(*f, group{path: path, tokenFile: tokenFile})
+	return nil
+}
+
+// projectFilters are the filters which the GitLab API can't apply for us.
+type projectFilters struct {
+	excludeUser       bool
+	excludeGroups     []string
+	topics            []string
+	excludeTopics     []string
+	lastActivityAfter time.Time
+}
+
+func (f *projectFilters) include(p *gitlab.Project) bool {
+	// Skip projects without a default branch - these should be projects
+	// where the repository isn't enabled
+	if p.DefaultBranch == "" {
+		return false
+	}
+	if p.Namespace != nil {
+		if f.excludeUser && p.Namespace.Kind == "user" {
+			return false
+		}
+		for _, g := range f.excludeGroups {
+			if inGroup(p.Namespace.FullPath, g) {
+				return false
+			}
+		}
+	}
+	if len(f.topics) > 0 && !hasIntersection(f.topics, p.Topics) {
+		return false
+	}
+	if hasIntersection(f.excludeTopics, p.Topics) {
+		return false
+	}
+	if !f.lastActivityAfter.IsZero() && (p.LastActivityAt == nil || p.LastActivityAt.Before(f.lastActivityAfter)) {
+		return false
+	}
+	return true
+}
+
+// inGroup returns true if the namespace path is group or one of its
+// subgroups.
+func inGroup(path, group string) bool {
+	group = strings.Trim(group, "/")
+	return path == group || strings.HasPrefix(path, group+"/")
+}
+
+func hasIntersection(s1, s2 []string) bool {
+	hash := make(map[string]bool)
+	for _, e := range s1 {
+		hash[e] = true
+	}
+	for _, e := range s2 {
+		if hash[e] {
+			return true
+		}
+	}
+	return false
+}
+
+// project is a project to mirror. creds is nil if git should find the
+// credentials itself, eg. in ~/.netrc.
+type project struct {
+	*gitlab.Project
+	creds gitindex.Credentials
+}
+
 func main() {
 	dest := flag.String("dest", "", "destination directory")
 	gitlabURL := flag.String("url", "https://gitlab.com/api/v4/", "Gitlab URL. If not set https://gitlab.com/api/v4/ will be used")
 	token := flag.String("token",
 		filepath.Join(os.Getenv("HOME"), ".gitlab-token"),
 		"file holding API token.")
-	isMember := flag.Bool("membership", false, "only mirror repos this user is a member of ")
+	groups := groupsFlag{}
+	flag.Var(&groups, "group", "mirror the repos of this group and its subgroups instead of all repos. Use group=file to read the API token of the group from file. You can add multiple groups by setting this more than once.")
+	excludeGroups := topicsFlag{}
+	flag.Var(&excludeGroups, "exclude_group", "don't mirror the repos of this group and its subgroups. You can add multiple groups by setting this more than once.")
+	isMember := flag.Bool("membership", false, "only mirror repos this user is a member of. Ignored with --group.")
 	isPublic := flag.Bool("public", false, "only mirror public repos")
 	deleteRepos := flag.Bool("delete", false, "delete missing repos")
 	excludeUserRepos := flag.Bool("exclude_user", false, "exclude user repos")
 	namePattern := flag.String("name", "", "only clone repos whose name matches the given regexp.")
 	excludePattern := flag.String("exclude", "", "don't mirror repos whose names match this regexp.")
+	topics := topicsFlag{}
+	flag.Var(&topics, "topic", "only clone repos whose have one of given topics. You can add multiple topics by setting this more than once.")
+	excludeTopics := topicsFlag{}
+	flag.Var(&excludeTopics, "exclude_topic", "don't clone repos whose have one of given topics. You can add multiple topics by setting this more than once.")
 	lastActivityAfter := flag.String("last_activity_after", "", "only mirror repos that have been active since this date (format: 2006-01-02).")
 	noArchived := flag.Bool("no_archived", false, "mirror only projects that are not archived")
 
@@ -71,67 +186,108 @@ func main() {
 		log.Fatal(err)
 	}
 
-	content, err := os.ReadFile(*token)
-	if err != nil {
-		log.Fatal(err)
-	}
-	apiToken := strings.TrimSpace(string(content))
+	newClient := func(tokenFile string) (*gitlab.Client, string) {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiToken := strings.TrimSpace(string(content))
 
-	client, err := gitlab.NewClient(apiToken, gitlab.WithBaseURL(*gitlabURL))
-	if err != nil {
-		log.Fatal(err)
+		client, err := gitlab.NewClient(apiToken, gitlab.WithBaseURL(*gitlabURL))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return client, apiToken
 	}
 
-	opt := &gitlab.ListProjectsOptions{
-		ListOptions: gitlab.ListOptions{
-			PerPage: 100,
-		},
-		Sort:       gitlab.String("asc"),
-		OrderBy:    gitlab.String("id"),
-		Membership: isMember,
+	filters := &projectFilters{
+		excludeUser:   *excludeUserRepos,
+		excludeGroups: excludeGroups,
+		topics:        topics,
+		excludeTopics: excludeTopics,
 	}
+
+	var visibility *gitlab.VisibilityValue
 	if *isPublic {
-		opt.Visibility = gitlab.Visibility(gitlab.PublicVisibility)
+		visibility = gitlab.Visibility(gitlab.PublicVisibility)
 	}
 
+	var archived *bool
+	if *noArchived {
+		archived = gitlab.Bool(false)
+	}
+
+	var lastActivityAfterTime *time.Time
 	if *lastActivityAfter != "" {
 		targetDate, err := time.Parse("2006-01-02", *lastActivityAfter)
 		if err != nil {
 			log.Fatal(err)
 		}
-		opt.LastActivityAfter = gitlab.Time(targetDate)
+		lastActivityAfterTime = gitlab.Time(targetDate)
+		filters.lastActivityAfter = targetDate
 	}
 
-	if *noArchived {
-		opt.Archived = gitlab.Bool(false)
-	}
-
-	var gitlabProjects []*gitlab.Project
-	for {
-		projects, _, err := client.Projects.ListProjects(opt)
+	var gitlabProjects []project
+	if len(groups) == 0 {
+		client, _ := newClient(*token)
+		opt := &gitlab.ListProjectsOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 100,
+			},
+			Sort:              gitlab.String("asc"),
+			OrderBy:           gitlab.String("id"),
+			Membership:        isMember,
+			Visibility:        visibility,
+			LastActivityAfter: lastActivityAfterTime,
+			Archived:          archived,
+		}
+		projects, err := getProjects(client, opt, filters)
 		if err != nil {
 			log.Fatal(err)
 		}
+		for _, p := range projects {
+			gitlabProjects = append(gitlabProjects, project{Project: p})
+		}
+	} else {
+		seen := map[int]struct{}{}
+		for _, g := range groups {
+			var (
+				client *gitlab.Client
+				creds  gitindex.Credentials
+			)
+			if g.tokenFile == "" {
+				client, _ = newClient(*token)
+			} else {
+				var apiToken string
+				client, apiToken = newClient(g.tokenFile)
+				creds = func() (string, string, error) {
+					return "oauth2", apiToken, nil
+				}
+			}
 
-		for _, project := range projects {
-
-			// Skip projects without a default branch - these should be projects
-			// where the repository isn't enabled
-			if project.DefaultBranch == "" {
-				continue
+			opt := &gitlab.ListGroupProjectsOptions{
+				ListOptions: gitlab.ListOptions{
+					PerPage: 100,
+				},
+				Sort:             gitlab.String("asc"),
+				OrderBy:          gitlab.String("id"),
+				IncludeSubGroups: gitlab.Bool(true),
+				Visibility:       visibility,
+				Archived:         archived,
 			}
-			if *excludeUserRepos && project.Namespace.Kind == "user" {
-				continue
+			projects, err := getGroupProjects(client, g.path, opt, filters)
+			if err != nil {
+				log.Fatalf("group %s: %v", g.path, err)
+			}
+			// Groups may overlap, eg. if a subgroup has its own token.
+			for _, p := range projects {
+				if _, ok := seen[p.ID]; ok {
+					continue
+				}
+				seen[p.ID] = struct{}{}
+				gitlabProjects = append(gitlabProjects, project{Project: p, creds: creds})
 			}
-
-			gitlabProjects = append(gitlabProjects, project)
-		}
-
-		if len(projects) == 0 {
-			break
 		}
-
-		opt.IDAfter = &projects[len(projects)-1].ID
 	}
 
 	filter, err := gitindex.NewFilter(*namePattern, *excludePattern)
@@ -148,7 +304,7 @@ func main() {
 		}
 		gitlabProjects = trimmed
 	}
-	fetchProjects(destDir, apiToken, gitlabProjects)
+	fetchProjects(destDir, gitlabProjects)
 
 	if *deleteRepos {
 		if err := deleteStaleProjects(*dest, filter, gitlabProjects); err != nil {
@@ -157,7 +313,57 @@ func main() {
 	}
 }
 
-func deleteStaleProjects(destDir string, filter *gitindex.Filter, projects []*gitlab.Project) error {
+// getProjects returns the projects visible to the user of client.
+func getProjects(client *gitlab.Client, opt *gitlab.ListProjectsOptions, filters *projectFilters) ([]*gitlab.Project, error) {
+	var allProjects []*gitlab.Project
+	for {
+		projects, _, err := client.Projects.ListProjects(opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range projects {
+			if filters.include(p) {
+				allProjects = append(allProjects, p)
+			}
+		}
+
+		if len(projects) == 0 {
+			break
+		}
+
+		opt.IDAfter = &projects[len(projects)-1].ID
+	}
+	return allProjects, nil
+}
+
+// getGroupProjects returns the projects of group and all its subgroups.
+func getGroupProjects(client *gitlab.Client, group string, opt *gitlab.ListGroupProjectsOptions, filters *projectFilters) ([]*gitlab.Project, error) {
+	var allProjects []*gitlab.Project
+	for {
+		projects, resp, err := client.Groups.ListGroupProjects(group, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range projects {
+			if filters.include(p) {
+				allProjects = append(allProjects, p)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return allProjects, nil
+}
+
+func deleteStaleProjects(destDir string, filter *gitindex.Filter, projects []project) error {
+	if len(projects) == 0 {
+		return nil
+	}
 	u, err := url.Parse(projects[0].HTTPURLToRepo)
 	u.Path = ""
 	if err != nil {
@@ -180,7 +386,7 @@ func deleteStaleProjects(destDir string, filter *gitindex.Filter, projects []*gi
 	return nil
 }
 
-func fetchProjects(destDir, token string, projects []*gitlab.Project) {
+func fetchProjects(destDir string, projects []project) {
 	for _, p := range projects {
 		u, err := url.Parse(p.HTTPURLToRepo)
 		if err != nil {
@@ -191,6 +397,7 @@ func fetchProjects(destDir, token string, projects []*gitlab.Project) {
 			"zoekt.web-url-type": "gitlab",
 			"zoekt.web-url":      p.WebURL,
 			"zoekt.name":         filepath.Join(u.Hostname(), p.PathWithNamespace),
+			"zoekt.description":  p.Description,
 
 			"zoekt.gitlab-stars": strconv.Itoa(p.StarCount),
 			"zoekt.gitlab-forks": strconv.Itoa(p.ForksCount),
@@ -201,7 +408,7 @@ func fetchProjects(destDir, token string, projects []*gitlab.Project) {
 		}
 
 		cloneURL := p.HTTPURLToRepo
-		dest, err := gitindex.CloneRepo(destDir, p.PathWithNamespace, cloneURL, config)
+		dest, err := gitindex.CloneRepoWithCredentials(destDir, p.PathWithNamespace, cloneURL, config, p.creds)
 		if err != nil {
 			log.Printf("cloneRepos: %v", err)
 			continue
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+func TestGroupsFlag(t *testing.T) {
+	var f groupsFlag
+	for _, v := range []string{"org", "/org/team/", "other=/tmp/token"} {
+		if err := f.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := groupsFlag{{path: "org"}, {path: "org/team"}, {path: "other", tokenFile: "/tmp/token"}}
+	if len(f) != len(want) {
+		t.Fatalf("got %v, want %v", f, want)
+	}
+	for i := range want {
+		if f[i] != want[i] {
+			t.Errorf("%d: got %+v, want %+v", i, f[i], want[i])
+		}
+	}
+	if err := f.Set("=/tmp/token"); err == nil {
+		t.Error("got no error for an empty group")
+	}
+}
+
+func TestProjectFilters(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	project := func(namespace string, topics ...string) *gitlab.Project {
+		return &gitlab.Project{
+			DefaultBranch:  "main",
+			Namespace:      &gitlab.ProjectNamespace{Kind: "group", FullPath: namespace},
+			Topics:         topics,
+			LastActivityAt: &day,
+		}
+	}
+
+	f := &projectFilters{
+		excludeGroups:     []string{"org/archive"},
+		topics:            []string{"go", "rust"},
+		excludeTopics:     []string{"deprecated"},
+		lastActivityAfter: day.Add(-time.Hour),
+	}
+	for _, tc := range []struct {
+		name string
+		p    *gitlab.Project
+		want bool
+	}{
+		{"match", project("org/team", "go"), true},
+		{"excluded group", project("org/archive", "go"), false},
+		{"excluded subgroup", project("org/archive/old", "go"), false},
+		{"group with excluded prefix", project("org/archived", "go"), true},
+		{"no topic", project("org/team"), false},
+		{"excluded topic", project("org/team", "go", "deprecated"), false},
+		{"no default branch", &gitlab.Project{Namespace: &gitlab.ProjectNamespace{FullPath: "org"}, Topics: []string{"go"}}, false},
+	} {
+		if got := f.include(tc.p); got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+
+	f.lastActivityAfter = day.Add(time.Hour)
+	if f.include(project("org/team", "go")) {
+		t.Error("included a project which was inactive since lastActivityAfter")
+	}
+}
@@ -0,0 +1,74 @@
+// Command zoekt-p4-index indexes Perforce depot paths at a submitted
+// changelist, without a workspace or a git bridge. The changelist number is
+// the version of the indexed branch.
+//
+// Examples:
+//
+//	zoekt-p4-index -name depot/game -p4port ssl:perforce:1666 //depot/game/...
+//
+// Index the view of a client spec at changelist 12345:
+//
+//	zoekt-p4-index -name game -p4client build-client -changelist 12345 //build-client/...
+//
+// With -delta, only the files changed since the last indexed changelist are
+// read, and added as a new shard on top of the existing ones.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"go.uber.org/automaxprocs/maxprocs"
+
+	"github.com/sourcegraph/zoekt/cmd"
+	"github.com/sourcegraph/zoekt/internal/p4"
+)
+
+func main() {
+	var (
+		incremental = flag.Bool("incremental", true, "only index if the changelist or the index options changed")
+		delta       = flag.Bool("delta", false, "only index the files changed since the last indexed changelist, on top of the existing shards")
+
+		name       = flag.String("name", "", "The repository name")
+		urlRaw     = flag.String("url", "", "The repository URL")
+		branch     = flag.String("branch", "HEAD", "The branch name")
+		changelist = flag.Int("changelist", 0, "The changelist to index. If zero, the last submitted changelist of the depot paths is indexed.")
+
+		p4Bin    = flag.String("p4", "p4", "The p4 command line client")
+		p4Port   = flag.String("p4port", "", "The Perforce server. Defaults to the environment of p4, eg. P4PORT.")
+		p4User   = flag.String("p4user", "", "The Perforce user. Defaults to the environment of p4, eg. P4USER.")
+		p4Client = flag.String("p4client", "", "The client spec for depot paths in client syntax. Defaults to the environment of p4, eg. P4CLIENT.")
+	)
+	flag.Parse()
+
+	// Tune GOMAXPROCS to match Linux container CPU quota.
+	_, _ = maxprocs.Set()
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if len(flag.Args()) == 0 {
+		log.Fatal("expected at least one depot path, eg. //depot/project/...")
+	}
+
+	bopts := cmd.OptionsFromFlags()
+	opts := p4.Options{
+		Incremental: *incremental,
+		Delta:       *delta,
+
+		Paths:      flag.Args(),
+		Changelist: *changelist,
+		Name:       *name,
+		RepoURL:    *urlRaw,
+		Branch:     *branch,
+
+		P4:     *p4Bin,
+		Port:   *p4Port,
+		User:   *p4User,
+		Client: *p4Client,
+	}
+
+	if err := p4.Index(context.Background(), opts, *bopts); err != nil {
+		log.Fatal(err)
+	}
+}
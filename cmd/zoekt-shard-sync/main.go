@@ -0,0 +1,159 @@
+// Command zoekt-shard-sync replicates a canonical index directory to read
+// replicas over HTTP.
+//
+// Usage:
+//
+//	zoekt-shard-sync serve [-index DIR] [-listen ADDR] [-token_file FILE] [-ssl_cert FILE -ssl_key FILE]
+//	zoekt-shard-sync pull [-index DIR] -primary URL [-token_file FILE] [-interval DURATION] [-once]
+//
+// serve runs next to the indexserver and publishes the shards in its index
+// directory together with their SHA-256 checksums. It only listens on
+// localhost by default. To serve other hosts, set -token_file, which replicas
+// must pass to pull as well, and preferably serve TLS. pull runs on each replica
+// and makes its index directory match the primary: changed shards are
+// downloaded to temporary files, verified and atomically renamed into place,
+// and shards deleted on the primary are deleted. Run zoekt-webserver with
+// -read_only on the replicas.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s serve|pull [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	var err error
+	switch subCommand := os.Args[1]; subCommand {
+	case "serve":
+		err = serveCmd(os.Args[2:])
+	case "pull":
+		err = pullCmd(os.Args[2:])
+	default:
+		log.Fatalf("unknown subcommand %s", subCommand)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	indexDir := fs.String("index", index.DefaultDir, "the canonical index directory to serve.")
+	listen := fs.String("listen", "localhost:6071", "listen on this address.")
+	tokenFile := fs.String("token_file", "", "file holding the bearer token which requests must carry. Required unless -listen is a loopback address.")
+	sslCert := fs.String("ssl_cert", "", "set path to SSL .pem holding certificate.")
+	sslKey := fs.String("ssl_key", "", "set path to SSL .pem holding key.")
+	_ = fs.Parse(args)
+
+	if fi, err := os.Stat(*indexDir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", *indexDir)
+	}
+
+	token, err := readToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	if len(token) == 0 && !isLoopback(*listen) {
+		return fmt.Errorf("must set -token_file to listen on %s", *listen)
+	}
+
+	log.Printf("serving shards of %s on %s", *indexDir, *listen)
+	h := newServer(*indexDir, token).handler()
+	if *sslCert != "" || *sslKey != "" {
+		return http.ListenAndServeTLS(*listen, *sslCert, *sslKey, h)
+	}
+	return http.ListenAndServe(*listen, h)
+}
+
+// readToken returns the trimmed contents of path, or nil if path is empty.
+func readToken(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// isLoopback reports whether the listen address addr only accepts
+// connections from the local host.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func pullCmd(args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	indexDir := fs.String("index", index.DefaultDir, "the index directory of the replica.")
+	primary := fs.String("primary", "", "the address of zoekt-shard-sync serve on the primary, eg. https://indexserver:6071.")
+	tokenFile := fs.String("token_file", "", "file holding the bearer token of the primary.")
+	interval := fs.Duration("interval", time.Minute, "how often to sync.")
+	parallelism := fs.Int("parallelism", 4, "the number of files to download at once.")
+	timeout := fs.Duration("timeout", time.Hour, "timeout of a single sync.")
+	once := fs.Bool("once", false, "sync once and exit. The exit status is non-zero if any file failed to sync.")
+	_ = fs.Parse(args)
+
+	if *primary == "" {
+		return fmt.Errorf("must set -primary")
+	}
+	token, err := readToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*indexDir, 0o755); err != nil {
+		return err
+	}
+
+	p := &puller{
+		client:      &http.Client{},
+		primary:     *primary,
+		token:       token,
+		sums:        newChecksumCache(*indexDir),
+		parallelism: max(*parallelism, 1),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		ctx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		stats, err := p.sync(ctx)
+		log.Printf("synced from %s: %+v", *primary, stats)
+		return err
+	}
+
+	p.pullLoop(ctx, *interval, *timeout)
+	return nil
+}
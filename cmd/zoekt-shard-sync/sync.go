@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+)
+
+// manifest lists the shards of the canonical index directory.
+type manifest struct {
+	Files []manifestFile
+}
+
+type manifestFile struct {
+	// Name is the base name of a shard or its .meta file.
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// isShardFile reports whether name is a file replicated between index
+// directories. Temporary files are never replicated.
+func isShardFile(name string) bool {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta")
+}
+
+type cachedSum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// checksumCache lists the shard files of a directory with their checksums.
+// Checksums are only recomputed if the size or modification time of a file
+// changes, since shards are large and rarely change.
+type checksumCache struct {
+	dir string
+
+	// hashing deduplicates concurrent hashing of the same file version.
+	hashing singleflight.Group
+
+	mu   sync.Mutex
+	sums map[string]cachedSum
+}
+
+func newChecksumCache(dir string) *checksumCache {
+	return &checksumCache{dir: dir, sums: map[string]cachedSum{}}
+}
+
+// list returns the shard files in the directory, sorted by name. Files are
+// hashed without holding c.mu, so a slow hash of a new shard doesn't block
+// concurrent calls which only need cached checksums.
+func (c *checksumCache) list() ([]manifestFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []manifestFile
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !isShardFile(e.Name()) {
+			continue
+		}
+		fi, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted since we read the directory.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		sum, err := c.sum(e.Name(), fi)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		seen[e.Name()] = true
+		files = append(files, manifestFile{Name: e.Name(), Size: fi.Size(), SHA256: sum})
+	}
+
+	c.mu.Lock()
+	for name := range c.sums {
+		if !seen[name] {
+			delete(c.sums, name)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// sum returns the checksum of the file name, which has the info fi. It is
+// hashed if the cache has no checksum for this size and modification time.
+func (c *checksumCache) sum(name string, fi os.FileInfo) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.sums[name]
+	c.mu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.sum, nil
+	}
+
+	key := fmt.Sprintf("%s/%d/%d", name, fi.Size(), fi.ModTime().UnixNano())
+	v, err, _ := c.hashing.Do(key, func() (any, error) {
+		sum, err := hashFile(filepath.Join(c.dir, name))
+		if err != nil {
+			return "", err
+		}
+		c.mu.Lock()
+		c.sums[name] = cachedSum{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+		c.mu.Unlock()
+		return sum, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// add records the checksum of a file we just wrote, so it isn't hashed again.
+func (c *checksumCache) add(name, sum string) {
+	fi, err := os.Stat(filepath.Join(c.dir, name))
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.sums[name] = cachedSum{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+	c.mu.Unlock()
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// server serves the shards of a canonical index directory to replicas. If
+// token is set, all requests must carry it as "Authorization: Bearer
+// <token>".
+//
+//	GET /manifest       the manifest as JSON
+//	GET /shards/NAME    the contents of a file in the manifest
+type server struct {
+	sums  *checksumCache
+	token []byte
+}
+
+func newServer(dir string, token []byte) *server {
+	return &server{sums: newChecksumCache(dir), token: token}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manifest", s.serveManifest)
+	mux.HandleFunc("/shards/", s.serveShard)
+	if len(s.token) == 0 {
+		return mux
+	}
+	return s.authenticate(mux)
+}
+
+func (s *server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="zoekt-shard-sync"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) serveManifest(w http.ResponseWriter, r *http.Request) {
+	files, err := s.sums.list()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(manifest{Files: files})
+}
+
+func (s *server) serveShard(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/shards/")
+	if !isShardFile(name) {
+		http.Error(w, "invalid shard name", http.StatusBadRequest)
+		return
+	}
+
+	// The open file stays valid if the indexer replaces the shard while we
+	// serve it. The replica then notices the checksum mismatch and retries.
+	f, err := os.Open(filepath.Join(s.sums.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+// puller replicates the index directory served by primary into dir. If
+// token is set, it is sent as a bearer token.
+type puller struct {
+	client      *http.Client
+	primary     string
+	token       []byte
+	sums        *checksumCache
+	parallelism int
+}
+
+type syncStats struct {
+	Downloaded, Deleted, Unchanged, Failed int
+}
+
+// sync makes dir match the manifest of the primary. Files are downloaded to
+// temporary files, verified against their checksum and then renamed into
+// place, so the webserver never sees a partial shard. Shards are replaced
+// before their .meta files, and files missing from the manifest are deleted.
+//
+// Failing files are skipped, so one bad shard doesn't stop replication. The
+// returned error joins their errors.
+func (p *puller) sync(ctx context.Context) (syncStats, error) {
+	var stats syncStats
+
+	remote, err := p.fetchManifest(ctx)
+	if err != nil {
+		return stats, err
+	}
+	localFiles, err := p.sums.list()
+	if err != nil {
+		return stats, err
+	}
+	local := make(map[string]manifestFile, len(localFiles))
+	for _, f := range localFiles {
+		local[f.Name] = f
+	}
+
+	var shards, metas []manifestFile
+	wanted := make(map[string]bool, len(remote.Files))
+	for _, f := range remote.Files {
+		if !isShardFile(f.Name) {
+			return stats, fmt.Errorf("manifest contains invalid file name %q", f.Name)
+		}
+		wanted[f.Name] = true
+		if local[f.Name] == f {
+			stats.Unchanged++
+		} else if strings.HasSuffix(f.Name, ".meta") {
+			metas = append(metas, f)
+		} else {
+			shards = append(shards, f)
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, batch := range [][]manifestFile{shards, metas} {
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(p.parallelism)
+		for _, f := range batch {
+			g.Go(func() error {
+				err := p.download(gctx, f)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					stats.Failed++
+					errs = append(errs, fmt.Errorf("%s: %w", f.Name, err))
+				} else {
+					stats.Downloaded++
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+	}
+
+	for _, f := range localFiles {
+		if wanted[f.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(p.sums.dir, f.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			stats.Failed++
+			errs = append(errs, err)
+			continue
+		}
+		stats.Deleted++
+	}
+
+	return stats, errors.Join(errs...)
+}
+
+func (p *puller) fetchManifest(ctx context.Context) (*manifest, error) {
+	resp, err := p.get(ctx, "/manifest")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func (p *puller) download(ctx context.Context, f manifestFile) (err error) {
+	resp, err := p.get(ctx, "/shards/"+url.PathEscape(f.Name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The .tmp suffix hides the file from the webserver's shard watcher.
+	tmp, err := os.CreateTemp(p.sums.dir, f.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if err != nil {
+		return err
+	}
+	if n != f.Size {
+		return fmt.Errorf("got %d bytes, want %d", n, f.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", sum, f.SHA256)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(p.sums.dir, f.Name)); err != nil {
+		return err
+	}
+
+	p.sums.add(f.Name, f.SHA256)
+	return nil
+}
+
+func (p *puller) get(ctx context.Context, path string) (*http.Response, error) {
+	u := strings.TrimSuffix(p.primary, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+string(p.token))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// pullLoop syncs every interval until ctx is done. Each sync is canceled
+// after timeout.
+func (p *puller) pullLoop(ctx context.Context, interval, timeout time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		start := time.Now()
+		sctx, cancel := context.WithTimeout(ctx, timeout)
+		stats, err := p.sync(sctx)
+		cancel()
+		log.Printf("synced from %s in %v: %+v", p.primary, time.Since(start).Round(time.Millisecond), stats)
+		if err != nil {
+			log.Printf("sync errors: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+func newTestPuller(url, dir string) *puller {
+	return &puller{
+		client:      http.DefaultClient,
+		primary:     url,
+		sums:        newChecksumCache(dir),
+		parallelism: 2,
+	}
+}
+
+func TestSync(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+	writeFiles(t, primaryDir, map[string]string{
+		"github.com%2Ffoo%2Fbar_v16.00000.zoekt":      "bar shard",
+		"github.com%2Ffoo%2Fbar_v16.00000.zoekt.meta": "bar meta",
+		"baz_v16.00000.zoekt":                         "baz shard",
+		// Not replicated.
+		"qux_v16.00000.zoekt.123.tmp": "partial",
+		"indexserver.sock":            "",
+	})
+	writeFiles(t, replicaDir, map[string]string{
+		"stale_v16.00000.zoekt": "stale",
+		"README":                "not a shard",
+	})
+
+	ts := httptest.NewServer(newServer(primaryDir, nil).handler())
+	defer ts.Close()
+	p := newTestPuller(ts.URL, replicaDir)
+
+	stats, err := p.sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (syncStats{Downloaded: 3, Deleted: 1}); stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+	want := map[string]string{
+		"github.com%2Ffoo%2Fbar_v16.00000.zoekt":      "bar shard",
+		"github.com%2Ffoo%2Fbar_v16.00000.zoekt.meta": "bar meta",
+		"baz_v16.00000.zoekt":                         "baz shard",
+		"README":                                      "not a shard",
+	}
+	if d := cmp.Diff(want, readDir(t, replicaDir)); d != "" {
+		t.Fatalf("replica mismatch (-want +got):\n%s", d)
+	}
+
+	// Syncing again downloads nothing.
+	if stats, err := p.sync(context.Background()); err != nil || stats != (syncStats{Unchanged: 3}) {
+		t.Fatalf("got stats %+v and err %v, want 3 unchanged files", stats, err)
+	}
+
+	// Changes and deletions on the primary are replicated.
+	writeFiles(t, primaryDir, map[string]string{"baz_v16.00000.zoekt": "baz shard, reindexed"})
+	if err := os.Remove(filepath.Join(primaryDir, "github.com%2Ffoo%2Fbar_v16.00000.zoekt.meta")); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := p.sync(context.Background()); err != nil || stats != (syncStats{Downloaded: 1, Deleted: 1, Unchanged: 1}) {
+		t.Fatalf("got stats %+v and err %v", stats, err)
+	}
+	want = map[string]string{
+		"github.com%2Ffoo%2Fbar_v16.00000.zoekt": "bar shard",
+		"baz_v16.00000.zoekt":                    "baz shard, reindexed",
+		"README":                                 "not a shard",
+	}
+	if d := cmp.Diff(want, readDir(t, replicaDir)); d != "" {
+		t.Fatalf("replica mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestSync_ChecksumMismatch(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+	writeFiles(t, primaryDir, map[string]string{
+		"good_v16.00000.zoekt": "good",
+		"bad_v16.00000.zoekt":  "bad",
+	})
+	writeFiles(t, replicaDir, map[string]string{"bad_v16.00000.zoekt": "old"})
+
+	// Corrupt the bad shard in transit.
+	h := newServer(primaryDir, nil).handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/bad_v16.00000.zoekt") {
+			_, _ = w.Write([]byte("BAD"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	stats, err := newTestPuller(ts.URL, replicaDir).sync(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("got err %v, want a checksum mismatch", err)
+	}
+	if want := (syncStats{Downloaded: 1, Failed: 1}); stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	// The good shard is synced, the old copy of the bad shard is kept and no
+	// temporary files are left behind.
+	want := map[string]string{
+		"good_v16.00000.zoekt": "good",
+		"bad_v16.00000.zoekt":  "old",
+	}
+	if d := cmp.Diff(want, readDir(t, replicaDir)); d != "" {
+		t.Fatalf("replica mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestServeShard_InvalidName(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"indexserver.sock": ""})
+	ts := httptest.NewServer(newServer(filepath.Join(dir, "index"), nil).handler())
+	defer ts.Close()
+
+	for _, name := range []string{"indexserver.sock", "..%2Findexserver.sock", ".hidden.zoekt", "foo.zoekt.123.tmp"} {
+		resp, err := http.Get(ts.URL + "/shards/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", name, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSync_Token(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+	writeFiles(t, primaryDir, map[string]string{"foo_v16.00000.zoekt": "foo"})
+
+	ts := httptest.NewServer(newServer(primaryDir, []byte("secret")).handler())
+	defer ts.Close()
+
+	p := newTestPuller(ts.URL, replicaDir)
+	if _, err := p.sync(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("got err %v, want unauthorized", err)
+	}
+
+	p.token = []byte("secret")
+	if _, err := p.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(map[string]string{"foo_v16.00000.zoekt": "foo"}, readDir(t, replicaDir)); d != "" {
+		t.Fatalf("replica mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"localhost:6071": true,
+		"127.0.0.1:6071": true,
+		"[::1]:6071":     true,
+		":6071":          false,
+		"0.0.0.0:6071":   false,
+		"10.0.0.1:6071":  false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
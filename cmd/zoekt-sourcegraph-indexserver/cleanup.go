@@ -26,16 +26,25 @@ var metricCleanupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 // that do not exist in indexDir, but do in indexDir/.trash it will move them
 // back into indexDir. Additionally it uses now to remove shards that have
 // been in the trash for 24 hours. It also deletes .tmp files older than 4 hours.
-func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool) {
+//
+// cleanup returns a report of what it changed. If dryRun is true, the index
+// directory is left untouched and the report describes what cleanup would
+// have done.
+func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging, dryRun bool) *cleanupReport {
 	start := time.Now()
+	report := &cleanupReport{Time: now, DryRun: dryRun}
+
 	trashDir := filepath.Join(indexDir, ".trash")
-	if err := os.MkdirAll(trashDir, 0o755); err != nil {
-		errorLog.Printf("failed to create trash dir: %v", err)
+	if !dryRun {
+		if err := os.MkdirAll(trashDir, 0o755); err != nil {
+			errorLog.Printf("failed to create trash dir: %v", err)
+		}
 	}
 
 	trash := getShards(trashDir)
 	tombtones := getTombstonedRepos(indexDir)
 	indexShards := getShards(indexDir)
+	states := getRepoStates(indexDir)
 
 	// trash: Remove old shards and conflicts with index
 	minAge := now.Add(-24 * time.Hour)
@@ -44,18 +53,26 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 		for _, shard := range shards {
 			if shard.ModTime.Before(minAge) {
 				old = true
-			} else if shard.ModTime.After(now) {
+			} else if shard.ModTime.After(now) && !dryRun {
 				debugLog.Printf("trashed shard %s has timestamp in the future, reseting to now", shard.Path)
 				_ = os.Chtimes(shard.Path, now, now)
 			}
 		}
 
-		if _, conflicts := indexShards[repo]; !conflicts && !old {
+		_, conflicts := indexShards[repo]
+		if !conflicts && !old {
 			continue
 		}
 
-		infoLog.Printf("removing old shards from trash for %v", repo)
-		removeAll(shards...)
+		reason := cleanupReasonOldGeneration
+		if conflicts {
+			reason = cleanupReasonConflict
+		}
+		report.add(cleanupActionRemove, reason, shards...)
+		if !dryRun {
+			infoLog.Printf("removing old shards from trash for %v", repo)
+			removeAll(shards...)
+		}
 		delete(trash, repo)
 	}
 
@@ -88,13 +105,19 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 		for _, shard := range shards {
 			paths = append(paths, filepath.Base(shard.Path))
 		}
-		infoLog.Printf("removing shards for %v due to multiple repository names: %s", repo, strings.Join(paths, " "))
+		if !dryRun {
+			infoLog.Printf("removing shards for %v due to multiple repository names: %s", repo, strings.Join(paths, " "))
+		}
 
 		// We may be in both normal and compound shards in this case. First
 		// tombstone the compound shards so we don't just rm them.
 		simple := shards[:0]
 		for _, s := range shards {
-			if shardMerging && maybeSetTombstone([]shard{s}, repo) {
+			if shardMerging && isCompound([]shard{s}) {
+				report.add(cleanupActionTombstone, cleanupReasonRenamed, s)
+				if !dryRun {
+					maybeSetTombstone([]shard{s}, repo)
+				}
 				continue
 			}
 
@@ -105,7 +128,41 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 			continue
 		}
 
-		removeAll(simple...)
+		report.add(cleanupActionRemove, cleanupReasonRenamed, simple...)
+		if !dryRun {
+			removeAll(simple...)
+		}
+	}
+
+	// states: Remove the state files of repos which have shards, or which are
+	// no longer assigned to us. State files only hold metadata, so we don't
+	// trash them.
+	assigned := make(map[uint32]bool, len(repos))
+	for _, repo := range repos {
+		assigned[repo] = true
+	}
+	for repo, s := range states {
+		reason := cleanupReasonUnassigned
+		if _, conflicts := indexShards[repo]; conflicts {
+			reason = cleanupReasonConflict
+		} else if assigned[repo] {
+			report.RepoStates = append(report.RepoStates, repoStateEntry{
+				RepoID:   s.RepoID,
+				RepoName: s.RepoName,
+				State:    s.State,
+				Reason:   s.StateReason,
+				Since:    s.ModTime,
+				Path:     s.Path,
+			})
+			continue
+		}
+
+		report.add(cleanupActionRemove, reason, s)
+		if !dryRun {
+			infoLog.Printf("removing %s state for %v", s.State, repo)
+			removeAll(s)
+		}
+		delete(states, repo)
 	}
 
 	// index: Move missing repos from trash into index
@@ -115,13 +172,26 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 		// trashed.
 		delete(indexShards, repo)
 
+		// The state was recorded after the repo was trashed or tombstoned, so
+		// it is more recent.
+		if _, ok := states[repo]; ok {
+			continue
+		}
+
 		if shards, ok := trash[repo]; ok {
-			infoLog.Printf("restoring shards from trash for %v", repo)
-			moveAll(indexDir, shards)
+			report.add(cleanupActionRestore, cleanupReasonAssigned, shards...)
+			if !dryRun {
+				infoLog.Printf("restoring shards from trash for %v", repo)
+				moveAll(indexDir, shards)
+			}
 			continue
 		}
 
 		if s, ok := tombtones[repo]; ok {
+			report.add(cleanupActionUntombstone, cleanupReasonAssigned, s)
+			if dryRun {
+				continue
+			}
 			infoLog.Printf("removing tombstone for %v", repo)
 			err := index.UnsetTombstone(s.Path, repo)
 			if err != nil {
@@ -132,6 +202,22 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 
 	// index: Move non-existent repos into trash
 	for repo, shards := range indexShards {
+		if shardMerging && isCompound(shards) {
+			report.add(cleanupActionTombstone, cleanupReasonUnassigned, shards...)
+		} else {
+			for _, s := range shards {
+				// moveAll removes compound shards instead of trashing them.
+				if isCompound([]shard{s}) {
+					report.add(cleanupActionRemove, cleanupReasonUnassigned, s)
+				} else {
+					report.add(cleanupActionTrash, cleanupReasonUnassigned, s)
+				}
+			}
+		}
+		if dryRun {
+			continue
+		}
+
 		// Best-effort touch. If touch fails, we will just remove from the
 		// trash sooner.
 		for _, shard := range shards {
@@ -158,13 +244,132 @@ func cleanup(indexDir string, repos []uint32, now time.Time, shardMerging bool)
 				continue
 			}
 			if !st.IsDir() {
-				infoLog.Printf("removing tmp file: %s", f)
-				os.Remove(f)
+				report.Entries = append(report.Entries, cleanupEntry{
+					Action: cleanupActionRemove,
+					Reason: cleanupReasonOrphanedTmp,
+					Path:   f,
+				})
+				if !dryRun {
+					infoLog.Printf("removing tmp file: %s", f)
+					os.Remove(f)
+				}
 			}
 		}
 	}
 
+	report.sort()
+	sort.Slice(report.RepoStates, func(i, j int) bool {
+		return report.RepoStates[i].RepoID < report.RepoStates[j].RepoID
+	})
+	if dryRun {
+		infoLog.Printf("cleanup dry-run: %s", report.summary())
+		return report
+	}
+
 	metricCleanupDuration.Observe(time.Since(start).Seconds())
+	return report
+}
+
+// Actions recorded in a cleanupReport.
+const (
+	cleanupActionRemove      = "remove"
+	cleanupActionTrash       = "trash"
+	cleanupActionTombstone   = "tombstone"
+	cleanupActionRestore     = "restore"
+	cleanupActionUntombstone = "untombstone"
+)
+
+// Reasons for the actions recorded in a cleanupReport.
+const (
+	// The repository is not in the list of repositories assigned to us.
+	cleanupReasonUnassigned = "unassigned"
+	// The repository is assigned to us again.
+	cleanupReasonAssigned = "assigned"
+	// The shards have been in the trash for more than 24 hours.
+	cleanupReasonOldGeneration = "old generation"
+	// The trashed shards conflict with shards in the index.
+	cleanupReasonConflict = "conflict"
+	// The repository ID is indexed under multiple names, eg. after a rename.
+	cleanupReasonRenamed = "multiple repository names"
+	// A .tmp file left behind by a crashed indexer.
+	cleanupReasonOrphanedTmp = "orphaned tmp"
+)
+
+// cleanupEntry is one change to the index directory made by cleanup.
+type cleanupEntry struct {
+	Action   string
+	Reason   string
+	RepoID   uint32 `json:",omitempty"`
+	RepoName string `json:",omitempty"`
+	Path     string
+	// State is set if Path is a repository state file, see index.RepoState.
+	State index.RepoState `json:",omitempty"`
+}
+
+// repoStateEntry is an assigned repository which has a state file instead of
+// shards, see index.RepoState.
+type repoStateEntry struct {
+	RepoID   uint32
+	RepoName string
+	State    index.RepoState
+	Reason   string `json:",omitempty"`
+	// Since is when the repository entered State.
+	Since time.Time
+	Path  string
+}
+
+// cleanupReport lists the changes made by one run of cleanup, or the changes
+// it would have made if DryRun is true.
+type cleanupReport struct {
+	Time    time.Time
+	DryRun  bool
+	Entries []cleanupEntry
+	// RepoStates lists the assigned repositories which could not be indexed.
+	RepoStates []repoStateEntry `json:",omitempty"`
+}
+
+func (r *cleanupReport) add(action, reason string, shards ...shard) {
+	for _, s := range shards {
+		r.Entries = append(r.Entries, cleanupEntry{
+			Action:   action,
+			Reason:   reason,
+			RepoID:   s.RepoID,
+			RepoName: s.RepoName,
+			Path:     s.Path,
+			State:    s.State,
+		})
+	}
+}
+
+// sort orders the entries by path so that reports are stable across runs.
+func (r *cleanupReport) sort() {
+	sort.SliceStable(r.Entries, func(i, j int) bool {
+		if r.Entries[i].Path != r.Entries[j].Path {
+			return r.Entries[i].Path < r.Entries[j].Path
+		}
+		return r.Entries[i].RepoID < r.Entries[j].RepoID
+	})
+}
+
+// summary returns the number of entries per action, eg. "remove=2 trash=1".
+func (r *cleanupReport) summary() string {
+	counts := map[string]int{}
+	for _, e := range r.Entries {
+		counts[e.Action]++
+	}
+	if len(counts) == 0 {
+		return "nothing to do"
+	}
+	actions := make([]string, 0, len(counts))
+	for a := range counts {
+		actions = append(actions, a)
+	}
+	sort.Strings(actions)
+	parts := make([]string, 0, len(actions))
+	for _, a := range actions {
+		parts = append(parts, fmt.Sprintf("%s=%d", a, counts[a]))
+	}
+	return strings.Join(parts, " ")
 }
 
 type shard struct {
@@ -173,6 +378,11 @@ type shard struct {
 	Path          string
 	ModTime       time.Time
 	RepoTombstone bool
+
+	// State and StateReason are set if Path is a repository state file. Then
+	// ModTime is when the repository entered State.
+	State       index.RepoState
+	StateReason string
 }
 
 func getShards(dir string) map[uint32][]shard {
@@ -254,6 +464,33 @@ func getTombstonedRepos(dir string) map[uint32]shard {
 	return m
 }
 
+// getRepoStates returns the repository state files in dir, see
+// index.RepoState.
+func getRepoStates(dir string) map[uint32]shard {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+index.RepoStateSuffix))
+	if err != nil {
+		return nil
+	}
+
+	m := make(map[uint32]shard, len(paths))
+	for _, p := range paths {
+		sf, err := index.ReadRepoState(p)
+		if err != nil {
+			debugLog.Printf("failed to read repository state: %v", err)
+			continue
+		}
+		m[sf.Repository.ID] = shard{
+			RepoID:      sf.Repository.ID,
+			RepoName:    sf.Repository.Name,
+			Path:        p,
+			ModTime:     sf.Time,
+			State:       sf.State,
+			StateReason: sf.Reason,
+		}
+	}
+	return m
+}
+
 var incompleteRE = regexp.MustCompile(`\.zoekt[0-9]+(\.\w+)?$`)
 
 func removeIncompleteShards(dir string) {
@@ -356,14 +593,19 @@ func consistentRepoName(shards []shard) bool {
 	return true
 }
 
+// isCompound returns true if shards represents a single compound shard.
+func isCompound(shards []shard) bool {
+	// 1 repo can be split across many simple shards but it should only be contained
+	// in 1 compound shard. Hence we check that len(shards)==1 and only consider the
+	// shard at index 0.
+	return len(shards) == 1 && strings.HasPrefix(filepath.Base(shards[0].Path), "compound-")
+}
+
 // maybeSetTombstone will call zoekt.SetTombstone for repoID if shards
 // represents a compound shard. It returns true if shards represents a
 // compound shard.
 func maybeSetTombstone(shards []shard, repoID uint32) bool {
-	// 1 repo can be split across many simple shards but it should only be contained
-	// in 1 compound shard. Hence we check that len(shards)==1 and only consider the
-	// shard at index 0.
-	if len(shards) != 1 || !strings.HasPrefix(filepath.Base(shards[0].Path), "compound-") {
+	if !isCompound(shards) {
 		return false
 	}
 
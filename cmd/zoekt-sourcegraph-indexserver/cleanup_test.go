@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -138,7 +140,27 @@ func TestCleanup(t *testing.T) {
 			for _, name := range tt.repos {
 				repoIDs = append(repoIDs, fakeID(name))
 			}
-			cleanup(dir, repoIDs, now, false)
+
+			// A dry-run must not change the index directory, but report the
+			// same changes as the real run.
+			before := glob(filepath.Join(dir, "*"))
+			beforeTrash := glob(filepath.Join(dir, ".trash", "*"))
+			beforeTmps := globBase(filepath.Join(dir, "*.tmp"))
+			dryRun := cleanup(dir, repoIDs, now, false, true)
+			if d := cmp.Diff(before, glob(filepath.Join(dir, "*"))); d != "" {
+				t.Errorf("dry-run changed index (-before, +after):\n%s", d)
+			}
+			if d := cmp.Diff(beforeTrash, glob(filepath.Join(dir, ".trash", "*"))); d != "" {
+				t.Errorf("dry-run changed trash (-before, +after):\n%s", d)
+			}
+			if d := cmp.Diff(beforeTmps, globBase(filepath.Join(dir, "*.tmp"))); d != "" {
+				t.Errorf("dry-run changed tmps (-before, +after):\n%s", d)
+			}
+
+			report := cleanup(dir, repoIDs, now, false, false)
+			if d := cmp.Diff(dryRun.Entries, report.Entries); d != "" {
+				t.Errorf("dry-run report differs from report (-dry-run, +report):\n%s", d)
+			}
 
 			if d := cmp.Diff(tt.wantIndex, glob(filepath.Join(dir, "*.zoekt"))); d != "" {
 				t.Errorf("unexpected index (-want, +got):\n%s", d)
@@ -160,6 +182,163 @@ func TestCleanup(t *testing.T) {
 	}
 }
 
+func TestCleanupReport(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	shardPath := func(name string) string {
+		return index.ShardName(dir, name, 15, 0)
+	}
+	trashPath := func(name string) string {
+		return index.ShardName(filepath.Join(dir, ".trash"), name, 15, 0)
+	}
+
+	createTestShard(t, "exists", fakeID("exists"), shardPath("exists"))
+	createTestShard(t, "unassigned", fakeID("unassigned"), shardPath("unassigned"))
+	createTestShard(t, "restored", fakeID("restored"), trashPath("restored"))
+	createTestShard(t, "old", fakeID("old"), trashPath("old"))
+	oldTime := now.Add(-25 * time.Hour)
+	if err := os.Chtimes(trashPath("old"), oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "crashed.tmp")
+	if _, err := os.Create(tmp); err != nil {
+		t.Fatal(err)
+	}
+
+	report := cleanup(dir, []uint32{fakeID("exists"), fakeID("restored")}, now, false, true)
+
+	want := []cleanupEntry{{
+		Action:   cleanupActionRemove,
+		Reason:   cleanupReasonOldGeneration,
+		RepoID:   fakeID("old"),
+		RepoName: "old",
+		Path:     trashPath("old"),
+	}, {
+		Action:   cleanupActionRestore,
+		Reason:   cleanupReasonAssigned,
+		RepoID:   fakeID("restored"),
+		RepoName: "restored",
+		Path:     trashPath("restored"),
+	}, {
+		Action: cleanupActionRemove,
+		Reason: cleanupReasonOrphanedTmp,
+		Path:   tmp,
+	}, {
+		Action:   cleanupActionTrash,
+		Reason:   cleanupReasonUnassigned,
+		RepoID:   fakeID("unassigned"),
+		RepoName: "unassigned",
+		Path:     shardPath("unassigned"),
+	}}
+	if d := cmp.Diff(want, report.Entries); d != "" {
+		t.Fatalf("unexpected report (-want, +got):\n%s", d)
+	}
+	if !report.DryRun {
+		t.Fatal("expected report of a dry-run")
+	}
+	if got, want := report.summary(), "remove=2 restore=1 trash=1"; got != want {
+		t.Fatalf("got summary %q, want %q", got, want)
+	}
+}
+
+func TestCleanupRepoStates(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	writeState := func(name string, state index.RepoState) string {
+		t.Helper()
+		o := index.Options{
+			IndexDir:              dir,
+			RepositoryDescription: zoekt.Repository{ID: fakeID(name), Name: name},
+		}
+		if err := o.WriteRepoState(state, "reason"); err != nil {
+			t.Fatal(err)
+		}
+		return o.RepoStatePath()
+	}
+
+	kept := writeState("kept", index.RepoStateCloneFailed)
+	unassigned := writeState("unassigned", index.RepoStateEmpty)
+	// The shard was written after the state, eg. by a crashed indexer.
+	conflict := writeState("conflict", index.RepoStateTooLarge)
+	createTestShard(t, "conflict", fakeID("conflict"), index.ShardName(dir, "conflict", 15, 0))
+	// The state was recorded after the repo was trashed.
+	trashed := writeState("trashed", index.RepoStateEmpty)
+	createTestShard(t, "trashed", fakeID("trashed"), index.ShardName(filepath.Join(dir, ".trash"), "trashed", 15, 0))
+
+	report := cleanup(dir, []uint32{fakeID("kept"), fakeID("conflict"), fakeID("trashed")}, now, false, false)
+
+	want := []cleanupEntry{{
+		Action:   cleanupActionRemove,
+		Reason:   cleanupReasonConflict,
+		RepoID:   fakeID("conflict"),
+		RepoName: "conflict",
+		Path:     conflict,
+		State:    index.RepoStateTooLarge,
+	}, {
+		Action:   cleanupActionRemove,
+		Reason:   cleanupReasonUnassigned,
+		RepoID:   fakeID("unassigned"),
+		RepoName: "unassigned",
+		Path:     unassigned,
+		State:    index.RepoStateEmpty,
+	}}
+	if d := cmp.Diff(want, report.Entries); d != "" {
+		t.Fatalf("unexpected report (-want, +got):\n%s", d)
+	}
+
+	var states []string
+	for _, e := range report.RepoStates {
+		if e.Since.IsZero() || e.Reason != "reason" {
+			t.Errorf("unexpected state entry %+v", e)
+		}
+		states = append(states, fmt.Sprintf("%s %s %s", e.RepoName, e.State, filepath.Base(e.Path)))
+	}
+	sort.Strings(states)
+	wantStates := []string{
+		"kept clone-failed " + filepath.Base(kept),
+		"trashed empty " + filepath.Base(trashed),
+	}
+	if d := cmp.Diff(wantStates, states); d != "" {
+		t.Fatalf("unexpected repo states (-want, +got):\n%s", d)
+	}
+
+	if got, want := globBase(filepath.Join(dir, "*")), []string{".trash", "conflict_v15.00000.zoekt", "kept.state.meta", "trashed.state.meta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected index dir: got %v, want %v", got, want)
+	}
+	if got := listIndexed(dir); len(got) != 3 {
+		t.Errorf("got %d indexed repos, want 3", len(got))
+	}
+}
+
+func TestHandleDebugCleanup(t *testing.T) {
+	s := &Server{}
+
+	get := func(target string) int {
+		w := httptest.NewRecorder()
+		s.handleDebugCleanup(w, httptest.NewRequest("GET", target, nil))
+		return w.Code
+	}
+
+	if got := get("/debug/cleanup?dry_run=true"); got != http.StatusNotFound {
+		t.Fatalf("before first cleanup: got status %d, want %d", got, http.StatusNotFound)
+	}
+
+	s.lastCleanupReport = &cleanupReport{}
+	if got := get("/debug/cleanup"); got != http.StatusOK {
+		t.Fatalf("got status %d, want %d", got, http.StatusOK)
+	}
+	if got := get("/debug/cleanup?dry_run=true"); got != http.StatusNotFound {
+		t.Fatalf("dry_run after real cleanup: got status %d, want %d", got, http.StatusNotFound)
+	}
+
+	s.lastCleanupReport = &cleanupReport{DryRun: true}
+	if got := get("/debug/cleanup?dry_run=true"); got != http.StatusOK {
+		t.Fatalf("dry_run after dry-run cleanup: got status %d, want %d", got, http.StatusOK)
+	}
+}
+
 func createTestShard(t *testing.T, repo string, id uint32, path string, optFns ...func(in *zoekt.Repository)) {
 	t.Helper()
 
@@ -412,7 +591,7 @@ func TestCleanupCompoundShards(t *testing.T) {
 	// want indexed
 	repos := []uint32{1, 2, 3, 4, 5}
 
-	cleanup(dir, repos, now, true)
+	cleanup(dir, repos, now, true, false)
 
 	index := getShards(dir)
 	trash := getShards(filepath.Join(dir, ".trash"))
@@ -526,3 +705,26 @@ func mergeHelper(t *testing.T, fn string) error {
 	_, _, err = index.Merge(filepath.Dir(fn), indexFile)
 	return err
 }
+
+func merge(t *testing.T, dstDir string, names []string) (string, string, error) {
+	t.Helper()
+
+	var files []index.IndexFile
+	for _, fn := range names {
+		f, err := os.Open(fn)
+		if err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+
+		indexFile, err := index.NewIndexFile(f)
+		if err != nil {
+			return "", "", err
+		}
+		defer indexFile.Close()
+
+		files = append(files, indexFile)
+	}
+
+	return index.Merge(dstDir, files...)
+}
@@ -94,8 +94,22 @@ func debugCmd() *ffcli.Command {
     "wget -q -O - http://localhost:6072/metrics -sS | grep index_shard_merging_running". It is only possible
     to trigger one merge operation at a time.
 
+  wget -q -O - http://localhost:6072/debug/cleanup[?dry_run=true/FALSE]
+    show the shards which the last cleanup of the index directory deleted, trashed or tombstoned, and why. If
+    dry_run=true, only show the report if the last cleanup was a dry run (see -cleanup_dry_run). Use this to
+    check a new repository assignment before it removes shards.
+
+  wget -q -O - --header "Authorization: token $SRC_DEBUG_SHARD_TOKEN" http://localhost:6072/debug/shard/
+    list the shards in the index directory. Download them with "debug fetch" for local inspection. Only
+    enabled if the indexserver runs with -debug_shard_token.
+
+  wget -q -O - http://localhost:6072/debug/tombstone/
+    list the repositories tombstoned with "debug tombstone". They are hidden from search and treated as
+    unassigned until their tombstone is cleared.
+
   wget -q -O - http://localhost:6072/debug/queue
-    list the repositories in the indexing queue, sorted by descending priority.
+    list the repositories in the indexing queue, sorted by descending priority. The order among stale
+    repositories depends on -queue_policy.
 
     COLUMN HEADERS
       Position     zero-indexed position of this repository in the indexing queue (sorted by priority).
@@ -105,6 +119,12 @@ func debugCmd() *ffcli.Command {
       Age          amount of time that this repository has spent in the indexing queue since its outstanding indexing job
                    was first added (ignoring any job metadata updates that may have occurred while it was still enqueued).
                    A "-" is printed instead if this repository doesn't have an outstanding job.
+      AvgDuration  moving average of how long indexing this repository took. A "-" is printed if it hasn't been
+                   indexed by this instance yet.
+      ExpectedStart estimated time from now until this repository starts indexing, based on the average durations
+                   of the repositories ahead of it and the index concurrency. Repositories which have not been
+                   indexed yet count with the mean duration of all repositories. A "-" is printed instead if this
+                   repository doesn't have an outstanding job.
       Branches     comma-separated list of branches in $BRANCH_NAME@$COMMIT_HASH format.
                    If the repository has a job on the indexing queue, this list represents the desired set of
                    branches + associated commits that will be process during the next indexing job.
@@ -112,8 +132,10 @@ func debugCmd() *ffcli.Command {
                    branches + associated commits that was indexed during its most recent indexing job.`,
 		FlagSet: fs,
 		Subcommands: []*ffcli.Command{
+			debugFetch(),
 			debugIndex(),
 			debugMeta(),
+			debugTombstone(),
 			debugTrigrams(),
 		},
 	}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// isShardFileName returns true if name is the file name of a shard or of its
+// .meta file, without any directory.
+func isShardFileName(name string) bool {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta")
+}
+
+// authorizeDebugShard checks the token of a request to /debug/shard/. Shards
+// contain source code, so the endpoint is disabled unless a token is
+// configured with -debug_shard_token, and requests must send it in an
+// "Authorization: token <token>" header. It writes an error and returns false
+// if the request is not authorized.
+func (s *Server) authorizeDebugShard(w http.ResponseWriter, r *http.Request) bool {
+	if s.debugShardToken == "" {
+		http.Error(w, "shard download is disabled, see -debug_shard_token", http.StatusNotFound)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "token ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.debugShardToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleDebugShards lists the shards in the index directory with their sizes.
+// Shard names start with the escaped name of their repository, or with
+// "compound-" for compound shards.
+func (s *Server) handleDebugShards(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeDebugShard(w, r) {
+		return
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.IndexDir, "*.zoekt"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(paths)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 16, 8, 4, ' ', 0)
+	fmt.Fprintf(tw, "Name\tSize\n")
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			// Removed since the glob.
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\n", filepath.Base(p), fi.Size())
+	}
+	tw.Flush()
+}
+
+// handleDebugShard serves the shard /debug/shard/{name} from the index
+// directory, so that it can be inspected locally, see "debug fetch".
+func (s *Server) handleDebugShard(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeDebugShard(w, r) {
+		return
+	}
+
+	name := r.PathValue("name")
+	if !isShardFileName(name) {
+		http.Error(w, fmt.Sprintf("invalid shard name %q", name), http.StatusBadRequest)
+		return
+	}
+
+	// Shards are replaced by renaming, so the open file stays intact while we
+	// serve it, even if the shard is rebuilt meanwhile.
+	f, err := os.Open(filepath.Join(s.IndexDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, fmt.Sprintf("shard %q not found", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+// fetchShard downloads the shard name from the indexserver at baseURL to dir.
+// It returns os.ErrNotExist if the indexserver doesn't have the file.
+func fetchShard(ctx context.Context, client *http.Client, baseURL, token, name, dir string) error {
+	u, err := url.JoinPath(baseURL, "debug/shard", url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		msg := strings.TrimSpace(string(body))
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%s: %w: %s", name, os.ErrNotExist, msg)
+		}
+		return fmt.Errorf("%s: %s: %s", name, resp.Status, msg)
+	}
+
+	// Write to a temporary file first, so that an interrupted download doesn't
+	// leave a truncated shard behind.
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+func debugFetch() *ffcli.Command {
+	fs := flag.NewFlagSet("debug fetch", flag.ExitOnError)
+	baseURL := fs.String("url", "http://localhost:6072", "the address of the indexserver")
+	token := fs.String("token", os.Getenv("SRC_DEBUG_SHARD_TOKEN"), "the token configured with -debug_shard_token on the indexserver. Defaults to $SRC_DEBUG_SHARD_TOKEN.")
+	dir := fs.String("dir", ".", "the directory to download the shards to")
+
+	return &ffcli.Command{
+		Name:       "fetch",
+		ShortUsage: "fetch [flags] <shard name>...",
+		ShortHelp:  "download shards from an indexserver",
+		LongHelp: `
+  Download shards, and their .meta files if they exist, from the /debug/shard endpoint of an
+  indexserver for local inspection, eg. with zoekt-webserver -index <dir> or the meta and
+  trigrams subcommands. /debug/shard/ lists the shards of the indexserver.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("missing shard name")
+			}
+			if *token == "" {
+				return fmt.Errorf("missing -token")
+			}
+			for _, name := range args {
+				name = filepath.Base(name)
+				if !strings.HasSuffix(name, ".zoekt") {
+					return fmt.Errorf("%s: not a shard, want a name ending in .zoekt", name)
+				}
+				if err := fetchShard(ctx, http.DefaultClient, *baseURL, *token, name, *dir); err != nil {
+					return err
+				}
+				err := fetchShard(ctx, http.DefaultClient, *baseURL, *token, name+".meta", *dir)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+				infoLog.Printf("downloaded %s to %s", name, *dir)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugShard(t *testing.T) {
+	indexDir := t.TempDir()
+	name := "github.com%2Fsourcegraph%2Fzoekt_v16.00000.zoekt"
+	content := []byte("not really a shard")
+	if err := os.WriteFile(filepath.Join(indexDir, name), content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{IndexDir: indexDir}
+	mux := http.NewServeMux()
+	s.addDebugHandlers(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path, token string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "token "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/debug/shard/", "secret"); code != http.StatusNotFound {
+		t.Fatalf("without -debug_shard_token: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	s.debugShardToken = "secret"
+	if code, _ := get("/debug/shard/", ""); code != http.StatusUnauthorized {
+		t.Fatalf("without token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := get("/debug/shard/", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, body := get("/debug/shard/", "secret"); code != http.StatusOK || !strings.Contains(body, name) {
+		t.Fatalf("list: got status %d and body %q, want %s listed", code, body, name)
+	}
+	if code, _ := get("/debug/shard/..%2Fsecret.zoekt", "secret"); code != http.StatusBadRequest {
+		t.Fatalf("path outside of the index directory: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	dir := t.TempDir()
+	if err := fetchShard(context.Background(), ts.Client(), ts.URL, "secret", name, dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("got %q, want %q", got, content)
+	}
+
+	err = fetchShard(context.Background(), ts.Client(), ts.URL, "secret", name+".meta", dir)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing .meta file: got %v, want os.ErrNotExist", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d files in %s, want only the shard", len(entries), dir)
+	}
+}
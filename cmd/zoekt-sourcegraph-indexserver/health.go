@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// health records the progress of the indexserver for /healthz and /readyz.
+type health struct {
+	// progress is the time in unix nanoseconds at which an index worker last
+	// made progress: it took a job off the queue, finished one or found
+	// nothing to do.
+	progress atomic.Int64
+
+	mu sync.Mutex
+	// lastSync is when we last listed the repositories of this instance with
+	// Sourcegraph, syncErr the error of the latest attempt, if it failed.
+	lastSync time.Time
+	syncErr  error
+}
+
+// markProgress records that an index worker made progress at now.
+func (h *health) markProgress(now time.Time) {
+	h.progress.Store(now.UnixNano())
+}
+
+// lastProgress returns when an index worker last made progress, or the zero
+// time if none ran yet.
+func (h *health) lastProgress() time.Time {
+	if ns := h.progress.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// markSync records the result of listing the repositories of this instance
+// with Sourcegraph at now.
+func (h *health) markSync(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.syncErr = err
+	if err == nil {
+		h.lastSync = now
+	}
+}
+
+// sync returns when we last listed the repositories with Sourcegraph and the
+// error of the latest attempt.
+func (h *health) sync() (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSync, h.syncErr
+}
+
+// stallTimeout returns how long the index workers may make no progress
+// before the indexserver is considered stuck. Jobs are killed after
+// s.timeout, so a job running that long is no reason to restart.
+func (s *Server) stallTimeout() time.Duration {
+	return time.Duration(s.healthIntervals)*s.Interval + s.timeout
+}
+
+// checkIndexDir returns an error if we cannot create files in the index
+// directory.
+func (s *Server) checkIndexDir() error {
+	f, err := os.CreateTemp(s.IndexDir, ".healthz-*")
+	if err != nil {
+		return fmt.Errorf("index directory is not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkQueue returns an error if there are repositories to index, but the
+// index workers made no progress for longer than stallTimeout.
+func (s *Server) checkQueue(now time.Time) error {
+	last := s.health.lastProgress()
+	if last.IsZero() {
+		// The workers have not started yet.
+		return nil
+	}
+	if d := now.Sub(last); d > s.stallTimeout() && s.queue.Len() > 0 {
+		return fmt.Errorf("queue stalled: %d repositories queued, but no index job finished or started for %s", s.queue.Len(), d.Round(time.Second))
+	}
+	return nil
+}
+
+// checkSourcegraph returns an error if we could not list the repositories of
+// this instance with Sourcegraph within the last healthIntervals intervals.
+func (s *Server) checkSourcegraph(now time.Time) error {
+	last, err := s.health.sync()
+	if last.IsZero() {
+		if err != nil {
+			return fmt.Errorf("Sourcegraph is not reachable: %w", err)
+		}
+		return fmt.Errorf("Sourcegraph has not been reached yet")
+	}
+	if d := now.Sub(last); d > time.Duration(s.healthIntervals)*s.Interval {
+		return fmt.Errorf("Sourcegraph is not reachable since %s: %v", d.Round(time.Second), err)
+	}
+	return nil
+}
+
+// handleHealthz reports whether the indexserver is alive. It fails if the
+// index directory is not writable or the queue is stalled, in which case
+// restarting the indexserver may help.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	writeHealth(w, s.checkIndexDir(), s.checkQueue(now))
+}
+
+// handleReadyz reports whether the indexserver is ready to index. On top of
+// the checks of /healthz, it fails until Sourcegraph has been reached and
+// whenever it has not been reachable for healthIntervals intervals.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	writeHealth(w, s.checkIndexDir(), s.checkQueue(now), s.checkSourcegraph(now))
+}
+
+// writeHealth responds with "ok" if all checks passed and with 503 and the
+// failed checks otherwise.
+func writeHealth(w http.ResponseWriter, checks ...error) {
+	var failed []string
+	for _, err := range checks {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		http.Error(w, strings.Join(failed, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/log/logtest"
+)
+
+func TestHealth(t *testing.T) {
+	s := &Server{
+		IndexDir:        t.TempDir(),
+		Interval:        time.Minute,
+		timeout:         time.Hour,
+		healthIntervals: 10,
+		queue:           *NewQueue(0, 0, logtest.Scoped(t)),
+	}
+
+	check := func(path string, wantCode int, wantBody string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		s.addDebugHandlers(mux)
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != wantCode || !strings.Contains(w.Body.String(), wantBody) {
+			t.Errorf("GET %s: got %d %q, want %d containing %q", path, w.Code, w.Body.String(), wantCode, wantBody)
+		}
+	}
+
+	// Sourcegraph has not been reached yet.
+	check("/healthz", http.StatusOK, "ok")
+	check("/readyz", http.StatusServiceUnavailable, "Sourcegraph has not been reached yet")
+
+	s.health.markSync(time.Now(), errors.New("connection refused"))
+	check("/readyz", http.StatusServiceUnavailable, "connection refused")
+
+	s.health.markSync(time.Now(), nil)
+	check("/readyz", http.StatusOK, "ok")
+
+	// A failed sync is fine until Sourcegraph has been unreachable for
+	// healthIntervals intervals.
+	s.health.markSync(time.Now(), errors.New("connection refused"))
+	check("/readyz", http.StatusOK, "ok")
+	s.health.mu.Lock()
+	s.health.lastSync = time.Now().Add(-11 * time.Minute)
+	s.health.mu.Unlock()
+	check("/readyz", http.StatusServiceUnavailable, "Sourcegraph is not reachable since 11m0s")
+	s.health.markSync(time.Now(), nil)
+
+	// The queue only stalls if there is something to index.
+	s.health.markProgress(time.Now().Add(-2 * time.Hour))
+	check("/healthz", http.StatusOK, "ok")
+	s.queue.AddOrUpdate(IndexOptions{RepoID: 1, Name: "foo"})
+	check("/healthz", http.StatusServiceUnavailable, "queue stalled: 1 repositories queued")
+	check("/readyz", http.StatusServiceUnavailable, "queue stalled")
+	s.health.markProgress(time.Now().Add(-time.Hour))
+	check("/healthz", http.StatusOK, "ok")
+
+	s.IndexDir = filepath.Join(s.IndexDir, "missing")
+	check("/healthz", http.StatusServiceUnavailable, "index directory is not writable")
+}
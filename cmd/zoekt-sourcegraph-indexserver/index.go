@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
 	"os"
 	"os/exec"
@@ -21,6 +22,7 @@ import (
 	"github.com/sourcegraph/zoekt/index"
 	"github.com/sourcegraph/zoekt/internal/ctags"
 	"github.com/sourcegraph/zoekt/internal/tenant"
+	"golang.org/x/sync/semaphore"
 )
 
 const defaultIndexingTimeout = 1*time.Hour + 30*time.Minute
@@ -33,6 +35,14 @@ type IndexOptions struct {
 	// here: https://golang.org/pkg/path/filepath/#Match.
 	LargeFiles []string
 
+	// IncludePaths and ExcludePaths are slices of glob patterns, including **
+	// for any number of directories, which select the file paths to index. If
+	// IncludePaths is non-empty, only matching paths are indexed, eg.
+	// services/foo/** for a per-team index of a monorepo. Paths matching
+	// ExcludePaths are never indexed.
+	IncludePaths []string
+	ExcludePaths []string
+
 	// Symbols if true will make zoekt index the output of ctags.
 	Symbols bool
 
@@ -132,6 +142,8 @@ func (o *indexArgs) BuildOptions() *index.Options {
 		Parallelism:      o.Parallelism,
 		SizeMax:          o.FileLimit,
 		LargeFiles:       o.LargeFiles,
+		IncludePaths:     o.IncludePaths,
+		ExcludePaths:     o.ExcludePaths,
 		CTagsMustSucceed: o.Symbols,
 		DisableCTags:     !o.Symbols,
 		IsDelta:          o.UseDelta,
@@ -178,9 +190,46 @@ type gitIndexConfig struct {
 
 	// timeout defines how long the index server waits before killing an indexing job.
 	timeout time.Duration
+
+	// maxRepoSizeBytes is the size of the fetched git directory above which
+	// the repository is not indexed. It is disabled if zero.
+	maxRepoSizeBytes int64
+
+	// fetchLimit, if not nil, is acquired while fetching the repository, to
+	// bound the fetches of concurrent index jobs.
+	fetchLimit *semaphore.Weighted
+}
+
+// acquireFetch blocks until c.fetchLimit admits another fetch. Call release
+// once the fetch is done.
+func (c gitIndexConfig) acquireFetch(ctx context.Context) (release func(), err error) {
+	if c.fetchLimit == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	if err := c.fetchLimit.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	metricFetchWait.Observe(time.Since(start).Seconds())
+	return func() { c.fetchLimit.Release(1) }, nil
 }
 
-func gitIndex(c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.Logger) error {
+// repoStateError is returned by gitIndex if the repository can't be indexed
+// for a reason we record in a repository state file, see index.RepoState.
+type repoStateError struct {
+	state index.RepoState
+	err   error
+}
+
+func (e *repoStateError) Error() string {
+	return fmt.Sprintf("%s: %v", e.state, e.err)
+}
+
+func (e *repoStateError) Unwrap() error {
+	return e.err
+}
+
+func gitIndex(ctx context.Context, c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.Logger) error {
 	logger := l.Scoped("gitIndex")
 
 	if len(o.Branches) == 0 {
@@ -195,7 +244,7 @@ func gitIndex(c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.L
 		return errors.New("findRepositoryMetadata in provided configuration was nil - a function must be provided")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
 	gitDir, err := tmpGitDir(o.Name)
@@ -204,10 +253,32 @@ func gitIndex(c gitIndexConfig, o *indexArgs, sourcegraph Sourcegraph, l sglog.L
 	}
 	defer os.RemoveAll(gitDir) // best-effort cleanup
 
-	err = fetchRepo(ctx, gitDir, o, c, logger)
+	release, err := c.acquireFetch(ctx)
 	if err != nil {
 		return err
 	}
+	err = fetchRepo(ctx, gitDir, o, c, logger)
+	release()
+	if err != nil {
+		// Don't record a failed clone if we were cancelled, eg. on shutdown.
+		if ctx.Err() != nil {
+			return err
+		}
+		return &repoStateError{state: index.RepoStateCloneFailed, err: err}
+	}
+
+	if c.maxRepoSizeBytes > 0 {
+		size, err := dirSize(gitDir)
+		if err != nil {
+			return err
+		}
+		if size > c.maxRepoSizeBytes {
+			return &repoStateError{
+				state: index.RepoStateTooLarge,
+				err:   fmt.Errorf("fetched %d bytes, more than the limit of %d bytes", size, c.maxRepoSizeBytes),
+			}
+		}
+	}
 
 	err = setZoektConfig(ctx, gitDir, o, c)
 	if err != nil {
@@ -450,3 +521,23 @@ func tmpGitDir(name string) (string, error) {
 	}
 	return dir, nil
 }
+
+// dirSize returns the total size of the regular files in dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		return nil
+	})
+	return size, err
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt/index"
 )
 
 // indexMutex is the concurrency control we have for operations that operate
@@ -12,6 +17,9 @@ import (
 // specific. A global operation is like a write lock on the whole directory. A
 // repository operation ensure we don't have multiple operations happening for
 // the same repository.
+//
+// If dirLock is set, the operations also exclude those of other processes
+// which share the index directory, eg. other indexservers on NFS.
 type indexMutex struct {
 	// indexMu protects state in index directory. global takes write lock, repo
 	// takes read lock.
@@ -23,6 +31,10 @@ type indexMutex struct {
 	// running maps by name since that is what we key by on disk. Once we start
 	// keying by repo ID on disk, we should switch to uint32.
 	running map[string]struct{}
+
+	// dirLock, if not nil, extends the locks to other processes. It is taken
+	// after indexMu, which serializes the locks of this process.
+	dirLock *index.DirLock
 }
 
 // With runs f if no other f with the same repoName is running. If f runs true
@@ -30,6 +42,8 @@ type indexMutex struct {
 //
 // With blocks if f runs or the Global lock is held.
 func (m *indexMutex) With(repoName string, f func()) bool {
+	start := time.Now()
+
 	m.indexMu.RLock()
 	defer m.indexMu.RUnlock()
 
@@ -54,6 +68,19 @@ func (m *indexMutex) With(repoName string, f func()) bool {
 		m.runningMu.Unlock()
 	}()
 
+	if m.dirLock != nil {
+		unlock, err := m.dirLock.LockRepo(context.Background(), repoName)
+		if errors.Is(err, index.ErrLocked) {
+			metricIndexMutexAlreadyRunning.Inc()
+			return false
+		} else if err != nil {
+			errorLog.Printf("failed to lock %s in the index directory: %v", repoName, err)
+			return false
+		}
+		defer unlock()
+	}
+	metricIndexMutexWait.WithLabelValues("repository").Observe(time.Since(start).Seconds())
+
 	metricIndexMutexRepo.Inc()
 	defer metricIndexMutexRepo.Dec()
 
@@ -64,13 +91,27 @@ func (m *indexMutex) With(repoName string, f func()) bool {
 
 // Global runs f once the global lock is held. IE no other Global or With f's
 // will be running.
+//
+// If dirLock is set and fails, f is not run.
 func (m *indexMutex) Global(f func()) {
 	metricIndexMutexGlobal.Inc()
 	defer metricIndexMutexGlobal.Dec()
 
+	start := time.Now()
+
 	m.indexMu.Lock()
 	defer m.indexMu.Unlock()
 
+	if m.dirLock != nil {
+		unlock, err := m.dirLock.LockGlobal(context.Background())
+		if err != nil {
+			errorLog.Printf("failed to lock the index directory: %v", err)
+			return
+		}
+		defer unlock()
+	}
+	metricIndexMutexWait.WithLabelValues("global").Observe(time.Since(start).Seconds())
+
 	f()
 }
 
@@ -89,4 +130,10 @@ var (
 		Name: "index_mutex_repository",
 		Help: "The number of goroutines successfully holding a repo lock.",
 	})
+
+	metricIndexMutexWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "index_mutex_wait_seconds",
+		Help:    "The time spent waiting for the global or a repo lock, including the lock of the index directory shared with other processes if -lock_index_dir is set.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 12), // 1ms -> ~1.2h
+	}, []string{"lock"})
 )
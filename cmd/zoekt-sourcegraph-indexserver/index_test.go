@@ -20,6 +20,7 @@ import (
 	"github.com/sourcegraph/zoekt/internal/ctags"
 	"github.com/sourcegraph/zoekt/internal/tenant/tenanttest"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -533,7 +534,7 @@ func TestIndexTenant(t *testing.T) {
 				findRepositoryMetadata: findRepositoryMetadata,
 			}
 
-			if err := gitIndex(c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
+			if err := gitIndex(context.Background(), c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
 				t.Fatal(err)
 			}
 			if !cmp.Equal(got, tc.want) {
@@ -614,7 +615,9 @@ func TestIndex(t *testing.T) {
 					{Name: "HEAD", Version: "deadbeef"},
 					{Name: "dev", Version: "feebdaed"}, // ignored for archive
 				},
-				TenantID: 1,
+				IncludePaths: []string{"services/foo/**"},
+				ExcludePaths: []string{"**/testdata/**"},
+				TenantID:     1,
 			},
 		},
 		want: []string{
@@ -632,6 +635,7 @@ func TestIndex(t *testing.T) {
 			"git -C $TMPDIR/test%2Frepo.git config zoekt.tenantID 1",
 			"zoekt-git-index -submodules=false -incremental -branches HEAD,dev " +
 				"-file_limit 123 -parallelism 4 -index /data/index -require_ctags -large_file foo -large_file bar " +
+				"-include_path services/foo/** -exclude_path **/testdata/** " +
 				"$TMPDIR/test%2Frepo.git",
 		},
 	}, {
@@ -709,7 +713,7 @@ func TestIndex(t *testing.T) {
 				findRepositoryMetadata: findRepositoryMetadata,
 			}
 
-			if err := gitIndex(c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
+			if err := gitIndex(context.Background(), c, &tc.args, sourcegraphNop{}, logtest.Scoped(t)); err != nil {
 				t.Fatal(err)
 			}
 			if !cmp.Equal(got, tc.want) {
@@ -781,3 +785,32 @@ git init -b main
 	err = setZoektConfig(context.Background(), filepath.Join(dir, "repo"), &indexArgs{}, c)
 	require.NoError(t, err, string(out))
 }
+
+func TestAcquireFetch(t *testing.T) {
+	var c gitIndexConfig
+	release, err := c.acquireFetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	c.fetchLimit = semaphore.NewWeighted(1)
+	release, err = c.acquireFetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second fetch waits for the first.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.acquireFetch(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v while another fetch runs, want context.DeadlineExceeded", err)
+	}
+
+	release()
+	release, err = c.acquireFetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
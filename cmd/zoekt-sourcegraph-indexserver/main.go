@@ -41,6 +41,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -59,10 +60,12 @@ import (
 	"github.com/sourcegraph/zoekt/internal/debugserver"
 	"github.com/sourcegraph/zoekt/internal/profiler"
 	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/verify"
+	"github.com/sourcegraph/zoekt/internal/webhook"
 
 	"go.uber.org/automaxprocs/maxprocs"
 	"golang.org/x/net/trace"
-	"golang.org/x/sys/unix"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -121,6 +124,23 @@ var (
 		"name",    // the name of the repository that the commits were fetched from
 	})
 
+	metricQueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "index_queue_wait_seconds",
+		Help:    "A histogram of durations from when an index job is added to the queue, to the time a worker picks it up.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 20), // 1 second -> 6 days
+	})
+
+	metricFetchWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "index_fetch_wait_seconds",
+		Help:    "A histogram of the time index jobs wait for a fetch slot, see -fetch_concurrency.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10), // 10ms -> ~43 minutes
+	})
+
+	metricWorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "index_workers_busy",
+		Help: "The number of index workers running a job, out of -index_concurrency.",
+	})
+
 	metricIndexIncrementalIndexState = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "index_incremental_index_state",
 		Help: "A count of the state on disk vs what we want to build. See zoekt/build.IndexState.",
@@ -208,6 +228,18 @@ type Server struct {
 	// If true, shard merging is enabled.
 	shardMerging bool
 
+	// If true, the periodic cleanup of the index directory only reports what
+	// it would change, see /debug/cleanup.
+	cleanupDryRun bool
+
+	// muCleanupReport protects lastCleanupReport.
+	muCleanupReport   sync.Mutex
+	lastCleanupReport *cleanupReport
+
+	// muTombstones serializes changes to the tombstones of the index
+	// directory, see readTombstones.
+	muTombstones sync.Mutex
+
 	// deltaBuildRepositoriesAllowList is an allowlist for repositories that we
 	// use delta-builds for instead of normal builds
 	deltaBuildRepositoriesAllowList map[string]struct{}
@@ -226,6 +258,39 @@ type Server struct {
 
 	// timeout defines how long the index server waits before killing an indexing job.
 	timeout time.Duration
+
+	// maxRepoSizeBytes is the size of a fetched repository above which we
+	// don't index it. It is disabled if zero.
+	maxRepoSizeBytes int64
+
+	// shutdownTimeout is how long Run waits for running index jobs to finish
+	// once it is asked to stop, before it kills them.
+	shutdownTimeout time.Duration
+
+	// debugShardToken authorizes downloads from /debug/shard/. If empty, the
+	// endpoint is disabled.
+	debugShardToken string
+
+	// webhooks is notified of indexing, merging and cleanup. It is nil if no
+	// endpoints are configured.
+	webhooks *webhook.Notifier
+
+	// fetchLimit, if not nil, bounds the number of repositories fetched
+	// concurrently by the index workers, see -fetch_concurrency.
+	fetchLimit *semaphore.Weighted
+
+	// snapshotRetention is how long daily snapshots of the index directory
+	// are kept, see package snapshot. Snapshots are disabled if it is zero.
+	snapshotRetention time.Duration
+
+	// health records the progress of syncing and indexing, see /healthz
+	// and /readyz.
+	health health
+
+	// healthIntervals is the number of Interval without reaching
+	// Sourcegraph or progress of the queue after which /readyz and /healthz
+	// fail.
+	healthIntervals int
 }
 
 var (
@@ -284,7 +349,7 @@ func (s *Server) loggedRun(tr trace.Trace, cmd *exec.Cmd) (err error) {
 			} else {
 				// Send quit (C-\) first so we get a stack dump.
 				infoLog.Printf("no output for %s, quitting %s", noOutputTimeout, cmd.Args)
-				if err := cmd.Process.Signal(unix.SIGQUIT); err != nil {
+				if err := quitProcess(cmd.Process); err != nil {
 					errorLog.Println("quit failed:", err)
 				}
 
@@ -339,28 +404,66 @@ func (sb *synchronizedBuffer) String() string {
 // IndexDir without the indexserver writing to it.
 const pauseFileName = "PAUSE"
 
-// Run the sync loop. This blocks forever.
-func (s *Server) Run() {
+// Run the sync loop. This blocks until ctx is done, after which Run stops
+// updating the queue and starting new index jobs, saves the queue and waits up
+// to s.shutdownTimeout for running jobs before killing them. Run then removes
+// what the jobs left behind and saves the queue again, now including the
+// interrupted jobs. The next call of Run loads it again.
+func (s *Server) Run(ctx context.Context) {
 	removeIncompleteShards(s.IndexDir)
 
-	// Start a goroutine which updates the queue with commits to index.
+	queueState := filepath.Join(s.IndexDir, queueStateFileName)
+	if n, err := s.queue.Load(queueState); err != nil {
+		errorLog.Printf("failed to load queue: %v", err)
+	} else if n > 0 {
+		infoLog.Printf("resuming %d queued repositories from %s", n, queueState)
+	}
+	// Only resume once. If we crash, the next start rebuilds the queue.
+	_ = os.Remove(queueState)
+
+	// Start a goroutine which updates the queue with commits to index. It
+	// stops once ctx is done, so that it doesn't change the queue while we
+	// save it.
+	syncDone := make(chan struct{})
 	go func() {
+		defer close(syncDone)
+
 		// We update the list of indexed repos every Interval. To speed up manual
 		// testing we also listen for SIGUSR1 to trigger updates.
 		//
 		// "pkill -SIGUSR1 zoekt-sourcegra"
-		for range jitterTicker(s.Interval, unix.SIGUSR1) {
+		ticker := jitterTicker(s.Interval, syncSignals...)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker:
+			}
+
 			if b, err := os.ReadFile(filepath.Join(s.IndexDir, pauseFileName)); err == nil {
 				infoLog.Printf("indexserver manually paused via PAUSE file: %s", string(bytes.TrimSpace(b)))
 				continue
 			}
 
-			repos, err := s.Sourcegraph.List(context.Background(), listIndexed(s.IndexDir))
+			repos, err := s.Sourcegraph.List(ctx, listIndexed(s.IndexDir))
+			if ctx.Err() == nil {
+				s.health.markSync(time.Now(), err)
+			}
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				errorLog.Printf("error listing repos: %s", err)
 				continue
 			}
 
+			tombstones, err := readTombstones(s.IndexDir)
+			if err != nil {
+				errorLog.Printf("error reading tombstones: %s", err)
+				continue
+			}
+			repos = withoutTombstones(repos, tombstones)
+
 			debugLog.Printf("updating index queue with %d repositories", len(repos.IDs))
 
 			// Stop indexing repos we don't need to track anymore
@@ -373,9 +476,16 @@ func (s *Server) Run() {
 			cleanupDone := make(chan struct{})
 			go func() {
 				defer close(cleanupDone)
+				var report *cleanupReport
 				s.muIndexDir.Global(func() {
-					cleanup(s.IndexDir, repos.IDs, time.Now(), s.shardMerging)
+					report = cleanup(s.IndexDir, repos.IDs, time.Now(), s.shardMerging, s.cleanupDryRun)
 				})
+				if !report.DryRun {
+					s.notifyCleanup(report)
+				}
+				s.muCleanupReport.Lock()
+				s.lastCleanupReport = report
+				s.muCleanupReport.Unlock()
 			}()
 
 			repos.IterateIndexOptions(s.queue.AddOrUpdate)
@@ -396,7 +506,7 @@ func (s *Server) Run() {
 	}()
 
 	go func() {
-		for range jitterTicker(s.mergeOpts.vacuumInterval, unix.SIGUSR1) {
+		for range jitterTicker(s.mergeOpts.vacuumInterval, syncSignals...) {
 			if s.shardMerging {
 				s.vacuum()
 			}
@@ -404,19 +514,71 @@ func (s *Server) Run() {
 	}()
 
 	go func() {
-		for range jitterTicker(s.mergeOpts.mergeInterval, unix.SIGUSR1) {
+		for range jitterTicker(s.mergeOpts.mergeInterval, syncSignals...) {
 			if s.shardMerging {
 				s.doMerge()
 			}
 		}
 	}()
 
+	if s.snapshotRetention > 0 {
+		go func() {
+			for range jitterTicker(time.Hour) {
+				s.snapshot(time.Now())
+			}
+		}()
+	}
+
+	// jobCtx is only cancelled once the shutdown timeout expired, so that
+	// running index jobs can finish.
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
 	for i := 0; i < s.IndexConcurrency; i++ {
-		go s.processQueue()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.processQueue(ctx, jobCtx)
+		}()
+	}
+
+	<-ctx.Done()
+	<-syncDone
+
+	// Save the queue before we wait for the running jobs, in case we are
+	// killed before they finish. We save it again below with the jobs we
+	// interrupted.
+	s.saveQueue(queueState)
+
+	infoLog.Printf("shutting down: waiting up to %s for running index jobs", s.shutdownTimeout)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.shutdownTimeout):
+		infoLog.Printf("shutting down: killing running index jobs")
+		cancelJobs()
+		<-done
+	}
+
+	removeIncompleteShards(s.IndexDir)
+	if err := os.RemoveAll(filepath.Join(s.IndexDir, tmpDirName)); err != nil {
+		errorLog.Printf("failed to remove tmp dir: %v", err)
 	}
 
-	// block forever
-	select {}
+	s.saveQueue(queueState)
+}
+
+func (s *Server) saveQueue(path string) {
+	if err := s.queue.Save(path); err != nil {
+		errorLog.Printf("failed to save queue: %v", err)
+	} else {
+		infoLog.Printf("saved queue to %s", path)
+	}
 }
 
 // formatList returns a comma-separated list of the first min(len(v), m) items.
@@ -437,28 +599,50 @@ func formatListUint32(v []uint32, m int) string {
 	return strings.TrimRight(sb.String(), ", ")
 }
 
-func (s *Server) processQueue() {
-	for {
+// processQueue runs index jobs until ctx is done. The jobs themselves run
+// with jobCtx.
+func (s *Server) processQueue(ctx context.Context, jobCtx context.Context) {
+	sleep := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+
+	for ctx.Err() == nil {
+		s.health.markProgress(time.Now())
+
 		if _, err := os.Stat(filepath.Join(s.IndexDir, pauseFileName)); err == nil {
-			time.Sleep(time.Second)
+			sleep()
 			continue
 		}
 
 		item, ok := s.queue.Pop()
 		if !ok {
-			time.Sleep(time.Second)
+			sleep()
 			continue
 		}
+		metricQueueWait.Observe(time.Since(item.DateAddedToQueue).Seconds())
 
 		opts := item.Opts
 		args := s.indexArgs(opts)
 
 		ran := s.muIndexDir.With(opts.Name, func() {
+			metricWorkersBusy.Inc()
+			defer metricWorkersBusy.Dec()
+
 			// only record time taken once we hold the lock. This avoids us
 			// recording time taken while merging/cleanup runs.
 			start := time.Now()
 
-			state, err := s.Index(args)
+			state, err := s.Index(jobCtx, args)
+			if err != nil && jobCtx.Err() != nil {
+				// We killed the job during shutdown. Put it back on the queue,
+				// so it is saved and resumed after the restart.
+				infoLog.Printf("interrupted indexing %s", args.String())
+				s.queue.AddOrUpdate(opts)
+				return
+			}
 
 			elapsed := time.Since(start)
 			metricIndexDuration.WithLabelValues(string(state), repoNameForMetric(opts.Name)).Observe(elapsed.Seconds())
@@ -472,6 +656,8 @@ func (s *Server) processQueue() {
 
 			switch state {
 			case indexStateSuccess:
+				s.queue.RecordIndexDuration(opts.RepoID, elapsed)
+
 				var branches []string
 				for _, b := range args.Branches {
 					branches = append(branches, fmt.Sprintf("%s=%s", b.Name, b.Version))
@@ -560,10 +746,11 @@ func jitterTicker(d time.Duration, sig ...os.Signal) <-chan struct{} {
 }
 
 // Index starts an index job for repo name at commit.
-func (s *Server) Index(args *indexArgs) (state indexState, err error) {
+func (s *Server) Index(ctx context.Context, args *indexArgs) (state indexState, err error) {
 	tr := trace.New("index", args.Name)
 	tr.SetMaxEvents(30) // Ensure we capture all indexing events
 
+	start := time.Now()
 	defer func() {
 		if err != nil {
 			tr.SetError()
@@ -573,6 +760,13 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 		}
 		tr.LazyPrintf("state: %s", state)
 		tr.Finish()
+
+		switch state {
+		case indexStateSuccess:
+			s.notifyIndex(webhook.IndexSucceeded, args, time.Since(start), nil)
+		case indexStateFail:
+			s.notifyIndex(webhook.IndexFailed, args, time.Since(start), err)
+		}
 	}()
 
 	// Sourcegraph should always provide a tenant ID.
@@ -583,7 +777,7 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 	tr.LazyPrintf("branches: %v", args.Branches)
 
 	if len(args.Branches) == 0 {
-		return indexStateEmpty, createEmptyShard(args)
+		return indexStateEmpty, recordRepoState(args, index.RepoStateEmpty, "no branches to index")
 	}
 
 	repositoryName := args.Name
@@ -616,8 +810,8 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 		case index.IndexStateMeta:
 			infoLog.Printf("updating index.meta %s", args.String())
 
-			// TODO(stefan) handle mergeMeta for tenant id.
-			if err := mergeMeta(bo); err != nil {
+			// TODO(stefan) handle MergeMeta for tenant id.
+			if err := index.MergeMeta(bo); err != nil {
 				errorLog.Printf("falling back to full update: failed to update index.meta %s: %s", args.String(), err)
 			} else {
 				return indexStateSuccessMeta, nil
@@ -631,6 +825,7 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 	infoLog.Printf("updating index %s reason=%s", args.String(), reason)
 
 	metricIndexingTotal.Inc()
+	s.notifyIndex(webhook.IndexStarted, args, 0, nil)
 	c := gitIndexConfig{
 		runCmd: func(cmd *exec.Cmd) error {
 			return s.loggedRun(tr, cmd)
@@ -639,10 +834,22 @@ func (s *Server) Index(args *indexArgs) (state indexState, err error) {
 		findRepositoryMetadata: func(args *indexArgs) (repository *zoekt.Repository, metadata *zoekt.IndexMetadata, ok bool, err error) {
 			return args.BuildOptions().FindRepositoryMetadata()
 		},
-		timeout: s.timeout,
+		timeout:          s.timeout,
+		maxRepoSizeBytes: s.maxRepoSizeBytes,
+		fetchLimit:       s.fetchLimit,
+	}
+
+	err = gitIndex(ctx, c, args, s.Sourcegraph, s.logger)
+	var stateErr *repoStateError
+	if errors.As(err, &stateErr) {
+		// We keep serving the shards of a repository which failed to clone,
+		// since the failure may well be transient.
+		if stateErr.state != index.RepoStateCloneFailed || len(args.BuildOptions().FindAllShards()) == 0 {
+			if err := recordRepoState(args, stateErr.state, stateErr.err.Error()); err != nil {
+				errorLog.Printf("failed to record state %s for %s: %v", stateErr.state, args.String(), err)
+			}
+		}
 	}
-
-	err = gitIndex(c, args, s.Sourcegraph, s.logger)
 	if err != nil {
 		return indexStateFail, err
 	}
@@ -683,6 +890,53 @@ func updateIndexStatusOnSourcegraph(c gitIndexConfig, args *indexArgs, sg Source
 	return nil
 }
 
+// notifyIndex sends an indexing event for args to the webhooks.
+func (s *Server) notifyIndex(typ webhook.EventType, args *indexArgs, d time.Duration, err error) {
+	e := webhook.Event{
+		Type:            typ,
+		RepoID:          args.RepoID,
+		RepoName:        args.Name,
+		DurationSeconds: d.Seconds(),
+	}
+	for _, b := range args.Branches {
+		e.Branches = append(e.Branches, fmt.Sprintf("%s=%s", b.Name, b.Version))
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.webhooks.Notify(e)
+}
+
+// notifyCleanup sends an event to the webhooks for each repository which
+// cleanup removed, trashed or tombstoned.
+func (s *Server) notifyCleanup(report *cleanupReport) {
+	type key struct {
+		repoID uint32
+		action string
+	}
+	seen := map[key]bool{}
+	for _, e := range report.Entries {
+		switch e.Action {
+		case cleanupActionRemove, cleanupActionTrash, cleanupActionTombstone:
+		default:
+			continue
+		}
+		// Orphaned .tmp files don't belong to a repository.
+		k := key{e.RepoID, e.Action}
+		if e.RepoID == 0 || seen[k] {
+			continue
+		}
+		seen[k] = true
+		s.webhooks.Notify(webhook.Event{
+			Type:     webhook.RepoCleanedUp,
+			RepoID:   e.RepoID,
+			RepoName: e.RepoName,
+			Action:   e.Action,
+			Reason:   e.Reason,
+		})
+	}
+}
+
 func sglogBranches(key string, branches []zoekt.RepositoryBranch) sglog.Field {
 	ss := make([]string, len(branches))
 	for i, b := range branches {
@@ -728,20 +982,12 @@ func (s *Server) parallelism(opts IndexOptions, maxProcs int) int {
 	return parallelism
 }
 
-func createEmptyShard(args *indexArgs) error {
+// recordRepoState records that the repository of args can't be indexed
+// because of state, in place of its shards. See index.RepoState.
+func recordRepoState(args *indexArgs, state index.RepoState, reason string) error {
 	bo := args.BuildOptions()
 	bo.SetDefaults()
-	bo.RepositoryDescription.Branches = []zoekt.RepositoryBranch{{Name: "HEAD", Version: "404aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}}
-
-	if args.Incremental && bo.IncrementalSkipIndexing() {
-		return nil
-	}
-
-	builder, err := index.NewBuilder(*bo)
-	if err != nil {
-		return err
-	}
-	return builder.Finish()
+	return bo.WriteRepoState(state, reason)
 }
 
 // addDebugHandlers adds handlers specific to indexserver.
@@ -750,12 +996,22 @@ func (s *Server) addDebugHandlers(mux *http.ServeMux) {
 	// on "/".
 	mux.Handle("/", http.HandlerFunc(s.handleRoot))
 
+	mux.Handle("/healthz", http.HandlerFunc(s.handleHealthz))
+	mux.Handle("/readyz", http.HandlerFunc(s.handleReadyz))
+
 	mux.Handle("/debug/reindex", http.HandlerFunc(s.handleReindex))
 	mux.Handle("/debug/indexed", http.HandlerFunc(s.handleDebugIndexed))
 	mux.Handle("/debug/list", http.HandlerFunc(s.handleDebugList))
 	mux.Handle("/debug/merge", http.HandlerFunc(s.handleDebugMerge))
 	mux.Handle("/debug/queue", http.HandlerFunc(s.queue.handleDebugQueue))
 	mux.Handle("/debug/host", http.HandlerFunc(s.handleHost))
+	mux.Handle("/debug/cleanup", http.HandlerFunc(s.handleDebugCleanup))
+	mux.Handle("/debug/verify", http.HandlerFunc(s.handleDebugVerify))
+	mux.Handle("GET /debug/shard/{$}", http.HandlerFunc(s.handleDebugShards))
+	mux.Handle("GET /debug/shard/{name}", http.HandlerFunc(s.handleDebugShard))
+	mux.Handle("GET /debug/tombstone/{$}", http.HandlerFunc(s.handleDebugTombstones))
+	mux.Handle("POST /debug/tombstone/{id}", http.HandlerFunc(s.handleDebugTombstone))
+	mux.Handle("DELETE /debug/tombstone/{id}", http.HandlerFunc(s.handleDebugTombstone))
 }
 
 func (s *Server) handleHost(w http.ResponseWriter, r *http.Request) {
@@ -963,6 +1219,70 @@ func (s *Server) handleDebugMerge(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("merging enqueued\n"))
 }
 
+// handleDebugCleanup returns the report of the last cleanup of the index
+// directory as JSON. With ?dry_run=true it only returns the report if the
+// last cleanup was a dry run, see -cleanup_dry_run. The handler never lists
+// repositories or locks the index directory itself; reports are computed by
+// the periodic cleanup.
+func (s *Server) handleDebugCleanup(w http.ResponseWriter, r *http.Request) {
+	s.muCleanupReport.Lock()
+	report := s.lastCleanupReport
+	s.muCleanupReport.Unlock()
+
+	if report == nil {
+		http.Error(w, "cleanup has not run yet", http.StatusNotFound)
+		return
+	}
+
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun && !report.DryRun {
+		http.Error(w, "no dry-run report, start the indexserver with -cleanup_dry_run", http.StatusNotFound)
+		return
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+// handleDebugVerify checks every shard in the index directory, see
+// verify.Dir, and returns the corrupt ones as JSON. With ?quarantine=true they
+// are moved aside, and the next sync of the queue rebuilds their repositories.
+// The index directory is locked meanwhile, so that we don't mistake shards
+// which are being written for corrupt ones.
+func (s *Server) handleDebugVerify(w http.ResponseWriter, r *http.Request) {
+	quarantine, _ := strconv.ParseBool(r.URL.Query().Get("quarantine"))
+
+	var report *verify.Report
+	var err error
+	s.muIndexDir.Global(func() {
+		report, err = verify.Dir(s.IndexDir, quarantine)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, c := range report.Corrupt {
+		if c.Quarantined != "" {
+			infoLog.Printf("quarantined corrupt shard %s: %s", c.Path, c.Error)
+		}
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
 func (s *Server) handleDebugIndexed(w http.ResponseWriter, r *http.Request) {
 	indexed := listIndexed(s.IndexDir)
 
@@ -1029,7 +1349,7 @@ func (s *Server) forceIndex(id uint32) (string, error) {
 
 	var state indexState
 	ran := s.muIndexDir.With(opts.Name, func() {
-		state, err = s.Index(args)
+		state, err = s.Index(context.Background(), args)
 	})
 	if !ran {
 		return fmt.Sprintf("index job for repository already running: %s", args), nil
@@ -1043,16 +1363,29 @@ func (s *Server) forceIndex(id uint32) (string, error) {
 func listIndexed(indexDir string) []uint32 {
 	index := getShards(indexDir)
 	metricNumIndexed.Set(float64(len(index)))
-	repoIDs := make([]uint32, 0, len(index))
+
+	// Repositories which could not be indexed count as indexed, so that we
+	// keep them until we try again.
+	states := getRepoStates(indexDir)
+	repoIDs := make([]uint32, 0, len(index)+len(states))
 	for id := range index {
 		repoIDs = append(repoIDs, id)
 	}
+	for id := range states {
+		if _, ok := index[id]; !ok {
+			repoIDs = append(repoIDs, id)
+		}
+	}
 	sort.Slice(repoIDs, func(i, j int) bool {
 		return repoIDs[i] < repoIDs[j]
 	})
 	return repoIDs
 }
 
+// tmpDirName is the directory in the index directory which the indexserver
+// uses as TMPDIR.
+const tmpDirName = ".indexserver.tmp"
+
 // setupTmpDir sets up a temporary directory on the same volume as the
 // indexes.
 //
@@ -1063,7 +1396,7 @@ func setupTmpDir(logger sglog.Logger, main bool, index string) error {
 	// debug sub command.
 	dir := ".indexserver.debug.tmp"
 	if main {
-		dir = ".indexserver.tmp"
+		dir = tmpDirName
 	}
 
 	tmpRoot := filepath.Join(index, dir)
@@ -1276,22 +1609,70 @@ type rootConfig struct {
 	targetSize          int64
 	minSize             int64
 	minAgeDays          int
+	maxWriteMBps        int64
 
 	// config values related to backoff indexing repos with one or more consecutive failures
 	backoffDuration    time.Duration
 	maxBackoffDuration time.Duration
+
+	// queuePolicy decides which repository is indexed next, see queuePolicy.
+	queuePolicy string
+
+	// cleanupDryRun disables all changes to the index directory by cleanup.
+	cleanupDryRun bool
+
+	// shutdownTimeout is how long we wait for running index jobs on SIGTERM.
+	shutdownTimeout time.Duration
+
+	// maxRepoSize is the size in MiB of a fetched repository above which we
+	// don't index it.
+	maxRepoSize int64
+
+	// debugShardToken enables /debug/shard/, see Server.debugShardToken.
+	debugShardToken string
+
+	// lockIndexDir coordinates writes to the index directory with other
+	// processes, see index.DirLock.
+	lockIndexDir bool
+
+	// webhookURLs is a comma separated list of endpoints for indexing
+	// lifecycle events, see webhook.Notifier.
+	webhookURLs string
+
+	// snapshotRetention enables daily snapshots of the index directory, see
+	// Server.snapshotRetention.
+	snapshotRetention time.Duration
+
+	// fetchConcurrency bounds the concurrent fetches of the index workers,
+	// see Server.fetchLimit.
+	fetchConcurrency int64
+
+	// healthIntervals configures /healthz and /readyz, see
+	// Server.healthIntervals.
+	healthIntervals int
 }
 
 func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
 	fs.StringVar(&rc.root, "sourcegraph_url", os.Getenv("SRC_FRONTEND_INTERNAL"), "http://sourcegraph-frontend-internal or http://localhost:3090. If a path to a directory, we fake the Sourcegraph API and index all repos rooted under path.")
 	fs.DurationVar(&rc.interval, "interval", time.Minute, "sync with sourcegraph this often")
-	fs.Int64Var(&rc.indexConcurrency, "index_concurrency", getEnvWithDefaultInt64("SRC_INDEX_CONCURRENCY", 1), "the number of repos to index concurrently")
+	fs.Int64Var(&rc.indexConcurrency, "index_concurrency", getEnvWithDefaultInt64("SRC_INDEX_CONCURRENCY", 1), "the number of repos to index concurrently. It is capped at the number of CPUs given by -cpu_fraction, and the CPUs are shared between the concurrent jobs. A repository is never indexed by two jobs at once, and merging and cleanup wait for all jobs.")
+	fs.Int64Var(&rc.fetchConcurrency, "fetch_concurrency", getEnvWithDefaultInt64("SRC_FETCH_CONCURRENCY", 0), "if set, the number of repos fetched concurrently by the -index_concurrency jobs, to bound the network and disk IO of fetches. Time spent waiting is reported in index_fetch_wait_seconds.")
 	fs.StringVar(&rc.index, "index", getEnvWithDefaultString("DATA_DIR", index.DefaultDir), "set index directory to use")
 	fs.StringVar(&rc.listen, "listen", ":6072", "listen on this address.")
 	fs.StringVar(&rc.hostname, "hostname", index.HostnameBestEffort(), "the name we advertise to Sourcegraph when asking for the list of repositories to index. Can also be set via the NODE_NAME environment variable.")
 	fs.Float64Var(&rc.cpuFraction, "cpu_fraction", 1.0, "use this fraction of the cores for indexing.")
 	fs.DurationVar(&rc.backoffDuration, "backoff_duration", getEnvWithDefaultDuration("BACKOFF_DURATION", 10*time.Minute), "for the given duration we backoff from enqueue operations for a repository that's failed its previous indexing attempt. Consecutive failures increase the duration of the delay linearly up to the maxBackoffDuration. A negative value disables indexing backoff.")
 	fs.DurationVar(&rc.maxBackoffDuration, "max_backoff_duration", getEnvWithDefaultDuration("MAX_BACKOFF_DURATION", 120*time.Minute), "the maximum duration to backoff from enqueueing a repo for indexing.  A negative value disables indexing backoff.")
+	fs.StringVar(&rc.queuePolicy, "queue_policy", getEnvWithDefaultString("SRC_INDEX_QUEUE_POLICY", string(queuePolicyFIFO)), "the order in which stale repos are indexed: fifo, priority (higher repo priority first) or fair (weigh priority against the average index duration of a repo, so small repos are not starved by monorepos).")
+
+	fs.DurationVar(&rc.shutdownTimeout, "shutdown_timeout", getEnvWithDefaultDuration("SRC_SHUTDOWN_TIMEOUT", 20*time.Second), "on SIGTERM or SIGINT, wait this long for running index jobs to finish before killing them. Keep it below the termination grace period of the container, eg. 30s in Kubernetes. The queue is saved in the index directory and resumed on the next start.")
+	fs.StringVar(&rc.debugShardToken, "debug_shard_token", os.Getenv("SRC_DEBUG_SHARD_TOKEN"), "enables downloading shards from /debug/shard/ with this token, see \"debug fetch\". Shards contain source code, so use a secret token. Disabled if empty.")
+	fs.BoolVar(&rc.lockIndexDir, "lock_index_dir", getEnvWithDefaultBool("SRC_LOCK_INDEX_DIR", false), "lock the index directory with flock(2) while indexing, merging and cleaning up, so that several indexservers can share it, eg. over NFS. Wait times are reported in index_mutex_wait_seconds.")
+	fs.StringVar(&rc.webhookURLs, "webhook_urls", os.Getenv("SRC_INDEX_WEBHOOK_URLS"), "comma separated list of URLs to POST JSON events to when indexing starts, succeeds or fails, when shards are merged and when cleanup removes a repository. Failed deliveries are retried with exponential backoff.")
+	fs.Int64Var(&rc.maxRepoSize, "max_repo_size", getEnvWithDefaultInt64("SRC_MAX_REPO_SIZE", 0), "do not index repositories whose fetched git directory is larger than this many MiB. They are listed with the state \"too-large\" instead. Disabled if 0.")
+	fs.DurationVar(&rc.snapshotRetention, "snapshot_retention", getEnvWithDefaultDuration("SRC_SNAPSHOT_RETENTION", 0), "if set, take a daily snapshot of the index directory in its .snapshots directory and keep it this long, eg. 2160h for 90 days. zoekt-webserver -snapshots searches them with index_at:YYYY-MM-DD. Snapshots hard link the shards, so they only take space for shards which changed since.")
+	fs.IntVar(&rc.healthIntervals, "health_intervals", getEnvWithDefaultInt("SRC_HEALTH_INTERVALS", 10), "/readyz fails if Sourcegraph could not be reached for this many -interval. /healthz and /readyz fail if repositories are queued, but no index job started or finished for this many -interval on top of the indexing timeout.")
+	fs.BoolVar(&rc.cleanupDryRun, "cleanup_dry_run", getEnvWithDefaultBool("SRC_CLEANUP_DRY_RUN", false), "do not delete, trash or tombstone shards of repositories which are no longer assigned to this instance. The changes cleanup would make are logged and listed on /debug/cleanup instead.")
 
 	// flags related to shard merging
 	fs.BoolVar(&rc.disableShardMerging, "shard_merging", getEnvWithDefaultBool("SRC_DISABLE_SHARD_MERGING", false), "disable shard merging")
@@ -1299,6 +1680,7 @@ func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
 	fs.DurationVar(&rc.mergeInterval, "merge_interval", getEnvWithDefaultDuration("SRC_MERGE_INTERVAL", 8*time.Hour), "run merge this often")
 	fs.Int64Var(&rc.targetSize, "merge_target_size", getEnvWithDefaultInt64("SRC_MERGE_TARGET_SIZE", 1000), "the target size of compound shards in MiB")
 	fs.Int64Var(&rc.minSize, "merge_min_size", getEnvWithDefaultInt64("SRC_MERGE_MIN_SIZE", 800), "the minimum size of a compound shard in MiB")
+	fs.Int64Var(&rc.maxWriteMBps, "merge_max_write_mbps", getEnvWithDefaultInt64("SRC_MERGE_MAX_WRITE_MBPS", 0), "the maximum MiB/s written when building a compound shard, so that merging doesn't compete with searches for IO. 0 means no limit.")
 	fs.IntVar(&rc.minAgeDays, "merge_min_age", getEnvWithDefaultInt("SRC_MERGE_MIN_AGE", 7), "the time since the last commit in days. Shards with newer commits are excluded from merging.")
 }
 
@@ -1319,6 +1701,10 @@ func startServer(conf rootConfig) error {
 			{Href: "debug/list?indexed=false", Text: "Assigned (this instance)", Description: "list of all repositories that are assigned to this instance"},
 			{Href: "debug/list?indexed=true", Text: "Assigned (all)", Description: "same as above, but includes repositories which this instance temporarily holds during re-balancing"},
 			{Href: "debug/queue", Text: "Indexing Queue State", Description: "list of all repositories in the indexing queue, sorted by descending priority"},
+			{Href: "debug/cleanup", Text: "Cleanup Report", Description: "shards deleted, trashed or tombstoned by the last cleanup of the index directory"},
+			{Href: "debug/shard/", Text: "Shards", Description: "list the shards in the index directory. Download them from debug/shard/<name> with \"debug fetch\". Requires -debug_shard_token"},
+			{Href: "debug/tombstone/", Text: "Tombstones", Description: "list the repositories hidden from search with \"debug tombstone\""},
+			{Href: "debug/verify", Text: "Verify Shards", Description: "check the checksums of all shards and list the corrupt ones. Add ?quarantine=true to move them aside, so they are rebuilt. Reads every shard in full and pauses indexing meanwhile"},
 		}...)
 		s.addDebugHandlers(mux)
 
@@ -1374,7 +1760,10 @@ func startServer(conf rootConfig) error {
 	c := mountinfo.NewCollector(logger, opts, map[string]string{"indexDir": conf.index})
 	prometheus.DefaultRegisterer.MustRegister(c)
 
-	s.Run()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	s.Run(ctx)
 	return nil
 }
 
@@ -1402,8 +1791,8 @@ func newServer(conf rootConfig) (*Server, error) {
 
 	// Automatically prepend our own path at the front, to minimize
 	// required configuration.
-	if l, err := os.Readlink("/proc/self/exe"); err == nil {
-		os.Setenv("PATH", filepath.Dir(l)+":"+os.Getenv("PATH"))
+	if l, err := os.Executable(); err == nil {
+		os.Setenv("PATH", filepath.Dir(l)+string(filepath.ListSeparator)+os.Getenv("PATH"))
 	}
 
 	if _, err := os.Stat(conf.index); err != nil {
@@ -1487,7 +1876,28 @@ func newServer(conf rootConfig) (*Server, error) {
 		conf.indexConcurrency = int64(cpuCount)
 	}
 
+	policy, err := parseQueuePolicy(conf.queuePolicy)
+	if err != nil {
+		return nil, err
+	}
+
 	q := NewQueue(conf.backoffDuration, conf.maxBackoffDuration, logger)
+	q.policy = policy
+	q.concurrency = int(conf.indexConcurrency)
+
+	// Fetching more repositories at once than we index makes no sense.
+	var fetchLimit *semaphore.Weighted
+	if conf.fetchConcurrency > 0 && conf.fetchConcurrency < conf.indexConcurrency {
+		fetchLimit = semaphore.NewWeighted(conf.fetchConcurrency)
+	}
+
+	var dirLock *index.DirLock
+	if conf.lockIndexDir {
+		dirLock, err = index.NewDirLock(conf.index)
+		if err != nil {
+			return nil, fmt.Errorf("lock_index_dir: %w", err)
+		}
+	}
 
 	return &Server{
 		logger:                            logger,
@@ -1498,6 +1908,7 @@ func newServer(conf rootConfig) (*Server, error) {
 		CPUCount:                          cpuCount,
 		queue:                             *q,
 		shardMerging:                      !conf.disableShardMerging,
+		cleanupDryRun:                     conf.cleanupDryRun,
 		deltaBuildRepositoriesAllowList:   deltaBuildRepositoriesAllowList,
 		deltaShardNumberFallbackThreshold: deltaShardNumberFallbackThreshold,
 		repositoriesSkipSymbolsCalculationAllowList: reposShouldSkipSymbolsCalculation,
@@ -1508,8 +1919,20 @@ func newServer(conf rootConfig) (*Server, error) {
 			targetSizeBytes: conf.targetSize * 1024 * 1024,
 			minSizeBytes:    conf.minSize * 1024 * 1024,
 			minAgeDays:      conf.minAgeDays,
+			maxWriteMBps:    conf.maxWriteMBps,
 		},
-		timeout: indexingTimeout,
+		timeout:          indexingTimeout,
+		maxRepoSizeBytes: conf.maxRepoSize * 1024 * 1024,
+		shutdownTimeout:  conf.shutdownTimeout,
+		debugShardToken:  conf.debugShardToken,
+		muIndexDir:       indexMutex{dirLock: dirLock},
+		webhooks: webhook.New(webhook.Options{
+			Endpoints: webhook.ParseEndpoints(conf.webhookURLs),
+			Hostname:  conf.hostname,
+		}),
+		snapshotRetention: conf.snapshotRetention,
+		fetchLimit:        fetchLimit,
+		healthIntervals:   conf.healthIntervals,
 	}, err
 }
 
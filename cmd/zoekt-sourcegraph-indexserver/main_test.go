@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	sglog "github.com/sourcegraph/log"
 	"github.com/sourcegraph/log/logtest"
@@ -20,10 +26,12 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
-	"github.com/sourcegraph/zoekt"
 	proto "github.com/sourcegraph/zoekt/cmd/zoekt-sourcegraph-indexserver/protos/sourcegraph/zoekt/configuration/v1"
+	"github.com/sourcegraph/zoekt/index"
 	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/webhook"
 )
 
 func TestServer_defaultArgs(t *testing.T) {
@@ -55,10 +63,99 @@ func TestServer_defaultArgs(t *testing.T) {
 
 func TestIndexNoTenant(t *testing.T) {
 	s := &Server{}
-	_, err := s.Index(&indexArgs{})
+	_, err := s.Index(context.Background(), &indexArgs{})
 	require.ErrorIs(t, err, tenant.ErrMissingTenant)
 }
 
+func TestServer_webhooks(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []webhook.Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		got = append(got, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s := &Server{webhooks: webhook.New(webhook.Options{Endpoints: []string{srv.URL}})}
+	_, _ = s.Index(context.Background(), &indexArgs{IndexOptions: IndexOptions{RepoID: 1, Name: "a"}})
+	s.notifyCleanup(&cleanupReport{Entries: []cleanupEntry{
+		{Action: cleanupActionTrash, Reason: cleanupReasonUnassigned, RepoID: 2, RepoName: "b", Path: "b_v16.00000.zoekt"},
+		{Action: cleanupActionTrash, Reason: cleanupReasonUnassigned, RepoID: 2, RepoName: "b", Path: "b_v16.00001.zoekt"},
+		{Action: cleanupActionRestore, Reason: cleanupReasonAssigned, RepoID: 3, RepoName: "c", Path: "c_v16.00000.zoekt"},
+		{Action: cleanupActionRemove, Reason: cleanupReasonOrphanedTmp, Path: "x.tmp"},
+	}})
+	s.webhooks.Close()
+
+	want := []webhook.Event{
+		{Type: webhook.IndexFailed, RepoID: 1, RepoName: "a", Error: tenant.ErrMissingTenant.Error()},
+		{Type: webhook.RepoCleanedUp, RepoID: 2, RepoName: "b", Action: cleanupActionTrash, Reason: cleanupReasonUnassigned},
+	}
+	if d := cmp.Diff(want, got, cmpopts.IgnoreFields(webhook.Event{}, "Time", "DurationSeconds")); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestServer_processQueueShutdown(t *testing.T) {
+	s := &Server{
+		logger:           logtest.Scoped(t),
+		IndexDir:         t.TempDir(),
+		IndexConcurrency: 1,
+		CPUCount:         1,
+		queue:            *NewQueue(time.Millisecond, time.Millisecond, logtest.Scoped(t)),
+		timeout:          time.Minute,
+	}
+	opts := mkHEADIndexOptions(1, "deadbeef")
+	opts.TenantID = 1
+	s.queue.AddOrUpdate(opts)
+
+	// Every job is interrupted, as if the shutdown timeout expired.
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	cancelJobs()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.processQueue(ctx, jobCtx)
+	}()
+
+	// Wait until the interrupted job was put back on the queue.
+	for {
+		s.queue.mu.Lock()
+		requeued := s.queue.items[1].seq > 1
+		s.queue.mu.Unlock()
+		if requeued {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("processQueue did not return after ctx was cancelled")
+	}
+
+	// Interrupted jobs are not failures, so they are resumed after a restart.
+	s.queue.mu.Lock()
+	item := s.queue.items[1]
+	s.queue.mu.Unlock()
+	if item.indexState == indexStateFail {
+		t.Fatal("interrupted job was marked as failed")
+	}
+	if s.queue.Len() != 1 {
+		t.Fatalf("got queue length %d, want 1", s.queue.Len())
+	}
+}
+
 func TestServer_parallelism(t *testing.T) {
 	root, err := url.Parse("http://api.test")
 	if err != nil {
@@ -215,7 +312,7 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestCreateEmptyShard(t *testing.T) {
+func TestRecordRepoState(t *testing.T) {
 	dir := t.TempDir()
 
 	args := &indexArgs{
@@ -230,15 +327,23 @@ func TestCreateEmptyShard(t *testing.T) {
 		FileLimit:   1,
 	}
 
-	if err := createEmptyShard(args); err != nil {
+	if err := recordRepoState(args, index.RepoStateEmpty, "no branches to index"); err != nil {
 		t.Fatal(err)
 	}
 
 	bo := args.BuildOptions()
-	bo.RepositoryDescription.Branches = []zoekt.RepositoryBranch{{Name: "HEAD", Version: "404aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}}
-
-	if got := bo.IncrementalSkipIndexing(); !got {
-		t.Fatalf("wanted %t, got %t", true, got)
+	if shards := bo.FindAllShards(); len(shards) != 0 {
+		t.Fatalf("got shards %v for an empty repo, want none", shards)
+	}
+	sf, err := index.ReadRepoState(bo.RepoStatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.State != index.RepoStateEmpty || sf.Repository.ID != 7 {
+		t.Fatalf("unexpected state %+v", sf)
+	}
+	if got := listIndexed(dir); !reflect.DeepEqual(got, []uint32{7}) {
+		t.Fatalf("got indexed %v, want [7]", got)
 	}
 }
 
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// syncSignals trigger a sync with Sourcegraph, a merge and a vacuum right
+// away, eg. "pkill -SIGUSR1 zoekt-sourcegra".
+var syncSignals = []os.Signal{unix.SIGUSR1}
+
+// quitProcess asks p to quit with a dump of its goroutines.
+func quitProcess(p *os.Process) error {
+	return p.Signal(unix.SIGQUIT)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+)
+
+// syncSignals is empty, since Windows has no user defined signals.
+var syncSignals []os.Signal
+
+// quitProcess does nothing. Windows can't ask p for a dump of its
+// goroutines, so we leave it to the kill which follows.
+func quitProcess(p *os.Process) error {
+	return nil
+}
@@ -2,16 +2,20 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/grafana/regexp"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/merging"
+	"github.com/sourcegraph/zoekt/internal/webhook"
 	"go.uber.org/atomic"
 )
 
@@ -45,9 +49,10 @@ func defaultMergeCmd(args ...string) *exec.Cmd {
 	return cmd
 }
 
-// doMerge drives the merge process. It holds the lock on s.indexDir for the
-// duration of 1 merge, which might be several minutes, depending on the target
-// size of the compound shard.
+// doMerge drives the merge process. Compound shards are built in a temporary
+// directory without holding the lock on s.indexDir, which might take several
+// minutes depending on the target size of the compound shard. The lock is only
+// held to swap a compound shard in for its simple shards.
 func (s *Server) doMerge() {
 	s.merge(defaultMergeCmd)
 }
@@ -65,51 +70,112 @@ func (s *Server) merge(mergeCmd func(args ...string) *exec.Cmd) {
 	metricShardMergingRunning.Set(1)
 	defer metricShardMergingRunning.Set(0)
 
+	// Remove compound shards left over by a merge which didn't finish.
+	tmpDir := filepath.Join(s.IndexDir, ".merge")
+	if err := os.RemoveAll(tmpDir); err != nil {
+		errorLog.Printf("failed to remove merge directory %s: %s", tmpDir, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
 	// We keep creating compound shards until we run out of shards to merge or until
 	// we encounter an error during merging.
-	next := true
-	for next {
-		next = false
-		s.muIndexDir.Global(func() {
-			candidates, excluded := loadCandidates(s.IndexDir, s.mergeOpts)
-			infoLog.Printf("loadCandidates: candidates=%d excluded=%d", len(candidates), excluded)
+	for s.mergeOnce(mergeCmd, tmpDir) {
+	}
+}
 
-			c := pickCandidates(candidates, s.mergeOpts.targetSizeBytes)
-			if len(c.shards) <= 1 {
-				infoLog.Printf("could not find enough shards to build a compound shard")
-				return
-			}
-			infoLog.Printf("start merging: shards=%d total_size=%.2fMiB", len(c.shards), float64(c.size)/(1024*1024))
+// mergeOnce builds 1 compound shard in tmpDir and swaps it in for its simple
+// shards. It returns true if it merged shards.
+func (s *Server) mergeOnce(mergeCmd func(args ...string) *exec.Cmd, tmpDir string) bool {
+	var c compound
+	s.muIndexDir.Global(func() {
+		candidates, excluded := loadCandidates(s.IndexDir, s.mergeOpts)
+		infoLog.Printf("loadCandidates: candidates=%d excluded=%d", len(candidates), excluded)
+		c = pickCandidates(candidates, s.mergeOpts.targetSizeBytes)
+	})
+	if len(c.shards) <= 1 {
+		infoLog.Printf("could not find enough shards to build a compound shard")
+		return false
+	}
+	infoLog.Printf("start merging: shards=%d total_size=%.2fMiB", len(c.shards), float64(c.size)/(1024*1024))
 
-			var paths []string
-			for _, p := range c.shards {
-				paths = append(paths, p.path)
-			}
+	args := []string{"-tmp_dir", tmpDir, "-max_write_mbps", strconv.FormatInt(s.mergeOpts.maxWriteMBps, 10)}
+	for _, p := range c.shards {
+		args = append(args, p.path)
+	}
 
-			start := time.Now()
+	start := time.Now()
 
-			cmd := mergeCmd(paths...)
+	cmd := mergeCmd(args...)
 
-			// zoekt-merge-index writes the full path of the new compound shard to stdout.
-			stdoutBuf := &bytes.Buffer{}
-			stderrBuf := &bytes.Buffer{}
-			cmd.Stdout = stdoutBuf
-			cmd.Stderr = stderrBuf
+	// zoekt-merge-index writes the full path of the new compound shard to stdout.
+	stdoutBuf := &bytes.Buffer{}
+	stderrBuf := &bytes.Buffer{}
+	cmd.Stdout = stdoutBuf
+	cmd.Stderr = stderrBuf
 
-			err := cmd.Run()
+	err := cmd.Run()
+	if err == nil {
+		s.muIndexDir.Global(func() {
+			err = swapCompound(strings.TrimSpace(stdoutBuf.String()), s.IndexDir, c)
+		})
+	}
 
-			durationSeconds := time.Since(start).Seconds()
-			metricShardMergingDuration.WithLabelValues(strconv.FormatBool(err != nil)).Observe(durationSeconds)
-			if err != nil {
-				errorLog.Printf("error merging shards: stdout=%s, stderr=%s, durationSeconds=%.2f err=%s", stdoutBuf.String(), stderrBuf.String(), durationSeconds, err)
-				return
-			}
+	durationSeconds := time.Since(start).Seconds()
+	metricShardMergingDuration.WithLabelValues(strconv.FormatBool(err != nil)).Observe(durationSeconds)
+	if err != nil {
+		errorLog.Printf("error merging shards: stdout=%s, stderr=%s, durationSeconds=%.2f err=%s", stdoutBuf.String(), stderrBuf.String(), durationSeconds, err)
+		return false
+	}
 
-			infoLog.Printf("finished merging: shard=%s durationSeconds=%.2f", stdoutBuf.String(), durationSeconds)
+	shard := filepath.Join(s.IndexDir, filepath.Base(strings.TrimSpace(stdoutBuf.String())))
+	infoLog.Printf("finished merging: shard=%s durationSeconds=%.2f", shard, durationSeconds)
+	s.webhooks.Notify(webhook.Event{
+		Type:            webhook.ShardMerged,
+		Shard:           shard,
+		DurationSeconds: durationSeconds,
+	})
 
-			next = true
-		})
+	return true
+}
+
+// swapCompound moves the compound shard at path to dir and removes the simple
+// shards of c it replaces. The caller must hold the global lock on dir.
+//
+// The compound shard is renamed into place before the simple shards are
+// removed, so that the repositories are searchable throughout. The shards
+// watcher of the webserver loads the compound shard and drops the simple
+// shards together, see shards.DirectoryWatcher.
+//
+// If a simple shard or its .meta sidecar changed while the compound shard
+// was built, eg. because its repository was reindexed or its metadata was
+// updated, the compound shard is stale and we remove it instead.
+func swapCompound(path, dir string, c compound) error {
+	for _, cand := range c.shards {
+		files, err := statShardFiles(cand.path)
+		if err != nil || !maps.EqualFunc(files, cand.files, fileState.equal) {
+			_ = os.Remove(path)
+			return fmt.Errorf("shard %s changed while merging", cand.path)
+		}
+	}
+
+	if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("failed to move compound shard: %w", err)
+	}
+
+	for _, cand := range c.shards {
+		paths, err := index.IndexFilePaths(cand.path)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil {
+				return fmt.Errorf("failed to remove simple shard: %w", err)
+			}
+		}
 	}
+	return nil
 }
 
 type candidate struct {
@@ -117,6 +183,39 @@ type candidate struct {
 
 	// The size as reported by os.Stat.
 	sizeBytes int64
+
+	// files is the state of the shard and its .meta sidecar when we picked
+	// it, see statShardFiles.
+	files map[string]fileState
+}
+
+// fileState is the size and modification time of a file as reported by
+// os.Stat.
+type fileState struct {
+	sizeBytes int64
+	modTime   time.Time
+}
+
+func (s fileState) equal(o fileState) bool {
+	return s.sizeBytes == o.sizeBytes && s.modTime.Equal(o.modTime)
+}
+
+// statShardFiles returns the state of each file of the shard at path, see
+// index.IndexFilePaths.
+func statShardFiles(path string) (map[string]fileState, error) {
+	paths, err := index.IndexFilePaths(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]fileState, len(paths))
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		files[p] = fileState{sizeBytes: fi.Size(), modTime: fi.ModTime()}
+	}
+	return files, nil
 }
 
 // loadCandidates returns all shards eligible for merging.
@@ -150,25 +249,21 @@ func loadCandidates(dir string, opts mergeOpts) ([]candidate, int) {
 			continue
 		}
 
+		files, err := statShardFiles(path)
+		if err != nil {
+			debugLog.Printf("stat failed for %s: %s", n, err)
+			continue
+		}
+
 		candidates = append(candidates, candidate{
 			path:      path,
 			sizeBytes: fi.Size(),
+			files:     files,
 		})
 	}
 	return candidates, excluded
 }
 
-var reShard = regexp.MustCompile(`\.[0-9]{5}\.zoekt$`)
-
-func hasMultipleShards(path string) bool {
-	if !reShard.MatchString(path) {
-		return false
-	}
-	secondShard := reShard.ReplaceAllString(path, ".00001.zoekt")
-	_, err := os.Stat(secondShard)
-	return !os.IsNotExist(err)
-}
-
 type mergeOpts struct {
 	// targetSizeBytes is the target size in bytes for compound shards. The higher
 	// the value the more repositories a compound shard will contain and the bigger
@@ -191,6 +286,11 @@ type mergeOpts struct {
 	// merging. For example, a value of 7 means that only repos that have been
 	// inactive for 7 days will be considered for merging.
 	minAgeDays int
+
+	// maxWriteMBps limits how many MiB/s zoekt-merge-index writes when
+	// building a compound shard, so that merging doesn't compete with
+	// searches for IO. 0 means no limit.
+	maxWriteMBps int64
 }
 
 // isExcluded returns true if a shard should not be merged, false otherwise.
@@ -198,30 +298,11 @@ type mergeOpts struct {
 // We need path and FileInfo because FileInfo does not contain the full path, see
 // discussion here https://github.com/golang/go/issues/32300.
 func isExcluded(path string, fi os.FileInfo, opts mergeOpts) bool {
-	if hasMultipleShards(path) {
-		return true
-	}
-
-	repos, _, err := index.ReadMetadataPath(path)
+	_, ok, err := merging.Eligible(path, opts.minAgeDays)
 	if err != nil {
 		debugLog.Printf("failed to load metadata for %s\n", fi.Name())
-		return true
-	}
-
-	// Exclude compound shards from being merge targets. Why? We want repositories in a
-	// compound shard to be ordered based on their priority. The easiest way to
-	// enforce this is to delete the compound shard once it drops below a certain
-	// size (handled by cleanup), reindex the repositories and merge them with other
-	// shards in the correct order.
-	if len(repos) > 1 {
-		return true
 	}
-
-	if repos[0].LatestCommitDate.After(time.Now().AddDate(0, 0, -opts.minAgeDays)) {
-		return true
-	}
-
-	return false
+	return !ok
 }
 
 type compound struct {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha1"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -14,36 +15,6 @@ import (
 	"github.com/sourcegraph/zoekt/index"
 )
 
-func TestHasMultipleShards(t *testing.T) {
-	dir := t.TempDir()
-
-	cases := []struct {
-		file                  string
-		wantHasMultipleShards bool
-	}{
-		{"large.00000.zoekt", true},
-		{"large.00001.zoekt", true},
-		{"small.00000.zoekt", false},
-		{"compound-foo.00000.zoekt", false},
-		{"else", false},
-	}
-
-	for _, c := range cases {
-		_, err := os.Create(filepath.Join(dir, c.file))
-		if err != nil {
-			t.Fatal(err)
-		}
-	}
-
-	for _, tt := range cases {
-		t.Run(tt.file, func(t *testing.T) {
-			if got := hasMultipleShards(filepath.Join(dir, tt.file)); got != tt.wantHasMultipleShards {
-				t.Fatalf("want %t, got %t", tt.wantHasMultipleShards, got)
-			}
-		})
-	}
-}
-
 func TestDoNotDeleteSingleShards(t *testing.T) {
 	dir := t.TempDir()
 
@@ -99,16 +70,21 @@ func TestCallMerge(t *testing.T) {
 		args = args[1:]
 	}
 
-	// We mock the merge process by deleting the input shards and creating an empty
-	// compound shard with a proper name.
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	tmpDir := fs.String("tmp_dir", "", "")
+	_ = fs.Int64("max_write_mbps", 0, "")
+	_ = fs.Parse(args)
+
+	// We mock the merge process by creating an empty compound shard with a
+	// proper name in the temporary directory.
 	h := sha1.New()
-	for _, a := range args {
+	for _, a := range fs.Args() {
 		h.Write([]byte(filepath.Base(a)))
 		h.Write([]byte{0})
-		_ = os.Remove(a)
 	}
 
-	compoundShardName := filepath.Join(filepath.Dir(args[1]), fmt.Sprintf("compound-%x_v%d.%05d.zoekt", h.Sum(nil), 17, 0))
+	_ = os.MkdirAll(*tmpDir, 0o755)
+	compoundShardName := filepath.Join(*tmpDir, fmt.Sprintf("compound-%x_v%d.%05d.zoekt", h.Sum(nil), 17, 0))
 	f, _ := os.Create(compoundShardName)
 	_ = f.Close()
 
@@ -227,3 +203,60 @@ func copyFile(src, dst string) (err error) {
 	}
 	return d.Close()
 }
+
+func TestSwapCompoundChanged(t *testing.T) {
+	for name, change := range map[string]func(shard string) error{
+		// The repository of the shard was reindexed while merging.
+		"shard": func(shard string) error {
+			return os.WriteFile(shard, []byte("reindexed"), 0o644)
+		},
+		// The metadata of the shard was updated while merging.
+		"sidecar": func(shard string) error {
+			return os.WriteFile(shard+".meta", []byte(`{"Name":"a"}`), 0o644)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			tmpDir := filepath.Join(dir, ".merge")
+			if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			var c compound
+			for _, name := range []string{"a_v16.00000.zoekt", "b_v16.00000.zoekt"} {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("simple"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				files, err := statShardFiles(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.add(candidate{path: path, sizeBytes: files[path].sizeBytes, files: files})
+			}
+			staged := filepath.Join(tmpDir, "compound-ab_v17.00000.zoekt")
+			if err := os.WriteFile(staged, []byte("compound"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := change(c.shards[0].path); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := swapCompound(staged, dir, c); err == nil {
+				t.Fatal("expected an error for a changed shard")
+			}
+			if _, err := os.Stat(staged); !os.IsNotExist(err) {
+				t.Errorf("stale compound shard was not removed: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, filepath.Base(staged))); !os.IsNotExist(err) {
+				t.Errorf("stale compound shard was swapped in: %v", err)
+			}
+			for _, cand := range c.shards {
+				if _, err := os.Stat(cand.path); err != nil {
+					t.Errorf("simple shard was removed: %v", err)
+				}
+			}
+		})
+	}
+}
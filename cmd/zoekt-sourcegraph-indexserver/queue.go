@@ -3,9 +3,15 @@ package main
 import (
 	"bytes"
 	"container/heap"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -40,14 +46,51 @@ type queueItem struct {
 	dateAddedToQueue time.Time
 	// backoff will handle backing off of future indexing requests for a duration of time based on previous failures
 	backoff backoff
+	// avgIndexDuration is a moving average of how long indexing repoID took.
+	// It is 0 if we haven't indexed repoID yet.
+	avgIndexDuration time.Duration
+	// rank orders items which are otherwise equally urgent, lower is more
+	// urgent. It is computed by the queue's policy whenever the item is pushed
+	// or updated.
+	rank float64
+}
+
+// queuePolicy decides which of the repositories that need indexing is popped
+// next.
+type queuePolicy string
+
+const (
+	// queuePolicyFIFO indexes repositories in the order they were enqueued.
+	queuePolicyFIFO queuePolicy = "fifo"
+
+	// queuePolicyPriority indexes repositories with a higher
+	// IndexOptions.Priority first, and in FIFO order among equal priorities.
+	queuePolicyPriority queuePolicy = "priority"
+
+	// queuePolicyFairShare orders repositories by a virtual deadline: the time
+	// they were enqueued plus their average index duration, scaled down by
+	// their priority. Small or important repositories overtake a monorepo
+	// enqueued at about the same time, but since the deadline is fixed once
+	// enqueued, no repository is starved.
+	queuePolicyFairShare queuePolicy = "fair"
+)
+
+func parseQueuePolicy(s string) (queuePolicy, error) {
+	switch p := queuePolicy(s); p {
+	case queuePolicyFIFO, queuePolicyPriority, queuePolicyFairShare:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown queue policy %q, want one of fifo, priority or fair", s)
+	}
 }
 
 // Queue is a priority queue which returns the next repo to index. It is safe
 // to use concurrently. It is a min queue on:
 //
-//	(!indexed, time added to the queue)
+//	(!indexed, failed, rank, time added to the queue)
 //
-// We use the above since we'd rather index a repo sooner if we know the commit is stale.
+// We use the above since we'd rather index a repo sooner if we know the commit
+// is stale. The rank is determined by the queue's policy.
 type Queue struct {
 	mu           sync.Mutex
 	items        map[uint32]*queueItem
@@ -55,6 +98,14 @@ type Queue struct {
 	seq          int64
 	logger       sglog.Logger
 	newQueueItem func(uint32) *queueItem
+
+	// policy orders items which are otherwise equally urgent. Defaults to
+	// queuePolicyFIFO.
+	policy queuePolicy
+
+	// concurrency is the number of repositories indexed at once. It is used to
+	// estimate when queued repositories will start indexing.
+	concurrency int
 }
 
 func NewQueue(backoffDuration, maxBackoffDuration time.Duration, l sglog.Logger) *Queue {
@@ -79,6 +130,8 @@ func NewQueue(backoffDuration, maxBackoffDuration time.Duration, l sglog.Logger)
 		seq:          0,
 		logger:       l.Scoped("queue"),
 		newQueueItem: newQueueItem,
+		policy:       queuePolicyFIFO,
+		concurrency:  1,
 	}
 }
 
@@ -129,15 +182,10 @@ func (q *Queue) AddOrUpdate(opts IndexOptions) {
 	}
 	if item.heapIdx < 0 {
 		if item.backoff.Allow(time.Now()) {
-			q.seq++
-			item.seq = q.seq
-			item.dateAddedToQueue = time.Now()
-
-			heap.Push(&q.pq, item)
-			metricQueueLen.Set(float64(len(q.pq)))
-			metricQueueCap.Set(float64(len(q.items)))
+			q.push(item)
 		}
 	} else {
+		item.rank = q.rank(item)
 		heap.Fix(&q.pq, item.heapIdx)
 	}
 	q.mu.Unlock()
@@ -157,13 +205,7 @@ func (q *Queue) Bump(ids []uint32) []uint32 {
 			missing = append(missing, id)
 		} else if item.heapIdx < 0 {
 			if item.backoff.Allow(time.Now()) {
-				q.seq++
-				item.seq = q.seq
-				item.dateAddedToQueue = time.Now()
-
-				heap.Push(&q.pq, item)
-				metricQueueLen.Set(float64(len(q.pq)))
-				metricQueueCap.Set(float64(len(q.items)))
+				q.push(item)
 			}
 		}
 	}
@@ -171,6 +213,61 @@ func (q *Queue) Bump(ids []uint32) []uint32 {
 	return missing
 }
 
+// push adds item to the heap.
+//
+// Note: push requires that q.mu is held.
+func (q *Queue) push(item *queueItem) {
+	q.seq++
+	item.seq = q.seq
+	item.dateAddedToQueue = time.Now()
+	item.rank = q.rank(item)
+
+	heap.Push(&q.pq, item)
+	metricQueueLen.Set(float64(len(q.pq)))
+	metricQueueCap.Set(float64(len(q.items)))
+}
+
+// rank returns the rank of item according to q.policy. See queueItem.rank.
+//
+// Note: rank requires that q.mu is held.
+func (q *Queue) rank(item *queueItem) float64 {
+	switch q.policy {
+	case queuePolicyPriority:
+		return -item.opts.Priority
+	case queuePolicyFairShare:
+		// Priorities are unbounded (eg. star counts), so we dampen them.
+		weight := 1 + math.Log1p(math.Max(item.opts.Priority, 0))
+		expected := time.Duration(float64(item.avgIndexDuration) / weight)
+		return float64(item.dateAddedToQueue.Add(expected).UnixNano())
+	default:
+		return 0
+	}
+}
+
+// indexDurationAlpha is the weight of the latest sample in the moving average
+// of index durations.
+const indexDurationAlpha = 0.3
+
+// RecordIndexDuration records how long indexing repoID took. The queue keeps
+// a moving average per repository, which is used by the fair share policy and
+// to estimate start times in debug/queue.
+func (q *Queue) RecordIndexDuration(repoID uint32, d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := q.getOrAdd(repoID)
+	if item.avgIndexDuration == 0 {
+		item.avgIndexDuration = d
+	} else {
+		item.avgIndexDuration += time.Duration(indexDurationAlpha * float64(d-item.avgIndexDuration))
+	}
+
+	if item.heapIdx >= 0 {
+		item.rank = q.rank(item)
+		heap.Fix(&q.pq, item.heapIdx)
+	}
+}
+
 // Iterate will call f on each item known to the queue, including items that
 // have been popped from the queue. Note: this is done in a random order and
 // the queue mutex is held during all calls to f. Do not mutate the data.
@@ -223,7 +320,7 @@ func (q *Queue) handleDebugQueue(w http.ResponseWriter, r *http.Request) {
 
 	writer := tabwriter.NewWriter(&bufferedWriter, 16, 8, 4, ' ', 0)
 
-	_, err := fmt.Fprintf(writer, "Position\tName\tID\tIsOnQueue\tAge\tBranches\t\n")
+	_, err := fmt.Fprintf(writer, "Position\tName\tID\tIsOnQueue\tAge\tAvgDuration\tExpectedStart\tBranches\t\n")
 	if err != nil {
 		http.Error(w, fmt.Sprintf("writing column headers: %s", err), http.StatusInternalServerError)
 		return
@@ -231,6 +328,15 @@ func (q *Queue) handleDebugQueue(w http.ResponseWriter, r *http.Request) {
 
 	now := time.Now()
 
+	// workers holds the time from now at which each worker is expected to be
+	// free. We ignore jobs which are currently running.
+	workers := make([]time.Duration, max(q.concurrency, 1))
+
+	// defaultCost is used for repositories we haven't indexed yet.
+	q.mu.Lock()
+	defaultCost := q.meanIndexDuration()
+	q.mu.Unlock()
+
 	position := -1
 	q.debugIteratedOrdered(func(item *queueItem) {
 		position++
@@ -246,11 +352,32 @@ func (q *Queue) handleDebugQueue(w http.ResponseWriter, r *http.Request) {
 
 		isOnQueue := item.heapIdx >= 0
 		age := "-"
+		expectedStart := "-"
 		if isOnQueue {
 			age = now.Sub(item.dateAddedToQueue).Round(time.Second).String()
+
+			// Assign the item to the worker which is free first.
+			w := 0
+			for i := range workers {
+				if workers[i] < workers[w] {
+					w = i
+				}
+			}
+			expectedStart = workers[w].Round(time.Second).String()
+
+			cost := item.avgIndexDuration
+			if cost == 0 {
+				cost = defaultCost
+			}
+			workers[w] += cost
 		}
 
-		_, err = fmt.Fprintf(writer, "%d\t%s\t%d\t%t\t%s\t%s\n", position, item.opts.Name, item.repoID, isOnQueue, age, strings.Join(branches, ", "))
+		avgDuration := "-"
+		if item.avgIndexDuration > 0 {
+			avgDuration = item.avgIndexDuration.Round(time.Second).String()
+		}
+
+		_, err = fmt.Fprintf(writer, "%d\t%s\t%d\t%t\t%s\t%s\t%s\t%s\n", position, item.opts.Name, item.repoID, isOnQueue, age, avgDuration, expectedStart, strings.Join(branches, ", "))
 	})
 
 	if err != nil {
@@ -273,6 +400,25 @@ func (q *Queue) handleDebugQueue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// meanIndexDuration returns the mean of the average index durations of all
+// repositories we have indexed, or 0 if we haven't indexed any yet.
+//
+// Note: meanIndexDuration requires that q.mu is held.
+func (q *Queue) meanIndexDuration() time.Duration {
+	var sum time.Duration
+	n := 0
+	for _, item := range q.items {
+		if item.avgIndexDuration > 0 {
+			sum += item.avgIndexDuration
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / time.Duration(n)
+}
+
 // SetIndexed sets what the currently indexed options are for opts.RepoID.
 func (q *Queue) SetIndexed(opts IndexOptions, state indexState) {
 	q.mu.Lock()
@@ -351,6 +497,91 @@ func (q *Queue) MaybeRemoveMissing(ids []uint32) []uint32 {
 	return removed
 }
 
+// queueStateFileName is the file in the index directory in which the queue is
+// persisted on shutdown. See Queue.Save.
+const queueStateFileName = ".indexserver.queue.json"
+
+// queueState is the on disk representation of a Queue.
+type queueState struct {
+	// Queued are the repositories which still need to be indexed, in the
+	// order they would have been popped.
+	Queued []IndexOptions
+
+	// Indexed are the remaining repositories known to the queue.
+	Indexed []IndexOptions
+}
+
+// Save writes the repositories known to the queue to path. A restarted
+// indexserver can use Load to resume indexing before its first sync with
+// Sourcegraph, instead of rebuilding the queue from scratch.
+func (q *Queue) Save(path string) error {
+	var state queueState
+	q.debugIteratedOrdered(func(item *queueItem) {
+		if item.heapIdx >= 0 || !item.indexed {
+			state.Queued = append(state.Queued, item.opts)
+		} else {
+			state.Indexed = append(state.Indexed, item.opts)
+		}
+	})
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so we never load a partial state.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load adds the repositories saved by Save at path to the queue. Queued
+// repositories are pushed in their saved order, the others are only
+// remembered so that Bump does not report them as missing. Load returns the
+// number of queued repositories. It is not an error if path does not exist.
+func (q *Queue) Load(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var state queueState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return 0, fmt.Errorf("invalid queue state %s: %w", path, err)
+	}
+
+	for _, opts := range state.Queued {
+		q.AddOrUpdate(opts)
+	}
+
+	q.mu.Lock()
+	for _, opts := range state.Indexed {
+		if _, ok := q.items[opts.RepoID]; ok {
+			continue
+		}
+		item := q.getOrAdd(opts.RepoID)
+		item.opts = opts
+		item.indexed = true
+	}
+	metricQueueCap.Set(float64(len(q.items)))
+	q.mu.Unlock()
+
+	return len(state.Queued), nil
+}
+
 // getOrAdd returns the item for repoID. If the repoID hasn't been seen before, it
 // is added to q.items.
 //
@@ -419,6 +650,10 @@ func lessQueueItemPriority(x, y *queueItem) bool {
 		return !xFail
 	}
 
+	if x.rank != y.rank {
+		return x.rank < y.rank
+	}
+
 	// tiebreaker is to prefer the item added to the queue first
 	return x.seq < y.seq
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -86,6 +87,87 @@ func TestQueueFIFO(t *testing.T) {
 	}
 }
 
+func TestQueuePriority(t *testing.T) {
+	backoffDuration := 1 * time.Millisecond
+	queue := NewQueue(backoffDuration, backoffDuration, logtest.Scoped(t))
+	queue.policy = queuePolicyPriority
+
+	for i := 0; i < 10; i++ {
+		opts := mkHEADIndexOptions(i, strconv.Itoa(i))
+		opts.Priority = float64(i % 2)
+		queue.AddOrUpdate(opts)
+	}
+
+	// Odd items have a higher priority, and within a priority we are FIFO.
+	want := []int{1, 3, 5, 7, 9, 0, 2, 4, 6, 8}
+	var got []int
+	for {
+		item, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, int(item.Opts.RepoID))
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected order (-want, +got):\n%s", d)
+	}
+}
+
+func TestQueueFairShare(t *testing.T) {
+	backoffDuration := 1 * time.Millisecond
+	queue := NewQueue(backoffDuration, backoffDuration, logtest.Scoped(t))
+	queue.policy = queuePolicyFairShare
+
+	monorepo := mkHEADIndexOptions(1, "a")
+	small := mkHEADIndexOptions(2, "a")
+	important := mkHEADIndexOptions(3, "a")
+	important.Priority = 100
+
+	queue.RecordIndexDuration(monorepo.RepoID, time.Hour)
+	queue.RecordIndexDuration(small.RepoID, time.Minute)
+	queue.RecordIndexDuration(important.RepoID, 10*time.Minute)
+
+	queue.AddOrUpdate(monorepo)
+	queue.AddOrUpdate(small)
+	queue.AddOrUpdate(important)
+
+	// important is weighted down to ~2m, so it is only overtaken by small.
+	want := []uint32{2, 3, 1}
+	var got []uint32
+	for {
+		item, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, item.Opts.RepoID)
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected order (-want, +got):\n%s", d)
+	}
+}
+
+func TestQueueFairShare_NoStarvation(t *testing.T) {
+	backoffDuration := 1 * time.Millisecond
+	queue := NewQueue(backoffDuration, backoffDuration, logtest.Scoped(t))
+	queue.policy = queuePolicyFairShare
+
+	monorepo := mkHEADIndexOptions(1, "a")
+	queue.RecordIndexDuration(monorepo.RepoID, time.Millisecond)
+	queue.AddOrUpdate(monorepo)
+
+	// Small repositories enqueued after the monorepo's virtual deadline do
+	// not overtake it.
+	time.Sleep(5 * time.Millisecond)
+	small := mkHEADIndexOptions(2, "a")
+	queue.RecordIndexDuration(small.RepoID, time.Microsecond)
+	queue.AddOrUpdate(small)
+
+	item, _ := queue.Pop()
+	if item.Opts.RepoID != monorepo.RepoID {
+		t.Fatalf("got %d, want monorepo to be indexed first", item.Opts.RepoID)
+	}
+}
+
 func TestQueue_MaybeRemoveMissing(t *testing.T) {
 	backoffDuration := 1 * time.Millisecond
 	queue := NewQueue(backoffDuration, backoffDuration, logtest.Scoped(t))
@@ -134,6 +216,64 @@ func TestQueue_Bump(t *testing.T) {
 	}
 }
 
+func TestQueue_SaveLoad(t *testing.T) {
+	backoffDuration := 1 * time.Millisecond
+	queue := NewQueue(backoffDuration, backoffDuration, logtest.Scoped(t))
+
+	for i := 0; i < 4; i++ {
+		queue.AddOrUpdate(mkHEADIndexOptions(i, strconv.Itoa(i)))
+	}
+
+	// Index the odd numbers and put the even ones back on the queue.
+	emptyQueue(queue)
+	for i := 0; i < 4; i++ {
+		if i%2 == 1 {
+			queue.SetIndexed(mkHEADIndexOptions(i, strconv.Itoa(i)), indexStateSuccess)
+		} else {
+			queue.AddOrUpdate(mkHEADIndexOptions(i, strconv.Itoa(i)))
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), queueStateFileName)
+	if err := queue.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewQueue(backoffDuration, backoffDuration, logtest.Scoped(t))
+	n, err := loaded.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d queued repositories, want 2", n)
+	}
+
+	// Only the even numbers are on the queue, but all repositories are known.
+	if missing := loaded.Bump(nil); len(missing) != 0 {
+		t.Fatalf("unexpected missing %v", missing)
+	}
+	var got []uint32
+	for {
+		item, ok := loaded.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, item.Opts.RepoID)
+	}
+	if d := cmp.Diff([]uint32{0, 2}, got); d != "" {
+		t.Errorf("unexpected queued repositories (-want, +got):\n%s", d)
+	}
+
+	if missing := loaded.Bump([]uint32{0, 1, 2, 3, 4}); !cmp.Equal([]uint32{4}, missing) {
+		t.Errorf("got missing %v, want [4]", missing)
+	}
+
+	// Loading a missing file is a noop.
+	if n, err := loaded.Load(filepath.Join(t.TempDir(), queueStateFileName)); n != 0 || err != nil {
+		t.Errorf("got %d, %v for a missing file", n, err)
+	}
+}
+
 func TestQueue_Integration_DebugQueue(t *testing.T) {
 	// helper function to normalize the queue's debug output - this makes the test less brittle
 	// + makes it much less annoying to make edits to the expected output in a way that doesn't
@@ -143,7 +283,7 @@ func TestQueue_Integration_DebugQueue(t *testing.T) {
 
 		var outputLines []string
 		for i, line := range strings.Split(output, "\n") {
-			columns := []string{"Position", "Name", "ID", "IsOnQueue", "Age", "Branches"}
+			columns := []string{"Position", "Name", "ID", "IsOnQueue", "Age", "AvgDuration", "ExpectedStart", "Branches"}
 			parts := strings.Fields(line) // Note: splitting on spaces like this would break for repositories that have more than one branch, but it's fine for just this test
 			if len(columns) != len(parts) {
 				t.Fatalf("normalizeDebugOutput: line %d: expected %d columns, got %d columns: %q", i, len(columns), len(parts), line)
@@ -178,6 +318,7 @@ func TestQueue_Integration_DebugQueue(t *testing.T) {
 
 	queue.AddOrUpdate(poppedRepository)
 	queue.Pop()
+	queue.RecordIndexDuration(poppedRepository.RepoID, time.Minute)
 
 	queue.AddOrUpdate(queuedRepository)
 
@@ -201,9 +342,9 @@ func TestQueue_Integration_DebugQueue(t *testing.T) {
 	actualOutput := normalizeDebugOutput(string(raw))
 
 	expectedOutput := `
-Position        Name            ID              IsOnQueue       Age                    Branches
-0               item-1          1               true            *                      HEAD@stillQueued
-1               item-0          0               false           -                      HEAD@popped
+Position        Name            ID              IsOnQueue       Age      AvgDuration    ExpectedStart    Branches
+0               item-1          1               true            *        -              0s               HEAD@stillQueued
+1               item-0          0               false           -        1m0s           -                HEAD@popped
 `
 
 	expectedOutput = normalizeDebugOutput(expectedOutput)
@@ -291,6 +291,9 @@ func (o *indexOptionsItem) FromProto(x *proto.ZoektIndexOptions) {
 		Branches:   branches,
 		Name:       x.GetName(),
 
+		IncludePaths: x.GetIncludePaths(),
+		ExcludePaths: x.GetExcludePaths(),
+
 		Priority: x.GetPriority(),
 
 		Public:   x.GetPublic(),
@@ -333,6 +336,9 @@ func (o *indexOptionsItem) ToProto() *proto.ZoektIndexOptions {
 		Branches:   branches,
 		Name:       o.Name,
 
+		IncludePaths: o.IncludePaths,
+		ExcludePaths: o.ExcludePaths,
+
 		Priority: o.Priority,
 
 		Public:   o.Public,
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"math/rand"
+	"reflect"
 	"testing"
 	"testing/quick"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/sourcegraph/zoekt"
 )
 
 func TestIndexOptions_RoundTrip(t *testing.T) {
@@ -30,6 +34,24 @@ func TestIndexOptions_RoundTrip(t *testing.T) {
 	}
 }
 
+// Generate implements quick.Generator. quick can't generate the CommitDate of
+// a branch, which Sourcegraph doesn't store anyway.
+func (indexStatus) Generate(rand *rand.Rand, size int) reflect.Value {
+	s := indexStatus{
+		RepoID:        rand.Uint32(),
+		IndexTimeUnix: rand.Int63(),
+	}
+	for i := rand.Intn(size); i > 0; i-- {
+		name, _ := quick.Value(reflect.TypeOf(""), rand)
+		version, _ := quick.Value(reflect.TypeOf(""), rand)
+		s.Branches = append(s.Branches, zoekt.RepositoryBranch{
+			Name:    name.String(),
+			Version: version.String(),
+		})
+	}
+	return reflect.ValueOf(s)
+}
+
 func TestUpdateIndexStatusRequest_RoundTrip(t *testing.T) {
 	var diff string
 	f := func(original updateIndexStatusRequest) bool {
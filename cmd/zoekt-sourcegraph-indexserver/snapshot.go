@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sourcegraph/zoekt/internal/snapshot"
+)
+
+// snapshot takes the snapshot of the index directory for the day of now, if
+// there is none yet, and removes the snapshots older than
+// s.snapshotRetention.
+func (s *Server) snapshot(now time.Time) {
+	snaps, err := snapshot.List(s.IndexDir)
+	if err != nil {
+		errorLog.Printf("failed to list snapshots: %v", err)
+		return
+	}
+	today := now.UTC().Format(time.DateOnly)
+	if len(snaps) > 0 && snaps[len(snaps)-1].Date.Format(time.DateOnly) == today {
+		return
+	}
+
+	// The global lock makes sure that the snapshot doesn't catch a
+	// repository halfway through replacing its shards.
+	s.muIndexDir.Global(func() {
+		snap, err := snapshot.Take(s.IndexDir, now)
+		if err != nil {
+			errorLog.Printf("failed to snapshot the index directory: %v", err)
+			return
+		}
+		infoLog.Printf("snapshot of the index directory taken in %s", snap.Dir)
+
+		removed, err := snapshot.Prune(s.IndexDir, now, s.snapshotRetention)
+		for _, snap := range removed {
+			infoLog.Printf("removed snapshot %s older than %s", snap.Dir, s.snapshotRetention)
+		}
+		if err != nil {
+			errorLog.Printf("failed to prune snapshots: %v", err)
+		}
+	})
+}
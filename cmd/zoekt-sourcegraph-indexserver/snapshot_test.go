@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/internal/snapshot"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "repo_v16.00000.zoekt"), []byte("shard"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{IndexDir: dir, snapshotRetention: 48 * time.Hour}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for h := 0; h < 4*24; h += 6 {
+		s.snapshot(start.Add(time.Duration(h) * time.Hour))
+	}
+
+	snaps, err := snapshot.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, snap := range snaps {
+		got = append(got, filepath.Base(snap.Dir))
+		if _, err := os.Stat(filepath.Join(snap.Dir, "repo_v16.00000.zoekt")); err != nil {
+			t.Errorf("shard missing from snapshot: %v", err)
+		}
+	}
+	// One snapshot a day, the last one on 2024-01-05 with the two days before.
+	want := []string{"2024-01-03", "2024-01-04", "2024-01-05"}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("snapshots mismatch (-want +got):\n%s", d)
+	}
+}
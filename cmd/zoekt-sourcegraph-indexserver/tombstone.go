@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// tombstonesFileName is the file in the index directory which lists the
+// repositories tombstoned with /debug/tombstone/.
+const tombstonesFileName = ".indexserver.tombstones.json"
+
+// readTombstones returns the IDs of the repositories tombstoned with
+// /debug/tombstone/. An operator tombstones a repository to hide it from
+// search right away, eg. for a legal takedown. Until the tombstone is
+// cleared, we treat the repository as if it was not assigned to us, so that
+// neither cleanup nor indexing brings it back.
+func readTombstones(indexDir string) (map[uint32]bool, error) {
+	b, err := os.ReadFile(filepath.Join(indexDir, tombstonesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[uint32]bool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return nil, fmt.Errorf("%s: %w", tombstonesFileName, err)
+	}
+	m := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		m[id] = true
+	}
+	return m, nil
+}
+
+// writeTombstones replaces the tombstones of indexDir with ids, see
+// readTombstones.
+func writeTombstones(indexDir string, ids map[uint32]bool) error {
+	sorted := make([]uint32, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	slices.Sort(sorted)
+
+	b, err := json.Marshal(sorted)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(indexDir, tombstonesFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(indexDir, tombstonesFileName))
+}
+
+// withoutTombstones returns repos without the repositories in tombstones.
+func withoutTombstones(repos *SourcegraphListResult, tombstones map[uint32]bool) *SourcegraphListResult {
+	if len(tombstones) == 0 {
+		return repos
+	}
+	iterate := repos.IterateIndexOptions
+	return &SourcegraphListResult{
+		IDs: slices.DeleteFunc(slices.Clone(repos.IDs), func(id uint32) bool {
+			return tombstones[id]
+		}),
+		IterateIndexOptions: func(f func(IndexOptions)) {
+			iterate(func(opts IndexOptions) {
+				if !tombstones[opts.RepoID] {
+					f(opts)
+				}
+			})
+		},
+	}
+}
+
+// setTombstone tombstones the repository repoID, or clears its tombstone,
+// in all compound shards of the index directory and records it for
+// readTombstones. It returns the compound shards it changed.
+//
+// Tombstones take effect right away, instead of once the repository is no
+// longer assigned to us. Shards of the repository which are not compound
+// are trashed by the next cleanup. Once the tombstone is cleared, the next
+// cleanup tombstones the repository again if it isn't assigned to us.
+func (s *Server) setTombstone(repoID uint32, tombstone bool) ([]string, error) {
+	s.muTombstones.Lock()
+	defer s.muTombstones.Unlock()
+
+	tombstones, err := readTombstones(s.IndexDir)
+	if err != nil {
+		return nil, err
+	}
+	if tombstone {
+		tombstones[repoID] = true
+	} else {
+		delete(tombstones, repoID)
+	}
+	if err := writeTombstones(s.IndexDir, tombstones); err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	s.muIndexDir.Global(func() {
+		var paths []string
+		paths, err = filepath.Glob(filepath.Join(s.IndexDir, "compound-*.zoekt"))
+		if err != nil {
+			return
+		}
+		for _, p := range paths {
+			var repos []*zoekt.Repository
+			repos, _, err = index.ReadMetadataPath(p)
+			if err != nil {
+				return
+			}
+			i := slices.IndexFunc(repos, func(r *zoekt.Repository) bool { return r.ID == repoID })
+			if i < 0 || repos[i].Tombstone == tombstone {
+				continue
+			}
+			if tombstone {
+				err = index.SetTombstone(p, repoID)
+			} else {
+				err = index.UnsetTombstone(p, repoID)
+			}
+			if err != nil {
+				return
+			}
+			changed = append(changed, filepath.Base(p))
+		}
+	})
+	return changed, err
+}
+
+// handleDebugTombstones lists the repositories tombstoned with
+// /debug/tombstone/{id}.
+func (s *Server) handleDebugTombstones(w http.ResponseWriter, r *http.Request) {
+	tombstones, err := readTombstones(s.IndexDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ids := make([]uint32, 0, len(tombstones))
+	for id := range tombstones {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, id := range ids {
+		fmt.Fprintln(w, id)
+	}
+}
+
+// handleDebugTombstone tombstones the repository /debug/tombstone/{id} on
+// POST and clears its tombstone on DELETE, see Server.setTombstone.
+func (s *Server) handleDebugTombstone(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid repository ID %q", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	tombstone := r.Method == "POST"
+	changed, err := s.setTombstone(uint32(id), tombstone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	action := "tombstoned"
+	if !tombstone {
+		action = "cleared the tombstone of"
+	}
+	infoLog.Printf("%s repository %d in %d compound shards", action, id, len(changed))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s repository %d in %d compound shards\n", action, id, len(changed))
+	for _, name := range changed {
+		fmt.Fprintln(w, name)
+	}
+}
+
+// requestTombstone tombstones the repository repoID on the indexserver at
+// baseURL, or clears its tombstone.
+func requestTombstone(ctx context.Context, client *http.Client, baseURL string, repoID uint32, tombstone bool) (string, error) {
+	u, err := url.JoinPath(baseURL, "debug/tombstone", strconv.FormatUint(uint64(repoID), 10))
+	if err != nil {
+		return "", err
+	}
+	method := "POST"
+	if !tombstone {
+		method = "DELETE"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	msg := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%d: %s: %s", repoID, resp.Status, msg)
+	}
+	return msg, nil
+}
+
+func debugTombstone() *ffcli.Command {
+	fs := flag.NewFlagSet("debug tombstone", flag.ExitOnError)
+	baseURL := fs.String("url", "http://localhost:6072", "the address of the indexserver")
+	unset := fs.Bool("clear", false, "clear the tombstones instead of setting them")
+
+	return &ffcli.Command{
+		Name:       "tombstone",
+		ShortUsage: "tombstone [flags] <repository ID>...",
+		ShortHelp:  "hide repositories from search right away",
+		LongHelp: `
+  Tombstone repositories in the compound shards of an indexserver with its /debug/tombstone
+  endpoint, eg. for a legal takedown. The repositories are hidden from search right away
+  and treated as unassigned by the indexserver until the tombstone is cleared with -clear,
+  so the next vacuum removes them from the compound shards.`,
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("missing repository ID")
+			}
+			for _, arg := range args {
+				id, err := strconv.ParseUint(arg, 10, 32)
+				if err != nil {
+					return fmt.Errorf("invalid repository ID %q", arg)
+				}
+				msg, err := requestTombstone(ctx, http.DefaultClient, *baseURL, uint32(id), !*unset)
+				if err != nil {
+					return err
+				}
+				infoLog.Println(msg)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestTombstone(t *testing.T) {
+	dir := t.TempDir()
+	compound := createCompoundShard(t, dir, []uint32{1, 2, 3})
+
+	s := &Server{IndexDir: dir}
+	mux := http.NewServeMux()
+	s.addDebugHandlers(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tombstoned := func() []uint32 {
+		t.Helper()
+		repos, _, err := index.ReadMetadataPath(compound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []uint32
+		for _, r := range repos {
+			if r.Tombstone {
+				ids = append(ids, r.ID)
+			}
+		}
+		return ids
+	}
+
+	msg, err := requestTombstone(context.Background(), ts.Client(), ts.URL, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg, "in 1 compound shards") {
+		t.Fatalf("got %q, want 1 compound shard changed", msg)
+	}
+	if got := tombstoned(); !slices.Equal(got, []uint32{2}) {
+		t.Fatalf("got tombstones %v, want [2]", got)
+	}
+
+	// The indexserver treats tombstoned repositories as unassigned.
+	tombstones, err := readTombstones(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iterated []uint32
+	repos := withoutTombstones(&SourcegraphListResult{
+		IDs: []uint32{1, 2, 3},
+		IterateIndexOptions: func(f func(IndexOptions)) {
+			for _, id := range []uint32{1, 2} {
+				f(IndexOptions{RepoID: id})
+			}
+		},
+	}, tombstones)
+	repos.IterateIndexOptions(func(opts IndexOptions) { iterated = append(iterated, opts.RepoID) })
+	if !slices.Equal(repos.IDs, []uint32{1, 3}) || !slices.Equal(iterated, []uint32{1}) {
+		t.Fatalf("got IDs %v and index options for %v, want [1 3] and [1]", repos.IDs, iterated)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "/debug/tombstone/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "2\n" {
+		t.Fatalf("list: got status %d and body %q, want 2 listed", resp.StatusCode, body)
+	}
+
+	if _, err := requestTombstone(context.Background(), ts.Client(), ts.URL, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := tombstoned(); len(got) != 0 {
+		t.Fatalf("got tombstones %v after clearing, want none", got)
+	}
+	if tombstones, err := readTombstones(dir); err != nil || len(tombstones) != 0 {
+		t.Fatalf("got tombstones %v, %v after clearing, want none", tombstones, err)
+	}
+
+	resp, err = ts.Client().Post(ts.URL+"/debug/tombstone/x", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid repository ID: got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
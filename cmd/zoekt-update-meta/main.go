@@ -0,0 +1,140 @@
+// Command zoekt-update-meta updates the metadata of an indexed repository
+// without reindexing it. The changes are written to the .meta files next to
+// the shards, which searchers pick up when they reload the shards.
+//
+// Usage:
+//
+//	zoekt-update-meta -name REPO [-id ID] [-priority N] [-set KEY=VALUE]... [-branch_description BRANCH=DESCRIPTION]...
+//
+// -priority sets the priority of the repository, which ranks its files
+// unless the repository ranks by its latest commit date. -set sets arbitrary
+// RawConfig keys, eg. custom boosts. -id is needed to find repositories in
+// compound shards.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// keyValueFlag collects repeated KEY=VALUE flags.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	kvs := make([]string, 0, len(f))
+	for k, v := range f {
+		kvs = append(kvs, k+"="+v)
+	}
+	return strings.Join(kvs, ",")
+}
+
+func (f keyValueFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	f[k] = v
+	return nil
+}
+
+// metaUpdate are the changes to the metadata of a repository.
+type metaUpdate struct {
+	// RawConfig are the RawConfig keys to set.
+	RawConfig map[string]string
+	// BranchDescriptions maps branch names to their new description.
+	BranchDescriptions map[string]string
+}
+
+// updateMeta applies u to the metadata of the repository described by o,
+// which must have the name and, for compound shards, the ID of the
+// repository.
+func updateMeta(o *index.Options, u metaUpdate) error {
+	shards := o.FindAllShards()
+	if len(shards) == 0 {
+		return fmt.Errorf("no shards found for repository %q in %s", o.RepositoryDescription.Name, o.IndexDir)
+	}
+	repos, _, err := index.ReadMetadataPath(shards[0])
+	if err != nil {
+		return err
+	}
+	var repo *zoekt.Repository
+	for _, cand := range repos {
+		if cand.Name == o.RepositoryDescription.Name {
+			repo = cand
+			break
+		}
+	}
+	if repo == nil {
+		return fmt.Errorf("could not find repository %q in shard %s", o.RepositoryDescription.Name, shards[0])
+	}
+
+	desc := *repo
+	desc.RawConfig = map[string]string{}
+	for k, v := range u.RawConfig {
+		desc.RawConfig[k] = v
+	}
+
+	desc.Branches = append([]zoekt.RepositoryBranch(nil), repo.Branches...)
+	for name, description := range u.BranchDescriptions {
+		found := false
+		for i := range desc.Branches {
+			if desc.Branches[i].Name == name {
+				desc.Branches[i].Description = description
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("repository %q has no branch %q", repo.Name, name)
+		}
+	}
+
+	o.RepositoryDescription = desc
+	return index.MergeMeta(o)
+}
+
+func main() {
+	var (
+		indexDir = flag.String("index", filepath.Join(os.Getenv("HOME"), ".zoekt"), "the directory of the shards")
+		name     = flag.String("name", "", "the name of the repository")
+		id       = flag.Uint("id", 0, "the ID of the repository, needed for repositories in compound shards")
+		priority = flag.String("priority", "", "if set, the new priority of the repository")
+
+		rawConfig          = keyValueFlag{}
+		branchDescriptions = keyValueFlag{}
+	)
+	flag.Var(rawConfig, "set", "a RawConfig KEY=VALUE to set. You can set multiple keys by setting this more than once.")
+	flag.Var(branchDescriptions, "branch_description", "a BRANCH=DESCRIPTION to set. You can set multiple descriptions by setting this more than once.")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatal("-name is required")
+	}
+	if *priority != "" {
+		if _, err := strconv.ParseFloat(*priority, 64); err != nil {
+			log.Fatalf("-priority: %v", err)
+		}
+		rawConfig["priority"] = *priority
+	}
+	if len(rawConfig) == 0 && len(branchDescriptions) == 0 {
+		log.Fatal("nothing to update, set at least one of -priority, -set or -branch_description")
+	}
+
+	o := &index.Options{
+		IndexDir: *indexDir,
+		RepositoryDescription: zoekt.Repository{
+			ID:   uint32(*id),
+			Name: *name,
+		},
+	}
+	if err := updateMeta(o, metaUpdate{RawConfig: rawConfig, BranchDescriptions: branchDescriptions}); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestUpdateMeta(t *testing.T) {
+	dir := t.TempDir()
+	opts := index.Options{
+		IndexDir: dir,
+		RepositoryDescription: zoekt.Repository{
+			Name:      "repo",
+			Branches:  []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}},
+			RawConfig: map[string]string{"priority": "10", "public": "1"},
+		},
+	}
+	opts.SetDefaults()
+	b, err := index.NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("F", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	list := func() *zoekt.Repository {
+		t.Helper()
+		rl, err := ss.List(context.Background(), &query.Const{Value: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rl.Repos) != 1 {
+			t.Fatalf("got %d repos, want 1", len(rl.Repos))
+		}
+		return &rl.Repos[0].Repository
+	}
+	oldRank := list().Rank
+
+	o := &index.Options{IndexDir: dir, RepositoryDescription: zoekt.Repository{Name: "repo"}}
+	if err := updateMeta(o, metaUpdate{
+		RawConfig:          map[string]string{"priority": "1000", "boost": "2"},
+		BranchDescriptions: map[string]string{"main": "the default branch"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The searcher reloads the shard once it notices the new .meta file.
+	var repo *zoekt.Repository
+	for i := 0; i < 100; i++ {
+		if repo = list(); repo.RawConfig["boost"] == "2" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := repo.RawConfig; got["priority"] != "1000" || got["boost"] != "2" || got["public"] != "1" {
+		t.Errorf("got RawConfig %v", got)
+	}
+	if repo.Rank <= oldRank {
+		t.Errorf("got rank %d, want a rank above %d", repo.Rank, oldRank)
+	}
+	if got := repo.Branches[0].Description; got != "the default branch" {
+		t.Errorf("got branch description %q", got)
+	}
+
+	// The index is still up to date for the updated description.
+	opts.RepositoryDescription.RawConfig = map[string]string{"priority": "1000", "boost": "2", "public": "1"}
+	if state, _ := opts.IndexState(); state != index.IndexStateEqual {
+		t.Errorf("got index state %s, want %s", state, index.IndexStateEqual)
+	}
+
+	if err := updateMeta(o, metaUpdate{BranchDescriptions: map[string]string{"dev": "x"}}); err == nil {
+		t.Error("want an error for an unknown branch")
+	}
+}
@@ -0,0 +1,116 @@
+// Command zoekt-upgrade-index rewrites shards of older feature versions at
+// the current feature version, without reindexing the repositories.
+//
+// Usage:
+//
+//	zoekt-upgrade-index [-n] SHARD|INDEX_DIR...
+//
+// Every shard is reported with its compatibility: current, upgradable,
+// needs-reindex or incompatible. Upgradable shards are rewritten in place,
+// unless -n is given. Shards which need to be reindexed are left to the
+// indexserver. zoekt-upgrade-index exits with status 1 if a shard is
+// incompatible or fails to upgrade.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// upgrade upgrades the shard name in place if it is upgradable, and returns
+// its compatibility before the upgrade.
+func upgrade(name string, dryRun bool) (index.ShardCompatibility, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return index.ShardIncompatible, err
+	}
+	indexFile, err := index.NewIndexFile(f)
+	if err != nil {
+		return index.ShardIncompatible, err
+	}
+	defer indexFile.Close()
+
+	_, md, err := index.ReadMetadata(indexFile)
+	if err != nil {
+		return index.ShardIncompatible, err
+	}
+	compat, err := index.CheckShardCompatibility(md)
+	if err != nil || compat != index.ShardUpgradable || dryRun {
+		return compat, err
+	}
+	return compat, index.UpgradeShard(name, indexFile)
+}
+
+// shardPaths returns the shards in args, which are shards or directories of
+// shards.
+func shardPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		shards, err := filepath.Glob(filepath.Join(arg, "*.zoekt"))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, shards...)
+	}
+	return paths, nil
+}
+
+// run upgrades the shards in args and reports them to w. It returns false if
+// a shard is incompatible or failed to upgrade.
+func run(w io.Writer, args []string, dryRun bool) (bool, error) {
+	paths, err := shardPaths(args)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	for _, p := range paths {
+		compat, err := upgrade(p, dryRun)
+		switch {
+		case err != nil:
+			ok = false
+			fmt.Fprintf(w, "%s\t%s\t%v\n", p, compat, err)
+		case compat == index.ShardUpgradable && !dryRun:
+			fmt.Fprintf(w, "%s\tupgraded\n", p)
+		default:
+			fmt.Fprintf(w, "%s\t%s\n", p, compat)
+		}
+	}
+	return ok, nil
+}
+
+func main() {
+	dryRun := flag.Bool("n", false, "only report the compatibility of the shards, without upgrading them")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: zoekt-upgrade-index [-n] SHARD|INDEX_DIR...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ok, err := run(os.Stdout, flag.Args(), *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var buf bytes.Buffer
+	ok, err := run(&buf, []string{"../../testdata/shards", "../../testdata/backcompat/static_toc_v16.00000.zoekt"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("got failures:\n%s", buf.String())
+	}
+
+	for _, want := range []string{
+		"../../testdata/shards/repo_v16.00000.zoekt\tcurrent\n",
+		"../../testdata/shards/ctagsrepo_v16.00000.zoekt\tneeds-reindex\n",
+		"../../testdata/backcompat/static_toc_v16.00000.zoekt\tneeds-reindex\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got output\n%s\nwant it to contain %q", buf.String(), want)
+		}
+	}
+
+	if _, err := run(&buf, []string{"missing"}, true); err == nil {
+		t.Error("got no error for a missing path")
+	}
+}
@@ -19,6 +19,12 @@ type samplingSender struct {
 }
 
 func (s *samplingSender) Send(event *zoekt.SearchResult) {
+	// Progress events are sampled by their sender already.
+	if event.SearchProgress != nil {
+		s.next.Send(event)
+		return
+	}
+
 	// We don't want to send events over the wire if they don't contain file
 	// matches. Hence, in case we didn't find any results, we aggregate the stats
 	// and send them out in regular intervals.
@@ -31,6 +31,9 @@ func TestSamplingStream(t *testing.T) {
 		}
 		return res
 	}
+	progressEvent := &zoekt.SearchResult{
+		SearchProgress: &zoekt.SearchProgress{ShardsScanned: 1, ShardsTotal: 2},
+	}
 	cases := []struct {
 		events           []*zoekt.SearchResult
 		beforeFlushCount int
@@ -49,6 +52,9 @@ func TestSamplingStream(t *testing.T) {
 		{append(fileEvents(1), statsEvents(100)...), 2, 2},
 		{statsEvents(500), 5, 5},
 		{statsEvents(501), 5, 6},
+		// Progress events are never sampled.
+		{[]*zoekt.SearchResult{progressEvent, progressEvent}, 2, 2},
+		{append(statsEvents(1), progressEvent), 1, 2},
 	}
 
 	for _, tc := range cases {
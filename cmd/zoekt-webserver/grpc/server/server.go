@@ -2,8 +2,10 @@ package server
 
 import (
 	"context"
+	"errors"
 	"math"
 
+	"github.com/sourcegraph/zoekt/grpc/checksum"
 	"github.com/sourcegraph/zoekt/grpc/chunk"
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"google.golang.org/grpc/codes"
@@ -32,7 +34,7 @@ func (s *Server) Search(ctx context.Context, req *proto.SearchRequest) (*proto.S
 
 	res, err := s.streamer.Search(ctx, q, zoekt.SearchOptionsFromProto(req.GetOpts()))
 	if err != nil {
-		return nil, err
+		return nil, searchError(err)
 	}
 
 	return res.ToProto(), nil
@@ -46,12 +48,33 @@ func (s *Server) StreamSearch(req *proto.StreamSearchRequest, ss proto.Webserver
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	sender := gRPCChunkSender(ss)
+	var sums *checksum.Stream
+	if req.GetChecksums() {
+		sums = &checksum.Stream{}
+	}
+
+	sender := gRPCChunkSender(ss, sums)
 	sampler := newSamplingSender(sender)
 
 	err = s.streamer.StreamSearch(ss.Context(), q, zoekt.SearchOptionsFromProto(request.GetOpts()), sampler)
-	if err == nil {
-		sampler.Flush()
+	if err != nil {
+		return searchError(err)
+	}
+	sampler.Flush()
+
+	if sums != nil {
+		// The final checksum tells the client that it received the whole stream.
+		return ss.Send(&proto.StreamSearchResponse{Checksum: sums.Checksum(true)})
+	}
+	return nil
+}
+
+// searchError reports queries rejected as too expensive as invalid
+// arguments, since retrying them won't help.
+func searchError(err error) error {
+	var tooExpensive *zoekt.QueryTooExpensiveError
+	if errors.As(err, &tooExpensive) {
+		return status.Error(codes.InvalidArgument, err.Error())
 	}
 	return err
 }
@@ -70,20 +93,99 @@ func (s *Server) List(ctx context.Context, req *proto.ListRequest) (*proto.ListR
 	return repoList.ToProto(), nil
 }
 
+func (s *Server) StreamList(req *proto.ListRequest, ss proto.WebserverService_StreamListServer) error {
+	q, err := query.QFromProto(req.GetQuery())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var sendErr error
+	err = zoekt.StreamList(ss.Context(), s.streamer, q, zoekt.ListOptionsFromProto(req.GetOpts()), zoekt.ListSenderFunc(func(rl *zoekt.RepoList) {
+		if sendErr == nil {
+			sendErr = sendRepoList(ss, rl)
+		}
+	}))
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func (s *Server) FetchDocument(ctx context.Context, req *proto.FetchDocumentRequest) (*proto.FetchDocumentResponse, error) {
+	if req.GetRepository() == "" || req.GetFileName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "repository and file_name are required")
+	}
+
+	doc, err := zoekt.FetchDocument(ctx, s.streamer, zoekt.DocumentRequestFromProto(req))
+	if errors.Is(err, zoekt.ErrDocumentNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.FetchDocumentResponse{Document: doc.ToProto()}, nil
+}
+
+// sendRepoList sends the batch rl in chunks which fit into a gRPC message.
+// The first chunk carries everything but the remaining repositories.
+func sendRepoList(ss proto.WebserverService_StreamListServer, rl *zoekt.RepoList) error {
+	resp := rl.ToProto()
+	repos := resp.GetRepos()
+	resp.Repos = nil
+
+	first := true
+	chunker := chunk.New(func(repos []*proto.RepoListEntry) error {
+		if first {
+			first = false
+			resp.Repos = repos
+			return ss.Send(resp)
+		}
+		return ss.Send(&proto.ListResponse{Repos: repos})
+	})
+	if err := chunker.Send(repos...); err != nil {
+		return err
+	}
+	if err := chunker.Flush(); err != nil {
+		return err
+	}
+
+	if first {
+		// The batch has no repositories.
+		return ss.Send(resp)
+	}
+	return nil
+}
+
 // gRPCChunkSender is a zoekt.Sender that sends small chunks of FileMatches to the provided gRPC stream.
-func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender {
+// If sums is non-nil, every response carries the checksums of the stream so far.
+func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer, sums *checksum.Stream) zoekt.Sender {
+	streamChecksum := func(files []*proto.FileMatch) *proto.StreamChecksum {
+		if sums == nil {
+			return nil
+		}
+		sums.Add(files)
+		return sums.Checksum(false)
+	}
+
 	f := func(r *zoekt.SearchResult) {
 		result := r.ToStreamProto().GetResponseChunk()
 
 		if len(result.GetFiles()) == 0 { // stats-only result, send it immediately
 			_ = ss.Send(&proto.StreamSearchResponse{
 				ResponseChunk: result,
+				Checksum:      streamChecksum(nil),
 			})
 			return
 		}
 
 		// Otherwise, chunk the file matches into multiple responses
 
+		if sums != nil {
+			// Sign before chunking, so that the chunks account for the checksums.
+			checksum.Sign(result.GetFiles())
+		}
+
 		statsSent := false
 		numFilesSent := 0
 
@@ -91,9 +193,11 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 			numFilesSent += len(filesChunk)
 
 			var stats *proto.Stats
-			if !statsSent { // We only send stats back on the first chunk
+			var suggestions []*proto.Suggestion
+			if !statsSent { // We only send stats and suggestions back on the first chunk
 				statsSent = true
 				stats = result.GetStats()
+				suggestions = result.GetSuggestions()
 			}
 
 			progress := result.GetProgress()
@@ -117,7 +221,13 @@ func gRPCChunkSender(ss proto.WebserverService_StreamSearchServer) zoekt.Sender
 
 					Stats:    stats,
 					Progress: progress,
+
+					// A progress report isn't a delta, so every chunk can carry it.
+					SearchProgress: result.GetSearchProgress(),
+
+					Suggestions: suggestions,
 				},
+				Checksum: streamChecksum(filesChunk),
 			})
 		}
 
@@ -5,19 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sourcegraph/zoekt/grpc/checksum"
 	"github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"go.uber.org/atomic"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 
@@ -105,12 +111,78 @@ func TestClientServer(t *testing.T) {
 	if diff := cmp.Diff(receivedFileMatches, mock.SearchResult.ToProto().GetFiles(), protocmp.Transform()); diff != "" {
 		t.Fatalf("unexpected difference in file matches (-want +got):\n%s", diff)
 	}
+
+	request.Checksums = true
+	cs, err = client.StreamSearch(context.Background(), &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		verifier checksum.Verifier
+		numFiles int
+	)
+	for {
+		resp, err := cs.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetChecksum() == nil {
+			t.Fatal("response without checksum")
+		}
+		if err := verifier.Verify(resp); err != nil {
+			t.Fatal(err)
+		}
+		numFiles += len(resp.GetResponseChunk().GetFiles())
+	}
+	if err := verifier.Done(); err != nil {
+		t.Fatal(err)
+	}
+	if numFiles != len(mock.SearchResult.Files) {
+		t.Fatalf("got %d file matches, want %d", numFiles, len(mock.SearchResult.Files))
+	}
+}
+
+func TestFetchDocument(t *testing.T) {
+	want := &zoekt.Document{
+		Repository: "repo",
+		FileName:   "a.go",
+		Content:    []byte("package a\n"),
+		Symbols:    []zoekt.DocumentSymbol{{Start: 8, End: 9, Symbol: zoekt.Symbol{Sym: "a", Kind: "package"}}},
+	}
+	s := NewServer(documentAdapter{doc: want})
+
+	for _, tc := range []struct {
+		name string
+		req  *v1.FetchDocumentRequest
+		code codes.Code
+	}{
+		{name: "found", req: &v1.FetchDocumentRequest{Repository: "repo", FileName: "a.go"}, code: codes.OK},
+		{name: "not found", req: &v1.FetchDocumentRequest{Repository: "repo", FileName: "b.go"}, code: codes.NotFound},
+		{name: "missing file name", req: &v1.FetchDocumentRequest{Repository: "repo"}, code: codes.InvalidArgument},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := s.FetchDocument(context.Background(), tc.req)
+			if got := status.Code(err); got != tc.code {
+				t.Fatalf("got code %s, want %s", got, tc.code)
+			}
+			if err != nil {
+				return
+			}
+			if d := cmp.Diff(want, zoekt.DocumentFromProto(resp.GetDocument()), cmpopts.EquateEmpty()); d != "" {
+				t.Errorf("document mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
 }
 
 func TestFuzzGRPCChunkSender(t *testing.T) {
 	validateResult := func(input zoekt.SearchResult) error {
 		clientStream, serverStream := newPairedSearchStream(t)
-		sender := gRPCChunkSender(serverStream)
+		sender := gRPCChunkSender(serverStream, nil)
 
 		sender.Send(&input)
 
@@ -146,9 +218,10 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 			opts := []cmp.Option{
 				protocmp.Transform(),
 				protocmp.IgnoreFields(&v1.SearchResponse{},
-					"progress", // progress is tested above
-					"stats",    // aggregated stats are tested below
-					"files",    // files are tested separately
+					"progress",    // progress is tested above
+					"stats",       // aggregated stats are tested below
+					"files",       // files are tested separately
+					"suggestions", // suggestions are tested separately
 				),
 			}
 
@@ -160,9 +233,11 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 		receivedStats := &zoekt.Stats{}
 
 		var receivedFileMatches []*v1.FileMatch
+		var receivedSuggestions []*v1.Suggestion
 		for _, r := range allResponses {
 			receivedStats.Add(zoekt.StatsFromProto(r.GetStats()))
 			receivedFileMatches = append(receivedFileMatches, r.GetFiles()...)
+			receivedSuggestions = append(receivedSuggestions, r.GetSuggestions()...)
 		}
 
 		// Check to make sure that we get one set of stats back
@@ -181,6 +256,12 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 			return fmt.Errorf("unexpected difference in file matches (-want +got):\n%s", diff)
 		}
 
+		// Check to make sure that we get the suggestions back once
+		if diff := cmp.Diff(expectedResult.GetSuggestions(), receivedSuggestions,
+			protocmp.Transform(), cmpopts.EquateEmpty()); diff != "" {
+			return fmt.Errorf("unexpected difference in suggestions (-want +got):\n%s", diff)
+		}
+
 		return nil
 	}
 
@@ -189,11 +270,52 @@ func TestFuzzGRPCChunkSender(t *testing.T) {
 		lastErr = validateResult(r)
 
 		return lastErr == nil
-	}, nil); err != nil {
+	}, &quick.Config{Values: func(args []reflect.Value, rand *rand.Rand) {
+		args[0] = arbitraryValue(reflect.TypeOf(zoekt.SearchResult{}), rand, 5)
+	}}); err != nil {
 		t.Fatal(lastErr.Error())
 	}
 }
 
+// arbitraryValue is like quick.Value, but also generates time.Time, which has
+// unexported fields, e.g. for zoekt.FileMatch.
+func arbitraryValue(t reflect.Type, rand *rand.Rand, size int) reflect.Value {
+	if t == reflect.TypeOf(time.Time{}) {
+		return reflect.ValueOf(time.Unix(rand.Int63n(1<<33), 0).UTC())
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			v.Field(i).Set(arbitraryValue(t.Field(i).Type, rand, size))
+		}
+	case reflect.Slice:
+		n := rand.Intn(size)
+		v.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			v.Index(i).Set(arbitraryValue(t.Elem(), rand, size))
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		for i := rand.Intn(size); i > 0; i-- {
+			v.SetMapIndex(arbitraryValue(t.Key(), rand, size), arbitraryValue(t.Elem(), rand, size))
+		}
+	case reflect.Pointer:
+		if rand.Intn(size) > 0 {
+			v.Set(reflect.New(t.Elem()))
+			v.Elem().Set(arbitraryValue(t.Elem(), rand, size))
+		}
+	default:
+		x, ok := quick.Value(t, rand)
+		if !ok {
+			panic(fmt.Sprintf("cannot generate a value of type %s", t))
+		}
+		v.Set(x)
+	}
+	return v
+}
+
 // newPairedSearchStream returns a pair of client and server search streams that are connected to each other.
 func newPairedSearchStream(t *testing.T) (v1.WebserverService_StreamSearchClient, v1.WebserverService_StreamSearchServer) {
 	client := &mockSearchStreamClient{t: t}
@@ -289,3 +411,16 @@ func (a adapter) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.Search
 	sender.Send(sr)
 	return nil
 }
+
+// documentAdapter is a streamer which has a single document.
+type documentAdapter struct {
+	adapter
+	doc *zoekt.Document
+}
+
+func (a documentAdapter) FetchDocument(_ context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	if req.Repository != a.doc.Repository || req.FileName != a.doc.FileName {
+		return nil, zoekt.ErrDocumentNotFound
+	}
+	return a.doc, nil
+}
@@ -25,6 +25,7 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -32,20 +33,27 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/sourcegraph/mountinfo"
 	"github.com/sourcegraph/zoekt/internal/debugserver"
+	"github.com/sourcegraph/zoekt/internal/federation"
+	"github.com/sourcegraph/zoekt/internal/objstore"
+	"github.com/sourcegraph/zoekt/internal/overlay"
 	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/internal/snapshot"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
@@ -60,6 +68,7 @@ import (
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 	"github.com/sourcegraph/zoekt/index"
 	"github.com/sourcegraph/zoekt/internal/profiler"
+	"github.com/sourcegraph/zoekt/internal/ratelimit"
 	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/internal/trace"
 	"github.com/sourcegraph/zoekt/internal/tracer"
@@ -119,6 +128,28 @@ func loadTemplates(tpl *template.Template, dir string) error {
 	return nil
 }
 
+// watchSearchContexts reloads the search contexts file name into contexts
+// whenever its modification time changes, checking every interval. A file
+// which fails to load keeps the previous contexts.
+func watchSearchContexts(contexts *web.SearchContexts, name string, interval time.Duration) {
+	var modTime time.Time
+	if fi, err := os.Stat(name); err == nil {
+		modTime = fi.ModTime()
+	}
+	for range time.Tick(interval) {
+		fi, err := os.Stat(name)
+		if err != nil || fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+		if err := contexts.Load(name); err != nil {
+			log.Printf("WARN keeping the previous search contexts: %v", err)
+			continue
+		}
+		log.Printf("reloaded search contexts from %s", name)
+	}
+}
+
 func writeTemplates(dir string) error {
 	if dir == "" {
 		return fmt.Errorf("must set --template_dir")
@@ -139,19 +170,39 @@ func main() {
 
 	listen := flag.String("listen", ":6070", "listen on this address.")
 	indexDir := flag.String("index", index.DefaultDir, "set index directory to use")
+	overlayIndex := flag.String("overlay_index", "", "if set, also search the shards in this directory. Its repositories shadow repositories with the same ID (or name, for repositories without ID) in --index or --federate, for example to search preview builds of a repository.")
+	indexBucket := flag.String("index_bucket", "", "if set, load the shards from this bucket instead of --index, which becomes the local cache of the downloaded shards. One of s3://BUCKET/PREFIX, gs://BUCKET/PREFIX or file:///DIR. Credentials are found like the AWS and Google Cloud SDKs do, eg. in AWS_ACCESS_KEY_ID or GOOGLE_APPLICATION_CREDENTIALS, and startup fails without them unless the URL ends in ?anonymous=true, see objstore.Open.")
+	indexBucketInterval := flag.Duration("index_bucket_interval", time.Minute, "if using --index_bucket, how often to check the bucket for changed shards.")
+	federate := flag.String("federate", "", "comma separated list of host:port of zoekt-webserver instances. If set, searches are fanned out to these instances instead of the shards in --index.")
 	html := flag.Bool("html", true, "enable HTML interface")
 	enableRPC := flag.Bool("rpc", false, "enable go/net RPC")
 	enableIndexserverProxy := flag.Bool("indexserver_proxy", false, "proxy requests with URLs matching the path /indexserver/ to <index>/indexserver.sock")
+	readOnly := flag.Bool("read_only", false, "never write to --index, eg. on a read replica whose shards are synced by zoekt-shard-sync. The index directory must exist, and --indexserver_proxy is refused since it forwards requests which modify the index.")
 	print := flag.Bool("print", false, "enable local result URLs")
 	enablePprof := flag.Bool("pprof", false, "set to enable remote profiling.")
 	sslCert := flag.String("ssl_cert", "", "set path to SSL .pem holding certificate.")
 	sslKey := flag.String("ssl_key", "", "set path to SSL .pem holding key.")
+	drainTimeout := flag.Duration("drain_timeout", 10*time.Second, "on SIGTERM, how long to wait for in-flight searches to finish before shutting down.")
+	rerankURL := flag.String("rerank_url", "", "EXPERIMENTAL: URL of a service which re-ranks the results of HTML searches, see web.NewHTTPReranker.")
+	rerankTimeout := flag.Duration("rerank_timeout", time.Second, "EXPERIMENTAL: how long to wait for --rerank_url before showing unranked results.")
+	rateLimitQPS := flag.Float64("ratelimit_qps", 0, "if set, limit each client to this many HTTP and gRPC requests per second. Requests over the limit are answered with 429 Too Many Requests, gRPC calls fail with RESOURCE_EXHAUSTED. Health checks, metrics and debug pages which do not search are not limited.")
+	rateLimitBurst := flag.Int("ratelimit_burst", 10, "the number of requests a client may send at once on top of --ratelimit_qps.")
+	rateLimitConcurrency := flag.Int("ratelimit_concurrency", 0, "if set, limit each client to this many concurrent HTTP and gRPC requests.")
+	repoFilterHeader := flag.String("repo_filter_header", "", "if set, restrict the searches of the HTML interface, the JSON API and gRPC to the comma separated repository IDs in this request header (gRPC metadata), eg. set by an authenticating proxy. Requests without the header see no repositories.")
+	maxEstimatedCost := flag.Int64("max_estimated_cost", 0, "if set, reject searches of the HTML interface, the JSON API and gRPC whose estimated cost exceeds this many bytes read, with an error suggesting how to narrow the query. /debug/explain shows the cost of a query.")
+	searchContexts := flag.String("search_contexts", "", "if set, a JSON file of search contexts which queries select with context:NAME, see web.SearchContexts. The file is reloaded when it changes, and /api/contexts lists the contexts.")
+	unindexedSearchCommand := flag.String("unindexed_search_command", "", "if set, the space separated command and arguments which search commits of rev: atoms that are not indexed, see web.UnindexedSearch. It is run with the repository name, the commit and a regular expression appended, and prints matches like git grep --null --line-number.")
+	unindexedSearchTimeout := flag.Duration("unindexed_search_timeout", 10*time.Second, "how long --unindexed_search_command may run for a repository before its results so far are shown.")
+	unindexedSearchMaxLines := flag.Int("unindexed_search_max_lines", 1000, "how many matching lines of --unindexed_search_command are read for a repository.")
+	highlightStyle := flag.String("highlight_style", "", "if set, syntax highlight the result snippets of the HTML interface with this chroma style, eg. github. The language of a file is the one detected when indexing it.")
+	rateLimitClientKey := flag.String("ratelimit_client_key", "ip", "how rate limits identify clients: ip, or header:NAME to use the value of a request header (gRPC metadata key) such as an API key. The header is trusted, a client which can choose its value gets a fresh quota per value. Only use header:NAME behind a proxy which sets or validates it.")
 	hostCustomization := flag.String(
 		"host_customization", "",
 		"specify host customization, as HOST1=QUERY,HOST2=QUERY")
 
 	templateDir := flag.String("template_dir", "", "set directory from which to load custom .html.tpl template files")
 	dumpTemplates := flag.Bool("dump_templates", false, "dump templates into --template_dir and exit.")
+	snapshots := flag.Bool("snapshots", false, "search the snapshots of --index taken by zoekt-sourcegraph-indexserver -snapshot_retention for queries with index_at:YYYY-MM-DD.")
 	version := flag.Bool("version", false, "Print version number")
 
 	flag.Parse()
@@ -168,6 +219,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if err := index.ValidateShardIO(); err != nil {
+		log.Fatal(err)
+	}
+
 	resource := sglog.Resource{
 		Name:       "zoekt-webserver",
 		Version:    index.Version,
@@ -192,11 +247,24 @@ func main() {
 	// Tune GOMAXPROCS to match Linux container CPU quota.
 	_, _ = maxprocs.Set()
 
-	if err := os.MkdirAll(*indexDir, 0o755); err != nil {
+	if *readOnly {
+		if *enableIndexserverProxy {
+			log.Fatal("--indexserver_proxy cannot be used with --read_only")
+		}
+		if *indexBucket != "" {
+			log.Fatal("--index_bucket cannot be used with --read_only, since shards are downloaded to --index")
+		}
+		if fi, err := os.Stat(*indexDir); err != nil {
+			log.Fatal(err)
+		} else if !fi.IsDir() {
+			log.Fatalf("%s is not a directory", *indexDir)
+		}
+	} else if err := os.MkdirAll(*indexDir, 0o755); err != nil {
 		log.Fatal(err)
 	}
 
 	mustRegisterDiskMonitor(*indexDir)
+	mustRegisterShardIOMetrics()
 
 	metricsLogger := sglog.Scoped("metricsRegistration")
 
@@ -207,19 +275,47 @@ func main() {
 
 	prometheus.DefaultRegisterer.MustRegister(c)
 
-	// Do not block on loading shards so we can become partially available
-	// sooner. Otherwise on large instances zoekt can be unavailable on the
-	// order of minutes.
-	searcher, err := shards.NewDirectorySearcherFast(*indexDir)
+	var (
+		searcher zoekt.Streamer
+		err      error
+	)
+	if *federate != "" {
+		searcher, err = federation.NewSearcher(strings.Split(*federate, ","))
+	} else if *indexBucket != "" {
+		searcher, err = newBucketSearcher(*indexBucket, *indexDir, *indexBucketInterval)
+	} else {
+		// Do not block on loading shards so we can become partially available
+		// sooner. Otherwise on large instances zoekt can be unavailable on the
+		// order of minutes.
+		searcher, err = shards.NewDirectorySearcherFast(*indexDir)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *overlayIndex != "" {
+		overlaySearcher, err := shards.NewDirectorySearcherFast(*overlayIndex)
+		if err != nil {
+			log.Fatal(err)
+		}
+		searcher = overlay.NewSearcher(searcher, overlaySearcher)
+	}
+
+	if *snapshots {
+		if *federate != "" {
+			log.Fatal("--snapshots cannot be used with --federate")
+		}
+		searcher = snapshot.NewSearcher(*indexDir, searcher)
+	}
+
 	searcher = &loggedSearcher{
 		Streamer: searcher,
 		Logger:   sglog.Scoped("searcher"),
 	}
 
+	inflight := &inflightSearcher{Streamer: searcher}
+	searcher = inflight
+
 	s := &web.Server{
 		Searcher: searcher,
 		Top:      web.Top,
@@ -235,6 +331,29 @@ func main() {
 	s.Print = *print
 	s.HTML = *html
 	s.RPC = *enableRPC
+	s.RepoFilterHeader = *repoFilterHeader
+	s.MaxEstimatedCost = *maxEstimatedCost
+	s.HighlightStyle = *highlightStyle
+
+	if *unindexedSearchCommand != "" {
+		s.UnindexedSearch = &web.UnindexedSearch{
+			Command:  strings.Fields(*unindexedSearchCommand),
+			Timeout:  *unindexedSearchTimeout,
+			MaxLines: *unindexedSearchMaxLines,
+		}
+	}
+
+	if *searchContexts != "" {
+		s.SearchContexts = &web.SearchContexts{}
+		if err := s.SearchContexts.Load(*searchContexts); err != nil {
+			log.Fatalf("loading search contexts: %v", err)
+		}
+		go watchSearchContexts(s.SearchContexts, *searchContexts, time.Minute)
+	}
+
+	if *rerankURL != "" {
+		s.Reranker = web.NewHTTPReranker(*rerankURL, *rerankTimeout)
+	}
 
 	if *hostCustomization != "" {
 		s.HostCustomQueries = map[string]string{}
@@ -256,7 +375,20 @@ func main() {
 		log.Fatal(err)
 	}
 
-	debugserver.AddHandlers(serveMux, *enablePprof)
+	debugserver.AddHandlers(serveMux, *enablePprof, debugserver.DebugPage{
+		Href:        "debug/explain",
+		Text:        "Explain",
+		Description: "explain how a query is parsed, simplified and evaluated per shard",
+	}, debugserver.DebugPage{
+		Href:        "debug/hot",
+		Text:        "Hot shards",
+		Description: "shards by the number of searches they served, with bytes loaded and average latency",
+	}, debugserver.DebugPage{
+		Href:        "debug/queries",
+		Text:        "Queries",
+		Description: "searches in progress, which can be cancelled with POST /debug/queries/ID/cancel",
+	})
+	serveMux.Handle("/debug/hot", shards.HotHandler())
 
 	if *enableIndexserverProxy {
 		socket := filepath.Join(*indexDir, "indexserver.sock")
@@ -264,7 +396,23 @@ func main() {
 		addProxyHandler(serveMux, socket)
 	}
 
-	handler := trace.Middleware(serveMux)
+	clientKey, err := ratelimit.ClientKeyFunc(*rateLimitClientKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grpcClientKey, err := ratelimit.GRPCClientKeyFunc(*rateLimitClientKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	limiter := ratelimit.New(ratelimit.Options{
+		QPS:           *rateLimitQPS,
+		Burst:         *rateLimitBurst,
+		MaxConcurrent: *rateLimitConcurrency,
+		ClientKey:     clientKey,
+		GRPCClientKey: grpcClientKey,
+	})
+
+	handler := trace.Middleware(rateLimited(limiter, serveMux))
 
 	// Sourcegraph: We use environment variables to configure watchdog since
 	// they are more convenient than flags in containerized environments.
@@ -295,7 +443,23 @@ func main() {
 	logger := sglog.Scoped("ZoektWebserverGRPCServer")
 
 	streamer := web.NewTraceAwareSearcher(s.Searcher)
-	grpcServer := newGRPCServer(logger, streamer)
+	grpcOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(limiter.UnaryServerInterceptor),
+		grpc.ChainStreamInterceptor(limiter.StreamServerInterceptor),
+	}
+	if *repoFilterHeader != "" {
+		// gRPC calls are restricted by the same header, sent as metadata, so
+		// that clients can't bypass the filter by speaking gRPC.
+		streamer = web.NewRepoFilterSearcher(streamer)
+		grpcOpts = append(grpcOpts,
+			grpc.ChainUnaryInterceptor(repoFilterUnaryInterceptor(*repoFilterHeader)),
+			grpc.ChainStreamInterceptor(repoFilterStreamInterceptor(*repoFilterHeader)),
+		)
+	}
+	if *maxEstimatedCost > 0 {
+		streamer = web.NewCostLimitSearcher(streamer, *maxEstimatedCost)
+	}
+	grpcServer := newGRPCServer(logger, streamer, grpcOpts...)
 
 	handler = multiplexGRPC(grpcServer, handler)
 
@@ -319,18 +483,23 @@ func main() {
 		}
 	}()
 
-	if s.RPC {
-		// Our RPC system does not support shutdown and hijacks the underlying
-		// http connection. This means shutdown is ineffective and just waits 10s
-		// before calling close. Lets just quit faster in that case.
-		if err := closeOnSignal(srv); err != nil {
-			log.Fatalf("http.Server.Close: %v", err)
-		}
-	} else {
-		if err := shutdownOnSignal(srv); err != nil {
-			log.Fatalf("http.Server.Shutdown: %v", err)
-		}
+	if err := shutdownOnSignal(srv, s, inflight, *drainTimeout); err != nil {
+		log.Fatalf("http.Server.Shutdown: %v", err)
+	}
+}
+
+// newBucketSearcher returns a searcher of the shards in the bucket at
+// bucketURL, which are downloaded to cacheDir.
+func newBucketSearcher(bucketURL, cacheDir string, interval time.Duration) (zoekt.Streamer, error) {
+	bucket, err := objstore.Open(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := shards.NewBucketStorage(bucket, cacheDir, interval)
+	if err != nil {
+		return nil, err
 	}
+	return shards.NewStorageSearcherFast(storage)
 }
 
 // multiplexGRPC takes a gRPC server and a plain HTTP handler and multiplexes the
@@ -351,6 +520,66 @@ func multiplexGRPC(grpcServer *grpc.Server, httpHandler http.Handler) http.Handl
 	return h2c.NewHandler(newHandler, &http2.Server{})
 }
 
+// repoFilterContext restricts ctx to the repository IDs in the metadata key
+// header of the incoming gRPC call. Calls without the metadata see no
+// repositories.
+func repoFilterContext(ctx context.Context, header string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx, err := web.NewRepoFilterContext(ctx, strings.Join(md.Get(header), ","))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s metadata: %v", header, err)
+	}
+	return ctx, nil
+}
+
+func repoFilterUnaryInterceptor(header string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := repoFilterContext(ctx, header)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func repoFilterStreamInterceptor(header string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := repoFilterContext(ss.Context(), header)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &repoFilterServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// repoFilterServerStream overrides the context of a grpc.ServerStream.
+type repoFilterServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *repoFilterServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// rateLimited applies limiter to all requests to next except for health
+// checks, metrics and debug pages, which must keep working when a client
+// exhausts its quota. /debug/explain runs searches, so it is limited.
+func rateLimited(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	limited := limiter.Middleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/debug/explain":
+			limited.ServeHTTP(w, r)
+		case r.URL.Path == "/healthz", r.URL.Path == "/livez", r.URL.Path == "/readyz",
+			r.URL.Path == "/metrics", strings.HasPrefix(r.URL.Path, "/debug"):
+			next.ServeHTTP(w, r)
+		default:
+			limited.ServeHTTP(w, r)
+		}
+	})
+}
+
 // addProxyHandler adds a handler to "mux" that proxies all requests with base
 // /indexserver to "socket".
 func addProxyHandler(mux *http.ServeMux, socket string) {
@@ -379,26 +608,18 @@ func shutdownSignalChan(maxReads int) <-chan os.Signal {
 	return c
 }
 
-// closeOnSignal will listen for SIGINT or SIGTERM and call srv.Close. This is
-// not a graceful shutdown, see shutdownOnSignal.
-func closeOnSignal(srv *http.Server) error {
-	c := shutdownSignalChan(1)
-	<-c
-
-	return srv.Close()
-}
-
-// shutdownOnSignal will listen for SIGINT or SIGTERM and call srv.Shutdown.
-// Note it doesn't call anything else for shutting down. Notably our RPC
-// framework doesn't allow us to drain connections, so when Shutdown we will
-// wait 10s before closing.
-//
-// Note: the call site for shutdownOnSignal should use closeOnSignal instead
-// if rpc mode is enabled due to the above limitation.
-func shutdownOnSignal(srv *http.Server) error {
+// shutdownOnSignal will listen for SIGINT or SIGTERM, put s into lame duck
+// mode, wait up to drainTimeout for in-flight searches to finish and then
+// shut down srv. In-flight searches include gRPC streams and RPC calls,
+// which srv.Shutdown does not know about since they run on hijacked
+// connections.
+func shutdownOnSignal(srv *http.Server, s *web.Server, inflight *inflightSearcher, drainTimeout time.Duration) error {
 	c := shutdownSignalChan(2)
 	<-c
 
+	log.Printf("entering lame duck mode")
+	s.EnterLameDuck()
+
 	// If we receive another signal, immediate shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -411,16 +632,79 @@ func shutdownOnSignal(srv *http.Server) error {
 		}
 	}()
 
-	// Wait for 10s to drain ongoing requests. Kubernetes gives us 30s to
-	// shutdown, we have already used 15s waiting for our endpoint removal to
-	// propagate.
-	ctx, cancel2 := context.WithTimeout(ctx, 10*time.Second)
+	// By default we wait 10s to drain ongoing requests. Kubernetes gives us
+	// 30s to shutdown, we have already used 15s waiting for our endpoint
+	// removal to propagate.
+	ctx, cancel2 := context.WithTimeout(ctx, drainTimeout)
 	defer cancel2()
 
+	if err := inflight.wait(ctx); err != nil {
+		log.Printf("%d searches still running after drain timeout", inflight.count.Load())
+	}
+
 	log.Printf("shutting down")
+
+	// Our RPC system hijacks the underlying http connection, so
+	// srv.Shutdown would wait for it until ctx is done. We have already
+	// drained the searches, so just close the remaining connections.
+	if s.RPC {
+		return srv.Close()
+	}
 	return srv.Shutdown(ctx)
 }
 
+// inflightSearcher counts running searches so that we can wait for them to
+// finish before shutting down.
+type inflightSearcher struct {
+	zoekt.Streamer
+	count atomic.Int64
+}
+
+func (s *inflightSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.count.Add(1)
+	defer s.count.Add(-1)
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *inflightSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	s.count.Add(1)
+	defer s.count.Add(-1)
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s *inflightSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	s.count.Add(1)
+	defer s.count.Add(-1)
+	return zoekt.BatchSearch(ctx, s.Streamer, qs, opts)
+}
+
+func (s *inflightSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	s.count.Add(1)
+	defer s.count.Add(-1)
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s *inflightSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	s.count.Add(1)
+	defer s.count.Add(-1)
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+// wait blocks until no searches are running or ctx is done.
+func (s *inflightSearcher) wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.count.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 func watchdogOnce(ctx context.Context, client *http.Client, addr string) error {
 	defer metricWatchdogTotal.Inc()
 
@@ -485,11 +769,7 @@ Possible remediations:
 }
 
 func diskUsage(path string) (*disk.UsageStat, error) {
-	duPath := path
-	if runtime.GOOS == "windows" {
-		duPath = filepath.VolumeName(duPath)
-	}
-	usage, err := disk.Usage(duPath)
+	usage, err := disk.Usage(diskUsagePath(path))
 	if err != nil {
 		return nil, fmt.Errorf("diskUsage: %w", err)
 	}
@@ -502,10 +782,10 @@ func mustRegisterDiskMonitor(path string) {
 		Help:        "Amount of free space disk space.",
 		ConstLabels: prometheus.Labels{"path": path},
 	}, func() float64 {
-		// I know there is no error handling here, and I don't like it
-		// but there was no error handling in the previous version
-		// that used Statfs, either, so I'm assuming there's no need for it
-		usage, _ := diskUsage(path)
+		usage, err := diskUsage(path)
+		if err != nil {
+			return math.NaN()
+		}
 		return float64(usage.Free)
 	}))
 
@@ -514,14 +794,39 @@ func mustRegisterDiskMonitor(path string) {
 		Help:        "Amount of total disk space.",
 		ConstLabels: prometheus.Labels{"path": path},
 	}, func() float64 {
-		// I know there is no error handling here, and I don't like it
-		// but there was no error handling in the previous version
-		// that used Statfs, either, so I'm assuming there's no need for it
-		usage, _ := diskUsage(path)
+		usage, err := diskUsage(path)
+		if err != nil {
+			return math.NaN()
+		}
 		return float64(usage.Total)
 	}))
 }
 
+// mustRegisterShardIOMetrics exports how the IO tuning of ZOEKT_SHARD_IO
+// affects the resident memory of the process.
+func mustRegisterShardIOMetrics() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "zoekt_shard_io_locked_bytes",
+		Help: "Size of the shard sections locked in memory.",
+	}, func() float64 {
+		return float64(index.ReadShardIOStats().LockedBytes)
+	}))
+
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "zoekt_shard_io_willneed_bytes_total",
+		Help: "Total size of the shard ranges read ahead because a search was about to read them.",
+	}, func() float64 {
+		return float64(index.ReadShardIOStats().WillNeedBytes)
+	}))
+
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "zoekt_shard_io_dontneed_bytes_total",
+		Help: "Total size of the shard ranges dropped from memory after a search scanned them.",
+	}, func() float64 {
+		return float64(index.ReadShardIOStats().DontNeedBytes)
+	}))
+}
+
 type loggedSearcher struct {
 	zoekt.Streamer
 	Logger sglog.Logger
@@ -563,6 +868,33 @@ func (s *loggedSearcher) StreamSearch(
 	return err
 }
 
+func (s *loggedSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s *loggedSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+func (s *loggedSearcher) BatchSearch(
+	ctx context.Context,
+	qs []query.Q,
+	opts *zoekt.SearchOptions,
+) (results []*zoekt.SearchResult, err error) {
+	metricSearchRequestsTotal.Add(float64(len(qs)))
+	results, err = zoekt.BatchSearch(ctx, s.Streamer, qs, opts)
+
+	for i, q := range qs {
+		var stats *zoekt.Stats
+		if err == nil {
+			stats = &results[i].Stats
+		}
+		s.log(ctx, q, opts, stats, err)
+	}
+
+	return results, err
+}
+
 func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, st *zoekt.Stats, err error) {
 	logger := s.Logger.
 		WithTrace(traceContext(ctx)).
@@ -603,6 +935,9 @@ func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchO
 		sglog.Int("stat.FilesConsidered", st.FilesConsidered),
 		sglog.Int("stat.FilesLoaded", st.FilesLoaded),
 		sglog.Int("stat.FilesSkipped", st.FilesSkipped),
+		sglog.Int("stat.FilesSkippedTombstoned", st.FilesSkippedTombstoned),
+		sglog.Int("stat.FilesSkippedSize", st.FilesSkippedSize),
+		sglog.Int("stat.FilesSkippedBinary", st.FilesSkippedBinary),
 		sglog.Int("stat.ShardsScanned", st.ShardsScanned),
 		sglog.Int("stat.ShardsSkipped", st.ShardsSkipped),
 		sglog.Int("stat.ShardsSkippedFilter", st.ShardsSkippedFilter),
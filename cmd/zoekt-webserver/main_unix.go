@@ -7,3 +7,8 @@ import (
 )
 
 const PLATFORM_SIGTERM = platform.SIGTERM
+
+// diskUsagePath returns the path to query the disk usage of path with.
+func diskUsagePath(path string) string {
+	return path
+}
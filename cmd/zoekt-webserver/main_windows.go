@@ -1,7 +1,21 @@
 package main
 
 import (
+	"path/filepath"
+
 	platform "golang.org/x/sys/windows"
 )
 
 const PLATFORM_SIGTERM = platform.SIGTERM
+
+// diskUsagePath returns the volume of path, eg. "C:", since Windows reports
+// the disk usage of volumes.
+func diskUsagePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if v := filepath.VolumeName(path); v != "" {
+		return v
+	}
+	return path
+}
@@ -16,8 +16,10 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -26,6 +28,7 @@ import (
 	"path/filepath"
 	"runtime/pprof"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/felixge/fgprof"
@@ -35,22 +38,106 @@ import (
 	"github.com/sourcegraph/zoekt/query"
 )
 
-func displayMatches(files []zoekt.FileMatch, pat string, withRepo bool, list bool) {
-	for _, f := range files {
-		r := ""
-		if withRepo {
-			r = f.Repository + "/"
+// output describes how matches are written.
+type output struct {
+	// format is "text", "json", "ndjson" or "template".
+	format string
+	// tmpl is executed for every file match with -format template.
+	tmpl *template.Template
+
+	withRepo bool
+	// list and count only apply to the text format.
+	list  bool
+	count bool
+}
+
+// newOutput validates the output flags.
+func newOutput(format, tmpl string, withRepo, list, count bool) (*output, error) {
+	o := &output{format: format, withRepo: withRepo, list: list, count: count}
+	switch format {
+	case "text":
+	case "json", "ndjson":
+	case "template":
+		if tmpl == "" {
+			return nil, fmt.Errorf("-format template needs -template")
+		}
+		if !strings.HasSuffix(tmpl, "\n") {
+			tmpl += "\n"
 		}
-		if list {
-			fmt.Printf("%s%s%s\n", r, f.FileName, addTabIfNonEmpty(f.Debug))
-			continue
+		t, err := template.New("file").Parse(tmpl)
+		if err != nil {
+			return nil, err
 		}
+		o.tmpl = t
+	default:
+		return nil, fmt.Errorf("unknown format %q, want text, json, ndjson or template", format)
+	}
+	if format != "text" && (list || count) {
+		return nil, fmt.Errorf("-l and -count only apply to -format text")
+	}
+	if list && count {
+		return nil, fmt.Errorf("-l and -count can't be combined")
+	}
+	return o, nil
+}
 
-		for _, m := range f.LineMatches {
-			l := bytes.TrimSuffix(m.Line, []byte{'\n'})
-			fmt.Printf("%s%s:%d:%s%s\n", r, f.FileName, m.LineNumber, l, addTabIfNonEmpty(f.Debug))
+// write writes the matches of sres to w. The json format writes the whole
+// result like the JSON API, ndjson one file match per line.
+func (o *output) write(w io.Writer, sres *zoekt.SearchResult) error {
+	switch o.format {
+	case "json":
+		return json.NewEncoder(w).Encode(sres)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for i := range sres.Files {
+			if err := enc.Encode(&sres.Files[i]); err != nil {
+				return err
+			}
 		}
+		return nil
+	case "template":
+		for i := range sres.Files {
+			if err := o.tmpl.Execute(w, &sres.Files[i]); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range sres.Files {
+		r := ""
+		if o.withRepo {
+			r = f.Repository + "/"
+		}
+		switch {
+		case o.list:
+			fmt.Fprintf(bw, "%s%s%s\n", r, f.FileName, addTabIfNonEmpty(f.Debug))
+		case o.count:
+			fmt.Fprintf(bw, "%s%s:%d\n", r, f.FileName, len(f.LineMatches))
+		default:
+			for _, m := range f.LineMatches {
+				l := bytes.TrimSuffix(m.Line, []byte{'\n'})
+				fmt.Fprintf(bw, "%s%s:%d:%s%s\n", r, f.FileName, m.LineNumber, l, addTabIfNonEmpty(f.Debug))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// The exit codes follow grep and ripgrep, so that scripts can tell "no
+// matches" from a failure.
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+)
+
+// fatal reports err and exits with exitError. Unlike log.Fatal, it also
+// reports errors without -v.
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "zoekt: %v\n", err)
+	os.Exit(exitError)
 }
 
 func addTabIfNonEmpty(s string) string {
@@ -97,7 +184,7 @@ func profile(path string, duration time.Duration, start func(io.Writer) (stop fu
 
 	f, err := os.Create(path)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	t := time.Now()
@@ -116,7 +203,7 @@ func profile(path string, duration time.Duration, start func(io.Writer) (stop fu
 func startCPUProfile(path string, duration time.Duration) func() bool {
 	return profile(path, duration, func(w io.Writer) func() {
 		if err := pprof.StartCPUProfile(w); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 
 		return pprof.StopCPUProfile
@@ -129,7 +216,7 @@ func startFullProfile(path string, duration time.Duration) func() bool {
 
 		return func() {
 			if err := stop(); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 		}
 	})
@@ -163,7 +250,12 @@ func main() {
 	debug := flag.Bool("debug", false, "show debugscore output.")
 	verbose := flag.Bool("v", false, "print some background data")
 	withRepo := flag.Bool("r", false, "print the repo before the file name")
-	list := flag.Bool("l", false, "print matching filenames only")
+	var list bool
+	flag.BoolVar(&list, "l", false, "print matching filenames only")
+	flag.BoolVar(&list, "files-with-matches", false, "same as -l")
+	count := flag.Bool("count", false, "print the number of matching lines of each matching file")
+	format := flag.String("format", "text", "output format: text; json for the search result as a single JSON object, with the offsets and scores of the matches; ndjson for one JSON file match per line; template to execute -template for every file match")
+	tmpl := flag.String("template", "", "Go `template` to execute for every file match with -format template, eg. '{{.Repository}}/{{.FileName}} {{.Score}}'. The fields are those of zoekt.FileMatch.")
 	sym := flag.Bool("sym", false, "do experimental symbol search")
 
 	flag.Usage = func() {
@@ -171,23 +263,27 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage:\n\n  %s [option] QUERY\n"+
 			"for example\n\n  %s byte file:java -file:test\n\n", name, name)
 		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "\nThe exit status is 0 if a file matched, 1 if none did and 2 on errors.\n")
 	}
 	flag.Parse()
 
 	if len(flag.Args()) == 0 {
 		fmt.Fprintf(os.Stderr, "Pattern is missing.\n")
 		flag.Usage()
-		os.Exit(2)
+		os.Exit(exitError)
 	}
 	pat := strings.Join(flag.Args(), " ")
 
+	out, err := newOutput(*format, *tmpl, *withRepo, list, *count)
+	if err != nil {
+		fatal(err)
+	}
+
 	if !*verbose {
 		log.SetOutput(io.Discard)
 	}
 
 	var searcher zoekt.Searcher
-	var err error
 	if *shard != "" {
 		searcher, err = loadShard(*shard, *verbose)
 	} else {
@@ -195,12 +291,12 @@ func main() {
 	}
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	q, err := query.Parse(pat)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	q = query.Map(q, query.ExpandFileContent)
 	if *sym {
@@ -214,9 +310,13 @@ func main() {
 	sOpts := zoekt.SearchOptions{
 		DebugScore: *debug,
 	}
+	if list {
+		// -l only prints the file names, so skip assembling the matches.
+		sOpts.Fields = zoekt.FieldFileName
+	}
 	sres, err := searcher.Search(context.Background(), q, &sOpts)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	// If profiling, do it another time so we measure with
@@ -228,8 +328,14 @@ func main() {
 		sres, _ = searcher.Search(context.Background(), q, &sOpts)
 	}
 
-	displayMatches(sres.Files, pat, *withRepo, *list)
+	if err := out.write(os.Stdout, sres); err != nil {
+		fatal(err)
+	}
 	if *verbose {
 		log.Printf("stats: %#v", sres.Stats)
 	}
+	if len(sres.Files) == 0 {
+		os.Exit(exitNoMatch)
+	}
+	os.Exit(exitMatch)
 }
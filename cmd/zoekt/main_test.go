@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestOutput(t *testing.T) {
+	sres := &zoekt.SearchResult{
+		Files: []zoekt.FileMatch{{
+			Repository: "repo",
+			FileName:   "a.go",
+			Score:      10,
+			LineMatches: []zoekt.LineMatch{
+				{Line: []byte("needle one\n"), LineNumber: 1, LineFragments: []zoekt.LineFragmentMatch{{LineOffset: 0, Offset: 0, MatchLength: 6}}},
+				{Line: []byte("two needle\n"), LineNumber: 2, LineFragments: []zoekt.LineFragmentMatch{{LineOffset: 4, Offset: 15, MatchLength: 6}}},
+			},
+		}, {
+			Repository:  "repo",
+			FileName:    "b.go",
+			Score:       5,
+			LineMatches: []zoekt.LineMatch{{Line: []byte("needle"), LineNumber: 3}},
+		}},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		format   string
+		tmpl     string
+		withRepo bool
+		list     bool
+		count    bool
+		want     string
+	}{
+		{name: "text", format: "text", want: "a.go:1:needle one\na.go:2:two needle\nb.go:3:needle\n"},
+		{name: "list", format: "text", withRepo: true, list: true, want: "repo/a.go\nrepo/b.go\n"},
+		{name: "count", format: "text", count: true, want: "a.go:2\nb.go:1\n"},
+		{name: "template", format: "template", tmpl: "{{.FileName}} {{.Score}} {{len .LineMatches}}", want: "a.go 10 2\nb.go 5 1\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := newOutput(tc.format, tc.tmpl, tc.withRepo, tc.list, tc.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := o.write(&buf, sres); err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.want, buf.String()); d != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+
+	// The JSON formats keep the offsets and scores.
+	o, err := newOutput("ndjson", "", false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := o.write(&buf, sres); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines of ndjson, want 2", len(lines))
+	}
+	var fm zoekt.FileMatch
+	if err := json.Unmarshal([]byte(lines[0]), &fm); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(sres.Files[0], fm); d != "" {
+		t.Errorf("ndjson file match mismatch (-want +got):\n%s", d)
+	}
+
+	o, err = newOutput("json", "", false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := o.write(&buf, sres); err != nil {
+		t.Fatal(err)
+	}
+	var got zoekt.SearchResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(sres.Files, got.Files); d != "" {
+		t.Errorf("json files mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestNewOutputErrors(t *testing.T) {
+	for _, tc := range []struct {
+		format, tmpl string
+		list, count  bool
+	}{
+		{format: "xml"},
+		{format: "template"},
+		{format: "template", tmpl: "{{.FileName"},
+		{format: "json", list: true},
+		{format: "ndjson", count: true},
+		{format: "text", list: true, count: true},
+	} {
+		if _, err := newOutput(tc.format, tc.tmpl, false, tc.list, tc.count); err == nil {
+			t.Errorf("%+v: got no error", tc)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Package checksum computes end-to-end checksums of streamed search results.
+// They allow clients to detect responses which were truncated or corrupted
+// on the way, for example by a misbehaving proxy, which gRPC itself does not
+// notice.
+package checksum
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	gproto "google.golang.org/protobuf/proto"
+
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// FileMatch returns the CRC-32C of the payload of fm. It covers the fields
+// which identify the file and its matches, but not debug output.
+func FileMatch(fm *proto.FileMatch) uint32 {
+	w := writer{crc32.New(table)}
+
+	w.bytes(fm.GetFileName())
+	w.string(fm.GetRepository())
+	w.uint64(uint64(fm.GetRepositoryId()))
+	w.string(fm.GetSubRepositoryName())
+	w.string(fm.GetSubRepositoryPath())
+	w.string(fm.GetVersion())
+	w.string(fm.GetLanguage())
+	w.uint64(uint64(len(fm.GetBranches())))
+	for _, b := range fm.GetBranches() {
+		w.string(b)
+	}
+	w.float64(fm.GetScore())
+	w.bytes(fm.GetContent())
+	w.bytes(fm.GetChecksum())
+
+	w.lineMatches(fm.GetLineMatches())
+	w.chunkMatches(fm.GetChunkMatches())
+
+	// Only covered if present, so that checksums of ungrouped and
+	// undeduplicated results do not depend on the version of the peer.
+	if bms := fm.GetBranchMatches(); len(bms) > 0 {
+		w.uint64(uint64(len(bms)))
+		for _, bm := range bms {
+			w.uint64(uint64(len(bm.GetBranches())))
+			for _, b := range bm.GetBranches() {
+				w.string(b)
+			}
+			w.string(bm.GetVersion())
+			w.float64(bm.GetScore())
+			w.bytes(bm.GetContent())
+			w.bytes(bm.GetChecksum())
+			w.lineMatches(bm.GetLineMatches())
+			w.chunkMatches(bm.GetChunkMatches())
+		}
+	}
+	if dups := fm.GetDuplicateRepositories(); len(dups) > 0 {
+		w.uint64(uint64(len(dups)))
+		for _, r := range dups {
+			w.string(r)
+		}
+	}
+
+	return w.h.Sum32()
+}
+
+// writer writes length-prefixed values, so that moving bytes between
+// adjacent fields changes the checksum.
+type writer struct {
+	h hash.Hash32
+}
+
+func (w writer) uint64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	w.h.Write(buf[:])
+}
+
+func (w writer) bytes(b []byte) {
+	w.uint64(uint64(len(b)))
+	w.h.Write(b)
+}
+
+func (w writer) string(s string) {
+	w.bytes([]byte(s))
+}
+
+func (w writer) bool(b bool) {
+	if b {
+		w.uint64(1)
+	} else {
+		w.uint64(0)
+	}
+}
+
+func (w writer) float64(f float64) {
+	w.uint64(math.Float64bits(f))
+}
+
+func (w writer) location(l *proto.Location) {
+	w.uint64(uint64(l.GetByteOffset()))
+	w.uint64(uint64(l.GetLineNumber()))
+	w.uint64(uint64(l.GetColumn()))
+}
+
+func (w writer) lineMatches(lms []*proto.LineMatch) {
+	w.uint64(uint64(len(lms)))
+	for _, lm := range lms {
+		w.bytes(lm.GetLine())
+		w.uint64(uint64(lm.GetLineStart()))
+		w.uint64(uint64(lm.GetLineEnd()))
+		w.uint64(uint64(lm.GetLineNumber()))
+		w.bytes(lm.GetBefore())
+		w.bytes(lm.GetAfter())
+		w.bool(lm.GetFileName())
+		w.uint64(uint64(len(lm.GetLineFragments())))
+		for _, f := range lm.GetLineFragments() {
+			w.uint64(uint64(f.GetLineOffset()))
+			w.uint64(uint64(f.GetOffset()))
+			w.uint64(uint64(f.GetMatchLength()))
+		}
+	}
+}
+
+func (w writer) chunkMatches(cms []*proto.ChunkMatch) {
+	w.uint64(uint64(len(cms)))
+	for _, cm := range cms {
+		w.bytes(cm.GetContent())
+		w.location(cm.GetContentStart())
+		w.bool(cm.GetFileName())
+		w.uint64(uint64(len(cm.GetRanges())))
+		for _, r := range cm.GetRanges() {
+			w.location(r.GetStart())
+			w.location(r.GetEnd())
+		}
+		w.uint64(uint64(cm.GetBestLineMatch()))
+	}
+}
+
+// Stream accumulates the checksum of the file matches sent on a stream. The
+// zero value is an empty stream. Stream is not safe for concurrent use.
+type Stream struct {
+	numFiles uint64
+	crc      uint32
+}
+
+// Sign sets the payload checksum of files.
+func Sign(files []*proto.FileMatch) {
+	for _, fm := range files {
+		fm.PayloadChecksum = FileMatch(fm)
+	}
+}
+
+// Add adds files, which must have been signed with Sign, to the stream.
+func (s *Stream) Add(files []*proto.FileMatch) {
+	for _, fm := range files {
+		s.add(fm.GetPayloadChecksum())
+	}
+}
+
+func (s *Stream) add(sum uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], sum)
+	s.crc = crc32.Update(s.crc, table, buf[:])
+	s.numFiles++
+}
+
+// Checksum returns the checksum of the file matches added so far. final marks
+// the checksum sent as the last response of the stream.
+func (s *Stream) Checksum(final bool) *proto.StreamChecksum {
+	return &proto.StreamChecksum{
+		NumFiles: s.numFiles,
+		Crc32C:   s.crc,
+		Final:    final,
+	}
+}
+
+// Verifier validates the checksums of the responses of a stream, in the order
+// they are received. Servers which don't send checksums are not validated.
+type Verifier struct {
+	stream  Stream
+	enabled bool
+	final   bool
+}
+
+// Verify returns an error if resp is inconsistent with its checksums or with
+// the responses received before it.
+func (v *Verifier) Verify(resp *proto.StreamSearchResponse) error {
+	want := resp.GetChecksum()
+	if want == nil {
+		if v.enabled {
+			return fmt.Errorf("checksum: response without checksum after %d file matches", v.stream.numFiles)
+		}
+		return nil
+	}
+	v.enabled = true
+
+	if v.final {
+		return fmt.Errorf("checksum: response after the final checksum")
+	}
+	v.final = want.GetFinal()
+
+	for _, fm := range resp.GetResponseChunk().GetFiles() {
+		if got := FileMatch(fm); got != fm.GetPayloadChecksum() {
+			return fmt.Errorf("checksum: file match %d (%s/%s) is corrupt: got checksum %08x, want %08x",
+				v.stream.numFiles, fm.GetRepository(), fm.GetFileName(), got, fm.GetPayloadChecksum())
+		}
+		v.stream.add(fm.GetPayloadChecksum())
+	}
+
+	if v.stream.numFiles != want.GetNumFiles() || v.stream.crc != want.GetCrc32C() {
+		return fmt.Errorf("checksum: stream mismatch after %d file matches: got %08x, want %d file matches with %08x",
+			v.stream.numFiles, v.stream.crc, want.GetNumFiles(), want.GetCrc32C())
+	}
+	return nil
+}
+
+// Done returns an error if the stream was truncated, ie. it ended without its
+// final checksum.
+func (v *Verifier) Done() error {
+	if v.enabled && !v.final {
+		return fmt.Errorf("checksum: stream truncated after %d file matches", v.stream.numFiles)
+	}
+	return nil
+}
+
+// StreamClientInterceptor verifies StreamSearch responses for any gRPC client
+// of the webserver service, eg. via grpc.WithChainStreamInterceptor. It asks
+// the server for checksums and fails the stream with codes.DataLoss if a
+// response is corrupt or the stream is truncated.
+//
+// Servers send the final checksum in a response without a ResponseChunk,
+// which callers must skip.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil || method != proto.WebserverService_StreamSearch_FullMethodName {
+		return cs, err
+	}
+	return &verifyingStream{ClientStream: cs}, nil
+}
+
+type verifyingStream struct {
+	grpc.ClientStream
+	verifier Verifier
+}
+
+func (s *verifyingStream) SendMsg(m any) error {
+	if req, ok := m.(*proto.StreamSearchRequest); ok && !req.GetChecksums() {
+		// Don't modify the request of the caller.
+		req = gproto.Clone(req).(*proto.StreamSearchRequest)
+		req.Checksums = true
+		m = req
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *verifyingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if errors.Is(err, io.EOF) {
+		if err := s.verifier.Done(); err != nil {
+			return status.Error(codes.DataLoss, err.Error())
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp, ok := m.(*proto.StreamSearchResponse); ok {
+		if err := s.verifier.Verify(resp); err != nil {
+			return status.Error(codes.DataLoss, err.Error())
+		}
+	}
+	return nil
+}
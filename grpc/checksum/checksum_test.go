@@ -0,0 +1,222 @@
+package checksum
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	gproto "google.golang.org/protobuf/proto"
+
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+)
+
+// stream returns the responses a server sends for two chunks of files.
+func stream() []*proto.StreamSearchResponse {
+	chunks := [][]*proto.FileMatch{{
+		{Repository: "repo", FileName: []byte("a.go"), ChunkMatches: []*proto.ChunkMatch{{
+			Content:      []byte("func a() {}\n"),
+			ContentStart: &proto.Location{LineNumber: 1, Column: 1},
+			Ranges:       []*proto.Range{{Start: &proto.Location{ByteOffset: 5, LineNumber: 1, Column: 6}, End: &proto.Location{ByteOffset: 6, LineNumber: 1, Column: 7}}},
+		}}},
+		{Repository: "repo", FileName: []byte("b.go"), LineMatches: []*proto.LineMatch{{Line: []byte("func b() {}"), LineNumber: 1}}, DuplicateRepositories: []string{"fork1", "fork2"}},
+	}, {
+		{Repository: "repo", FileName: []byte("c.go"), Content: []byte("package c\n"), Branches: []string{"main", "dev"}, BranchMatches: []*proto.BranchMatch{
+			{Branches: []string{"main"}, LineMatches: []*proto.LineMatch{{Line: []byte("package c"), LineNumber: 1}}},
+			{Branches: []string{"dev"}, LineMatches: []*proto.LineMatch{{Line: []byte("package c // dev"), LineNumber: 1}}},
+		}},
+	}}
+
+	var (
+		s     Stream
+		resps []*proto.StreamSearchResponse
+	)
+	for _, files := range chunks {
+		Sign(files)
+		s.Add(files)
+		resps = append(resps, &proto.StreamSearchResponse{
+			ResponseChunk: &proto.SearchResponse{Files: files},
+			Checksum:      s.Checksum(false),
+		})
+	}
+	return append(resps, &proto.StreamSearchResponse{Checksum: s.Checksum(true)})
+}
+
+func verify(resps []*proto.StreamSearchResponse) error {
+	var v Verifier
+	for _, resp := range resps {
+		if err := v.Verify(resp); err != nil {
+			return err
+		}
+	}
+	return v.Done()
+}
+
+func TestVerifier(t *testing.T) {
+	if err := verify(stream()); err != nil {
+		t.Fatalf("valid stream: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		mutate  func([]*proto.StreamSearchResponse) []*proto.StreamSearchResponse
+		wantErr string
+	}{{
+		name: "corrupt content",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			resps[0].ResponseChunk.Files[0].ChunkMatches[0].Content[0] = 'F'
+			return resps
+		},
+		wantErr: "file match 0 (repo/a.go) is corrupt",
+	}, {
+		name: "corrupt range",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			resps[0].ResponseChunk.Files[0].ChunkMatches[0].Ranges[0].End.ByteOffset++
+			return resps
+		},
+		wantErr: "is corrupt",
+	}, {
+		name: "corrupt branch match",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			resps[1].ResponseChunk.Files[0].BranchMatches[1].Branches[0] = "main"
+			return resps
+		},
+		wantErr: "(repo/c.go) is corrupt",
+	}, {
+		name: "corrupt duplicate repositories",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			resps[0].ResponseChunk.Files[1].DuplicateRepositories[1] = "fork3"
+			return resps
+		},
+		wantErr: "file match 1 (repo/b.go) is corrupt",
+	}, {
+		name: "dropped file match",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			resps[0].ResponseChunk.Files = resps[0].ResponseChunk.Files[:1]
+			return resps
+		},
+		wantErr: "stream mismatch after 1 file matches",
+	}, {
+		name: "reordered file matches",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			files := resps[0].ResponseChunk.Files
+			files[0], files[1] = files[1], files[0]
+			return resps
+		},
+		wantErr: "stream mismatch",
+	}, {
+		name: "dropped response",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			return append(resps[:1], resps[2:]...)
+		},
+		wantErr: "stream mismatch",
+	}, {
+		name: "truncated",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			return resps[:2]
+		},
+		wantErr: "stream truncated after 3 file matches",
+	}, {
+		name: "missing checksum",
+		mutate: func(resps []*proto.StreamSearchResponse) []*proto.StreamSearchResponse {
+			resps[1].Checksum = nil
+			return resps
+		},
+		wantErr: "response without checksum",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verify(tc.mutate(stream()))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifier_NoChecksums(t *testing.T) {
+	// Servers which don't support checksums are not validated.
+	var v Verifier
+	resp := &proto.StreamSearchResponse{ResponseChunk: &proto.SearchResponse{
+		Files: []*proto.FileMatch{{Repository: "repo", FileName: []byte("a.go")}},
+	}}
+	if err := v.Verify(resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Done(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeClientStream replays resps and records the messages sent.
+type fakeClientStream struct {
+	grpc.ClientStream
+	resps []*proto.StreamSearchResponse
+	sent  []any
+}
+
+func (s *fakeClientStream) SendMsg(m any) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *fakeClientStream) RecvMsg(m any) error {
+	if len(s.resps) == 0 {
+		return io.EOF
+	}
+	gproto.Merge(m.(*proto.StreamSearchResponse), s.resps[0])
+	s.resps = s.resps[1:]
+	return nil
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	open := func(resps []*proto.StreamSearchResponse) (grpc.ClientStream, *fakeClientStream) {
+		fake := &fakeClientStream{resps: resps}
+		cs, err := StreamClientInterceptor(context.Background(), nil, nil, proto.WebserverService_StreamSearch_FullMethodName,
+			func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+				return fake, nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cs, fake
+	}
+
+	recvAll := func(cs grpc.ClientStream) error {
+		for {
+			if err := cs.RecvMsg(&proto.StreamSearchResponse{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	req := &proto.StreamSearchRequest{}
+	cs, fake := open(stream())
+	if err := cs.SendMsg(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.GetChecksums() {
+		t.Fatal("the request of the caller was modified")
+	}
+	if !fake.sent[0].(*proto.StreamSearchRequest).GetChecksums() {
+		t.Fatal("checksums were not requested")
+	}
+	if err := recvAll(cs); err != io.EOF {
+		t.Fatalf("valid stream: got %v, want io.EOF", err)
+	}
+
+	resps := stream()
+	resps[0].ResponseChunk.Files[0].ChunkMatches[0].Content[0] = 'F'
+	cs, _ = open(resps)
+	if err := recvAll(cs); status.Code(err) != codes.DataLoss {
+		t.Fatalf("corrupt stream: got %v, want DataLoss", err)
+	}
+
+	cs, _ = open(stream()[:2])
+	if err := recvAll(cs); status.Code(err) != codes.DataLoss {
+		t.Fatalf("truncated stream: got %v, want DataLoss", err)
+	}
+}
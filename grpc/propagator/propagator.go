@@ -67,6 +67,49 @@ func UnaryServerPropagator(prop Propagator) grpc.UnaryServerInterceptor {
 	}
 }
 
+// StreamClientPropagator returns an interceptor that will use the given propagator
+// to forward some information from the context across the RPC call. The server
+// should be configured with an interceptor that uses the same propagator.
+func StreamClientPropagator(prop Propagator) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(appendOutgoingContext(ctx, prop), desc, cc, method, opts...)
+	}
+}
+
+// UnaryClientPropagator returns an interceptor that will use the given propagator
+// to forward some information from the context across the RPC call. The server
+// should be configured with an interceptor that uses the same propagator.
+func UnaryClientPropagator(prop Propagator) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(appendOutgoingContext(ctx, prop), method, req, reply, cc, opts...)
+	}
+}
+
+func appendOutgoingContext(ctx context.Context, prop Propagator) context.Context {
+	md := prop.FromContext(ctx)
+	if len(md) == 0 {
+		return ctx
+	}
+	if prev, ok := metadata.FromOutgoingContext(ctx); ok {
+		md = metadata.Join(prev, md)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
 type contextedServerStream struct {
 	grpc.ServerStream
 	ctx context.Context
@@ -19,9 +19,11 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	WebserverService_Search_FullMethodName       = "/zoekt.webserver.v1.WebserverService/Search"
-	WebserverService_StreamSearch_FullMethodName = "/zoekt.webserver.v1.WebserverService/StreamSearch"
-	WebserverService_List_FullMethodName         = "/zoekt.webserver.v1.WebserverService/List"
+	WebserverService_Search_FullMethodName        = "/zoekt.webserver.v1.WebserverService/Search"
+	WebserverService_StreamSearch_FullMethodName  = "/zoekt.webserver.v1.WebserverService/StreamSearch"
+	WebserverService_List_FullMethodName          = "/zoekt.webserver.v1.WebserverService/List"
+	WebserverService_StreamList_FullMethodName    = "/zoekt.webserver.v1.WebserverService/StreamList"
+	WebserverService_FetchDocument_FullMethodName = "/zoekt.webserver.v1.WebserverService/FetchDocument"
 )
 
 // WebserverServiceClient is the client API for WebserverService service.
@@ -33,6 +35,12 @@ type WebserverServiceClient interface {
 	// List lists repositories. The query `q` can only contain
 	// query.Repo atoms.
 	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// StreamList is like List, but streams the repositories in batches. Adding
+	// up the responses yields the response of List.
+	StreamList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (WebserverService_StreamListClient, error)
+	// FetchDocument returns a whole document as stored in the index. It fails
+	// with NOT_FOUND if no indexed document matches the request.
+	FetchDocument(ctx context.Context, in *FetchDocumentRequest, opts ...grpc.CallOption) (*FetchDocumentResponse, error)
 }
 
 type webserverServiceClient struct {
@@ -93,6 +101,47 @@ func (c *webserverServiceClient) List(ctx context.Context, in *ListRequest, opts
 	return out, nil
 }
 
+func (c *webserverServiceClient) StreamList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (WebserverService_StreamListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &WebserverService_ServiceDesc.Streams[1], WebserverService_StreamList_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &webserverServiceStreamListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WebserverService_StreamListClient interface {
+	Recv() (*ListResponse, error)
+	grpc.ClientStream
+}
+
+type webserverServiceStreamListClient struct {
+	grpc.ClientStream
+}
+
+func (x *webserverServiceStreamListClient) Recv() (*ListResponse, error) {
+	m := new(ListResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *webserverServiceClient) FetchDocument(ctx context.Context, in *FetchDocumentRequest, opts ...grpc.CallOption) (*FetchDocumentResponse, error) {
+	out := new(FetchDocumentResponse)
+	err := c.cc.Invoke(ctx, WebserverService_FetchDocument_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // WebserverServiceServer is the server API for WebserverService service.
 // All implementations must embed UnimplementedWebserverServiceServer
 // for forward compatibility
@@ -102,6 +151,12 @@ type WebserverServiceServer interface {
 	// List lists repositories. The query `q` can only contain
 	// query.Repo atoms.
 	List(context.Context, *ListRequest) (*ListResponse, error)
+	// StreamList is like List, but streams the repositories in batches. Adding
+	// up the responses yields the response of List.
+	StreamList(*ListRequest, WebserverService_StreamListServer) error
+	// FetchDocument returns a whole document as stored in the index. It fails
+	// with NOT_FOUND if no indexed document matches the request.
+	FetchDocument(context.Context, *FetchDocumentRequest) (*FetchDocumentResponse, error)
 	mustEmbedUnimplementedWebserverServiceServer()
 }
 
@@ -118,6 +173,12 @@ func (UnimplementedWebserverServiceServer) StreamSearch(*StreamSearchRequest, We
 func (UnimplementedWebserverServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
 }
+func (UnimplementedWebserverServiceServer) StreamList(*ListRequest, WebserverService_StreamListServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamList not implemented")
+}
+func (UnimplementedWebserverServiceServer) FetchDocument(context.Context, *FetchDocumentRequest) (*FetchDocumentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchDocument not implemented")
+}
 func (UnimplementedWebserverServiceServer) mustEmbedUnimplementedWebserverServiceServer() {}
 
 // UnsafeWebserverServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -188,6 +249,45 @@ func _WebserverService_List_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WebserverService_StreamList_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WebserverServiceServer).StreamList(m, &webserverServiceStreamListServer{stream})
+}
+
+type WebserverService_StreamListServer interface {
+	Send(*ListResponse) error
+	grpc.ServerStream
+}
+
+type webserverServiceStreamListServer struct {
+	grpc.ServerStream
+}
+
+func (x *webserverServiceStreamListServer) Send(m *ListResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WebserverService_FetchDocument_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WebserverServiceServer).FetchDocument(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WebserverService_FetchDocument_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WebserverServiceServer).FetchDocument(ctx, req.(*FetchDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // WebserverService_ServiceDesc is the grpc.ServiceDesc for WebserverService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -203,6 +303,10 @@ var WebserverService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "List",
 			Handler:    _WebserverService_List_Handler,
 		},
+		{
+			MethodName: "FetchDocument",
+			Handler:    _WebserverService_FetchDocument_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -210,6 +314,11 @@ var WebserverService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _WebserverService_StreamSearch_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "StreamList",
+			Handler:       _WebserverService_StreamList_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "zoekt/webserver/v1/webserver.proto",
 }
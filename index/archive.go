@@ -0,0 +1,162 @@
+package index
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// ArchiveSeparator separates the name of an archive from the path of a file
+// inside it in the names of documents expanded from archives, eg.
+// lib/foo.jar!com/foo/Bar.java. See Options.ExpandArchives.
+const ArchiveSeparator = "!"
+
+// SplitArchivePath splits the name of a document expanded from an archive
+// into the name of the archive and the path of the file inside it. ok is
+// false if name is not inside an archive.
+func SplitArchivePath(name string) (archive, member string, ok bool) {
+	archive, member, ok = strings.Cut(name, ArchiveSeparator)
+	if !ok || member == "" || archiveFormatOf(archive) == nil {
+		return "", "", false
+	}
+	return archive, member, true
+}
+
+// isTombstoned returns true if the document name is in tombstones. Files
+// expanded from an archive are tombstoned with the archive.
+func isTombstoned(tombstones map[string]struct{}, name string) bool {
+	if _, ok := tombstones[name]; ok {
+		return true
+	}
+	if archive, _, ok := SplitArchivePath(name); ok {
+		_, ok = tombstones[archive]
+		return ok
+	}
+	return false
+}
+
+// archiveFormat reads the files of an archive.
+type archiveFormat struct {
+	suffixes []string
+	// walk calls fn for each regular file in the archive. size is the
+	// uncompressed size the archive declares for the file.
+	walk func(content []byte, fn func(name string, size int64, r io.Reader) error) error
+}
+
+var archiveFormats = []archiveFormat{
+	{suffixes: []string{".zip", ".jar"}, walk: walkZip},
+	{suffixes: []string{".tar.gz", ".tgz"}, walk: walkTarGz},
+	{suffixes: []string{".tar"}, walk: walkTar},
+}
+
+func archiveFormatOf(name string) *archiveFormat {
+	lower := strings.ToLower(name)
+	for i := range archiveFormats {
+		for _, suffix := range archiveFormats[i].suffixes {
+			if strings.HasSuffix(lower, suffix) {
+				return &archiveFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+func walkZip(content []byte, fn func(name string, size int64, r io.Reader) error) error {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTarGz(content []byte, fn func(name string, size int64, r io.Reader) error) error {
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return walkTarReader(zr, fn)
+}
+
+func walkTar(content []byte, fn func(name string, size int64, r io.Reader) error) error {
+	return walkTarReader(bytes.NewReader(content), fn)
+}
+
+func walkTarReader(r io.Reader, fn func(name string, size int64, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// addArchiveMembers adds the files of the archive doc as documents of their
+// own. Files beyond ArchiveSizeMax are added as skipped documents. Corrupt
+// archives are logged, and only the files before the corruption are added.
+func (b *Builder) addArchiveMembers(doc *Document) error {
+	budget := int64(b.opts.ArchiveSizeMax)
+	var addErr error
+	err := archiveFormatOf(doc.Name).walk(doc.Content, func(name string, size int64, r io.Reader) error {
+		member := Document{
+			Name:              doc.Name + ArchiveSeparator + strings.TrimPrefix(path.Clean("/"+name), "/"),
+			Branches:          doc.Branches,
+			SubRepositoryPath: doc.SubRepositoryPath,
+			Generated:         doc.Generated,
+			ModTime:           doc.ModTime,
+		}
+
+		if size > budget {
+			member.SkipReason = fmt.Sprintf("files of archive %s larger than limit %d", doc.Name, b.opts.ArchiveSizeMax)
+		} else if content, err := io.ReadAll(io.LimitReader(r, budget+1)); err != nil {
+			return err
+		} else if int64(len(content)) > budget {
+			// The archive declared the wrong size.
+			member.SkipReason = fmt.Sprintf("files of archive %s larger than limit %d", doc.Name, b.opts.ArchiveSizeMax)
+			budget = 0
+		} else {
+			member.Content = content
+			budget -= int64(len(content))
+		}
+
+		addErr = b.add(member)
+		return addErr
+	})
+	if addErr != nil {
+		return addErr
+	}
+	if err != nil {
+		log.Printf("expanding archive %s: %v", doc.Name, err)
+	}
+	return nil
+}
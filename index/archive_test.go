@@ -0,0 +1,168 @@
+package index
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExpandArchives(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		IndexDir:       dir,
+		ExpandArchives: []string{"**/*.jar", "*.tgz"},
+		ArchiveSizeMax: 1000,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	opts.SetDefaults()
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, doc := range []Document{
+		{Name: "lib/foo.jar", Content: zipArchive(t, map[string]string{
+			"com/foo/Bar.java": "class Bar {}",
+			"/abs/../big.txt":  strings.Repeat("needle ", 200),
+		})},
+		{Name: "deps.tgz", Content: tarGzArchive(t, map[string]string{
+			"./pkg/README.md": "the needle in the tarball",
+		})},
+		// Doesn't match ExpandArchives.
+		{Name: "other.zip", Content: zipArchive(t, map[string]string{"x.txt": "needle"})},
+		{Name: "broken.jar", Content: []byte("not a zip")},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(opts.FindAllShards()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	indexFile, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexFile.Close()
+	s, err := NewSearcher(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	search := func(q query.Q) []string {
+		t.Helper()
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	if d := cmp.Diff([]string{"lib/foo.jar!com/foo/Bar.java"}, search(&query.Substring{Pattern: "class Bar", Content: true})); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+	// big.txt is over the budget, and other.zip isn't expanded.
+	if d := cmp.Diff([]string{"deps.tgz!pkg/README.md"}, search(&query.Substring{Pattern: "needle", Content: true})); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff([]string{"lib/foo.jar!big.txt"}, search(&query.Substring{Pattern: "big.txt", FileName: true})); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	for name, want := range map[string][]string{
+		"lib/foo.jar!com/foo/Bar.java": {"lib/foo.jar", "com/foo/Bar.java"},
+		"deps.tar.gz!a/b":              {"deps.tar.gz", "a/b"},
+		"wow!such.go":                  nil,
+		"lib/foo.jar!":                 nil,
+		"lib/foo.jar":                  nil,
+	} {
+		archive, member, ok := SplitArchivePath(name)
+		var got []string
+		if ok {
+			got = []string{archive, member}
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("%q: mismatch (-want +got):\n%s", name, d)
+		}
+	}
+
+	tombstones := map[string]struct{}{"lib/foo.jar": {}, "a.go": {}}
+	for name, want := range map[string]bool{
+		"a.go":                         true,
+		"b.go":                         false,
+		"lib/foo.jar!com/foo/Bar.java": true,
+		"lib/bar.jar!com/foo/Bar.java": false,
+	} {
+		if got := isTombstoned(tombstones, name); got != want {
+			t.Errorf("isTombstoned(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
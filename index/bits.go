@@ -322,6 +322,24 @@ func fromSizedDeltas16(data []byte, ps []uint16) []uint16 {
 	return ps
 }
 
+// toDeltas encodes increasing offsets as uvarint deltas, the format of
+// posting lists, which fromDeltas decodes.
+func toDeltas(offsets []uint32) []byte {
+	var enc [8]byte
+
+	deltas := make([]byte, 0, len(offsets)*2)
+
+	var last uint32
+	for _, p := range offsets {
+		delta := p - last
+		last = p
+
+		m := binary.PutUvarint(enc[:], uint64(delta))
+		deltas = append(deltas, enc[:m]...)
+	}
+	return deltas
+}
+
 func fromDeltas(data []byte, buf []uint32) []uint32 {
 	buf = buf[:0]
 	if cap(buf) < len(data)/2 {
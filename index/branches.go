@@ -0,0 +1,128 @@
+package index
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// GroupByBranch merges the file matches for the same file on different
+// branches into one, see zoekt.SearchOptions.GroupByBranch. Versions of the
+// file with the same content are merged into one zoekt.BranchMatch. Files are
+// returned in the order they first appear in files. If no file appears twice,
+// files is returned unchanged.
+func GroupByBranch(files []zoekt.FileMatch) []zoekt.FileMatch {
+	type key struct {
+		repositoryID uint32
+		repository   string
+		fileName     string
+	}
+
+	var keys []key
+	groups := map[key][]fileVersion{}
+	for i := range files {
+		f := &files[i]
+		k := key{repositoryID: f.RepositoryID, repository: f.Repository, fileName: f.FileName}
+		versions, ok := groups[k]
+		if !ok {
+			keys = append(keys, k)
+		}
+		for _, bm := range branchMatches(f) {
+			versions = addFileVersion(versions, fileVersion{file: f, branch: bm})
+		}
+		groups[k] = versions
+	}
+
+	if len(keys) == len(files) {
+		return files
+	}
+
+	grouped := make([]zoekt.FileMatch, 0, len(keys))
+	for _, k := range keys {
+		versions := groups[k]
+		sort.SliceStable(versions, func(i, j int) bool {
+			return versions[i].branch.Score > versions[j].branch.Score
+		})
+
+		// The best version describes the file.
+		fm := *versions[0].file
+		best := versions[0].branch
+		fm.Version = best.Version
+		fm.LineMatches = best.LineMatches
+		fm.ChunkMatches = best.ChunkMatches
+		fm.Content = best.Content
+		fm.Checksum = best.Checksum
+		fm.Score = best.Score
+
+		fm.Branches = nil
+		fm.BranchMatches = nil
+		for _, v := range versions {
+			fm.Branches = unionStrings(fm.Branches, v.branch.Branches)
+			if len(versions) > 1 {
+				fm.BranchMatches = append(fm.BranchMatches, v.branch)
+			}
+		}
+
+		grouped = append(grouped, fm)
+	}
+	return grouped
+}
+
+// fileVersion is a version of a file and the file match it came from.
+type fileVersion struct {
+	file   *zoekt.FileMatch
+	branch zoekt.BranchMatch
+}
+
+// branchMatches returns the versions of the file in f. These are
+// f.BranchMatches if f was grouped before, otherwise f itself.
+func branchMatches(f *zoekt.FileMatch) []zoekt.BranchMatch {
+	if len(f.BranchMatches) > 0 {
+		return f.BranchMatches
+	}
+	return []zoekt.BranchMatch{{
+		Branches:     f.Branches,
+		Version:      f.Version,
+		LineMatches:  f.LineMatches,
+		ChunkMatches: f.ChunkMatches,
+		Content:      f.Content,
+		Checksum:     f.Checksum,
+		Score:        f.Score,
+	}}
+}
+
+// addFileVersion adds v to versions, unless a version with the same content
+// exists. In that case the two are merged, keeping the matches of the better
+// one.
+func addFileVersion(versions []fileVersion, v fileVersion) []fileVersion {
+	if len(v.branch.Checksum) == 0 {
+		return append(versions, v)
+	}
+
+	for i, other := range versions {
+		if !bytes.Equal(other.branch.Checksum, v.branch.Checksum) {
+			continue
+		}
+		better, worse := other, v
+		if v.branch.Score > other.branch.Score {
+			better, worse = v, other
+		}
+		better.branch.Branches = unionStrings(slices.Clone(better.branch.Branches), worse.branch.Branches)
+		versions[i] = better
+		return versions
+	}
+
+	return append(versions, v)
+}
+
+// unionStrings appends the strings of b which are not in a to a.
+func unionStrings(a, b []string) []string {
+	for _, branch := range b {
+		if !slices.Contains(a, branch) {
+			a = append(a, branch)
+		}
+	}
+	return a
+}
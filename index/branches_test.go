@@ -0,0 +1,56 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestGroupByBranch_Regroup(t *testing.T) {
+	file := func(branch string, checksum string, score float64) zoekt.FileMatch {
+		return zoekt.FileMatch{
+			Repository:  "repo",
+			FileName:    "f",
+			Branches:    []string{branch},
+			Version:     "v-" + branch,
+			Checksum:    []byte(checksum),
+			Score:       score,
+			LineMatches: []zoekt.LineMatch{{Line: []byte(branch)}},
+		}
+	}
+
+	// Results of two shards of the same repository, which were grouped by
+	// each shard, are grouped again when they are aggregated.
+	files := GroupByBranch([]zoekt.FileMatch{file("main", "a", 1), file("dev", "b", 3)})
+	files = append(files, zoekt.FileMatch{Repository: "repo", FileName: "other"})
+	files = append(files, GroupByBranch([]zoekt.FileMatch{file("release", "a", 2)})...)
+
+	got := GroupByBranch(files)
+	if len(got) != 2 || got[0].FileName != "f" || got[1].FileName != "other" {
+		t.Fatalf("got %v, want f and other", got)
+	}
+
+	f := got[0]
+	if d := cmp.Diff([]string{"dev", "release", "main"}, f.Branches); d != "" {
+		t.Errorf("branches mismatch (-want +got):\n%s", d)
+	}
+	if f.Score != 3 || f.Version != "v-dev" || string(f.LineMatches[0].Line) != "dev" {
+		t.Errorf("got %+v, want the dev version to describe the file", f)
+	}
+
+	// release has the same content as main, but the better score.
+	want := []zoekt.BranchMatch{
+		{Branches: []string{"dev"}, Version: "v-dev", Checksum: []byte("b"), Score: 3, LineMatches: []zoekt.LineMatch{{Line: []byte("dev")}}},
+		{Branches: []string{"release", "main"}, Version: "v-release", Checksum: []byte("a"), Score: 2, LineMatches: []zoekt.LineMatch{{Line: []byte("release")}}},
+	}
+	if d := cmp.Diff(want, f.BranchMatches); d != "" {
+		t.Errorf("branch matches mismatch (-want +got):\n%s", d)
+	}
+
+	// Grouping is idempotent.
+	if d := cmp.Diff(got, GroupByBranch(got)); d != "" {
+		t.Errorf("regrouping changed the result (-want +got):\n%s", d)
+	}
+}
@@ -318,9 +318,18 @@ func (b btreeIndex) SizeBytes() (sz int) {
 // 3. Binary search the bucket (in MEM)
 // 4. Return the simple section pointing to the posting list (in MEM)
 func (b btreeIndex) Get(ng ngram) (ss simpleSection) {
-	if b.bt == nil {
+	i, ok := b.ngramIndex(ng)
+	if !ok {
 		return simpleSection{}
 	}
+	return b.getPostingList(i)
+}
+
+// ngramIndex returns the position of ng among the sorted ngrams of the index.
+func (b btreeIndex) ngramIndex(ng ngram) (int, bool) {
+	if b.bt == nil {
+		return 0, false
+	}
 
 	// find bucket
 	bucketIndex, postingIndexOffset := b.bt.find(ng)
@@ -329,7 +338,7 @@ func (b btreeIndex) Get(ng ngram) (ss simpleSection) {
 	off, sz := b.getBucket(bucketIndex)
 	bucket, err := b.file.Read(off, sz)
 	if err != nil {
-		return simpleSection{}
+		return 0, false
 	}
 
 	// find ngram in bucket
@@ -343,12 +352,10 @@ func (b btreeIndex) Get(ng ngram) (ss simpleSection) {
 		return ng <= getNGram(i)
 	})
 
-	// return associated posting list
 	if x >= bucketSize || getNGram(x) != ng {
-		return simpleSection{}
+		return 0, false
 	}
-
-	return b.getPostingList(postingIndexOffset + x)
+	return postingIndexOffset + x, true
 }
 
 // getPostingList returns the simple section pointing to the posting list of
@@ -13,11 +13,14 @@
 // limitations under the License.
 
 // Package index contains logic for building Zoekt indexes. NOTE: this package is not considered
-// part of the public API, and it is not recommended to rely on it in external code.
+// part of the public API, and it is not recommended to rely on it in external code. Use package
+// indexer to build shards from external code instead.
 package index
 
 import (
+	"bytes"
 	"cmp"
+	"context"
 	"crypto/sha1"
 	"flag"
 	"fmt"
@@ -27,7 +30,6 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
-	"reflect"
 	"runtime"
 	"runtime/pprof"
 	"sort"
@@ -35,6 +37,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bmatcuk/doublestar"
 	"github.com/dustin/go-humanize"
@@ -61,6 +64,13 @@ type Options struct {
 	// SizeMax is the maximum file size
 	SizeMax int
 
+	// TruncateLargeFiles, if non-zero, indexes the first TruncateLargeFiles
+	// bytes of files larger than SizeMax instead of skipping them, eg. for
+	// lock files which just exceed the limit. The prefix is capped at
+	// SizeMax and ends after its last complete line. The documents are
+	// marked as truncated, see zoekt.FileMatch.Truncated.
+	TruncateLargeFiles int
+
 	// Parallelism is the maximum number of shards to index in parallel
 	Parallelism int
 
@@ -95,6 +105,31 @@ type Options struct {
 	// https://github.com/bmatcuk/doublestar/tree/v1#patterns.
 	LargeFiles []string
 
+	// IncludePaths and ExcludePaths are glob patterns like LargeFiles which
+	// select the files to index, eg. services/foo/** to index one part of a
+	// monorepo. If IncludePaths is non-empty, only files matching one of its
+	// patterns are indexed. Files matching one of ExcludePaths are never
+	// indexed. See IncludePath.
+	IncludePaths []string
+	ExcludePaths []string
+
+	// NamesOnly are glob patterns like LargeFiles of files whose content is
+	// not indexed, eg. generated/** or **/*.min.js. Their names are indexed
+	// like the ones of skipped files, so they match file: queries, but they
+	// add no content ngrams to the shard. See NameOnly.
+	NamesOnly []string
+
+	// ExpandArchives are glob patterns like LargeFiles of archives whose
+	// files are indexed as documents of their own, named like
+	// lib/foo.jar!com/foo/Bar.java, see ArchiveSeparator. Zip (.zip, .jar)
+	// and tar (.tar, .tar.gz, .tgz) archives are supported. Archives inside
+	// archives are not expanded.
+	ExpandArchives []string
+
+	// ArchiveSizeMax is the maximum size of the files expanded from one
+	// archive. Larger archives are not expanded.
+	ArchiveSizeMax int
+
 	// IsDelta is true if this run contains only the changed documents since the
 	// last run.
 	IsDelta bool
@@ -120,24 +155,115 @@ type Options struct {
 
 	// ShardPrefix is the prefix of the shard. It defaults to the repository name.
 	ShardPrefix string
+
+	// CompressContents stores file contents zstd compressed, which reduces
+	// the size of shards at the cost of decompressing contents at search time.
+	CompressContents bool
+
+	// SubTokens stores where identifiers split into sub-tokens, at case
+	// changes and underscores, so that sub-token queries and ranking don't
+	// have to inspect the contents around each match.
+	SubTokens bool
+
+	// CaseFoldedNgrams additionally stores case-folded content ngrams, so
+	// that case-insensitive substring searches don't look up every casing
+	// of each ngram. This makes case-insensitive searches faster at the cost
+	// of larger shards.
+	CaseFoldedNgrams bool
+
+	// DocumentFrequencies stores the number of documents each content ngram
+	// occurs in, so that BM25 scoring estimates how common a query term is
+	// in the shard, instead of counting the documents the search visited.
+	DocumentFrequencies bool
+
+	// IdentifierIndex stores which documents define each symbol, keyed by
+	// the exact symbol name, so that exact symbol searches like
+	// sym:^FooBar$ look up their documents instead of matching ngrams and
+	// regular expressions.
+	IdentifierIndex bool
+
+	// PathPrefixIndex stores the documents sorted by file name, so that
+	// file name searches anchored at the start, like file:^src/foo/, look
+	// up their documents instead of matching ngrams and regular
+	// expressions.
+	PathPrefixIndex bool
+
+	// DisableGitAttributes ignores the .gitattributes files of git
+	// repositories. Otherwise files marked linguist-generated or
+	// linguist-vendored rank below other files.
+	DisableGitAttributes bool
+
+	// GitAttributesExportIgnore skips files marked export-ignore in
+	// .gitattributes, like git archive does. It has no effect if
+	// DisableGitAttributes is set.
+	GitAttributesExportIgnore bool
+
+	// Progress, if set, is called by the Builder after each added document
+	// and once all shards are written. It is called from the goroutine
+	// calling Add and Finish.
+	Progress func(BuildProgress)
+}
+
+// BuildProgress reports the progress of a Builder, see Options.Progress.
+type BuildProgress struct {
+	// Documents is the number of documents added so far, including the
+	// files expanded from archives.
+	Documents int
+
+	// Bytes is the size of the content of the documents added so far.
+	Bytes int64
+
+	// Shards is the number of shards written so far. Shards are only renamed
+	// into place once Finish succeeds.
+	Shards int
 }
 
 // HashOptions contains only the options in Options that upon modification leads to IndexState of IndexStateMismatch during the next index building.
 type HashOptions struct {
 	sizeMax          int
+	truncateLarge    int
 	disableCTags     bool
 	ctagsPath        string
 	cTagsMustSucceed bool
 	largeFiles       []string
+	includePaths     []string
+	excludePaths     []string
+	namesOnly        []string
+	expandArchives   []string
+	archiveSizeMax   int
+	compressContents bool
+	subTokens        bool
+	caseFoldedNgrams bool
+	documentFreqs    bool
+	identifierIndex  bool
+	pathPrefixIndex  bool
+
+	disableGitAttributes      bool
+	gitAttributesExportIgnore bool
 }
 
 func (o *Options) HashOptions() HashOptions {
 	return HashOptions{
 		sizeMax:          o.SizeMax,
+		truncateLarge:    o.TruncateLargeFiles,
 		disableCTags:     o.DisableCTags,
 		ctagsPath:        o.CTagsPath,
 		cTagsMustSucceed: o.CTagsMustSucceed,
 		largeFiles:       o.LargeFiles,
+		includePaths:     o.IncludePaths,
+		excludePaths:     o.ExcludePaths,
+		namesOnly:        o.NamesOnly,
+		expandArchives:   o.ExpandArchives,
+		archiveSizeMax:   o.ArchiveSizeMax,
+		compressContents: o.CompressContents,
+		subTokens:        o.SubTokens,
+		caseFoldedNgrams: o.CaseFoldedNgrams,
+		documentFreqs:    o.DocumentFrequencies,
+		identifierIndex:  o.IdentifierIndex,
+		pathPrefixIndex:  o.PathPrefixIndex,
+
+		disableGitAttributes:      o.DisableGitAttributes,
+		gitAttributesExportIgnore: o.GitAttributesExportIgnore,
 	}
 }
 
@@ -150,6 +276,46 @@ func (o *Options) GetHash() string {
 	hasher.Write([]byte(fmt.Sprintf("%d", h.sizeMax)))
 	hasher.Write([]byte(fmt.Sprintf("%q", h.largeFiles)))
 	hasher.Write([]byte(fmt.Sprintf("%t", h.disableCTags)))
+	// Only hashed if set, so that the hash of existing shards stays valid.
+	if h.compressContents {
+		hasher.Write([]byte("compressContents"))
+	}
+	if h.subTokens {
+		hasher.Write([]byte("subTokens"))
+	}
+	if h.caseFoldedNgrams {
+		hasher.Write([]byte("caseFoldedNgrams"))
+	}
+	if h.documentFreqs {
+		hasher.Write([]byte("documentFrequencies"))
+	}
+	if h.identifierIndex {
+		hasher.Write([]byte("identifierIndex"))
+	}
+	if h.pathPrefixIndex {
+		hasher.Write([]byte("pathPrefixIndex"))
+	}
+	if h.disableGitAttributes {
+		hasher.Write([]byte("disableGitAttributes"))
+	}
+	if h.gitAttributesExportIgnore {
+		hasher.Write([]byte("gitAttributesExportIgnore"))
+	}
+	if h.truncateLarge > 0 {
+		hasher.Write([]byte(fmt.Sprintf("truncateLargeFiles%d", h.truncateLarge)))
+	}
+	if len(h.includePaths) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("includePaths%q", h.includePaths)))
+	}
+	if len(h.excludePaths) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("excludePaths%q", h.excludePaths)))
+	}
+	if len(h.namesOnly) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("namesOnly%q", h.namesOnly)))
+	}
+	if len(h.expandArchives) > 0 {
+		hasher.Write([]byte(fmt.Sprintf("expandArchives%q%d", h.expandArchives, h.archiveSizeMax)))
+	}
 
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
@@ -173,18 +339,45 @@ func (f largeFilesFlag) Set(value string) error {
 	return nil
 }
 
+// globsFlag is a flag which collects glob patterns, one per occurrence.
+type globsFlag struct{ globs *[]string }
+
+func (f globsFlag) String() string {
+	if f.globs == nil {
+		return ""
+	}
+	return strings.Join(*f.globs, " ")
+}
+
+func (f globsFlag) Set(value string) error {
+	*f.globs = append(*f.globs, value)
+	return nil
+}
+
 // Flags adds flags for build options to fs. It is the "inverse" of Args.
 func (o *Options) Flags(fs *flag.FlagSet) {
 	x := *o
 	x.SetDefaults()
 	fs.IntVar(&o.SizeMax, "file_limit", x.SizeMax, "maximum file size")
+	fs.IntVar(&o.TruncateLargeFiles, "truncate_large_files", x.TruncateLargeFiles, "If non-zero, the first this many bytes of files larger than -file_limit are indexed instead of skipping them.")
 	fs.IntVar(&o.TrigramMax, "max_trigram_count", x.TrigramMax, "maximum number of trigrams per document")
 	fs.IntVar(&o.ShardMax, "shard_limit", x.ShardMax, "maximum corpus size for a shard")
 	fs.IntVar(&o.Parallelism, "parallelism", x.Parallelism, "maximum number of parallel indexing processes.")
 	fs.StringVar(&o.IndexDir, "index", x.IndexDir, "directory for search indices")
 	fs.BoolVar(&o.CTagsMustSucceed, "require_ctags", x.CTagsMustSucceed, "If set, ctags calls must succeed.")
 	fs.Var(largeFilesFlag{o}, "large_file", "A glob pattern where matching files are to be index regardless of their size. You can add multiple patterns by setting this more than once.")
+	fs.Var(globsFlag{&o.IncludePaths}, "include_path", "A glob pattern of files to index, eg. services/foo/**. If set, only matching files are indexed. You can add multiple patterns by setting this more than once.")
+	fs.Var(globsFlag{&o.ExcludePaths}, "exclude_path", "A glob pattern of files not to index. You can add multiple patterns by setting this more than once.")
+	fs.Var(globsFlag{&o.NamesOnly}, "names_only", "A glob pattern of files, eg. generated/**, of which only the name is indexed, not the content. You can add multiple patterns by setting this more than once.")
+	fs.Var(globsFlag{&o.ExpandArchives}, "expand_archive", "A glob pattern of zip, jar or tar archives, eg. **/*.jar, whose files are indexed as documents named like lib/foo.jar!com/foo/Bar.java. You can add multiple patterns by setting this more than once.")
+	fs.IntVar(&o.ArchiveSizeMax, "archive_limit", x.ArchiveSizeMax, "maximum size of the files expanded from one archive")
 	fs.StringVar(&o.ShardPrefix, "shard_prefix", x.ShardPrefix, "the prefix of the shard. Defaults to repository name")
+	fs.BoolVar(&o.CompressContents, "compress_contents", x.CompressContents, "If set, file contents are stored zstd compressed.")
+	fs.BoolVar(&o.SubTokens, "sub_tokens", x.SubTokens, "If set, sub-token boundaries of identifiers are stored, so that subtoken: queries and ranking look them up instead of inspecting the contents around each match.")
+	fs.BoolVar(&o.CaseFoldedNgrams, "case_folded_ngrams", x.CaseFoldedNgrams, "If set, case-folded ngrams are stored as well, which makes case-insensitive searches faster at the cost of larger shards.")
+	fs.BoolVar(&o.DocumentFrequencies, "document_frequencies", x.DocumentFrequencies, "If set, the number of documents of each ngram is stored, which BM25 scoring uses to weigh query terms.")
+	fs.BoolVar(&o.IdentifierIndex, "identifier_index", x.IdentifierIndex, "If set, the documents defining each symbol are stored, so that exact symbol searches like sym:^FooBar$ look them up directly.")
+	fs.BoolVar(&o.PathPrefixIndex, "path_prefix_index", x.PathPrefixIndex, "If set, the documents are stored sorted by file name, so that file name searches anchored at the start like file:^src/foo/ look them up directly.")
 
 	// Sourcegraph specific
 	fs.BoolVar(&o.DisableCTags, "disable_ctags", x.DisableCTags, "If set, ctags will not be called.")
@@ -199,6 +392,10 @@ func (o *Options) Args() []string {
 		args = append(args, "-file_limit", strconv.Itoa(o.SizeMax))
 	}
 
+	if o.TruncateLargeFiles != 0 {
+		args = append(args, "-truncate_large_files", strconv.Itoa(o.TruncateLargeFiles))
+	}
+
 	if o.TrigramMax != 0 {
 		args = append(args, "-max_trigram_count", strconv.Itoa(o.TrigramMax))
 	}
@@ -223,6 +420,25 @@ func (o *Options) Args() []string {
 		args = append(args, "-large_file", a)
 	}
 
+	for _, a := range o.IncludePaths {
+		args = append(args, "-include_path", a)
+	}
+
+	for _, a := range o.ExcludePaths {
+		args = append(args, "-exclude_path", a)
+	}
+
+	for _, a := range o.NamesOnly {
+		args = append(args, "-names_only", a)
+	}
+
+	for _, a := range o.ExpandArchives {
+		args = append(args, "-expand_archive", a)
+	}
+	if len(o.ExpandArchives) > 0 && o.ArchiveSizeMax != 0 {
+		args = append(args, "-archive_limit", strconv.Itoa(o.ArchiveSizeMax))
+	}
+
 	// Sourcegraph specific
 	if o.DisableCTags {
 		args = append(args, "-disable_ctags")
@@ -236,6 +452,30 @@ func (o *Options) Args() []string {
 		args = append(args, "-shard_prefix", o.ShardPrefix)
 	}
 
+	if o.CompressContents {
+		args = append(args, "-compress_contents")
+	}
+
+	if o.SubTokens {
+		args = append(args, "-sub_tokens")
+	}
+
+	if o.CaseFoldedNgrams {
+		args = append(args, "-case_folded_ngrams")
+	}
+
+	if o.DocumentFrequencies {
+		args = append(args, "-document_frequencies")
+	}
+
+	if o.IdentifierIndex {
+		args = append(args, "-identifier_index")
+	}
+
+	if o.PathPrefixIndex {
+		args = append(args, "-path_prefix_index")
+	}
+
 	return args
 }
 
@@ -244,6 +484,7 @@ func (o *Options) Args() []string {
 // then builds a shard and writes.
 type Builder struct {
 	opts     Options
+	ctx      context.Context
 	throttle chan int
 
 	nextShardNum int
@@ -272,6 +513,8 @@ type Builder struct {
 	id string
 
 	finishCalled bool
+
+	progress BuildProgress
 }
 
 type finishedShard struct {
@@ -324,6 +567,9 @@ func (o *Options) SetDefaults() {
 	if o.TrigramMax == 0 {
 		o.TrigramMax = 20000
 	}
+	if o.ArchiveSizeMax == 0 {
+		o.ArchiveSizeMax = 10 << 20
+	}
 
 	if o.RepositoryDescription.Name == "" && o.RepositoryDescription.URL != "" {
 		parsed, _ := url.Parse(o.RepositoryDescription.URL)
@@ -410,7 +656,7 @@ func (o *Options) IndexState() (IndexState, string) {
 		return IndexStateOption, fn
 	}
 
-	if !reflect.DeepEqual(repo.Branches, o.RepositoryDescription.Branches) {
+	if !zoekt.BranchVersionsEqual(repo.Branches, o.RepositoryDescription.Branches) {
 		return IndexStateContent, fn
 	}
 
@@ -513,6 +759,21 @@ func (o *Options) FindAllShards() []string {
 	return nil
 }
 
+// ExpandArchive returns true if the files of the archive name of size bytes
+// are indexed, see ExpandArchives. Readers should read such archives
+// regardless of SizeMax.
+func (o *Options) ExpandArchive(name string, size int) bool {
+	if size > o.ArchiveSizeMax || archiveFormatOf(name) == nil {
+		return false
+	}
+	for _, pattern := range o.ExpandArchives {
+		if m, _ := doublestar.PathMatch(strings.TrimSpace(pattern), name); m {
+			return true
+		}
+	}
+	return false
+}
+
 // IgnoreSizeMax determines whether the max size should be ignored.
 func (o *Options) IgnoreSizeMax(name string) bool {
 	// A pattern match will override preceding pattern matches.
@@ -532,6 +793,86 @@ func (o *Options) IgnoreSizeMax(name string) bool {
 	return false
 }
 
+// TruncateSize returns how many bytes of files larger than SizeMax are
+// indexed, or 0 if they are skipped. See TruncateLargeFiles.
+func (o *Options) TruncateSize() int {
+	if o.TruncateLargeFiles <= 0 {
+		return 0
+	}
+	return min(o.TruncateLargeFiles, o.SizeMax)
+}
+
+// TruncateContent returns the prefix of content which is indexed for a file
+// truncated to limit bytes. It ends after the last complete line within
+// limit, or at a rune boundary if the first line is longer than limit.
+// Indexers which only read the first limit bytes of a file can pass those.
+func TruncateContent(content []byte, limit int) []byte {
+	if len(content) <= limit {
+		return content
+	}
+	content = content[:limit]
+	if i := bytes.LastIndexByte(content, '\n'); i >= 0 {
+		return content[:i+1]
+	}
+	for len(content) > 0 && !utf8.RuneStart(content[len(content)-1]) {
+		content = content[:len(content)-1]
+	}
+	if len(content) > 0 {
+		if r, _ := utf8.DecodeLastRune(content); r == utf8.RuneError {
+			content = content[:len(content)-1]
+		}
+	}
+	return content
+}
+
+// symbolsWithin returns the symbols which end within the first size bytes of
+// a document.
+func symbolsWithin(secs []DocumentSection, metaData []*zoekt.Symbol, size int) ([]DocumentSection, []*zoekt.Symbol) {
+	var keptSecs []DocumentSection
+	var keptMetaData []*zoekt.Symbol
+	for i, sec := range secs {
+		if int(sec.End) > size {
+			continue
+		}
+		keptSecs = append(keptSecs, sec)
+		if i < len(metaData) {
+			keptMetaData = append(keptMetaData, metaData[i])
+		}
+	}
+	return keptSecs, keptMetaData
+}
+
+// IncludePath returns true if the file name should be indexed according to
+// IncludePaths and ExcludePaths.
+func (o *Options) IncludePath(name string) bool {
+	for _, pattern := range o.ExcludePaths {
+		if m, _ := doublestar.PathMatch(strings.TrimSpace(pattern), name); m {
+			return false
+		}
+	}
+	if len(o.IncludePaths) == 0 {
+		return true
+	}
+	for _, pattern := range o.IncludePaths {
+		if m, _ := doublestar.PathMatch(strings.TrimSpace(pattern), name); m {
+			return true
+		}
+	}
+	return false
+}
+
+// NameOnly returns true if only the name of the file name is indexed
+// according to NamesOnly. Readers don't need to read the content of such
+// files.
+func (o *Options) NameOnly(name string) bool {
+	for _, pattern := range o.NamesOnly {
+		if m, _ := doublestar.PathMatch(strings.TrimSpace(pattern), name); m {
+			return true
+		}
+	}
+	return false
+}
+
 func checkIsNegatePattern(pattern string) (bool, string) {
 	negate := "!"
 
@@ -545,6 +886,13 @@ func checkIsNegatePattern(pattern string) (bool, string) {
 
 // NewBuilder creates a new Builder instance.
 func NewBuilder(opts Options) (*Builder, error) {
+	return NewBuilderContext(context.Background(), opts)
+}
+
+// NewBuilderContext is like NewBuilder, but stops building once ctx is
+// done: Add and Finish then return ctx.Err(), and the shards built so far
+// are discarded. Existing shards are left untouched.
+func NewBuilderContext(ctx context.Context, opts Options) (*Builder, error) {
 	opts.SetDefaults()
 	if opts.RepositoryDescription.Name == "" {
 		return nil, fmt.Errorf("builder: must set Name")
@@ -552,6 +900,7 @@ func NewBuilder(opts Options) (*Builder, error) {
 
 	b := &Builder{
 		opts:           opts,
+		ctx:            ctx,
 		throttle:       make(chan int, opts.Parallelism),
 		finishedShards: map[string]string{},
 	}
@@ -600,8 +949,53 @@ func (b *Builder) Add(doc Document) error {
 		return nil
 	}
 
+	if b.ctx.Err() != nil {
+		// Finish discards the shards built so far.
+		return b.Finish()
+	}
+
+	if b.opts.ExpandArchive(doc.Name, len(doc.Content)) {
+		if err := b.addArchiveMembers(&doc); err != nil {
+			return err
+		}
+	}
+	err := b.add(doc)
+	b.reportProgress()
+	return err
+}
+
+// reportProgress calls Options.Progress with the current progress.
+func (b *Builder) reportProgress() {
+	if b.opts.Progress == nil {
+		return
+	}
+	b.errMu.Lock()
+	b.progress.Shards = len(b.finishedShards)
+	b.errMu.Unlock()
+	b.opts.Progress(b.progress)
+}
+
+func (b *Builder) add(doc Document) error {
+	b.progress.Documents++
+	b.progress.Bytes += int64(len(doc.Content))
+
+	nameOnly := b.opts.NameOnly(doc.Name)
 	allowLargeFile := b.opts.IgnoreSizeMax(doc.Name)
-	if len(doc.Content) > b.opts.SizeMax && !allowLargeFile {
+	if limit := b.opts.TruncateSize(); limit > 0 && len(doc.Content) > b.opts.SizeMax && !allowLargeFile && !nameOnly {
+		doc.Content = TruncateContent(doc.Content, limit)
+		doc.Truncated = true
+	}
+	if doc.Truncated {
+		doc.Symbols, doc.SymbolsMetaData = symbolsWithin(doc.Symbols, doc.SymbolsMetaData, len(doc.Content))
+	}
+
+	if nameOnly {
+		doc.SkipReason = "content excluded by -names_only"
+		// The name is all we index, so it alone determines the language,
+		// whether or not the reader read the content.
+		doc.Content = nil
+		DetermineLanguageIfUnknown(&doc)
+	} else if len(doc.Content) > b.opts.SizeMax && !allowLargeFile {
 		// We could pass the document on to the shardbuilder, but if
 		// we pass through a part of the source tree with binary/large
 		// files, the corresponding shard would be mostly empty, so
@@ -650,9 +1044,22 @@ func (b *Builder) Finish() error {
 
 	b.finishCalled = true
 
+	if err := b.ctx.Err(); err != nil {
+		b.errMu.Lock()
+		if b.buildError == nil {
+			b.buildError = err
+		}
+		b.errMu.Unlock()
+	}
+
 	b.flush()
 	b.building.Wait()
 
+	if b.buildError == nil {
+		// Don't replace the existing shards if ctx was done while building.
+		b.buildError = b.ctx.Err()
+	}
+
 	if b.buildError != nil {
 		for tmp := range b.finishedShards {
 			log.Printf("Builder.Finish %s", tmp)
@@ -662,6 +1069,8 @@ func (b *Builder) Finish() error {
 		return b.buildError
 	}
 
+	b.reportProgress()
+
 	// map of temporary -> final names for all updated shards + shard metadata files
 	artifactPaths := make(map[string]string)
 	for tmp, final := range b.finishedShards {
@@ -719,6 +1128,11 @@ func (b *Builder) Finish() error {
 
 			repository.LatestCommitDate = b.opts.RepositoryDescription.LatestCommitDate
 
+			repository.License = b.opts.RepositoryDescription.License
+
+			repository.Description = b.opts.RepositoryDescription.Description
+			repository.Homepage = b.opts.RepositoryDescription.Homepage
+
 			tempPath, finalPath, err := JsonMarshalRepoMetaTemp(shard, repository)
 			if err != nil {
 				return fmt.Errorf("writing repository metadta for shard %q: %w", shard, err)
@@ -746,6 +1160,12 @@ func (b *Builder) Finish() error {
 		// So, we skip populating the toDelete map if we're building delta shards.
 
 		toDelete = make(map[string]struct{})
+
+		// The repository has shards again, so it is no longer in a RepoState.
+		if _, err := os.Stat(b.opts.RepoStatePath()); err == nil {
+			toDelete[b.opts.RepoStatePath()] = struct{}{}
+		}
+
 		for _, name := range oldShards {
 			paths, err := IndexFilePaths(name)
 			if err != nil {
@@ -768,23 +1188,35 @@ func (b *Builder) Finish() error {
 
 	b.finishedShards = map[string]string{}
 
-	for p := range toDelete {
+	if err := b.opts.removeShardFiles(toDelete); err != nil {
+		b.buildError = err
+	}
+
+	return b.buildError
+}
+
+// removeShardFiles removes the shard files in paths. The repository of o is
+// tombstoned in compound shards instead. It returns the last error it
+// encountered.
+func (o *Options) removeShardFiles(paths map[string]struct{}) error {
+	var lastErr error
+	for p := range paths {
 		// Don't delete compound shards, set tombstones instead.
-		if b.opts.ShardMerging && strings.HasPrefix(filepath.Base(p), "compound-") {
+		if o.ShardMerging && strings.HasPrefix(filepath.Base(p), "compound-") {
 			if !strings.HasSuffix(p, ".zoekt") {
 				continue
 			}
-			err := SetTombstone(p, b.opts.RepositoryDescription.ID)
-			b.buildError = err
+			if err := SetTombstone(p, o.RepositoryDescription.ID); err != nil {
+				lastErr = err
+			}
 			continue
 		}
 		log.Printf("removing old shard file: %s", p)
 		if err := os.Remove(p); err != nil {
-			b.buildError = err
+			lastErr = err
 		}
 	}
-
-	return b.buildError
+	return lastErr
 }
 
 // BranchNamesEqual compares the given zoekt.RepositoryBranch slices, and returns true
@@ -867,7 +1299,7 @@ func squashRange(j int) float64 {
 // These 'priority' criteria affects how documents are ordered within a shard. It's
 // also used to help guess a file's rank when we're missing ranking information.
 func IsLowPriority(path string, content []byte) bool {
-	return enry.IsTest(path) || enry.IsVendor(path) || enry.IsGenerated(path, content)
+	return enry.IsTest(path) || enry.IsVendor(path) || IsGeneratedFile(path, content)
 }
 
 type rankedDoc struct {
@@ -886,7 +1318,7 @@ func rank(d *Document, origIdx int) []float64 {
 	}
 
 	generated := 0.0
-	if enry.IsGenerated(d.Name, d.Content) {
+	if d.Generated || IsGeneratedFile(d.Name, d.Content) {
 		generated = 1.0
 	}
 
@@ -957,7 +1389,7 @@ func sortDocuments(todo []*Document) {
 }
 
 func (b *Builder) buildShard(todo []*Document, nextShardNum int) (*finishedShard, error) {
-	if !b.opts.DisableCTags && (b.opts.CTagsPath != "" || b.opts.ScipCTagsPath != "") {
+	if !b.opts.DisableCTags && (b.opts.CTagsPath != "" || b.opts.ScipCTagsPath != "" || ctags.UsesTreeSitter(b.opts.LanguageMap)) {
 		err := parseSymbols(todo, b.opts.LanguageMap, b.parserBins)
 		if b.opts.CTagsMustSucceed && err != nil {
 			return nil, err
@@ -1032,6 +1464,12 @@ func (b *Builder) newShardBuilder() (*ShardBuilder, error) {
 	}
 	shardBuilder.IndexTime = b.indexTime
 	shardBuilder.ID = b.id
+	shardBuilder.CompressContents = b.opts.CompressContents
+	shardBuilder.SubTokens = b.opts.SubTokens
+	shardBuilder.CaseFoldedNgrams = b.opts.CaseFoldedNgrams
+	shardBuilder.DocumentFrequencies = b.opts.DocumentFrequencies
+	shardBuilder.IdentifierIndex = b.opts.IdentifierIndex
+	shardBuilder.PathPrefixIndex = b.opts.PathPrefixIndex
 	return shardBuilder, nil
 }
 
@@ -1105,6 +1543,26 @@ type Document struct {
 	// is the reason it wasn't indexed.
 	SkipReason string
 
+	// Truncated marks documents whose Content is only a prefix of the file,
+	// see Options.TruncateLargeFiles and TruncateContent.
+	Truncated bool
+
+	// Generated marks files which the repository declares as generated or
+	// vendored, eg. with linguist-generated in .gitattributes. They rank
+	// below other files. Files which look generated are flagged anyway, see
+	// IsGeneratedFile.
+	Generated bool
+
+	// Commit is set for documents which describe a commit rather than a
+	// file, see CommitDocument. They only match queries which contain a
+	// query.Commit atom.
+	Commit *CommitMetadata
+
+	// ModTime is the time the file was last modified, eg. the date of the
+	// last commit which changed it. It is optional and surfaces as
+	// zoekt.FileMatch.LastModified.
+	ModTime time.Time
+
 	// Document sections for symbols. Offsets should use bytes.
 	Symbols         []DocumentSection
 	SymbolsMetaData []*zoekt.Symbol
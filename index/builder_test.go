@@ -1,6 +1,7 @@
 package index
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -17,6 +19,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 var update = flag.Bool("update", false, "update golden file")
@@ -130,6 +133,17 @@ func TestFlags(t *testing.T) {
 		want: Options{
 			LargeFiles: []string{"*.md", "\\!*.yaml"},
 		},
+	}, {
+		args: []string{"-include_path", "services/foo/**", "-include_path", "lib/**", "-exclude_path", "**/testdata/**"},
+		want: Options{
+			IncludePaths: []string{"services/foo/**", "lib/**"},
+			ExcludePaths: []string{"**/testdata/**"},
+		},
+	}, {
+		args: []string{"-names_only", "generated/**", "-names_only", "**/*.min.js"},
+		want: Options{
+			NamesOnly: []string{"generated/**", "**/*.min.js"},
+		},
 	}}
 
 	ignored := []cmp.Option{
@@ -254,6 +268,155 @@ func TestDontCountContentOfSkippedFiles(t *testing.T) {
 	}
 }
 
+func TestTruncateContent(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		limit   int
+		want    string
+	}{
+		{"abc\ndef\n", 20, "abc\ndef\n"},
+		{"abc\ndef\nghi\n", 9, "abc\ndef\n"},
+		{"abc\ndef\nghi\n", 10, "abc\ndef\n"},
+		{"abcdef", 4, "abcd"},
+		{"abécd", 3, "ab"},
+		{"ééé", 5, "éé"},
+	} {
+		if got := string(TruncateContent([]byte(tc.content), tc.limit)); got != tc.want {
+			t.Errorf("TruncateContent(%q, %d) = %q, want %q", tc.content, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestTruncateLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := Options{
+		IndexDir:           dir,
+		SizeMax:            20,
+		TruncateLargeFiles: 14,
+		DisableCTags:       true,
+	}
+	opts.RepositoryDescription.Name = "repo"
+	opts.SetDefaults()
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(Document{
+		Name:            "large.txt",
+		Content:         []byte("first line\nsecond line\nneedle\n"),
+		Symbols:         []DocumentSection{{0, 5}, {11, 17}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "a"}, {Kind: "b"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("small.txt", []byte("first needle\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := opts.FindAllShards()
+	if len(shards) != 1 {
+		t.Fatalf("got %d shards, want 1", len(shards))
+	}
+	s, err := loadShard(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	search := func(q query.Q) map[string]bool {
+		t.Helper()
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for _, f := range res.Files {
+			got[f.FileName] = f.Truncated
+		}
+		return got
+	}
+
+	if got, want := search(&query.Substring{Pattern: "first"}), map[string]bool{"large.txt": true, "small.txt": false}; !cmp.Equal(got, want) {
+		t.Errorf("got %v searching the prefix, want %v", got, want)
+	}
+	if got, want := search(&query.Substring{Pattern: "needle"}), map[string]bool{"small.txt": false}; !cmp.Equal(got, want) {
+		t.Errorf("got %v searching past the prefix, want %v", got, want)
+	}
+	if got, want := search(&query.Symbol{Expr: &query.Substring{Pattern: "second"}}), map[string]bool{}; !cmp.Equal(got, want) {
+		t.Errorf("got %v searching a symbol past the prefix, want %v", got, want)
+	}
+}
+
+func TestNamesOnly(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := Options{
+		IndexDir:     dir,
+		NamesOnly:    []string{"generated/**"},
+		DisableCTags: true,
+	}
+	opts.RepositoryDescription.Name = "repo"
+	opts.SetDefaults()
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("generated/api.js", []byte("var needle = 1;\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("main.go", []byte("var needle = 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := opts.FindAllShards()
+	if len(shards) != 1 {
+		t.Fatalf("got %d shards, want 1", len(shards))
+	}
+	s, err := loadShard(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	search := func(q query.Q) []string {
+		t.Helper()
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	if got, want := search(&query.Substring{Pattern: "needle", Content: true}), []string{"main.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v searching content, want %v", got, want)
+	}
+	if got, want := search(&query.Substring{Pattern: "api", FileName: true}), []string{"generated/api.js"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v searching file names, want %v", got, want)
+	}
+	// The language still comes from the name.
+	if got, want := search(&query.Language{Language: "JavaScript"}), []string{"generated/api.js"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v searching the language, want %v", got, want)
+	}
+
+	if (&Options{}).GetHash() == opts.GetHash() {
+		t.Error("NamesOnly doesn't change the hash")
+	}
+}
+
 func TestPartialSuccess(t *testing.T) {
 	dir := t.TempDir()
 
@@ -1132,3 +1295,46 @@ func TestFileRank(t *testing.T) {
 		})
 	}
 }
+
+func TestGetHash_GitAttributes(t *testing.T) {
+	var opts Options
+	base := opts.GetHash()
+
+	opts.DisableGitAttributes = true
+	disabled := opts.GetHash()
+
+	opts = Options{GitAttributesExportIgnore: true}
+	exportIgnore := opts.GetHash()
+
+	if base == disabled || base == exportIgnore || disabled == exportIgnore {
+		t.Fatalf("got hashes %s, %s and %s, want them to differ", base, disabled, exportIgnore)
+	}
+}
+
+func TestIncludePath(t *testing.T) {
+	opts := Options{
+		IncludePaths: []string{"services/foo/**", "README.md"},
+		ExcludePaths: []string{"**/testdata/**"},
+	}
+	for path, want := range map[string]bool{
+		"services/foo/main.go":            true,
+		"services/foo/pkg/a.go":           true,
+		"README.md":                       true,
+		"services/bar/main.go":            false,
+		"docs/README.md":                  false,
+		"services/foo/testdata/golden.go": false,
+	} {
+		if got := opts.IncludePath(path); got != want {
+			t.Errorf("IncludePath(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	if opts := (Options{ExcludePaths: []string{"vendor/**"}}); !opts.IncludePath("main.go") || opts.IncludePath("vendor/x.go") {
+		t.Error("ExcludePaths without IncludePaths should only exclude matching files")
+	}
+
+	base := (&Options{}).GetHash()
+	if base == opts.GetHash() {
+		t.Error("IncludePaths and ExcludePaths don't change the hash")
+	}
+}
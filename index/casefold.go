@@ -0,0 +1,70 @@
+package index
+
+import (
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Case-folded ngrams index all case variants of an ngram, eg. "foo", "Foo"
+// and "FOO", under one posting list, so that case-insensitive searches look
+// up one ngram instead of every variant generateCaseNgrams returns.
+
+// foldNgram returns the representative of the case variants of g, which
+// generateCaseNgrams returns: every rune is replaced by foldRune.
+func foldNgram(g ngram) ngram {
+	rs := ngramToRunes(g)
+	for i, r := range rs {
+		rs[i] = foldRune(r)
+	}
+	return runesToNGram(rs)
+}
+
+// foldRune returns the smallest rune which is equal to r under simple case
+// folding, eg. 'K' for 'k' and the Kelvin sign.
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}
+	folded := r
+	for c := unicode.SimpleFold(r); c != r; c = unicode.SimpleFold(c) {
+		folded = min(folded, c)
+	}
+	return folded
+}
+
+// foldPostings returns the posting lists of the case-folded ngrams: the
+// postings of all case variants of an ngram merged under foldNgram. Ngrams
+// which are their only variant are left out, readers use their regular
+// posting list instead.
+func foldPostings(postings map[ngram][]byte) map[ngram][]byte {
+	variants := map[ngram][]ngram{}
+	for ng := range postings {
+		f := foldNgram(ng)
+		variants[f] = append(variants[f], ng)
+	}
+
+	folded := make(map[ngram][]byte, len(variants))
+	var offsets, buf []uint32
+	for f, ngs := range variants {
+		if len(ngs) == 1 {
+			if ngs[0] != f {
+				folded[f] = postings[ngs[0]]
+			}
+			continue
+		}
+
+		offsets = offsets[:0]
+		for _, ng := range ngs {
+			buf = fromDeltas(postings[ng], buf)
+			offsets = append(offsets, buf...)
+		}
+		// Variants never start at the same rune, so there are no duplicates.
+		slices.Sort(offsets)
+		folded[f] = toDeltas(offsets)
+	}
+	return folded
+}
@@ -0,0 +1,123 @@
+package index
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestFoldNgram(t *testing.T) {
+	for _, s := range []string{"foo", "Foo", "FOO", "fOo"} {
+		if got, want := foldNgram(stringToNGram(s)), stringToNGram("FOO"); got != want {
+			t.Errorf("foldNgram(%q) = %s, want %s", s, got, want)
+		}
+	}
+	// The Kelvin sign folds like k and K.
+	if got, want := foldNgram(runesToNGram([ngramSize]rune{'\u212a', 'e', 'y'})), foldNgram(stringToNGram("key")); got != want {
+		t.Errorf("got %s for the Kelvin sign, want %s", got, want)
+	}
+	if got := foldNgram(stringToNGram("a_1")); got != stringToNGram("A_1") {
+		t.Errorf("got %s, want A_1", got)
+	}
+}
+
+func TestFoldPostings(t *testing.T) {
+	postings := map[ngram][]byte{
+		stringToNGram("foo"): toDeltas([]uint32{1, 10}),
+		stringToNGram("Foo"): toDeltas([]uint32{5}),
+		stringToNGram("bar"): toDeltas([]uint32{20}),
+		stringToNGram("BAZ"): toDeltas([]uint32{30}),
+	}
+
+	got := map[ngram][]uint32{}
+	for ng, p := range foldPostings(postings) {
+		got[ng] = fromDeltas(p, nil)
+	}
+	want := map[ngram][]uint32{
+		stringToNGram("FOO"): {1, 5, 10},
+		stringToNGram("BAR"): {20},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestCaseFoldedNgramsSearch(t *testing.T) {
+	docs := []Document{
+		{Name: "a.go", Content: []byte("func ParseHTTPRequest() {}\n")},
+		{Name: "b.go", Content: []byte("// parsehttprequest parses\n")},
+		{Name: "c.txt", Content: []byte("the \u212aelvin scale\n")},
+		{Name: "d.txt", Content: []byte("PARSE nothing\n")},
+	}
+
+	lookups := map[bool]int{}
+	for _, folded := range []bool{false, true} {
+		b := testShardBuilder(t, nil, docs...)
+		b.CaseFoldedNgrams = folded
+		s := searcherForTest(t, b)
+		if got := s.(*indexData).foldedNgrams.bt != nil; got != folded {
+			t.Fatalf("got case-folded ngrams %t, want %t", got, folded)
+		}
+
+		for _, tc := range []struct {
+			q    query.Q
+			want []string
+		}{
+			{&query.Substring{Pattern: "parsehttp", Content: true}, []string{"a.go", "b.go"}},
+			{&query.Substring{Pattern: "ParseHTTP", Content: true, CaseSensitive: true}, []string{"a.go"}},
+			{&query.Substring{Pattern: "kelvin", Content: true}, []string{"c.txt"}},
+			{&query.Substring{Pattern: "parse", Content: true}, []string{"a.go", "b.go", "d.txt"}},
+			{&query.Substring{Pattern: "missing", Content: true}, nil},
+			{&query.Substring{Pattern: "A.GO", FileName: true}, []string{"a.go"}},
+		} {
+			res, err := s.Search(context.Background(), tc.q, &zoekt.SearchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range res.Files {
+				got = append(got, f.FileName)
+			}
+			slices.Sort(got)
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("case-folded %t, %s: mismatch (-want +got):\n%s", folded, tc.q, d)
+			}
+			if tc.q.(*query.Substring).Pattern == "parsehttp" {
+				lookups[folded] = res.Stats.NgramLookups
+			}
+		}
+	}
+
+	if lookups[true] >= lookups[false] {
+		t.Errorf("got %d ngram lookups with case-folded ngrams, want fewer than %d without", lookups[true], lookups[false])
+	}
+}
+
+func TestMerge_CaseFoldedNgrams(t *testing.T) {
+	b1 := testShardBuilder(t, &zoekt.Repository{Name: "repo1"}, Document{Name: "a.go", Content: []byte("FooBar")})
+	b1.CaseFoldedNgrams = true
+	b2 := testShardBuilder(t, &zoekt.Repository{Name: "repo2"}, Document{Name: "b.go", Content: []byte("foobar")})
+
+	var ds []*indexData
+	for _, b := range []*ShardBuilder{b1, b2} {
+		ds = append(ds, searcherForTest(t, b).(*indexData))
+	}
+
+	sb, err := merge(ds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sb.CaseFoldedNgrams {
+		t.Fatal("merging a shard with case-folded ngrams should produce a shard with case-folded ngrams")
+	}
+
+	res := searchForTest(t, sb, &query.Substring{Pattern: "FOOBAR", Content: true})
+	if len(res.Files) != 2 {
+		t.Fatalf("got %d files, want 2", len(res.Files))
+	}
+}
@@ -0,0 +1,35 @@
+package index
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"hash/crc64"
+)
+
+// checksumPrefixMatcher returns a function that reports whether a checksum
+// starts with prefix, a string of hex digits, see query.Checksum.
+func checksumPrefixMatcher(prefix string) (func(checksum []byte) bool, error) {
+	if len(prefix) > 2*crc64.Size {
+		return nil, fmt.Errorf("hash:%s: checksums have %d hex digits", prefix, 2*crc64.Size)
+	}
+
+	// An odd number of digits leaves the high half of one more byte to check.
+	whole, err := hex.DecodeString(prefix[:len(prefix)&^1])
+	if err != nil {
+		return nil, fmt.Errorf("hash:%s: %w", prefix, err)
+	}
+	if len(prefix)%2 == 0 {
+		return func(checksum []byte) bool {
+			return bytes.HasPrefix(checksum, whole)
+		}, nil
+	}
+
+	half, err := hex.DecodeString(prefix[len(prefix)-1:] + "0")
+	if err != nil {
+		return nil, fmt.Errorf("hash:%s: %w", prefix, err)
+	}
+	return func(checksum []byte) bool {
+		return bytes.HasPrefix(checksum, whole) && checksum[len(whole)]&0xf0 == half[0]
+	}, nil
+}
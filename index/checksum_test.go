@@ -0,0 +1,50 @@
+package index
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSearchChecksum(t *testing.T) {
+	license := []byte("Permission is hereby granted, free of charge\n")
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "LICENSE", Content: license},
+		Document{Name: "vendor/lib/LICENSE", Content: license},
+		Document{Name: "vendor/lib/LICENSE.orig", Content: append(license, '\n')},
+		Document{Name: "main.go", Content: []byte("package main\n")},
+	)
+	d := searcherForTest(t, b)
+
+	sum := hex.EncodeToString(zoekt.ContentChecksum(license))
+	want := []string{"LICENSE", "vendor/lib/LICENSE"}
+
+	for _, prefix := range []string{sum, sum[:7], sum[:8]} {
+		res, err := d.Search(context.Background(), &query.Checksum{Prefix: prefix}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		sort.Strings(got)
+		if !cmp.Equal(got, want) {
+			t.Errorf("hash:%s: got %v, want %v", prefix, got, want)
+		}
+	}
+
+	files, err := zoekt.FindDuplicates(context.Background(), d, &query.Substring{Pattern: "vendor/", FileName: true}, license, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].FileName != "vendor/lib/LICENSE" || files[0].Content != nil {
+		t.Errorf("FindDuplicates: got %+v, want only vendor/lib/LICENSE without content", files)
+	}
+}
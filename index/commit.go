@@ -0,0 +1,173 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Commit is a commit in the history of a repository, see CommitDocument.
+type Commit struct {
+	Hash string
+
+	// Author is formatted as "Name <email>".
+	Author string
+	Date   time.Time
+
+	Message string
+
+	// Paths are the files added, modified or deleted by the commit.
+	Paths []string
+}
+
+// CommitDocument returns a document describing c, named after its hash. The
+// content resembles the output of git log --name-only, so that commit
+// messages and changed paths are found by regular content queries:
+//
+//	commit 8a3f...
+//	Author: Jane Doe <jane@example.com>
+//	Date:   2024-01-02T15:04:05Z
+//
+//	    Fix the frobnicator
+//
+//	cmd/frob/main.go
+func CommitDocument(c Commit, branches []string) Document {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "commit %s\nAuthor: %s\nDate:   %s\n\n", c.Hash, c.Author, c.Date.UTC().Format(time.RFC3339))
+	for _, l := range strings.Split(strings.TrimRight(c.Message, "\n"), "\n") {
+		if l != "" {
+			b.WriteString("    ")
+			b.WriteString(l)
+		}
+		b.WriteByte('\n')
+	}
+	if len(c.Paths) > 0 {
+		b.WriteByte('\n')
+		for _, p := range c.Paths {
+			b.WriteString(p)
+			b.WriteByte('\n')
+		}
+	}
+
+	return Document{
+		Name:     c.Hash,
+		Content:  b.Bytes(),
+		Branches: branches,
+		Commit:   &CommitMetadata{Author: c.Author, Date: c.Date},
+	}
+}
+
+// CommitMetadata is stored for commit documents, so that commit: queries can
+// filter by author and date without reading the contents.
+type CommitMetadata struct {
+	// Author is formatted as "Name <email>".
+	Author string
+	Date   time.Time
+}
+
+// commitMetadata is the in-memory form of CommitMetadata.
+type commitMetadata struct {
+	author string
+	date   int64 // seconds since the epoch
+}
+
+// appendCommitMetadata appends the metadata of the commit document docID to
+// buf. The commits section is a sequence of entries, ordered by document:
+//
+//	uvarint  docID
+//	varint   date in seconds since the epoch
+//	uvarint  len(author)
+//	[]byte   author
+func appendCommitMetadata(buf []byte, docID uint32, c *CommitMetadata) []byte {
+	buf = binary.AppendUvarint(buf, uint64(docID))
+	buf = binary.AppendVarint(buf, c.Date.Unix())
+	buf = binary.AppendUvarint(buf, uint64(len(c.Author)))
+	return append(buf, c.Author...)
+}
+
+// decodeCommitMetadata decodes a commits section written with
+// appendCommitMetadata.
+func decodeCommitMetadata(buf []byte) (map[uint32]commitMetadata, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	commits := map[uint32]commitMetadata{}
+	for len(buf) > 0 {
+		docID, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed document ID")
+		}
+		buf = buf[n:]
+
+		date, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed date of document %d", docID)
+		}
+		buf = buf[n:]
+
+		sz, n := binary.Uvarint(buf)
+		if n <= 0 || sz > uint64(len(buf)-n) {
+			return nil, fmt.Errorf("malformed author of document %d", docID)
+		}
+		buf = buf[n:]
+
+		commits[uint32(docID)] = commitMetadata{author: string(buf[:sz]), date: date}
+		buf = buf[sz:]
+	}
+	return commits, nil
+}
+
+// commitMetadata returns the metadata of the commit document docID, or nil if
+// it is not a commit document.
+func (d *indexData) commitMetadata(docID uint32) *CommitMetadata {
+	c, ok := d.commits[docID]
+	if !ok {
+		return nil
+	}
+	return &CommitMetadata{Author: c.author, Date: time.Unix(c.date, 0).UTC()}
+}
+
+// newCommitMatchTree returns a matchTree for commit documents satisfying q.
+func (d *indexData) newCommitMatchTree(q *query.Commit) matchTree {
+	if len(d.commits) == 0 {
+		return &noMatchTree{Why: "no commits"}
+	}
+
+	author := strings.ToLower(q.Author)
+	return &docMatchTree{
+		reason:  "commit",
+		numDocs: d.numDocs(),
+		predicate: func(docID uint32) bool {
+			c, ok := d.commits[docID]
+			if !ok {
+				return false
+			}
+			if author != "" && !strings.Contains(strings.ToLower(c.author), author) {
+				return false
+			}
+			if !q.After.IsZero() && c.date < q.After.Unix() {
+				return false
+			}
+			if !q.Before.IsZero() && c.date >= q.Before.Unix() {
+				return false
+			}
+			return true
+		},
+	}
+}
+
+// hasCommitQuery returns true if q contains a query.Commit atom.
+func hasCommitQuery(q query.Q) bool {
+	found := false
+	query.VisitAtoms(q, func(q query.Q) {
+		if _, ok := q.(*query.Commit); ok {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,153 @@
+package index
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestCommitDocument(t *testing.T) {
+	date := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	doc := CommitDocument(Commit{
+		Hash:    "8a3f",
+		Author:  "Jane Doe <jane@example.com>",
+		Date:    date,
+		Message: "Fix the frobnicator\n\nIt was broken.\n",
+		Paths:   []string{"cmd/frob/main.go"},
+	}, []string{"main"})
+
+	want := `commit 8a3f
+Author: Jane Doe <jane@example.com>
+Date:   2024-01-02T15:04:05Z
+
+    Fix the frobnicator
+
+    It was broken.
+
+cmd/frob/main.go
+`
+	if got := string(doc.Content); got != want {
+		t.Errorf("got content\n%s\nwant\n%s", got, want)
+	}
+
+	if doc.Commit == nil || doc.Commit.Author != "Jane Doe <jane@example.com>" || !doc.Commit.Date.Equal(date) {
+		t.Errorf("got metadata %+v", doc.Commit)
+	}
+}
+
+func TestCommitMetadataRoundTrip(t *testing.T) {
+	want := map[uint32]commitMetadata{
+		3:   {author: "Jane Doe <jane@example.com>", date: 1704207845},
+		300: {author: "", date: -1},
+	}
+	var buf []byte
+	for _, docID := range []uint32{3, 300} {
+		c := want[docID]
+		buf = appendCommitMetadata(buf, docID, &CommitMetadata{Author: c.author, Date: time.Unix(c.date, 0)})
+	}
+
+	got, err := decodeCommitMetadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := decodeCommitMetadata(buf[:len(buf)-1]); err == nil {
+		t.Error("truncated section decoded without error")
+	}
+}
+
+func TestSearchCommits(t *testing.T) {
+	commit := func(hash, author, message string, date time.Time) Document {
+		return CommitDocument(Commit{
+			Hash:    hash,
+			Author:  author,
+			Date:    date,
+			Message: message,
+			Paths:   []string{"needle.go"},
+		}, nil)
+	}
+
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "needle.go", Content: []byte("func needle() {}")},
+		commit("aaaa", "Jane Doe <jane@example.com>", "Add needle", time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)),
+		commit("bbbb", "John Roe <john@example.com>", "Fix needle", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)),
+	)
+
+	names := func(res *zoekt.SearchResult) []string {
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	cases := []struct {
+		name string
+		q    query.Q
+		want []string
+	}{{
+		name: "commits are not returned for code searches",
+		q:    &query.Substring{Pattern: "needle"},
+		want: []string{"needle.go"},
+	}, {
+		name: "commits have no language",
+		q:    &query.Language{Language: "commit"},
+		want: nil,
+	}, {
+		name: "message",
+		q:    query.NewAnd(&query.Commit{}, &query.Substring{Pattern: "fix", Content: true}),
+		want: []string{"bbbb"},
+	}, {
+		name: "changed path",
+		q:    query.NewAnd(&query.Commit{}, &query.Substring{Pattern: "needle.go"}),
+		want: []string{"aaaa", "bbbb"},
+	}, {
+		name: "author",
+		q:    &query.Commit{Author: "JANE@"},
+		want: []string{"aaaa"},
+	}, {
+		name: "after",
+		q:    &query.Commit{After: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		want: []string{"bbbb"},
+	}, {
+		name: "before",
+		q:    &query.Commit{Before: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		want: []string{"aaaa"},
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := names(searchForTest(t, b, tc.q))
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitsDoNotCountAsDocuments(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "needle.go", Content: []byte("func needle() {}")},
+		CommitDocument(Commit{Hash: "aaaa", Message: "Add needle"}, nil),
+	)
+
+	rl, err := searcherForTest(t, b).List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rl.Repos[0].Stats.Documents; got != 1 {
+		t.Errorf("got %d documents, want 1", got)
+	}
+}
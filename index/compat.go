@@ -0,0 +1,190 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// featureUpgrade is how a shard of an older feature version gains a feature.
+type featureUpgrade int
+
+const (
+	// upgradeRewrite features are derived when writing a shard, so
+	// rewriting a shard from its own contents adds them.
+	upgradeRewrite featureUpgrade = iota
+
+	// upgradeLanguages features change language detection. Rewriting a shard
+	// adds them if the languages are detected again.
+	upgradeLanguages
+
+	// upgradeReindex features need data which is not in the shard, like the
+	// sources or ctags output. Only reindexing adds them.
+	upgradeReindex
+)
+
+// featureUpgrades is the compatibility matrix of feature versions. For every
+// feature version since ReadMinFeatureVersion it records how shards of an
+// older feature version are brought up to it. See FeatureVersion for the
+// features. Bumping FeatureVersion requires adding an entry.
+var featureUpgrades = map[int]featureUpgrade{
+	9:  upgradeReindex,
+	10: upgradeRewrite,
+	11: upgradeRewrite,
+	12: upgradeLanguages,
+	13: upgradeReindex,
+	14: upgradeRewrite,
+	15: upgradeRewrite,
+	16: upgradeLanguages,
+	17: upgradeRewrite,
+	18: upgradeRewrite,
+}
+
+// ShardCompatibility is how this version of zoekt can use a shard.
+type ShardCompatibility int
+
+const (
+	// ShardCurrent shards have the current feature version.
+	ShardCurrent ShardCompatibility = iota
+
+	// ShardUpgradable shards can be searched, and UpgradeShard rewrites them
+	// to the current feature version.
+	ShardUpgradable
+
+	// ShardNeedsReindex shards can be searched, but only reindexing brings
+	// them to the current feature version.
+	ShardNeedsReindex
+
+	// ShardIncompatible shards can't be read.
+	ShardIncompatible
+)
+
+func (c ShardCompatibility) String() string {
+	switch c {
+	case ShardCurrent:
+		return "current"
+	case ShardUpgradable:
+		return "upgradable"
+	case ShardNeedsReindex:
+		return "needs-reindex"
+	case ShardIncompatible:
+		return "incompatible"
+	}
+	return fmt.Sprintf("ShardCompatibility(%d)", int(c))
+}
+
+// ErrShardNeedsReindex is returned by UpgradeShard for shards which can't be
+// upgraded without reindexing.
+var ErrShardNeedsReindex = errors.New("shard can only be upgraded by reindexing")
+
+// checkReadable returns an error if a shard with metadata md can't be read.
+func checkReadable(md *zoekt.IndexMetadata) error {
+	if !canReadVersion(md) {
+		return fmt.Errorf("file is v%d, want v%d", md.IndexFormatVersion, IndexFormatVersion)
+	}
+	if md.IndexFeatureVersion < ReadMinFeatureVersion {
+		return fmt.Errorf("file is feature version %d, want feature version >= %d", md.IndexFeatureVersion, ReadMinFeatureVersion)
+	}
+	if md.IndexMinReaderVersion > FeatureVersion {
+		return fmt.Errorf("file needs read feature version >= %d, have read feature version %d", md.IndexMinReaderVersion, FeatureVersion)
+	}
+	return nil
+}
+
+// CheckShardCompatibility returns how a shard with metadata md can be used.
+// For ShardIncompatible the error says why.
+func CheckShardCompatibility(md *zoekt.IndexMetadata) (ShardCompatibility, error) {
+	if err := checkReadable(md); err != nil {
+		return ShardIncompatible, err
+	}
+	if md.IndexFeatureVersion >= FeatureVersion {
+		return ShardCurrent, nil
+	}
+	for v := md.IndexFeatureVersion + 1; v <= FeatureVersion; v++ {
+		if featureUpgrades[v] == upgradeReindex {
+			return ShardNeedsReindex, nil
+		}
+	}
+	return ShardUpgradable, nil
+}
+
+// UpgradeShard rewrites the shard f at the current feature version to
+// dstName, which may be the name of f. It returns ErrShardNeedsReindex if f
+// can't be upgraded, see CheckShardCompatibility. Shards which are current
+// are rewritten as well. The caller closes f.
+//
+// The documents are copied one at a time from f, so only the new shard is
+// held in memory. The contents, symbols, branches and repository metadata are
+// kept, as are the format version and the time f was indexed.
+func UpgradeShard(dstName string, f IndexFile) error {
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		return err
+	}
+	d := searcher.(*indexData)
+
+	compat, err := CheckShardCompatibility(&d.metaData)
+	if err != nil {
+		return err
+	}
+	if compat == ShardNeedsReindex {
+		return ErrShardNeedsReindex
+	}
+
+	redetectLanguages := false
+	for v := d.metaData.IndexFeatureVersion + 1; v <= FeatureVersion; v++ {
+		redetectLanguages = redetectLanguages || featureUpgrades[v] == upgradeLanguages
+	}
+
+	sb := newShardBuilder()
+	sb.indexFormatVersion = d.metaData.IndexFormatVersion
+	sb.IndexTime = d.metaData.IndexTime
+	sb.CompressContents = d.contentBlocks != nil
+	sb.SubTokens = len(d.subTokensIndex) > 0
+	sb.CaseFoldedNgrams = d.foldedNgrams.bt != nil
+	sb.DocumentFrequencies = d.ngramDocFreqs.sz > 0
+	sb.IdentifierIndex = d.identifiers.present()
+	sb.PathPrefixIndex = len(d.pathOrder) > 0
+
+	lastRepoID := -1
+	for docID := uint32(0); int(docID) < len(d.fileBranchMasks); docID++ {
+		repoID := int(d.repos[docID])
+		if d.repoMetaData[repoID].Tombstone {
+			continue
+		}
+
+		if repoID != lastRepoID {
+			if lastRepoID > repoID {
+				return fmt.Errorf("non-contiguous repo ids in %s for document %d: old=%d current=%d", d.String(), docID, lastRepoID, repoID)
+			}
+			lastRepoID = repoID
+			if err := sb.setRepository(&d.repoMetaData[repoID]); err != nil {
+				return err
+			}
+		}
+
+		doc, err := readDocument(d, repoID, docID)
+		if err != nil {
+			return err
+		}
+		if redetectLanguages && !isSkippedDocument(doc) {
+			doc.Language = ""
+		}
+		if err := sb.Add(doc); err != nil {
+			return err
+		}
+	}
+	if len(sb.repoList) == 0 {
+		return fmt.Errorf("%s has no documents outside of tombstoned repositories", d.String())
+	}
+
+	return builderWriteAll(dstName, sb, 0)
+}
+
+// isSkippedDocument returns true if the contents of doc were not indexed, in
+// which case its language says why.
+func isSkippedDocument(doc Document) bool {
+	return doc.Language == "skipped" || doc.Language == "binary" || bytes.HasPrefix(doc.Content, []byte(notIndexedMarker))
+}
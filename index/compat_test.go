@@ -0,0 +1,191 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestFeatureUpgrades(t *testing.T) {
+	for v := ReadMinFeatureVersion + 1; v <= FeatureVersion; v++ {
+		if _, ok := featureUpgrades[v]; !ok {
+			t.Errorf("feature version %d is missing from featureUpgrades", v)
+		}
+	}
+}
+
+func TestCheckShardCompatibility(t *testing.T) {
+	for _, tc := range []struct {
+		md   zoekt.IndexMetadata
+		want ShardCompatibility
+	}{
+		{zoekt.IndexMetadata{IndexFormatVersion: IndexFormatVersion, IndexFeatureVersion: FeatureVersion}, ShardCurrent},
+		{zoekt.IndexMetadata{IndexFormatVersion: NextIndexFormatVersion, IndexFeatureVersion: FeatureVersion}, ShardCurrent},
+		{zoekt.IndexMetadata{IndexFormatVersion: IndexFormatVersion, IndexFeatureVersion: 14}, ShardUpgradable},
+		{zoekt.IndexMetadata{IndexFormatVersion: IndexFormatVersion, IndexFeatureVersion: 12}, ShardNeedsReindex},
+		{zoekt.IndexMetadata{IndexFormatVersion: IndexFormatVersion, IndexFeatureVersion: ReadMinFeatureVersion - 1}, ShardIncompatible},
+		{zoekt.IndexMetadata{IndexFormatVersion: IndexFormatVersion - 1, IndexFeatureVersion: FeatureVersion}, ShardIncompatible},
+		{zoekt.IndexMetadata{IndexFormatVersion: IndexFormatVersion, IndexFeatureVersion: FeatureVersion, IndexMinReaderVersion: FeatureVersion + 1}, ShardIncompatible},
+	} {
+		got, err := CheckShardCompatibility(&tc.md)
+		if got != tc.want {
+			t.Errorf("%+v: got %s, want %s", tc.md, got, tc.want)
+		}
+		if (err != nil) != (tc.want == ShardIncompatible) {
+			t.Errorf("%+v: got error %v", tc.md, err)
+		}
+	}
+}
+
+// writeTestShard writes b to a file in dir and returns its name.
+func writeTestShard(t *testing.T, dir string, b *ShardBuilder) string {
+	t.Helper()
+	name := ShardName(dir, "repo", b.indexFormatVersion, 0)
+	if err := builderWriteAll(name, b, 0); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func openTestShard(t *testing.T, name string) *indexData {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifile, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSearcher(ifile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s.(*indexData)
+}
+
+func upgradeTestShard(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	ifile, err := NewIndexFile(f)
+	if err != nil {
+		return err
+	}
+	defer ifile.Close()
+	return UpgradeShard(name, ifile)
+}
+
+// shardDocuments returns the documents of d without their languages, which
+// upgrades may detect again.
+func shardDocuments(t *testing.T, d *indexData) []Document {
+	t.Helper()
+	var docs []Document
+	for docID := uint32(0); docID < d.numDocs(); docID++ {
+		doc, err := readDocument(d, int(d.repos[docID]), docID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.Language = ""
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func TestUpgradeShard(t *testing.T) {
+	dir := t.TempDir()
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo", Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "v1"}}},
+		Document{Name: "main.go", Content: []byte("package main\n"), Language: "Perl", Branches: []string{"main"}},
+		Document{Name: "main_test.go", Content: []byte("package main\n"), Branches: []string{"main"}},
+		Document{Name: "blob", Content: []byte("bin\x00ary"), Branches: []string{"main"}})
+	b.featureVersion = 15
+	name := writeTestShard(t, dir, b)
+
+	before := openTestShard(t, name)
+	if got, _ := CheckShardCompatibility(&before.metaData); got != ShardUpgradable {
+		t.Fatalf("got %s before upgrading, want %s", got, ShardUpgradable)
+	}
+
+	if err := upgradeTestShard(name); err != nil {
+		t.Fatalf("UpgradeShard: %v", err)
+	}
+
+	after := openTestShard(t, name)
+	if got, _ := CheckShardCompatibility(&after.metaData); got != ShardCurrent {
+		t.Errorf("got %s after upgrading, want %s", got, ShardCurrent)
+	}
+	if !after.metaData.IndexTime.Equal(before.metaData.IndexTime) {
+		t.Errorf("got index time %v, want the original %v", after.metaData.IndexTime, before.metaData.IndexTime)
+	}
+	// The language statistics follow the languages detected again, see below.
+	wantRepos := slices.Clone(before.repoMetaData)
+	wantRepos[0].Languages = map[string]zoekt.LanguageStats{"Go": {Files: 2, Bytes: 26}}
+	if !reflect.DeepEqual(after.repoMetaData, wantRepos) {
+		t.Errorf("got repositories %+v, want %+v", after.repoMetaData, wantRepos)
+	}
+	if got, want := shardDocuments(t, after), shardDocuments(t, before); !reflect.DeepEqual(got, want) {
+		t.Errorf("got documents %+v, want %+v", got, want)
+	}
+
+	// Feature version 16 changed language detection, so languages are
+	// detected again, except for documents which were skipped.
+	for docID, want := range []string{"Go", "Go", "binary"} {
+		if got := after.languageMap[after.getLanguage(uint32(docID))]; got != want {
+			t.Errorf("document %d: got language %q, want %q", docID, got, want)
+		}
+	}
+	if !after.isTestFile(1) {
+		t.Error("main_test.go is not flagged as a test file")
+	}
+}
+
+func TestUpgradeShard_NeedsReindex(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"}, Document{Name: "f", Content: []byte("abc")})
+	b.featureVersion = 12
+	name := writeTestShard(t, t.TempDir(), b)
+
+	if err := upgradeTestShard(name); !errors.Is(err, ErrShardNeedsReindex) {
+		t.Fatalf("got error %v, want %v", err, ErrShardNeedsReindex)
+	}
+}
+
+// TestUpgradeShard_Testdata checks that the shards of older versions of
+// zoekt in testdata can be read, and upgraded if the matrix allows it.
+func TestUpgradeShard_Testdata(t *testing.T) {
+	names, err := filepath.Glob("../testdata/*/*.zoekt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("found no shards in testdata")
+	}
+
+	for _, name := range names {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			before := openTestShard(t, name)
+			compat, err := CheckShardCompatibility(&before.metaData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compat == ShardNeedsReindex {
+				return
+			}
+
+			upgraded := filepath.Join(t.TempDir(), filepath.Base(name))
+			if err := UpgradeShard(upgraded, before.file); err != nil {
+				t.Fatalf("UpgradeShard: %v", err)
+			}
+			after := openTestShard(t, upgraded)
+			if got, want := shardDocuments(t, after), shardDocuments(t, before); !reflect.DeepEqual(got, want) {
+				t.Errorf("upgrading changed the documents")
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package index
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentBlockSize is the uncompressed size of the blocks compressed
+// contents are split into. Each block is a separate zstd frame, so reading a
+// file only decompresses the blocks it overlaps. Changing it changes the
+// index format.
+const contentBlockSize = 64 << 10
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodecs returns the shared encoder and decoder. Their EncodeAll and
+// DecodeAll methods are safe for concurrent use.
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		var err error
+		zstdEncoder, err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			panic(err)
+		}
+		zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		if err != nil {
+			panic(err)
+		}
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// writeCompressedContents writes the concatenated contents of files as zstd
+// compressed blocks of contentBlockSize to blocks, and the uncompressed
+// offsets of the files, including the end of the last file, to offsets.
+func writeCompressedContents(w *writer, files []*searchableString, blocks *compoundSection, offsets *simpleSection) {
+	enc, _ := zstdCodecs()
+
+	var (
+		block []byte
+		dst   []byte
+	)
+	flush := func() {
+		dst = enc.EncodeAll(block, dst[:0])
+		blocks.addItem(w, dst)
+		block = block[:0]
+	}
+
+	blocks.start(w)
+	var end uint32
+	ends := make([]uint32, 0, len(files)+1)
+	ends = append(ends, 0)
+	for _, f := range files {
+		data := f.data
+		end += uint32(len(data))
+		ends = append(ends, end)
+
+		for len(data) > 0 {
+			n := min(contentBlockSize-len(block), len(data))
+			block = append(block, data[:n]...)
+			data = data[n:]
+			if len(block) == contentBlockSize {
+				flush()
+			}
+		}
+	}
+	if len(block) > 0 {
+		flush()
+	}
+	blocks.end(w)
+
+	offsets.start(w)
+	for _, o := range ends {
+		w.U32(o)
+	}
+	offsets.end(w)
+}
+
+// readCompressedContents returns the uncompressed contents in [start, end).
+func (d *indexData) readCompressedContents(start, end uint32) ([]byte, error) {
+	if end <= start {
+		return nil, nil
+	}
+
+	_, dec := zstdCodecs()
+
+	first := start / contentBlockSize
+	last := (end - 1) / contentBlockSize
+	if int(last)+1 >= len(d.contentBlocks) {
+		return nil, fmt.Errorf("content range [%d, %d) beyond the last block", start, end)
+	}
+
+	out := make([]byte, 0, int(last-first+1)*contentBlockSize)
+	for b := first; b <= last; b++ {
+		blob, err := d.readSectionBlob(simpleSection{
+			off: d.contentBlocksStart + d.contentBlocks[b],
+			sz:  d.contentBlocks[b+1] - d.contentBlocks[b],
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out, err = dec.DecodeAll(blob, out); err != nil {
+			return nil, fmt.Errorf("content block %d: %w", b, err)
+		}
+	}
+
+	base := first * contentBlockSize
+	if uint32(len(out)) < end-base {
+		return nil, fmt.Errorf("content blocks %d-%d are %d bytes, want at least %d", first, last, len(out), end-base)
+	}
+	return out[start-base : end-base], nil
+}
@@ -0,0 +1,167 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// compressionTestDocs returns documents spanning several content blocks. The
+// first document contains "needle" across the first block boundary.
+func compressionTestDocs() []Document {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"func", "return", "value", "error", "context", "päivää", "日本語", "if", "nil", "{", "}"}
+	text := func(n int) string {
+		var b strings.Builder
+		for b.Len() < n {
+			b.WriteString(words[rng.Intn(len(words))])
+			if rng.Intn(8) == 0 {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		return b.String()
+	}
+
+	first := strings.Repeat("x", contentBlockSize-3) + "needle\n" + text(1000)
+	docs := []Document{{Name: "first.go", Content: []byte(first)}}
+	for i := 0; i < 30; i++ {
+		docs = append(docs, Document{
+			Name:    fmt.Sprintf("f%d.go", i),
+			Content: []byte(text(rng.Intn(20000))),
+		})
+	}
+	docs = append(docs, Document{Name: "empty.go"}, Document{Name: "last.go", Content: []byte("needle at the end")})
+	return docs
+}
+
+func TestCompressedContents(t *testing.T) {
+	docs := compressionTestDocs()
+
+	var plainBuf, compressedBuf bytes.Buffer
+	// Both shards must have the same IndexTime, which is part of the results.
+	indexTime := time.Now()
+	plainBuilder := testShardBuilder(t, nil, docs...)
+	plainBuilder.IndexTime = indexTime
+	if err := plainBuilder.Write(&plainBuf); err != nil {
+		t.Fatal(err)
+	}
+	compressedBuilder := testShardBuilder(t, nil, docs...)
+	compressedBuilder.CompressContents = true
+	compressedBuilder.IndexTime = indexTime
+	if err := compressedBuilder.Write(&compressedBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	if compressedBuf.Len() >= plainBuf.Len() {
+		t.Errorf("compressed shard is %d bytes, want less than the %d bytes of the plain shard", compressedBuf.Len(), plainBuf.Len())
+	}
+
+	plain, err := NewSearcher(&memSeeker{plainBuf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := NewSearcher(&memSeeker{compressedBuf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := compressed.(*indexData)
+	if len(d.contentBlocks) < 3 {
+		t.Fatalf("got %d content blocks, want several", len(d.contentBlocks)-1)
+	}
+	if got := d.metaData.IndexMinReaderVersion; got != compressedContentsFeatureVersion {
+		t.Errorf("got IndexMinReaderVersion %d, want %d", got, compressedContentsFeatureVersion)
+	}
+	for i, doc := range docs {
+		got, err := d.readContents(uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, doc.Content) {
+			t.Fatalf("contents of %s differ", doc.Name)
+		}
+	}
+
+	for _, q := range []query.Q{
+		&query.Substring{Pattern: "needle", Content: true},
+		&query.Substring{Pattern: "päivää"},
+		&query.Regexp{Regexp: mustParseRE("日本語 (if|nil)"), Content: true},
+	} {
+		for _, opts := range []*zoekt.SearchOptions{
+			{NumContextLines: 1},
+			{ChunkMatches: true, NumContextLines: 1},
+			{Whole: true},
+		} {
+			want, err := plain.Search(context.Background(), q, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := compressed.Search(context.Background(), q, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(want.Files) == 0 {
+				t.Fatalf("%s: no matches", q)
+			}
+			if reflect.DeepEqual(want.Files, got.Files) {
+				continue
+			}
+			if d := cmp.Diff(want.Files, got.Files); d != "" {
+				t.Errorf("%s %+v: mismatch (-plain +compressed):\n%s", q, opts, d)
+			}
+		}
+	}
+}
+
+func TestMerge_CompressedContents(t *testing.T) {
+	docs := compressionTestDocs()
+
+	b1 := testShardBuilder(t, &zoekt.Repository{Name: "repo1"}, docs[:10]...)
+	b1.CompressContents = true
+	b2 := testShardBuilder(t, &zoekt.Repository{Name: "repo2"}, docs[10:]...)
+
+	var ds []*indexData
+	for _, b := range []*ShardBuilder{b1, b2} {
+		ds = append(ds, searcherForTest(t, b).(*indexData))
+	}
+
+	sb, err := merge(ds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sb.CompressContents {
+		t.Fatal("merging a compressed shard should produce a compressed shard")
+	}
+
+	d := searcherForTest(t, sb).(*indexData)
+	if d.contentBlocks == nil {
+		t.Fatal("merged shard has no compressed contents")
+	}
+
+	want := map[string][]byte{}
+	for _, doc := range docs {
+		want[doc.Name] = doc.Content
+	}
+	for i := 0; i < len(d.fileBranchMasks); i++ {
+		name := string(d.fileName(uint32(i)))
+		got, err := d.readContents(uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want[name]) {
+			t.Errorf("contents of %s differ", name)
+		}
+	}
+}
@@ -44,6 +44,9 @@ type contentProvider struct {
 	_sects   []DocumentSection
 	_sectBuf []DocumentSection
 	fileSize uint32
+
+	_subTokens    []uint32
+	_subTokensBuf []uint32
 }
 
 // setDocument skips to the given document.
@@ -55,6 +58,7 @@ func (p *contentProvider) setDocument(docID uint32) {
 
 	p._nl = nil
 	p._sects = nil
+	p._subTokens = nil
 	p._data = nil
 }
 
@@ -119,6 +123,14 @@ func (p *contentProvider) findOffset(filename bool, r uint32) uint32 {
 
 	if filename {
 		data = p.id.fileNameContent[byteOff:]
+	} else if p.id.contentBlocks != nil && byteOff >= fileStartByte {
+		// Compressed contents are read in whole blocks, so we use the file
+		// contents which are usually loaded already.
+		data = p.data(false)
+		if p.err != nil {
+			return 0
+		}
+		data = data[byteOff-fileStartByte:]
 	} else {
 		data, p.err = p.id.readContentSlice(byteOff, 3*runeOffsetFrequency)
 		if p.err != nil {
@@ -178,6 +190,7 @@ func (p *contentProvider) fillMatches(ms []*candidateMatch, numContextLines int,
 			Offset:      m.byteOffset,
 		})
 	}
+	setFragmentOffsets(res.Line, res.LineFragments, opts.OffsetEncoding)
 
 	return []zoekt.LineMatch{res}
 
@@ -211,9 +224,10 @@ func (p *contentProvider) fillChunkMatches(ms []*candidateMatch, numContextLines
 	// Otherwise, we return a single chunk representing the filename index.
 	lineScore, _ := p.scoreLine(filenameMatches, language, -1 /* must pass -1 for filenames */, opts)
 	fileName := p.id.fileName(p.idx)
+	utf16 := opts.OffsetEncoding == zoekt.OffsetEncodingUTF16
 	ranges := make([]zoekt.Range, 0, len(ms))
 	for _, m := range ms {
-		ranges = append(ranges, zoekt.Range{
+		r := zoekt.Range{
 			Start: zoekt.Location{
 				ByteOffset: m.byteOffset,
 				LineNumber: 1,
@@ -224,12 +238,21 @@ func (p *contentProvider) fillChunkMatches(ms []*candidateMatch, numContextLines
 				LineNumber: 1,
 				Column:     uint32(utf8.RuneCount(fileName[:m.byteOffset+m.byteMatchSz]) + 1),
 			},
-		})
+		}
+		if utf16 {
+			r.Start.ColumnUTF16 = uint32(utf16Len(fileName[:m.byteOffset]) + 1)
+			r.End.ColumnUTF16 = uint32(utf16Len(fileName[:m.byteOffset+m.byteMatchSz]) + 1)
+		}
+		ranges = append(ranges, r)
 	}
 
+	contentStart := zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1}
+	if utf16 {
+		contentStart.ColumnUTF16 = 1
+	}
 	return []zoekt.ChunkMatch{{
 		Content:      fileName,
-		ContentStart: zoekt.Location{ByteOffset: 0, LineNumber: 1, Column: 1},
+		ContentStart: contentStart,
 		Ranges:       ranges,
 		FileName:     true,
 		Score:        lineScore.score,
@@ -312,6 +335,7 @@ func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLin
 
 			finalMatch.LineFragments = append(finalMatch.LineFragments, fragment)
 		}
+		setFragmentOffsets(finalMatch.Line, finalMatch.LineFragments, opts.OffsetEncoding)
 		result = append(result, finalMatch)
 	}
 	return result
@@ -320,6 +344,12 @@ func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLin
 func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numContextLines int, language string, opts *zoekt.SearchOptions) []zoekt.ChunkMatch {
 	data := p.data(false)
 
+	// utf16Helper counts the columns in UTF-16 code units, if requested.
+	var utf16Helper *columnHelper
+	if opts.OffsetEncoding == zoekt.OffsetEncodingUTF16 {
+		utf16Helper = &columnHelper{data: data, utf16: true}
+	}
+
 	// columnHelper prevents O(len(ms) * len(data)) lookups for all columns.
 	// However, it depends on ms being sorted by byteOffset and non-overlapping.
 	// This invariant is true at the time of writing, but we conservatively
@@ -341,7 +371,7 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 			endOffset := cm.byteOffset + cm.byteMatchSz
 			startLine, endLine := newlines.offsetRangeToLineRange(startOffset, endOffset)
 
-			ranges = append(ranges, zoekt.Range{
+			r := zoekt.Range{
 				Start: zoekt.Location{
 					ByteOffset: startOffset,
 					LineNumber: uint32(startLine),
@@ -352,7 +382,12 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 					LineNumber: uint32(endLine),
 					Column:     columnHelper.get(int(newlines.lineStart(endLine)), endOffset),
 				},
-			})
+			}
+			if utf16Helper != nil {
+				r.Start.ColumnUTF16 = utf16Helper.get(int(newlines.lineStart(startLine)), startOffset)
+				r.End.ColumnUTF16 = utf16Helper.get(int(newlines.lineStart(endLine)), endOffset)
+			}
+			ranges = append(ranges, r)
 		}
 
 		firstLineNumber := int(chunk.firstLine) - numContextLines
@@ -360,15 +395,19 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 			firstLineNumber = 1
 		}
 		firstLineStart := newlines.lineStart(firstLineNumber)
+		contentStart := zoekt.Location{
+			ByteOffset: firstLineStart,
+			LineNumber: uint32(firstLineNumber),
+			Column:     1,
+		}
+		if utf16Helper != nil {
+			contentStart.ColumnUTF16 = 1
+		}
 
 		chunkScore, symbolInfo := p.scoreChunk(chunk.candidates, language, opts)
 		chunkMatches = append(chunkMatches, zoekt.ChunkMatch{
-			Content: newlines.getLines(data, firstLineNumber, int(chunk.lastLine)+numContextLines+1),
-			ContentStart: zoekt.Location{
-				ByteOffset: firstLineStart,
-				LineNumber: uint32(firstLineNumber),
-				Column:     1,
-			},
+			Content:       newlines.getLines(data, firstLineNumber, int(chunk.lastLine)+numContextLines+1),
+			ContentStart:  contentStart,
 			FileName:      false,
 			Ranges:        ranges,
 			SymbolInfo:    symbolInfo,
@@ -433,6 +472,9 @@ func chunkCandidates(ms []*candidateMatch, newlines newlines, numContextLines in
 type columnHelper struct {
 	data []byte
 
+	// utf16 counts UTF-16 code units instead of runes.
+	utf16 bool
+
 	// 0 values for all these are valid values
 	lastLineOffset int
 	lastOffset     uint32
@@ -447,10 +489,10 @@ func (c *columnHelper) get(lineOffset int, offset uint32) uint32 {
 
 	if lineOffset == c.lastLineOffset && offset >= c.lastOffset {
 		// Can count from last calculation
-		runeCount = c.lastRuneCount + uint32(utf8.RuneCount(c.data[c.lastOffset:offset]))
+		runeCount = c.lastRuneCount + c.count(c.data[c.lastOffset:offset])
 	} else {
 		// Need to count from the beginning of line
-		runeCount = uint32(utf8.RuneCount(c.data[lineOffset:offset]))
+		runeCount = c.count(c.data[lineOffset:offset])
 	}
 
 	c.lastLineOffset = lineOffset
@@ -460,6 +502,69 @@ func (c *columnHelper) get(lineOffset int, offset uint32) uint32 {
 	return runeCount + 1
 }
 
+func (c *columnHelper) count(b []byte) uint32 {
+	if c.utf16 {
+		return uint32(utf16Len(b))
+	}
+	return uint32(utf8.RuneCount(b))
+}
+
+// utf16Len returns the number of UTF-16 code units of the UTF-8 text b.
+// Invalid bytes count as one unit each, like the replacement character which
+// decoders substitute for them.
+func utf16Len(b []byte) int {
+	n := 0
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			n++
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r > 0xFFFF {
+			n += 2
+		} else {
+			n++
+		}
+		i += size
+	}
+	return n
+}
+
+// setFragmentOffsets sets the offsets of the fragments of line in runes or
+// UTF-16 code units, see zoekt.SearchOptions.OffsetEncoding.
+func setFragmentOffsets(line []byte, fragments []zoekt.LineFragmentMatch, enc zoekt.OffsetEncoding) {
+	var count func([]byte) int
+	switch enc {
+	case zoekt.OffsetEncodingRunes:
+		count = utf8.RuneCount
+	case zoekt.OffsetEncodingUTF16:
+		count = utf16Len
+	default:
+		return
+	}
+
+	// Fragments are usually sorted, so we count from the end of the previous
+	// one.
+	last, lastCount := 0, 0
+	for i := range fragments {
+		f := &fragments[i]
+		start := min(f.LineOffset, len(line))
+		end := min(start+f.MatchLength, len(line))
+		if start < last {
+			last, lastCount = 0, 0
+		}
+		offset := lastCount + count(line[last:start])
+		length := count(line[start:end])
+		if enc == zoekt.OffsetEncodingRunes {
+			f.LineOffsetRunes, f.MatchLengthRunes = offset, length
+		} else {
+			f.LineOffsetUTF16, f.MatchLengthUTF16 = offset, length
+		}
+		last, lastCount = end, offset+length
+	}
+}
+
 type newlines struct {
 	// locs is the sorted set of byte offsets of the newlines in the file
 	locs []uint32
@@ -479,6 +584,15 @@ func (nls newlines) atOffset(offset uint32) (lineNumber int) {
 	return idx + 1
 }
 
+// lineCount returns the number of lines in the file. The last line doesn't
+// need to end in a newline.
+func (nls newlines) lineCount() int {
+	if nls.fileSize == 0 {
+		return 0
+	}
+	return nls.atOffset(nls.fileSize - 1)
+}
+
 // lineStart returns the byte offset of the beginning of the given line.
 // lineNumber is 1-based. If lineNumber is out of range of the lines in the
 // file, the return value will be clamped to [0,fileSize].
@@ -520,6 +634,7 @@ const (
 	// Query-dependent scoring signals. All of these together are bounded at ~9000
 	// (scoreWordMatch + scoreSymbol + scoreKindMatch * 10 + scoreFactorAtomMatch).
 	scorePartialWordMatch = 50.0
+	scoreSubTokenMatch    = 200.0
 	scoreWordMatch        = 500.0
 	scoreBase             = 7000.0
 	scorePartialBase      = 4000.0
@@ -527,6 +642,20 @@ const (
 	scorePartialSymbol    = 4000.0
 	scoreKindMatch        = 100.0
 	scoreFactorAtomMatch  = 400.0
+	// scoreFuzzyMatch is the score of a file name which matches a fuzzy
+	// pattern perfectly, like a base match, scaled down for worse matches.
+	scoreFuzzyMatch = 7000.0
+
+	// Test files keep this fraction of their query-dependent score, so that
+	// implementation code ranks first among otherwise equal matches.
+	scoreTestFileFactor = 0.9
+
+	// Likewise for generated and vendored files, see Document.Generated.
+	scoreGeneratedFileFactor = 0.8
+
+	// The boost of a file modified just now, if SearchOptions.RecencyHalfLife
+	// is set. It is on par with a word match.
+	scoreFactorRecency = 500.0
 
 	// Used for ordering line and chunk matches within a file.
 	scoreLineOrderFactor = 1.0
@@ -8,6 +8,9 @@ import (
 	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 func getNewlines(data []byte) newlines {
@@ -464,3 +467,50 @@ func TestFindMaxOverlappingSection(t *testing.T) {
 		})
 	}
 }
+
+func TestOffsetEncoding(t *testing.T) {
+	// "é" is 2 bytes and 1 UTF-16 unit, "😀" is 4 bytes and 2 UTF-16 units.
+	b := testShardBuilder(t, nil, Document{Name: "f", Content: []byte("x\né😀 needle😀needle\n")})
+
+	for _, tc := range []struct {
+		enc                   zoekt.OffsetEncoding
+		wantOffsets           [][2]int
+		wantStart, wantStart2 uint32
+	}{
+		{enc: zoekt.OffsetEncodingRunes, wantOffsets: [][2]int{{3, 6}, {10, 6}}},
+		{enc: zoekt.OffsetEncodingUTF16, wantOffsets: [][2]int{{4, 6}, {12, 6}}, wantStart: 5, wantStart2: 13},
+	} {
+		t.Run(tc.enc.String(), func(t *testing.T) {
+			res := searchForTest(t, b, &query.Substring{Pattern: "needle", Content: true}, zoekt.SearchOptions{OffsetEncoding: tc.enc})
+			if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 {
+				t.Fatalf("got %+v, want one line match", res.Files)
+			}
+			var got [][2]int
+			for _, f := range res.Files[0].LineMatches[0].LineFragments {
+				if tc.enc == zoekt.OffsetEncodingRunes {
+					got = append(got, [2]int{f.LineOffsetRunes, f.MatchLengthRunes})
+				} else {
+					got = append(got, [2]int{f.LineOffsetUTF16, f.MatchLengthUTF16})
+				}
+			}
+			if d := cmp.Diff(tc.wantOffsets, got); d != "" {
+				t.Errorf("line fragments (-want +got):\n%s", d)
+			}
+
+			res = searchForTest(t, b, &query.Substring{Pattern: "needle", Content: true}, zoekt.SearchOptions{OffsetEncoding: tc.enc, ChunkMatches: true})
+			if len(res.Files) != 1 || len(res.Files[0].ChunkMatches) != 1 {
+				t.Fatalf("got %+v, want one chunk match", res.Files)
+			}
+			ranges := res.Files[0].ChunkMatches[0].Ranges
+			if len(ranges) != 2 || ranges[0].Start.Column != 4 || ranges[0].Start.ColumnUTF16 != tc.wantStart || ranges[1].Start.ColumnUTF16 != tc.wantStart2 {
+				t.Errorf("got ranges %+v", ranges)
+			}
+		})
+	}
+
+	// Without an encoding only bytes are reported.
+	res := searchForTest(t, b, &query.Substring{Pattern: "needle", Content: true})
+	if f := res.Files[0].LineMatches[0].LineFragments[0]; f.LineOffset != 7 || f.LineOffsetRunes != 0 || f.LineOffsetUTF16 != 0 {
+		t.Errorf("got fragment %+v", f)
+	}
+}
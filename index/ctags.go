@@ -171,6 +171,7 @@ func (t *tagsToSections) Convert(content []byte, tags []*ctags.Entry) ([]Documen
 			Kind:       t.Kind,
 			Parent:     t.Parent,
 			ParentKind: t.ParentKind,
+			Signature:  t.Signature,
 		})
 	}
 
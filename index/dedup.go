@@ -0,0 +1,61 @@
+package index
+
+import (
+	"slices"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// DedupByChecksum collapses file matches with the same content in different
+// repositories into the best scoring one, see
+// zoekt.SearchOptions.DedupByChecksum. The repositories of the collapsed
+// matches, and the ones they collapsed before, are added to its
+// DuplicateRepositories, so results which were deduplicated by each shard can
+// be deduplicated again when they are aggregated. Files without a checksum
+// and copies in the repository of the best match are kept. If nothing is
+// collapsed, files is returned unchanged.
+func DedupByChecksum(files []zoekt.FileMatch) []zoekt.FileMatch {
+	// best maps a checksum to the index of the best scoring file with it.
+	best := map[string]int{}
+	for i := range files {
+		k := string(files[i].Checksum)
+		if k == "" {
+			continue
+		}
+		if j, ok := best[k]; !ok || files[i].Score > files[j].Score {
+			best[k] = i
+		}
+	}
+
+	// dups holds the repositories collapsed into each best file.
+	dups := map[int][]string{}
+	collapsed := make([]bool, len(files))
+	for i := range files {
+		f := &files[i]
+		j, ok := best[string(f.Checksum)]
+		if !ok || j == i || f.Repository == files[j].Repository {
+			continue
+		}
+		collapsed[i] = true
+		dups[j] = unionStrings(dups[j], append([]string{f.Repository}, f.DuplicateRepositories...))
+	}
+
+	if len(dups) == 0 {
+		return files
+	}
+
+	deduped := make([]zoekt.FileMatch, 0, len(files))
+	for i, f := range files {
+		if collapsed[i] {
+			continue
+		}
+		if repos, ok := dups[i]; ok {
+			repos = unionStrings(slices.Clone(f.DuplicateRepositories), repos)
+			f.DuplicateRepositories = slices.DeleteFunc(repos, func(r string) bool {
+				return r == f.Repository
+			})
+		}
+		deduped = append(deduped, f)
+	}
+	return deduped
+}
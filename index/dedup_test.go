@@ -0,0 +1,45 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestDedupByChecksum(t *testing.T) {
+	file := func(repo, name, checksum string, score float64) zoekt.FileMatch {
+		return zoekt.FileMatch{Repository: repo, FileName: name, Checksum: []byte(checksum), Score: score}
+	}
+
+	// Results of two shards, which were deduplicated by each shard, are
+	// deduplicated again when they are aggregated.
+	files := DedupByChecksum([]zoekt.FileMatch{
+		file("fork1", "LICENSE", "a", 1),
+		file("fork2", "LICENSE", "a", 2),
+		file("fork1", "main.go", "b", 1),
+	})
+	files = append(files, DedupByChecksum([]zoekt.FileMatch{
+		file("repo", "LICENSE", "a", 3),
+		file("repo", "vendor/LICENSE", "a", 1),
+		file("fork3", "LICENSE", "a", 1),
+		{Repository: "fork3", FileName: "empty"},
+	})...)
+
+	got := DedupByChecksum(files)
+	want := []zoekt.FileMatch{
+		file("fork1", "main.go", "b", 1),
+		{Repository: "repo", FileName: "LICENSE", Checksum: []byte("a"), Score: 3, DuplicateRepositories: []string{"fork3", "fork2", "fork1"}},
+		file("repo", "vendor/LICENSE", "a", 1),
+		{Repository: "fork3", FileName: "empty"},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	// Deduplication is idempotent.
+	if d := cmp.Diff(got, DedupByChecksum(got)); d != "" {
+		t.Errorf("deduplicating again changed the result (-want +got):\n%s", d)
+	}
+}
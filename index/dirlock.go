@@ -0,0 +1,124 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dirLockDir is the directory in an index directory which holds the lock
+// files of DirLock.
+const dirLockDir = ".locks"
+
+// globalLockFile is the lock file of the whole index directory.
+const globalLockFile = "global.lock"
+
+// ErrLocked is returned by DirLock.LockRepo if another process holds the lock
+// of the repository.
+var ErrLocked = errors.New("locked by another process")
+
+// DirLock coordinates the processes which write to an index directory, eg.
+// several indexservers which share the directory over NFS. Like the locks
+// within a process, a global lock excludes all other writers, for example to
+// merge or clean up shards, while repository locks only exclude writers of
+// the same repository.
+//
+// The locks are flock(2) locks on files in the .locks directory of the index
+// directory. They are released when the process exits, and the Linux NFS
+// client maps them to byte range locks, which the server expires if a client
+// disappears. Locks taken within the same process exclude each other too, so
+// callers must serialize them, eg. with a sync.RWMutex.
+type DirLock struct {
+	dir string
+
+	// pollInterval is the longest wait between attempts to take a lock that
+	// another process holds.
+	pollInterval time.Duration
+}
+
+// NewDirLock returns a DirLock for the index directory indexDir.
+func NewDirLock(indexDir string) (*DirLock, error) {
+	dir := filepath.Join(indexDir, dirLockDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirLock{dir: dir, pollInterval: time.Second}, nil
+}
+
+// LockGlobal blocks until no other process holds the global lock or a
+// repository lock, and then takes the global lock. Call unlock to release
+// it.
+func (l *DirLock) LockGlobal(ctx context.Context) (unlock func(), err error) {
+	f, err := l.lock(ctx, globalLockFile, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// LockRepo takes the lock of the repository name. It blocks while another
+// process holds the global lock, and returns ErrLocked if another process
+// holds the lock of the repository. Call unlock to release it.
+func (l *DirLock) LockRepo(ctx context.Context, name string) (unlock func(), err error) {
+	global, err := l.lock(ctx, globalLockFile, false, true)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := l.lock(ctx, url.QueryEscape(name)+".lock", true, false)
+	if err != nil {
+		global.Close()
+		return nil, err
+	}
+	return func() {
+		repo.Close()
+		global.Close()
+	}, nil
+}
+
+// lock locks the lock file name, shared or exclusive. If wait is false, it
+// returns ErrLocked instead of waiting for another process.
+func (l *DirLock) lock(ctx context.Context, name string, exclusive, wait bool) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// flock can't be interrupted, so poll instead of blocking in it.
+	delay := 10 * time.Millisecond
+	for {
+		ok, err := tryFlock(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", name, err)
+		}
+		if ok {
+			break
+		}
+		if !wait {
+			f.Close()
+			return nil, ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, l.pollInterval)
+	}
+
+	if exclusive {
+		// Record the holder to help debugging stuck locks.
+		hostname, _ := os.Hostname()
+		holder := fmt.Sprintf("%s pid=%d since=%s\n", hostname, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+		if err := f.Truncate(0); err == nil {
+			_, _ = f.WriteAt([]byte(holder), 0)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows || wasm
+
+package index
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func tryFlock(f *os.File, exclusive bool) (bool, error) {
+	return false, fmt.Errorf("locking index directories is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,70 @@
+//go:build !windows && !wasm
+
+package index
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDirLock(t *testing.T) {
+	// flock locks of different open files exclude each other even within a
+	// process, so two DirLocks on the same directory act like two processes.
+	dir := t.TempDir()
+	newLock := func() *DirLock {
+		l, err := NewDirLock(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.pollInterval = 10 * time.Millisecond
+		return l
+	}
+	a, b := newLock(), newLock()
+
+	timeout := func() context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	unlockRepo, err := a.LockRepo(context.Background(), "github.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LockRepo(context.Background(), "github.com/foo/bar"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("same repository: got %v, want ErrLocked", err)
+	}
+	unlockOther, err := b.LockRepo(context.Background(), "github.com/foo/baz")
+	if err != nil {
+		t.Fatalf("other repository: %v", err)
+	}
+	unlockOther()
+	if _, err := b.LockGlobal(timeout()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("global while a repository is locked: got %v, want context.DeadlineExceeded", err)
+	}
+	unlockRepo()
+
+	unlockGlobal, err := b.LockGlobal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.LockRepo(timeout(), "github.com/foo/bar"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("repository while global is locked: got %v, want context.DeadlineExceeded", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		unlock, err := a.LockGlobal(context.Background())
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	unlockGlobal()
+	if err := <-done; err != nil {
+		t.Fatalf("waiting for global: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package index
+
+import (
+	"encoding/binary"
+)
+
+// Shards built with document frequencies store the number of documents each
+// content ngram occurs in. BM25 scoring estimates the document frequency of a
+// query term from those of its ngrams, so that the idf of a term doesn't
+// depend on which documents the search happened to visit.
+
+// ngramDocFreq returns the number of documents whose content has ng.
+func (d *indexData) ngramDocFreq(ng ngram) uint32 {
+	i, ok := d.contentNgrams.ngramIndex(ng)
+	if !ok {
+		return 0
+	}
+	b, err := d.file.Read(d.ngramDocFreqs.off+uint32(i)*4, 4)
+	if err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// termDocFreq estimates the number of documents whose content has term,
+// ignoring case. Every document with the term has all its ngrams, so the
+// least common ngram bounds the count from above. ok is false if the shard
+// has no document frequencies or term is shorter than an ngram.
+func (d *indexData) termDocFreq(term string) (df int, ok bool) {
+	if d.ngramDocFreqs.sz == 0 {
+		return 0, false
+	}
+	ngramOffs := splitNGrams([]byte(term))
+	if len(ngramOffs) == 0 {
+		return 0, false
+	}
+
+	df = int(d.numDocs())
+	for _, o := range ngramOffs {
+		n := 0
+		for _, v := range generateCaseNgrams(o.ngram) {
+			n += int(d.ngramDocFreq(v))
+		}
+		df = min(df, n)
+		if df == 0 {
+			break
+		}
+	}
+	return df, true
+}
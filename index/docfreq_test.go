@@ -0,0 +1,116 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var docFreqDocs = []Document{
+	{Name: "a.txt", Content: []byte("foo bar foo\n")},
+	{Name: "b.txt", Content: []byte("Foo baz\n")},
+	{Name: "c.txt", Content: []byte("bar\n")},
+	{Name: "d.txt", Content: []byte("qux\n")},
+}
+
+func TestTermDocFreq(t *testing.T) {
+	b := testShardBuilder(t, nil, docFreqDocs...)
+	b.DocumentFrequencies = true
+	d := searcherForTest(t, b).(*indexData)
+
+	for _, tc := range []struct {
+		ngram string
+		want  uint32
+	}{
+		{"foo", 1},
+		{"Foo", 1},
+		{"bar", 2},
+		{"FOO", 0},
+	} {
+		if got := d.ngramDocFreq(stringToNGram(tc.ngram)); got != tc.want {
+			t.Errorf("ngramDocFreq(%s) = %d, want %d", tc.ngram, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		term string
+		want int
+	}{
+		{"foo", 2},
+		{"bar", 2},
+		{"baz", 1},
+		{"bar foo", 1},
+		{"missing", 0},
+	} {
+		if got, ok := d.termDocFreq(tc.term); !ok || got != tc.want {
+			t.Errorf("termDocFreq(%q) = %d, %t, want %d", tc.term, got, ok, tc.want)
+		}
+	}
+	if _, ok := d.termDocFreq("fo"); ok {
+		t.Errorf("got a document frequency for a term shorter than an ngram")
+	}
+
+	d = searcherForTest(t, testShardBuilder(t, nil, docFreqDocs...)).(*indexData)
+	if _, ok := d.termDocFreq("foo"); ok {
+		t.Errorf("got a document frequency from a shard without them")
+	}
+}
+
+// TestBM25DocumentFrequencies checks that with stored document frequencies,
+// the score of a file doesn't depend on the other files the search visits.
+func TestBM25DocumentFrequencies(t *testing.T) {
+	score := func(s zoekt.Searcher, q query.Q) float64 {
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{UseBM25Scoring: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range res.Files {
+			if f.FileName == "a.txt" {
+				return f.Score
+			}
+		}
+		t.Fatalf("%s: a.txt did not match", q)
+		return 0
+	}
+	foo := &query.Substring{Pattern: "foo", Content: true}
+	bar := &query.Substring{Pattern: "bar", Content: true}
+
+	for _, stored := range []bool{false, true} {
+		b := testShardBuilder(t, nil, docFreqDocs...)
+		b.DocumentFrequencies = stored
+		s := searcherForTest(t, b)
+
+		and, or := score(s, query.NewAnd(foo, bar)), score(s, query.NewOr(foo, bar))
+		if stored && and != or {
+			t.Errorf("with document frequencies, got score %f for AND and %f for OR", and, or)
+		}
+		if !stored && and == or {
+			t.Errorf("without document frequencies, got the same score %f for AND and OR", and)
+		}
+	}
+}
+
+func TestMerge_DocumentFrequencies(t *testing.T) {
+	b1 := testShardBuilder(t, &zoekt.Repository{Name: "repo1"}, Document{Name: "a.go", Content: []byte("foobar")})
+	b1.DocumentFrequencies = true
+	b2 := testShardBuilder(t, &zoekt.Repository{Name: "repo2"}, Document{Name: "b.go", Content: []byte("foobar")})
+
+	var ds []*indexData
+	for _, b := range []*ShardBuilder{b1, b2} {
+		ds = append(ds, searcherForTest(t, b).(*indexData))
+	}
+
+	sb, err := merge(ds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sb.DocumentFrequencies {
+		t.Fatal("merging a shard with document frequencies should produce a shard with document frequencies")
+	}
+	d := searcherForTest(t, sb).(*indexData)
+	if got, _ := d.termDocFreq("foobar"); got != 2 {
+		t.Errorf("got document frequency %d for the merged shard, want 2", got)
+	}
+}
@@ -0,0 +1,116 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"math/bits"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+)
+
+var _ zoekt.DocumentFetcher = (*indexData)(nil)
+
+// FetchDocument returns the document of the shard which req identifies, or
+// zoekt.ErrDocumentNotFound.
+func (d *indexData) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	repoID := -1
+	for i, md := range d.repoMetaData {
+		// 🚨 SECURITY: Only return documents of repositories the tenant can
+		// access.
+		if md.Name == req.Repository && !md.Tombstone && tenant.HasAccess(ctx, md.TenantID) {
+			repoID = i
+			break
+		}
+	}
+	if repoID < 0 {
+		return nil, zoekt.ErrDocumentNotFound
+	}
+
+	var branchMask uint64
+	if req.Branch != "" {
+		branchMask = uint64(d.branchIDs[repoID][req.Branch])
+		if branchMask == 0 {
+			return nil, zoekt.ErrDocumentNotFound
+		}
+	}
+
+	// A delta build replaced the file in a newer shard, so this shard's
+	// version is stale.
+	if isTombstoned(d.repoMetaData[repoID].FileTombstones, req.FileName) {
+		return nil, zoekt.ErrDocumentNotFound
+	}
+
+	// A file has a document per distinct version on the indexed branches.
+	// Without a branch we pick the version of the first branch. Like
+	// searches, we skip the documents which describe commits.
+	fileName := []byte(req.FileName)
+	doc, found := uint32(0), false
+	for i := uint32(0); i < d.numDocs(); i++ {
+		if int(d.repos[i]) != repoID || !bytes.Equal(d.fileName(i), fileName) {
+			continue
+		}
+		if d.isCommitDoc(i) {
+			continue
+		}
+		mask := d.fileBranchMasks[i]
+		if branchMask != 0 {
+			if mask&branchMask != 0 {
+				doc, found = i, true
+				break
+			}
+		} else if !found || bits.TrailingZeros64(mask) < bits.TrailingZeros64(d.fileBranchMasks[doc]) {
+			doc, found = i, true
+		}
+	}
+	if !found {
+		return nil, zoekt.ErrDocumentNotFound
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	content, err := d.readContents(doc)
+	if err != nil {
+		return nil, err
+	}
+	secs, _, err := d.readDocSections(doc, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &d.repoMetaData[repoID]
+	result := &zoekt.Document{
+		Repository:   md.Name,
+		RepositoryID: md.ID,
+		FileName:     req.FileName,
+		Language:     d.languageMap[d.getLanguage(doc)],
+		Checksum:     d.getChecksum(doc),
+		Content:      content,
+	}
+
+	mask := d.fileBranchMasks[doc]
+	for m := mask; m != 0; m &= m - 1 {
+		result.Branches = append(result.Branches, d.branchNames[repoID][uint(1)<<bits.TrailingZeros64(m)])
+	}
+	if branchMask != 0 {
+		mask = branchMask
+	}
+	branches := md.Branches
+	if s := d.subRepos[doc]; s > 0 {
+		branches = md.SubRepoMap[d.subRepoPaths[repoID][s]].Branches
+	}
+	if idx := bits.TrailingZeros64(mask); idx < len(branches) {
+		result.Version = branches[idx].Version
+	}
+
+	for i, sec := range secs {
+		sym := zoekt.DocumentSymbol{Start: sec.Start, End: sec.End}
+		if si := d.symbols.data(d.fileEndSymbol[doc] + uint32(i)); si != nil {
+			sym.Symbol = *si
+		}
+		sym.Sym = string(sectionSlice(content, sec))
+		result.Symbols = append(result.Symbols, sym)
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestFetchDocument(t *testing.T) {
+	repo := &zoekt.Repository{
+		Name: "repo",
+		Branches: []zoekt.RepositoryBranch{
+			{Name: "HEAD", Version: "v1"},
+			{Name: "dev", Version: "v2"},
+		},
+	}
+	b := testShardBuilder(t, repo,
+		Document{Name: "a.go", Content: []byte("func old()"), Branches: []string{"dev"}},
+		wordsAsSymbols(Document{Name: "a.go", Content: []byte("func main()"), Branches: []string{"HEAD"}}),
+		Document{Name: "b.go", Content: []byte("package b"), Branches: []string{"HEAD", "dev"}},
+	)
+	d := searcherForTest(t, b).(*indexData)
+	ctx := context.Background()
+
+	doc, err := d.FetchDocument(ctx, &zoekt.DocumentRequest{Repository: "repo", FileName: "a.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zoekt.Document{
+		Repository: "repo",
+		FileName:   "a.go",
+		Branches:   []string{"HEAD"},
+		Version:    "v1",
+		Language:   "Go",
+		Checksum:   doc.Checksum,
+		Content:    []byte("func main()"),
+		Symbols: []zoekt.DocumentSymbol{
+			{Start: 0, End: 4, Symbol: zoekt.Symbol{Sym: "func", Kind: "method"}},
+			{Start: 5, End: 9, Symbol: zoekt.Symbol{Sym: "main", Kind: "method"}},
+		},
+	}
+	if d := cmp.Diff(want, doc); d != "" {
+		t.Errorf("default branch mismatch (-want +got):\n%s", d)
+	}
+
+	doc, err = d.FetchDocument(ctx, &zoekt.DocumentRequest{Repository: "repo", FileName: "a.go", Branch: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(doc.Content); got != "func old()" || doc.Version != "v2" || len(doc.Symbols) != 0 {
+		t.Errorf("dev: got content %q, version %q and %d symbols", got, doc.Version, len(doc.Symbols))
+	}
+
+	doc, err = d.FetchDocument(ctx, &zoekt.DocumentRequest{Repository: "repo", FileName: "b.go", Branch: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"HEAD", "dev"}, doc.Branches); d != "" || doc.Version != "v2" {
+		t.Errorf("b.go on dev: got version %q, branches mismatch (-want +got):\n%s", doc.Version, d)
+	}
+
+	for _, req := range []zoekt.DocumentRequest{
+		{Repository: "repo", FileName: "c.go"},
+		{Repository: "other", FileName: "a.go"},
+		{Repository: "repo", FileName: "a.go", Branch: "missing"},
+	} {
+		if _, err := d.FetchDocument(ctx, &req); !errors.Is(err, zoekt.ErrDocumentNotFound) {
+			t.Errorf("%+v: got error %v, want %v", req, err, zoekt.ErrDocumentNotFound)
+		}
+	}
+}
+
+func TestFetchDocumentSkipped(t *testing.T) {
+	// A delta build tombstoned a.go in this older shard, and 8a3f is a
+	// commit rather than a file.
+	repo := &zoekt.Repository{
+		Name:           "repo",
+		FileTombstones: map[string]struct{}{"a.go": {}},
+	}
+	b := testShardBuilder(t, repo,
+		Document{Name: "a.go", Content: []byte("func stale()")},
+		Document{Name: "b.go", Content: []byte("package b")},
+		CommitDocument(Commit{Hash: "8a3f", Message: "Add b.go"}, nil),
+	)
+	d := searcherForTest(t, b).(*indexData)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.go", "8a3f"} {
+		req := &zoekt.DocumentRequest{Repository: "repo", FileName: name}
+		if _, err := d.FetchDocument(ctx, req); !errors.Is(err, zoekt.ErrDocumentNotFound) {
+			t.Errorf("%s: got error %v, want %v", name, err, zoekt.ErrDocumentNotFound)
+		}
+	}
+	if _, err := d.FetchDocument(ctx, &zoekt.DocumentRequest{Repository: "repo", FileName: "b.go"}); err != nil {
+		t.Errorf("b.go: %v", err)
+	}
+}
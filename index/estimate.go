@@ -0,0 +1,97 @@
+package index
+
+import "github.com/sourcegraph/zoekt/query"
+
+// MatchEstimator is implemented by the searchers returned by NewSearcher.
+type MatchEstimator interface {
+	// EstimateMatches returns a cheap estimate of the number of matches of q
+	// in the shard. It only consults the ngram index, so it is an upper bound
+	// for queries made of substrings and a coarse guess otherwise. Estimates
+	// of different shards are comparable.
+	EstimateMatches(q query.Q) int
+}
+
+var _ MatchEstimator = (*indexData)(nil)
+
+func (d *indexData) EstimateMatches(q query.Q) int {
+	if len(d.fileNameIndex) == 0 {
+		return 0
+	}
+	return d.estimateMatches(d.simplify(q))
+}
+
+// estimateMatches estimates the number of hits of q by the size of the
+// posting lists of its ngrams. Atoms which the ngram index can't narrow down
+// are estimated by the size of all content or file names, ie. as if every
+// byte matched.
+func (d *indexData) estimateMatches(q query.Q) int {
+	switch s := q.(type) {
+	case *query.Const:
+		if !s.Value {
+			return 0
+		}
+		return d.allContentBytes()
+	case *query.And:
+		est := -1
+		for _, ch := range s.Children {
+			if e := d.estimateMatches(ch); est < 0 || e < est {
+				est = e
+			}
+		}
+		return max(est, 0)
+	case *query.Or:
+		est := 0
+		for _, ch := range s.Children {
+			est += d.estimateMatches(ch)
+		}
+		return est
+	case *query.Substring:
+		return d.estimateSubstring(s)
+	case *query.Symbol:
+		return d.estimateMatches(s.Expr)
+	case *query.Type:
+		return d.estimateMatches(s.Child)
+	case *query.Boost:
+		return d.estimateMatches(s.Child)
+	default:
+		return d.allContentBytes()
+	}
+}
+
+// estimateSubstring returns the frequency of the rarest ngram of s, the same
+// measure iterateNgrams uses to pick ngrams.
+func (d *indexData) estimateSubstring(s *query.Substring) int {
+	ngramOffs := splitNGrams([]byte(s.Pattern))
+	if len(ngramOffs) == 0 {
+		if s.FileName {
+			return len(d.fileNameContent)
+		}
+		return d.allContentBytes()
+	}
+
+	ngrams := d.ngrams(s.FileName)
+	est := -1
+	for _, o := range ngramOffs {
+		var freq uint32
+		if s.CaseSensitive {
+			freq = ngrams.Get(o.ngram).sz
+		} else if sec, _, ok := d.foldedPostings(o.ngram, s.FileName); ok {
+			freq = sec.sz
+		} else {
+			for _, v := range generateCaseNgrams(o.ngram) {
+				freq += ngrams.Get(v).sz
+			}
+		}
+		if est < 0 || int(freq) < est {
+			est = int(freq)
+		}
+	}
+	return est
+}
+
+func (d *indexData) allContentBytes() int {
+	if len(d.boundaries) == 0 {
+		return 0
+	}
+	return int(d.boundaries[len(d.boundaries)-1] - d.boundaries[0])
+}
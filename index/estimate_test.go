@@ -0,0 +1,32 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestEstimateMatches(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "a.go", Content: []byte("needle needle needle")},
+		Document{Name: "b.go", Content: []byte("haystack")},
+	)
+	d := searcherForTest(t, b).(*indexData)
+
+	needle := d.EstimateMatches(&query.Substring{Pattern: "needle"})
+	if needle == 0 {
+		t.Fatal("got no matches for needle")
+	}
+	if got := d.EstimateMatches(&query.Substring{Pattern: "missing"}); got != 0 {
+		t.Errorf("missing: got %d, want 0", got)
+	}
+	if got := d.EstimateMatches(query.NewAnd(&query.Substring{Pattern: "needle"}, &query.Substring{Pattern: "missing"})); got != 0 {
+		t.Errorf("and: got %d, want 0", got)
+	}
+	if got := d.EstimateMatches(query.NewOr(&query.Substring{Pattern: "needle"}, &query.Substring{Pattern: "haystack"})); got <= needle {
+		t.Errorf("or: got %d, want more than %d", got, needle)
+	}
+	if got, want := d.EstimateMatches(&query.Substring{Pattern: "ne"}), d.allContentBytes(); got != want {
+		t.Errorf("short pattern: got %d, want all %d content bytes", got, want)
+	}
+}
@@ -19,14 +19,18 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	enry_data "github.com/go-enry/go-enry/v2/data"
 	"github.com/grafana/regexp"
+	"github.com/opentracing/opentracing-go"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/licenses"
 	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/trace"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -82,8 +86,22 @@ func (d *indexData) simplify(in query.Q) query.Q {
 			return d.simplifyMultiRepo(q, func(repo *zoekt.Repository) bool {
 				return r.Repos.Contains(repo.ID)
 			})
+		case *query.License:
+			return d.simplifyMultiRepo(q, func(repo *zoekt.Repository) bool {
+				return licenses.Matches(repo.License, r.License)
+			})
+		case *query.Commit:
+			if len(d.commits) == 0 {
+				return &query.Const{Value: false}
+			}
+		case *query.PathBoost:
+			// Only affects scoring, see Search.
+			return &query.Const{Value: true}
 		case *query.Language:
-			_, has := d.metaData.LanguageMap[r.Language]
+			lang, has := d.resolveLanguage(r.Language)
+			if has && lang != r.Language {
+				return &query.Language{Language: lang}
+			}
 			if !has && d.metaData.IndexFeatureVersion < 12 {
 				// For index files that haven't been re-indexed by go-enry,
 				// fall back to file-based matching and continue even if this
@@ -123,6 +141,25 @@ func (d *indexData) simplify(in query.Q) query.Q {
 	return query.Simplify(eval)
 }
 
+// prepareMatchTree builds the match tree Search evaluates for q, which must
+// be simplified for this shard. inspect, if non-nil, is called with the tree
+// before it is pruned. The returned tree is nil if q can't match in this
+// shard.
+func (d *indexData) prepareMatchTree(q query.Q, inspect func(matchTree)) (matchTree, error) {
+	q = query.Map(q, query.ExpandFileContent)
+
+	mt, err := d.newMatchTree(q, matchTreeOpt{})
+	if err != nil {
+		return nil, err
+	}
+
+	if inspect != nil {
+		inspect(mt)
+	}
+
+	return pruneMatchTree(mt)
+}
+
 func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (sr *zoekt.SearchResult, err error) {
 	timer := newTimer()
 
@@ -130,6 +167,11 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 	opts = &copyOpts
 	opts.SetDefaults()
 
+	if pq, boosts := query.ExtractPathBoosts(q); len(boosts) > 0 {
+		q = pq
+		opts.PathBoosts = append(slices.Clip(opts.PathBoosts), boosts...)
+	}
+
 	var res zoekt.SearchResult
 	if len(d.fileNameIndex) == 0 {
 		return &res, nil
@@ -142,27 +184,44 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 	default:
 	}
 
+	span, ctx := trace.StartSpanFromContext(ctx, "indexData.Search")
+	span.SetTag("shard", d.file.Name())
+	if len(d.repoMetaData) == 1 {
+		span.SetTag("repo", d.repoMetaData[0].Name)
+	} else {
+		span.SetTag("repos", len(d.repoMetaData))
+	}
+	defer func() {
+		if sr != nil {
+			span.SetTag("files", len(sr.Files))
+			span.SetTag("matches", sr.Stats.MatchCount)
+		}
+		if err != nil {
+			span.SetTag("error", true)
+			span.LogKV("error", err.Error())
+		}
+		span.Finish()
+	}()
+
 	q = d.simplify(q)
 	if c, ok := q.(*query.Const); ok && !c.Value {
 		return &res, nil
 	}
 
+	// Commit documents only match queries which ask for them.
+	skipCommits := len(d.commits) > 0 && !hasCommitQuery(q)
+
 	if opts.EstimateDocCount {
 		res.Stats.ShardFilesConsidered = len(d.fileBranchMasks)
 		return &res, nil
 	}
 
-	q = query.Map(q, query.ExpandFileContent)
-
-	mt, err := d.newMatchTree(q, matchTreeOpt{})
-	if err != nil {
-		return nil, err
-	}
-
-	// Capture the costs of construction before pruning
-	updateMatchTreeStats(mt, &res.Stats)
-
-	mt, err = pruneMatchTree(mt)
+	mtSpan, _ := trace.StartSpanFromContext(ctx, "matchTreeConstruction")
+	mt, err := d.prepareMatchTree(q, func(mt matchTree) {
+		// Capture the costs of construction before pruning
+		updateMatchTreeStats(mt, &res.Stats)
+	})
+	mtSpan.Finish()
 	if err != nil {
 		return nil, err
 	}
@@ -186,6 +245,12 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 		repoMatchCount int
 	)
 
+	// The number of matching files per repository for MaxFilesPerRepo.
+	var repoFileCount []int
+	if opts.MaxFilesPerRepo > 0 {
+		repoFileCount = make([]int, len(d.repoMetaData))
+	}
+
 	docCount := uint32(len(d.fileBranchMasks))
 	lastDoc := int(-1)
 
@@ -195,6 +260,12 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOpt
 	// term frequency per file index
 	var tfs []termFrequency
 
+	// The stages of the document loop are interleaved, so instead of a span
+	// per document we record the total time spent in each stage.
+	searchSpan, _ := trace.StartSpanFromContext(ctx, "matchTreeSearch")
+	stages := stageTimer{enabled: trace.IsRecording(searchSpan)}
+	stages.start()
+
 nextFileMatch:
 	for {
 		canceled := false
@@ -209,12 +280,19 @@ nextFileMatch:
 			nextDoc = uint32(lastDoc + 1)
 		}
 
+		// Only the first document is a candidate of the match tree, the
+		// documents after it are skipped without consulting the match tree.
+		candidate := nextDoc
+
 		for ; nextDoc < docCount; nextDoc++ {
 			repoID := d.repos[nextDoc]
 			repoMetadata := &d.repoMetaData[repoID]
 
 			// Skip tombstoned repositories
 			if repoMetadata.Tombstone {
+				if nextDoc == candidate {
+					res.Stats.FilesSkippedTombstoned++
+				}
 				continue
 			}
 
@@ -224,9 +302,16 @@ nextFileMatch:
 				continue
 			}
 
+			if skipCommits && d.isCommitDoc(nextDoc) {
+				continue
+			}
+
 			// Skip documents that are tombstoned
 			if len(repoMetadata.FileTombstones) > 0 {
-				if _, tombstoned := repoMetadata.FileTombstones[string(d.fileName(nextDoc))]; tombstoned {
+				if isTombstoned(repoMetadata.FileTombstones, string(d.fileName(nextDoc))) {
+					if nextDoc == candidate {
+						res.Stats.FilesSkippedTombstoned++
+					}
 					continue
 				}
 			}
@@ -239,9 +324,17 @@ nextFileMatch:
 				}
 			}
 
+			// Skip documents of repositories with MaxFilesPerRepo matching
+			// files.
+			if repoFileCount != nil && repoFileCount[repoID] >= opts.MaxFilesPerRepo {
+				res.Stats.FilesSkipped++
+				continue
+			}
+
 			break
 		}
 
+		stages.stop(&stages.postings)
 		if nextDoc >= docCount {
 			break
 		}
@@ -260,6 +353,7 @@ nextFileMatch:
 		}
 
 		res.Stats.FilesConsidered++
+		d.updateSkippedStats(nextDoc, &res.Stats)
 		mt.prepare(nextDoc)
 
 		cp.setDocument(nextDoc)
@@ -278,6 +372,7 @@ nextFileMatch:
 				// could short-circuit now, but we want to run higher costs to
 				// potentially find higher ranked matches.
 			case matchesNone:
+				stages.stop(&stages.evaluation)
 				continue nextFileMatch
 			}
 		}
@@ -287,8 +382,14 @@ nextFileMatch:
 			RepositoryID:       md.ID,
 			RepositoryPriority: md.GetPriority(),
 			FileName:           string(d.fileName(nextDoc)),
-			Checksum:           d.getChecksum(nextDoc),
 			Language:           d.languageMap[d.getLanguage(nextDoc)],
+			LastModified:       d.modTime(nextDoc),
+			IndexTime:          d.metaData.IndexTime,
+			Truncated:          d.isTruncatedFile(nextDoc),
+			Generated:          d.isGeneratedFile(nextDoc),
+		}
+		if opts.Fields.Has(zoekt.FieldChecksum) || opts.GroupByBranch || opts.DedupByChecksum {
+			fileMatch.Checksum = d.getChecksum(nextDoc)
 		}
 
 		if s := d.subRepos[nextDoc]; s > 0 {
@@ -301,11 +402,13 @@ nextFileMatch:
 			fileMatch.SubRepositoryName = sr.Name
 			if idx := d.branchIndex(nextDoc); idx >= 0 {
 				fileMatch.Version = sr.Branches[idx].Version
+				fileMatch.CommitDate = sr.Branches[idx].CommitDate
 			}
 		} else {
 			idx := d.branchIndex(nextDoc)
 			if idx >= 0 {
 				fileMatch.Version = md.Branches[idx].Version
+				fileMatch.CommitDate = md.Branches[idx].CommitDate
 			}
 		}
 
@@ -313,20 +416,39 @@ nextFileMatch:
 		// non-overlapping. gatherMatches respects this invariant and all later
 		// transformations respect this.
 		finalCands := d.gatherMatches(nextDoc, mt, known)
+		stages.stop(&stages.evaluation)
+
+		if opts.Fields.Has(zoekt.FieldStats) {
+			fileMatch.MatchCount = len(finalCands)
+			fileMatch.FileSize = int(cp.fileSize)
+			fileMatch.LineCount = cp.newlines().lineCount()
+		}
 
-		if opts.ChunkMatches {
+		// Drop the matches over MaxMatchesPerFile before we load the content
+		// around them.
+		if opts.MaxMatchesPerFile > 0 && len(finalCands) > opts.MaxMatchesPerFile {
+			finalCands = finalCands[:opts.MaxMatchesPerFile]
+		}
+
+		// omittedMatches counts the matches which aren't returned, so that
+		// they still count towards the limits on matches.
+		omittedMatches := 0
+		if !opts.Fields.Has(zoekt.FieldMatches) {
+			omittedMatches = len(finalCands)
+		} else if opts.ChunkMatches {
 			fileMatch.ChunkMatches = cp.fillChunkMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts)
 		} else {
 			fileMatch.LineMatches = cp.fillMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts)
 		}
+		stages.stop(&stages.content)
 
 		var tf map[string]int
 		if opts.UseBM25Scoring {
 			// For BM25 scoring, the calculation of the score is split in two parts. Here we
 			// calculate the term frequencies for the current document and update the
-			// document frequencies. Since we don't store document frequencies in the index,
-			// we have to defer the calculation of the final BM25 score to after the whole
-			// shard has been processed.
+			// document frequencies. Since shards don't necessarily store document
+			// frequencies, we have to defer the calculation of the final BM25 score to
+			// after the whole shard has been processed.
 			tf = cp.calculateTermFrequency(finalCands, df)
 		} else {
 			// Use the standard, non-experimental scoring method by default
@@ -336,8 +458,10 @@ nextFileMatch:
 		fileMatch.Branches = d.gatherBranches(nextDoc, mt, known)
 		sortMatchesByScore(fileMatch.LineMatches)
 		sortChunkMatchesByScore(fileMatch.ChunkMatches)
+		stages.stop(&stages.scoring)
 		if opts.Whole {
 			fileMatch.Content = cp.data(false)
+			stages.stop(&stages.content)
 		}
 
 		matchedChunkRanges := 0
@@ -347,6 +471,7 @@ nextFileMatch:
 
 		repoMatchCount += len(fileMatch.LineMatches)
 		repoMatchCount += matchedChunkRanges
+		repoMatchCount += omittedMatches
 
 		if opts.UseBM25Scoring {
 			// Invariant: tfs[i] belongs to res.Files[i]
@@ -356,18 +481,34 @@ nextFileMatch:
 			})
 		}
 		res.Files = append(res.Files, fileMatch)
+		if repoFileCount != nil {
+			repoFileCount[d.repos[nextDoc]]++
+		}
 
 		res.Stats.MatchCount += len(fileMatch.LineMatches)
 		res.Stats.MatchCount += matchedChunkRanges
+		res.Stats.MatchCount += omittedMatches
 		res.Stats.FileCount++
 	}
 
-	// Calculate BM25 score for all file matches in the shard. We assume that we
-	// have seen all documents containing any of the terms in the query so that df
-	// correctly reflects the document frequencies. This is true, for example, if
-	// all terms in the query are ORed together.
+	// Calculate BM25 score for all file matches in the shard. Unless the shard
+	// stores document frequencies, we assume that we have seen all documents
+	// containing any of the terms in the query so that df correctly reflects the
+	// document frequencies. This is true, for example, if all terms in the query
+	// are ORed together.
+	stages.finish(searchSpan, &res.Stats)
+
 	if opts.UseBM25Scoring {
+		bm25Span, _ := trace.StartSpanFromContext(ctx, "scoreFilesUsingBM25")
 		d.scoreFilesUsingBM25(res.Files, tfs, df, opts)
+		bm25Span.Finish()
+	}
+
+	if opts.GroupByBranch {
+		res.Files = GroupByBranch(res.Files)
+	}
+	if opts.DedupByChecksum {
+		res.Files = DedupByChecksum(res.Files)
 	}
 
 	for _, md := range d.repoMetaData {
@@ -380,6 +521,7 @@ nextFileMatch:
 
 	// Update stats based on work done during document search.
 	updateMatchTreeStats(mt, &res.Stats)
+	d.hints.searched(res.Stats.FilesLoaded, int(d.numDocs()))
 
 	res.Stats.MatchTreeSearch = timer.Elapsed()
 
@@ -420,6 +562,12 @@ func (d *indexData) gatherMatches(nextDoc uint32, mt matchTree, known map[matchT
 		if smt, ok := mt.(*symbolRegexpMatchTree); ok {
 			cands = append(cands, setScoreWeight(scoreWeight, smt.found)...)
 		}
+		if fzt, ok := mt.(*fuzzyMatchTree); ok {
+			cands = append(cands, setScoreWeight(scoreWeight, fzt.found)...)
+		}
+		if st, ok := mt.(*structuralMatchTree); ok {
+			cands = append(cands, setScoreWeight(scoreWeight, st.found)...)
+		}
 	})
 
 	// If we found no candidate matches at all, assume there must have been a match on filename.
@@ -723,3 +871,51 @@ func (t *timer) Elapsed() time.Duration {
 	t.last = now
 	return d
 }
+
+// stageTimer accumulates the time spent in each stage of the document loop of
+// Search. It only reads the clock if enabled, so it costs nothing unless the
+// search is traced.
+type stageTimer struct {
+	enabled bool
+	last    time.Time
+
+	// postings is the time spent advancing the match tree to the next
+	// candidate document.
+	postings time.Duration
+	// evaluation is the time spent deciding whether a candidate matches and
+	// gathering its matches, including content loaded for verification.
+	evaluation time.Duration
+	// content is the time spent loading content for line and chunk matches.
+	content time.Duration
+	// scoring is the time spent scoring and sorting matches.
+	scoring time.Duration
+}
+
+func (t *stageTimer) start() {
+	if t.enabled {
+		t.last = time.Now()
+	}
+}
+
+// stop adds the time since the last call to start or stop to the stage d.
+func (t *stageTimer) stop(d *time.Duration) {
+	if !t.enabled {
+		return
+	}
+	now := time.Now()
+	*d += now.Sub(t.last)
+	t.last = now
+}
+
+// finish records the stages on span and finishes it.
+func (t *stageTimer) finish(span opentracing.Span, stats *zoekt.Stats) {
+	if t.enabled {
+		span.SetTag("postings", t.postings.String())
+		span.SetTag("evaluation", t.evaluation.String())
+		span.SetTag("content", t.content.String())
+		span.SetTag("scoring", t.scoring.String())
+		span.SetTag("filesConsidered", stats.FilesConsidered)
+		span.SetTag("contentBytesLoaded", stats.ContentBytesLoaded)
+	}
+	span.Finish()
+}
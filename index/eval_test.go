@@ -15,6 +15,7 @@
 package index
 
 import (
+	"bytes"
 	"context"
 	"hash/fnv"
 	"reflect"
@@ -26,7 +27,10 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/grafana/regexp"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/trace"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -175,7 +179,8 @@ func TestSearch_ShardRepoMaxMatchCountOpt(t *testing.T) {
 
 	t.Run("stats", func(t *testing.T) {
 		got, want := sr.Stats, zoekt.Stats{
-			ContentBytesLoaded: 0,
+			// The newlines of the matching files, for their line counts.
+			ContentBytesLoaded: 2,
 			FileCount:          2,
 			FilesConsidered:    2,
 			FilesSkipped:       2,
@@ -188,6 +193,125 @@ func TestSearch_ShardRepoMaxMatchCountOpt(t *testing.T) {
 	})
 }
 
+func TestSearch_MaxFilesPerRepoOpt(t *testing.T) {
+	cs := compoundReposShard(t, "foo", "bar")
+
+	sr, err := cs.Search(context.Background(), &query.Substring{Pattern: "content"}, &zoekt.SearchOptions{MaxFilesPerRepo: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filenames []string
+	for _, r := range sr.Files {
+		filenames = append(filenames, r.FileName)
+	}
+	if diff := cmp.Diff([]string{"foo.txt", "bar.txt"}, filenames); diff != "" {
+		t.Errorf("mismatch (-want, +got): %s", diff)
+	}
+	if sr.Stats.FilesSkipped != 2 {
+		t.Errorf("got %d files skipped, want 2", sr.Stats.FilesSkipped)
+	}
+}
+
+func TestSearch_MaxMatchesPerFileOpt(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "generated.go", Content: bytes.Repeat([]byte("needle\n"), 1000)},
+		Document{Name: "small.go", Content: []byte("needle\n")},
+	)
+	q := &query.Substring{Pattern: "needle", Content: true}
+
+	for _, chunks := range []bool{false, true} {
+		sr := searchForTest(t, b, q, zoekt.SearchOptions{MaxMatchesPerFile: 3, ChunkMatches: chunks})
+		if len(sr.Files) != 2 {
+			t.Fatalf("got %d files, want 2", len(sr.Files))
+		}
+		got := map[string]int{}
+		for _, f := range sr.Files {
+			got[f.FileName] = len(f.LineMatches)
+			for _, cm := range f.ChunkMatches {
+				got[f.FileName] += len(cm.Ranges)
+			}
+		}
+		if diff := cmp.Diff(map[string]int{"generated.go": 3, "small.go": 1}, got); diff != "" {
+			t.Errorf("chunks=%t: matches per file mismatch (-want, +got): %s", chunks, diff)
+		}
+		if sr.Stats.MatchCount != 4 {
+			t.Errorf("chunks=%t: got match count %d, want 4", chunks, sr.Stats.MatchCount)
+		}
+	}
+}
+
+func TestSearch_FileMatchStats(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "generated.go", Content: bytes.Repeat([]byte("needle\n"), 1000)},
+		Document{Name: "small.go", Content: []byte("needle needle\nno trailing newline")},
+	)
+	q := &query.Substring{Pattern: "needle", Content: true}
+
+	type stats struct{ matches, lines, size int }
+	want := map[string]stats{
+		"generated.go": {matches: 1000, lines: 1000, size: 7000},
+		"small.go":     {matches: 2, lines: 2, size: 33},
+	}
+	for _, chunks := range []bool{false, true} {
+		sr := searchForTest(t, b, q, zoekt.SearchOptions{MaxMatchesPerFile: 3, ChunkMatches: chunks})
+		got := map[string]stats{}
+		for _, f := range sr.Files {
+			got[f.FileName] = stats{matches: f.MatchCount, lines: f.LineCount, size: f.FileSize}
+		}
+		if diff := cmp.Diff(want, got, cmp.AllowUnexported(stats{})); diff != "" {
+			t.Errorf("chunks=%t: file stats mismatch (-want, +got): %s", chunks, diff)
+		}
+	}
+}
+
+func TestSearch_Fields(t *testing.T) {
+	s := searcherForTest(t, testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "a.go", Content: []byte("needle\nhay\nneedle\n")},
+		Document{Name: "needle.go", Content: []byte("hay\n")},
+	))
+	// searchForTest clears the checksums.
+	search := func(q query.Q, opts zoekt.SearchOptions) *zoekt.SearchResult {
+		sr, err := s.Search(context.Background(), q, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sr
+	}
+
+	for _, chunks := range []bool{false, true} {
+		sr := search(&query.Substring{Pattern: "needle", Content: true}, zoekt.SearchOptions{
+			ChunkMatches: chunks,
+			Fields:       zoekt.FieldFileName,
+		})
+		if len(sr.Files) != 1 {
+			t.Fatalf("chunks=%t: got %d files, want 1", chunks, len(sr.Files))
+		}
+		f := sr.Files[0]
+		if f.LineMatches != nil || f.ChunkMatches != nil || f.Checksum != nil || f.MatchCount != 0 || f.LineCount != 0 {
+			t.Errorf("chunks=%t: got omitted fields in %+v", chunks, f)
+		}
+		if sr.Stats.MatchCount != 2 {
+			t.Errorf("chunks=%t: got match count %d, want 2", chunks, sr.Stats.MatchCount)
+		}
+	}
+
+	sr := search(&query.Substring{Pattern: "needle", Content: true}, zoekt.SearchOptions{
+		Fields: zoekt.FieldChecksum | zoekt.FieldStats,
+	})
+	f := sr.Files[0]
+	if f.LineMatches != nil || f.Checksum == nil || f.MatchCount != 2 || f.LineCount != 3 {
+		t.Errorf("got %+v, want a checksum and stats without matches", f)
+	}
+
+	// Matching file names doesn't need the contents of the files at all.
+	sr = search(&query.Substring{Pattern: "needle", FileName: true}, zoekt.SearchOptions{
+		Fields: zoekt.FieldFileName,
+	})
+	if len(sr.Files) != 1 || sr.Stats.ContentBytesLoaded != 0 || sr.Stats.FilesLoaded != 0 {
+		t.Errorf("got %d files with stats %+v, want 1 file without loading content", len(sr.Files), sr.Stats)
+	}
+}
+
 func compoundReposShard(t *testing.T, names ...string) *indexData {
 	t.Helper()
 
@@ -377,11 +501,11 @@ func TestGatherBranches(t *testing.T) {
 	content := []byte("dummy")
 	b := testShardBuilder(t, &zoekt.Repository{
 		Branches: []zoekt.RepositoryBranch{
-			{"foo", "v1"},
-			{"foo-2", "v1"},
-			{"main", "v1"},
-			{"bar", "v1"},
-			{"quz", "v1"},
+			{Name: "foo", Version: "v1"},
+			{Name: "foo-2", Version: "v1"},
+			{Name: "main", Version: "v1"},
+			{Name: "bar", Version: "v1"},
+			{Name: "quz", Version: "v1"},
 		},
 	},
 		Document{Name: "f1", Content: content, Branches: []string{"foo", "bar", "quz"}},
@@ -417,3 +541,61 @@ func TestGatherBranches(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchSpans(t *testing.T) {
+	tracer := mocktracer.New()
+	old := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() { opentracing.SetGlobalTracer(old) })
+
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "a.go", Content: []byte("needle haystack")},
+		Document{Name: "b.go", Content: []byte("haystack")},
+	)
+	s := searcherForTest(t, b)
+
+	// Without opentracing enabled for the request we don't create spans.
+	if _, err := s.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tracer.FinishedSpans(); len(got) != 0 {
+		t.Fatalf("got %d spans, want none", len(got))
+	}
+
+	ctx := trace.WithOpenTracingEnabled(context.Background(), true)
+	if _, err := s.Search(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{UseBM25Scoring: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]*mocktracer.MockSpan{}
+	var names []string
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+		names = append(names, span.OperationName)
+	}
+	want := []string{"matchTreeConstruction", "matchTreeSearch", "scoreFilesUsingBM25", "indexData.Search"}
+	if d := cmp.Diff(want, names); d != "" {
+		t.Fatalf("spans mismatch (-want +got):\n%s", d)
+	}
+
+	root := spans["indexData.Search"]
+	for _, name := range want[:3] {
+		if spans[name].ParentID != root.SpanContext.SpanID {
+			t.Errorf("%s is not a child of indexData.Search", name)
+		}
+	}
+	if got := root.Tag("shard"); got != "memseeker" {
+		t.Errorf("got shard tag %v, want memseeker", got)
+	}
+	if got := root.Tag("repo"); got != "repo" {
+		t.Errorf("got repo tag %v, want repo", got)
+	}
+	if got := root.Tag("files"); got != 1 {
+		t.Errorf("got files tag %v, want 1", got)
+	}
+	for _, tag := range []string{"postings", "evaluation", "content", "scoring"} {
+		if spans["matchTreeSearch"].Tag(tag) == nil {
+			t.Errorf("matchTreeSearch is missing tag %q", tag)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"regexp/syntax"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Explain explains how Search evaluates q in this shard: the query after
+// simplification, whether the shard is skipped, and the atoms of the match
+// tree with an estimate of their cost. It follows the steps of Search up to
+// the construction of the match tree.
+func (d *indexData) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	explanation := &zoekt.Explanation{Query: q.String()}
+
+	e := zoekt.ShardExplanation{
+		Shard: d.String(),
+	}
+	for _, md := range d.repoMetaData {
+		// 🚨 SECURITY: Only describe shards of repositories the tenant can
+		// access. The shard name contains the repository name.
+		if !md.Tombstone && tenant.HasAccess(ctx, md.TenantID) {
+			e.Repositories = append(e.Repositories, md.Name)
+		}
+	}
+	if len(e.Repositories) == 0 && tenant.EnforceTenant() {
+		return explanation, nil
+	}
+
+	explanation.Shards = []zoekt.ShardExplanation{e}
+	se := &explanation.Shards[0]
+
+	if len(d.fileNameIndex) == 0 {
+		se.Skipped, se.SkipReason = true, "the shard is empty"
+		return explanation, nil
+	}
+
+	q = d.simplify(q)
+	se.Query = q.String()
+	if c, ok := q.(*query.Const); ok && !c.Value {
+		se.Skipped, se.SkipReason = true, "the query simplifies to false for the repositories and branches of this shard"
+		return explanation, nil
+	}
+
+	mt, err := d.prepareMatchTree(q, func(mt matchTree) {
+		visitMatchTree(mt, func(mt matchTree) {
+			se.Atoms = append(se.Atoms, explainAtom(mt))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mt == nil {
+		se.Skipped, se.SkipReason = true, "an atom required to match has no matching ngrams in this shard"
+		return explanation, nil
+	}
+
+	se.ScansAllDocuments = scansAllDocuments(mt)
+	se.Cost = d.estimateCost(mt)
+
+	return explanation, nil
+}
+
+// EstimateCost estimates the cost of evaluating q in this shard before it is
+// searched, see zoekt.CostEstimator. It is the Cost of Explain.
+func (d *indexData) EstimateCost(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (int64, error) {
+	if len(d.fileNameIndex) == 0 {
+		return 0, nil
+	}
+
+	q = d.simplify(q)
+	if c, ok := q.(*query.Const); ok && !c.Value {
+		return 0, nil
+	}
+
+	mt, err := d.prepareMatchTree(q, nil)
+	if err != nil {
+		return 0, err
+	}
+	if mt == nil {
+		return 0, nil
+	}
+	return d.estimateCost(mt), nil
+}
+
+// estimateCost returns a rough estimate of the number of bytes read to
+// evaluate mt: the size of the posting lists of its ngram atoms, which is
+// proportional to the number of candidate documents, or the size of all
+// content if every document is scanned. Scans are weighted by the complexity
+// of the regular expressions run on every document.
+func (d *indexData) estimateCost(mt matchTree) int64 {
+	if scansAllDocuments(mt) {
+		return d.contentSize() * int64(1+regexpComplexity(mt))
+	}
+
+	var cost int64
+	visitMatchTree(mt, func(mt matchTree) {
+		if st, ok := mt.(*substrMatchTree); ok {
+			cost += substrPostingBytes(st)
+		}
+	})
+	return cost
+}
+
+// regexpComplexity returns the number of repetitions and alternations of the
+// most complex regular expression in mt. These make matching backtrack, so a
+// scan with /(a|b)*c+/ is more expensive than one with /abc/.
+func regexpComplexity(mt matchTree) int {
+	var n int
+	visitMatchTree(mt, func(mt matchTree) {
+		if rt, ok := mt.(*regexpMatchTree); ok {
+			n = max(n, countRepetitions(rt.origRegexp))
+		}
+	})
+	return n
+}
+
+func countRepetitions(r *syntax.Regexp) int {
+	if r == nil {
+		return 0
+	}
+	var n int
+	switch r.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat, syntax.OpAlternate:
+		n = 1
+	}
+	for _, sub := range r.Sub {
+		n += countRepetitions(sub)
+	}
+	return n
+}
+
+func explainAtom(mt matchTree) zoekt.AtomExplanation {
+	switch t := mt.(type) {
+	case *substrMatchTree:
+		a := zoekt.AtomExplanation{Atom: t.query.String(), Method: "ngram"}
+		if it, ok := unwrapNgramResults(t.matchIterator).(*noMatchTree); ok {
+			a.Method = fmt.Sprintf("ngram, no matches (%s)", it.Why)
+		}
+		a.PostingBytes = substrPostingBytes(t)
+		return a
+	case *regexpMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "regexp, evaluated on every candidate document"}
+	case *wordMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "word, evaluated on every candidate document"}
+	case *fuzzyMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "fuzzy, evaluated on every candidate file name"}
+	case *structuralMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "structural, evaluated on every candidate document"}
+	case *docMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "document metadata"}
+	case *docListMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: t.reason}
+	case *branchQueryMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "branch masks"}
+	case *bruteForceMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "matches every document"}
+	case *noMatchTree:
+		return zoekt.AtomExplanation{Atom: t.String(), Method: "matches no document"}
+	default:
+		return zoekt.AtomExplanation{Atom: fmt.Sprintf("%v", mt), Method: fmt.Sprintf("%T", mt)}
+	}
+}
+
+func unwrapNgramResults(it matchIterator) matchIterator {
+	if res, ok := it.(*ngramIterationResults); ok {
+		return res.matchIterator
+	}
+	return it
+}
+
+// substrPostingBytes returns the size of the posting lists read by the ngram
+// iterator of t.
+func substrPostingBytes(t *substrMatchTree) int64 {
+	if it, ok := unwrapNgramResults(t.matchIterator).(*ngramDocIterator); ok {
+		return postingBytes(it.iter)
+	}
+	return 0
+}
+
+// postingBytes returns the size of the posting lists read by it.
+func postingBytes(it hitIterator) int64 {
+	switch it := it.(type) {
+	case *distanceHitIterator:
+		return postingBytes(it.i1) + postingBytes(it.i2)
+	case *mergingIterator:
+		var n int64
+		for _, j := range it.iters {
+			n += postingBytes(j)
+		}
+		return n
+	case *compressedPostingIterator:
+		return int64(it.indexBytesLoaded + len(it.blob))
+	case *inMemoryIterator:
+		return int64(4 * len(it.postings))
+	default:
+		return 0
+	}
+}
+
+// scansAllDocuments reports whether iterating mt visits every document, ie.
+// no atom narrows down the candidates with the ngram index.
+func scansAllDocuments(mt matchTree) bool {
+	switch t := mt.(type) {
+	case *andMatchTree:
+		for _, ch := range t.children {
+			if !scansAllDocuments(ch) {
+				return false
+			}
+		}
+		return true
+	case *andLineMatchTree:
+		return scansAllDocuments(&t.andMatchTree)
+	case *orMatchTree:
+		for _, ch := range t.children {
+			if scansAllDocuments(ch) {
+				return true
+			}
+		}
+		return false
+	case *noVisitMatchTree:
+		return scansAllDocuments(t.matchTree)
+	case *fileNameMatchTree:
+		return scansAllDocuments(t.child)
+	case *boostMatchTree:
+		return scansAllDocuments(t.child)
+	case *symbolSubstrMatchTree:
+		return scansAllDocuments(t.substrMatchTree)
+	case *symbolRegexpMatchTree:
+		return scansAllDocuments(t.matchTree)
+	case *subTokenMatchTree:
+		return scansAllDocuments(t.child)
+	case *substrMatchTree, *docListMatchTree, *noMatchTree:
+		return false
+	default:
+		// not, regexp, word, doc, branch and brute force trees visit every
+		// document.
+		return true
+	}
+}
+
+// contentSize returns the size of the contents of all documents in bytes.
+func (d *indexData) contentSize() int64 {
+	if len(d.boundaries) == 0 {
+		return 0
+	}
+	return int64(d.boundaries[len(d.boundaries)-1] - d.boundaries[0])
+}
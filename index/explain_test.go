@@ -0,0 +1,44 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestEstimateCost(t *testing.T) {
+	b := testShardBuilder(t, nil,
+		Document{Name: "f1", Content: []byte("needle in a haystack")},
+		Document{Name: "f2", Content: []byte("another haystack")},
+	)
+	d := searcherForTest(t, b).(*indexData)
+
+	cost := func(q query.Q) int64 {
+		t.Helper()
+		c, err := d.EstimateCost(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	needle := cost(&query.Substring{Pattern: "needle", Content: true})
+	haystack := cost(&query.Substring{Pattern: "haystack", Content: true})
+	scan := cost(&query.Regexp{Regexp: mustParseRE("."), Content: true})
+	complexScan := cost(&query.Regexp{Regexp: mustParseRE("(a|b)*c+"), Content: true})
+
+	if needle == 0 || haystack <= needle {
+		t.Errorf("got cost %d for needle and %d for haystack, want more for the more frequent substring", needle, haystack)
+	}
+	if scan != d.contentSize() {
+		t.Errorf("got cost %d for a scan, want the content size %d", scan, d.contentSize())
+	}
+	if complexScan <= scan {
+		t.Errorf("got cost %d for a complex regexp, want more than %d", complexScan, scan)
+	}
+	if c := cost(&query.Substring{Pattern: "nomatch", Content: true}); c != 0 {
+		t.Errorf("got cost %d for a query without matching ngrams, want 0", c)
+	}
+}
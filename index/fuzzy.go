@@ -0,0 +1,254 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// The scores of fuzzyMatch follow fzf: every matched character is worth
+// fuzzyScoreMatch, plus a bonus if it starts a word, and gaps between
+// matched characters cost a penalty that grows with their length.
+const (
+	fuzzyScoreMatch        = 16
+	fuzzyScoreGapStart     = -3
+	fuzzyScoreGapExtension = -1
+
+	// fuzzyBonusBoundary is the bonus for a character at the start of the file
+	// name or after a separator like / - _ . or a space.
+	fuzzyBonusBoundary = 8
+	// fuzzyBonusCamel is the bonus for an upper case letter after a lower
+	// case one, or a digit after a non-digit.
+	fuzzyBonusCamel = 7
+	// fuzzyBonusConsecutive is the bonus for a character right after the
+	// previous matched one.
+	fuzzyBonusConsecutive = 4
+	// fuzzyBonusBasename is the bonus for a character in the last path
+	// component, which users have in mind when jumping to a file.
+	fuzzyBonusBasename = 2
+	// The bonus of the first character counts twice, so "zkw" prefers
+	// "zoekt-webserver" over "frozen-kwargs".
+	fuzzyBonusFirstCharMultiplier = 2
+)
+
+// fuzzyMatch finds the best alignment of pattern as a subsequence of name.
+// It returns the score of the alignment and the offsets in name of the
+// matched bytes, or ok false if name doesn't contain pattern. If
+// caseSensitive is false, pattern must be lower case and ASCII letters in
+// name are folded to lower case. Multi-byte characters of pattern must match
+// as a whole.
+//
+// Like fzf's second algorithm, this is a dynamic program over pattern and
+// name, which is cheap for the short strings of file names.
+func fuzzyMatch(name, pattern []byte, caseSensitive bool) (score int, positions []int, ok bool) {
+	m := len(pattern)
+	if m == 0 {
+		return 0, nil, true
+	}
+
+	at := func(j int) byte {
+		c := name[j]
+		if !caseSensitive && c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		return c
+	}
+
+	// Find the leftmost occurrence greedily. Its start is the first position
+	// the alignment can start at, and the last occurrence of the final byte
+	// is the last position it can end at.
+	start, i := -1, 0
+	for j := 0; j < len(name) && i < m; j++ {
+		if at(j) == pattern[i] {
+			if i == 0 {
+				start = j
+			}
+			i++
+		}
+	}
+	if i < m {
+		return 0, nil, false
+	}
+	end := len(name) - 1
+	for at(end) != pattern[m-1] {
+		end--
+	}
+
+	n := end - start + 1
+	basename := bytes.LastIndexByte(name, '/') + 1
+
+	const none = math.MinInt32
+	// h[i*n+j] is the best score of pattern[:i+1] with pattern[i] matched at
+	// start+j, and prev[i*n+j] the position of pattern[i-1] in that alignment.
+	h := make([]int32, m*n)
+	prev := make([]int32, m*n)
+	for i := 0; i < m; i++ {
+		// Continuation bytes of UTF-8 characters must follow the previous
+		// byte of the pattern directly.
+		continuation := pattern[i]&0xC0 == 0x80
+
+		// gap is the best score of pattern[:i] ending at or before j-2,
+		// including the penalty for the gap up to j, and gapPos its position.
+		gap, gapPos := int32(none), int32(-1)
+		for j := 0; j < n; j++ {
+			k := i*n + j
+			h[k] = none
+			if gap != none {
+				gap += fuzzyScoreGapExtension
+			}
+			if i > 0 && j >= 2 {
+				if p := h[k-n-2]; p != none && p+fuzzyScoreGapStart > gap {
+					gap, gapPos = p+fuzzyScoreGapStart, int32(j-2)
+				}
+			}
+			if at(start+j) != pattern[i] {
+				continue
+			}
+
+			s := int32(fuzzyScoreMatch + fuzzyBonus(name, start+j))
+			if start+j >= basename {
+				s += fuzzyBonusBasename
+			}
+
+			if i == 0 {
+				if continuation {
+					continue
+				}
+				h[k] = s + (fuzzyBonusFirstCharMultiplier-1)*int32(fuzzyBonus(name, start+j))
+				continue
+			}
+
+			best, bestPos := int32(none), int32(-1)
+			if j >= 1 && h[k-n-1] != none {
+				best, bestPos = h[k-n-1]+fuzzyBonusConsecutive, int32(j-1)
+			}
+			if !continuation && gap > best {
+				best, bestPos = gap, gapPos
+			}
+			if best == none {
+				continue
+			}
+			h[k], prev[k] = best+s, bestPos
+		}
+	}
+
+	last, j := int32(none), -1
+	for jj := 0; jj < n; jj++ {
+		if s := h[(m-1)*n+jj]; s > last {
+			last, j = s, jj
+		}
+	}
+	if j < 0 {
+		return 0, nil, false
+	}
+
+	positions = make([]int, m)
+	for i := m - 1; i >= 0; i-- {
+		positions[i] = start + j
+		j = int(prev[i*n+j])
+	}
+	return int(last), positions, true
+}
+
+// fuzzyBonus returns the bonus for matching name[j], which depends on the
+// character before it.
+func fuzzyBonus(name []byte, j int) int {
+	if j == 0 {
+		return fuzzyBonusBoundary
+	}
+	p, c := name[j-1], name[j]
+	switch {
+	case p == '/' || p == '-' || p == '_' || p == '.' || p == ' ':
+		return fuzzyBonusBoundary
+	case byteClass(p) == _classLowerChar && byteClass(c) == _classUpperChar:
+		return fuzzyBonusCamel
+	case byteClass(p) != _classDigit && byteClass(c) == _classDigit:
+		return fuzzyBonusCamel
+	}
+	return 0
+}
+
+// fuzzyMatchTree matches file names which contain its pattern as a
+// subsequence, see query.FilenameFuzzy. File names are in memory, so it is
+// cheap to evaluate on every document, but it can't use the ngram index to
+// skip documents.
+type fuzzyMatchTree struct {
+	pattern       []byte
+	caseSensitive bool
+
+	// perfect is the score of a file named pattern, which scales score to
+	// [0, 1].
+	perfect int
+
+	// mutable
+	evaluated bool
+	found     []*candidateMatch
+	// score is the score of the best alignment in the current document
+	// relative to perfect.
+	score float64
+
+	// nextDoc, prepare.
+	bruteForceMatchTree
+}
+
+func newFuzzyMatchTree(q *query.FilenameFuzzy) *fuzzyMatchTree {
+	pattern := []byte(q.Pattern)
+	if !q.CaseSensitive {
+		pattern = bytes.ToLower(pattern)
+	}
+	perfect, _, _ := fuzzyMatch(pattern, pattern, true)
+	return &fuzzyMatchTree{
+		pattern:       pattern,
+		caseSensitive: q.CaseSensitive,
+		perfect:       perfect,
+	}
+}
+
+func (t *fuzzyMatchTree) prepare(doc uint32) {
+	t.found = t.found[:0]
+	t.evaluated = false
+	t.score = 0
+	t.bruteForceMatchTree.prepare(doc)
+}
+
+func (t *fuzzyMatchTree) String() string {
+	return fmt.Sprintf("ffuzzy(%q)", t.pattern)
+}
+
+func (t *fuzzyMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if t.evaluated {
+		return matchesStateForSlice(t.found)
+	}
+
+	if cost < costMemory {
+		return matchesRequiresHigherCost
+	}
+
+	score, positions, ok := fuzzyMatch(cp.data(true), t.pattern, t.caseSensitive)
+	found := t.found[:0]
+	if ok {
+		// Highlight each run of adjacent matched bytes.
+		for i := 0; i < len(positions); {
+			j := i + 1
+			for j < len(positions) && positions[j] == positions[j-1]+1 {
+				j++
+			}
+			found = append(found, &candidateMatch{
+				byteOffset:  uint32(positions[i]),
+				byteMatchSz: uint32(j - i),
+				fileName:    true,
+				fuzzy:       true,
+			})
+			i = j
+		}
+		if t.perfect > 0 {
+			t.score = min(max(float64(score)/float64(t.perfect), 0), 1)
+		}
+	}
+	t.found = found
+	t.evaluated = true
+
+	return matchesStateForSlice(t.found)
+}
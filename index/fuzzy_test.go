@@ -0,0 +1,101 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, tc := range []struct {
+		name, pattern string
+		want          []int
+	}{
+		{"zoekt-webserver", "zkwbsrv", []int{0, 3, 6, 8, 9, 11, 12}},
+		// The b of web is preferred over the first b found greedily.
+		{"a/bar/webserver", "wbs", []int{6, 8, 9}},
+		{"cmd/zoekt/main.go", "main", []int{10, 11, 12, 13}},
+		{"MainGo", "mg", []int{0, 4}},
+		{"src/FooBar.java", "fb", []int{4, 7}},
+		{"héllo", "hé", []int{0, 1, 2}},
+		{"abc", "abcd", nil},
+		{"abc", "cb", nil},
+		// The bytes of é must be adjacent.
+		{"h\xc3x\xa9", "h\xc3\xa9", nil},
+	} {
+		_, got, ok := fuzzyMatch([]byte(tc.name), []byte(tc.pattern), false)
+		if ok != (tc.want != nil) || !cmp.Equal(got, tc.want) {
+			t.Errorf("fuzzyMatch(%q, %q): got %v, %v, want %v", tc.name, tc.pattern, got, ok, tc.want)
+		}
+	}
+
+	if _, _, ok := fuzzyMatch([]byte("Main.go"), []byte("main"), true); ok {
+		t.Error("case sensitive fuzzyMatch ignored case")
+	}
+}
+
+func TestFuzzyMatchScore(t *testing.T) {
+	score := func(name string) int {
+		s, _, ok := fuzzyMatch([]byte(name), []byte("zkwbsrv"), false)
+		if !ok {
+			t.Fatalf("%s doesn't match", name)
+		}
+		return s
+	}
+
+	// Each name matches the pattern worse than the one before.
+	names := []string{
+		"cmd/zoekt-webserver",
+		"cmd/zoekt-webserver/main.go",
+		"frozen/kiwi/bus/server.go",
+	}
+	for i := 1; i < len(names); i++ {
+		if a, b := score(names[i-1]), score(names[i]); a <= b {
+			t.Errorf("score(%s) = %d, want more than score(%s) = %d", names[i-1], a, names[i], b)
+		}
+	}
+}
+
+func TestSearchFilenameFuzzy(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "frozen/kiwi/bus/server.go", Content: []byte("package server\n")},
+		Document{Name: "cmd/zoekt-webserver/main.go", Content: []byte("package main\n")},
+		Document{Name: "cmd/zoekt-index/main.go", Content: []byte("package main\n")},
+		Document{Name: "cmd/zoekt-webserver.go", Content: []byte("package main\n")},
+	)
+	d := searcherForTest(t, b)
+
+	q, err := query.Parse("f~:zkwbsrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SortFiles(res.Files)
+
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	want := []string{"cmd/zoekt-webserver.go", "cmd/zoekt-webserver/main.go", "frozen/kiwi/bus/server.go"}
+	if !cmp.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// The runs of matched characters are highlighted.
+	var ranges []string
+	for _, m := range res.Files[0].LineMatches {
+		for _, f := range m.LineFragments {
+			ranges = append(ranges, string(m.Line[f.LineOffset:f.LineOffset+f.MatchLength]))
+		}
+	}
+	if want := []string{"z", "k", "w", "bs", "rv"}; !cmp.Equal(ranges, want) {
+		t.Errorf("got fragments %q, want %q", ranges, want)
+	}
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/go-enry/go-enry/v2"
+)
+
+// generatedMarkers are the comments which mark generated files, eg. the
+// "Code generated ... DO NOT EDIT." line of Go. They are only looked for in
+// the first generatedHeaderBytes of a file, so that code which checks for
+// them isn't flagged.
+var generatedMarkers = [][]byte{
+	[]byte("DO NOT EDIT"),
+	[]byte("@generated"),
+	[]byte("Code generated"),
+}
+
+const (
+	generatedHeaderBytes = 1024
+
+	// Files of at least minifiedMinBytes whose lines are longer than
+	// minifiedLineBytes on average are minified, eg. bundle.js.
+	minifiedMinBytes  = 4096
+	minifiedLineBytes = 300
+
+	// Files of at least minifiedMinBytes whose first entropySampleBytes
+	// have more than maxEntropy bits per byte and long lines are encoded
+	// data, eg. base64 blobs. Source code has about 5 bits per byte.
+	entropySampleBytes = 16 << 10
+	entropyLineBytes   = 60
+	maxEntropy         = 5.6
+)
+
+// IsGeneratedFile returns true if the file looks generated or minified: enry
+// recognizes it as generated, its header contains a marker like "DO NOT
+// EDIT", its lines are very long, or it looks like encoded data. Such files
+// are flagged like the ones of Document.Generated.
+func IsGeneratedFile(name string, content []byte) bool {
+	header := content[:min(len(content), generatedHeaderBytes)]
+	for _, m := range generatedMarkers {
+		if bytes.Contains(header, m) {
+			return true
+		}
+	}
+
+	if len(content) >= minifiedMinBytes {
+		lineBytes := len(content) / (bytes.Count(content, []byte{'\n'}) + 1)
+		if lineBytes > minifiedLineBytes {
+			return true
+		}
+		if lineBytes > entropyLineBytes && entropy(content[:min(len(content), entropySampleBytes)]) > maxEntropy {
+			return true
+		}
+	}
+
+	return enry.IsGenerated(name, content)
+}
+
+// entropy returns the Shannon entropy of the bytes of b in bits per byte.
+func entropy(b []byte) float64 {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	var h float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(b))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
@@ -0,0 +1,83 @@
+package index
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// base64Blob returns n bytes of base64-like data in lines of 76 bytes.
+func base64Blob(n int) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	var b strings.Builder
+	x := uint32(1)
+	for i := 1; i <= n; i++ {
+		x = x*1664525 + 1013904223
+		b.WriteByte(alphabet[x>>26])
+		if i%76 == 0 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+func TestIsGeneratedFile(t *testing.T) {
+	code := strings.Repeat("func main() {\n\tfmt.Println(\"hello world\")\n}\n", 200)
+	for _, tc := range []struct {
+		name, content string
+		want          bool
+	}{
+		{"main.go", code, false},
+		{"main.go", "// Code generated by protoc-gen-go. DO NOT EDIT.\n" + code, true},
+		{"gen.py", "# @generated by tool\n" + code, true},
+		// Markers after the header are code which checks for them.
+		{"lint.go", code + "if bytes.Contains(src, []byte(\"DO NOT EDIT\")) {}\n", false},
+		{"bundle.js", strings.Repeat("var a=function(b){return b+1};", 400), true},
+		{"blob.txt", base64Blob(8192), true},
+		{"small.js", "var a=1;", false},
+		{"empty.go", "", false},
+		// enry knows about generated file names.
+		{"search.js.map", "", true},
+	} {
+		if got := IsGeneratedFile(tc.name, []byte(tc.content)); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSearchGeneratedFiles(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "bundle.js", Content: []byte(strings.Repeat("needle();", 1000))},
+		Document{Name: "app.js", Content: []byte("needle();\n")},
+		Document{Name: "vendor.js", Content: []byte("needle();\n"), Generated: true},
+	)
+	d := searcherForTest(t, b)
+
+	search := func(q query.Q) map[string]bool {
+		res, err := d.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated := map[string]bool{}
+		for _, f := range res.Files {
+			generated[f.FileName] = f.Generated
+		}
+		return generated
+	}
+
+	needle := &query.Substring{Pattern: "needle"}
+	if d := cmp.Diff(map[string]bool{"bundle.js": true, "app.js": false, "vendor.js": true}, search(needle)); d != "" {
+		t.Errorf("needle: mismatch (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff(map[string]bool{"app.js": false}, search(query.NewAnd(needle, &query.Not{Child: &query.GeneratedFile{}}))); d != "" {
+		t.Errorf("needle generated:no: mismatch (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff(map[string]bool{"bundle.js": true, "vendor.js": true}, search(query.NewAnd(needle, &query.GeneratedFile{}))); d != "" {
+		t.Errorf("needle generated:only: mismatch (-want +got):\n%s", d)
+	}
+}
@@ -112,6 +112,22 @@ func (d *indexData) newDistanceTrigramIter(ng1, ng2 ngram, dist uint32, caseSens
 }
 
 func (d *indexData) trigramHitIterator(ng ngram, caseSensitive, fileName bool) (hitIterator, error) {
+	if !caseSensitive {
+		if sec, lookups, ok := d.foldedPostings(ng, fileName); ok {
+			d.hints.advise(sec, adviceWillNeed)
+			blob, err := d.readSectionBlob(sec)
+			if err != nil {
+				return nil, err
+			}
+			if len(blob) == 0 {
+				return &mergingIterator{ngramLookups: lookups}, nil
+			}
+			iter := newCompressedPostingIterator(blob, foldNgram(ng))
+			iter.ngramLookups = lookups
+			return iter, nil
+		}
+	}
+
 	variants := []ngram{ng}
 	if !caseSensitive {
 		variants = generateCaseNgrams(ng)
@@ -123,6 +139,7 @@ func (d *indexData) trigramHitIterator(ng ngram, caseSensitive, fileName bool) (
 	for _, v := range variants {
 		sec := ngrams.Get(v)
 		ngramLookups++
+		d.hints.advise(sec, adviceWillNeed)
 		blob, err := d.readSectionBlob(sec)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,129 @@
+package index
+
+import (
+	"regexp/syntax"
+	"sort"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// identifierIndex maps the symbol names of a shard to the documents defining
+// them. The names are stored case-preserving and sorted, so that looking up
+// an exact name is a binary search. Only case-sensitive searches for a whole
+// symbol name can use it, see exactSymbolName.
+type identifierIndex struct {
+	textStart uint32
+	textIndex []uint32
+
+	postingsStart uint32
+	postingsIndex []uint32
+}
+
+// present returns true if the shard was built with an identifier index.
+func (x *identifierIndex) present() bool {
+	return len(x.textIndex) > 0
+}
+
+// writeIdentifiers writes the identifier index of the symbols in docSections
+// of the documents with contents.
+func writeIdentifiers(w *writer, contents []*searchableString, docSections [][]DocumentSection, text, postings *compoundSection) {
+	docs := map[string][]uint32{}
+	for docID, secs := range docSections {
+		data := contents[docID].data
+		for _, sec := range secs {
+			if sec.Start >= sec.End || int(sec.End) > len(data) {
+				continue
+			}
+			name := string(data[sec.Start:sec.End])
+			if ids := docs[name]; len(ids) == 0 || ids[len(ids)-1] != uint32(docID) {
+				docs[name] = append(ids, uint32(docID))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(docs))
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text.start(w)
+	for _, name := range names {
+		text.addItem(w, []byte(name))
+	}
+	text.end(w)
+
+	postings.start(w)
+	for _, name := range names {
+		postings.addItem(w, toSizedDeltas(docs[name]))
+	}
+	postings.end(w)
+}
+
+// identifierDocs returns the documents defining a symbol called name, in
+// increasing order. The shard must have an identifier index.
+func (d *indexData) identifierDocs(name string) ([]uint32, error) {
+	x := &d.identifiers
+	n := len(x.textIndex) - 1
+
+	var err error
+	i := sort.Search(n, func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var blob []byte
+		blob, err = d.readSectionBlob(simpleSection{
+			off: x.textStart + x.textIndex[i],
+			sz:  x.textIndex[i+1] - x.textIndex[i],
+		})
+		return string(blob) >= name
+	})
+	if err != nil {
+		return nil, err
+	}
+	if i == n {
+		return nil, nil
+	}
+
+	blob, err := d.readSectionBlob(simpleSection{
+		off: x.textStart + x.textIndex[i],
+		sz:  x.textIndex[i+1] - x.textIndex[i],
+	})
+	if err != nil || string(blob) != name {
+		return nil, err
+	}
+
+	blob, err = d.readSectionBlob(simpleSection{
+		off: x.postingsStart + x.postingsIndex[i],
+		sz:  x.postingsIndex[i+1] - x.postingsIndex[i],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromSizedDeltas(blob, nil), nil
+}
+
+// exactSymbolName returns the symbol name q matches if q is a case-sensitive
+// regular expression matching a whole symbol name, like ^FooBar$.
+func exactSymbolName(q query.Q) (string, bool) {
+	re, ok := q.(*query.Regexp)
+	if !ok || !re.CaseSensitive || re.FileName {
+		return "", false
+	}
+
+	r := re.Regexp
+	if r.Op != syntax.OpConcat || len(r.Sub) != 3 {
+		return "", false
+	}
+	begin, lit, end := r.Sub[0], r.Sub[1], r.Sub[2]
+	if begin.Op != syntax.OpBeginText && begin.Op != syntax.OpBeginLine {
+		return "", false
+	}
+	if end.Op != syntax.OpEndText && end.Op != syntax.OpEndLine {
+		return "", false
+	}
+	if lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(lit.Rune), true
+}
@@ -0,0 +1,170 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var identifierDocs = []Document{
+	{
+		Name:            "a.go",
+		Content:         []byte("func FooBar() {}\nfunc Baz() {}\n"),
+		Symbols:         []DocumentSection{{5, 11}, {22, 25}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}, {Kind: "function"}},
+	},
+	{
+		Name:            "b.go",
+		Content:         []byte("// calls FooBar\nfunc fooBar() {}\n"),
+		Symbols:         []DocumentSection{{21, 27}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "function"}},
+	},
+	{
+		Name:            "c.go",
+		Content:         []byte("type FooBar struct{}\n"),
+		Symbols:         []DocumentSection{{5, 11}},
+		SymbolsMetaData: []*zoekt.Symbol{{Kind: "struct"}},
+	},
+	{Name: "d.txt", Content: []byte("FooBar\n")},
+}
+
+func TestIdentifierDocs(t *testing.T) {
+	b := testShardBuilder(t, nil, identifierDocs...)
+	b.IdentifierIndex = true
+	d := searcherForTest(t, b).(*indexData)
+
+	for name, want := range map[string][]uint32{
+		"FooBar": {0, 2},
+		"fooBar": {1},
+		"Baz":    {0},
+		"Foo":    nil,
+		"Zzz":    nil,
+		"":       nil,
+	} {
+		got, err := d.identifierDocs(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Errorf("identifierDocs(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	d = searcherForTest(t, testShardBuilder(t, nil, identifierDocs...)).(*indexData)
+	if d.identifiers.present() {
+		t.Errorf("got an identifier index in a shard built without one")
+	}
+}
+
+func TestExactSymbolName(t *testing.T) {
+	for q, want := range map[string]string{
+		"case:yes ^FooBar$": "FooBar",
+		"^FooBar$":          "FooBar",
+		"case:yes ^foo$":    "foo",
+		"^foobar$":          "",
+		"FooBar":            "",
+		"^FooBar":           "",
+		"^Foo.*Bar$":        "",
+		"case:yes f:^Foo$":  "",
+	} {
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := exactSymbolName(parsed)
+		if got != want {
+			t.Errorf("exactSymbolName(%s) = %q, want %q", parsed, got, want)
+		}
+	}
+}
+
+func TestSearch_IdentifierIndex(t *testing.T) {
+	search := func(index bool, q string) (*zoekt.SearchResult, matchTree) {
+		t.Helper()
+		b := testShardBuilder(t, nil, identifierDocs...)
+		b.IdentifierIndex = index
+		d := searcherForTest(t, b).(*indexData)
+
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt, err := d.newMatchTree(parsed, matchTreeOpt{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := d.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearScores(res)
+		return res, mt
+	}
+
+	for _, tc := range []struct {
+		q         string
+		wantFiles []string
+		indexed   bool
+	}{
+		{"sym:^FooBar$", []string{"a.go", "c.go"}, true},
+		{"sym:^fooBar$ case:yes", []string{"b.go"}, true},
+		{"sym:^Missing$", nil, true},
+		{"sym:^foobar$", []string{"a.go", "b.go", "c.go"}, false},
+		{"sym:FooBar", []string{"a.go", "c.go"}, false},
+	} {
+		t.Run(tc.q, func(t *testing.T) {
+			res, mt := search(true, tc.q)
+			want, _ := search(false, tc.q)
+
+			var files []string
+			for _, f := range res.Files {
+				files = append(files, f.FileName)
+			}
+			if !cmp.Equal(files, tc.wantFiles) {
+				t.Errorf("got files %v, want %v", files, tc.wantFiles)
+			}
+			if d := cmp.Diff(want.Files, res.Files, ignoreIndexTime); d != "" {
+				t.Errorf("results differ from the ones without an identifier index (-without +with):\n%s", d)
+			}
+
+			indexed := false
+			visitMatchTree(mt, func(mt matchTree) {
+				switch mt := mt.(type) {
+				case *docListMatchTree:
+					indexed = true
+				case *noMatchTree:
+					indexed = mt.Why == "identifier index"
+				}
+			})
+			if indexed != tc.indexed {
+				t.Errorf("got match tree %s, want identifier index %t", mt, tc.indexed)
+			}
+		})
+	}
+}
+
+func TestMerge_IdentifierIndex(t *testing.T) {
+	b1 := testShardBuilder(t, &zoekt.Repository{Name: "r1"}, identifierDocs[:2]...)
+	b1.IdentifierIndex = true
+	b2 := testShardBuilder(t, &zoekt.Repository{Name: "r2"}, identifierDocs[2:]...)
+
+	sb, err := merge(searcherForTest(t, b1).(*indexData), searcherForTest(t, b2).(*indexData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sb.IdentifierIndex {
+		t.Fatal("merged shard lost the identifier index")
+	}
+	d := searcherForTest(t, sb).(*indexData)
+	got, err := d.identifierDocs("FooBar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint32{0, 2}; !cmp.Equal(got, want) {
+		t.Errorf("identifierDocs(FooBar) = %v, want %v", got, want)
+	}
+}
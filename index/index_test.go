@@ -210,7 +210,10 @@ func TestNewlines(t *testing.T) {
 
 		matches := sres.Files
 		want := []zoekt.FileMatch{{
-			FileName: "filename",
+			FileName:   "filename",
+			MatchCount: 1,
+			LineCount:  3,
+			FileSize:   15,
 			LineMatches: []zoekt.LineMatch{{
 				LineFragments: []zoekt.LineFragmentMatch{{
 					Offset:      8,
@@ -224,7 +227,7 @@ func TestNewlines(t *testing.T) {
 			}},
 		}}
 
-		if diff := cmp.Diff(matches, want); diff != "" {
+		if diff := cmp.Diff(matches, want, ignoreIndexTime); diff != "" {
 			t.Fatal(diff)
 		}
 	})
@@ -234,7 +237,10 @@ func TestNewlines(t *testing.T) {
 
 		matches := sres.Files
 		want := []zoekt.FileMatch{{
-			FileName: "filename",
+			FileName:   "filename",
+			MatchCount: 1,
+			LineCount:  3,
+			FileSize:   15,
 			ChunkMatches: []zoekt.ChunkMatch{{
 				Content: []byte("line2\n"),
 				ContentStart: zoekt.Location{
@@ -249,12 +255,16 @@ func TestNewlines(t *testing.T) {
 			}},
 		}}
 
-		if diff := cmp.Diff(want, matches); diff != "" {
+		if diff := cmp.Diff(want, matches, ignoreIndexTime); diff != "" {
 			t.Fatal(diff)
 		}
 	})
 }
 
+// ignoreIndexTime ignores the time the shard was written at, which
+// testShardBuilder leaves to the writer.
+var ignoreIndexTime = cmpopts.IgnoreFields(zoekt.FileMatch{}, "IndexTime")
+
 // A result spanning multiple lines should have LineMatches that only cover
 // single lines.
 func TestQueryNewlines(t *testing.T) {
@@ -1207,9 +1217,9 @@ func TestDocumentOrder(t *testing.T) {
 func TestBranchMask(t *testing.T) {
 	b := testShardBuilder(t, &zoekt.Repository{
 		Branches: []zoekt.RepositoryBranch{
-			{"master", "v-master"},
-			{"stable", "v-stable"},
-			{"bonzai", "v-bonzai"},
+			{Name: "master", Version: "v-master"},
+			{Name: "stable", Version: "v-stable"},
+			{Name: "bonzai", Version: "v-bonzai"},
 		},
 	}, Document{Name: "f1", Content: []byte("needle"), Branches: []string{"master"}},
 		Document{Name: "f2", Content: []byte("needle"), Branches: []string{"stable", "master"}},
@@ -1262,7 +1272,8 @@ func TestBranchLimit(t *testing.T) {
 		for i := 0; i < limit; i++ {
 			s := fmt.Sprintf("b%d", i)
 			r.Branches = append(r.Branches, zoekt.RepositoryBranch{
-				s, "v-" + s,
+				Name:    s,
+				Version: "v-" + s,
 			})
 		}
 		_, err := NewShardBuilder(r)
@@ -1278,8 +1289,8 @@ func TestBranchReport(t *testing.T) {
 	branches := []string{"stable", "master"}
 	b := testShardBuilder(t, &zoekt.Repository{
 		Branches: []zoekt.RepositoryBranch{
-			{"stable", "vs"},
-			{"master", "vm"},
+			{Name: "stable", Version: "vs"},
+			{Name: "master", Version: "vm"},
 		},
 	},
 		Document{Name: "f2", Content: []byte("needle"), Branches: branches})
@@ -1316,8 +1327,8 @@ func TestBranchReport(t *testing.T) {
 func TestBranchVersions(t *testing.T) {
 	b := testShardBuilder(t, &zoekt.Repository{
 		Branches: []zoekt.RepositoryBranch{
-			{"stable", "v-stable"},
-			{"master", "v-master"},
+			{Name: "stable", Version: "v-stable"},
+			{Name: "master", Version: "v-master"},
 		},
 	}, Document{Name: "f2", Content: []byte("needle"), Branches: []string{"master"}})
 
@@ -1350,6 +1361,66 @@ func TestBranchVersions(t *testing.T) {
 	})
 }
 
+func TestGroupByBranch(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{
+		Branches: []zoekt.RepositoryBranch{
+			{Name: "main", Version: "v-main"},
+			{Name: "dev", Version: "v-dev"},
+			{Name: "release", Version: "v-release"},
+		},
+	},
+		Document{Name: "f1", Content: []byte("needle main"), Branches: []string{"main"}},
+		Document{Name: "f1", Content: []byte("needle dev"), Branches: []string{"dev"}},
+		Document{Name: "f1", Content: []byte("needle main"), Branches: []string{"release"}},
+		Document{Name: "f2", Content: []byte("needle"), Branches: []string{"main", "dev"}},
+	)
+	q := &query.Substring{Pattern: "needle"}
+
+	if sres := searchForTest(t, b, q); len(sres.Files) != 4 {
+		t.Fatalf("got %d files without grouping, want 4", len(sres.Files))
+	}
+
+	for _, opts := range []zoekt.SearchOptions{{GroupByBranch: true}, {GroupByBranch: true, ChunkMatches: true}} {
+		sres := searchForTest(t, b, q, opts)
+		files := map[string]zoekt.FileMatch{}
+		for _, f := range sres.Files {
+			files[f.FileName] = f
+		}
+		if len(sres.Files) != 2 || len(files) != 2 {
+			t.Fatalf("got %v, want one result for each of f1 and f2", sres.Files)
+		}
+
+		// f1 has two versions, since release has the same content as main.
+		f1 := files["f1"]
+		if d := cmp.Diff([]string{"dev", "main", "release"}, f1.Branches, cmpopts.SortSlices(func(a, b string) bool { return a < b })); d != "" {
+			t.Errorf("f1 branches mismatch (-want +got):\n%s", d)
+		}
+		got := map[string][]string{}
+		for _, bm := range f1.BranchMatches {
+			got[bm.Version] = bm.Branches
+			if len(bm.LineMatches)+len(bm.ChunkMatches) == 0 {
+				t.Errorf("version %s has no matches", bm.Version)
+			}
+		}
+		want := map[string][]string{
+			"v-main": {"main", "release"},
+			"v-dev":  {"dev"},
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("f1 versions mismatch (-want +got):\n%s", d)
+		}
+		if f1.Version != f1.BranchMatches[0].Version {
+			t.Errorf("got version %s, want the version of the first branch match %s", f1.Version, f1.BranchMatches[0].Version)
+		}
+
+		// f2 is the same on all branches.
+		f2 := files["f2"]
+		if len(f2.BranchMatches) != 0 || !cmp.Equal(f2.Branches, []string{"main", "dev"}) {
+			t.Errorf("got f2 with branches %v and %d branch matches, want [main dev] and none", f2.Branches, len(f2.BranchMatches))
+		}
+	}
+}
+
 func mustParseRE(s string) *syntax.Regexp {
 	r, err := syntax.Parse(s, syntax.Perl)
 	if err != nil {
@@ -1697,6 +1768,56 @@ func TestRegexpCaseFolding(t *testing.T) {
 	}
 }
 
+func TestRegexpMultiline(t *testing.T) {
+	content := []byte("bla\nfunc unmarshal(\n\tb []byte,\n) int {\n")
+	b := testShardBuilder(t, nil, Document{Name: "f1", Content: content})
+
+	t.Run("SingleLine", func(t *testing.T) {
+		res := searchForTest(t, b,
+			&query.Regexp{Regexp: mustParseRE("func.*int")},
+			chunkOpts,
+		)
+		if len(res.Files) != 0 {
+			t.Fatalf("got %v, want no matches", res.Files)
+		}
+	})
+
+	t.Run("ChunkMatches", func(t *testing.T) {
+		res := searchForTest(t, b,
+			&query.Regexp{Regexp: mustParseRE("func.*int"), Multiline: true},
+			chunkOpts,
+		)
+		if len(res.Files) != 1 || len(res.Files[0].ChunkMatches) != 1 {
+			t.Fatalf("got %v, want one chunk", res.Files)
+		}
+
+		got := res.Files[0].ChunkMatches[0].Ranges
+		want := []zoekt.Range{{
+			Start: zoekt.Location{ByteOffset: 4, LineNumber: 2, Column: 1},
+			End:   zoekt.Location{ByteOffset: 36, LineNumber: 4, Column: 6},
+		}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("unexpected ranges (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("LineMatches", func(t *testing.T) {
+		res := searchForTest(t, b,
+			&query.Regexp{Regexp: mustParseRE("func.*int"), Multiline: true})
+		if len(res.Files) != 1 {
+			t.Fatalf("got %v, want one file", res.Files)
+		}
+
+		var lines []int
+		for _, lm := range res.Files[0].LineMatches {
+			lines = append(lines, lm.LineNumber)
+		}
+		if want := []int{2, 3, 4}; !reflect.DeepEqual(lines, want) {
+			t.Fatalf("got lines %v, want %v", lines, want)
+		}
+	})
+}
+
 func TestCaseRegexp(t *testing.T) {
 	content := []byte("BLABLABLA")
 	b := testShardBuilder(t, nil,
@@ -2061,7 +2182,7 @@ func TestListRepos(t *testing.T) {
 				Repos:                      1,
 				Shards:                     1,
 				Documents:                  4,
-				IndexBytes:                 412,
+				IndexBytes:                 416,
 				ContentBytes:               68,
 				NewLinesCount:              4,
 				DefaultBranchNewLinesCount: 2,
@@ -2710,7 +2831,7 @@ func TestAndOrUnicode(t *testing.T) {
 
 	b := testShardBuilder(t, &zoekt.Repository{
 		Name:     "name",
-		Branches: []zoekt.RepositoryBranch{{"master", "master-version"}},
+		Branches: []zoekt.RepositoryBranch{{Name: "master", Version: "master-version"}},
 	}, Document{
 		Name:    "f2",
 		Content: []byte("orange\u2318apple"),
@@ -2833,6 +2954,28 @@ func TestLang(t *testing.T) {
 	})
 }
 
+func TestLangCaseInsensitive(t *testing.T) {
+	content := []byte("bla needle bla")
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "f1", Language: "MyLang", Content: content},
+		Document{Name: "f2", Language: "java", Content: content},
+	)
+
+	q := query.NewAnd(&query.Substring{Pattern: "needle"},
+		&query.Language{Language: "mylang"})
+	res := searchForTest(t, b, q)
+	if len(res.Files) != 1 || res.Files[0].FileName != "f1" {
+		t.Fatalf("got %v, want 1 result in f1", res.Files)
+	}
+
+	q = query.NewAnd(&query.Substring{Pattern: "needle"},
+		&query.Not{Child: &query.Language{Language: "mylang"}})
+	res = searchForTest(t, b, q)
+	if len(res.Files) != 1 || res.Files[0].FileName != "f2" {
+		t.Fatalf("got %v, want 1 result in f2", res.Files)
+	}
+}
+
 func TestLangShortcut(t *testing.T) {
 	content := []byte("bla needle bla")
 	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
@@ -3366,6 +3509,46 @@ func TestSkipInvalidContent(t *testing.T) {
 	}
 }
 
+func TestSkippedFilesStats(t *testing.T) {
+	b, err := NewShardBuilder(&zoekt.Repository{
+		Name:           "repo",
+		FileTombstones: map[string]struct{}{"f4": {}},
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+
+	for _, doc := range []Document{
+		{Name: "f1", Content: []byte("abc def")},
+		{Name: "f2", Content: []byte("abc def \x00 abc")},
+		{Name: "f3", SkipReason: "document size 100 larger than limit 10"},
+		{Name: "f4", Content: []byte("abc def")},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := searchForTest(t, b, &query.Substring{Pattern: "f", FileName: true})
+	if len(res.Files) != 3 {
+		t.Fatalf("got %v, want 3 results", res.Files)
+	}
+
+	want := zoekt.Stats{
+		FilesSkippedTombstoned: 1,
+		FilesSkippedSize:       1,
+		FilesSkippedBinary:     1,
+	}
+	got := zoekt.Stats{
+		FilesSkippedTombstoned: res.Stats.FilesSkippedTombstoned,
+		FilesSkippedSize:       res.Stats.FilesSkippedSize,
+		FilesSkippedBinary:     res.Stats.FilesSkippedBinary,
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", d)
+	}
+}
+
 func TestDocChecker(t *testing.T) {
 	docChecker := DocChecker{}
 
@@ -3983,3 +4166,48 @@ func BenchmarkScoreChunkMatches(b *testing.B) {
 		}
 	})
 }
+
+func TestSearchPathBoosts(t *testing.T) {
+	searcher := searcherForTest(t, testShardBuilder(t, nil,
+		Document{Name: "vendor/a.go", Content: []byte("needle")},
+		Document{Name: "b.go", Content: []byte("needle")},
+		Document{Name: "docs/c.md", Content: []byte("needle")},
+	))
+
+	search := func(q query.Q, opts *zoekt.SearchOptions) []string {
+		t.Helper()
+		res, err := searcher.Search(context.Background(), q, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SortFiles(res.Files)
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	needle := &query.Substring{Pattern: "needle"}
+	if got, want := search(needle, &zoekt.SearchOptions{}), []string{"vendor/a.go", "b.go", "docs/c.md"}; !cmp.Equal(got, want) {
+		t.Fatalf("without boosts: got %v, want %v", got, want)
+	}
+
+	boosts := []query.PathBoost{{Pattern: "vendor/", Boost: 0.1}, {Pattern: "docs/", Boost: 2}}
+	want := []string{"docs/c.md", "b.go", "vendor/a.go"}
+	if got := search(needle, &zoekt.SearchOptions{PathBoosts: boosts}); !cmp.Equal(got, want) {
+		t.Errorf("PathBoosts: got %v, want %v", got, want)
+	}
+	if got := search(needle, &zoekt.SearchOptions{PathBoosts: boosts, UseBM25Scoring: true}); !cmp.Equal(got, want) {
+		t.Errorf("PathBoosts with BM25: got %v, want %v", got, want)
+	}
+
+	// boost: atoms only change the ranking, not which files match.
+	q, err := query.Parse("boost:vendor/:0.1 boost:docs/ needle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := search(q, &zoekt.SearchOptions{}); !cmp.Equal(got, want) {
+		t.Errorf("boost: atoms: got %v, want %v", got, want)
+	}
+}
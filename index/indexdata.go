@@ -22,6 +22,7 @@ import (
 	"log"
 	"math/bits"
 	"slices"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/sourcegraph/zoekt"
@@ -36,14 +37,37 @@ type indexData struct {
 
 	file IndexFile
 
+	// hints is nil unless ZOEKT_SHARD_IO enables access hints.
+	hints *accessHints
+
 	contentNgrams btreeIndex
 
+	// foldedNgrams is empty if the shard was built without case-folded
+	// ngrams.
+	foldedNgrams btreeIndex
+
+	// ngramDocFreqs is the section with the document frequencies of the
+	// contentNgrams. It is empty if the shard was built without them.
+	ngramDocFreqs simpleSection
+
+	// identifiers is empty if the shard was built without an identifier
+	// index.
+	identifiers identifierIndex
+
+	// pathOrder is empty if the shard was built without a path prefix
+	// index, see pathPrefixDocs.
+	pathOrder []uint32
+
 	newlinesStart uint32
 	newlinesIndex []uint32
 
 	docSectionsStart uint32
 	docSectionsIndex []uint32
 
+	// subTokensIndex is empty if the shard was built without sub-tokens.
+	subTokensStart uint32
+	subTokensIndex []uint32
+
 	runeDocSections []DocumentSection
 
 	// rune offset=>byte offset mapping, relative to the start of the content corpus
@@ -53,6 +77,12 @@ type indexData struct {
 	boundariesStart uint32
 	boundaries      []uint32
 
+	// offsets of the zstd compressed content blocks relative to
+	// contentBlocksStart; includes end of last block. nil if the contents
+	// are not compressed, see writeCompressedContents.
+	contentBlocksStart uint32
+	contentBlocks      []uint32
+
 	// rune offsets for the file content boundaries
 	fileEndRunes []uint32
 
@@ -89,6 +119,18 @@ type indexData struct {
 	// languages for all the files.
 	languages []byte
 
+	// fileFlag bits for all the files. Empty for shards written before
+	// feature version 14.
+	fileFlags []byte
+
+	// commits maps commit documents to their metadata, see
+	// Document.Commit.
+	commits map[uint32]commitMetadata
+
+	// modTimes holds Document.ModTime in seconds since the epoch per
+	// document. Empty if the shard doesn't record them.
+	modTimes []int64
+
 	// inverse of LanguageMap in metaData
 	languageMap map[uint16]string
 
@@ -109,8 +151,12 @@ type symbolData struct {
 	// symKindContent is an enum of sym.Kind and sym.ParentKind
 	symKindContent []byte
 	symKindIndex   []uint32
-	// symMetadata is [4]uint32 0 Kind Parent ParentKind
+	// symMetadata is [4]uint32 Signature Kind Parent ParentKind
 	symMetaData []byte
+
+	// hasSignatures is true if the first symMetaData field is an index into
+	// symContent. Shards older than feature version 13 store 0 there.
+	hasSignatures bool
 }
 
 func uint32SliceAt(a []byte, n uint32) uint32 {
@@ -155,6 +201,10 @@ func (d *symbolData) data(i uint32) *zoekt.Symbol {
 	sym.Parent = string(d.parent(key))
 	key = uint32SliceAt(metadata, 3)
 	sym.ParentKind = string(d.kind(key))
+	if d.hasSignatures {
+		key = uint32SliceAt(metadata, 0)
+		sym.Signature = string(d.parent(key))
+	}
 	return sym
 }
 
@@ -163,6 +213,18 @@ func (d *indexData) getChecksum(idx uint32) []byte {
 	return d.checksums[start : start+crc64.Size]
 }
 
+// updateSkippedStats counts the document in stats if its content was not
+// indexed. The builder marks these documents with the languages "skipped"
+// (too large) and "binary".
+func (d *indexData) updateSkippedStats(idx uint32, stats *zoekt.Stats) {
+	switch d.languageMap[d.getLanguage(idx)] {
+	case "skipped":
+		stats.FilesSkippedSize++
+	case "binary":
+		stats.FilesSkippedBinary++
+	}
+}
+
 func (d *indexData) getLanguage(idx uint32) uint16 {
 	if d.metaData.IndexFeatureVersion < 12 {
 		// older zoekt files had 8-bit language entries
@@ -172,6 +234,47 @@ func (d *indexData) getLanguage(idx uint32) uint16 {
 	return uint16(d.languages[idx*2]) | uint16(d.languages[idx*2+1])<<8
 }
 
+// isTestFile returns true if the document looks like a test, see IsTestFile.
+func (d *indexData) isTestFile(idx uint32) bool {
+	if len(d.fileFlags) == 0 {
+		// Shards without the flags are classified by name.
+		return IsTestFile(string(d.fileName(idx)), d.languageMap[d.getLanguage(idx)])
+	}
+	return d.fileFlags[idx]&fileFlagTest != 0
+}
+
+// isCommitDoc returns true if the document was added with Document.Commit.
+func (d *indexData) isCommitDoc(idx uint32) bool {
+	return len(d.fileFlags) > 0 && d.fileFlags[idx]&fileFlagCommit != 0
+}
+
+// isTruncatedFile returns true if only a prefix of the document was
+// indexed, see Document.Truncated.
+func (d *indexData) isTruncatedFile(idx uint32) bool {
+	return len(d.fileFlags) > 0 && d.fileFlags[idx]&fileFlagTruncated != 0
+}
+
+// isGeneratedFile returns true if the document was added with
+// Document.Generated or looks generated, see IsGeneratedFile.
+func (d *indexData) isGeneratedFile(idx uint32) bool {
+	return len(d.fileFlags) > 0 && d.fileFlags[idx]&fileFlagGenerated != 0
+}
+
+// resolveLanguage returns the name under which lang is stored in this shard.
+// Names which don't match exactly are compared case-insensitively, so that
+// lang: filters for languages unknown to the query parser still work.
+func (d *indexData) resolveLanguage(lang string) (string, bool) {
+	if _, ok := d.metaData.LanguageMap[lang]; ok {
+		return lang, true
+	}
+	for name := range d.metaData.LanguageMap {
+		if strings.EqualFold(name, lang) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
 // calculates stats for files in the range [start, end).
 func (d *indexData) calculateStatsForFileRange(start, end uint32) zoekt.RepoStats {
 	if start >= end {
@@ -185,6 +288,17 @@ func (d *indexData) calculateStatsForFileRange(start, end uint32) zoekt.RepoStat
 	bytesFN := d.fileNameIndex[end] - d.fileNameIndex[start]
 	count, defaultCount, otherCount := d.calculateNewLinesStats(start, end)
 
+	// Commits are not files, so they don't count towards the repository's
+	// size.
+	documents := int(end - start)
+	for i := start; i < end; i++ {
+		if d.isCommitDoc(i) {
+			documents--
+			bytesContent -= d.boundaries[i+1] - d.boundaries[i]
+			bytesFN -= d.fileNameIndex[i+1] - d.fileNameIndex[i]
+		}
+	}
+
 	// CR keegan for stefan: I think we may want to restructure RepoListEntry so
 	// that we don't change anything, except we have
 	// []Repository. Alternatively, things we can divide up we do (like
@@ -193,7 +307,7 @@ func (d *indexData) calculateStatsForFileRange(start, end uint32) zoekt.RepoStat
 	// chat more.
 	return zoekt.RepoStats{
 		ContentBytes: int64(bytesContent) + int64(bytesFN),
-		Documents:    int(end - start),
+		Documents:    documents,
 		// CR keegan for stefan: our shard count is going to go out of whack,
 		// since we will aggregate these. So we will report more shards than are
 		// present on disk. What should we do?
@@ -219,10 +333,14 @@ func (d *indexData) calculateStats() error {
 			return fmt.Errorf("shard documents out of order with respect to repositories: expected document %d to be part of repo %d", start, repoID)
 		}
 
+		// List reports the languages in the stats, which add up across the
+		// shards of a repository.
+		stats := d.calculateStatsForFileRange(start, end)
+		stats.Languages, md.Languages = md.Languages, nil
 		d.repoListEntry = append(d.repoListEntry, zoekt.RepoListEntry{
 			Repository:    md,
 			IndexMetadata: d.metaData,
-			Stats:         d.calculateStatsForFileRange(start, end),
+			Stats:         stats,
 		})
 		start = end
 	}
@@ -295,22 +413,32 @@ func (d *indexData) String() string {
 func (d *indexData) memoryUse() int {
 	sz := 0
 	for _, a := range [][]uint32{
-		d.newlinesIndex, d.docSectionsIndex,
-		d.boundaries, d.fileNameIndex,
+		d.newlinesIndex, d.docSectionsIndex, d.subTokensIndex,
+		d.boundaries, d.contentBlocks, d.fileNameIndex,
 		d.fileEndRunes, d.fileNameEndRunes,
 		d.fileEndSymbol, d.symbols.symKindIndex,
-		d.subRepos,
+		d.subRepos, d.identifiers.textIndex, d.identifiers.postingsIndex,
+		d.pathOrder,
 	} {
 		sz += 4 * len(a)
 	}
 	sz += d.runeOffsets.sizeBytes()
 	sz += d.fileNameRuneOffsets.sizeBytes()
 	sz += len(d.languages)
+	sz += len(d.fileFlags)
+	for _, c := range d.commits {
+		// map entry, author and date
+		sz += 4 + 16 + len(c.author) + 8
+	}
+	sz += 8 * len(d.modTimes)
 	sz += len(d.checksums)
 	sz += 2 * len(d.repos)
 	sz += 8 * len(d.runeDocSections)
 	sz += 8 * len(d.fileBranchMasks)
 	sz += d.contentNgrams.SizeBytes()
+	if d.foldedNgrams.bt != nil {
+		sz += d.foldedNgrams.SizeBytes()
+	}
 	sz += d.fileNameNgrams.SizeBytes()
 	return sz
 }
@@ -372,6 +500,21 @@ func (data *indexData) ngrams(filename bool) btreeIndex {
 	return data.contentNgrams
 }
 
+// foldedPostings returns the posting list of all case variants of ng, and
+// the number of ngram lookups it took. ok is false if the shard has no
+// case-folded ngrams, which are only stored for file contents.
+func (d *indexData) foldedPostings(ng ngram, fileName bool) (sec simpleSection, lookups int, ok bool) {
+	if fileName || d.foldedNgrams.bt == nil {
+		return simpleSection{}, 0, false
+	}
+	f := foldNgram(ng)
+	if sec := d.foldedNgrams.Get(f); sec.sz > 0 {
+		return sec, 1, true
+	}
+	// Ngrams without other case variants are only stored as themselves.
+	return d.contentNgrams.Get(f), 2, true
+}
+
 type ngramIterationResults struct {
 	matchIterator
 
@@ -396,6 +539,24 @@ func (r *ngramIterationResults) candidates() []*candidateMatch {
 	return cs
 }
 
+// ngramFrequency returns the size of the posting list of ng in file contents,
+// or in file names if fileName is set, and the number of ngram lookups it
+// took. Unless caseSensitive is set, it sums over the case variants of ng.
+func (d *indexData) ngramFrequency(ng ngram, caseSensitive, fileName bool) (freq uint32, lookups int) {
+	ngrams := d.ngrams(fileName)
+	if caseSensitive {
+		return ngrams.Get(ng).sz, 1
+	}
+	if sec, lookups, ok := d.foldedPostings(ng, fileName); ok {
+		return sec.sz, lookups
+	}
+	for _, v := range generateCaseNgrams(ng) {
+		freq += ngrams.Get(v).sz
+		lookups++
+	}
+	return freq, lookups
+}
+
 func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResults, error) {
 	str := query.Pattern
 
@@ -413,18 +574,9 @@ func (d *indexData) iterateNgrams(query *query.Substring) (*ngramIterationResult
 	frequencies := make([]uint32, 0, len(ngramOffs))
 	indexMap := make([]int, len(ngramOffs))
 	ngramLookups := 0
-	ngrams := d.ngrams(query.FileName)
 	for i, o := range ngramOffs {
-		var freq uint32
-		if query.CaseSensitive {
-			freq = ngrams.Get(o.ngram).sz
-			ngramLookups++
-		} else {
-			for _, v := range generateCaseNgrams(o.ngram) {
-				freq += ngrams.Get(v).sz
-				ngramLookups++
-			}
-		}
+		freq, lookups := d.ngramFrequency(o.ngram, query.CaseSensitive, query.FileName)
+		ngramLookups += lookups
 
 		if freq == 0 {
 			return &ngramIterationResults{
@@ -0,0 +1,32 @@
+package index
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fadvise approximates posix_fadvise, which darwin lacks, by toggling
+// read-ahead for f.
+func fadvise(f *os.File, a advice) error {
+	rdahead := 1
+	if a == adviceRandom {
+		rdahead = 0
+	}
+	_, err := unix.FcntlInt(f.Fd(), unix.F_RDAHEAD, rdahead)
+	return err
+}
+
+// openDirect opens name bypassing the buffer cache. darwin has no O_DIRECT,
+// F_NOCACHE is the closest equivalent.
+func openDirect(name string) (int, error) {
+	fd, err := unix.Open(name, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_NOCACHE, 1); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
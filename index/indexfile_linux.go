@@ -0,0 +1,25 @@
+package index
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var fadviseFlags = map[advice]int{
+	adviceNormal:     unix.FADV_NORMAL,
+	adviceRandom:     unix.FADV_RANDOM,
+	adviceSequential: unix.FADV_SEQUENTIAL,
+	adviceWillNeed:   unix.FADV_WILLNEED,
+	adviceDontNeed:   unix.FADV_DONTNEED,
+}
+
+// fadvise sets the read-ahead policy for f. The policy sticks to the open
+// file, so it also applies to page faults on mappings of f after f is closed.
+func fadvise(f *os.File, a advice) error {
+	return unix.Fadvise(int(f.Fd()), 0, 0, fadviseFlags[a])
+}
+
+func openDirect(name string) (int, error) {
+	return unix.Open(name, unix.O_RDONLY|unix.O_DIRECT|unix.O_CLOEXEC, 0)
+}
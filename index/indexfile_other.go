@@ -22,6 +22,9 @@ import (
 	"os"
 )
 
+// shardIOSupported is true if index files implement tunableIndexFile.
+const shardIOSupported = false
+
 // NewIndexFile returns a new index file. The index file takes
 // ownership of the passed in file, and may close it.
 func NewIndexFile(f *os.File) (IndexFile, error) {
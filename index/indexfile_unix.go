@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -28,12 +30,22 @@ type mmapedIndexFile struct {
 	name string
 	size uint32
 	data []byte
+
+	// direct is set if a section is read with O_DIRECT instead of through
+	// the mapping.
+	direct *directReader
+
+	// locked is the number of bytes locked with mlock.
+	locked int64
 }
 
 func (f *mmapedIndexFile) Read(off, sz uint32) ([]byte, error) {
 	if off > off+sz || off+sz > uint32(len(f.data)) {
 		return nil, fmt.Errorf("out of bounds: %d, len %d, name %s", off+sz, len(f.data), f.name)
 	}
+	if f.direct != nil && f.direct.contains(off, sz) {
+		return f.direct.read(off, sz)
+	}
 	return f.data[off : off+sz], nil
 }
 
@@ -46,9 +58,145 @@ func (f *mmapedIndexFile) Size() (uint32, error) {
 }
 
 func (f *mmapedIndexFile) Close() {
+	// Unmapping also unlocks the pages.
 	if err := unix.Munmap(f.data); err != nil {
 		log.Printf("WARN failed to Munmap %s: %v", f.name, err)
 	}
+	shardIOCounters.lockedBytes.Add(-f.locked)
+	if f.direct != nil {
+		if err := unix.Close(f.direct.fd); err != nil {
+			log.Printf("WARN failed to close %s: %v", f.name, err)
+		}
+	}
+}
+
+func (f *mmapedIndexFile) madvise(sec simpleSection, a advice) error {
+	if sec.sz == 0 {
+		return nil
+	}
+	end := sec.off + sec.sz
+	if end < sec.off || end > uint32(len(f.data)) {
+		return fmt.Errorf("out of bounds: %d, len %d", end, len(f.data))
+	}
+	// madvise requires a page aligned address.
+	start := sec.off &^ (pageSize - 1)
+	return unix.Madvise(f.data[start:end], madviseFlags[a])
+}
+
+func (f *mmapedIndexFile) mlock(sec simpleSection) error {
+	if sec.sz == 0 {
+		return nil
+	}
+	end := sec.off + sec.sz
+	if end < sec.off || end > uint32(len(f.data)) {
+		return fmt.Errorf("out of bounds: %d, len %d", end, len(f.data))
+	}
+	start := sec.off &^ (pageSize - 1)
+	if err := unix.Mlock(f.data[start:end]); err != nil {
+		return err
+	}
+	f.locked += int64(end - start)
+	shardIOCounters.lockedBytes.Add(int64(end - start))
+	return nil
+}
+
+func (f *mmapedIndexFile) readDirect(sec simpleSection) error {
+	if sec.sz == 0 || f.direct != nil {
+		return nil
+	}
+	fd, err := openDirect(f.name)
+	if err != nil {
+		return err
+	}
+	f.direct = &directReader{fd: fd, start: sec.off, end: sec.off + sec.sz}
+	return nil
+}
+
+var madviseFlags = map[advice]int{
+	adviceNormal:     unix.MADV_NORMAL,
+	adviceRandom:     unix.MADV_RANDOM,
+	adviceSequential: unix.MADV_SEQUENTIAL,
+	adviceWillNeed:   unix.MADV_WILLNEED,
+	adviceDontNeed:   unix.MADV_DONTNEED,
+}
+
+// pageSize is the alignment required for madvise and O_DIRECT reads.
+const pageSize = 4096
+
+// shardIOSupported is true if index files implement tunableIndexFile.
+const shardIOSupported = true
+
+// directReader reads the range [start, end) of a shard from a file
+// descriptor opened with O_DIRECT (or F_NOCACHE on darwin).
+type directReader struct {
+	fd         int
+	start, end uint32
+}
+
+func (d *directReader) contains(off, sz uint32) bool {
+	return off >= d.start && off+sz <= d.end
+}
+
+// read returns a freshly allocated copy of [off, off+sz). O_DIRECT requires
+// the offset, length and buffer to be aligned, so we read the surrounding
+// pages into a pooled buffer and copy out the requested part.
+func (d *directReader) read(off, sz uint32) ([]byte, error) {
+	if sz == 0 {
+		return []byte{}, nil
+	}
+
+	start := int64(off) &^ (pageSize - 1)
+	end := (int64(off) + int64(sz) + pageSize - 1) &^ (pageSize - 1)
+	bufp := getAlignedBuffer(int(end - start))
+	defer alignedBufferPool.Put(bufp)
+	buf := *bufp
+
+	n := 0
+	for n < len(buf) {
+		m, err := unix.Pread(d.fd, buf[n:], start+int64(n))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m == 0 {
+			break
+		}
+		n += m
+	}
+
+	lo := int(int64(off) - start)
+	if n < lo+int(sz) {
+		return nil, fmt.Errorf("short read at %d: got %d bytes, want %d", off, n-lo, sz)
+	}
+	return append([]byte(nil), buf[lo:lo+int(sz)]...), nil
+}
+
+// alignedBufferPool holds page aligned buffers for directReader. Reads of
+// file contents are small and frequent, so allocating a fresh buffer with
+// an extra page for alignment each time adds up.
+var alignedBufferPool sync.Pool
+
+// getAlignedBuffer returns a page aligned buffer of length n from
+// alignedBufferPool, allocating one if the pooled buffer is too small.
+// Callers return the buffer to the pool when done.
+func getAlignedBuffer(n int) *[]byte {
+	if bufp, ok := alignedBufferPool.Get().(*[]byte); ok && cap(*bufp) >= n {
+		*bufp = (*bufp)[:n]
+		return bufp
+	}
+	buf := alignedBuffer(n)
+	return &buf
+}
+
+func alignedBuffer(n int) []byte {
+	buf := make([]byte, n+pageSize)
+	shift := int(uintptr(unsafe.Pointer(&buf[0])) & (pageSize - 1))
+	if shift != 0 {
+		shift = pageSize - shift
+	}
+	return buf[shift : shift+n : shift+n]
 }
 
 // NewIndexFile returns a new index file. The index file takes
@@ -70,6 +218,12 @@ func NewIndexFile(f *os.File) (IndexFile, error) {
 		size: uint32(sz),
 	}
 
+	if shardIO.readahead != nil {
+		if err := fadvise(f, *shardIO.readahead); err != nil {
+			log.Printf("WARN fadvise %s: %v", f.Name(), err)
+		}
+	}
+
 	rounded := (r.size + 4095) &^ 4095
 	r.data, err = unix.Mmap(int(f.Fd()), 0, int(rounded), unix.PROT_READ, unix.MAP_SHARED)
 	if err != nil {
@@ -8,16 +8,24 @@ import (
 
 // SortAndTruncateFiles is a convenience around SortFiles and
 // DisplayTruncator. Given an aggregated files it will sort and then truncate
-// based on the search options.
+// based on the search options. With opts.GroupByBranch and
+// opts.DedupByChecksum the files are grouped and deduplicated before they are
+// sorted.
 func SortAndTruncateFiles(files []zoekt.FileMatch, opts *zoekt.SearchOptions) []zoekt.FileMatch {
+	if opts.GroupByBranch {
+		files = GroupByBranch(files)
+	}
+	if opts.DedupByChecksum {
+		files = DedupByChecksum(files)
+	}
 	SortFiles(files)
 	truncator, _ := NewDisplayTruncator(opts)
 	files, _ = truncator(files)
 	return files
 }
 
-// DisplayTruncator is a stateful function which enforces Document and Match
-// display limits by truncating and mutating before. hasMore is true until the
+// DisplayTruncator is a stateful function which enforces Document, Match and
+// files per repository display limits by truncating and mutating before. hasMore is true until the
 // limits are exhausted. Once hasMore is false each subsequent call will
 // return an empty after and hasMore false.
 type DisplayTruncator func(before []zoekt.FileMatch) (after []zoekt.FileMatch, hasMore bool)
@@ -32,9 +40,15 @@ func NewDisplayTruncator(opts *zoekt.SearchOptions) (_ DisplayTruncator, hasLimi
 	matchLimit := opts.MaxMatchDisplayCount
 	matchLimited := matchLimit > 0
 
+	// Shards already limit the files of each repository, but a repository
+	// can be split across several shards.
+	repoLimit := opts.MaxFilesPerRepo
+	repoLimited := repoLimit > 0
+	repoFiles := map[string]int{}
+
 	done := false
 
-	if !docLimited && !matchLimited {
+	if !docLimited && !matchLimited && !repoLimited {
 		return func(fm []zoekt.FileMatch) ([]zoekt.FileMatch, bool) {
 			return fm, true
 		}, false
@@ -45,6 +59,17 @@ func NewDisplayTruncator(opts *zoekt.SearchOptions) (_ DisplayTruncator, hasLimi
 			return nil, false
 		}
 
+		if repoLimited {
+			kept := fm[:0]
+			for _, f := range fm {
+				if repoFiles[f.Repository] < repoLimit {
+					repoFiles[f.Repository]++
+					kept = append(kept, f)
+				}
+			}
+			fm = kept
+		}
+
 		if docLimited {
 			if len(fm) >= docLimit {
 				done = true
@@ -3,6 +3,7 @@ package index
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -162,3 +163,37 @@ func TestLimitMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestDisplayTruncatorMaxFilesPerRepo(t *testing.T) {
+	truncator, hasLimits := NewDisplayTruncator(&zoekt.SearchOptions{MaxFilesPerRepo: 2})
+	if !hasLimits {
+		t.Fatal("MaxFilesPerRepo is not a limit")
+	}
+
+	files := func(names ...string) []zoekt.FileMatch {
+		var fm []zoekt.FileMatch
+		for _, n := range names {
+			repo, file, _ := strings.Cut(n, "/")
+			fm = append(fm, zoekt.FileMatch{Repository: repo, FileName: file})
+		}
+		return fm
+	}
+	names := func(fm []zoekt.FileMatch) []string {
+		var s []string
+		for _, f := range fm {
+			s = append(s, f.Repository+"/"+f.FileName)
+		}
+		return s
+	}
+
+	// The limit holds across the batches of a stream, eg. of several shards
+	// of the same repository.
+	got, _ := truncator(files("a/1", "b/1", "a/2", "a/3"))
+	if d := cmp.Diff([]string{"a/1", "b/1", "a/2"}, names(got)); d != "" {
+		t.Errorf("first batch mismatch (-want +got):\n%s", d)
+	}
+	got, _ = truncator(files("a/4", "b/2", "b/3"))
+	if d := cmp.Diff([]string{"b/2"}, names(got)); d != "" {
+		t.Errorf("second batch mismatch (-want +got):\n%s", d)
+	}
+}
@@ -43,6 +43,9 @@ type candidateMatch struct {
 	caseSensitive bool
 	fileName      bool
 	symbol        bool
+	// fuzzy is set for the runs of a fuzzy file name match, which are
+	// highlighted but scored as a whole, see fuzzyMatchTree.
+	fuzzy bool
 }
 
 // Matches content against the substring, and populates byteMatchSz on success
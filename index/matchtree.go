@@ -19,11 +19,13 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"slices"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/licenses"
 	"github.com/sourcegraph/zoekt/internal/syntaxutil"
 	"github.com/sourcegraph/zoekt/query"
 )
@@ -144,6 +146,21 @@ type docMatchTree struct {
 	docID     uint32
 }
 
+// docListMatchTree iterates over a sorted list of documents, eg. the ones
+// found in the identifier index or the path prefix index.
+type docListMatchTree struct {
+	docs []uint32
+
+	// provides additional information about the reason why the
+	// docListMatchTree was created.
+	reason string
+
+	// mutable
+	i         int
+	firstDone bool
+	docID     uint32
+}
+
 type bruteForceMatchTree struct {
 	// mutable
 	firstDone bool
@@ -237,6 +254,29 @@ type substrMatchTree struct {
 	contEvaluated bool
 }
 
+// subTokenMatchTree keeps the content matches of child which start and end
+// at sub-token boundaries.
+type subTokenMatchTree struct {
+	// child is a substrMatchTree, or a regexpMatchTree for patterns shorter
+	// than an ngram.
+	child matchTree
+
+	// mutable
+	evaluated bool
+}
+
+// candidates returns the candidate matches of the child, or nil if the child
+// has an unexpected type.
+func (t *subTokenMatchTree) candidates() *[]*candidateMatch {
+	switch c := t.child.(type) {
+	case *substrMatchTree:
+		return &c.current
+	case *regexpMatchTree:
+		return &c.found
+	}
+	return nil
+}
+
 type branchQueryMatchTree struct {
 	fileMasks []uint64
 	masks     []uint64
@@ -383,6 +423,11 @@ func (t *docMatchTree) prepare(doc uint32) {
 	t.firstDone = true
 }
 
+func (t *docListMatchTree) prepare(doc uint32) {
+	t.docID = doc
+	t.firstDone = true
+}
+
 func (t *andMatchTree) prepare(doc uint32) {
 	for _, c := range t.children {
 		c.prepare(doc)
@@ -401,6 +446,11 @@ func (t *wordMatchTree) prepare(doc uint32) {
 	t.bruteForceMatchTree.prepare(doc)
 }
 
+func (t *subTokenMatchTree) prepare(doc uint32) {
+	t.evaluated = false
+	t.child.prepare(doc)
+}
+
 func (t *orMatchTree) prepare(doc uint32) {
 	for _, c := range t.children {
 		c.prepare(doc)
@@ -445,6 +495,16 @@ func (t *docMatchTree) nextDoc() uint32 {
 	return maxUInt32
 }
 
+func (t *docListMatchTree) nextDoc() uint32 {
+	for t.firstDone && t.i < len(t.docs) && t.docs[t.i] <= t.docID {
+		t.i++
+	}
+	if t.i < len(t.docs) {
+		return t.docs[t.i]
+	}
+	return maxUInt32
+}
+
 func (t *bruteForceMatchTree) nextDoc() uint32 {
 	if !t.firstDone {
 		return 0
@@ -486,6 +546,10 @@ func (t *boostMatchTree) nextDoc() uint32 {
 	return t.child.nextDoc()
 }
 
+func (t *subTokenMatchTree) nextDoc() uint32 {
+	return t.child.nextDoc()
+}
+
 func (t *branchQueryMatchTree) nextDoc() uint32 {
 	var start uint32
 	if t.firstDone {
@@ -559,6 +623,10 @@ func (t *substrMatchTree) String() string {
 	return fmt.Sprintf("%ssubstr(%q, %v, %v)", f, t.query.Pattern, t.current, t.matchIterator)
 }
 
+func (t *subTokenMatchTree) String() string {
+	return fmt.Sprintf("subtoken(%v)", t.child)
+}
+
 func (t *branchQueryMatchTree) String() string {
 	return fmt.Sprintf("branch(%x)", t.masks)
 }
@@ -567,6 +635,10 @@ func (t *symbolSubstrMatchTree) String() string {
 	return fmt.Sprintf("symbol(%v)", t.substrMatchTree)
 }
 
+func (t *docListMatchTree) String() string {
+	return fmt.Sprintf("docs(%s, %d)", t.reason, len(t.docs))
+}
+
 func (t *symbolRegexpMatchTree) String() string {
 	return fmt.Sprintf("symbol(%v)", t.matchTree)
 }
@@ -597,6 +669,8 @@ func visitMatchTree(t matchTree, f func(matchTree)) {
 		visitMatchTree(s.substrMatchTree, f)
 	case *symbolRegexpMatchTree:
 		visitMatchTree(s.matchTree, f)
+	case *subTokenMatchTree:
+		visitMatchTree(s.child, f)
 	default:
 		f(t)
 	}
@@ -640,6 +714,8 @@ func visitMatches(t matchTree, known map[matchTree]bool, weight float64, f func(
 		visitMatches(s.child, known, weight*s.boost, f)
 	case *symbolSubstrMatchTree:
 		visitMatches(s.substrMatchTree, known, weight, f)
+	case *subTokenMatchTree:
+		visitMatches(s.child, known, weight, f)
 	case *notMatchTree:
 	case *noVisitMatchTree:
 		// don't collect into negative trees.
@@ -656,6 +732,11 @@ func (t *docMatchTree) matches(cp *contentProvider, cost int, known map[matchTre
 	return matchesStatePred(t.predicate(cp.idx))
 }
 
+func (t *docListMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	_, found := slices.BinarySearch(t.docs, cp.idx)
+	return matchesStatePred(found)
+}
+
 func (t *bruteForceMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
 	return matchesFound
 }
@@ -927,6 +1008,33 @@ func (t *boostMatchTree) matches(cp *contentProvider, cost int, known map[matchT
 	return evalMatchTree(cp, cost, known, t.child)
 }
 
+func (t *subTokenMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	cands := t.candidates()
+	if cands == nil {
+		// We can't check the boundaries of the matches, so we don't match
+		// rather than ignore the sub-token restriction.
+		return matchesNone
+	}
+	if t.evaluated {
+		return matchesStateForSlice(*cands)
+	}
+
+	if state := evalMatchTree(cp, cost, known, t.child); state != matchesFound {
+		return state
+	}
+
+	pruned := (*cands)[:0]
+	for _, m := range *cands {
+		if cp.subTokenBoundary(m.byteOffset) && cp.subTokenBoundary(m.byteOffset+m.byteMatchSz) {
+			pruned = append(pruned, m)
+		}
+	}
+	*cands = pruned
+	t.evaluated = true
+
+	return matchesStateForSlice(*cands)
+}
+
 func (t *substrMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
 	if t.contEvaluated {
 		return matchesStateForSlice(t.current)
@@ -971,6 +1079,31 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 	}
 	switch s := q.(type) {
 	case *query.Regexp:
+		if s.Multiline {
+			// In multiline mode "." matches newlines. Rewriting the regexp up
+			// front keeps regexpToMatchTreeRecursive from treating ".*" as
+			// confined to a line, so the ngrams of all literals are required
+			// anywhere in the document rather than on the same line.
+			mre := *s
+			mre.Regexp = query.DotMatchesNewline(s.Regexp)
+			s = &mre
+		}
+
+		if prefix, caseSensitive, ok := filePathPrefix(s); ok && len(d.pathOrder) > 0 {
+			docs := d.pathPrefixDocs(prefix, caseSensitive)
+			if len(docs) == 0 {
+				return &noMatchTree{Why: "path prefix index"}, nil
+			}
+			// The index finds the documents, the regexp only has to find
+			// the matches in their names.
+			return &andMatchTree{
+				children: []matchTree{
+					newRegexpMatchTree(s),
+					&noVisitMatchTree{&docListMatchTree{docs: docs, reason: "path prefix index"}},
+				},
+			}, nil
+		}
+
 		// RegexpToMatchTreeRecursive tries to distill a matchTree that matches a
 		// superset of the regexp. If the returned matchTree is equivalent to the
 		// original regexp, it returns true. An equivalent matchTree has the same
@@ -1054,6 +1187,28 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 	case *query.Substring:
 		return d.newSubstringMatchTree(s)
 
+	case *query.FilenameFuzzy:
+		return newFuzzyMatchTree(s), nil
+
+	case *query.Structural:
+		return d.newStructuralMatchTree(s)
+
+	case *query.SubToken:
+		ct, err := d.newSubstringMatchTree(&query.Substring{
+			Pattern:       s.Pattern,
+			CaseSensitive: s.CaseSensitive,
+			Content:       true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch ct.(type) {
+		case *substrMatchTree, *regexpMatchTree:
+		default:
+			return nil, fmt.Errorf("unexpected sub-token child %T", ct)
+		}
+		return &subTokenMatchTree{child: ct}, nil
+
 	case *query.Branch:
 		masks := make([]uint64, 0, len(d.repoMetaData))
 		if s.Pattern == "HEAD" {
@@ -1096,6 +1251,20 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 		}, nil
 
 	case *query.Symbol:
+		if name, ok := exactSymbolName(s.Expr); ok && d.identifiers.present() {
+			docs, err := d.identifierDocs(name)
+			if err != nil {
+				return nil, err
+			}
+			if len(docs) == 0 {
+				return &noMatchTree{Why: "identifier index"}, nil
+			}
+			return &symbolRegexpMatchTree{
+				regexp:    regexp.MustCompile("^" + regexp.QuoteMeta(name) + "$"),
+				matchTree: &docListMatchTree{docs: docs, reason: "identifier index"},
+			}, nil
+		}
+
 		// Disable WordMatchTree since we don't support it in symbols yet.
 		optCopy := opt
 		optCopy.DisableWordMatchOptimization = true
@@ -1221,6 +1390,64 @@ func (d *indexData) newMatchTree(q query.Q, opt matchTreeOpt) (matchTree, error)
 			},
 		}, nil
 
+	case *query.Commit:
+		return d.newCommitMatchTree(s), nil
+
+	case *query.TestFile:
+		return &docMatchTree{
+			reason:  "TestFile",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				return d.isTestFile(docID)
+			},
+		}, nil
+
+	case *query.GeneratedFile:
+		return &docMatchTree{
+			reason:  "GeneratedFile",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				return d.isGeneratedFile(docID)
+			},
+		}, nil
+
+	case *query.Checksum:
+		match, err := checksumPrefixMatcher(s.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		return &docMatchTree{
+			reason:  "Checksum",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				return match(d.getChecksum(docID))
+			},
+		}, nil
+
+	case *query.License:
+		reposWant := make([]bool, len(d.repoMetaData))
+		for repoIdx, r := range d.repoMetaData {
+			if licenses.Matches(r.License, s.License) {
+				reposWant[repoIdx] = true
+			}
+		}
+		return &docMatchTree{
+			reason:  "License",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				return reposWant[d.repos[docID]]
+			},
+		}, nil
+
+	case *query.IndexAt:
+		return nil, fmt.Errorf("%s: searching past snapshots of the index is not enabled", s)
+
+	case *query.SearchContext:
+		return nil, fmt.Errorf("%s: search contexts are not defined", s)
+
+	case *query.Rev:
+		return nil, fmt.Errorf("%s: revisions are not resolved", s)
+
 	case query.RawConfig:
 		return &docMatchTree{
 			reason:  s.String(),
@@ -1352,6 +1579,14 @@ func pruneMatchTree(mt matchTree) (matchTree, error) {
 		if mt.child == nil {
 			return nil, nil
 		}
+	case *subTokenMatchTree:
+		mt.child, err = pruneMatchTree(mt.child)
+		if err != nil {
+			return nil, err
+		}
+		if mt.child == nil {
+			return nil, nil
+		}
 	case *andLineMatchTree:
 		child, err := pruneMatchTree(&mt.andMatchTree)
 		if err != nil {
@@ -1378,9 +1613,12 @@ func pruneMatchTree(mt matchTree) (matchTree, error) {
 		}
 	// unhandled:
 	case *docMatchTree:
+	case *docListMatchTree:
 	case *bruteForceMatchTree:
 	case *regexpMatchTree:
 	case *wordMatchTree:
+	case *fuzzyMatchTree:
+	case *structuralMatchTree:
 	}
 	return mt, err
 }
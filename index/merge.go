@@ -2,6 +2,7 @@ package index
 
 import (
 	"crypto/sha1"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
@@ -18,6 +19,18 @@ import (
 // dstName. It is the responsibility of the caller to delete the input shards and
 // rename the temporary compound shard from tmpName to dstName.
 func Merge(dstDir string, files ...IndexFile) (tmpName, dstName string, _ error) {
+	return MergeWithOptions(dstDir, MergeOptions{}, files...)
+}
+
+// MergeOptions configures MergeWithOptions.
+type MergeOptions struct {
+	// MaxWriteBytesPerSec limits how fast the compound shard is written, so
+	// that merging doesn't compete with searches for IO. 0 means no limit.
+	MaxWriteBytesPerSec int64
+}
+
+// MergeWithOptions is like Merge, but configurable with opts.
+func MergeWithOptions(dstDir string, opts MergeOptions, files ...IndexFile) (tmpName, dstName string, _ error) {
 	var ds []*indexData
 	for _, f := range files {
 		searcher, err := NewSearcher(f)
@@ -45,13 +58,111 @@ func Merge(dstDir string, files ...IndexFile) (tmpName, dstName string, _ error)
 
 	dstName = filepath.Join(dstDir, fmt.Sprintf("compound-%x_v%d.%05d.zoekt", hasher.Sum(nil), NextIndexFormatVersion, 0))
 	tmpName = dstName + ".tmp"
-	if err := builderWriteAll(tmpName, ib); err != nil {
+	if err := builderWriteAll(tmpName, ib, opts.MaxWriteBytesPerSec); err != nil {
 		return "", "", err
 	}
 	return tmpName, dstName, nil
 }
 
-func builderWriteAll(fn string, ib *ShardBuilder) error {
+// EstimateMergeMemory returns the heap memory used by the shards in files when
+// they are loaded separately and an estimate for a compound shard merging
+// them, both in the accounting of RepoStats.IndexBytes. Merging saves memory
+// because ngrams which occur in several shards are only held once. The
+// estimate is slightly conservative.
+//
+// The shards are loaded one at a time and files are closed, also on error.
+func EstimateMergeMemory(files ...IndexFile) (separate, merged int, _ error) {
+	var contentNgrams, nameNgrams []ngram
+	for i, f := range files {
+		mem, content, names, err := shardNgrams(f)
+		if err != nil {
+			for _, f := range files[i+1:] {
+				f.Close()
+			}
+			return 0, 0, err
+		}
+		contentNgrams = unionNgrams(contentNgrams, content.ngrams)
+		nameNgrams = unionNgrams(nameNgrams, names.ngrams)
+
+		separate += mem
+		merged += mem - content.sizeBytes - names.sizeBytes
+	}
+
+	for _, ngrams := range [][]ngram{contentNgrams, nameNgrams} {
+		bt := newBtree(ngramBtreeOpts)
+		for _, ng := range ngrams {
+			bt.insert(ng)
+		}
+		bt.freeze()
+		merged += btreeIndex{bt: bt}.SizeBytes()
+	}
+
+	return separate, merged, nil
+}
+
+type shardNgramSet struct {
+	ngrams    []ngram
+	sizeBytes int
+}
+
+// shardNgrams loads the shard in f and returns its memory use and its content
+// and file name ngrams. f is closed.
+func shardNgrams(f IndexFile) (mem int, content, names shardNgramSet, _ error) {
+	searcher, err := NewSearcher(f)
+	if err != nil {
+		f.Close()
+		return 0, content, names, err
+	}
+	defer searcher.Close()
+	d := searcher.(*indexData)
+
+	content = shardNgramSet{sizeBytes: d.contentNgrams.SizeBytes()}
+	if content.ngrams, err = d.readNgrams(d.contentNgrams); err != nil {
+		return 0, content, names, err
+	}
+	names = shardNgramSet{sizeBytes: d.fileNameNgrams.SizeBytes()}
+	if names.ngrams, err = d.readNgrams(d.fileNameNgrams); err != nil {
+		return 0, content, names, err
+	}
+	return d.memoryUse(), content, names, nil
+}
+
+// readNgrams returns the sorted ngrams of b.
+func (d *indexData) readNgrams(b btreeIndex) ([]ngram, error) {
+	blob, err := d.readSectionBlob(b.ngramSec)
+	if err != nil {
+		return nil, err
+	}
+	ngrams := make([]ngram, 0, len(blob)/ngramEncoding)
+	for i := 0; i+ngramEncoding <= len(blob); i += ngramEncoding {
+		ngrams = append(ngrams, ngram(binary.BigEndian.Uint64(blob[i:i+ngramEncoding])))
+	}
+	return ngrams, nil
+}
+
+// unionNgrams returns the union of the sorted ngrams a and b.
+func unionNgrams(a, b []ngram) []ngram {
+	out := make([]ngram, 0, max(len(a), len(b)))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			out = append(out, a[0])
+			a = a[1:]
+		case b[0] < a[0]:
+			out = append(out, b[0])
+			b = b[1:]
+		default:
+			out = append(out, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	out = append(out, a...)
+	return append(out, b...)
+}
+
+// builderWriteAll writes ib to fn. If bytesPerSec is positive, the writes are
+// throttled to that rate.
+func builderWriteAll(fn string, ib *ShardBuilder, bytesPerSec int64) error {
 	dir := filepath.Dir(fn)
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return err
@@ -69,7 +180,7 @@ func builderWriteAll(fn string, ib *ShardBuilder) error {
 	}
 
 	defer f.Close()
-	if err := ib.Write(f); err != nil {
+	if err := ib.Write(newThrottledWriter(f, bytesPerSec)); err != nil {
 		return err
 	}
 	fi, err := f.Stat()
@@ -102,6 +213,18 @@ func merge(ds ...*indexData) (*ShardBuilder, error) {
 	sb := newShardBuilder()
 	sb.indexFormatVersion = NextIndexFormatVersion
 
+	// Compound shards keep the contents compressed if any input has them
+	// compressed, and likewise for sub-tokens, case-folded ngrams, document
+	// frequencies, the identifier index and the path prefix index.
+	for _, d := range ds {
+		sb.CompressContents = sb.CompressContents || d.contentBlocks != nil
+		sb.SubTokens = sb.SubTokens || len(d.subTokensIndex) > 0
+		sb.CaseFoldedNgrams = sb.CaseFoldedNgrams || d.foldedNgrams.bt != nil
+		sb.DocumentFrequencies = sb.DocumentFrequencies || d.ngramDocFreqs.sz > 0
+		sb.IdentifierIndex = sb.IdentifierIndex || d.identifiers.present()
+		sb.PathPrefixIndex = sb.PathPrefixIndex || len(d.pathOrder) > 0
+	}
+
 	for _, d := range ds {
 		lastRepoID := -1
 		for docID := uint32(0); int(docID) < len(d.fileBranchMasks); docID++ {
@@ -173,7 +296,7 @@ func explode(dstDir string, f IndexFile, ibFuncs ...shardBuilderFunc) (map[strin
 		shardName := ShardName(dstDir, prefix, ib.indexFormatVersion, 0)
 		shardNameTmp := shardName + ".tmp"
 		shardNames[shardNameTmp] = shardName
-		return builderWriteAll(shardNameTmp, ib)
+		return builderWriteAll(shardNameTmp, ib, 0)
 	}
 
 	var sb *ShardBuilder
@@ -199,6 +322,12 @@ func explode(dstDir string, f IndexFile, ibFuncs ...shardBuilderFunc) (map[strin
 
 			sb = newShardBuilder()
 			sb.indexFormatVersion = IndexFormatVersion
+			sb.CompressContents = d.contentBlocks != nil
+			sb.SubTokens = len(d.subTokensIndex) > 0
+			sb.CaseFoldedNgrams = d.foldedNgrams.bt != nil
+			sb.DocumentFrequencies = d.ngramDocFreqs.sz > 0
+			sb.IdentifierIndex = d.identifiers.present()
+			sb.PathPrefixIndex = len(d.pathOrder) > 0
 			if err := sb.setRepository(&d.repoMetaData[repoID]); err != nil {
 				return shardNames, err
 			}
@@ -220,22 +349,36 @@ func explode(dstDir string, f IndexFile, ibFuncs ...shardBuilderFunc) (map[strin
 }
 
 func addDocument(d *indexData, ib *ShardBuilder, repoID int, docID uint32) error {
+	doc, err := readDocument(d, repoID, docID)
+	if err != nil {
+		return err
+	}
+	return ib.Add(doc)
+}
+
+// readDocument returns document docID of repository repoID in d as it was
+// passed to the ShardBuilder.
+func readDocument(d *indexData, repoID int, docID uint32) (Document, error) {
 	doc := Document{
 		Name: string(d.fileName(docID)),
 		// Content set below since it can return an error
 		// Branches set below since it requires lookups
 		SubRepositoryPath: d.subRepoPaths[repoID][d.subRepos[docID]],
 		Language:          d.languageMap[d.getLanguage(docID)],
+		Generated:         d.isGeneratedFile(docID),
+		Truncated:         d.isTruncatedFile(docID),
+		Commit:            d.commitMetadata(docID),
+		ModTime:           d.modTime(docID),
 		// SkipReason not set, will be part of content from original indexer.
 	}
 
 	var err error
 	if doc.Content, err = d.readContents(docID); err != nil {
-		return err
+		return Document{}, err
 	}
 
 	if doc.Symbols, _, err = d.readDocSections(docID, nil); err != nil {
-		return err
+		return Document{}, err
 	}
 
 	doc.SymbolsMetaData = make([]*zoekt.Symbol, len(doc.Symbols))
@@ -255,7 +398,7 @@ func addDocument(d *indexData, ib *ShardBuilder, repoID int, docID uint32) error
 			mask >>= 1
 		}
 	}
-	return ib.Add(doc)
+	return doc, nil
 }
 
 // copied from builder package to avoid circular imports.
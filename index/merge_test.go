@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"testing"
@@ -125,3 +126,54 @@ func checkSameShards(t *testing.T, shard1, shard2 string) {
 
 	t.Fatalf("-%s\n+%s:\n%s", shard1, shard2, d)
 }
+
+func TestEstimateMergeMemory(t *testing.T) {
+	repos := []*zoekt.Repository{{Name: "repo1"}, {Name: "repo2"}}
+	var files []IndexFile
+	for _, r := range repos {
+		b := testShardBuilder(t, r,
+			Document{Name: "main.go", Content: []byte("package main\n\nfunc main() {}\n")},
+			Document{Name: r.Name + ".go", Content: []byte("package " + r.Name + "\n")},
+		)
+		var buf bytes.Buffer
+		if err := b.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &memSeeker{buf.Bytes()})
+	}
+
+	separate, merged, err := EstimateMergeMemory(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged >= separate {
+		t.Fatalf("got merged %d >= separate %d, want savings", merged, separate)
+	}
+
+	// The estimate matches the compound shard.
+	var ds []*indexData
+	for _, f := range files {
+		s, err := NewSearcher(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, s.(*indexData))
+	}
+	ib, err := merge(ds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The estimate is conservative, it ignores the few bytes of sentinel
+	// entries per shard.
+	s := searcherForTest(t, ib)
+	if got := s.(*indexData).memoryUse(); got > merged || merged-got > merged/10 {
+		t.Errorf("compound shard uses %d bytes, estimated %d", got, merged)
+	}
+}
+
+func TestUnionNgrams(t *testing.T) {
+	got := unionNgrams([]ngram{1, 3, 5}, []ngram{2, 3, 6, 7})
+	if d := cmp.Diff([]ngram{1, 2, 3, 5, 6, 7}, got); d != "" {
+		t.Errorf("unionNgrams (-want +got):\n%s", d)
+	}
+}
@@ -1,25 +1,26 @@
-package main
+package index
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/sourcegraph/zoekt"
-	"github.com/sourcegraph/zoekt/index"
 )
 
-// mergeMeta updates the .meta files for the shards on disk for o.
+// MergeMeta updates the .meta files for the shards on disk for o with the
+// mutable fields of o.RepositoryDescription, see zoekt.Repository.MergeMutable.
+// Searchers pick up the changes when they reload the shards, so metadata such
+// as the priority, RawConfig and branch descriptions change without
+// reindexing.
 //
 // This process is best effort. If anything fails we return on the first
 // failure. This means you might have an inconsistent state on disk if an
 // error is returned. It is recommended to fallback to re-indexing in that
 // case.
-func mergeMeta(o *index.Options) error {
+func MergeMeta(o *Options) error {
 	todo := map[string]string{}
 	for _, fn := range o.FindAllShards() {
-		repos, md, err := index.ReadMetadataPath(fn)
+		repos, md, err := ReadMetadataPath(fn)
 		if err != nil {
 			return err
 		}
@@ -33,7 +34,7 @@ func mergeMeta(o *index.Options) error {
 		}
 
 		if repo == nil {
-			return fmt.Errorf("mergeMeta: could not find repo %s in shard %s", o.RepositoryDescription.Name, fn)
+			return fmt.Errorf("MergeMeta: could not find repo %s in shard %s", o.RepositoryDescription.Name, fn)
 		}
 
 		if updated, err := repo.MergeMutable(&o.RepositoryDescription); err != nil {
@@ -52,8 +53,7 @@ func mergeMeta(o *index.Options) error {
 			merged = repo
 		}
 
-		dst := fn + ".meta"
-		tmp, err := jsonMarshalTmpFile(merged, dst)
+		tmp, dst, err := JsonMarshalRepoMetaTemp(fn, merged)
 		if err != nil {
 			return err
 		}
@@ -75,38 +75,3 @@ func mergeMeta(o *index.Options) error {
 
 	return renameErr
 }
-
-// jsonMarshalFileTmp marshals v to the temporary file p + ".*.tmp" and
-// returns the file name.
-//
-// Note: .tmp is the same suffix used by Builder. indexserver knows to clean
-// them up.
-func jsonMarshalTmpFile(v interface{}, p string) (_ string, err error) {
-	b, err := json.Marshal(v)
-	if err != nil {
-		return "", err
-	}
-
-	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
-	if err != nil {
-		return "", err
-	}
-	defer func() {
-		f.Close()
-		if err != nil {
-			_ = os.Remove(f.Name())
-		}
-	}()
-
-	if err := f.Chmod(0o666 &^ umask); err != nil {
-		return "", err
-	}
-	if _, err := f.Write(b); err != nil {
-		return "", err
-	}
-
-	return f.Name(), f.Close()
-}
-
-// respect process umask. build does this.
-var umask os.FileMode
@@ -1,4 +1,4 @@
-package main
+package index
 
 import (
 	"os"
@@ -6,8 +6,8 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
 	"github.com/sourcegraph/zoekt"
-	"github.com/sourcegraph/zoekt/index"
 )
 
 func TestMergeMeta(t *testing.T) {
@@ -17,7 +17,7 @@ func TestMergeMeta(t *testing.T) {
 	var repoFns []string
 
 	for _, name := range repoNames {
-		opts := index.Options{
+		opts := Options{
 			IndexDir: dir,
 			RepositoryDescription: zoekt.Repository{
 				Name: name,
@@ -27,7 +27,7 @@ func TestMergeMeta(t *testing.T) {
 			},
 		}
 		opts.SetDefaults()
-		b, err := index.NewBuilder(opts)
+		b, err := NewBuilder(opts)
 		if err != nil {
 			t.Fatalf("NewBuilder: %v", err)
 		}
@@ -41,7 +41,7 @@ func TestMergeMeta(t *testing.T) {
 	}
 
 	// update meta on repo3 then test it changed
-	opts := &index.Options{
+	opts := &Options{
 		IndexDir: dir,
 		RepositoryDescription: zoekt.Repository{
 			Name: "repo3",
@@ -51,10 +51,10 @@ func TestMergeMeta(t *testing.T) {
 		},
 	}
 	opts.SetDefaults()
-	if err := mergeMeta(opts); err != nil {
+	if err := MergeMeta(opts); err != nil {
 		t.Fatal(err)
 	}
-	repos, _, _ := index.ReadMetadataPath(repoFns[3])
+	repos, _, _ := ReadMetadataPath(repoFns[3])
 	if got, want := repos[0].RawConfig["public"], "0"; got != want {
 		t.Fatalf("failed to update metadata of repo3. Got public %q want %q", got, want)
 	}
@@ -62,7 +62,7 @@ func TestMergeMeta(t *testing.T) {
 	// create a compound shard. Use a new indexdir to avoid the need to cleanup
 	// old shards.
 	dir = t.TempDir()
-	tmpFn, dstFn, err := merge(t, dir, repoFns)
+	tmpFn, dstFn, err := mergeShards(t, dir, repoFns)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,7 +72,7 @@ func TestMergeMeta(t *testing.T) {
 
 	readPublic := func() []string {
 		var public []string
-		repos, _, _ := index.ReadMetadataPath(dstFn)
+		repos, _, _ := ReadMetadataPath(dstFn)
 		for _, r := range repos {
 			public = append(public, r.RawConfig["public"])
 		}
@@ -84,7 +84,7 @@ func TestMergeMeta(t *testing.T) {
 	}
 
 	// Update a repo1 in compound shard to be private
-	opts = &index.Options{
+	opts = &Options{
 		IndexDir: dir,
 		RepositoryDescription: zoekt.Repository{
 			Name: "repo1",
@@ -94,7 +94,7 @@ func TestMergeMeta(t *testing.T) {
 		},
 	}
 	opts.SetDefaults()
-	if err := mergeMeta(opts); err != nil {
+	if err := MergeMeta(opts); err != nil {
 		t.Fatal(err)
 	}
 	if d := cmp.Diff([]string{"1", "0", "1", "0"}, readPublic()); d != "" {
@@ -102,10 +102,10 @@ func TestMergeMeta(t *testing.T) {
 	}
 }
 
-func merge(t *testing.T, dstDir string, names []string) (string, string, error) {
+func mergeShards(t *testing.T, dstDir string, names []string) (string, string, error) {
 	t.Helper()
 
-	var files []index.IndexFile
+	var files []IndexFile
 	for _, fn := range names {
 		f, err := os.Open(fn)
 		if err != nil {
@@ -113,7 +113,7 @@ func merge(t *testing.T, dstDir string, names []string) (string, string, error)
 		}
 		defer f.Close()
 
-		indexFile, err := index.NewIndexFile(f)
+		indexFile, err := NewIndexFile(f)
 		if err != nil {
 			return "", "", err
 		}
@@ -122,5 +122,5 @@ func merge(t *testing.T, dstDir string, names []string) (string, string, error)
 		files = append(files, indexFile)
 	}
 
-	return index.Merge(dstDir, files...)
+	return Merge(dstDir, files...)
 }
@@ -0,0 +1,55 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// marshalModTimes encodes the modification times of all documents, see
+// Document.ModTime, as a varint of seconds since the epoch per document. 0
+// stands for an unknown time. It returns nil if no document has a
+// modification time, so that shards without them don't grow.
+func marshalModTimes(modTimes []int64) []byte {
+	if !slices.ContainsFunc(modTimes, func(t int64) bool { return t != 0 }) {
+		return nil
+	}
+
+	buf := make([]byte, 0, len(modTimes)*binary.MaxVarintLen32)
+	for _, t := range modTimes {
+		buf = binary.AppendVarint(buf, t)
+	}
+	return buf
+}
+
+// unmarshalModTimes decodes a modTimes section written by marshalModTimes
+// for numDocs documents.
+func unmarshalModTimes(buf []byte, numDocs uint32) ([]int64, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	modTimes := make([]int64, 0, numDocs)
+	for len(buf) > 0 {
+		t, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed modification time of document %d", len(modTimes))
+		}
+		modTimes = append(modTimes, t)
+		buf = buf[n:]
+	}
+	if len(modTimes) != int(numDocs) {
+		return nil, fmt.Errorf("got modification times for %d documents, want %d", len(modTimes), numDocs)
+	}
+	return modTimes, nil
+}
+
+// modTime returns the modification time of docID, or the zero time if the
+// shard doesn't record it.
+func (d *indexData) modTime(docID uint32) time.Time {
+	if int(docID) >= len(d.modTimes) || d.modTimes[docID] == 0 {
+		return time.Time{}
+	}
+	return time.Unix(d.modTimes[docID], 0).UTC()
+}
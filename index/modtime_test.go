@@ -0,0 +1,59 @@
+package index
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSearchModTimes(t *testing.T) {
+	now := time.Now().Truncate(time.Second).UTC()
+	modTimes := map[string]time.Time{
+		"old.go": now.AddDate(-2, 0, 0),
+		"new.go": now.Add(-time.Hour),
+	}
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "old.go", Content: []byte("func needle() {}"), ModTime: modTimes["old.go"]},
+		Document{Name: "new.go", Content: []byte("func needle() {}"), ModTime: modTimes["new.go"]},
+		Document{Name: "unknown.go", Content: []byte("func needle() {}")},
+	)
+	d := searcherForTest(t, b)
+
+	search := func(opts *zoekt.SearchOptions) []zoekt.FileMatch {
+		t.Helper()
+		res, err := d.Search(context.Background(), &query.Substring{Pattern: "needle"}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SortFiles(res.Files)
+		return res.Files
+	}
+	names := func(files []zoekt.FileMatch) []string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	files := search(&zoekt.SearchOptions{})
+	for _, f := range files {
+		if !f.LastModified.Equal(modTimes[f.FileName]) {
+			t.Errorf("%s: got LastModified %v, want %v", f.FileName, f.LastModified, modTimes[f.FileName])
+		}
+	}
+	if got, want := names(files), []string{"old.go", "new.go", "unknown.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Recently modified files rank higher, files of unknown age lowest.
+	files = search(&zoekt.SearchOptions{RecencyHalfLife: 30 * 24 * time.Hour})
+	if got, want := names(files), []string{"new.go", "old.go", "unknown.go"}; !cmp.Equal(got, want) {
+		t.Errorf("RecencyHalfLife: got %v, want %v", got, want)
+	}
+}
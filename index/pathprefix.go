@@ -0,0 +1,102 @@
+package index
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// The path prefix index lists the documents of a shard sorted by their case
+// folded file names, so that the documents whose names start with a prefix,
+// eg. for file:^src/foo/, are a range found by binary search. It is empty
+// unless the shard was built with one.
+
+// foldPath returns name with each rune replaced by the smallest rune it
+// matches case-insensitively. Names which match a prefix case-insensitively
+// start with the folded prefix.
+func foldPath(name string) string {
+	var sb strings.Builder
+	sb.Grow(len(name))
+	for _, r := range name {
+		folded := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folded = min(folded, f)
+		}
+		sb.WriteRune(folded)
+	}
+	return sb.String()
+}
+
+// writePathOrder writes the IDs of the documents with names sorted by their
+// folded names.
+func writePathOrder(w *writer, names []*searchableString, sec *simpleSection) {
+	keys := make([]string, len(names))
+	order := make([]uint32, len(names))
+	for i, name := range names {
+		keys[i] = foldPath(string(name.data))
+		order[i] = uint32(i)
+	}
+	slices.SortStableFunc(order, func(a, b uint32) int {
+		return strings.Compare(keys[a], keys[b])
+	})
+
+	sec.start(w)
+	for _, docID := range order {
+		w.U32(docID)
+	}
+	sec.end(w)
+}
+
+// pathPrefixDocs returns the documents whose file names start with prefix,
+// in increasing order. The shard must have a path prefix index.
+func (d *indexData) pathPrefixDocs(prefix string, caseSensitive bool) []uint32 {
+	key := foldPath(prefix)
+	foldedName := func(i int) string {
+		return foldPath(string(d.fileName(d.pathOrder[i])))
+	}
+
+	start := sort.Search(len(d.pathOrder), func(i int) bool {
+		return foldedName(i) >= key
+	})
+	end := start + sort.Search(len(d.pathOrder)-start, func(i int) bool {
+		return !strings.HasPrefix(foldedName(start+i), key)
+	})
+
+	docs := make([]uint32, 0, end-start)
+	for _, docID := range d.pathOrder[start:end] {
+		if caseSensitive && !bytes.HasPrefix(d.fileName(docID), []byte(prefix)) {
+			continue
+		}
+		docs = append(docs, docID)
+	}
+	slices.Sort(docs)
+	return docs
+}
+
+// filePathPrefix returns the literal prefix of the file names q matches if
+// q is a file name regexp anchored at the start, like ^src/foo/ or
+// ^src/.*\.go$. File names have no line breaks in practice, so the start of
+// a line is the start of the name.
+func filePathPrefix(q *query.Regexp) (prefix string, caseSensitive bool, ok bool) {
+	if !q.FileName {
+		return "", false, false
+	}
+
+	r := q.Regexp
+	if r.Op != syntax.OpConcat || len(r.Sub) < 2 {
+		return "", false, false
+	}
+	begin, lit := r.Sub[0], r.Sub[1]
+	if begin.Op != syntax.OpBeginText && begin.Op != syntax.OpBeginLine {
+		return "", false, false
+	}
+	if lit.Op != syntax.OpLiteral {
+		return "", false, false
+	}
+	return string(lit.Rune), q.CaseSensitive && lit.Flags&syntax.FoldCase == 0, true
+}
@@ -0,0 +1,176 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var pathPrefixDocs = []Document{
+	{Name: "src/foo/a.go", Content: []byte("package foo\n")},
+	{Name: "README.md", Content: []byte("foo\n")},
+	{Name: "Src/Foo/b.go", Content: []byte("package foo\n")},
+	{Name: "src/foobar/c.go", Content: []byte("package foobar\n")},
+	{Name: "src/foo/d.txt", Content: []byte("foo\n")},
+	{Name: "lib/src/foo/e.go", Content: []byte("package foo\n")},
+}
+
+func TestPathPrefixDocs(t *testing.T) {
+	b := testShardBuilder(t, nil, pathPrefixDocs...)
+	b.PathPrefixIndex = true
+	d := searcherForTest(t, b).(*indexData)
+
+	for _, tc := range []struct {
+		prefix        string
+		caseSensitive bool
+		want          []uint32
+	}{
+		{"src/foo/", false, []uint32{0, 2, 4}},
+		{"src/foo/", true, []uint32{0, 4}},
+		{"Src/", true, []uint32{2}},
+		{"src/foo", false, []uint32{0, 2, 3, 4}},
+		{"readme", false, []uint32{1}},
+		{"readme", true, []uint32{}},
+		{"zzz", false, []uint32{}},
+		{"", false, []uint32{0, 1, 2, 3, 4, 5}},
+	} {
+		got := d.pathPrefixDocs(tc.prefix, tc.caseSensitive)
+		if !cmp.Equal(got, tc.want) {
+			t.Errorf("pathPrefixDocs(%q, %t) = %v, want %v", tc.prefix, tc.caseSensitive, got, tc.want)
+		}
+	}
+
+	d = searcherForTest(t, testShardBuilder(t, nil, pathPrefixDocs...)).(*indexData)
+	if len(d.pathOrder) > 0 {
+		t.Errorf("got a path prefix index in a shard built without one")
+	}
+}
+
+func TestFilePathPrefix(t *testing.T) {
+	type prefix struct {
+		Prefix        string
+		CaseSensitive bool
+	}
+	for q, want := range map[string]*prefix{
+		"file:^src/foo/":            {"src/foo/", false},
+		"file:^Src/Foo/":            {"Src/Foo/", true},
+		"case:yes file:^src/":       {"src/", true},
+		`file:^src/.*\.go$`:         {"src/", false},
+		"file:src/foo/":             nil,
+		"file:(^src|^lib)/":         nil,
+		"file:^.*/foo/":             nil,
+		"content:^src/":             nil,
+		"case:yes file:^(?i)Src/":   {"SRC/", false},
+		"file:^src/foo/ case:no":    {"src/foo/", false},
+		"f:^src/foo/ case:yes":      {"src/foo/", true},
+		"file:^src/foo/ lang:go":    nil,
+		"file:^src/foo/ foo":        nil,
+		"file:^src/foo/ -file:bar/": nil,
+	} {
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got *prefix
+		if re, ok := parsed.(*query.Regexp); ok {
+			if p, caseSensitive, ok := filePathPrefix(re); ok {
+				got = &prefix{p, caseSensitive}
+			}
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("filePathPrefix(%s): mismatch (-want +got):\n%s", parsed, d)
+		}
+	}
+}
+
+func TestSearch_PathPrefixIndex(t *testing.T) {
+	search := func(index bool, q string) (*zoekt.SearchResult, matchTree) {
+		t.Helper()
+		b := testShardBuilder(t, nil, pathPrefixDocs...)
+		b.PathPrefixIndex = index
+		d := searcherForTest(t, b).(*indexData)
+
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt, err := d.newMatchTree(parsed, matchTreeOpt{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := d.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearScores(res)
+		return res, mt
+	}
+
+	for _, tc := range []struct {
+		q         string
+		wantFiles []string
+		indexed   bool
+	}{
+		{"file:^src/foo/", []string{"src/foo/a.go", "Src/Foo/b.go", "src/foo/d.txt"}, true},
+		{"file:^src/foo/ case:yes", []string{"src/foo/a.go", "src/foo/d.txt"}, true},
+		{`file:^src/.*\.go$`, []string{"src/foo/a.go", "Src/Foo/b.go", "src/foobar/c.go"}, true},
+		{"file:^src/foo/ package", []string{"src/foo/a.go", "Src/Foo/b.go"}, true},
+		{"file:^missing/", nil, true},
+		{"file:src/foo/", []string{"src/foo/a.go", "Src/Foo/b.go", "src/foo/d.txt", "lib/src/foo/e.go"}, false},
+	} {
+		t.Run(tc.q, func(t *testing.T) {
+			res, mt := search(true, tc.q)
+			want, _ := search(false, tc.q)
+
+			var files []string
+			for _, f := range res.Files {
+				files = append(files, f.FileName)
+			}
+			if !cmp.Equal(files, tc.wantFiles) {
+				t.Errorf("got files %v, want %v", files, tc.wantFiles)
+			}
+			if d := cmp.Diff(want.Files, res.Files, ignoreIndexTime); d != "" {
+				t.Errorf("results differ from the ones without a path prefix index (-without +with):\n%s", d)
+			}
+
+			indexed := false
+			visitMatchTree(mt, func(mt matchTree) {
+				switch mt := mt.(type) {
+				case *docListMatchTree:
+					indexed = true
+				case *noMatchTree:
+					indexed = mt.Why == "path prefix index"
+				}
+			})
+			if indexed != tc.indexed {
+				t.Errorf("got match tree %s, want path prefix index %t", mt, tc.indexed)
+			}
+		})
+	}
+}
+
+func TestMerge_PathPrefixIndex(t *testing.T) {
+	b1 := testShardBuilder(t, &zoekt.Repository{Name: "r1"}, pathPrefixDocs[:3]...)
+	b1.PathPrefixIndex = true
+	b2 := testShardBuilder(t, &zoekt.Repository{Name: "r2"}, pathPrefixDocs[3:]...)
+
+	sb, err := merge(searcherForTest(t, b1).(*indexData), searcherForTest(t, b2).(*indexData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sb.PathPrefixIndex {
+		t.Fatal("merged shard lost the path prefix index")
+	}
+	d := searcherForTest(t, sb).(*indexData)
+	var got []string
+	for _, docID := range d.pathPrefixDocs("src/foo/", true) {
+		got = append(got, string(d.fileName(docID)))
+	}
+	if want := []string{"src/foo/a.go", "src/foo/d.txt"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -261,6 +261,9 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	if err := checkReadable(md); err != nil {
+		return nil, err
+	}
 
 	d.metaData = *md
 	d.repoMetaData = make([]zoekt.Repository, 0, len(repos))
@@ -268,22 +271,26 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		d.repoMetaData = append(d.repoMetaData, *r)
 	}
 
-	if d.metaData.IndexFeatureVersion < ReadMinFeatureVersion {
-		return nil, fmt.Errorf("file is feature version %d, want feature version >= %d", d.metaData.IndexFeatureVersion, ReadMinFeatureVersion)
-	}
-
-	if d.metaData.IndexMinReaderVersion > FeatureVersion {
-		return nil, fmt.Errorf("file needs read feature version >= %d, have read feature version %d", d.metaData.IndexMinReaderVersion, FeatureVersion)
+	if toc.contentOffsets.sz > 0 {
+		d.boundaries, err = readSectionU32(d.file, toc.contentOffsets)
+		if err != nil {
+			return nil, err
+		}
+		d.contentBlocksStart = toc.contentBlocks.data.off
+		d.contentBlocks = toc.contentBlocks.relativeIndex()
+	} else {
+		d.boundariesStart = toc.fileContents.data.off
+		d.boundaries = toc.fileContents.relativeIndex()
 	}
-
-	d.boundariesStart = toc.fileContents.data.off
-	d.boundaries = toc.fileContents.relativeIndex()
 	d.newlinesStart = toc.newlines.data.off
 	d.newlinesIndex = toc.newlines.relativeIndex()
 	d.docSectionsStart = toc.fileSections.data.off
 	d.docSectionsIndex = toc.fileSections.relativeIndex()
+	d.subTokensStart = toc.subTokens.data.off
+	d.subTokensIndex = toc.subTokens.relativeIndex()
 
 	d.symbols.symKindIndex = toc.symbolKindMap.relativeIndex()
+	d.symbols.hasSignatures = d.metaData.IndexFeatureVersion >= 13
 	d.fileEndSymbol, err = readSectionU32(d.file, toc.fileEndSymbol)
 	if err != nil {
 		return nil, err
@@ -312,16 +319,61 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, err
 	}
 
+	d.fileFlags, err = d.readSectionBlob(toc.fileFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	commits, err := d.readSectionBlob(toc.commits)
+	if err != nil {
+		return nil, err
+	}
+	if d.commits, err = decodeCommitMetadata(commits); err != nil {
+		return nil, fmt.Errorf("commits: %w", err)
+	}
+
 	d.contentNgrams, err = d.newBtreeIndex(toc.ngramText, toc.postings)
 	if err != nil {
 		return nil, err
 	}
 
+	if toc.foldedNgramText.sz > 0 {
+		d.foldedNgrams, err = d.newBtreeIndex(toc.foldedNgramText, toc.foldedPostings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.identifiers = identifierIndex{
+		textStart:     toc.identifierText.data.off,
+		textIndex:     toc.identifierText.relativeIndex(),
+		postingsStart: toc.identifierPostings.data.off,
+		postingsIndex: toc.identifierPostings.relativeIndex(),
+	}
+	if len(d.identifiers.textIndex) != len(d.identifiers.postingsIndex) {
+		return nil, fmt.Errorf("identifier index: got %d identifiers, but %d posting lists", len(d.identifiers.textIndex), len(d.identifiers.postingsIndex))
+	}
+
+	if toc.ngramDocFreqs.sz > 0 {
+		if want := toc.ngramText.sz / ngramEncoding * 4; toc.ngramDocFreqs.sz != want {
+			return nil, fmt.Errorf("ngramDocFreqs: got %d bytes, want %d", toc.ngramDocFreqs.sz, want)
+		}
+		d.ngramDocFreqs = toc.ngramDocFreqs
+	}
+
 	d.fileBranchMasks, err = readSectionU64(d.file, toc.branchMasks)
 	if err != nil {
 		return nil, err
 	}
 
+	modTimes, err := d.readSectionBlob(toc.modTimes)
+	if err != nil {
+		return nil, err
+	}
+	if d.modTimes, err = unmarshalModTimes(modTimes, d.numDocs()); err != nil {
+		return nil, fmt.Errorf("modTimes: %w", err)
+	}
+
 	d.fileNameContent, err = d.readSectionBlob(toc.fileNames.data)
 	if err != nil {
 		return nil, err
@@ -329,6 +381,14 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 
 	d.fileNameIndex = toc.fileNames.relativeIndex()
 
+	d.pathOrder, err = readSectionU32(d.file, toc.pathOrder)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.pathOrder) > 0 && len(d.pathOrder) != int(d.numDocs()) {
+		return nil, fmt.Errorf("pathOrder: got %d documents, want %d", len(d.pathOrder), d.numDocs())
+	}
+
 	d.fileNameNgrams, err = d.newBtreeIndex(toc.nameNgramText, toc.namePostings)
 	if err != nil {
 		return nil, err
@@ -458,6 +518,12 @@ func (r *reader) parseMetadata(metaData simpleSection, repoMetaData simpleSectio
 
 const ngramEncoding = 8
 
+// ngramBtreeOpts configures the btrees over the ngrams of a shard. For 500k
+// trigams we can expect approx 1000 leaf nodes (500k divided by half the
+// bucketSize) and 20 nodes on level 2 (all but the rightmost inner nodes will
+// have exactly v=50 children) plus a root node.
+var ngramBtreeOpts = btreeOpts{bucketSize: btreeBucketSize, v: 50}
+
 func (d *indexData) newBtreeIndex(ngramSec simpleSection, postings compoundSection) (btreeIndex, error) {
 	bi := btreeIndex{file: d.file}
 
@@ -466,10 +532,7 @@ func (d *indexData) newBtreeIndex(ngramSec simpleSection, postings compoundSecti
 		return btreeIndex{}, err
 	}
 
-	// For 500k trigams we can expect approx 1000 leaf nodes (500k divided by
-	// half the bucketSize) and 20 nodes on level 2 (all but the rightmost
-	// inner nodes will have exactly v=50 children) plus a root node.
-	bt := newBtree(btreeOpts{bucketSize: btreeBucketSize, v: 50})
+	bt := newBtree(ngramBtreeOpts)
 	for i := 0; i < len(textContent); i += ngramEncoding {
 		ng := ngram(binary.BigEndian.Uint64(textContent[i : i+ngramEncoding]))
 		bt.insert(ng)
@@ -505,17 +568,29 @@ func (d *indexData) verify() error {
 			return fmt.Errorf("got %s %d, want %d", what, got, n)
 		}
 	}
+	if len(d.subTokensIndex) > 0 && len(d.subTokensIndex)-1 != n {
+		return fmt.Errorf("got sub-token index %d, want %d", len(d.subTokensIndex)-1, n)
+	}
 	return nil
 }
 
 func (d *indexData) readContents(i uint32) ([]byte, error) {
-	return d.readSectionBlob(simpleSection{
+	if d.contentBlocks != nil {
+		return d.readCompressedContents(d.boundaries[i], d.boundaries[i+1])
+	}
+	sec := simpleSection{
 		off: d.boundariesStart + d.boundaries[i],
 		sz:  d.boundaries[i+1] - d.boundaries[i],
-	})
+	}
+	d.hints.advise(sec, adviceWillNeed)
+	return d.readSectionBlob(sec)
 }
 
 func (d *indexData) readContentSlice(off uint32, sz uint32) ([]byte, error) {
+	if d.contentBlocks != nil {
+		end := min(off+sz, d.boundaries[len(d.boundaries)-1])
+		return d.readCompressedContents(off, end)
+	}
 	// TODO(hanwen): cap result if it is at the end of the content
 	// section.
 	return d.readSectionBlob(simpleSection{
@@ -524,6 +599,25 @@ func (d *indexData) readContentSlice(off uint32, sz uint32) ([]byte, error) {
 	})
 }
 
+func (d *indexData) readSubTokens(i uint32, buf []uint32) ([]uint32, uint32, error) {
+	sec := simpleSection{
+		off: d.subTokensStart + d.subTokensIndex[i],
+		sz:  d.subTokensIndex[i+1] - d.subTokensIndex[i],
+	}
+	blob, err := d.readSectionBlob(sec)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	st := fromSizedDeltas(blob, buf)
+
+	// We rely on it being non-nil to cache the read, see readNewlines.
+	if st == nil {
+		st = make([]uint32, 0)
+	}
+	return st, sec.sz, nil
+}
+
 func (d *indexData) readNewlines(i uint32, buf []uint32) ([]uint32, uint32, error) {
 	sec := simpleSection{
 		off: d.newlinesStart + d.newlinesIndex[i],
@@ -576,11 +670,14 @@ func NewSearcher(r IndexFile) (zoekt.Searcher, error) {
 	if err := rd.readTOC(&toc); err != nil {
 		return nil, err
 	}
+	tuneIO(r, &toc, shardIO)
+
 	indexData, err := rd.readIndexData(&toc)
 	if err != nil {
 		return nil, err
 	}
 	indexData.file = r
+	indexData.hints = newAccessHints(r, &toc, shardIO)
 	return indexData, nil
 }
 
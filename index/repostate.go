@@ -0,0 +1,213 @@
+package index
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/licenses"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// RepoState is the reason a repository has no shards. It is recorded in a
+// repository state file, see Options.WriteRepoState.
+type RepoState string
+
+const (
+	// RepoStateEmpty is the state of repositories without branches to index.
+	RepoStateEmpty RepoState = "empty"
+	// RepoStateCloneFailed is the state of repositories which could not be
+	// fetched, and have not been indexed before.
+	RepoStateCloneFailed RepoState = "clone-failed"
+	// RepoStateTooLarge is the state of repositories which are too large to
+	// index.
+	RepoStateTooLarge RepoState = "too-large"
+)
+
+// RepoStateSuffix is the suffix of repository state files. A state file
+// takes the place of the shards of a repository which could not be indexed.
+// It only holds metadata, so searchers list the repository with its state,
+// but find no documents in it.
+const RepoStateSuffix = ".state.meta"
+
+// RepoStateFile is the content of a repository state file.
+type RepoStateFile struct {
+	Repository zoekt.Repository
+	State      RepoState
+	// Reason describes the state, eg. the error of a failed clone.
+	Reason string `json:",omitempty"`
+	// Time is when the repository entered State.
+	Time time.Time
+}
+
+// RepoStatePath returns the path of the repository state file for the
+// repository with the given shard prefix.
+func RepoStatePath(indexDir, prefix string) string {
+	return filepath.Join(indexDir, escapeShardPrefix(prefix)+RepoStateSuffix)
+}
+
+// RepoStatePath returns the path of the repository state file for o.
+func (o *Options) RepoStatePath() string {
+	return RepoStatePath(o.IndexDir, cmp.Or(o.ShardPrefix, o.RepositoryDescription.Name))
+}
+
+// ReadRepoState reads the repository state file at path.
+func ReadRepoState(path string) (*RepoStateFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sf RepoStateFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	sf.Repository.State = string(sf.State)
+	return &sf, nil
+}
+
+// WriteRepoState records that the repository of o is in state, in place of
+// its shards. Existing shards of the repository are removed, or tombstoned if
+// they are compound shards. If the repository already is in state, the time
+// it entered the state is kept.
+func (o *Options) WriteRepoState(state RepoState, reason string) error {
+	path := o.RepoStatePath()
+	sf := RepoStateFile{
+		Repository: o.RepositoryDescription,
+		State:      state,
+		Reason:     reason,
+		Time:       time.Now(),
+	}
+	sf.Repository.State = string(state)
+
+	// Don't touch an unchanged state file, so that searchers don't reload it.
+	unchanged := false
+	if old, err := os.ReadFile(path); err == nil {
+		var oldState RepoStateFile
+		if json.Unmarshal(old, &oldState) == nil && oldState.State == state {
+			sf.Time = oldState.Time
+		}
+		b, err := json.Marshal(&sf)
+		unchanged = err == nil && bytes.Equal(old, b)
+	}
+
+	if !unchanged {
+		tmp, final, err := JsonMarshalRepoMetaTemp(strings.TrimSuffix(path, ".meta"), &sf)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, final); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	toDelete := map[string]struct{}{}
+	for _, name := range o.FindAllShards() {
+		paths, err := IndexFilePaths(name)
+		if err != nil {
+			return fmt.Errorf("failed to find old paths for %s: %w", name, err)
+		}
+		for _, p := range paths {
+			toDelete[p] = struct{}{}
+		}
+	}
+	return o.removeShardFiles(toDelete)
+}
+
+// repoStateSearcher searches a repository state file. It lists the
+// repository, but has no documents.
+type repoStateSearcher struct {
+	path  string
+	state *RepoStateFile
+}
+
+// NewRepoStateSearcher returns a searcher for the repository state file at
+// path.
+func NewRepoStateSearcher(path string) (zoekt.Searcher, error) {
+	sf, err := ReadRepoState(path)
+	if err != nil {
+		return nil, err
+	}
+	return &repoStateSearcher{path: path, state: sf}, nil
+}
+
+func (s *repoStateSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return &zoekt.SearchResult{}, nil
+}
+
+// simplify evaluates the repository atoms of q. Like indexData.List, the
+// repository is only listed if q simplifies to true, since there are no
+// documents to evaluate the other atoms on.
+func (s *repoStateSearcher) simplify(in query.Q) query.Q {
+	repo := &s.state.Repository
+	eval := query.Map(in, func(q query.Q) query.Q {
+		var match bool
+		switch r := q.(type) {
+		case *query.Repo:
+			match = r.Regexp.MatchString(repo.Name)
+		case *query.RepoRegexp:
+			match = r.Regexp.MatchString(repo.Name)
+		case *query.RepoSet:
+			match = r.Set[repo.Name]
+		case *query.RepoIDs:
+			match = r.Repos.Contains(repo.ID)
+		case query.RawConfig:
+			match = uint8(r)&encodeRawConfig(repo.RawConfig) == uint8(r)
+		case *query.License:
+			match = licenses.Matches(repo.License, r.License)
+		case *query.BranchesRepos:
+			// The repository has no indexed branches.
+		case *query.PathBoost:
+			match = true
+		default:
+			return q
+		}
+		return &query.Const{Value: match}
+	})
+	return query.Simplify(eval)
+}
+
+func (s *repoStateSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	repo := &s.state.Repository
+
+	// 🚨 SECURITY: Skip repositories that don't belong to the tenant.
+	if !tenant.HasAccess(ctx, repo.TenantID) {
+		return &zoekt.RepoList{}, nil
+	}
+	if c, ok := s.simplify(q).(*query.Const); !ok || !c.Value {
+		return &zoekt.RepoList{}, nil
+	}
+
+	field, err := opts.GetField()
+	if err != nil {
+		return nil, err
+	}
+
+	var l zoekt.RepoList
+	if field == zoekt.RepoListFieldReposMap && repo.ID != 0 {
+		l.ReposMap = zoekt.ReposMap{repo.ID: zoekt.MinimalRepoListEntry{
+			IndexTimeUnix: s.state.Time.Unix(),
+		}}
+	} else {
+		l.Repos = []*zoekt.RepoListEntry{{
+			Repository:    *repo,
+			IndexMetadata: zoekt.IndexMetadata{IndexTime: s.state.Time},
+		}}
+	}
+	l.Stats.Repos = 1
+	return &l, nil
+}
+
+func (s *repoStateSearcher) Close() {}
+
+func (s *repoStateSearcher) String() string {
+	return s.path
+}
@@ -0,0 +1,143 @@
+package index
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestWriteRepoState(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		IndexDir: dir,
+		RepositoryDescription: zoekt.Repository{
+			ID:       7,
+			Name:     "repo",
+			Branches: []zoekt.RepositoryBranch{{Name: "HEAD", Version: "v1"}},
+		},
+	}
+	opts.SetDefaults()
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("a.go", []byte("package a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state replaces the shards.
+	if err := opts.WriteRepoState(RepoStateTooLarge, "too many bytes"); err != nil {
+		t.Fatal(err)
+	}
+	if shards := opts.FindAllShards(); len(shards) != 0 {
+		t.Fatalf("got shards %v, want none", shards)
+	}
+	sf, err := ReadRepoState(opts.RepoStatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.State != RepoStateTooLarge || sf.Reason != "too many bytes" || sf.Repository.State != string(RepoStateTooLarge) || sf.Time.IsZero() {
+		t.Fatalf("unexpected state file %+v", sf)
+	}
+
+	// Recording the same state again keeps its time, a new state resets it.
+	old := sf.Time.Add(-time.Hour)
+	if err := os.Chtimes(opts.RepoStatePath(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.WriteRepoState(RepoStateTooLarge, "too many bytes"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(opts.RepoStatePath()); err != nil || !fi.ModTime().Equal(old) {
+		t.Fatalf("unchanged state file was rewritten: %v", err)
+	}
+	if err := opts.WriteRepoState(RepoStateEmpty, ""); err != nil {
+		t.Fatal(err)
+	}
+	if sf2, err := ReadRepoState(opts.RepoStatePath()); err != nil || sf2.State != RepoStateEmpty || !sf2.Time.After(sf.Time) {
+		t.Fatalf("got %+v, %v for a new state", sf2, err)
+	}
+
+	// Indexing the repository removes the state.
+	b, err = NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(opts.RepoStatePath()); !os.IsNotExist(err) {
+		t.Fatalf("state file still exists after indexing: %v", err)
+	}
+}
+
+func TestRepoStateSearcher(t *testing.T) {
+	opts := Options{
+		IndexDir: t.TempDir(),
+		RepositoryDescription: zoekt.Repository{
+			ID:        7,
+			Name:      "github.com/foo/empty",
+			RawConfig: map[string]string{"public": "1"},
+		},
+	}
+	if err := opts.WriteRepoState(RepoStateEmpty, ""); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewRepoStateSearcher(opts.RepoStatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		q    query.Q
+		want bool
+	}{
+		{&query.Const{Value: true}, true},
+		{&query.Repo{Regexp: regexp.MustCompile("foo/empty")}, true},
+		{&query.Repo{Regexp: regexp.MustCompile("bar")}, false},
+		{query.NewSingleBranchesRepos("HEAD", 7), false},
+		{query.NewAnd(query.NewRepoIDs(7), query.RcOnlyPublic), true},
+		// There are no documents to match.
+		{&query.Substring{Pattern: "foo"}, false},
+	} {
+		rl, err := s.List(ctx, tc.q, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(rl.Repos) == 1; got != tc.want {
+			t.Errorf("%s: got listed %t, want %t", tc.q, got, tc.want)
+			continue
+		}
+		if tc.want && rl.Repos[0].Repository.State != string(RepoStateEmpty) {
+			t.Errorf("%s: got state %q", tc.q, rl.Repos[0].Repository.State)
+		}
+	}
+
+	rl, err := s.List(ctx, &query.Const{Value: true}, &zoekt.ListOptions{Field: zoekt.RepoListFieldReposMap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rl.ReposMap[7]; !ok || len(rl.Repos) != 0 {
+		t.Errorf("got %+v, want repo 7 in ReposMap", rl)
+	}
+
+	sr, err := s.Search(ctx, &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 0 {
+		t.Errorf("got %d files, want none", len(sr.Files))
+	}
+}
@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"time"
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/internal/ctags"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 const (
@@ -117,6 +119,9 @@ func (p *contentProvider) scoreLine(ms []*candidateMatch, language string, lineN
 
 	var bestScore lineScore
 	for i, m := range ms {
+		if m.fuzzy {
+			continue
+		}
 		data := p.data(m.fileName)
 
 		endOffset := m.byteOffset + m.byteMatchSz
@@ -128,6 +133,8 @@ func (p *contentProvider) scoreLine(ms []*candidateMatch, language string, lineN
 
 		if startBoundary && endBoundary {
 			addScore("WordMatch", scoreWordMatch)
+		} else if !m.fileName && p.subTokenBoundary(m.byteOffset) && p.subTokenBoundary(endOffset) {
+			addScore("SubTokenMatch", scoreSubTokenMatch)
 		} else if startBoundary || endBoundary {
 			addScore("PartialWordMatch", scorePartialWordMatch)
 		}
@@ -267,8 +274,12 @@ func (p *contentProvider) calculateTermFrequency(cands []*candidateMatch, df ter
 // whether there's an exact match on a symbol, the number of query clauses that matched, etc.
 func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTree, known map[matchTree]bool, opts *zoekt.SearchOptions) {
 	atomMatchCount := 0
+	fuzzyScore := 0.0
 	visitMatchAtoms(mt, known, func(mt matchTree) {
 		atomMatchCount++
+		if ft, ok := mt.(*fuzzyMatchTree); ok {
+			fuzzyScore = max(fuzzyScore, ft.score)
+		}
 	})
 
 	addScore := func(what string, computed float64) {
@@ -305,6 +316,28 @@ func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTr
 	// the matches.
 	addScore("fragment", maxFileScore)
 
+	// The fragments of fuzzy matches are single characters or short runs, so
+	// scoreLine skips them and they are ranked by how well the whole pattern
+	// lines up.
+	if fuzzyScore > 0 {
+		addScore("fuzzy", scoreFuzzyMatch*fuzzyScore)
+	}
+
+	if d.isTestFile(doc) {
+		addScore("test-file", -(1-scoreTestFileFactor)*fileMatch.Score)
+	}
+	if d.isGeneratedFile(doc) {
+		addScore("generated-file", -(1-scoreGeneratedFileFactor)*fileMatch.Score)
+	}
+	if boost := query.PathBoostFactor(opts.PathBoosts, fileMatch.FileName); boost != 1 {
+		addScore("path-boost", (boost-1)*fileMatch.Score)
+	}
+	if opts.RecencyHalfLife > 0 {
+		if boost := recencyBoost(fileMatch, opts.RecencyHalfLife, time.Now()); boost > 0 {
+			addScore("recency", boost)
+		}
+	}
+
 	// Add tiebreakers
 	//
 	// ScoreOffset shifts the score 7 digits to the left.
@@ -331,6 +364,21 @@ func (d *indexData) scoreFile(fileMatch *zoekt.FileMatch, doc uint32, mt matchTr
 	}
 }
 
+// recencyBoost returns scoreFactorRecency for a file modified at now, halved
+// for every halfLife since. Files without a modification time fall back to
+// the commit date of their branch and get no boost without either.
+func recencyBoost(fileMatch *zoekt.FileMatch, halfLife time.Duration, now time.Time) float64 {
+	modTime := fileMatch.LastModified
+	if modTime.IsZero() {
+		modTime = fileMatch.CommitDate
+	}
+	if modTime.IsZero() {
+		return 0
+	}
+	age := max(now.Sub(modTime), 0)
+	return scoreFactorRecency * math.Exp2(-float64(age)/float64(halfLife))
+}
+
 // termFrequency stores the term frequencies for doc.
 type termFrequency struct {
 	doc uint32
@@ -347,6 +395,14 @@ func (d *indexData) scoreFilesUsingBM25(fileMatches []zoekt.FileMatch, tfs []ter
 	// Use standard parameter defaults used in Lucene (https://lucene.apache.org/core/10_1_0/core/org/apache/lucene/search/similarities/BM25Similarity.html)
 	k, b := 1.2, 0.75
 
+	// The documents we visited undercount the document frequencies if the
+	// query has ANDed terms or the search stopped early.
+	for term, n := range df {
+		if est, ok := d.termDocFreq(term); ok {
+			df[term] = max(n, est)
+		}
+	}
+
 	averageFileLength := float64(d.boundaries[d.numDocs()]) / float64(d.numDocs())
 	// This is very unlikely, but explicitly guard against division by zero.
 	if averageFileLength == 0 {
@@ -370,10 +426,30 @@ func (d *indexData) scoreFilesUsingBM25(fileMatches []zoekt.FileMatch, tfs []ter
 			score += idf(df[term], int(d.numDocs())) * tfScore
 		}
 
+		testFile := d.isTestFile(doc)
+		if testFile {
+			score *= scoreTestFileFactor
+		}
+		generatedFile := d.isGeneratedFile(doc)
+		if generatedFile {
+			score *= scoreGeneratedFileFactor
+		}
+		pathBoost := query.PathBoostFactor(opts.PathBoosts, fileMatches[i].FileName)
+		score *= pathBoost
+
 		fileMatches[i].Score = score
 
 		if opts.DebugScore {
 			fileMatches[i].Debug = fmt.Sprintf("bm25-score: %.2f <- sum-termFrequencies: %d, length-ratio: %.2f", score, sumTF, L)
+			if testFile {
+				fileMatches[i].Debug += fmt.Sprintf(", test-file: %.2f", scoreTestFileFactor)
+			}
+			if generatedFile {
+				fileMatches[i].Debug += fmt.Sprintf(", generated-file: %.2f", scoreGeneratedFileFactor)
+			}
+			if pathBoost != 1 {
+				fileMatches[i].Debug += fmt.Sprintf(", path-boost: %.2f", pathBoost)
+			}
 		}
 	}
 }
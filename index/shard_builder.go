@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"hash/crc64"
 	"log"
 	"net/url"
 	"os"
@@ -62,6 +61,9 @@ type postingsBuilder struct {
 	postings    map[ngram][]byte
 	lastOffsets map[ngram]uint32
 
+	// docFreqs is the number of documents each ngram occurs in.
+	docFreqs map[ngram]uint32
+
 	// To support UTF-8 searching, we must map back runes to byte
 	// offsets. As a first attempt, we sample regularly. The
 	// precise offset can be found by walking from the recorded
@@ -79,6 +81,7 @@ func newPostingsBuilder() *postingsBuilder {
 	return &postingsBuilder{
 		postings:     map[ngram][]byte{},
 		lastOffsets:  map[ngram]uint32{},
+		docFreqs:     map[ngram]uint32{},
 		isPlainASCII: true,
 	}
 }
@@ -129,8 +132,12 @@ func (s *postingsBuilder) newSearchableString(data []byte, byteSections []Docume
 		}
 
 		ng := runesToNGram(runeGram)
-		lastOff := s.lastOffsets[ng]
+		lastOff, seen := s.lastOffsets[ng]
 		newOff := endRune + uint32(runeIndex) - 2
+		if !seen || lastOff < endRune {
+			// First occurrence in this document.
+			s.docFreqs[ng]++
+		}
 
 		m := binary.PutUvarint(buf[:], uint64(newOff-lastOff))
 		s.postings[ng] = append(s.postings[ng], buf[:m]...)
@@ -206,12 +213,51 @@ type ShardBuilder struct {
 	// language codes, uint16 encoded as little-endian
 	languages []uint8
 
+	// fileFlag bits per document, eg. fileFlagTest
+	fileFlags []uint8
+
+	// commits holds the metadata of commit documents, see
+	// appendCommitMetadata.
+	commits []byte
+
+	// modTimes holds Document.ModTime in seconds since the epoch per
+	// document, 0 if it is unknown.
+	modTimes []int64
+
 	// IndexTime will be used as the time if non-zero. Otherwise
 	// time.Now(). This is useful for doing reproducible builds in tests.
 	IndexTime time.Time
 
 	// a sortable 20 chars long id.
 	ID string
+
+	// CompressContents stores the file contents zstd compressed. Such
+	// shards can only be read by readers of FeatureVersion 15 or newer.
+	CompressContents bool
+
+	// SubTokens stores the sub-token boundaries of the file contents, which
+	// query.SubToken and ranking use instead of inspecting the contents.
+	// Older readers ignore them.
+	SubTokens bool
+
+	// CaseFoldedNgrams additionally stores the postings of the content
+	// ngrams merged over their case variants, so that case-insensitive
+	// searches look up one posting list per ngram instead of one per
+	// variant. Older readers ignore them.
+	CaseFoldedNgrams bool
+
+	// DocumentFrequencies stores the number of documents each content ngram
+	// occurs in, which BM25 scoring uses to estimate the document frequency
+	// of query terms. Older readers ignore them.
+	DocumentFrequencies bool
+
+	// IdentifierIndex stores the posting list of the documents defining
+	// each symbol name, see identifierIndex. Older readers ignore it.
+	IdentifierIndex bool
+
+	// PathPrefixIndex stores the documents sorted by file name, see
+	// writePathOrder. Older readers ignore it.
+	PathPrefixIndex bool
 }
 
 func verify(repo *zoekt.Repository) error {
@@ -304,6 +350,10 @@ func (b *ShardBuilder) setRepository(desc *zoekt.Repository) error {
 
 	repo := *desc
 
+	// The language statistics are of the documents added to this shard, eg.
+	// not the ones of the shards merged into a compound shard.
+	repo.Languages = nil
+
 	// copy subrepomap without root
 	repo.SubRepoMap = map[string]*zoekt.Repository{}
 	for k, v := range desc.SubRepoMap {
@@ -384,11 +434,9 @@ func (b *ShardBuilder) symbolKindID(t string) uint32 {
 func (b *ShardBuilder) addSymbols(symbols []*zoekt.Symbol) {
 	for _, sym := range symbols {
 		b.symMetaData = append(b.symMetaData,
-			// This field was removed due to redundancy. To avoid
-			// needing to reindex, it is set to zero for now. In the
-			// future, this field will be completely removed. It
-			// will require incrementing the feature version.
-			0,
+			// This field used to hold the redundant symbol name. Since
+			// feature version 13 it holds the signature instead.
+			b.symbolID(sym.Signature),
 			b.symbolKindID(sym.Kind),
 			b.symbolID(sym.Parent),
 			b.symbolKindID(sym.ParentKind))
@@ -403,8 +451,6 @@ func DetermineLanguageIfUnknown(doc *Document) {
 
 // Add a file which only occurs in certain branches.
 func (b *ShardBuilder) Add(doc Document) error {
-	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
-
 	if idx := bytes.IndexByte(doc.Content, 0); idx >= 0 {
 		doc.SkipReason = fmt.Sprintf("binary content at byte offset %d", idx)
 		doc.Language = "binary"
@@ -419,7 +465,10 @@ func (b *ShardBuilder) Add(doc Document) error {
 		}
 	}
 
-	DetermineLanguageIfUnknown(&doc)
+	// Commits are not files, so they have no language.
+	if doc.Commit == nil {
+		DetermineLanguageIfUnknown(&doc)
+	}
 
 	sort.Sort(symbolSlice{doc.Symbols, doc.SymbolsMetaData})
 	var last DocumentSection
@@ -473,8 +522,6 @@ func (b *ShardBuilder) Add(doc Document) error {
 	b.subRepos = append(b.subRepos, subRepoIdx)
 	b.repos = append(b.repos, uint16(repoIdx))
 
-	hasher.Write(doc.Content)
-
 	b.contentStrings = append(b.contentStrings, docStr)
 	b.runeDocSections = append(b.runeDocSections, runeSecs...)
 
@@ -482,7 +529,7 @@ func (b *ShardBuilder) Add(doc Document) error {
 	b.docSections = append(b.docSections, doc.Symbols)
 	b.fileEndSymbol = append(b.fileEndSymbol, uint32(len(b.runeDocSections)))
 	b.branchMasks = append(b.branchMasks, mask)
-	b.checksums = append(b.checksums, hasher.Sum(nil)...)
+	b.checksums = append(b.checksums, zoekt.ContentChecksum(doc.Content)...)
 
 	langCode, ok := b.languageMap[doc.Language]
 	if !ok {
@@ -494,6 +541,40 @@ func (b *ShardBuilder) Add(doc Document) error {
 	}
 	b.languages = append(b.languages, uint8(langCode), uint8(langCode>>8))
 
+	var flags uint8
+	if doc.Commit != nil {
+		flags |= fileFlagCommit
+		b.commits = appendCommitMetadata(b.commits, uint32(len(b.fileFlags)), doc.Commit)
+	} else if IsTestFile(doc.Name, doc.Language) {
+		flags |= fileFlagTest
+	}
+	if doc.Generated || IsGeneratedFile(doc.Name, doc.Content) {
+		flags |= fileFlagGenerated
+	}
+	if doc.Truncated {
+		flags |= fileFlagTruncated
+	}
+	b.fileFlags = append(b.fileFlags, flags)
+
+	// Like GitHub's language breakdown, the language statistics only count
+	// the files written by hand.
+	if doc.Commit == nil && doc.Language != "" && flags&fileFlagGenerated == 0 && !isSkippedDocument(doc) {
+		repo := &b.repoList[repoIdx]
+		if repo.Languages == nil {
+			repo.Languages = map[string]zoekt.LanguageStats{}
+		}
+		ls := repo.Languages[doc.Language]
+		ls.Files++
+		ls.Bytes += int64(len(doc.Content))
+		repo.Languages[doc.Language] = ls
+	}
+
+	var modTime int64
+	if !doc.ModTime.IsZero() {
+		modTime = doc.ModTime.Unix()
+	}
+	b.modTimes = append(b.modTimes, modTime)
+
 	return nil
 }
 
@@ -567,9 +648,15 @@ func (t *DocChecker) clearTrigrams(maxTrigramCount int) {
 // ShardName returns the name of the shard for the given prefix, version, and
 // shard number.
 func ShardName(indexDir string, prefix string, version, n int) string {
+	return filepath.Join(indexDir, fmt.Sprintf("%s_v%d.%05d.zoekt", escapeShardPrefix(prefix), version, n))
+}
+
+// escapeShardPrefix escapes prefix for use in file names, and truncates it
+// if it is too long.
+func escapeShardPrefix(prefix string) string {
 	prefix = url.QueryEscape(prefix)
 	if len(prefix) > 200 {
 		prefix = prefix[:200] + hashString(prefix)[:8]
 	}
-	return filepath.Join(indexDir, fmt.Sprintf("%s_v%d.%05d.zoekt", prefix, version, n))
+	return prefix
 }
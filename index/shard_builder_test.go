@@ -1,8 +1,14 @@
 package index
 
 import (
+	"context"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
 )
 
 func TestShardName(t *testing.T) {
@@ -47,3 +53,36 @@ func TestShardName(t *testing.T) {
 		})
 	}
 }
+
+func TestLanguageStats(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "repo"},
+		Document{Name: "main.go", Content: []byte("package main\n")},
+		Document{Name: "util.go", Content: []byte("package util\n")},
+		Document{Name: "README.md", Content: []byte("# repo\n")},
+		Document{Name: "api.pb.go", Content: []byte("// Code generated by protoc-gen-go. DO NOT EDIT.\n")},
+		Document{Name: "logo.png", Content: []byte("\x89PNG\x00")},
+	)
+	want := map[string]zoekt.LanguageStats{
+		"Go":       {Files: 2, Bytes: 26},
+		"Markdown": {Files: 1, Bytes: 7},
+	}
+
+	d := searcherForTest(t, b).(*indexData)
+	rl, err := d.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, rl.Repos[0].Stats.Languages); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	// Merging recounts the documents instead of adding up the statistics
+	// of the input shards.
+	sb, err := merge(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, sb.repoList[0].Languages); d != "" {
+		t.Errorf("merged mismatch (-want +got):\n%s", d)
+	}
+}
@@ -0,0 +1,287 @@
+package index
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// advice is a hint to the kernel about how a range of a shard will be
+// accessed. It maps to the POSIX_FADV_* and MADV_* constants.
+type advice int
+
+const (
+	adviceNormal advice = iota
+	adviceRandom
+	adviceSequential
+	adviceWillNeed
+	adviceDontNeed
+)
+
+var adviceNames = map[string]advice{
+	"normal":     adviceNormal,
+	"random":     adviceRandom,
+	"sequential": adviceSequential,
+	"willneed":   adviceWillNeed,
+	"dontneed":   adviceDontNeed,
+}
+
+// shardIOOptions tunes how shard files are read from disk. The zero value
+// leaves everything to the kernel, which works well for SSDs but can behave
+// poorly on slow or zoned disks.
+type shardIOOptions struct {
+	// readahead, if set, is passed to posix_fadvise for the whole file. It
+	// controls the read-ahead window used when faulting in pages.
+	readahead *advice
+
+	// madvise maps section names (see indexTOC.namedSections) to the advice
+	// passed to madvise for the mapped range of that section.
+	madvise map[string]advice
+
+	// directContents reads file contents with O_DIRECT, bypassing the page
+	// cache. Useful for sequential scans over cold storage which would
+	// otherwise evict hot index data. Only supported on Linux.
+	directContents bool
+
+	// accessHints passes madvise hints for the ranges a search is about to
+	// read or has finished scanning, see accessHints.
+	accessHints bool
+
+	// mlock lists the sections (see indexTOC.namedSections) which are locked
+	// in memory, so that they are never evicted from the page cache.
+	mlock []string
+}
+
+// shardIO is configured with the ZOEKT_SHARD_IO environment variable, a comma
+// separated list of
+//
+//	readahead=ADVICE         posix_fadvise for the whole shard
+//	madvise.SECTION=ADVICE   madvise for one section of the shard
+//	direct=contents          read file contents with O_DIRECT
+//	hints=on                 madvise the ranges searches read, see accessHints
+//	mlock=SECTION            lock one section of the shard in memory
+//
+// where ADVICE is one of normal, random, sequential, willneed or dontneed, and
+// SECTION is one of contents, newlines, postings, ngrams, filenames,
+// namepostings or symbols. For example:
+//
+//	ZOEKT_SHARD_IO=readahead=random,madvise.postings=willneed,direct=contents
+//	ZOEKT_SHARD_IO=hints=on,mlock=filenames,mlock=namepostings
+//
+// Locking is limited by RLIMIT_MEMLOCK. Sections which exceed it are logged
+// and left unlocked.
+//
+// An invalid value is ignored, see ValidateShardIO.
+var shardIO, shardIOErr = parseShardIOEnv(os.Getenv("ZOEKT_SHARD_IO"))
+
+func parseShardIOEnv(s string) (shardIOOptions, error) {
+	opts, err := parseShardIO(s)
+	if err == nil && !opts.isZero() && !shardIOSupported {
+		err = fmt.Errorf("IO tuning is not supported on %s", runtime.GOOS)
+	}
+	if err != nil {
+		err = fmt.Errorf("ZOEKT_SHARD_IO: %w", err)
+		log.Printf("WARN ignoring %v", err)
+		return shardIOOptions{}, err
+	}
+	return opts, nil
+}
+
+// ValidateShardIO returns an error if ZOEKT_SHARD_IO is set to a value which
+// is ignored, either because it is invalid or because the platform does not
+// support it. Binaries call it on startup to refuse a configuration which
+// would silently have no effect.
+func ValidateShardIO() error {
+	return shardIOErr
+}
+
+func (o shardIOOptions) isZero() bool {
+	return o.readahead == nil && len(o.madvise) == 0 && !o.directContents && !o.accessHints && len(o.mlock) == 0
+}
+
+func parseShardIO(s string) (shardIOOptions, error) {
+	var opts shardIOOptions
+	if s == "" {
+		return opts, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return shardIOOptions{}, fmt.Errorf("expected key=value, got %q", kv)
+		}
+
+		switch {
+		case k == "readahead":
+			a, ok := adviceNames[v]
+			if !ok {
+				return shardIOOptions{}, fmt.Errorf("unknown advice %q", v)
+			}
+			opts.readahead = &a
+
+		case strings.HasPrefix(k, "madvise."):
+			section := strings.TrimPrefix(k, "madvise.")
+			if _, ok := (&indexTOC{}).namedSections()[section]; !ok {
+				return shardIOOptions{}, fmt.Errorf("unknown section %q", section)
+			}
+			a, ok := adviceNames[v]
+			if !ok {
+				return shardIOOptions{}, fmt.Errorf("unknown advice %q", v)
+			}
+			if opts.madvise == nil {
+				opts.madvise = map[string]advice{}
+			}
+			opts.madvise[section] = a
+
+		case k == "direct":
+			if v != "contents" {
+				return shardIOOptions{}, fmt.Errorf("O_DIRECT is only supported for contents, got %q", v)
+			}
+			opts.directContents = true
+
+		case k == "hints":
+			switch v {
+			case "on":
+				opts.accessHints = true
+			case "off":
+				opts.accessHints = false
+			default:
+				return shardIOOptions{}, fmt.Errorf("hints must be on or off, got %q", v)
+			}
+
+		case k == "mlock":
+			if _, ok := (&indexTOC{}).namedSections()[v]; !ok {
+				return shardIOOptions{}, fmt.Errorf("unknown section %q", v)
+			}
+			opts.mlock = append(opts.mlock, v)
+
+		default:
+			return shardIOOptions{}, fmt.Errorf("unknown key %q", k)
+		}
+	}
+
+	return opts, nil
+}
+
+// tunableIndexFile is implemented by index files which support per section
+// IO tuning.
+type tunableIndexFile interface {
+	madvise(sec simpleSection, a advice) error
+	readDirect(sec simpleSection) error
+	mlock(sec simpleSection) error
+}
+
+// tuneIO applies opts to the sections of f. Errors are logged, since tuning
+// is best effort and must never prevent a shard from loading.
+func tuneIO(f IndexFile, toc *indexTOC, opts shardIOOptions) {
+	t, ok := f.(tunableIndexFile)
+	if !ok {
+		return
+	}
+
+	sections := toc.namedSections()
+	for name, a := range opts.madvise {
+		if err := t.madvise(sections[name], a); err != nil {
+			log.Printf("WARN madvise %s of %s: %v", name, f.Name(), err)
+		}
+	}
+
+	if opts.directContents {
+		if err := t.readDirect(sections["contents"]); err != nil {
+			log.Printf("WARN O_DIRECT for %s: %v", f.Name(), err)
+		}
+	}
+
+	for _, name := range opts.mlock {
+		if err := t.mlock(sections[name]); err != nil {
+			log.Printf("WARN mlock %s of %s: %v", name, f.Name(), err)
+		}
+	}
+}
+
+// minAdviseBytes is the smallest range we pass access hints for. Smaller
+// ranges are served by the kernel's read-ahead, so the hint would only add a
+// syscall.
+const minAdviseBytes = 64 << 10
+
+// accessHints passes madvise hints for the ranges searches access:
+//
+//   - Posting lists and file contents of at least minAdviseBytes are read
+//     front to back right away, so we ask for them with MADV_WILLNEED.
+//   - A search which loaded the contents of most files in the shard was a
+//     scan. Scans rarely repeat, so we drop the contents from our mapping with
+//     MADV_DONTNEED instead of letting them crowd out the hot parts of the
+//     index.
+type accessHints struct {
+	file     tunableIndexFile
+	contents simpleSection
+}
+
+// newAccessHints returns the access hints for f, or nil if they are disabled
+// or f does not support them.
+func newAccessHints(f IndexFile, toc *indexTOC, opts shardIOOptions) *accessHints {
+	t, ok := f.(tunableIndexFile)
+	if !opts.accessHints || !ok {
+		return nil
+	}
+	return &accessHints{file: t, contents: toc.namedSections()["contents"]}
+}
+
+// advise passes a to madvise for sec if it is large enough. Hints are best
+// effort, so errors are ignored.
+func (h *accessHints) advise(sec simpleSection, a advice) {
+	if h == nil || sec.sz < minAdviseBytes {
+		return
+	}
+	if err := h.file.madvise(sec, a); err != nil {
+		return
+	}
+	switch a {
+	case adviceWillNeed:
+		shardIOCounters.willNeedBytes.Add(int64(sec.sz))
+	case adviceDontNeed:
+		shardIOCounters.dontNeedBytes.Add(int64(sec.sz))
+	}
+}
+
+// searched is called after a search of a shard with numDocs documents which
+// loaded the contents of filesLoaded of them.
+func (h *accessHints) searched(filesLoaded, numDocs int) {
+	if h == nil || filesLoaded <= numDocs/2 {
+		return
+	}
+	h.advise(h.contents, adviceDontNeed)
+}
+
+var shardIOCounters struct {
+	lockedBytes   atomic.Int64
+	willNeedBytes atomic.Int64
+	dontNeedBytes atomic.Int64
+}
+
+// ShardIOStats describes how IO tuning (see ZOEKT_SHARD_IO) affects the
+// memory of the process.
+type ShardIOStats struct {
+	// LockedBytes is the size of the sections currently locked in memory.
+	LockedBytes int64
+
+	// WillNeedBytes is the total size of the ranges read ahead because a
+	// search was about to read them.
+	WillNeedBytes int64
+
+	// DontNeedBytes is the total size of the ranges dropped from memory after
+	// a search scanned them.
+	DontNeedBytes int64
+}
+
+// ReadShardIOStats returns the current ShardIOStats.
+func ReadShardIOStats() ShardIOStats {
+	return ShardIOStats{
+		LockedBytes:   shardIOCounters.lockedBytes.Load(),
+		WillNeedBytes: shardIOCounters.willNeedBytes.Load(),
+		DontNeedBytes: shardIOCounters.dontNeedBytes.Load(),
+	}
+}
@@ -0,0 +1,170 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestParseShardIO(t *testing.T) {
+	random := adviceRandom
+	for _, tc := range []struct {
+		in      string
+		want    shardIOOptions
+		wantErr bool
+	}{
+		{in: "", want: shardIOOptions{}},
+		{
+			in: "readahead=random,madvise.postings=willneed,madvise.contents=sequential,direct=contents",
+			want: shardIOOptions{
+				readahead: &random,
+				madvise: map[string]advice{
+					"postings": adviceWillNeed,
+					"contents": adviceSequential,
+				},
+				directContents: true,
+			},
+		},
+		{in: "readahead=fast", wantErr: true},
+		{in: "madvise.bogus=random", wantErr: true},
+		{in: "direct=postings", wantErr: true},
+		{in: "readahead", wantErr: true},
+		{
+			in:   "hints=on,mlock=filenames,mlock=namepostings",
+			want: shardIOOptions{accessHints: true, mlock: []string{"filenames", "namepostings"}},
+		},
+		{in: "hints=maybe", wantErr: true},
+		{in: "mlock=bogus", wantErr: true},
+	} {
+		got, err := parseShardIO(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: got err %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTuneIO(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	p := filepath.Join(t.TempDir(), "shard")
+	if err := os.WriteFile(p, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifile, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ifile.Close()
+
+	if _, ok := ifile.(tunableIndexFile); !ok {
+		t.Skip("index file does not support IO tuning on this platform")
+	}
+
+	// Sections which are not page aligned.
+	var toc indexTOC
+	toc.fileContents.data = simpleSection{off: 100, sz: 9000}
+	toc.postings.data = simpleSection{off: 9100, sz: 5000}
+
+	tuneIO(ifile, &toc, shardIOOptions{
+		madvise:        map[string]advice{"postings": adviceRandom},
+		directContents: true,
+	})
+
+	for _, r := range []struct{ off, sz uint32 }{
+		{100, 10},   // inside contents, read with O_DIRECT
+		{4090, 20},  // crosses a page boundary
+		{9000, 100}, // end of contents
+		{9100, 50},  // postings, read from the mapping
+		{0, 0},
+	} {
+		got, err := ifile.Read(r.off, r.sz)
+		if err != nil {
+			t.Fatalf("Read(%d, %d): %v", r.off, r.sz, err)
+		}
+		if want := content[r.off : r.off+r.sz]; !bytes.Equal(got, want) {
+			t.Errorf("Read(%d, %d): got %q, want %q", r.off, r.sz, got, want)
+		}
+	}
+}
+
+func TestAccessHints(t *testing.T) {
+	// Large enough for both the contents and the posting list of "nee" to
+	// exceed minAdviseBytes.
+	b := testShardBuilder(t, nil, Document{Name: "big", Content: bytes.Repeat([]byte("needle\n"), 100_000)})
+	p := filepath.Join(t.TempDir(), "shard")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if f, err = os.Open(p); err != nil {
+		t.Fatal(err)
+	}
+	ifile, err := NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ifile.(tunableIndexFile); !ok {
+		ifile.Close()
+		t.Skip("index file does not support IO tuning on this platform")
+	}
+
+	rd := &reader{r: ifile}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		t.Fatal(err)
+	}
+	d, err := rd.readIndexData(&toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.file = ifile
+
+	opts := shardIOOptions{accessHints: true, mlock: []string{"filenames"}}
+	before := ReadShardIOStats()
+	tuneIO(ifile, &toc, opts)
+	d.hints = newAccessHints(ifile, &toc, opts)
+
+	sr, err := d.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{})
+	after := ReadShardIOStats()
+	d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Stats.FilesLoaded != 1 {
+		t.Fatalf("got %d files loaded, want 1", sr.Stats.FilesLoaded)
+	}
+
+	contents := int64(toc.namedSections()["contents"].sz)
+	if got := after.WillNeedBytes - before.WillNeedBytes; got <= contents {
+		t.Errorf("got %d bytes read ahead, want more than the %d bytes of contents", got, contents)
+	}
+	if got := after.DontNeedBytes - before.DontNeedBytes; got != contents {
+		t.Errorf("got %d bytes dropped after the scan, want %d", got, contents)
+	}
+	// mlock fails if RLIMIT_MEMLOCK is too low, which tuneIO only logs.
+	if after.LockedBytes == before.LockedBytes {
+		t.Log("filenames were not locked")
+	}
+	if got := ReadShardIOStats().LockedBytes; got != before.LockedBytes {
+		t.Errorf("got %d locked bytes after closing the shard, want %d", got, before.LockedBytes)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin
+
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestFadvise runs the platform specific parts of the IO tuning, which
+// differ between linux and darwin.
+func TestFadvise(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "shard")
+	content := bytes.Repeat([]byte("x"), 3*pageSize)
+	if err := os.WriteFile(p, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for name, a := range adviceNames {
+		if err := fadvise(f, a); err != nil {
+			t.Errorf("fadvise %s: %v", name, err)
+		}
+	}
+
+	fd, err := openDirect(p)
+	if err != nil {
+		t.Skipf("direct IO not supported by the file system: %v", err)
+	}
+	d := &directReader{fd: fd, start: 0, end: uint32(len(content))}
+	defer unix.Close(fd)
+
+	// Reads of different sizes reuse and grow the pooled buffers.
+	for _, sz := range []uint32{10, 2 * pageSize, 10} {
+		got, err := d.read(pageSize-5, sz)
+		if err != nil {
+			t.Fatalf("read %d: %v", sz, err)
+		}
+		if !bytes.Equal(got, content[pageSize-5:pageSize-5+sz]) {
+			t.Fatalf("read %d: got %d unexpected bytes", sz, len(got))
+		}
+	}
+}
+
+func TestValidateShardIO(t *testing.T) {
+	if _, err := parseShardIOEnv("direct=contents"); err != nil {
+		t.Fatalf("supported option rejected: %v", err)
+	}
+	if _, err := parseShardIOEnv("direct=postings"); err == nil {
+		t.Fatal("invalid option accepted")
+	}
+}
@@ -0,0 +1,396 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// structuralMaxSteps bounds the backtracking of a structural pattern per
+// document. Patterns with many holes can take exponential time on
+// pathological content, so documents which exceed it stop matching.
+const structuralMaxSteps = 1 << 20
+
+type structuralKind uint8
+
+const (
+	// structuralLiteral matches text exactly.
+	structuralLiteral structuralKind = iota
+	// structuralSpace matches one or more whitespace characters.
+	structuralSpace
+	// structuralHole matches balanced text, :[name].
+	structuralHole
+	// structuralIdentHole matches an identifier, :[[name]].
+	structuralIdentHole
+)
+
+// structuralTerm is a part of a structural pattern, see query.Structural.
+type structuralTerm struct {
+	kind structuralKind
+
+	// text is the text of a literal, or the name of a hole. Holes named ""
+	// or "_" don't bind, every other name must match the same text wherever
+	// it appears.
+	text string
+
+	// multiline is set for holes inside delimiters of the pattern, which
+	// may span lines like in foo(:[args]). Holes outside of delimiters stop
+	// at the end of the line.
+	multiline bool
+}
+
+// parseStructural splits a structural pattern into terms. Leading and
+// trailing whitespace is dropped, and ":[" without a matching "]" is
+// literal text.
+func parseStructural(pattern string) ([]structuralTerm, error) {
+	var terms []structuralTerm
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			terms = append(terms, structuralTerm{kind: structuralLiteral, text: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	p := strings.TrimSpace(pattern)
+	depth := 0
+	hasLiteral := false
+	for len(p) > 0 {
+		if kind, name, n := parseStructuralHole(p); n > 0 {
+			flush()
+			terms = append(terms, structuralTerm{kind: kind, text: name, multiline: depth > 0})
+			p = p[n:]
+			continue
+		}
+
+		c := p[0]
+		if isStructuralSpace(c) {
+			flush()
+			if len(terms) == 0 || terms[len(terms)-1].kind != structuralSpace {
+				terms = append(terms, structuralTerm{kind: structuralSpace})
+			}
+			p = p[1:]
+			continue
+		}
+
+		switch c {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth = max(depth-1, 0)
+		}
+		hasLiteral = true
+		lit.WriteByte(c)
+		p = p[1:]
+	}
+	flush()
+
+	if !hasLiteral {
+		return nil, fmt.Errorf("structural pattern %q must contain text besides holes", pattern)
+	}
+	return terms, nil
+}
+
+// parseStructuralHole parses a hole at the start of p, and returns its kind,
+// its name and its length, or 0 if p doesn't start with a hole.
+func parseStructuralHole(p string) (structuralKind, string, int) {
+	kind, open, close := structuralHole, ":[", "]"
+	if strings.HasPrefix(p, ":[[") {
+		kind, open, close = structuralIdentHole, ":[[", "]]"
+	} else if !strings.HasPrefix(p, open) {
+		return 0, "", 0
+	}
+	end := strings.Index(p[len(open):], close)
+	if end < 0 {
+		return 0, "", 0
+	}
+	name := p[len(open) : len(open)+end]
+	for i := 0; i < len(name); i++ {
+		if !characterClass(name[i]) {
+			return 0, "", 0
+		}
+	}
+	return kind, name, len(open) + end + len(close)
+}
+
+func isStructuralSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// structuralMatcher finds the matches of a structural pattern in a
+// document.
+type structuralMatcher struct {
+	terms []structuralTerm
+
+	// mutable
+	data  []byte
+	steps int
+	binds []structuralBind
+}
+
+type structuralBind struct {
+	name       string
+	start, end int
+}
+
+// findAll returns the start and end offsets of the non-overlapping matches
+// in data. Like comby, holes match lazily, so each match is the shortest one
+// starting at its offset.
+func (m *structuralMatcher) findAll(data []byte) [][2]int {
+	m.data, m.steps = data, 0
+	defer func() { m.data = nil }()
+
+	var found [][2]int
+	for start := 0; start < len(data); {
+		if m.terms[0].kind == structuralLiteral {
+			i := bytes.Index(data[start:], []byte(m.terms[0].text))
+			if i < 0 {
+				break
+			}
+			start += i
+		} else if start > 0 && characterClass(data[start-1]) && characterClass(data[start]) {
+			// Holes at the start of the pattern start at a token.
+			start++
+			continue
+		}
+
+		m.binds = m.binds[:0]
+		end, ok := m.match(start, 0)
+		if m.steps > structuralMaxSteps {
+			break
+		}
+		if ok && end > start {
+			found = append(found, [2]int{start, end})
+			start = end
+			continue
+		}
+		_, n := utf8.DecodeRune(data[start:])
+		start += n
+	}
+	return found
+}
+
+// match matches the terms from ti on at offset pos, and returns the end of
+// the match.
+func (m *structuralMatcher) match(pos, ti int) (int, bool) {
+	if m.steps++; m.steps > structuralMaxSteps {
+		return 0, false
+	}
+	if ti == len(m.terms) {
+		return pos, true
+	}
+
+	data := m.data
+	t := &m.terms[ti]
+	switch t.kind {
+	case structuralLiteral:
+		if !bytes.HasPrefix(data[pos:], []byte(t.text)) {
+			return 0, false
+		}
+		return m.match(pos+len(t.text), ti+1)
+
+	case structuralSpace:
+		end := pos
+		for end < len(data) && isStructuralSpace(data[end]) {
+			end++
+		}
+		if end == pos {
+			return 0, false
+		}
+		return m.match(end, ti+1)
+
+	case structuralIdentHole:
+		for end := pos + 1; end <= len(data) && characterClass(data[end-1]); end++ {
+			if e, ok := m.bindAndMatch(t, pos, end, ti); ok {
+				return e, true
+			}
+		}
+		return 0, false
+
+	default:
+		for end := pos; end >= 0; end = m.skipBalanced(end, t.multiline) {
+			if e, ok := m.bindAndMatch(t, pos, end, ti); ok {
+				return e, true
+			}
+			if m.steps > structuralMaxSteps {
+				break
+			}
+		}
+		return 0, false
+	}
+}
+
+// bindAndMatch binds data[start:end] to the hole t, and matches the terms
+// after it.
+func (m *structuralMatcher) bindAndMatch(t *structuralTerm, start, end, ti int) (int, bool) {
+	if t.text != "" && t.text != "_" {
+		for _, b := range m.binds {
+			if b.name == t.text {
+				if !bytes.Equal(m.data[b.start:b.end], m.data[start:end]) {
+					return 0, false
+				}
+				return m.match(end, ti+1)
+			}
+		}
+		m.binds = append(m.binds, structuralBind{name: t.text, start: start, end: end})
+		defer func(n int) { m.binds = m.binds[:n] }(len(m.binds) - 1)
+	}
+	return m.match(end, ti+1)
+}
+
+// skipBalanced returns the offset after the character, string or balanced
+// group of delimiters at pos, or -1 if a hole can't extend past pos: at the
+// end of data, at an unbalanced closing delimiter, or at a newline outside
+// of delimiters if multiline is false.
+func (m *structuralMatcher) skipBalanced(pos int, multiline bool) int {
+	data := m.data
+	if pos >= len(data) {
+		return -1
+	}
+	switch c := data[pos]; c {
+	case ')', ']', '}':
+		return -1
+	case '\n':
+		if !multiline {
+			return -1
+		}
+		return pos + 1
+	case '"', '`':
+		return skipStructuralString(data, pos)
+	case '(', '[', '{':
+		var stack []byte
+		for i := pos; i < len(data); i++ {
+			switch data[i] {
+			case '(':
+				stack = append(stack, ')')
+			case '[':
+				stack = append(stack, ']')
+			case '{':
+				stack = append(stack, '}')
+			case ')', ']', '}':
+				if stack[len(stack)-1] != data[i] {
+					return -1
+				}
+				stack = stack[:len(stack)-1]
+				if len(stack) == 0 {
+					return i + 1
+				}
+			case '"', '`':
+				i = skipStructuralString(data, i) - 1
+			}
+		}
+		return -1
+	default:
+		_, n := utf8.DecodeRune(data[pos:])
+		return pos + n
+	}
+}
+
+// skipStructuralString returns the offset after the string literal starting
+// with the quote at pos. Double quoted strings end at the end of the line
+// and may escape quotes with a backslash, raw strings in backquotes don't.
+// An unterminated quote is skipped as a single character.
+func skipStructuralString(data []byte, pos int) int {
+	quote := data[pos]
+	for i := pos + 1; i < len(data); i++ {
+		switch data[i] {
+		case quote:
+			return i + 1
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case '\n':
+			if quote == '"' {
+				return pos + 1
+			}
+		}
+	}
+	return pos + 1
+}
+
+// structuralMatchTree matches file contents against a structural pattern,
+// see query.Structural. Like regexpMatchTree it runs on every candidate
+// document, so newStructuralMatchTree narrows the candidates down with the
+// literal text of the pattern.
+type structuralMatchTree struct {
+	query   *query.Structural
+	matcher structuralMatcher
+
+	// mutable
+	evaluated bool
+	found     []*candidateMatch
+
+	// nextDoc, prepare.
+	bruteForceMatchTree
+}
+
+func (d *indexData) newStructuralMatchTree(q *query.Structural) (matchTree, error) {
+	terms, err := parseStructural(q.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	st := &structuralMatchTree{query: q, matcher: structuralMatcher{terms: terms}}
+
+	// Every match contains the literals of the pattern, so documents without
+	// their ngrams are skipped. Shorter literals would need a scan of every
+	// document, which the structural matcher does anyway.
+	var prefilter []matchTree
+	for _, t := range terms {
+		if t.kind != structuralLiteral || utf8.RuneCountInString(t.text) < ngramSize {
+			continue
+		}
+		mt, err := d.newSubstringMatchTree(&query.Substring{
+			Pattern:       t.text,
+			CaseSensitive: true,
+			Content:       true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		prefilter = append(prefilter, mt)
+	}
+	if len(prefilter) == 0 {
+		return st, nil
+	}
+	return &andMatchTree{
+		children: []matchTree{
+			st, &noVisitMatchTree{&andMatchTree{prefilter}},
+		},
+	}, nil
+}
+
+func (t *structuralMatchTree) prepare(doc uint32) {
+	t.found = t.found[:0]
+	t.evaluated = false
+	t.bruteForceMatchTree.prepare(doc)
+}
+
+func (t *structuralMatchTree) String() string {
+	return fmt.Sprintf("structural(%q)", t.query.Pattern)
+}
+
+func (t *structuralMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) matchesState {
+	if t.evaluated {
+		return matchesStateForSlice(t.found)
+	}
+
+	if cost < costRegexp {
+		return matchesRequiresHigherCost
+	}
+
+	found := t.found[:0]
+	for _, m := range t.matcher.findAll(cp.data(false)) {
+		found = append(found, &candidateMatch{
+			byteOffset:  uint32(m[0]),
+			byteMatchSz: uint32(m[1] - m[0]),
+		})
+	}
+	t.found = found
+	t.evaluated = true
+
+	return matchesStateForSlice(t.found)
+}
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestStructuralMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, content string
+		want             []string
+	}{
+		{"foo(:[args])", "a := foo(x, bar(y), z)\n", []string{"foo(x, bar(y), z)"}},
+		{"foo(:[args])", "foo() + foo(1)", []string{"foo()", "foo(1)"}},
+		// Holes inside delimiters span lines.
+		{"foo(:[args])", "foo(\n\t1,\n\t2,\n)", []string{"foo(\n\t1,\n\t2,\n)"}},
+		// Delimiters in strings don't count.
+		{"foo(:[args])", `foo(")", 1)`, []string{`foo(")", 1)`}},
+		// Unbalanced delimiters don't match.
+		{"foo(:[args])", "foo(a(b)", nil},
+		{"foo(:[a], :[b])", "foo(x, g(y, z))", []string{"foo(x, g(y, z))"}},
+		// Whitespace matches any whitespace.
+		{"if :[c] {", "if  x > 0\t{", []string{"if  x > 0\t{"}},
+		// Holes outside of delimiters stop at the end of the line.
+		{"if :[c] {", "if x\ny {", nil},
+		{":[[x]].Close()", "defer f.Close()", []string{"f.Close()"}},
+		{":[[x]].Close()", "a.b.Close()", []string{"b.Close()"}},
+		// Holes with the same name match the same text.
+		{":[[x]] = :[[x]]", "a = b; c = c", []string{"c = c"}},
+		{"f(:[_], :[_])", "f(a, b)", []string{"f(a, b)"}},
+		// An unterminated hole is literal text.
+		{"a:[b", "a:[b", []string{"a:[b"}},
+	} {
+		terms, err := parseStructural(tc.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.pattern, err)
+		}
+		m := structuralMatcher{terms: terms}
+		var got []string
+		for _, r := range m.findAll([]byte(tc.content)) {
+			got = append(got, tc.content[r[0]:r[1]])
+		}
+		if d := cmp.Diff(tc.want, got); d != "" {
+			t.Errorf("%q in %q: mismatch (-want +got):\n%s", tc.pattern, tc.content, d)
+		}
+	}
+}
+
+func TestParseStructuralNoText(t *testing.T) {
+	for _, pattern := range []string{":[x]", " :[x] :[[y]] "} {
+		if _, err := parseStructural(pattern); err == nil {
+			t.Errorf("%q: got no error", pattern)
+		}
+	}
+}
+
+func TestStructuralMatchBudget(t *testing.T) {
+	terms, err := parseStructural(":[a],:[b],:[c],:[d];")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := structuralMatcher{terms: terms}
+	if got := m.findAll([]byte("(" + strings.Repeat("x,", 5000))); len(got) != 0 {
+		t.Errorf("got %d matches, want none", len(got))
+	}
+}
+
+func TestSearchStructural(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "a.go", Content: []byte("func a() {\n\tregister(\"a\",\n\t\thandler)\n}\n")},
+		Document{Name: "b.go", Content: []byte("func b() {\n\tregister()\n}\n")},
+		Document{Name: "c.go", Content: []byte("func c() {\n\tregister(\n}\n")},
+	)
+	d := searcherForTest(t, b)
+
+	q, err := query.Parse("patterntype:structural register(:[x],:[y])")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "a.go" {
+		t.Fatalf("got %v, want a.go", res.Files)
+	}
+
+	var lines []string
+	for _, m := range res.Files[0].LineMatches {
+		lines = append(lines, strings.TrimSuffix(string(m.Line), "\n"))
+	}
+	if want := []string{"\tregister(\"a\",", "\t\thandler)"}; !cmp.Equal(lines, want) {
+		t.Errorf("got lines %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,90 @@
+package index
+
+import (
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sub-tokens split identifiers at underscores and other non-alphanumeric
+// characters, and at case changes: FooBarBaz, foo_bar_baz and HTTPServer
+// have the sub-tokens Foo/Bar/Baz, foo/bar/baz and HTTP/Server.
+
+func isSubTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isSubTokenBoundary returns true if a sub-token starts or ends at byte
+// offset off of content.
+func isSubTokenBoundary(content []byte, off uint32) bool {
+	if int(off) > len(content) || (int(off) < len(content) && !utf8.RuneStart(content[off])) {
+		return false
+	}
+
+	prev, next := utf8.RuneError, utf8.RuneError
+	prevWord, nextWord := false, false
+	nextSz := 0
+	if off > 0 {
+		prev, _ = utf8.DecodeLastRune(content[:off])
+		prevWord = isSubTokenRune(prev)
+	}
+	if int(off) < len(content) {
+		next, nextSz = utf8.DecodeRune(content[off:])
+		nextWord = isSubTokenRune(next)
+	}
+
+	if prevWord != nextWord {
+		return true
+	}
+	if !prevWord || !unicode.IsUpper(next) {
+		return false
+	}
+
+	// fooBar, foo2Bar
+	if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+		return true
+	}
+
+	// The last upper case letter of an acronym starts the next sub-token,
+	// eg. HTTP|Server.
+	if unicode.IsUpper(prev) {
+		after, _ := utf8.DecodeRune(content[int(off)+nextSz:])
+		return unicode.IsLower(after)
+	}
+	return false
+}
+
+// subTokenBoundaries returns the sorted byte offsets of all sub-token
+// boundaries in content.
+func subTokenBoundaries(content []byte) []uint32 {
+	var res []uint32
+	for off := 0; off <= len(content); {
+		if isSubTokenBoundary(content, uint32(off)) {
+			res = append(res, uint32(off))
+		}
+		if off == len(content) {
+			break
+		}
+		_, sz := utf8.DecodeRune(content[off:])
+		off += sz
+	}
+	return res
+}
+
+// subTokenBoundary returns true if a sub-token starts or ends at byte offset
+// off of the current document. It uses the sub-token section of the shard if
+// it has one, and otherwise inspects the contents around off.
+func (p *contentProvider) subTokenBoundary(off uint32) bool {
+	if len(p.id.subTokensIndex) == 0 {
+		return isSubTokenBoundary(p.data(false), off)
+	}
+
+	if p._subTokens == nil {
+		var sz uint32
+		p._subTokens, sz, p.err = p.id.readSubTokens(p.idx, p._subTokensBuf)
+		p._subTokensBuf = p._subTokens
+		p.stats.ContentBytesLoaded += int64(sz)
+	}
+	_, found := slices.BinarySearch(p._subTokens, off)
+	return found
+}
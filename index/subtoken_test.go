@@ -0,0 +1,153 @@
+package index
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSubTokenBoundaries(t *testing.T) {
+	// want marks the boundaries with |.
+	for in, want := range map[string]string{
+		"FooBarBaz":        "|Foo|Bar|Baz|",
+		"foo_bar_baz":      "|foo|_|bar|_|baz|",
+		"HTTPServer":       "|HTTP|Server|",
+		"parseHTTP":        "|parse|HTTP|",
+		"utf8Decode":       "|utf8|Decode|",
+		"ALL_CAPS":         "|ALL|_|CAPS|",
+		"x := fooBar()":    "|x| := |foo|Bar|()",
+		"päiväÄäni":        "|päivä|Ääni|",
+		"__init__":         "__|init|__",
+		"":                 "",
+		"  ":               "  ",
+		"getURLForÄpfel42": "|get|URL|For|Äpfel42|",
+	} {
+		var got strings.Builder
+		last := uint32(0)
+		for _, b := range subTokenBoundaries([]byte(in)) {
+			got.WriteString(in[last:b])
+			got.WriteByte('|')
+			last = b
+		}
+		got.WriteString(in[last:])
+		if got.String() != want {
+			t.Errorf("%q: got %q, want %q", in, got.String(), want)
+		}
+	}
+}
+
+func TestSubTokenSearch(t *testing.T) {
+	docs := []Document{
+		{Name: "camel.go", Content: []byte("func NewFooBarBaz() {}\n")},
+		{Name: "snake.py", Content: []byte("def new_foo_bar_baz():\n")},
+		{Name: "longer.go", Content: []byte("var FooBarBazooka int\n")},
+		{Name: "acronym.go", Content: []byte("type HTTPServer struct{}\n")},
+	}
+
+	for _, subTokens := range []bool{false, true} {
+		b := testShardBuilder(t, nil, docs...)
+		b.SubTokens = subTokens
+		s := searcherForTest(t, b)
+		if got := len(s.(*indexData).subTokensIndex) > 0; got != subTokens {
+			t.Fatalf("got sub-token section %t, want %t", got, subTokens)
+		}
+
+		for _, tc := range []struct {
+			q    query.Q
+			want []string
+		}{
+			{&query.SubToken{Pattern: "BarBaz", CaseSensitive: true}, []string{"camel.go:BarBaz"}},
+			{&query.SubToken{Pattern: "barbaz"}, []string{"camel.go:BarBaz"}},
+			{&query.SubToken{Pattern: "bar_baz"}, []string{"snake.py:bar_baz"}},
+			{&query.SubToken{Pattern: "foo"}, []string{"camel.go:Foo", "longer.go:Foo", "snake.py:foo"}},
+			{&query.SubToken{Pattern: "Server"}, []string{"acronym.go:Server"}},
+			// Shorter than an ngram.
+			{&query.SubToken{Pattern: "TP"}, nil},
+			{&query.SubToken{Pattern: "ba"}, nil},
+			{&query.SubToken{Pattern: "Baz", CaseSensitive: true}, []string{"camel.go:Baz"}},
+		} {
+			res := searchForTest(t, b, tc.q)
+			var got []string
+			for _, f := range res.Files {
+				for _, l := range f.LineMatches {
+					for _, lf := range l.LineFragments {
+						got = append(got, f.FileName+":"+string(l.Line[lf.LineOffset:lf.LineOffset+lf.MatchLength]))
+					}
+				}
+			}
+			slices.Sort(got)
+			if d := cmp.Diff(tc.want, got); d != "" {
+				t.Errorf("sub-tokens %t, %s: mismatch (-want +got):\n%s", subTokens, tc.q, d)
+			}
+		}
+	}
+}
+
+func TestMerge_SubTokens(t *testing.T) {
+	b1 := testShardBuilder(t, &zoekt.Repository{Name: "repo1"}, Document{Name: "a.go", Content: []byte("FooBar")})
+	b1.SubTokens = true
+	b2 := testShardBuilder(t, &zoekt.Repository{Name: "repo2"}, Document{Name: "b.go", Content: []byte("foo_bar")})
+
+	var ds []*indexData
+	for _, b := range []*ShardBuilder{b1, b2} {
+		ds = append(ds, searcherForTest(t, b).(*indexData))
+	}
+
+	sb, err := merge(ds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sb.SubTokens {
+		t.Fatal("merging a shard with sub-tokens should produce a shard with sub-tokens")
+	}
+
+	d := searcherForTest(t, sb).(*indexData)
+	if got := len(d.subTokensIndex) - 1; got != 2 {
+		t.Fatalf("got sub-tokens for %d documents, want 2", got)
+	}
+}
+
+func TestSubTokenScore(t *testing.T) {
+	docs := []Document{
+		{Name: "a.go", Content: []byte("type HTTPServer struct{}\n")},
+		{Name: "b.go", Content: []byte("type HTTPServers struct{}\n")},
+	}
+
+	for _, subTokens := range []bool{false, true} {
+		b := testShardBuilder(t, nil, docs...)
+		b.SubTokens = subTokens
+		s := searcherForTest(t, b)
+
+		res, err := s.Search(context.Background(), &query.Substring{Pattern: "Server", CaseSensitive: true, Content: true}, &zoekt.SearchOptions{
+			ChunkMatches: true,
+			DebugScore:   true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		debug := map[string]string{}
+		for _, f := range res.Files {
+			debug[f.FileName] = f.ChunkMatches[0].DebugScore
+		}
+		if !strings.Contains(debug["a.go"], "SubTokenMatch") {
+			t.Errorf("sub-tokens %t: got score %q for a.go, want a sub-token match", subTokens, debug["a.go"])
+		}
+		if strings.Contains(debug["b.go"], "SubTokenMatch") {
+			t.Errorf("sub-tokens %t: got score %q for b.go, want no sub-token match", subTokens, debug["b.go"])
+		}
+	}
+}
+
+func TestSubTokenMatchTree_UnexpectedChild(t *testing.T) {
+	mt := &subTokenMatchTree{child: &bruteForceMatchTree{}}
+	if got := mt.matches(nil, costMax, nil); got != matchesNone {
+		t.Fatalf("got %v, want matchesNone", got)
+	}
+}
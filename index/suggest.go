@@ -0,0 +1,192 @@
+package index
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	// maxSuggestionsPerAtom bounds the suggestions for each substring of a
+	// query.
+	maxSuggestionsPerAtom = 5
+
+	// minEditLen and maxEditLen bound the length of the substrings for which
+	// we suggest misspellings. Shorter ones match too much by accident, and
+	// longer ones need too many ngram lookups.
+	minEditLen = 4
+	maxEditLen = 32
+)
+
+// editAlphabet are the characters inserted and substituted to find the
+// identifier a misspelled substring was meant to be.
+const editAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789_-"
+
+// Suggest suggests rewrites of the substrings of q, see zoekt.Suggester.
+// For each substring it tries, in order, the lower case substring if it is
+// case sensitive, swapping hyphens and underscores, and the substrings one
+// edit away whose ngrams occur most often in this shard. Suggestions only
+// look at the ngram index, so they may still have no results.
+func (d *indexData) Suggest(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) ([]zoekt.Suggestion, error) {
+	if len(d.fileNameIndex) == 0 {
+		return nil, nil
+	}
+
+	// 🚨 SECURITY: Only suggest from shards with repositories the tenant can
+	// access, otherwise the suggestions reveal their content.
+	accessible := false
+	for _, md := range d.repoMetaData {
+		if !md.Tombstone && tenant.HasAccess(ctx, md.TenantID) {
+			accessible = true
+			break
+		}
+	}
+	if !accessible {
+		return nil, nil
+	}
+
+	s := &suggester{d: d, freqs: map[suggestNgram]uint32{}}
+	seen := map[string]bool{}
+	var suggestions []zoekt.Suggestion
+	query.VisitAtoms(q, func(q query.Q) {
+		sq, ok := q.(*query.Substring)
+		if !ok || seen[sq.Pattern] {
+			return
+		}
+		seen[sq.Pattern] = true
+		for _, r := range s.suggest(sq) {
+			suggestions = append(suggestions, zoekt.Suggestion{Pattern: sq.Pattern, Replacement: r})
+		}
+	})
+	return suggestions, nil
+}
+
+type suggestNgram struct {
+	ngram                   ngram
+	caseSensitive, fileName bool
+}
+
+// suggester memoizes the ngram frequencies of the candidates of Suggest,
+// which mostly share ngrams.
+type suggester struct {
+	d     *indexData
+	freqs map[suggestNgram]uint32
+}
+
+// suggest returns the replacements of sq.Pattern, best first.
+func (s *suggester) suggest(sq *query.Substring) []string {
+	p := sq.Pattern
+	var found []string
+	seen := map[string]bool{p: true}
+	add := func(c string) {
+		if !seen[c] && s.frequency(c, sq.FileName) > 0 {
+			found = append(found, c)
+		}
+		seen[c] = true
+	}
+
+	if sq.CaseSensitive {
+		add(strings.ToLower(p))
+	}
+	add(strings.ReplaceAll(p, "-", "_"))
+	add(strings.ReplaceAll(p, "_", "-"))
+
+	if len(found) >= maxSuggestionsPerAtom || len(p) < minEditLen || len(p) > maxEditLen || !isASCII(p) {
+		return found[:min(len(found), maxSuggestionsPerAtom)]
+	}
+
+	type edit struct {
+		replacement string
+		freq        uint32
+	}
+	var edits []edit
+	for _, c := range edits1(p) {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		if freq := s.frequency(c, sq.FileName); freq > 0 {
+			edits = append(edits, edit{c, freq})
+		}
+	}
+	// Edits which occur often are more likely to be what was meant. Among
+	// equally frequent ones, longer edits are more specific.
+	slices.SortStableFunc(edits, func(a, b edit) int {
+		if c := cmp.Compare(b.freq, a.freq); c != 0 {
+			return c
+		}
+		return cmp.Compare(len(b.replacement), len(a.replacement))
+	})
+	for _, e := range edits {
+		if len(found) >= maxSuggestionsPerAtom {
+			break
+		}
+		found = append(found, e.replacement)
+	}
+	return found
+}
+
+// frequency returns the minimum frequency of the ngrams of c, which bounds
+// the number of documents containing c. Like zoekt.Suggestion.Apply, c is
+// case sensitive if it contains upper case characters.
+func (s *suggester) frequency(c string, fileName bool) uint32 {
+	caseSensitive := c != strings.ToLower(c)
+	ngramOffs := splitNGrams([]byte(c))
+	if len(ngramOffs) == 0 {
+		return 0
+	}
+	var minFreq uint32
+	for i, o := range ngramOffs {
+		key := suggestNgram{o.ngram, caseSensitive, fileName}
+		freq, ok := s.freqs[key]
+		if !ok {
+			freq, _ = s.d.ngramFrequency(o.ngram, caseSensitive, fileName)
+			s.freqs[key] = freq
+		}
+		if i == 0 || freq < minFreq {
+			minFreq = freq
+		}
+		if minFreq == 0 {
+			break
+		}
+	}
+	return minFreq
+}
+
+// edits1 returns the strings one deletion, transposition, substitution or
+// insertion of a character of editAlphabet away from p. It leaves out
+// deleting the first or last character, which gives a substring of p.
+func edits1(p string) []string {
+	var edits []string
+	for i := 0; i <= len(p); i++ {
+		head, tail := p[:i], p[i:]
+		if i > 0 && len(tail) > 1 {
+			edits = append(edits, head+tail[1:])
+		}
+		if len(tail) > 1 {
+			edits = append(edits, head+tail[1:2]+tail[:1]+tail[2:])
+		}
+		for j := 0; j < len(editAlphabet); j++ {
+			c := editAlphabet[j : j+1]
+			if len(tail) > 0 && tail[0] != c[0] {
+				edits = append(edits, head+c+tail[1:])
+			}
+			edits = append(edits, head+c+tail)
+		}
+	}
+	return edits
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
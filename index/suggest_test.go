@@ -0,0 +1,66 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSuggest(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "server.go", Content: []byte("func registerHandler(max_count int) {}")},
+		Document{Name: "client.go", Content: []byte("registerHandler(1)\nregisterHandler(2)")},
+	)
+	d := searcherForTest(t, b).(*indexData)
+
+	for _, tc := range []struct {
+		q    string
+		want string
+	}{
+		// Misspelled identifiers.
+		{"registerHandlr", "registerHandler"},
+		{"regsiterHandler", "registerHandler"},
+		// Case sensitive substrings.
+		{"RegisterHandler", "registerhandler"},
+		// Hyphens and underscores.
+		{"max-count", "max_count"},
+		{"file:clinet", "client"},
+		// Too short to suggest edits.
+		{"fnc", ""},
+	} {
+		q, err := query.Parse(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		suggestions, err := d.Suggest(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if len(suggestions) > 0 {
+			got = suggestions[0].Replacement
+		}
+		if got != tc.want {
+			t.Errorf("%s: got suggestions %v, want %q first", tc.q, suggestions, tc.want)
+		}
+	}
+}
+
+func TestEdits1(t *testing.T) {
+	edits := map[string]bool{}
+	for _, e := range edits1("abc") {
+		edits[e] = true
+	}
+	for _, want := range []string{"ac", "bac", "acb", "xbc", "abd", "_abc", "abc-"} {
+		if !edits[want] {
+			t.Errorf("missing edit %q", want)
+		}
+	}
+	for _, unwanted := range []string{"abc", "bc", "ab"} {
+		if edits[unwanted] {
+			t.Errorf("unwanted edit %q", unwanted)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package index
+
+import (
+	"github.com/go-enry/go-enry/v2"
+	"github.com/grafana/regexp"
+)
+
+const (
+	// fileFlagTest marks documents which look like tests, see IsTestFile.
+	fileFlagTest uint8 = 1 << 0
+
+	// fileFlagGenerated marks documents added with Document.Generated, and
+	// documents which look generated, see IsGeneratedFile.
+	fileFlagGenerated uint8 = 1 << 1
+
+	// fileFlagCommit marks documents added with Document.Commit.
+	fileFlagCommit uint8 = 1 << 3
+
+	// fileFlagTruncated marks documents added with Document.Truncated.
+	fileFlagTruncated uint8 = 1 << 4
+)
+
+// testFileConventions are the test file conventions of languages which enry
+// does not fully cover. The patterns are matched against the full path.
+var testFileConventions = map[string]*regexp.Regexp{
+	"Go":         regexp.MustCompile(`_test\.go$|(^|/)testdata/`),
+	"Python":     regexp.MustCompile(`(^|/)(test_[^/]*|[^/]*_test|conftest)\.py$|(^|/)tests?/`),
+	"JavaScript": regexp.MustCompile(`\.(test|spec)\.[cm]?jsx?$|(^|/)__tests__/`),
+	"TypeScript": regexp.MustCompile(`\.(test|spec)\.[cm]?tsx?$|(^|/)__tests__/`),
+	"TSX":        regexp.MustCompile(`\.(test|spec)\.tsx$|(^|/)__tests__/`),
+	"Java":       regexp.MustCompile(`(^|/)src/test/|[^/]+(Test|Tests|IT)\.java$`),
+	"Kotlin":     regexp.MustCompile(`(^|/)src/test/|[^/]+(Test|Tests|IT)\.kts?$`),
+	"Scala":      regexp.MustCompile(`(^|/)src/test/|[^/]+(Test|Tests|Spec|Suite)\.scala$`),
+	"Ruby":       regexp.MustCompile(`_(test|spec)\.rb$|(^|/)(test|spec)/`),
+	"Rust":       regexp.MustCompile(`(^|/)tests/[^/]+\.rs$`),
+	"C#":         regexp.MustCompile(`[^/]+Tests?\.cs$|(^|/)[^/]+\.Tests?/`),
+	"PHP":        regexp.MustCompile(`[^/]+Test\.php$|(^|/)tests/`),
+	"C":          regexp.MustCompile(`(^|/)tests?/|_test\.c$`),
+	"C++":        regexp.MustCompile(`(^|/)tests?/|_(test|unittest)\.(cc|cpp|cxx)$`),
+	"Swift":      regexp.MustCompile(`(^|/)Tests/|[^/]+Tests?\.swift$`),
+	"Elixir":     regexp.MustCompile(`_test\.exs$`),
+	"Dart":       regexp.MustCompile(`_test\.dart$`),
+}
+
+// IsTestFile returns true if the file name, in the given language, follows
+// the test file conventions of that language. Languages without known
+// conventions fall back to enry.IsTest.
+func IsTestFile(name, language string) bool {
+	if enry.IsTest(name) {
+		return true
+	}
+	re, ok := testFileConventions[language]
+	return ok && re.MatchString(name)
+}
@@ -0,0 +1,90 @@
+package index
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestIsTestFile(t *testing.T) {
+	cases := []struct {
+		name, language string
+		want           bool
+	}{
+		{"pkg/server_test.go", "Go", true},
+		{"pkg/testdata/input.go", "Go", true},
+		{"pkg/server.go", "Go", false},
+		{"tests/server.py", "Python", true},
+		{"pkg/server_test.py", "Python", true},
+		{"conftest.py", "Python", true},
+		{"pkg/contest.py", "Python", false},
+		{"src/app.spec.ts", "TypeScript", true},
+		{"src/__tests__/app.js", "JavaScript", true},
+		{"src/latest.js", "JavaScript", false},
+		{"src/test/java/com/foo/ServerIT.java", "Java", true},
+		{"src/main/java/com/foo/Server.java", "Java", false},
+		{"spec/models/user_spec.rb", "Ruby", true},
+		{"tests/integration.rs", "Rust", true},
+		{"src/tests.rs", "Rust", false},
+		{"Foo.Tests/ServerTest.cs", "C#", true},
+
+		// Conventions only apply to their own language.
+		{"tests/data.json", "JSON", false},
+		{"conftest.py", "", false},
+
+		// enry covers the common conventions of all languages.
+		{"pkg/server_test.go", "", true},
+	}
+	for _, c := range cases {
+		if got := IsTestFile(c.name, c.language); got != c.want {
+			t.Errorf("IsTestFile(%q, %q) = %t, want %t", c.name, c.language, got, c.want)
+		}
+	}
+}
+
+func TestSearchTestFiles(t *testing.T) {
+	b := testShardBuilder(t, &zoekt.Repository{Name: "reponame"},
+		Document{Name: "server_test.go", Content: []byte("func needle() {}")},
+		Document{Name: "server.go", Content: []byte("func needle() {}")},
+	)
+	d := searcherForTest(t, b).(*indexData)
+
+	names := func(q query.Q) []string {
+		res, err := d.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		SortFiles(res.Files)
+
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	check := func() {
+		t.Helper()
+
+		// Test files rank below implementation code with the same match.
+		if got, want := names(&query.Substring{Pattern: "needle"}), []string{"server.go", "server_test.go"}; !cmp.Equal(got, want) {
+			t.Errorf("needle: got %v, want %v", got, want)
+		}
+		if got, want := names(query.NewAnd(&query.Substring{Pattern: "needle"}, &query.TestFile{})), []string{"server_test.go"}; !cmp.Equal(got, want) {
+			t.Errorf("needle test:only: got %v, want %v", got, want)
+		}
+		if got, want := names(query.NewAnd(&query.Substring{Pattern: "needle"}, &query.Not{Child: &query.TestFile{}})), []string{"server.go"}; !cmp.Equal(got, want) {
+			t.Errorf("needle test:no: got %v, want %v", got, want)
+		}
+	}
+
+	check()
+
+	// Shards written before the test file flags classify files by name.
+	d.fileFlags = nil
+	check()
+}
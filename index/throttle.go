@@ -0,0 +1,49 @@
+package index
+
+import (
+	"io"
+	"time"
+)
+
+// throttledWriter limits the rate of writes to w to bytesPerSec, so that
+// writing a big shard, eg. while merging, doesn't starve searches of IO.
+type throttledWriter struct {
+	w           io.Writer
+	bytesPerSec int64
+
+	start   time.Time
+	written int64
+}
+
+func newThrottledWriter(w io.Writer, bytesPerSec int64) io.Writer {
+	if bytesPerSec <= 0 {
+		return w
+	}
+	return &throttledWriter{w: w, bytesPerSec: bytesPerSec}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+
+	// We write in chunks of 1/10 of a second, so that the writes are spread
+	// out evenly even if the caller writes big buffers.
+	chunk := max(int(t.bytesPerSec/10), 1)
+	written := 0
+	for len(p) > 0 {
+		n, err := t.w.Write(p[:min(len(p), chunk)])
+		written += n
+		t.written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		due := time.Duration(float64(t.written) / float64(t.bytesPerSec) * float64(time.Second))
+		if d := due - time.Since(t.start); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,35 @@
+package index
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newThrottledWriter(&buf, 1<<20)
+
+	data := bytes.Repeat([]byte("x"), 1<<18)
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 512KiB at 1MiB/s take half a second.
+	if d := time.Since(start); d < 450*time.Millisecond {
+		t.Errorf("writing took %s, want at least 500ms", d)
+	}
+	if buf.Len() != 2*len(data) {
+		t.Errorf("wrote %d bytes, want %d", buf.Len(), 2*len(data))
+	}
+}
+
+func TestThrottledWriterUnlimited(t *testing.T) {
+	var buf bytes.Buffer
+	if w := newThrottledWriter(&buf, 0); w != &buf {
+		t.Errorf("got %T, want the writer itself", w)
+	}
+}
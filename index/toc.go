@@ -43,7 +43,21 @@ const IndexFormatVersion = 16
 // 10: Compound shards; more flexible TOC format.
 // 11: Bloom filters for file names & contents
 // 12: go-enry for identifying file languages
-const FeatureVersion = 12
+// 13: Store ctags signatures in the symbol metadata
+// 14: Flag test files
+// 15: Optional zstd compression of file contents
+// 16: Content-based language detection for ambiguous file names
+// 17: Flag files which look generated or minified
+// 18: Language statistics in the repository metadata
+//
+// Bumping FeatureVersion requires recording in featureUpgrades whether older
+// shards can be upgraded to it without reindexing.
+const FeatureVersion = 18
+
+// compressedContentsFeatureVersion is the reader feature version needed for
+// shards with compressed contents. Shards without compressed contents can
+// still be read by older readers.
+const compressedContentsFeatureVersion = 15
 
 // WriteMinFeatureVersion and ReadMinFeatureVersion constrain forwards and backwards
 // compatibility. For example, if a new way to encode filenameNgrams on disk is
@@ -70,14 +84,25 @@ const NextIndexFormatVersion = 17
 
 type indexTOC struct {
 	fileContents compoundSection
+
+	// If contents are compressed, fileContents is empty. Instead
+	// contentBlocks holds the zstd compressed blocks of the contents and
+	// contentOffsets the uncompressed offsets of the files.
+	contentBlocks  compoundSection
+	contentOffsets simpleSection
+
 	fileNames    compoundSection
 	fileSections compoundSection
 	postings     compoundSection
 	newlines     compoundSection
+	subTokens    compoundSection
 	ngramText    simpleSection
 	runeOffsets  simpleSection
 	fileEndRunes simpleSection
 	languages    simpleSection
+	fileFlags    simpleSection
+	commits      simpleSection
+	modTimes     simpleSection
 
 	fileEndSymbol  simpleSection
 	symbolMap      lazyCompoundSection
@@ -96,6 +121,27 @@ type indexTOC struct {
 	contentChecksums simpleSection
 	runeDocSections  simpleSection
 
+	// foldedNgramText and foldedPostings are empty unless the shard was
+	// built with case-folded ngrams.
+	foldedNgramText simpleSection
+	foldedPostings  compoundSection
+
+	// ngramDocFreqs holds the number of documents of each ngram of
+	// ngramText as a uint32. It is empty unless the shard was built with
+	// document frequencies.
+	ngramDocFreqs simpleSection
+
+	// identifierText holds the sorted symbol names of the shard and
+	// identifierPostings the documents defining each of them. Both are
+	// empty unless the shard was built with an identifier index.
+	identifierText     compoundSection
+	identifierPostings compoundSection
+
+	// pathOrder holds the document IDs sorted by file name, see
+	// writePathOrder. It is empty unless the shard was built with a path
+	// prefix index.
+	pathOrder simpleSection
+
 	repos simpleSection
 
 	ranks simpleSection
@@ -133,6 +179,24 @@ func (t *indexTOC) sections() []section {
 	}
 }
 
+// namedSections returns the data ranges of the sections which can be tuned
+// via ZOEKT_SHARD_IO, keyed by the name used in that variable.
+func (t *indexTOC) namedSections() map[string]simpleSection {
+	contents := t.fileContents.data
+	if t.contentOffsets.sz > 0 {
+		contents = t.contentBlocks.data
+	}
+	return map[string]simpleSection{
+		"contents":     contents,
+		"newlines":     t.newlines.data,
+		"postings":     t.postings.data,
+		"ngrams":       t.ngramText,
+		"filenames":    t.fileNames.data,
+		"namepostings": t.namePostings.data,
+		"symbols":      t.symbolMap.data,
+	}
+}
+
 func (t *indexTOC) sectionsNext() []section {
 	return append(t.sections(), &t.repos)
 }
@@ -179,8 +243,20 @@ func (t *indexTOC) sectionsTaggedList() []taggedSection {
 		{"nameEndRunes", &t.nameEndRunes},
 		{"contentChecksums", &t.contentChecksums},
 		{"languages", &t.languages},
+		{"fileFlags", &t.fileFlags},
+		{"commits", &t.commits},
+		{"modTimes", &t.modTimes},
+		{"contentBlocks", &t.contentBlocks},
+		{"contentOffsets", &t.contentOffsets},
 		{"runeDocSections", &t.runeDocSections},
 		{"repos", &t.repos},
+		{"subTokens", &t.subTokens},
+		{"foldedNgramText", &t.foldedNgramText},
+		{"foldedPostings", &t.foldedPostings},
+		{"ngramDocFreqs", &t.ngramDocFreqs},
+		{"identifierText", &t.identifierText},
+		{"identifierPostings", &t.identifierPostings},
+		{"pathOrder", &t.pathOrder},
 
 		// We no longer write these sections, but we still return them here to avoid
 		// warnings about unknown sections.
@@ -0,0 +1,125 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc64"
+	"os"
+)
+
+// VerifyShard checks the integrity of the shard at path. Unlike loading the
+// shard, it reads all of it: every section listed in the table of contents
+// must lie within the file, the items of compound sections must be in order
+// and the checksum of every document must match its content. It returns an
+// error describing the first problem found, or nil if the shard is intact.
+func VerifyShard(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	iFile, err := NewIndexFile(f)
+	if err != nil {
+		return err
+	}
+	defer iFile.Close()
+
+	return verifyShard(iFile)
+}
+
+func verifyShard(f IndexFile) error {
+	rd := &reader{r: f}
+	var toc indexTOC
+	if err := rd.readTOC(&toc); err != nil {
+		return fmt.Errorf("table of contents: %w", err)
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	for _, ts := range toc.sectionsTaggedList() {
+		if err := verifySection(f, ts.sec, size); err != nil {
+			return fmt.Errorf("section %s: %w", ts.tag, err)
+		}
+	}
+
+	d, err := rd.readIndexData(&toc)
+	if err != nil {
+		return err
+	}
+	return d.verifyChecksums()
+}
+
+// verifySection checks that sec lies within a file of the given size.
+func verifySection(f IndexFile, sec section, size uint32) error {
+	switch s := sec.(type) {
+	case *simpleSection:
+		return verifySimpleSection(*s, size)
+	case *compoundSection:
+		return verifyCompoundSection(s, size)
+	case *lazyCompoundSection:
+		// The offsets of lazy sections are only read on demand.
+		offsets, err := readSectionU32(f, s.index)
+		if err != nil {
+			return err
+		}
+		c := s.compoundSection
+		c.offsets = offsets
+		return verifyCompoundSection(&c, size)
+	default:
+		return fmt.Errorf("unknown section type %T", sec)
+	}
+}
+
+func verifySimpleSection(s simpleSection, size uint32) error {
+	if end := uint64(s.off) + uint64(s.sz); end > uint64(size) {
+		return fmt.Errorf("ends at %d, beyond the end of the file at %d", end, size)
+	}
+	return nil
+}
+
+func verifyCompoundSection(s *compoundSection, size uint32) error {
+	if err := verifySimpleSection(s.data, size); err != nil {
+		return fmt.Errorf("data %w", err)
+	}
+	if err := verifySimpleSection(s.index, size); err != nil {
+		return fmt.Errorf("index %w", err)
+	}
+
+	last := s.data.off
+	for i, o := range s.offsets {
+		if o < last || o > s.data.off+s.data.sz {
+			return fmt.Errorf("item %d at %d is out of order or outside of [%d, %d]", i, o, s.data.off, s.data.off+s.data.sz)
+		}
+		last = o
+	}
+	return nil
+}
+
+// verifyChecksums compares the checksum of every document with the checksum
+// of its content, see ShardBuilder.Add.
+func (d *indexData) verifyChecksums() error {
+	n := len(d.fileNameIndex) - 1
+	if n <= 0 {
+		return nil
+	}
+	if len(d.checksums) != n*crc64.Size {
+		return fmt.Errorf("got %d bytes of checksums, want %d for %d documents", len(d.checksums), n*crc64.Size, n)
+	}
+
+	hasher := crc64.New(crc64.MakeTable(crc64.ISO))
+	for i := 0; i < n; i++ {
+		content, err := d.readContents(uint32(i))
+		if err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+		hasher.Reset()
+		hasher.Write(content)
+		if !bytes.Equal(hasher.Sum(nil), d.getChecksum(uint32(i))) {
+			return fmt.Errorf("document %d (%s): checksum mismatch", i, d.fileName(uint32(i)))
+		}
+	}
+	return nil
+}
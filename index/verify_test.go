@@ -0,0 +1,58 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestVerifyShard(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		shards := createTestShard(t, t.TempDir(), zoekt.Repository{Name: "repo"}, 1, func(o *Options) {
+			o.CompressContents = compress
+		})
+		if err := VerifyShard(shards[0]); err != nil {
+			t.Errorf("compress=%v: intact shard: %v", compress, err)
+		}
+	}
+
+	corrupt := func(t *testing.T, f func(b []byte)) string {
+		t.Helper()
+		p := createTestShard(t, t.TempDir(), zoekt.Repository{Name: "repo"}, 1)[0]
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(b)
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	t.Run("content", func(t *testing.T) {
+		p := corrupt(t, func(b []byte) {
+			i := bytes.Index(b, bytes.Repeat([]byte("A"), 100))
+			if i < 0 {
+				t.Fatal("content not found")
+			}
+			b[i+50] = 'B'
+		})
+		err := VerifyShard(p)
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("got %v, want checksum mismatch", err)
+		}
+	})
+
+	t.Run("toc", func(t *testing.T) {
+		p := corrupt(t, func(b []byte) {
+			copy(b[len(b)-8:], bytes.Repeat([]byte{0xff}, 8))
+		})
+		if err := VerifyShard(p); err == nil {
+			t.Fatal("want error for corrupt table of contents")
+		}
+	})
+}
@@ -68,11 +68,27 @@ func (s *compoundSection) writeMap(w *writer, m map[string]uint32) {
 	s.writeStrings(w, keys)
 }
 
+// writePostings writes the ngrams of s and returns them sorted.
 func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 	charOffsets *simpleSection, postings *compoundSection, endRunes *simpleSection,
-) {
-	keys := make(ngramSlice, 0, len(s.postings))
-	for k := range s.postings {
+) ngramSlice {
+	keys := writeNgrams(w, s.postings, ngramText, postings)
+
+	charOffsets.start(w)
+	w.Write(toSizedDeltas(s.runeOffsets))
+	charOffsets.end(w)
+
+	endRunes.start(w)
+	w.Write(toSizedDeltas(s.endRunes))
+	endRunes.end(w)
+	return keys
+}
+
+// writeNgrams writes the sorted ngrams of m to ngramText and their posting
+// lists to postings. It returns the sorted ngrams.
+func writeNgrams(w *writer, m map[ngram][]byte, ngramText *simpleSection, postings *compoundSection) ngramSlice {
+	keys := make(ngramSlice, 0, len(m))
+	for k := range m {
 		keys = append(keys, k)
 	}
 	sort.Sort(keys)
@@ -87,17 +103,10 @@ func writePostings(w *writer, s *postingsBuilder, ngramText *simpleSection,
 
 	postings.start(w)
 	for _, k := range keys {
-		postings.addItem(w, s.postings[k])
+		postings.addItem(w, m[k])
 	}
 	postings.end(w)
-
-	charOffsets.start(w)
-	w.Write(toSizedDeltas(s.runeOffsets))
-	charOffsets.end(w)
-
-	endRunes.start(w)
-	w.Write(toSizedDeltas(s.endRunes))
-	endRunes.end(w)
+	return keys
 }
 
 func (b *ShardBuilder) Write(out io.Writer) error {
@@ -109,13 +118,27 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 	w := &writer{w: buffered}
 	toc := indexTOC{}
 
-	toc.fileContents.writeStrings(w, b.contentStrings)
+	minReaderVersion := WriteMinFeatureVersion
+	if b.CompressContents {
+		writeCompressedContents(w, b.contentStrings, &toc.contentBlocks, &toc.contentOffsets)
+		minReaderVersion = compressedContentsFeatureVersion
+	} else {
+		toc.fileContents.writeStrings(w, b.contentStrings)
+	}
 	toc.newlines.start(w)
 	for _, f := range b.contentStrings {
 		toc.newlines.addItem(w, toSizedDeltas(newLinesIndices(f.data)))
 	}
 	toc.newlines.end(w)
 
+	if b.SubTokens {
+		toc.subTokens.start(w)
+		for _, f := range b.contentStrings {
+			toc.subTokens.addItem(w, toSizedDeltas(subTokenBoundaries(f.data)))
+		}
+		toc.subTokens.end(w)
+	}
+
 	toc.fileEndSymbol.start(w)
 	for _, m := range b.fileEndSymbol {
 		w.U32(m)
@@ -142,10 +165,26 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 	}
 	toc.fileSections.end(w)
 
-	writePostings(w, b.contentPostings, &toc.ngramText, &toc.runeOffsets, &toc.postings, &toc.fileEndRunes)
+	contentNgrams := writePostings(w, b.contentPostings, &toc.ngramText, &toc.runeOffsets, &toc.postings, &toc.fileEndRunes)
+	if b.DocumentFrequencies {
+		toc.ngramDocFreqs.start(w)
+		for _, ng := range contentNgrams {
+			w.U32(b.contentPostings.docFreqs[ng])
+		}
+		toc.ngramDocFreqs.end(w)
+	}
+	if b.CaseFoldedNgrams {
+		writeNgrams(w, foldPostings(b.contentPostings.postings), &toc.foldedNgramText, &toc.foldedPostings)
+	}
+	if b.IdentifierIndex {
+		writeIdentifiers(w, b.contentStrings, b.docSections, &toc.identifierText, &toc.identifierPostings)
+	}
 
 	// names.
 	toc.fileNames.writeStrings(w, b.nameStrings)
+	if b.PathPrefixIndex {
+		writePathOrder(w, b.nameStrings, &toc.pathOrder)
+	}
 
 	writePostings(w, b.namePostings, &toc.nameNgramText, &toc.nameRuneOffsets, &toc.namePostings, &toc.nameEndRunes)
 
@@ -161,6 +200,18 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 	w.Write(b.languages)
 	toc.languages.end(w)
 
+	toc.fileFlags.start(w)
+	w.Write(b.fileFlags)
+	toc.fileFlags.end(w)
+
+	toc.commits.start(w)
+	w.Write(b.commits)
+	toc.commits.end(w)
+
+	toc.modTimes.start(w)
+	w.Write(marshalModTimes(b.modTimes))
+	toc.modTimes.end(w)
+
 	toc.runeDocSections.start(w)
 	w.Write(marshalDocSections(b.runeDocSections))
 	toc.runeDocSections.end(w)
@@ -180,7 +231,7 @@ func (b *ShardBuilder) Write(out io.Writer) error {
 		IndexFormatVersion:    b.indexFormatVersion,
 		IndexTime:             indexTime,
 		IndexFeatureVersion:   b.featureVersion,
-		IndexMinReaderVersion: WriteMinFeatureVersion,
+		IndexMinReaderVersion: minReaderVersion,
 		PlainASCII:            b.contentPostings.isPlainASCII && b.namePostings.isPlainASCII,
 		LanguageMap:           b.languageMap,
 		ZoektVersion:          Version,
@@ -0,0 +1,58 @@
+// Package indexer builds Zoekt shards in-process. It is the library
+// counterpart of zoekt-index, zoekt-git-index and zoekt-archive-index, for Go
+// programs which want to build shards without running those commands.
+//
+// All builds take a context. If it is done before a build finishes, the
+// shards built so far are discarded, the existing shards of the repository
+// are left untouched, and the build returns ctx.Err(). Progress is reported
+// through Options.Progress.
+package indexer
+
+import (
+	"context"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/archive"
+	"github.com/sourcegraph/zoekt/internal/gitindex"
+)
+
+// Options are the options for building the shards of a repository. The
+// shards are written to IndexDir, and RepositoryDescription.Name must be set.
+type Options = index.Options
+
+// Progress is passed to Options.Progress while building.
+type Progress = index.BuildProgress
+
+// Document is a file to index.
+type Document = index.Document
+
+// Builder builds the shards of a repository from the documents added to it.
+// Finish must be called once all documents are added, also if adding a
+// document failed.
+type Builder = index.Builder
+
+// NewBuilder returns a Builder for the repository described by opts.
+func NewBuilder(ctx context.Context, opts Options) (*Builder, error) {
+	return index.NewBuilderContext(ctx, opts)
+}
+
+// GitOptions are the options for IndexGitRepo. GitOptions.BuildOptions are
+// the Options of the shards.
+type GitOptions = gitindex.Options
+
+// IndexGitRepo indexes the branches of the git repository at
+// GitOptions.RepoDir. It returns false if GitOptions.Incremental is set and
+// the shards are already up to date.
+func IndexGitRepo(ctx context.Context, opts GitOptions) (bool, error) {
+	return gitindex.IndexGitRepo(ctx, opts)
+}
+
+// ArchiveOptions are the options for IndexArchive. ArchiveOptions.Archive is
+// the URL or path of a tar or zip archive.
+type ArchiveOptions = archive.Options
+
+// IndexArchive indexes the files of an archive as a single branch of a
+// repository.
+func IndexArchive(ctx context.Context, opts ArchiveOptions, bopts Options) error {
+	return archive.Index(ctx, opts, bopts)
+}
@@ -0,0 +1,140 @@
+package indexer_test
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/indexer"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func searchFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	ss, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	res, err := ss.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range res.Files {
+		names = append(names, f.FileName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBuilder(t *testing.T) {
+	dir := t.TempDir()
+	var progress []indexer.Progress
+	opts := indexer.Options{
+		IndexDir:              dir,
+		ShardMax:              10,
+		Parallelism:           1,
+		DisableCTags:          true,
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		Progress:              func(p indexer.Progress) { progress = append(progress, p) },
+	}
+
+	b, err := indexer.NewBuilder(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.go", "b.go"} {
+		if err := b.Add(indexer.Document{Name: name, Content: []byte("package main")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []indexer.Progress{
+		{Documents: 1, Bytes: 12, Shards: 1},
+		{Documents: 2, Bytes: 24, Shards: 2},
+		{Documents: 2, Bytes: 24, Shards: 2},
+	}
+	if d := cmp.Diff(want, progress); d != "" {
+		t.Errorf("progress mismatch (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff([]string{"a.go", "b.go"}, searchFiles(t, dir)); d != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", d)
+	}
+
+	// A cancelled build leaves the existing shards alone.
+	ctx, cancel := context.WithCancel(context.Background())
+	opts.Progress = nil
+	b, err = indexer.NewBuilder(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(indexer.Document{Name: "c.go", Content: []byte("package main")}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := b.Add(indexer.Document{Name: "d.go", Content: []byte("package main")}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v from Add after cancel, want context.Canceled", err)
+	}
+	if err := b.Finish(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v from Finish after cancel, want context.Canceled", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("cancelled build left temporary files %v", tmp)
+	}
+	if d := cmp.Diff([]string{"a.go", "b.go"}, searchFiles(t, dir)); d != "" {
+		t.Errorf("files mismatch after cancelled build (-want +got):\n%s", d)
+	}
+}
+
+func TestIndexArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for name, content := range map[string]string{"main.go": "package main", "README.md": "hello"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dir := t.TempDir()
+	opts := indexer.ArchiveOptions{Archive: path, Name: "repo", Branch: "main"}
+	bopts := indexer.Options{IndexDir: dir, DisableCTags: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := indexer.IndexArchive(ctx, opts, bopts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v from a cancelled build, want context.Canceled", err)
+	}
+	if files := searchFiles(t, dir); len(files) != 0 {
+		t.Fatalf("cancelled build indexed %v", files)
+	}
+
+	if err := indexer.IndexArchive(context.Background(), opts, bopts); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]string{"README.md", "main.go"}, searchFiles(t, dir)); d != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", d)
+	}
+}
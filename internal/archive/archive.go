@@ -12,6 +12,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type Archive interface {
@@ -111,6 +113,10 @@ func newZipArchive(r io.Reader, closer io.Closer) (*zipArchive, error) {
 	}, nil
 }
 
+// zstdMagic is the magic number of zstd frames, which
+// http.DetectContentType does not know about.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
 func detectContentType(r io.Reader) (string, io.Reader, error) {
 	var buf [512]byte
 	n, err := io.ReadFull(r, buf[:])
@@ -119,6 +125,9 @@ func detectContentType(r io.Reader) (string, io.Reader, error) {
 	}
 
 	ct := http.DetectContentType(buf[:n])
+	if bytes.HasPrefix(buf[:n], zstdMagic) {
+		ct = "application/zstd"
+	}
 
 	// If we are a seeker, we can just undo our read
 	if s, ok := r.(io.Seeker); ok {
@@ -157,16 +166,22 @@ func OpenReader(u string) (io.ReadCloser, error) {
 	return os.Open(u)
 }
 
-// openArchive opens the tar at the URL or filepath u. Also supported is tgz
-// files over http.
-func openArchive(u string) (ar Archive, err error) {
+// openArchive opens the archive at the URL or filepath u, or stdin if u is
+// "-". format is "tar", "zip" or empty to detect the format. Compression of
+// tar files with gzip or zstd is detected in either case.
+func openArchive(u, format string) (ar Archive, err error) {
+	if format != "" && format != "tar" && format != "zip" {
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+
 	readCloser, err := OpenReader(u)
 	if err != nil {
 		return nil, err
 	}
+	var closer io.Closer = readCloser
 	defer func() {
 		if err != nil {
-			_ = readCloser.Close()
+			_ = closer.Close()
 		}
 	}()
 
@@ -174,6 +189,11 @@ func openArchive(u string) (ar Archive, err error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if format == "zip" || (format == "" && ct == "application/zip") {
+		return newZipArchive(r, readCloser)
+	}
+
 	switch ct {
 	case "application/x-gzip":
 		r, err = gzip.NewReader(r)
@@ -181,12 +201,24 @@ func openArchive(u string) (ar Archive, err error) {
 			return nil, err
 		}
 
-	case "application/zip":
-		return newZipArchive(r, readCloser)
+	case "application/zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+		closer = closerFunc(func() error {
+			zr.Close()
+			return readCloser.Close()
+		})
 	}
 
 	return &tarArchive{
-		Closer: readCloser,
+		Closer: closer,
 		tr:     tar.NewReader(r),
 	}, nil
 }
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -16,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/index"
 	"github.com/sourcegraph/zoekt/internal/shards"
@@ -65,6 +66,21 @@ func writeArchive(w io.Writer, format string, files map[string]string) (err erro
 		format = "tar"
 	}
 
+	if format == "tzst" {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err2 := zw.Close()
+			if err == nil {
+				err = err2
+			}
+		}()
+		w = zw
+		format = "tar"
+	}
+
 	if format != "tar" {
 		return errors.New("expected tar")
 	}
@@ -98,7 +114,7 @@ func writeArchive(w io.Writer, format string, files map[string]string) (err erro
 // -incremental=true option changing the options between indexes and ensuring
 // the results change as expected.
 func TestIndexIncrementally(t *testing.T) {
-	for _, format := range []string{"tar", "tgz", "zip"} {
+	for _, format := range []string{"tar", "tgz", "tzst", "zip"} {
 		t.Run(format, func(t *testing.T) {
 			testIndexIncrementally(t, format)
 		})
@@ -174,7 +190,7 @@ func testIndexIncrementally(t *testing.T, format string) {
 			Strip:       0,
 		}
 
-		if err := Index(opts, bopts); err != nil {
+		if err := Index(context.Background(), opts, bopts); err != nil {
 			t.Fatalf("error creating index: %v", err)
 		}
 
@@ -204,7 +220,7 @@ func testIndexIncrementally(t *testing.T, format string) {
 // TestLatestCommitDate tests that the latest commit date is set correctly if
 // the mod time of the files has been set during the archive creation.
 func TestLatestCommitDate(t *testing.T) {
-	for _, format := range []string{"tar", "tgz", "zip"} {
+	for _, format := range []string{"tar", "tgz", "tzst", "zip"} {
 		t.Run(format, func(t *testing.T) {
 			testLatestCommitDate(t, format)
 		})
@@ -243,7 +259,7 @@ func testLatestCommitDate(t *testing.T, format string) {
 		Commit:  "cccccccccccccccccccccccccccccccccccccccc",
 	}
 
-	err = Index(opts, bopts)
+	err = Index(context.Background(), opts, bopts)
 	require.NoError(t, err)
 
 	// Read the metadata of the index we just created and check the latest commit date.
@@ -258,3 +274,49 @@ func testLatestCommitDate(t *testing.T, format string) {
 	require.Len(t, repos, 1)
 	require.True(t, repos[0].LatestCommitDate.Equal(modTime))
 }
+
+// TestIndexStdin tests indexing an archive piped to stdin, which unlike a file
+// can't be seeked.
+func TestIndexStdin(t *testing.T) {
+	for _, format := range []string{"tar", "tgz", "tzst"} {
+		t.Run(format, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			require.NoError(t, err)
+
+			oldStdin := os.Stdin
+			os.Stdin = r
+			defer func() { os.Stdin = oldStdin }()
+
+			files := map[string]string{"F0": "hello world", "F1": "goodbye"}
+			writeErr := make(chan error, 1)
+			go func() {
+				writeErr <- writeArchive(w, format, files)
+				w.Close()
+			}()
+
+			indexDir := t.TempDir()
+			opts := Options{
+				Archive: "-",
+				Format:  "tar",
+				Name:    "repo",
+				Branch:  "master",
+			}
+			require.NoError(t, Index(context.Background(), opts, index.Options{IndexDir: indexDir}))
+			require.NoError(t, <-writeErr)
+
+			ss, err := shards.NewDirectorySearcher(indexDir)
+			require.NoError(t, err)
+			defer ss.Close()
+
+			result, err := ss.Search(context.Background(), &query.Substring{Pattern: "world"}, &zoekt.SearchOptions{})
+			require.NoError(t, err)
+			require.Len(t, result.Files, 1)
+			require.Equal(t, "F0", result.Files[0].FileName)
+		})
+	}
+}
+
+func TestOpenArchive_UnsupportedFormat(t *testing.T) {
+	_, err := openArchive("-", "rar")
+	require.ErrorContains(t, err, "unsupported archive format")
+}
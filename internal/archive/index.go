@@ -2,6 +2,7 @@
 package archive
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -17,7 +18,13 @@ import (
 type Options struct {
 	Incremental bool
 
+	// Archive is the URL or path of the archive, or "-" to read it from
+	// stdin.
 	Archive string
+	// Format is the format of the archive, "tar" or "zip". If empty, it is
+	// detected.
+	Format string
+
 	Name    string
 	RepoURL string
 	Branch  string
@@ -73,8 +80,10 @@ func (o *Options) SetDefaults() {
 	}
 }
 
-// Index archive specified in opts using bopts.
-func Index(opts Options, bopts index.Options) error {
+// Index archive specified in opts using bopts. If ctx is done before
+// indexing finishes, the shards built so far are discarded and ctx.Err() is
+// returned.
+func Index(ctx context.Context, opts Options, bopts index.Options) error {
 	opts.SetDefaults()
 
 	if opts.Name == "" && opts.RepoURL == "" {
@@ -107,7 +116,7 @@ func Index(opts Options, bopts index.Options) error {
 		return nil
 	}
 
-	a, err := openArchive(opts.Archive)
+	a, err := openArchive(opts.Archive, opts.Format)
 	if err != nil {
 		return err
 	}
@@ -124,7 +133,7 @@ func Index(opts Options, bopts index.Options) error {
 		once.Do(func() {
 			// We use the ModTime of the first file as a proxy for the latest commit date.
 			bopts.RepositoryDescription.LatestCommitDate = f.ModTime
-			builder, onceErr = index.NewBuilder(bopts)
+			builder, onceErr = index.NewBuilderContext(ctx, bopts)
 		})
 		if onceErr != nil {
 			return onceErr
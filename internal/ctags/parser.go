@@ -77,6 +77,15 @@ func (lp *CTagsParser) Parse(name string, content []byte, typ CTagsParserType) (
 }
 
 func (lp *CTagsParser) newParserProcess(typ CTagsParserType) (goctags.Parser, error) {
+	if typ == TreeSitterCTags {
+		// tree-sitter runs in process, so there is no binary to start.
+		parser, err := newTreeSitterParser()
+		if err != nil {
+			return nil, err
+		}
+		return parser, nil
+	}
+
 	bin := lp.bins[typ]
 	if bin == "" {
 		// This happens if CTagsMustSucceed is false and we didn't find the binary
@@ -16,6 +16,7 @@ package ctags
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
@@ -28,6 +29,9 @@ const (
 	NoCTags
 	UniversalCTags
 	ScipCTags
+	// TreeSitterCTags finds symbols with the tree-sitter grammars compiled
+	// into zoekt instead of an external binary, see TreeSitterSupported.
+	TreeSitterCTags
 )
 
 const debug = false
@@ -44,6 +48,8 @@ func ParserToString(parser CTagsParserType) string {
 		return "universal"
 	case ScipCTags:
 		return "scip"
+	case TreeSitterCTags:
+		return "tree-sitter"
 	default:
 		panic("Reached impossible CTagsParserType state")
 	}
@@ -57,6 +63,8 @@ func StringToParser(str string) CTagsParserType {
 		return UniversalCTags
 	case "scip":
 		return ScipCTags
+	case "tree-sitter":
+		return TreeSitterCTags
 	default:
 		return UniversalCTags
 	}
@@ -64,6 +72,19 @@ func StringToParser(str string) CTagsParserType {
 
 type ParserBinMap map[CTagsParserType]string
 
+var errTreeSitterDisabled = errors.New("tree-sitter symbols are not supported by this binary, build it with -tags treesitter")
+
+// UsesTreeSitter returns true if languageMap selects the tree-sitter parser
+// for any language.
+func UsesTreeSitter(languageMap LanguageMap) bool {
+	for _, parserType := range languageMap {
+		if parserType == TreeSitterCTags {
+			return true
+		}
+	}
+	return false
+}
+
 func NewParserBinMap(
 	ctagsPath string,
 	scipCTagsPath string,
@@ -78,6 +99,9 @@ func NewParserBinMap(
 			break
 		}
 	}
+	if UsesTreeSitter(languageMap) && !TreeSitterSupported && cTagsMustSucceed {
+		return nil, fmt.Errorf("ctags.NewParserBinMap: %w", errTreeSitterDisabled)
+	}
 
 	for parserType, bin := range requiredBins {
 		if bin == "" && cTagsMustSucceed {
@@ -0,0 +1,289 @@
+//go:build treesitter
+
+package ctags
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/java"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/kotlin"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+)
+
+// TreeSitterSupported is true if zoekt was built with the treesitter build
+// tag, which compiles in the grammars of the tree-sitter parser.
+const TreeSitterSupported = true
+
+// treeSitterGrammar describes how to find the symbols of a language. Each
+// pattern captures a definition as @definition.<kind> and its name as @name.
+type treeSitterGrammar struct {
+	language string
+	grammar  func() *sitter.Language
+	patterns []string
+}
+
+var (
+	javascriptPatterns = []string{
+		`(function_declaration name: (identifier) @name) @definition.function`,
+		`(generator_function_declaration name: (identifier) @name) @definition.function`,
+		`(class_declaration name: (identifier) @name) @definition.class`,
+		`(method_definition name: (property_identifier) @name) @definition.method`,
+		`(variable_declarator name: (identifier) @name value: [(arrow_function) (function)]) @definition.function`,
+		`(variable_declarator name: (identifier) @name value: [(arrow_function) (function_expression)]) @definition.function`,
+	}
+
+	typescriptPatterns = append([]string{
+		`(class_declaration name: (type_identifier) @name) @definition.class`,
+		`(abstract_class_declaration name: (type_identifier) @name) @definition.class`,
+		`(interface_declaration name: (type_identifier) @name) @definition.interface`,
+		`(type_alias_declaration name: (type_identifier) @name) @definition.typealias`,
+		`(enum_declaration name: (identifier) @name) @definition.enum`,
+		`(public_field_definition name: (property_identifier) @name) @definition.field`,
+		`(abstract_method_signature name: (property_identifier) @name) @definition.method`,
+		`(method_signature name: (property_identifier) @name) @definition.method`,
+	}, javascriptPatterns...)
+)
+
+// treeSitterGrammars maps file extensions to grammars. The first pattern that
+// matches a name wins, so more specific patterns come first.
+var treeSitterGrammars = map[string]*treeSitterGrammar{
+	".go": {
+		language: "Go",
+		grammar:  golang.GetLanguage,
+		patterns: []string{
+			`(function_declaration name: (identifier) @name) @definition.function`,
+			`(method_declaration name: (field_identifier) @name) @definition.method`,
+			`(type_spec name: (type_identifier) @name type: (struct_type)) @definition.struct`,
+			`(type_spec name: (type_identifier) @name type: (interface_type)) @definition.interface`,
+			`(type_spec name: (type_identifier) @name) @definition.type`,
+			`(type_alias name: (type_identifier) @name) @definition.typealias`,
+			`(field_declaration name: (field_identifier) @name) @definition.field`,
+			`(source_file (const_declaration (const_spec name: (identifier) @name) @definition.constant))`,
+			`(source_file (var_declaration (var_spec name: (identifier) @name) @definition.variable))`,
+		},
+	},
+	".java": {
+		language: "Java",
+		grammar:  java.GetLanguage,
+		patterns: []string{
+			`(class_declaration name: (identifier) @name) @definition.class`,
+			`(record_declaration name: (identifier) @name) @definition.class`,
+			`(interface_declaration name: (identifier) @name) @definition.interface`,
+			`(enum_declaration name: (identifier) @name) @definition.enum`,
+			`(enum_constant name: (identifier) @name) @definition.enumConstant`,
+			`(method_declaration name: (identifier) @name) @definition.method`,
+			`(constructor_declaration name: (identifier) @name) @definition.method`,
+			`(field_declaration declarator: (variable_declarator name: (identifier) @name)) @definition.field`,
+		},
+	},
+	".js": {
+		language: "JavaScript",
+		grammar:  javascript.GetLanguage,
+		patterns: javascriptPatterns,
+	},
+	".kt": {
+		language: "Kotlin",
+		grammar:  kotlin.GetLanguage,
+		patterns: []string{
+			`(class_declaration (type_identifier) @name) @definition.class`,
+			`(object_declaration (type_identifier) @name) @definition.object`,
+			`(function_declaration (simple_identifier) @name) @definition.function`,
+			`(property_declaration (variable_declaration (simple_identifier) @name)) @definition.variable`,
+			`(type_alias (type_identifier) @name) @definition.typealias`,
+		},
+	},
+	".py": {
+		language: "Python",
+		grammar:  python.GetLanguage,
+		patterns: []string{
+			`(class_definition name: (identifier) @name) @definition.class`,
+			`(function_definition name: (identifier) @name) @definition.function`,
+		},
+	},
+	".ts": {
+		language: "TypeScript",
+		grammar:  typescript.GetLanguage,
+		patterns: typescriptPatterns,
+	},
+	".tsx": {
+		language: "TSX",
+		grammar:  tsx.GetLanguage,
+		patterns: typescriptPatterns,
+	},
+}
+
+func init() {
+	for ext, alias := range map[string]string{
+		".cjs": ".js",
+		".jsx": ".js",
+		".mjs": ".js",
+		".kts": ".kt",
+		".pyi": ".py",
+		".cts": ".ts",
+		".mts": ".ts",
+	} {
+		treeSitterGrammars[ext] = treeSitterGrammars[alias]
+	}
+}
+
+// treeSitterQuery is a compiled treeSitterGrammar.
+type treeSitterQuery struct {
+	language string
+	grammar  *sitter.Language
+	query    *sitter.Query
+}
+
+// treeSitterParser finds symbols with the tree-sitter grammars compiled into
+// zoekt, so it doesn't need an external binary. Like the other parsers, it
+// is only safe for single-threaded use.
+type treeSitterParser struct {
+	parser  *sitter.Parser
+	queries map[*treeSitterGrammar]*treeSitterQuery
+}
+
+func newTreeSitterParser() (*treeSitterParser, error) {
+	return &treeSitterParser{
+		parser:  sitter.NewParser(),
+		queries: map[*treeSitterGrammar]*treeSitterQuery{},
+	}, nil
+}
+
+// compile compiles the patterns of g into a single query. Patterns which
+// don't compile, eg. because a node type was renamed in the grammar, are
+// skipped rather than failing all files of the language.
+func (p *treeSitterParser) compile(g *treeSitterGrammar) (*treeSitterQuery, error) {
+	if q, ok := p.queries[g]; ok {
+		return q, nil
+	}
+
+	lang := g.grammar()
+	var valid []string
+	for _, pattern := range g.patterns {
+		q, err := sitter.NewQuery([]byte(pattern), lang)
+		if err != nil {
+			continue
+		}
+		q.Close()
+		valid = append(valid, pattern)
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("tree-sitter: no valid symbol patterns for %s", g.language)
+	}
+
+	query, err := sitter.NewQuery([]byte(strings.Join(valid, "\n")), lang)
+	if err != nil {
+		return nil, fmt.Errorf("tree-sitter: %s: %w", g.language, err)
+	}
+	q := &treeSitterQuery{language: g.language, grammar: lang, query: query}
+	p.queries[g] = q
+	return q, nil
+}
+
+// treeSitterDefinition is a symbol found by a query.
+type treeSitterDefinition struct {
+	entry      *Entry
+	start, end uint32
+	pattern    uint16
+}
+
+func (p *treeSitterParser) Parse(name string, content []byte) ([]*Entry, error) {
+	g, ok := treeSitterGrammars[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return nil, nil
+	}
+	q, err := p.compile(g)
+	if err != nil {
+		return nil, err
+	}
+
+	p.parser.SetLanguage(q.grammar)
+	tree, err := p.parser.ParseCtx(context.Background(), nil, content)
+	if err != nil {
+		return nil, fmt.Errorf("tree-sitter: %s: %w", name, err)
+	}
+	defer tree.Close()
+
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+	cursor.Exec(q.query, tree.RootNode())
+
+	// Several patterns can match the same definition, eg. a Go struct is also
+	// a type. Keep the first pattern, which is the most specific one.
+	byName := map[uint32]*treeSitterDefinition{}
+	for {
+		m, ok := cursor.NextMatch()
+		if !ok {
+			break
+		}
+
+		var def treeSitterDefinition
+		var nameNode *sitter.Node
+		for _, c := range m.Captures {
+			capture := q.query.CaptureNameForId(c.Index)
+			if capture == "name" {
+				nameNode = c.Node
+			} else if kind, ok := strings.CutPrefix(capture, "definition."); ok {
+				def.entry = &Entry{Kind: kind}
+				def.start, def.end = c.Node.StartByte(), c.Node.EndByte()
+			}
+		}
+		if nameNode == nil || def.entry == nil {
+			continue
+		}
+		def.entry.Name = nameNode.Content(content)
+		def.entry.Path = name
+		def.entry.Line = int(nameNode.StartPoint().Row) + 1
+		def.entry.Language = q.language
+		def.pattern = m.PatternIndex
+
+		if prev, ok := byName[nameNode.StartByte()]; ok && prev.pattern <= def.pattern {
+			continue
+		}
+		byName[nameNode.StartByte()] = &def
+	}
+
+	defs := make([]*treeSitterDefinition, 0, len(byName))
+	for _, d := range byName {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].start != defs[j].start {
+			return defs[i].start < defs[j].start
+		}
+		return defs[i].end > defs[j].end
+	})
+
+	// The parent of a definition is the innermost definition around it, eg.
+	// the class of a method.
+	var stack []*treeSitterDefinition
+	entries := make([]*Entry, 0, len(defs))
+	for _, d := range defs {
+		for len(stack) > 0 && stack[len(stack)-1].end <= d.start {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1].entry
+			d.entry.Parent = parent.Name
+			d.entry.ParentKind = parent.Kind
+		}
+		stack = append(stack, d)
+		entries = append(entries, d.entry)
+	}
+	return entries, nil
+}
+
+func (p *treeSitterParser) Close() {
+	for _, q := range p.queries {
+		q.query.Close()
+	}
+	p.parser.Close()
+}
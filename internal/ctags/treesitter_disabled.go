@@ -0,0 +1,21 @@
+//go:build !treesitter
+
+package ctags
+
+// TreeSitterSupported is true if zoekt was built with the treesitter build
+// tag, which compiles in the grammars of the tree-sitter parser.
+const TreeSitterSupported = false
+
+// treeSitterParser is a placeholder for the tree-sitter parser, which needs
+// cgo and is only built with the treesitter build tag.
+type treeSitterParser struct{}
+
+func newTreeSitterParser() (*treeSitterParser, error) {
+	return nil, errTreeSitterDisabled
+}
+
+func (p *treeSitterParser) Parse(name string, content []byte) ([]*Entry, error) {
+	return nil, errTreeSitterDisabled
+}
+
+func (p *treeSitterParser) Close() {}
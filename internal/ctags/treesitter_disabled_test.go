@@ -0,0 +1,25 @@
+//go:build !treesitter
+
+package ctags
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTreeSitterDisabled(t *testing.T) {
+	if got := StringToParser(ParserToString(TreeSitterCTags)); got != TreeSitterCTags {
+		t.Fatalf("got parser type %d, want %d", got, TreeSitterCTags)
+	}
+
+	p := NewCTagsParser(nil)
+	defer p.Close()
+	if _, err := p.Parse("main.go", []byte("package main"), TreeSitterCTags); !errors.Is(err, errTreeSitterDisabled) {
+		t.Fatalf("got error %v, want %v", err, errTreeSitterDisabled)
+	}
+
+	languageMap := LanguageMap{"go": TreeSitterCTags}
+	if _, err := NewParserBinMap("", "", languageMap, true); !errors.Is(err, errTreeSitterDisabled) {
+		t.Fatalf("NewParserBinMap: got error %v, want %v", err, errTreeSitterDisabled)
+	}
+}
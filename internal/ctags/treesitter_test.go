@@ -0,0 +1,84 @@
+//go:build treesitter
+
+package ctags
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestTreeSitter(t *testing.T) {
+	p := NewCTagsParser(nil)
+	defer p.Close()
+
+	cases := []struct {
+		name    string
+		content string
+		want    []*Entry
+	}{
+		{
+			name: "main.go",
+			content: `package main
+
+type Server struct {
+	Addr string
+}
+
+func (s *Server) Serve() {}
+
+func main() {}
+`,
+			want: []*Entry{
+				{Name: "Server", Line: 3, Kind: "struct"},
+				{Name: "Addr", Line: 4, Kind: "field", Parent: "Server", ParentKind: "struct"},
+				{Name: "Serve", Line: 7, Kind: "method"},
+				{Name: "main", Line: 9, Kind: "function"},
+			},
+		},
+		{
+			name: "Button.tsx",
+			content: `interface Props {
+  label: string
+}
+
+export const Button = (props: Props) => <button>{props.label}</button>
+
+class Store {
+  load() {}
+}
+`,
+			want: []*Entry{
+				{Name: "Props", Line: 1, Kind: "interface"},
+				{Name: "Button", Line: 5, Kind: "function"},
+				{Name: "Store", Line: 7, Kind: "class"},
+				{Name: "load", Line: 8, Kind: "method", Parent: "Store", ParentKind: "class"},
+			},
+		},
+		{
+			name: "build.gradle.kts",
+			content: `fun versionName(): String = "1.0"
+`,
+			want: []*Entry{
+				{Name: "versionName", Line: 1, Kind: "function"},
+			},
+		},
+		{
+			name:    "README.md",
+			content: "# not code",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := p.Parse(tc.name, []byte(tc.content), TreeSitterCTags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.want, got, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(Entry{}, "Path", "Language")); d != "" {
+				t.Errorf("mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+}
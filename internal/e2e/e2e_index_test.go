@@ -442,15 +442,10 @@ func TestUpdate(t *testing.T) {
 		}
 	}
 
-	// This is ugly, and potentially flaky, but there is no
-	// observable synchronization for the Sharded searcher, so
-	// this is the best we can do.
-	time.Sleep(100 * time.Millisecond)
-
-	ctx = context.Background()
-	if repos, err = ss.List(ctx, &query.Repo{Regexp: regexp.MustCompile("repo")}, nil); err != nil {
-		t.Fatalf("List: %v", err)
-	} else if len(repos.Repos) != 2 {
+	// There is no observable synchronization for the Sharded searcher, so we
+	// poll until the watcher, which waits a little after changes, loaded the
+	// new shard.
+	if repos = waitForRepos(t, ss, 2); len(repos.Repos) != 2 {
 		t.Errorf("List(repo): got %v, want 2 repos", repos.Repos)
 	}
 
@@ -461,12 +456,7 @@ func TestUpdate(t *testing.T) {
 		}
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
-	ctx = context.Background()
-	if repos, err = ss.List(ctx, &query.Repo{Regexp: regexp.MustCompile("repo")}, nil); err != nil {
-		t.Fatalf("List: %v", err)
-	} else if len(repos.Repos) != 1 {
+	if repos = waitForRepos(t, ss, 1); len(repos.Repos) != 1 {
 		var ss []string
 		for _, r := range repos.Repos {
 			ss = append(ss, r.Repository.Name)
@@ -475,6 +465,21 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+// waitForRepos lists the repositories matching "repo" until there are want of
+// them, or a few seconds passed.
+func waitForRepos(t *testing.T, ss zoekt.Searcher, want int) *zoekt.RepoList {
+	t.Helper()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		repos, err := ss.List(context.Background(), &query.Repo{Regexp: regexp.MustCompile("repo")}, nil)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(repos.Repos) == want || time.Since(start) > 5*time.Second {
+			return repos
+		}
+	}
+}
+
 func TestDeleteOldShards(t *testing.T) {
 	dir := t.TempDir()
 
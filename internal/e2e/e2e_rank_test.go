@@ -247,7 +247,7 @@ func indexURL(indexDir, u string) error {
 	//	languageMap[lang] = ctags.ScipCTags
 	// }
 
-	err := archive.Index(opts, index.Options{
+	err := archive.Index(context.Background(), opts, index.Options{
 		IndexDir:         indexDir,
 		CTagsMustSucceed: true,
 		RepositoryDescription: zoekt.Repository{
@@ -0,0 +1,566 @@
+// Package federation implements a zoekt.Streamer which fans out requests to
+// a set of remote zoekt-webserver instances and merges their responses. This
+// allows spreading a large corpus of shards over multiple hosts while still
+// exposing a single search endpoint.
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/grpc/checksum"
+	"github.com/sourcegraph/zoekt/grpc/messagesize"
+	"github.com/sourcegraph/zoekt/grpc/propagator"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/trace"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Searcher fans out Search, StreamSearch, List and StreamList to all backends.
+//
+// A backend which fails is reported as a crash in the stats of the merged
+// response rather than failing the whole request, mirroring how
+// shards.shardedSearcher treats a crashing shard.
+type Searcher struct {
+	backends []*backend
+}
+
+var (
+	_ zoekt.Streamer        = (*Searcher)(nil)
+	_ zoekt.ListStreamer    = (*Searcher)(nil)
+	_ zoekt.DocumentFetcher = (*Searcher)(nil)
+)
+
+// NewSearcher returns a Searcher which talks gRPC to the zoekt-webserver
+// instances listening on addrs (host:port). Connections are established
+// lazily. additionalOpts are appended to the default dial options.
+func NewSearcher(addrs []string, additionalOpts ...grpc.DialOption) (*Searcher, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("federation: no backends specified")
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainStreamInterceptor(
+			messagesize.StreamClientInterceptor,
+			propagator.StreamClientPropagator(tenant.Propagator{}),
+			checksum.StreamClientInterceptor,
+		),
+		grpc.WithChainUnaryInterceptor(
+			messagesize.UnaryClientInterceptor,
+			propagator.UnaryClientPropagator(tenant.Propagator{}),
+		),
+	}
+	opts = append(opts, additionalOpts...)
+
+	// Ensure that the message size options are set last, so they override any
+	// other client-specific options that tweak the message size.
+	opts = append(opts, messagesize.MustGetClientMessageSizeFromEnv()...)
+
+	s := &Searcher{}
+	for _, addr := range addrs {
+		cc, err := grpc.NewClient(addr, opts...)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("dialing %q: %w", addr, err)
+		}
+		s.backends = append(s.backends, &backend{
+			addr:          addr,
+			cc:            cc,
+			client:        proto.NewWebserverServiceClient(cc),
+			repoURLs:      map[string]string{},
+			lineFragments: map[string]string{},
+		})
+	}
+
+	return s, nil
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	tr, ctx := trace.New(ctx, "federation.Search", "")
+	defer tr.Finish()
+
+	start := time.Now()
+
+	results := make([]*zoekt.SearchResult, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+	for i, b := range s.backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			results[i], errs[i] = b.search(ctx, q, opts)
+		}(i, b)
+	}
+	wg.Wait()
+
+	agg := &zoekt.SearchResult{
+		RepoURLs:      map[string]string{},
+		LineFragments: map[string]string{},
+	}
+	for i, r := range results {
+		if err := errs[i]; err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("[ERROR] federation: search on %s failed: %v", s.backends[i].addr, err)
+			tr.LazyPrintf("backend %s: %v", s.backends[i].addr, err)
+			agg.Stats.Crashes++
+			continue
+		}
+
+		agg.Stats.Add(r.Stats)
+		agg.Files = append(agg.Files, r.Files...)
+		for k, v := range r.RepoURLs {
+			agg.RepoURLs[k] = v
+		}
+		for k, v := range r.LineFragments {
+			agg.LineFragments[k] = v
+		}
+		agg.Priority = math.Max(agg.Priority, r.Priority)
+	}
+
+	agg.Files = index.SortAndTruncateFiles(agg.Files, opts)
+	agg.Stats.Duration = time.Since(start)
+
+	tr.LazyPrintf("num files: %d crashes: %d", len(agg.Files), agg.Stats.Crashes)
+
+	return agg, nil
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	tr, ctx := trace.New(ctx, "federation.StreamSearch", "")
+	defer tr.Finish()
+
+	// pending holds the latest MaxPendingPriority reported by each backend. We
+	// don't know anything about a backend until it has sent its first
+	// response, so we start with +Inf. A finished backend has nothing
+	// pending.
+	var mu sync.Mutex
+	pending := make([]float64, len(s.backends))
+	for i := range pending {
+		pending[i] = math.Inf(1)
+	}
+	// progress holds the latest progress reported by each backend.
+	progress := make([]zoekt.SearchProgress, len(s.backends))
+	maxPending := func() float64 {
+		m := math.Inf(-1)
+		for _, p := range pending {
+			m = math.Max(m, p)
+		}
+		return m
+	}
+
+	var wg sync.WaitGroup
+	for i, b := range s.backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+
+			err := b.streamSearch(ctx, q, opts, func(sr *zoekt.SearchResult) {
+				mu.Lock()
+				defer mu.Unlock()
+				pending[i] = sr.MaxPendingPriority
+				sr.MaxPendingPriority = maxPending()
+				if sr.SearchProgress != nil {
+					progress[i] = *sr.SearchProgress
+					sr.SearchProgress = sumProgress(progress)
+				}
+				sender.Send(sr)
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			pending[i] = math.Inf(-1)
+
+			var stats zoekt.Stats
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[ERROR] federation: stream search on %s failed: %v", b.addr, err)
+				tr.LazyPrintf("backend %s: %v", b.addr, err)
+				stats.Crashes = 1
+			}
+
+			// Always send a final update so the consumer learns that this
+			// backend has nothing pending anymore.
+			sender.Send(&zoekt.SearchResult{
+				Stats:    stats,
+				Progress: zoekt.Progress{MaxPendingPriority: maxPending()},
+			})
+		}(i, b)
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// sumProgress returns the progress of a search across all backends, given the
+// latest progress reported by each.
+func sumProgress(progress []zoekt.SearchProgress) *zoekt.SearchProgress {
+	var sum zoekt.SearchProgress
+	for _, p := range progress {
+		sum.Add(p)
+	}
+	return &sum
+}
+
+func (s *Searcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	tr, ctx := trace.New(ctx, "federation.List", "")
+	defer tr.Finish()
+
+	results := make([]*zoekt.RepoList, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+	for i, b := range s.backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			results[i], errs[i] = b.list(ctx, q, opts)
+		}(i, b)
+	}
+	wg.Wait()
+
+	agg := zoekt.RepoList{
+		ReposMap: zoekt.ReposMap{},
+		Repos:    []*zoekt.RepoListEntry{},
+	}
+	uniq := map[string]*zoekt.RepoListEntry{}
+
+	for i, rl := range results {
+		if err := errs[i]; err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("[ERROR] federation: list on %s failed: %v", s.backends[i].addr, err)
+			tr.LazyPrintf("backend %s: %v", s.backends[i].addr, err)
+			agg.Crashes++
+			continue
+		}
+
+		agg.Crashes += rl.Crashes
+		agg.Stats.Add(&rl.Stats)
+
+		// The same repository can be indexed by more than one backend, for
+		// example while shards are being moved between hosts.
+		for _, r := range rl.Repos {
+			prev, ok := uniq[r.Repository.Name]
+			if !ok {
+				uniq[r.Repository.Name] = r
+			} else {
+				prev.Stats.Add(&r.Stats)
+			}
+		}
+
+		for id, r := range rl.ReposMap {
+			if _, ok := agg.ReposMap[id]; !ok {
+				agg.ReposMap[id] = r
+			}
+		}
+	}
+
+	agg.Repos = make([]*zoekt.RepoListEntry, 0, len(uniq))
+	for _, r := range uniq {
+		agg.Repos = append(agg.Repos, r)
+	}
+
+	// See shardedSearcher.List: only one of Repos and ReposMap is populated.
+	agg.Stats.Repos = len(uniq) + len(agg.ReposMap)
+
+	tr.LazyPrintf("repos.size=%d reposmap.size=%d crashes=%d", len(agg.Repos), len(agg.ReposMap), agg.Crashes)
+
+	return &agg, nil
+}
+
+// StreamList forwards the batches of all backends as they come. Unlike List,
+// it cannot merge a repository which is indexed by more than one backend, so
+// such a repository is sent once per backend.
+func (s *Searcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	tr, ctx := trace.New(ctx, "federation.StreamList", "")
+	defer tr.Finish()
+
+	var mu sync.Mutex
+	send := func(rl *zoekt.RepoList) {
+		mu.Lock()
+		defer mu.Unlock()
+		sender.Send(rl)
+	}
+
+	var wg sync.WaitGroup
+	for _, b := range s.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+
+			err := b.streamList(ctx, q, opts, send)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[ERROR] federation: stream list on %s failed: %v", b.addr, err)
+				tr.LazyPrintf("backend %s: %v", b.addr, err)
+				send(&zoekt.RepoList{Crashes: 1})
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// FetchDocument asks all backends for the document and returns the one of
+// the first backend, in the order they were specified, which has it.
+func (s *Searcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	tr, ctx := trace.New(ctx, "federation.FetchDocument", "")
+	defer tr.Finish()
+
+	docs := make([]*zoekt.Document, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+	for i, b := range s.backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			docs[i], errs[i] = b.fetchDocument(ctx, req)
+		}(i, b)
+	}
+	wg.Wait()
+
+	var firstErr error
+	for i, doc := range docs {
+		err := errs[i]
+		if err == nil {
+			return doc, nil
+		}
+		if errors.Is(err, zoekt.ErrDocumentNotFound) {
+			continue
+		}
+		tr.LazyPrintf("backend %s: %v", s.backends[i].addr, err)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("backend %s: %w", s.backends[i].addr, err)
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, zoekt.ErrDocumentNotFound
+}
+
+func (s *Searcher) Close() {
+	for _, b := range s.backends {
+		_ = b.cc.Close()
+	}
+}
+
+func (s *Searcher) String() string {
+	addrs := make([]string, 0, len(s.backends))
+	for _, b := range s.backends {
+		addrs = append(addrs, b.addr)
+	}
+	return fmt.Sprintf("federation(%s)", strings.Join(addrs, ","))
+}
+
+// backend is a single remote zoekt-webserver.
+type backend struct {
+	addr   string
+	cc     *grpc.ClientConn
+	client proto.WebserverServiceClient
+
+	// The gRPC API does not return RepoURLs and LineFragments with search
+	// results, so we look them up via List and cache them per repository.
+	mu            sync.Mutex
+	repoURLs      map[string]string
+	lineFragments map[string]string
+}
+
+func (b *backend) search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	resp, err := b.client.Search(ctx, &proto.SearchRequest{
+		Query: query.QToProto(q),
+		Opts:  opts.ToProto(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sr := zoekt.SearchResultFromProto(resp, nil, nil)
+	b.fillTemplates(ctx, sr)
+	return sr, nil
+}
+
+func (b *backend) streamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, send func(*zoekt.SearchResult)) error {
+	stream, err := b.client.StreamSearch(ctx, &proto.StreamSearchRequest{
+		Request: &proto.SearchRequest{
+			Query: query.QToProto(q),
+			Opts:  opts.ToProto(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// checksum.StreamClientInterceptor verifies the responses. Backends which
+	// predate checksums don't send any, in which case it accepts the stream
+	// as is.
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if resp.GetResponseChunk() == nil {
+			// The final checksum.
+			continue
+		}
+
+		sr := zoekt.SearchResultFromStreamProto(resp, nil, nil)
+		b.fillTemplates(ctx, sr)
+		send(sr)
+	}
+}
+
+func (b *backend) list(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	resp, err := b.client.List(ctx, &proto.ListRequest{
+		Query: query.QToProto(q),
+		Opts:  opts.ToProto(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.RepoListFromProto(resp), nil
+}
+
+// streamList calls send for every batch of the backend. Backends which
+// predate StreamList send all repositories in one batch.
+func (b *backend) streamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, send func(*zoekt.RepoList)) error {
+	req := &proto.ListRequest{
+		Query: query.QToProto(q),
+		Opts:  opts.ToProto(),
+	}
+	stream, err := b.client.StreamList(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for first := true; ; first = false {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if first && status.Code(err) == codes.Unimplemented {
+			rl, err := b.list(ctx, q, opts)
+			if err != nil {
+				return err
+			}
+			send(rl)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		send(zoekt.RepoListFromProto(resp))
+	}
+}
+
+// fetchDocument returns zoekt.ErrDocumentNotFound if the backend doesn't have
+// the document. Backends which predate FetchDocument are searched for it.
+func (b *backend) fetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	resp, err := b.client.FetchDocument(ctx, req.ToProto())
+	switch status.Code(err) {
+	case codes.OK:
+		return zoekt.DocumentFromProto(resp.GetDocument()), nil
+	case codes.NotFound:
+		return nil, zoekt.ErrDocumentNotFound
+	case codes.Unimplemented:
+		return zoekt.FetchDocument(ctx, backendSearcher{b}, req)
+	default:
+		return nil, err
+	}
+}
+
+// backendSearcher is the zoekt.Searcher of a single backend.
+type backendSearcher struct {
+	*backend
+}
+
+func (b backendSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return b.search(ctx, q, opts)
+}
+
+func (b backendSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return b.list(ctx, q, opts)
+}
+
+func (backendSearcher) Close() {}
+
+func (b backendSearcher) String() string {
+	return b.addr
+}
+
+// fillTemplates sets RepoURLs and LineFragments for all repositories in sr.
+// Repositories we haven't seen before are looked up with a single List call.
+func (b *backend) fillTemplates(ctx context.Context, sr *zoekt.SearchResult) {
+	if len(sr.Files) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	var missing []string
+	seen := map[string]struct{}{}
+	for _, f := range sr.Files {
+		if _, ok := seen[f.Repository]; ok {
+			continue
+		}
+		seen[f.Repository] = struct{}{}
+		if _, ok := b.repoURLs[f.Repository]; !ok {
+			missing = append(missing, f.Repository)
+		}
+	}
+	b.mu.Unlock()
+
+	if len(missing) > 0 {
+		rl, err := b.list(ctx, query.NewRepoSet(missing...), nil)
+		if err != nil {
+			if status.Code(err) != codes.Canceled {
+				log.Printf("[WARN] federation: looking up repository URLs on %s failed: %v", b.addr, err)
+			}
+		} else {
+			b.mu.Lock()
+			for _, r := range rl.Repos {
+				b.repoURLs[r.Repository.Name] = r.Repository.FileURLTemplate
+				b.lineFragments[r.Repository.Name] = r.Repository.LineFragmentTemplate
+			}
+			b.mu.Unlock()
+		}
+	}
+
+	sr.RepoURLs = make(map[string]string, len(seen))
+	sr.LineFragments = make(map[string]string, len(seen))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range seen {
+		if u, ok := b.repoURLs[name]; ok {
+			sr.RepoURLs[name] = u
+		}
+		if lf, ok := b.lineFragments[name]; ok {
+			sr.LineFragments[name] = lf
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/cmd/zoekt-webserver/grpc/server"
+	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
+	"github.com/sourcegraph/zoekt/internal/mockSearcher"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestFederation(t *testing.T) {
+	q := &query.Substring{Pattern: "needle"}
+	all := &query.Const{Value: true}
+
+	backendA := &mockSearcher.MockSearcher{
+		WantSearch: q,
+		SearchResult: &zoekt.SearchResult{
+			Stats: zoekt.Stats{FileCount: 1, MatchCount: 2},
+			Files: []zoekt.FileMatch{{FileName: "a.go", Repository: "repoA", Score: 10}},
+		},
+		WantList: all,
+		RepoList: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{
+				{Repository: zoekt.Repository{Name: "repoA", FileURLTemplate: "a/{{.Path}}"}, Stats: zoekt.RepoStats{Shards: 1}},
+				{Repository: zoekt.Repository{Name: "shared"}, Stats: zoekt.RepoStats{Shards: 1}},
+			},
+		},
+	}
+	backendB := &mockSearcher.MockSearcher{
+		WantSearch: q,
+		SearchResult: &zoekt.SearchResult{
+			Stats: zoekt.Stats{FileCount: 1, MatchCount: 3},
+			Files: []zoekt.FileMatch{{FileName: "b.go", Repository: "repoB", Score: 20}},
+		},
+		WantList: all,
+		RepoList: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{
+				{Repository: zoekt.Repository{Name: "repoB", FileURLTemplate: "b/{{.Path}}"}, Stats: zoekt.RepoStats{Shards: 1}},
+				{Repository: zoekt.Repository{Name: "shared"}, Stats: zoekt.RepoStats{Shards: 2}},
+			},
+		},
+	}
+
+	s, err := NewSearcher([]string{startBackend(t, backendA), startBackend(t, backendB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The mock only answers List for the "all" query, so URL lookups during
+	// search fail and leave RepoURLs empty.
+	sr, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fileNames(sr.Files), []string{"b.go", "a.go"}; !cmp.Equal(got, want) {
+		t.Errorf("search files: got %v, want %v", got, want)
+	}
+	if sr.Stats.FileCount != 2 || sr.Stats.MatchCount != 5 || sr.Stats.Crashes != 0 {
+		t.Errorf("search stats: got %+v", sr.Stats)
+	}
+
+	var (
+		mu    sync.Mutex
+		files []zoekt.FileMatch
+		stats zoekt.Stats
+	)
+	err = s.StreamSearch(context.Background(), q, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		files = append(files, sr.Files...)
+		stats.Add(sr.Stats)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fileNames(files)
+	sort.Strings(got)
+	if want := []string{"a.go", "b.go"}; !cmp.Equal(got, want) {
+		t.Errorf("stream files: got %v, want %v", got, want)
+	}
+	if stats.MatchCount != 5 {
+		t.Errorf("stream stats: got %+v", stats)
+	}
+
+	rl, err := s.List(context.Background(), all, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := map[string]int{}
+	for _, r := range rl.Repos {
+		shards[r.Repository.Name] = r.Stats.Shards
+	}
+	if want := map[string]int{"repoA": 1, "repoB": 1, "shared": 3}; !cmp.Equal(shards, want) {
+		t.Errorf("list: got %v, want %v", shards, want)
+	}
+	if rl.Stats.Repos != 3 {
+		t.Errorf("list: got Stats.Repos=%d, want 3", rl.Stats.Repos)
+	}
+}
+
+func TestFederationBackendDown(t *testing.T) {
+	all := &query.Const{Value: true}
+	up := &mockSearcher.MockSearcher{
+		WantList: all,
+		RepoList: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{{Repository: zoekt.Repository{Name: "repo"}}},
+		},
+	}
+
+	// Nothing listens on port 1, so the second backend always fails.
+	s, err := NewSearcher([]string{startBackend(t, up), "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	rl, err := s.List(context.Background(), all, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 || rl.Crashes != 1 {
+		t.Fatalf("got %d repos and %d crashes, want 1 and 1", len(rl.Repos), rl.Crashes)
+	}
+}
+
+func TestFederationFetchDocument(t *testing.T) {
+	backendA := &documentSearcher{docs: []*zoekt.Document{
+		{Repository: "repo", FileName: "a.go", Content: []byte("A")},
+	}}
+	backendB := &documentSearcher{docs: []*zoekt.Document{
+		{Repository: "repo", FileName: "a.go", Content: []byte("B")},
+		{Repository: "repo", FileName: "b.go", Content: []byte("B")},
+	}}
+
+	s, err := NewSearcher([]string{startBackend(t, backendA), startBackend(t, backendB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, tc := range []struct {
+		fileName string
+		want     string
+	}{
+		// Both backends have a.go, the first one wins.
+		{fileName: "a.go", want: "A"},
+		{fileName: "b.go", want: "B"},
+	} {
+		doc, err := s.FetchDocument(context.Background(), &zoekt.DocumentRequest{Repository: "repo", FileName: tc.fileName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(doc.Content); got != tc.want {
+			t.Errorf("%s: got content %q, want %q", tc.fileName, got, tc.want)
+		}
+	}
+
+	_, err = s.FetchDocument(context.Background(), &zoekt.DocumentRequest{Repository: "repo", FileName: "c.go"})
+	if !errors.Is(err, zoekt.ErrDocumentNotFound) {
+		t.Fatalf("got error %v, want %v", err, zoekt.ErrDocumentNotFound)
+	}
+}
+
+func startBackend(t *testing.T, searcher zoekt.Searcher) string {
+	t.Helper()
+
+	gs := grpc.NewServer()
+	t.Cleanup(gs.Stop)
+
+	streamer, ok := searcher.(zoekt.Streamer)
+	if !ok {
+		streamer = adapter{searcher}
+	}
+	proto.RegisterWebserverServiceServer(gs, server.NewServer(streamer))
+	ts := httptest.NewServer(h2c.NewHandler(gs, &http2.Server{}))
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func fileNames(files []zoekt.FileMatch) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.FileName)
+	}
+	return names
+}
+
+type adapter struct {
+	zoekt.Searcher
+}
+
+func (a adapter) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	sr, err := a.Searcher.Search(ctx, q, opts)
+	if err != nil {
+		return err
+	}
+	sender.Send(sr)
+	return nil
+}
+
+// documentSearcher is a streamer which only has documents.
+type documentSearcher struct {
+	adapter
+	docs []*zoekt.Document
+}
+
+func (s *documentSearcher) FetchDocument(_ context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	for _, d := range s.docs {
+		if d.Repository == req.Repository && d.FileName == req.FileName {
+			return d, nil
+		}
+	}
+	return nil, zoekt.ErrDocumentNotFound
+}
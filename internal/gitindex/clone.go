@@ -54,6 +54,40 @@ func updateZoektGitConfig(repoDest string, settings map[string]string) error {
 // determines where the repo is stored relative to `destDir`. Returns
 // the directory of the repository.
 func CloneRepo(destDir, name, cloneURL string, settings map[string]string) (string, error) {
+	return CloneRepoWithCredentials(destDir, name, cloneURL, settings, nil)
+}
+
+// Credentials returns the username and password git should use for a clone.
+type Credentials func() (username, password string, err error)
+
+// CloneRepoWithCredentials is like CloneRepo, but answers the credential
+// requests of git with creds if it is non-nil. creds is called for every
+// clone, so it may hand out short-lived tokens. The credentials are passed to
+// git through its environment and are not stored in the repository.
+func CloneRepoWithCredentials(destDir, name, cloneURL string, settings map[string]string, creds Credentials) (string, error) {
+	return CloneRepoWithOptions(destDir, name, cloneURL, settings, CloneOptions{Credentials: creds})
+}
+
+// CloneOptions configures CloneRepoWithOptions.
+type CloneOptions struct {
+	// Credentials, if non-nil, answer the credential requests of git, see
+	// CloneRepoWithCredentials.
+	Credentials Credentials
+
+	// Depth, if positive, creates a shallow clone with this many commits per
+	// branch. Indexing only needs the tip of each branch.
+	Depth int
+
+	// Filter, if set, creates a partial clone with this object filter, eg.
+	// blob:none. Indexing fetches the missing blobs on demand if
+	// Options.FetchMissingBlobs is set.
+	Filter string
+}
+
+// CloneRepoWithOptions is like CloneRepo, but clones as configured by opts.
+// opts only apply to new clones; existing repositories keep their history and
+// objects.
+func CloneRepoWithOptions(destDir, name, cloneURL string, settings map[string]string, opts CloneOptions) (string, error) {
 	parent := filepath.Join(destDir, filepath.Dir(name))
 	if err := os.MkdirAll(parent, 0o755); err != nil {
 		return "", err
@@ -83,9 +117,26 @@ func CloneRepo(destDir, name, cloneURL string, settings map[string]string) (stri
 		}
 	}
 
-	cmd := exec.Command(
-		"git", "clone", "--bare", "--verbose", "--progress",
-	)
+	cmd := exec.Command("git")
+	if opts.Credentials != nil {
+		username, password, err := opts.Credentials()
+		if err != nil {
+			return "", fmt.Errorf("credentials for %s: %w", name, err)
+		}
+		cmd.Args = append(cmd.Args, credentialHelperArgs...)
+		cmd.Env = append(os.Environ(),
+			"ZOEKT_GIT_USERNAME="+username,
+			"ZOEKT_GIT_PASSWORD="+password,
+		)
+	}
+	cmd.Args = append(cmd.Args, "clone", "--bare", "--verbose", "--progress")
+	if opts.Depth > 0 {
+		// --depth implies --single-branch, but we mirror all branches.
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--depth=%d", opts.Depth), "--no-single-branch")
+	}
+	if opts.Filter != "" {
+		cmd.Args = append(cmd.Args, "--filter="+opts.Filter)
+	}
 	cmd.Args = append(cmd.Args, config...)
 	cmd.Args = append(cmd.Args, cloneURL, repoDest)
 
@@ -102,6 +153,14 @@ func CloneRepo(destDir, name, cloneURL string, settings map[string]string) (stri
 	return repoDest, nil
 }
 
+// credentialHelperArgs replace the configured credential helpers of git by one
+// which answers with the username and password from the environment, see
+// CloneRepoWithCredentials.
+var credentialHelperArgs = []string{
+	"-c", "credential.helper=",
+	"-c", `credential.helper=!f() { test "$1" = get && echo "username=$ZOEKT_GIT_USERNAME" && echo "password=$ZOEKT_GIT_PASSWORD"; }; f`,
+}
+
 func setFetch(repoDir, remote, refspec string) error {
 	repo, err := git.PlainOpen(repoDir)
 	if err != nil {
@@ -15,7 +15,9 @@
 package gitindex
 
 import (
+	"os"
 	"os/exec"
+	"strings"
 	"testing"
 
 	git "github.com/go-git/go-git/v5"
@@ -56,3 +58,24 @@ git clone orig/.git clone.git
 		t.Fatalf("got %q want %q", got, want)
 	}
 }
+
+func TestCredentialHelperArgs(t *testing.T) {
+	cmd := exec.Command("git", credentialHelperArgs...)
+	cmd.Args = append(cmd.Args, "credential", "fill")
+	cmd.Env = append(os.Environ(),
+		"ZOEKT_GIT_USERNAME=x-access-token",
+		"ZOEKT_GIT_PASSWORD=s3cret",
+		"GIT_TERMINAL_PROMPT=0",
+	)
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=github.com\n\n")
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git credential fill: %v", err)
+	}
+	for _, want := range []string{"username=x-access-token\n", "password=s3cret\n"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("got %q, want it to contain %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package gitindex
+
+import (
+	"errors"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const gitattributesFile = ".gitattributes"
+
+// The attributes we honor. Files marked linguist-generated or
+// linguist-vendored are indexed with index.Document.Generated, so they rank
+// below hand-written code. If enabled, files with export-ignore are not
+// indexed, like they are left out of git archive.
+const (
+	attrExportIgnore      = "export-ignore"
+	attrLinguistGenerated = "linguist-generated"
+	attrLinguistVendored  = "linguist-vendored"
+)
+
+// attributesMatcher matches paths against the .gitattributes files of a
+// tree. The .gitattributes files are read on demand for the directories of
+// the matched paths, so delta builds only read the files on the paths of
+// changed files.
+type attributesMatcher struct {
+	tree *object.Tree
+
+	// exportIgnore is set if match reports export-ignore.
+	exportIgnore bool
+
+	// dirs caches the attributes which apply to the files of a directory,
+	// keyed by the directory path. The root is "".
+	dirs map[string]*dirAttributes
+}
+
+type dirAttributes struct {
+	// stack holds the attributes of the directory and its parents, in order
+	// of increasing priority.
+	stack []gitattributes.MatchAttribute
+}
+
+func newAttributesMatcher(tree *object.Tree, exportIgnore bool) *attributesMatcher {
+	return &attributesMatcher{tree: tree, exportIgnore: exportIgnore, dirs: map[string]*dirAttributes{}}
+}
+
+// match returns the attributes of the file at p which affect indexing. A nil
+// matcher matches nothing.
+func (m *attributesMatcher) match(p string) (exportIgnore, generated bool) {
+	if m == nil {
+		return false, false
+	}
+
+	dir := path.Dir(p)
+	if dir == "." {
+		dir = ""
+	}
+	attrs := m.dir(dir).match(strings.Split(p, "/"))
+	return m.exportIgnore && isTrue(attrs[attrExportIgnore]), isTrue(attrs[attrLinguistGenerated]) || isTrue(attrs[attrLinguistVendored])
+}
+
+// match returns the attributes of the file at p. We don't use
+// gitattributes.Matcher, because it lets the first matching line win instead
+// of the last.
+func (d *dirAttributes) match(p []string) map[string]gitattributes.Attribute {
+	macros := map[string][]gitattributes.Attribute{}
+	res := map[string]gitattributes.Attribute{}
+	for _, ma := range d.stack {
+		if ma.Pattern == nil {
+			macros[ma.Name] = ma.Attributes
+			continue
+		}
+		if !ma.Pattern.Match(p) {
+			continue
+		}
+		for _, a := range ma.Attributes {
+			if a.IsSet() {
+				for _, ma := range macros[a.Name()] {
+					res[ma.Name()] = ma
+				}
+			}
+			res[a.Name()] = a
+		}
+	}
+	return res
+}
+
+func (m *attributesMatcher) dir(dir string) *dirAttributes {
+	if d, ok := m.dirs[dir]; ok {
+		return d
+	}
+
+	var stack []gitattributes.MatchAttribute
+	var domain []string
+	if dir != "" {
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		stack = slices.Clip(m.dir(parent).stack)
+		domain = strings.Split(dir, "/")
+	}
+
+	// A broken .gitattributes file should not fail indexing, so we log and
+	// ignore it like git does.
+	attrs, err := m.read(dir, domain)
+	if err != nil {
+		log.Printf("gitattributes: ignoring %s: %v", path.Join(dir, gitattributesFile), err)
+	}
+	stack = append(stack, attrs...)
+
+	d := &dirAttributes{stack: stack}
+	m.dirs[dir] = d
+	return d
+}
+
+func (m *attributesMatcher) read(dir string, domain []string) ([]gitattributes.MatchAttribute, error) {
+	f, err := m.tree.File(path.Join(dir, gitattributesFile))
+	if errors.Is(err, object.ErrFileNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	// Like git, only the top-level file may define macros.
+	return gitattributes.ReadAttributes(strings.NewReader(content), domain, dir == "")
+}
+
+// isTrue returns true if the attribute is set, eg. "linguist-generated" or
+// "linguist-generated=true".
+func isTrue(a gitattributes.Attribute) bool {
+	if a == nil {
+		return false
+	}
+	return a.IsSet() || (a.IsValueSet() && a.Value() == "true")
+}
+
+// isGitattributesFile returns true if p is a .gitattributes file.
+func isGitattributesFile(p string) bool {
+	return path.Base(p) == gitattributesFile
+}
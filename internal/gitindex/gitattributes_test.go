@@ -0,0 +1,168 @@
+package gitindex
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func createGitattributesRepo(dir string) error {
+	script := `mkdir repo
+cd repo
+git init -b master
+mkdir -p docs vendor/lib sub
+echo needle > main.go
+echo needle > package-lock.json
+echo needle > api.pb.go
+echo needle > vendor/lib/lib.go
+echo needle > docs/README.md
+echo needle > sub/api.pb.go
+echo needle > sub/schema.gen.go
+cat > .gitattributes <<EOF
+package-lock.json linguist-generated=true
+*.pb.go linguist-generated
+vendor/** linguist-vendored
+docs/** export-ignore
+EOF
+cat > sub/.gitattributes <<EOF
+*.pb.go -linguist-generated
+*.gen.go linguist-generated
+EOF
+git add .
+git config user.email "you@example.com"
+git config user.name "Your Name"
+git commit -am amsg
+git checkout -b other
+echo "main.go linguist-generated" >> .gitattributes
+git commit -am "generated main"
+git checkout master
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("execution error: %v, output %s", err, out)
+	}
+	return nil
+}
+
+func TestGitattributes(t *testing.T) {
+	dir := t.TempDir()
+	if err := createGitattributesRepo(dir); err != nil {
+		t.Fatalf("createGitattributesRepo: %v", err)
+	}
+
+	// indexed returns the files matching "needle", with a * suffix for files
+	// marked as generated.
+	indexed := func(t *testing.T, branches []string, disable, exportIgnore bool) []string {
+		indexDir := t.TempDir()
+		buildOpts := index.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "repo",
+			},
+		}
+		buildOpts.SetDefaults()
+		buildOpts.DisableGitAttributes = disable
+		buildOpts.GitAttributesExportIgnore = exportIgnore
+
+		opts := Options{
+			RepoDir:      filepath.Join(dir, "repo"),
+			BuildOptions: buildOpts,
+			BranchPrefix: "refs/heads",
+			Branches:     branches,
+		}
+		if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+			t.Fatalf("IndexGitRepo: %v", err)
+		}
+
+		searcher, err := shards.NewDirectorySearcher(indexDir)
+		if err != nil {
+			t.Fatal("NewDirectorySearcher", err)
+		}
+		defer searcher.Close()
+
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{DebugScore: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, f := range res.Files {
+			name := f.FileName
+			if strings.Contains(f.Debug, "generated-file") {
+				name += "*"
+			}
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	master := []string{"master"}
+
+	want := []string{
+		"api.pb.go*",
+		"docs/README.md",
+		"main.go",
+		"package-lock.json*",
+		"sub/api.pb.go",
+		"sub/schema.gen.go*",
+		"vendor/lib/lib.go*",
+	}
+	if d := cmp.Diff(want, indexed(t, master, false, false)); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	want = []string{
+		"api.pb.go*",
+		"main.go",
+		"package-lock.json*",
+		"sub/api.pb.go",
+		"sub/schema.gen.go*",
+		"vendor/lib/lib.go*",
+	}
+	if d := cmp.Diff(want, indexed(t, master, false, true)); d != "" {
+		t.Errorf("GitAttributesExportIgnore: mismatch (-want +got):\n%s", d)
+	}
+
+	// package-lock.json looks generated without .gitattributes, see
+	// index.IsGeneratedFile.
+	want = []string{
+		"api.pb.go",
+		"docs/README.md",
+		"main.go",
+		"package-lock.json*",
+		"sub/api.pb.go",
+		"sub/schema.gen.go",
+		"vendor/lib/lib.go",
+	}
+	if d := cmp.Diff(want, indexed(t, master, true, true)); d != "" {
+		t.Errorf("DisableGitAttributes: mismatch (-want +got):\n%s", d)
+	}
+
+	// main.go is the same blob on both branches, but only the second branch
+	// marks it as generated.
+	want = []string{
+		"api.pb.go*",
+		"docs/README.md",
+		"main.go*",
+		"package-lock.json*",
+		"sub/api.pb.go",
+		"sub/schema.gen.go*",
+		"vendor/lib/lib.go*",
+	}
+	if d := cmp.Diff(want, indexed(t, []string{"master", "other"}, false, false)); d != "" {
+		t.Errorf("two branches: mismatch (-want +got):\n%s", d)
+	}
+}
@@ -0,0 +1,166 @@
+package gitindex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// historyDocuments returns a document for each commit reachable from the
+// indexed branches, see index.CommitDocument. At most opts.HistoryLimit
+// commits are visited per branch. Walks stop early at the boundary of a
+// shallow clone.
+func historyDocuments(repo *git.Repository, opts Options) ([]index.Document, error) {
+	type entry struct {
+		commit   *object.Commit
+		branches []string
+	}
+	var (
+		order   []plumbing.Hash
+		commits = map[plumbing.Hash]*entry{}
+	)
+
+	for _, b := range opts.BuildOptions.RepositoryDescription.Branches {
+		iter, err := repo.Log(&git.LogOptions{
+			From:  plumbing.NewHash(b.Version),
+			Order: git.LogOrderCommitterTime,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("log %s: %w", b.Name, err)
+		}
+
+		n := 0
+		for opts.HistoryLimit <= 0 || n < opts.HistoryLimit {
+			c, err := iter.Next()
+			if err == io.EOF || errors.Is(err, plumbing.ErrObjectNotFound) {
+				break
+			}
+			if err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("log %s: %w", b.Name, err)
+			}
+			n++
+
+			e, ok := commits[c.Hash]
+			if !ok {
+				e = &entry{commit: c}
+				commits[c.Hash] = e
+				order = append(order, c.Hash)
+			}
+			e.branches = append(e.branches, b.Name)
+		}
+		iter.Close()
+	}
+
+	docs := make([]index.Document, 0, len(order))
+	for _, h := range order {
+		e := commits[h]
+		paths, err := changedPaths(e.commit)
+		if err != nil {
+			return nil, fmt.Errorf("changed paths of %s: %w", h, err)
+		}
+		docs = append(docs, index.CommitDocument(index.Commit{
+			Hash:    h.String(),
+			Author:  fmt.Sprintf("%s <%s>", e.commit.Author.Name, e.commit.Author.Email),
+			Date:    e.commit.Author.When,
+			Message: e.commit.Message,
+			Paths:   paths,
+		}, e.branches))
+	}
+	return docs, nil
+}
+
+// fileModTimes returns the committer date of the last commit which changed
+// each of paths, see Options.FileModTimes. A path on several branches gets
+// the latest date of any of them. Paths whose last change lies beyond
+// opts.HistoryLimit or the boundary of a shallow clone are missing.
+func fileModTimes(repo *git.Repository, opts Options, paths map[string]bool) (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, b := range opts.BuildOptions.RepositoryDescription.Branches {
+		iter, err := repo.Log(&git.LogOptions{
+			From:  plumbing.NewHash(b.Version),
+			Order: git.LogOrderCommitterTime,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("log %s: %w", b.Name, err)
+		}
+
+		// Commits come newest first, so the first commit which changes a
+		// path is its last change on this branch.
+		seen := map[string]bool{}
+		for n := 0; len(seen) < len(paths) && (opts.HistoryLimit <= 0 || n < opts.HistoryLimit); n++ {
+			c, err := iter.Next()
+			if err == io.EOF || errors.Is(err, plumbing.ErrObjectNotFound) {
+				break
+			}
+			if err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("log %s: %w", b.Name, err)
+			}
+
+			changed, err := changedPaths(c)
+			if err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("changed paths of %s: %w", c.Hash, err)
+			}
+			for _, p := range changed {
+				if !paths[p] || seen[p] {
+					continue
+				}
+				seen[p] = true
+				if when := c.Committer.When; when.After(modTimes[p]) {
+					modTimes[p] = when
+				}
+			}
+		}
+		iter.Close()
+	}
+	return modTimes, nil
+}
+
+// changedPaths returns the paths changed by c relative to its first parent.
+// Root commits, and commits whose parent is missing from a shallow clone,
+// are compared with the empty tree.
+func changedPaths(c *object.Commit) ([]string, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return nil, err
+		}
+		if parent != nil {
+			if parentTree, err = parent.Tree(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, ch := range changes {
+		name := ch.To.Name
+		if name == "" {
+			name = ch.From.Name
+		}
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
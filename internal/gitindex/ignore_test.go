@@ -72,7 +72,7 @@ func TestIgnore(t *testing.T) {
 		Submodules:   true,
 		Incremental:  true,
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 
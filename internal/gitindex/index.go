@@ -31,6 +31,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5/config"
@@ -41,6 +42,7 @@ import (
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/ignore"
 	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/licenses"
 
 	git "github.com/go-git/go-git/v5"
 )
@@ -247,6 +249,9 @@ func setTemplatesFromConfig(desc *zoekt.Repository, repoDir string) error {
 	id, _ := strconv.ParseUint(sec.Options.Get("repoid"), 10, 32)
 	desc.ID = uint32(id)
 
+	desc.Description = sec.Options.Get("description")
+	desc.Homepage = sec.Options.Get("homepage")
+
 	if desc.RawConfig == nil {
 		desc.RawConfig = map[string]string{}
 	}
@@ -301,6 +306,29 @@ type Options struct {
 	// If set, follow submodule links. This requires RepoCacheDir to be set.
 	Submodules bool
 
+	// If set, index each submodule entry as a small document named after the
+	// submodule path, holding the pinned commit SHA. Submodules followed
+	// because of Submodules are indexed as their files instead.
+	SubmodulePointers bool
+
+	// If set, index each symlink as a small document holding the link target
+	// resolved against the repository root, instead of the raw target.
+	SymlinkTargets bool
+
+	// If set, index a document for each commit reachable from the indexed
+	// branches, holding its message, author, date and changed paths. See
+	// index.CommitDocument.
+	IndexHistory bool
+
+	// HistoryLimit is the maximum number of commits indexed per branch if
+	// IndexHistory is set. If 0, all commits are indexed.
+	HistoryLimit int
+
+	// If set, record the date of the last commit which changed each file as
+	// index.Document.ModTime. This walks the history of the indexed
+	// branches, up to HistoryLimit commits per branch.
+	FileModTimes bool
+
 	// If set, skip indexing if the existing index shard is newer
 	// than the refs in the repository.
 	Incremental bool
@@ -308,9 +336,34 @@ type Options struct {
 	// Don't error out if some branch is missing
 	AllowMissingBranch bool
 
+	// If set and RepoDir is a shallow clone, fetch branches which are missing
+	// from the clone from origin before indexing.
+	Unshallow bool
+
+	// UnshallowDepth is the number of commits fetched per missing branch if
+	// Unshallow is set. Defaults to 1, which is all indexing needs.
+	UnshallowDepth int
+
+	// If set and RepoDir is a partial clone, eg. one cloned with
+	// --filter=blob:none, the blobs to index which are missing from it are
+	// fetched from its promisor remote before indexing. Otherwise they are
+	// skipped.
+	FetchMissingBlobs bool
+
 	// Specifies the root of a Repository cache. Needed for submodule indexing.
 	RepoCacheDir string
 
+	// If set together with Submodules, submodule commits which are missing
+	// from RepoCacheDir are fetched into it from the submodule URL, cloning
+	// the submodule repository if needed. Otherwise such submodules are
+	// skipped.
+	FetchSubmodules bool
+
+	// SubmoduleFetchDepth is the number of commits fetched per submodule
+	// commit if FetchSubmodules is set. Defaults to 1, which is all indexing
+	// needs.
+	SubmoduleFetchDepth int
+
 	// Indexing options.
 	BuildOptions index.Options
 
@@ -380,15 +433,17 @@ func expandBranches(repo *git.Repository, bs []string, prefix string) ([]string,
 
 // IndexGitRepo indexes the git repository as specified by the options.
 // The returned bool indicates whether the index was updated as a result. This
-// can be informative if doing incremental indexing.
-func IndexGitRepo(opts Options) (bool, error) {
-	return indexGitRepo(opts, gitIndexConfig{})
+// can be informative if doing incremental indexing. If ctx is done before
+// indexing finishes, the shards built so far are discarded and ctx.Err() is
+// returned.
+func IndexGitRepo(ctx context.Context, opts Options) (bool, error) {
+	return indexGitRepo(ctx, opts, gitIndexConfig{})
 }
 
 // indexGitRepo indexes the git repository as specified by the options and the provided gitIndexConfig.
 // The returned bool indicates whether the index was updated as a result. This
 // can be informative if doing incremental indexing.
-func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
+func indexGitRepo(ctx context.Context, opts Options, config gitIndexConfig) (bool, error) {
 	prepareDeltaBuild := prepareDeltaBuild
 	if config.prepareDeltaBuild != nil {
 		prepareDeltaBuild = config.prepareDeltaBuild
@@ -407,32 +462,39 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 
 	opts.BuildOptions.RepositoryDescription.Source = opts.RepoDir
 
-	var repo *git.Repository
-	// TODO: this now defaults to on since we found a bug in it. Once we have
-	// fixed openRepo default to false.
-	legacyRepoOpen := cmp.Or(os.Getenv("ZOEKT_DISABLE_GOGIT_OPTIMIZATION"), "true")
-	if b, err := strconv.ParseBool(legacyRepoOpen); b || err != nil {
-		repo, err = git.PlainOpen(opts.RepoDir)
-		if err != nil {
-			return false, fmt.Errorf("git.PlainOpen: %w", err)
-		}
-	} else {
-		var repoCloser io.Closer
-		repo, repoCloser, err = openRepo(opts.RepoDir)
+	repo, repoCloser, err := openRepoForIndexing(opts.RepoDir)
+	if err != nil {
+		return false, err
+	}
+	defer func() { repoCloser.Close() }()
+
+	shallow, fetched, err := prepareShallowClone(repo, opts)
+	if err != nil {
+		return false, fmt.Errorf("prepareShallowClone: %w", err)
+	}
+	if fetched {
+		// Reopen the repository, since the fetch added refs and packs behind
+		// the back of the open one.
+		repoCloser.Close()
+		repo, repoCloser, err = openRepoForIndexing(opts.RepoDir)
 		if err != nil {
-			return false, fmt.Errorf("openRepo: %w", err)
+			return false, err
 		}
-		defer repoCloser.Close()
 	}
 
 	if err := setTemplatesFromConfig(&opts.BuildOptions.RepositoryDescription, opts.RepoDir); err != nil {
 		log.Printf("setTemplatesFromConfig(%s): %s", opts.RepoDir, err)
 	}
 
+	// Record that history and branches may be incomplete, so that consumers of
+	// the index can tell it apart from one built from a full clone.
+	opts.BuildOptions.RepositoryDescription.Shallow = shallow
+
 	branches, err := expandBranches(repo, opts.Branches, opts.BranchPrefix)
 	if err != nil {
 		return false, fmt.Errorf("expandBranches: %w", err)
 	}
+	var branchLicenses []string
 	for _, b := range branches {
 		commit, err := getCommit(repo, opts.BranchPrefix, b)
 		if err != nil {
@@ -444,14 +506,22 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 		}
 
 		opts.BuildOptions.RepositoryDescription.Branches = append(opts.BuildOptions.RepositoryDescription.Branches, zoekt.RepositoryBranch{
-			Name:    b,
-			Version: commit.Hash.String(),
+			Name:       b,
+			Version:    commit.Hash.String(),
+			CommitDate: commit.Committer.When,
 		})
 
 		if when := commit.Committer.When; when.After(opts.BuildOptions.RepositoryDescription.LatestCommitDate) {
 			opts.BuildOptions.RepositoryDescription.LatestCommitDate = when
 		}
+
+		license, err := detectLicense(commit)
+		if err != nil {
+			log.Printf("detectLicense(%s, %s): %s", opts.RepoDir, b, err)
+		}
+		branchLicenses = append(branchLicenses, license)
 	}
+	opts.BuildOptions.RepositoryDescription.License = licenses.Combine(branchLicenses)
 
 	if opts.Incremental && opts.BuildOptions.IncrementalSkipIndexing() {
 		return false, nil
@@ -484,6 +554,16 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 		}
 	}
 
+	if opts.FetchMissingBlobs {
+		n, err := fetchMissingBlobs(repo, opts, repos)
+		if err != nil {
+			return false, fmt.Errorf("fetchMissingBlobs: %w", err)
+		}
+		if n > 0 {
+			log.Printf("fetched %d missing blobs into partial clone %s", n, opts.RepoDir)
+		}
+	}
+
 	reposByPath := map[string]BlobLocation{}
 	for key, info := range repos {
 		reposByPath[key.SubRepoPath] = info
@@ -511,7 +591,7 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 		}
 	}
 
-	builder, err := index.NewBuilder(opts.BuildOptions)
+	builder, err := index.NewBuilderContext(ctx, opts.BuildOptions)
 	if err != nil {
 		return false, fmt.Errorf("build.NewBuilder: %w", err)
 	}
@@ -541,6 +621,20 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 	sort.Strings(names)
 	names = uniq(names)
 
+	var modTimes map[string]time.Time
+	if opts.FileModTimes {
+		paths := map[string]bool{}
+		for key := range repos {
+			if key.SubRepoPath == "" {
+				paths[key.Path] = true
+			}
+		}
+		modTimes, err = fileModTimes(repo, opts, paths)
+		if err != nil {
+			return false, fmt.Errorf("fileModTimes: %w", err)
+		}
+	}
+
 	log.Printf("attempting to index %d total files", totalFiles)
 	for idx, name := range names {
 		keys := fileKeys[name]
@@ -550,6 +644,9 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 			if err != nil {
 				return false, err
 			}
+			if key.SubRepoPath == "" {
+				doc.ModTime = modTimes[key.Path]
+			}
 
 			if err := builder.Add(doc); err != nil {
 				return false, fmt.Errorf("error adding document with name %s: %w", key.FullPath(), err)
@@ -560,6 +657,20 @@ func indexGitRepo(opts Options, config gitIndexConfig) (bool, error) {
 			}
 		}
 	}
+
+	if opts.IndexHistory {
+		docs, err := historyDocuments(repo, opts)
+		if err != nil {
+			return false, fmt.Errorf("historyDocuments: %w", err)
+		}
+		log.Printf("attempting to index %d commits", len(docs))
+		for _, doc := range docs {
+			if err := builder.Add(doc); err != nil {
+				return false, fmt.Errorf("error adding commit %s: %w", doc.Name, err)
+			}
+		}
+	}
+
 	return true, builder.Finish()
 }
 
@@ -617,6 +728,33 @@ func (r repoPathRanks) rank(path string, content []byte) float64 {
 	}
 }
 
+// detectLicense returns the SPDX identifier of the license stored in the root
+// of the commit's tree, or the empty string if no license was recognized.
+func detectLicense(commit *object.Commit) (string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range tree.Entries {
+		if !e.Mode.IsFile() || !licenses.IsLicenseFile(e.Name) {
+			continue
+		}
+		f, err := tree.TreeEntryFile(&e)
+		if err != nil {
+			return "", err
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return "", err
+		}
+		if license := licenses.Detect([]byte(content)); license != "" {
+			return license, nil
+		}
+	}
+	return "", nil
+}
+
 func newIgnoreMatcher(tree *object.Tree) (*ignore.Matcher, error) {
 	ignoreFile, err := tree.File(ignore.IgnoreFile)
 	if err == object.ErrFileNotFound {
@@ -654,10 +792,43 @@ type gitIndexConfig struct {
 	prepareNormalBuild prepareNormalBuildFunc
 }
 
+// openRepoForIndexing opens the repository at dir. The returned closer must
+// be called once the repository is no longer used.
+func openRepoForIndexing(dir string) (*git.Repository, io.Closer, error) {
+	// TODO: this now defaults to on since we found a bug in it. Once we have
+	// fixed openRepo default to false.
+	legacyRepoOpen := cmp.Or(os.Getenv("ZOEKT_DISABLE_GOGIT_OPTIMIZATION"), "true")
+	if b, err := strconv.ParseBool(legacyRepoOpen); b || err != nil {
+		repo, err := git.PlainOpen(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("git.PlainOpen: %w", err)
+		}
+		return repo, io.NopCloser(nil), nil
+	}
+
+	repo, closer, err := openRepo(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("openRepo: %w", err)
+	}
+	return repo, closer, nil
+}
+
 func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[fileKey]BlobLocation, branchVersions map[string]map[string]plumbing.Hash, changedOrDeletedPaths []string, err error) {
 	if options.Submodules {
 		return nil, nil, nil, fmt.Errorf("delta builds currently don't support submodule indexing")
 	}
+	if options.SubmodulePointers {
+		return nil, nil, nil, fmt.Errorf("delta builds currently don't support submodule pointers")
+	}
+	if options.SymlinkTargets {
+		return nil, nil, nil, fmt.Errorf("delta builds currently don't support symlink targets")
+	}
+	if options.IndexHistory {
+		return nil, nil, nil, fmt.Errorf("delta builds currently don't support indexing history")
+	}
+	if options.FileModTimes {
+		return nil, nil, nil, fmt.Errorf("delta builds currently don't support file modification times")
+	}
 
 	// discover what commits we indexed during our last build
 	existingRepository, _, ok, err := options.BuildOptions.FindRepositoryMetadata()
@@ -717,6 +888,9 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 	// branch name -> git worktree at most current commit
 	branchToCurrentTree := make(map[string]*object.Tree, len(options.Branches))
 
+	// branch name -> .gitattributes of the current tree, nil if disabled
+	branchToAttributes := make(map[string]*attributesMatcher, len(options.Branches))
+
 	for _, b := range options.Branches {
 		commit, err := getCommit(repository, options.BranchPrefix, b)
 		if err != nil {
@@ -729,6 +903,9 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 		}
 
 		branchToCurrentTree[b] = tree
+		if !options.BuildOptions.DisableGitAttributes {
+			branchToAttributes[b] = newAttributesMatcher(tree, options.BuildOptions.GitAttributesExportIgnore)
+		}
 	}
 
 	rawURL := options.BuildOptions.RepositoryDescription.URL
@@ -737,6 +914,33 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 		return nil, nil, nil, fmt.Errorf("parsing repository URL %q: %w", rawURL, err)
 	}
 
+	// addFile adds the version id of the file at p on branch b to the build,
+	// unless .gitattributes or the include and exclude paths exclude it.
+	addFile := func(p string, id plumbing.Hash, b string) {
+		if !options.BuildOptions.IncludePath(p) {
+			return
+		}
+
+		exportIgnore, generated := branchToAttributes[b].match(p)
+		if exportIgnore {
+			return
+		}
+
+		file := fileKey{Path: p, ID: id}
+		if existing, ok := repos[file]; ok {
+			existing.Branches = append(existing.Branches, b)
+			existing.Generated = existing.Generated || generated
+			repos[file] = existing
+		} else {
+			repos[file] = BlobLocation{
+				GitRepo:   repository,
+				URL:       u,
+				Branches:  []string{b},
+				Generated: generated,
+			}
+		}
+	}
+
 	// TODO: Support repository submodules for delta builds
 
 	// loop over all branches, calculate the diff between our
@@ -773,18 +977,14 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 					return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
 				}
 
-				// either file is added or renamed, so we need to add the new version to the build
-				file := fileKey{Path: newFileRelativeRootPath, ID: newFile.Hash}
-				if existing, ok := repos[file]; ok {
-					existing.Branches = append(existing.Branches, branch.Name)
-					repos[file] = existing
-				} else {
-					repos[file] = BlobLocation{
-						GitRepo:  repository,
-						URL:      u,
-						Branches: []string{branch.Name},
-					}
+				// A changed .gitattributes file can change the attributes of
+				// files which didn't change.
+				if !options.BuildOptions.DisableGitAttributes && isGitattributesFile(newFileRelativeRootPath) {
+					return nil, nil, nil, fmt.Errorf("changes to %q are not yet supported in delta builds", newFileRelativeRootPath)
 				}
+
+				// either file is added or renamed, so we need to add the new version to the build
+				addFile(newFileRelativeRootPath, newFile.Hash, branch.Name)
 			}
 
 			if oldFile == nil {
@@ -800,6 +1000,10 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 				return nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
 			}
 
+			if !options.BuildOptions.DisableGitAttributes && isGitattributesFile(oldFileRelativeRootPath) {
+				return nil, nil, nil, fmt.Errorf("changes to %q are not yet supported in delta builds", oldFileRelativeRootPath)
+			}
+
 			// The file is either modified or deleted. So, we need to add ALL versions
 			// of the old file (across all branches) to the build.
 			for b, currentTree := range branchToCurrentTree {
@@ -813,17 +1017,7 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 					return nil, nil, nil, fmt.Errorf("getting hash for file %q in branch %q: %w", oldFile.Name, b, err)
 				}
 
-				file := fileKey{Path: oldFileRelativeRootPath, ID: f.ID()}
-				if existing, ok := repos[file]; ok {
-					existing.Branches = append(existing.Branches, b)
-					repos[file] = existing
-				} else {
-					repos[file] = BlobLocation{
-						GitRepo:  repository,
-						URL:      u,
-						Branches: []string{b},
-					}
-				}
+				addFile(oldFileRelativeRootPath, f.ID(), b)
 			}
 
 			changedOrDeletedPaths = append(changedOrDeletedPaths, oldFileRelativeRootPath)
@@ -849,6 +1043,8 @@ func prepareNormalBuild(options Options, repository *git.Repository) (repos map[
 	var repoCache *RepoCache
 	if options.Submodules {
 		repoCache = NewRepoCache(options.RepoCacheDir)
+		repoCache.Fetch = options.FetchSubmodules
+		repoCache.FetchDepth = options.SubmoduleFetchDepth
 	}
 
 	// Branch => Repo => SHA1
@@ -860,6 +1056,13 @@ func prepareNormalBuild(options Options, repository *git.Repository) (repos map[
 	}
 
 	rw := NewRepoWalker(repository, options.BuildOptions.RepositoryDescription.URL, repoCache)
+	rw.SubmodulePointers = options.SubmodulePointers
+	rw.SymlinkTargets = options.SymlinkTargets
+	rw.GitAttributes = !options.BuildOptions.DisableGitAttributes
+	rw.GitAttributesExportIgnore = options.BuildOptions.GitAttributesExportIgnore
+	if len(options.BuildOptions.IncludePaths) > 0 || len(options.BuildOptions.ExcludePaths) > 0 {
+		rw.IncludePath = options.BuildOptions.IncludePath
+	}
 	for _, b := range branches {
 		commit, err := getCommit(repository, options.BranchPrefix, b)
 		if err != nil {
@@ -896,10 +1099,30 @@ func createDocument(key fileKey,
 	opts index.Options,
 ) (index.Document, error) {
 	repo := repos[key]
-	blob, err := repo.GitRepo.BlobObject(key.ID)
 	branches := repos[key].Branches
 
+	if repo.Submodule {
+		return index.Document{
+			SubRepositoryPath: key.SubRepoPath,
+			Name:              key.FullPath(),
+			Content:           submodulePointerContent(key.ID),
+			Branches:          branches,
+		}, nil
+	}
+
+	if opts.NameOnly(key.FullPath()) {
+		// The builder only indexes the name.
+		return index.Document{
+			SubRepositoryPath: key.SubRepoPath,
+			Name:              key.FullPath(),
+			Branches:          branches,
+		}, nil
+	}
+
+	blob, err := repo.GitRepo.BlobObject(key.ID)
+
 	// We filter out large documents when fetching the repo. So if an object is too large, it will not be found.
+	// The same holds for blobs missing from a partial clone without Options.FetchMissingBlobs.
 	if errors.Is(err, plumbing.ErrObjectNotFound) {
 		return skippedLargeDoc(key, branches, opts), nil
 	}
@@ -909,20 +1132,39 @@ func createDocument(key fileKey,
 	}
 
 	keyFullPath := key.FullPath()
-	if blob.Size > int64(opts.SizeMax) && !opts.IgnoreSizeMax(keyFullPath) {
-		return skippedLargeDoc(key, branches, opts), nil
+	if blob.Size > int64(opts.SizeMax) && !opts.IgnoreSizeMax(keyFullPath) && !opts.ExpandArchive(keyFullPath, int(blob.Size)) {
+		limit := opts.TruncateSize()
+		if limit == 0 || repo.Symlink {
+			return skippedLargeDoc(key, branches, opts), nil
+		}
+		prefix, err := blobPrefix(blob, limit)
+		if err != nil {
+			return index.Document{}, err
+		}
+		return index.Document{
+			SubRepositoryPath: key.SubRepoPath,
+			Name:              keyFullPath,
+			Content:           index.TruncateContent(prefix, limit),
+			Truncated:         true,
+			Branches:          branches,
+			Generated:         repo.Generated,
+		}, nil
 	}
 
 	contents, err := blobContents(blob)
 	if err != nil {
 		return index.Document{}, err
 	}
+	if repo.Symlink {
+		contents = symlinkContent(keyFullPath, contents)
+	}
 
 	return index.Document{
 		SubRepositoryPath: key.SubRepoPath,
 		Name:              keyFullPath,
 		Content:           contents,
 		Branches:          branches,
+		Generated:         repo.Generated,
 	}, nil
 }
 
@@ -935,6 +1177,22 @@ func skippedLargeDoc(key fileKey, branches []string, opts index.Options) index.D
 	}
 }
 
+// blobPrefix returns the first limit bytes of blob, which is larger.
+func blobPrefix(blob *object.Blob, limit int) ([]byte, error) {
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf := make([]byte, limit)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
 func blobContents(blob *object.Blob) ([]byte, error) {
 	r, err := blob.Reader()
 	if err != nil {
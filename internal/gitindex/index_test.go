@@ -27,6 +27,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -60,7 +61,7 @@ func TestIndexEmptyRepo(t *testing.T) {
 		},
 	}
 
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 }
@@ -79,7 +80,7 @@ func TestIndexNonexistentRepo(t *testing.T) {
 		},
 	}
 
-	if _, err := IndexGitRepo(opts); err == nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err == nil {
 		t.Fatal("expected error, got none")
 	} else if !errors.Is(err, git.ErrRepositoryNotExists) {
 		t.Fatalf("expected git.ErrRepositoryNotExists, got %v", err)
@@ -112,7 +113,7 @@ func TestIndexTinyRepo(t *testing.T) {
 			},
 		}
 
-		if _, err := IndexGitRepo(opts); err != nil {
+		if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 			t.Fatalf("unexpected error %v", err)
 		}
 
@@ -134,6 +135,188 @@ func TestIndexTinyRepo(t *testing.T) {
 	}
 }
 
+func TestIndexLicense(t *testing.T) {
+	dir := t.TempDir()
+	executeCommand(t, dir, exec.Command("git", "init", "-b", "main", "repo"))
+
+	repoDir := filepath.Join(dir, "repo")
+	executeCommand(t, repoDir, exec.Command("git", "config", "--local", "user.name", "Thomas"))
+	executeCommand(t, repoDir, exec.Command("git", "config", "--local", "user.email", "thomas@google.com"))
+
+	license := "Apache License\nVersion 2.0, January 2004\n"
+	if err := os.WriteFile(filepath.Join(repoDir, "LICENSE"), []byte(license), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	executeCommand(t, repoDir, exec.Command("git", "add", "."))
+	executeCommand(t, repoDir, exec.Command("git", "commit", "-m", "initial commit"))
+
+	// A second branch with a different license, which must be detected too.
+	executeCommand(t, repoDir, exec.Command("git", "checkout", "-b", "dual"))
+	if err := os.WriteFile(filepath.Join(repoDir, "LICENSE"), []byte("SPDX-License-Identifier: MIT OR BSD-3-Clause\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	executeCommand(t, repoDir, exec.Command("git", "commit", "-am", "dual license"))
+
+	opts := Options{
+		RepoDir:  repoDir,
+		Branches: []string{"main", "dual"},
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              dir,
+		},
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	for q, want := range map[string]int{
+		"license:apache-2.0":   2,
+		"license:Apache-2.0":   2,
+		"license:mit":          2,
+		"license:bsd-3-clause": 2,
+		"license:gpl-3.0":      0,
+	} {
+		results, err := searcher.Search(context.Background(), mustParse(t, q), &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results.Files) != want {
+			t.Errorf("%s: got %d files, want %d", q, len(results.Files), want)
+		}
+	}
+}
+
+func TestIndexHistory(t *testing.T) {
+	dir := t.TempDir()
+	runScript(t, dir, `git init -b main repo
+cd repo
+git config user.email "jane@example.com"
+git config user.name "Jane Doe"
+echo hello > hello.go
+git add hello.go
+GIT_AUTHOR_DATE="2023-06-01T00:00:00Z" git commit -m "Add hello"
+git config user.email "john@example.com"
+git config user.name "John Roe"
+echo world >> hello.go
+echo doc > README
+git add hello.go README
+GIT_AUTHOR_DATE="2024-06-01T00:00:00Z" git commit -m "Fix greeting"
+`)
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		Branches:     []string{"main"},
+		IndexHistory: true,
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              dir,
+		},
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	for q, want := range map[string]int{
+		"hello":                         1,
+		"commit:":                       2,
+		"commit:greeting":               1,
+		"commit:README":                 1,
+		"commit:hello.go":               2,
+		"author:jane":                   1,
+		"author:john@example.com":       1,
+		"after:2024-01-01":              1,
+		"before:2024-01-01 author:john": 0,
+	} {
+		results, err := searcher.Search(context.Background(), mustParse(t, q), &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results.Files) != want {
+			t.Errorf("%s: got %d files, want %d", q, len(results.Files), want)
+		}
+	}
+}
+
+func TestIndexFileModTimes(t *testing.T) {
+	dir := t.TempDir()
+	runScript(t, dir, `git init -b main repo
+cd repo
+git config user.email "jane@example.com"
+git config user.name "Jane Doe"
+echo "package a" > a.go
+git add a.go
+GIT_COMMITTER_DATE="2023-06-01T00:00:00Z" git commit -m "Add a"
+echo "package b" > b.go
+git add b.go
+GIT_COMMITTER_DATE="2024-06-01T00:00:00Z" git commit -m "Add b"
+`)
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		Branches:     []string{"main"},
+		FileModTimes: true,
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              dir,
+		},
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	results, err := searcher.Search(context.Background(), mustParse(t, "package"), &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitDate := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	want := map[string]time.Time{
+		"a.go": time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		"b.go": commitDate,
+	}
+	if len(results.Files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(results.Files), len(want))
+	}
+	for _, f := range results.Files {
+		if !f.LastModified.Equal(want[f.FileName]) {
+			t.Errorf("%s: got LastModified %v, want %v", f.FileName, f.LastModified, want[f.FileName])
+		}
+		if !f.CommitDate.Equal(commitDate) {
+			t.Errorf("%s: got CommitDate %v, want %v", f.FileName, f.CommitDate, commitDate)
+		}
+		if f.IndexTime.IsZero() {
+			t.Errorf("%s: IndexTime is not set", f.FileName)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) query.Q {
+	t.Helper()
+	q, err := query.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
 func executeCommand(t *testing.T, dir string, cmd *exec.Cmd) *exec.Cmd {
 	cmd.Dir = dir
 	if err := cmd.Run(); err != nil {
@@ -700,7 +883,7 @@ func TestIndexDeltaBasic(t *testing.T) {
 					}
 
 					// run test
-					_, err := indexGitRepo(options, gitIndexConfig{
+					_, err := indexGitRepo(context.Background(), options, gitIndexConfig{
 						prepareDeltaBuild:  prepareDeltaSpy,
 						prepareNormalBuild: prepareNormalSpy,
 					})
@@ -856,6 +1039,8 @@ func TestSetTemplates_e2e(t *testing.T) {
 	// setup: initialize the repository and all of its branches
 	runScript(t, repositoryDir, "git init -b master")
 	runScript(t, repositoryDir, "git config remote.origin.url git@github.com:sourcegraph/zoekt.git")
+	runScript(t, repositoryDir, "git config zoekt.description 'Fast trigram based code search'")
+	runScript(t, repositoryDir, "git config zoekt.homepage https://example.com/zoekt")
 	desc := zoekt.Repository{}
 	if err := setTemplatesFromConfig(&desc, repositoryDir); err != nil {
 		t.Fatalf("setTemplatesFromConfig: %v", err)
@@ -864,6 +1049,9 @@ func TestSetTemplates_e2e(t *testing.T) {
 	if got, want := desc.FileURLTemplate, `{{URLJoinPath "https://github.com/sourcegraph/zoekt" "blob" .Version .Path}}`; got != want {
 		t.Errorf("got %q, want %q", got, want)
 	}
+	if desc.Description != "Fast trigram based code search" || desc.Homepage != "https://example.com/zoekt" {
+		t.Errorf("got description %q and homepage %q", desc.Description, desc.Homepage)
+	}
 }
 
 func TestSetTemplates(t *testing.T) {
@@ -994,3 +1182,57 @@ func BenchmarkPrepareNormalBuild(b *testing.B) {
 		b.Fatalf("Unexpected empty results")
 	}
 }
+
+func TestIndexIncludeExcludePaths(t *testing.T) {
+	dir := t.TempDir()
+	executeCommand(t, dir, exec.Command("git", "init", "-b", "main", "repo"))
+
+	repoDir := filepath.Join(dir, "repo")
+	executeCommand(t, repoDir, exec.Command("git", "config", "--local", "user.name", "Thomas"))
+	executeCommand(t, repoDir, exec.Command("git", "config", "--local", "user.email", "thomas@google.com"))
+
+	for _, name := range []string{"README.md", "services/foo/main.go", "services/foo/testdata/golden.go", "services/bar/main.go"} {
+		if err := os.MkdirAll(filepath.Join(repoDir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte("needle\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	executeCommand(t, repoDir, exec.Command("git", "add", "."))
+	executeCommand(t, repoDir, exec.Command("git", "commit", "-m", "initial commit"))
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir:  repoDir,
+		Branches: []string{"main"},
+		BuildOptions: index.Options{
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			IndexDir:              indexDir,
+			IncludePaths:          []string{"services/foo/**"},
+			ExcludePaths:          []string{"**/testdata/**"},
+		},
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	results, err := searcher.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal("search failed", err)
+	}
+
+	var got []string
+	for _, f := range results.Files {
+		got = append(got, f.FileName)
+	}
+	if want := []string{"services/foo/main.go"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
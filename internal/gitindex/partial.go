@@ -0,0 +1,99 @@
+package gitindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// fetchBlobsTimeout bounds how long we wait for fetching the missing blobs of
+// a partial clone.
+const fetchBlobsTimeout = 30 * time.Minute
+
+// promisorRemote returns the remote from which the partial clone repo fetches
+// missing objects, or "" if repo is not a partial clone.
+func promisorRemote(repo *git.Repository) (string, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", err
+	}
+	for _, s := range cfg.Raw.Section("remote").Subsections {
+		if s.Option("promisor") == "true" {
+			return s.Name, nil
+		}
+	}
+	return cfg.Raw.Section("extensions").Option("partialClone"), nil
+}
+
+// missingBlobs returns the blobs of repos stored in repo which are missing
+// from its object store, sorted by hash. Blobs of files whose content opts
+// doesn't index are not needed.
+func missingBlobs(repo *git.Repository, repos map[fileKey]BlobLocation, opts *index.Options) ([]plumbing.Hash, error) {
+	seen := map[plumbing.Hash]bool{}
+	var missing []plumbing.Hash
+	for key, loc := range repos {
+		if loc.Submodule || loc.GitRepo != repo || seen[key.ID] || opts.NameOnly(key.FullPath()) {
+			continue
+		}
+		seen[key.ID] = true
+
+		err := repo.Storer.HasEncodedObject(key.ID)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			missing = append(missing, key.ID)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].String() < missing[j].String() })
+	return missing, nil
+}
+
+// fetchMissingBlobs fetches the blobs of repos which are missing from repo,
+// opened from opts.RepoDir, if it is a partial clone. Clones made with
+// --filter=blob:none hold no blobs at all, and git only fetches them one by
+// one on access, so we fetch them in a single batch like git does for
+// missing objects of a checkout. It returns the number of fetched blobs.
+func fetchMissingBlobs(repo *git.Repository, opts Options, repos map[fileKey]BlobLocation) (int, error) {
+	remote, err := promisorRemote(repo)
+	if err != nil || remote == "" {
+		return 0, err
+	}
+
+	missing, err := missingBlobs(repo, repos, &opts.BuildOptions)
+	if err != nil || len(missing) == 0 {
+		return 0, err
+	}
+
+	var stdin strings.Builder
+	for _, h := range missing {
+		stdin.WriteString(h.String())
+		stdin.WriteByte('\n')
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchBlobsTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "-C", opts.RepoDir,
+		"-c", "fetch.negotiationAlgorithm=noop",
+		"fetch", "--no-tags", "--no-write-fetch-head", "--recurse-submodules=no",
+		"--filter=blob:none", "--stdin", remote)
+	cmd.Stdin = strings.NewReader(stdin.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("fetching %d missing blobs from %s: %w, output %s", len(missing), remote, err, out)
+	}
+
+	// The fetch added a pack behind the back of the object store.
+	if s, ok := repo.Storer.(interface{ Reindex() }); ok {
+		s.Reindex()
+	}
+	return len(missing), nil
+}
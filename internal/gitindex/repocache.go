@@ -15,20 +15,39 @@
 package gitindex
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
+// fetchTimeout bounds how long we wait for fetching a commit into the cache.
+const fetchTimeout = 10 * time.Minute
+
 // RepoCache is a set of repositories on the file system, named and
 // stored by URL.
 type RepoCache struct {
 	baseDir string
 
+	// Fetch, if set, makes OpenCommit fetch repositories and commits which
+	// are missing from the cache from their URL.
+	Fetch bool
+
+	// FetchDepth is the number of commits fetched per missing commit if
+	// Fetch is set. Defaults to 1, which is all indexing needs.
+	FetchDepth int
+
 	reposMu sync.Mutex
 	repos   map[string]*git.Repository
 }
@@ -83,6 +102,76 @@ func (rc *RepoCache) Open(u *url.URL) (*git.Repository, error) {
 	return repo, err
 }
 
+// OpenCommit opens the repository for u, which must contain the commit id.
+// If Fetch is set and the repository or the commit is missing, the commit is
+// fetched from u into the cache first.
+func (rc *RepoCache) OpenCommit(u *url.URL, id plumbing.Hash) (*git.Repository, error) {
+	repo, err := rc.Open(u)
+	if err == nil {
+		if _, err = repo.CommitObject(id); err == nil {
+			return repo, nil
+		}
+	}
+	if !rc.Fetch || !(errors.Is(err, git.ErrRepositoryNotExists) || errors.Is(err, plumbing.ErrObjectNotFound)) {
+		return nil, err
+	}
+
+	if err := rc.fetch(u, id, errors.Is(err, git.ErrRepositoryNotExists)); err != nil {
+		return nil, err
+	}
+
+	// Reopen the repository, so that it sees the fetched objects.
+	rc.reposMu.Lock()
+	delete(rc.repos, repoKey(u))
+	rc.reposMu.Unlock()
+	repo, err = rc.Open(u)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repo.CommitObject(id); err != nil {
+		return nil, fmt.Errorf("commit %s after fetching from %s: %w", id, u, err)
+	}
+	return repo, nil
+}
+
+// fetch fetches the commit id from u into the cache, creating the bare
+// repository first if create is set. The commit is kept alive by the ref
+// refs/zoekt/<id>.
+func (rc *RepoCache) fetch(u *url.URL, id plumbing.Hash, create bool) error {
+	dir := rc.Path(u)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	run := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		// Prevent prompting
+		cmd.Stdin = &bytes.Buffer{}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %w, output %s", strings.Join(args, " "), err, out)
+		}
+		return nil
+	}
+
+	if create {
+		if err := run("init", "--bare", dir); err != nil {
+			return err
+		}
+		if err := run("-C", dir, "remote", "add", "origin", u.String()); err != nil {
+			return err
+		}
+	}
+
+	depth := rc.FetchDepth
+	if depth <= 0 {
+		depth = 1
+	}
+	if err := run("-C", dir, "fetch", fmt.Sprintf("--depth=%d", depth), "--no-tags", u.String(), "+"+id.String()+":refs/zoekt/"+id.String()); err != nil {
+		return err
+	}
+	log.Printf("fetched %s from %s into %s", id, u, dir)
+	return nil
+}
+
 // ListRepos returns paths to repos on disk that start with the given
 // URL prefix. The paths are relative to baseDir, and typically
 // include a ".git" suffix.
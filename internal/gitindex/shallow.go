@@ -0,0 +1,88 @@
+package gitindex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// unshallowTimeout bounds how long we wait for fetching missing branches of a
+// shallow clone.
+const unshallowTimeout = 10 * time.Minute
+
+// isShallow returns true if repo is a shallow clone, ie. if some of its
+// commits have parents which are missing from the object store.
+func isShallow(repo *git.Repository) (bool, error) {
+	commits, err := repo.Storer.Shallow()
+	if err != nil {
+		return false, err
+	}
+	return len(commits) > 0, nil
+}
+
+// missingBranches returns the branches in opts.Branches which cannot be
+// resolved in repo. Wildcards are skipped, since we cannot know which
+// branches they would expand to on the remote.
+func missingBranches(repo *git.Repository, opts Options) []string {
+	var missing []string
+	for _, b := range opts.Branches {
+		if strings.Contains(b, "*") {
+			continue
+		}
+		if _, err := getCommit(repo, opts.BranchPrefix, b); err != nil {
+			missing = append(missing, b)
+		}
+	}
+	return missing
+}
+
+// prepareShallowClone checks whether repo, opened from opts.RepoDir, is a
+// shallow clone. If it is and opts.Unshallow is set, branches missing from the
+// clone are fetched from origin with at most opts.UnshallowDepth commits each.
+// Shallow clones are often created with --single-branch, so without fetching
+// we would index an incomplete set of branches. fetched is true if anything
+// was fetched, in which case repo is stale and must be reopened.
+func prepareShallowClone(repo *git.Repository, opts Options) (shallow, fetched bool, err error) {
+	shallow, err = isShallow(repo)
+	if err != nil || !shallow {
+		return false, false, err
+	}
+
+	missing := missingBranches(repo, opts)
+	if len(missing) == 0 {
+		return true, false, nil
+	}
+	if !opts.Unshallow {
+		log.Printf("%s is a shallow clone missing branches %v, set Unshallow to fetch them", opts.RepoDir, missing)
+		return true, false, nil
+	}
+
+	depth := opts.UnshallowDepth
+	if depth <= 0 {
+		depth = 1
+	}
+
+	args := []string{"-C", opts.RepoDir, "fetch", fmt.Sprintf("--depth=%d", depth), "--no-tags", "origin"}
+	for _, b := range missing {
+		args = append(args, fmt.Sprintf("+%s:%s", b, path.Join(opts.BranchPrefix, b)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), unshallowTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = &bytes.Buffer{}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return true, false, fmt.Errorf("fetching %v into shallow clone: %w, output %s", missing, err, out)
+	}
+	log.Printf("fetched missing branches %v into shallow clone %s", missing, opts.RepoDir)
+
+	return true, true, nil
+}
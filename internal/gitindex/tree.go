@@ -15,6 +15,7 @@
 package gitindex
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -34,9 +35,35 @@ import (
 // RepoWalker walks one or more commit trees, collecting the files to index in its Files map.
 //
 // It also recurses into submodules if Options.Submodules is enabled.
+//
+// Symlinks are collected like regular files, their content is the link
+// target.
 type RepoWalker struct {
 	Files map[fileKey]BlobLocation
 
+	// SubmodulePointers, if set, collects every submodule entry as a
+	// pseudo-file holding the pinned commit. Submodules which the walker
+	// recurses into are collected as their files instead.
+	SubmodulePointers bool
+
+	// SymlinkTargets, if set, marks symlinks so that they are indexed as
+	// pseudo-files holding the link target resolved against the repository
+	// root, see symlinkContent.
+	SymlinkTargets bool
+
+	// GitAttributes, if set, honors linguist-generated and linguist-vendored
+	// in the .gitattributes files of the walked trees.
+	GitAttributes bool
+
+	// GitAttributesExportIgnore, if set together with GitAttributes, skips
+	// files marked export-ignore.
+	GitAttributesExportIgnore bool
+
+	// IncludePath, if set, skips the files for which it returns false, eg.
+	// to index only one part of a monorepo. Paths are relative to the root of
+	// the outermost repository. See index.Options.IncludePath.
+	IncludePath func(path string) bool
+
 	repo    *git.Repository
 	repoURL *url.URL
 
@@ -108,6 +135,11 @@ func (rw *RepoWalker) CollectFiles(t *object.Tree, branch string, ig *ignore.Mat
 		return nil, fmt.Errorf("newIgnoreMatcher: %w", err)
 	}
 
+	var attrs *attributesMatcher
+	if rw.GitAttributes {
+		attrs = newAttributesMatcher(t, rw.GitAttributesExportIgnore)
+	}
+
 	tw := object.NewTreeWalker(t, true, make(map[plumbing.Hash]bool))
 	defer tw.Close()
 
@@ -118,18 +150,25 @@ func (rw *RepoWalker) CollectFiles(t *object.Tree, branch string, ig *ignore.Mat
 		if err == io.EOF {
 			break
 		}
-		if err := rw.handleEntry(name, &entry, branch, subRepoVersions, ig); err != nil {
+		if err := rw.handleEntry(name, &entry, branch, subRepoVersions, ig, attrs); err != nil {
 			return nil, fmt.Errorf("handleEntry: %w", err)
 		}
 	}
 	return subRepoVersions, nil
 }
 
-func (rw *RepoWalker) tryHandleSubmodule(p string, id *plumbing.Hash, branch string, subRepoVersions map[string]plumbing.Hash, ig *ignore.Matcher) error {
+// tryHandleSubmodule recurses into the submodule at p. It returns false if
+// the submodule could not be walked.
+func (rw *RepoWalker) tryHandleSubmodule(p string, id *plumbing.Hash, branch string, subRepoVersions map[string]plumbing.Hash, ig *ignore.Matcher) bool {
 	if err := rw.handleSubmodule(p, id, branch, subRepoVersions, ig); err != nil {
-		log.Printf("submodule %s: ignoring error %v", p, err)
+		if errors.Is(err, git.ErrRepositoryNotExists) && !rw.repoCache.Fetch {
+			log.Printf("submodule %s: skipping, its repository is not in the repository cache", p)
+		} else {
+			log.Printf("submodule %s: ignoring error %v", p, err)
+		}
+		return false
 	}
-	return nil
+	return true
 }
 
 func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string, subRepoVersions map[string]plumbing.Hash, ig *ignore.Matcher) error {
@@ -143,7 +182,7 @@ func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string
 		return err
 	}
 
-	subRepo, err := rw.repoCache.Open(subURL)
+	subRepo, err := rw.repoCache.OpenCommit(subURL, *id)
 	if err != nil {
 		return err
 	}
@@ -160,6 +199,13 @@ func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string
 	subRepoVersions[p] = *id
 
 	sw := NewRepoWalker(subRepo, subURL.String(), rw.repoCache)
+	sw.SubmodulePointers = rw.SubmodulePointers
+	sw.SymlinkTargets = rw.SymlinkTargets
+	sw.GitAttributes = rw.GitAttributes
+	sw.GitAttributesExportIgnore = rw.GitAttributesExportIgnore
+	if rw.IncludePath != nil {
+		sw.IncludePath = func(sp string) bool { return rw.IncludePath(path.Join(p, sp)) }
+	}
 	subVersions, err := sw.CollectFiles(tree, branch, ig)
 	if err != nil {
 		return err
@@ -177,30 +223,52 @@ func (rw *RepoWalker) handleSubmodule(p string, id *plumbing.Hash, branch string
 	return nil
 }
 
-func (rw *RepoWalker) handleEntry(p string, e *object.TreeEntry, branch string, subRepoVersions map[string]plumbing.Hash, ig *ignore.Matcher) error {
+func (rw *RepoWalker) handleEntry(p string, e *object.TreeEntry, branch string, subRepoVersions map[string]plumbing.Hash, ig *ignore.Matcher, attrs *attributesMatcher) error {
+	recursed := false
 	if e.Mode == filemode.Submodule && rw.repoCache != nil {
-		if err := rw.tryHandleSubmodule(p, &e.Hash, branch, subRepoVersions, ig); err != nil {
-			return fmt.Errorf("submodule %s: %v", p, err)
-		}
+		recursed = rw.tryHandleSubmodule(p, &e.Hash, branch, subRepoVersions, ig)
 	}
 
+	isSubmodule := e.Mode == filemode.Submodule
 	switch e.Mode {
 	case filemode.Regular, filemode.Executable, filemode.Symlink:
+	case filemode.Submodule:
+		// The files of the submodule take the place of the pointer.
+		if !rw.SubmodulePointers || recursed {
+			return nil
+		}
 	default:
 		return nil
 	}
 
+	if rw.IncludePath != nil && !rw.IncludePath(p) {
+		return nil
+	}
+
 	// Skip ignored files
 	if ig.Match(p) {
 		return nil
 	}
 
+	exportIgnore, generated := attrs.match(p)
+	if exportIgnore {
+		return nil
+	}
+
 	key := fileKey{Path: p, ID: e.Hash}
 	if existing, ok := rw.Files[key]; ok {
 		existing.Branches = append(existing.Branches, branch)
+		existing.Generated = existing.Generated || generated
 		rw.Files[key] = existing
 	} else {
-		rw.Files[key] = BlobLocation{GitRepo: rw.repo, URL: rw.repoURL, Branches: []string{branch}}
+		rw.Files[key] = BlobLocation{
+			GitRepo:   rw.repo,
+			URL:       rw.repoURL,
+			Branches:  []string{branch},
+			Submodule: isSubmodule,
+			Symlink:   rw.SymlinkTargets && e.Mode == filemode.Symlink,
+			Generated: generated,
+		}
 	}
 
 	return nil
@@ -226,12 +294,47 @@ type BlobLocation struct {
 
 	// Branches is the list of branches that contain the blob.
 	Branches []string
+
+	// Submodule is set if the entry is a submodule pointer. The ID of the
+	// entry is then the pinned commit rather than a blob.
+	Submodule bool
+
+	// Symlink is set if the entry is a symlink which is indexed as a
+	// pointer to its target, see RepoWalker.SymlinkTargets.
+	Symlink bool
+
+	// Generated is set if .gitattributes marks the file as generated or
+	// vendored on any of its branches.
+	Generated bool
 }
 
 func (l *BlobLocation) Blob(id *plumbing.Hash) ([]byte, error) {
+	if l.Submodule {
+		return submodulePointerContent(*id), nil
+	}
 	blob, err := l.GitRepo.BlobObject(*id)
 	if err != nil {
 		return nil, err
 	}
 	return blobContents(blob)
 }
+
+// symlinkContent returns the content of the pseudo-file for the symlink at
+// p pointing at target. Relative targets inside the repository are resolved
+// against the repository root, so that navigation tooling can follow them
+// without knowing where the link lives. Other targets are kept as is.
+func symlinkContent(p string, target []byte) []byte {
+	resolved := string(target)
+	if !path.IsAbs(resolved) {
+		if j := path.Join(path.Dir(p), resolved); j != ".." && !strings.HasPrefix(j, "../") {
+			resolved = j
+		}
+	}
+	return []byte(fmt.Sprintf("Symbolic link to %s\n", resolved))
+}
+
+// submodulePointerContent returns the content of the pseudo-file for a
+// submodule pinned at commit. It matches how git shows submodules in diffs.
+func submodulePointerContent(commit plumbing.Hash) []byte {
+	return []byte(fmt.Sprintf("Subproject commit %s\n", commit))
+}
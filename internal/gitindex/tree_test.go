@@ -191,6 +191,42 @@ func TestCollectFiles(t *testing.T) {
 	if !reflect.DeepEqual(paths, want) {
 		t.Errorf("got %v, want %v", paths, want)
 	}
+
+	// Submodules we recurse into are not collected as pointers as well.
+	rw = NewRepoWalker(repo, aURL.String(), cache)
+	rw.SubmodulePointers = true
+	rw.SymlinkTargets = true
+	if _, err := rw.CollectFiles(tree, "main", &ignore.Matcher{}); err != nil {
+		t.Fatalf("CollectFiles: %v", err)
+	}
+
+	paths = paths[:0]
+	for k, loc := range rw.Files {
+		paths = append(paths, k.FullPath())
+		if got, want := loc.Symlink, k.FullPath() == "bname/bsymlink"; got != want {
+			t.Errorf("%s: got Symlink %v, want %v", k.FullPath(), got, want)
+		}
+	}
+	sort.Strings(paths)
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("with pointers: got %v, want %v", paths, want)
+	}
+}
+
+func TestSymlinkContent(t *testing.T) {
+	for _, tc := range []struct {
+		path, target, want string
+	}{
+		{"bsymlink", "bfile", "bfile"},
+		{"a/b/link", "../c/file", "a/c/file"},
+		{"a/link", "../../outside", "../../outside"},
+		{"link", "/etc/passwd", "/etc/passwd"},
+	} {
+		got := string(symlinkContent(tc.path, []byte(tc.target)))
+		if want := "Symbolic link to " + tc.want + "\n"; got != want {
+			t.Errorf("%s -> %s: got %q, want %q", tc.path, tc.target, got, want)
+		}
+	}
 }
 
 func TestSubmoduleIndex(t *testing.T) {
@@ -214,7 +250,7 @@ func TestSubmoduleIndex(t *testing.T) {
 		Incremental:  true,
 		RepoCacheDir: dir,
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 
@@ -318,7 +354,7 @@ func TestSearchSymlinkByContent(t *testing.T) {
 		Incremental:  true,
 		RepoCacheDir: dir,
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 
@@ -376,12 +412,141 @@ func TestAllowMissingBranch(t *testing.T) {
 		Incremental:  true,
 		RepoCacheDir: dir,
 	}
-	if _, err := IndexGitRepo(opts); err == nil {
-		t.Fatalf("IndexGitRepo(nonexist) succeeded")
+	if _, err := IndexGitRepo(context.Background(), opts); err == nil {
+		t.Fatalf("IndexGitRepo(context.Background(), nonexist) succeeded")
 	}
 	opts.AllowMissingBranch = true
-	if _, err := IndexGitRepo(opts); err != nil {
-		t.Fatalf("IndexGitRepo(nonexist, allow): %v", err)
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo(context.Background(), nonexist, allow): %v", err)
+	}
+}
+
+func TestUnshallow(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := createMultibranchRepo(dir); err != nil {
+		t.Fatalf("createMultibranchRepo: %v", err)
+	}
+
+	// --depth implies --single-branch, so the clone only has master.
+	runScript(t, dir, "git clone --bare --depth=1 file://$PWD/repo shallow.git")
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir: filepath.Join(dir, "shallow.git"),
+		BuildOptions: index.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "repo",
+			},
+		},
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master", "branchdir/a"},
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err == nil {
+		t.Fatalf("IndexGitRepo succeeded on shallow clone missing branch branchdir/a")
+	}
+
+	opts.Unshallow = true
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo(context.Background(), unshallow): %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	rlist, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatalf("List(): %v", err)
+	}
+	if len(rlist.Repos) != 1 {
+		t.Fatalf("got %v, want 1 repo", rlist.Repos)
+	}
+	repo := rlist.Repos[0].Repository
+	if len(repo.Branches) != 2 {
+		t.Errorf("got branches %v, want 2", repo.Branches)
+	}
+	if !repo.Shallow {
+		t.Errorf("got Shallow false, want true")
+	}
+}
+
+func TestFetchMissingBlobs(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := createMultibranchRepo(dir); err != nil {
+		t.Fatalf("createMultibranchRepo: %v", err)
+	}
+	runScript(t, filepath.Join(dir, "repo"), "git config uploadpack.allowFilter true")
+
+	repoDir, err := CloneRepoWithOptions(dir, "partial", "file://"+filepath.Join(dir, "repo"), nil, CloneOptions{
+		Depth:  1,
+		Filter: "blob:none",
+	})
+	if err != nil {
+		t.Fatalf("CloneRepoWithOptions: %v", err)
+	}
+
+	search := func(fetch bool) (*zoekt.Repository, []string) {
+		t.Helper()
+		indexDir := t.TempDir()
+		opts := Options{
+			RepoDir: repoDir,
+			BuildOptions: index.Options{
+				IndexDir: indexDir,
+				RepositoryDescription: zoekt.Repository{
+					Name: "repo",
+				},
+			},
+			BranchPrefix:      "refs/heads/",
+			Branches:          []string{"master", "branchdir/a"},
+			FetchMissingBlobs: fetch,
+		}
+		if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+			t.Fatalf("IndexGitRepo: %v", err)
+		}
+
+		searcher, err := shards.NewDirectorySearcher(indexDir)
+		if err != nil {
+			t.Fatal("NewDirectorySearcher", err)
+		}
+		defer searcher.Close()
+
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "cont"}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, f := range res.Files {
+			files = append(files, f.FileName)
+		}
+		sort.Strings(files)
+
+		rlist, err := searcher.List(context.Background(), &query.Const{Value: true}, nil)
+		if err != nil {
+			t.Fatalf("List(): %v", err)
+		}
+		if len(rlist.Repos) != 1 {
+			t.Fatalf("got %v, want 1 repo", rlist.Repos)
+		}
+		return &rlist.Repos[0].Repository, files
+	}
+
+	// Without fetching, the missing blobs are skipped.
+	repo, files := search(false)
+	if len(files) != 0 {
+		t.Errorf("got matches in %v, want none", files)
+	}
+	if !repo.Shallow || len(repo.Branches) != 2 {
+		t.Errorf("got Shallow %t and branches %v, want a shallow clone with 2 branches", repo.Shallow, repo.Branches)
+	}
+
+	_, files = search(true)
+	if want := []string{"afile", "afile", "subdir/sub-file"}; !cmp.Equal(files, want) {
+		t.Errorf("got matches in %v, want %v", files, want)
 	}
 }
 
@@ -445,7 +610,7 @@ func TestBranchWildcard(t *testing.T) {
 		Submodules:   true,
 		Incremental:  true,
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 
@@ -493,9 +658,186 @@ func TestSkipSubmodules(t *testing.T) {
 		Branches:     []string{"master"},
 		Submodules:   false,
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+}
+
+func TestFetchSubmodules(t *testing.T) {
+	dir := t.TempDir()
+	runScript(t, dir, `
+export GIT_CONFIG_COUNT=1
+export GIT_CONFIG_KEY_0=protocol.file.allow
+export GIT_CONFIG_VALUE_0=always
+
+git init -b master bdir
+cd bdir
+git config user.email "you@example.com"
+git config user.name "Your Name"
+echo old-bcont > bfile
+git add bfile
+git commit -m bmsg1
+echo new-bcont > bfile
+git commit -am bmsg2
+
+cd ..
+git init -b master adir
+cd adir
+git config user.email "you@example.com"
+git config user.name "Your Name"
+echo acont > afile
+git add afile
+git submodule add --name bname -- "file://$PWD/../bdir" bname
+git commit -am amsg
+
+cd ..
+git clone --bare adir adir.git
+git -C adir.git remote set-url origin http://example.com/adir
+`)
+
+	search := func(pattern string) int {
+		t.Helper()
+		searcher, err := shards.NewDirectorySearcher(filepath.Join(dir, "index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer searcher.Close()
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: pattern}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Files)
+	}
+
+	cacheDir := filepath.Join(dir, "cache")
+	opts := Options{
+		RepoDir: filepath.Join(dir, "adir.git"),
+		BuildOptions: index.Options{
+			IndexDir:              filepath.Join(dir, "index"),
+			RepositoryDescription: zoekt.Repository{Name: "adir"},
+		},
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+		Submodules:   true,
+		RepoCacheDir: cacheDir,
+	}
+
+	// Submodules missing from the cache are skipped.
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+	if got := search("acont"); got != 1 {
+		t.Fatalf("got %d files for acont, want 1", got)
+	}
+	if got := search("new-bcont"); got != 0 {
+		t.Fatalf("got %d files for new-bcont without fetching, want 0", got)
+	}
+
+	opts.FetchSubmodules = true
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+	if got := search("new-bcont"); got != 1 {
+		t.Fatalf("got %d files for new-bcont, want 1", got)
+	}
+
+	// Only the pinned commit was fetched.
+	cached, err := ListRepos(cacheDir, &url.URL{})
+	if err != nil || len(cached) != 1 {
+		t.Fatalf("got cached repositories %v, %v, want 1", cached, err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, cached[0], "shallow")); err != nil {
+		t.Errorf("fetched submodule is not shallow: %v", err)
+	}
+}
+
+func TestSubmodulePointers(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := createSubmoduleRepo(dir); err != nil {
+		t.Fatalf("createSubmoduleRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+
+	opts := Options{
+		RepoDir: filepath.Join(dir, "gerrit.googlesource.com", "adir.git"),
+		BuildOptions: index.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "gerrit.googlesource.com/adir",
+			},
+		},
+		BranchPrefix:      "refs/heads",
+		Branches:          []string{"master"},
+		SubmodulePointers: true,
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	results, err := searcher.Search(context.Background(),
+		&query.And{Children: []query.Q{
+			&query.Substring{Pattern: "bname", FileName: true},
+			&query.Substring{Pattern: "Subproject commit"},
+		}},
+		&zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Files) != 1 || results.Files[0].FileName != "bname" {
+		t.Fatalf("got %v, want the pointer file bname", results.Files)
+	}
+}
+
+func TestSymlinkTargets(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := createSubmoduleRepo(dir); err != nil {
+		t.Fatalf("createSubmoduleRepo: %v", err)
+	}
+
+	indexDir := t.TempDir()
+
+	opts := Options{
+		RepoDir: filepath.Join(dir, "gerrit.googlesource.com", "bdir.git"),
+		BuildOptions: index.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "gerrit.googlesource.com/bdir",
+			},
+		},
+		BranchPrefix:   "refs/heads",
+		Branches:       []string{"master"},
+		SymlinkTargets: true,
+	}
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	results, err := searcher.Search(context.Background(),
+		&query.Substring{Pattern: "Symbolic link to bfile"},
+		&zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Files) != 1 || results.Files[0].FileName != "bsymlink" {
+		t.Fatalf("got %v, want the symlink bsymlink", results.Files)
+	}
 }
 
 func TestFullAndShortRefNames(t *testing.T) {
@@ -524,7 +866,7 @@ func TestFullAndShortRefNames(t *testing.T) {
 		Incremental:        false,
 		AllowMissingBranch: false,
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 
@@ -574,7 +916,7 @@ func TestLatestCommit(t *testing.T) {
 		BranchPrefix: "refs/heads",
 		Branches:     []string{"branchdir/a", "branchdir/b"},
 	}
-	if _, err := IndexGitRepo(opts); err != nil {
+	if _, err := IndexGitRepo(context.Background(), opts); err != nil {
 		t.Fatalf("IndexGitRepo: %v", err)
 	}
 
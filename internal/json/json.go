@@ -3,10 +3,15 @@ package json
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/ratelimit"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -14,11 +19,19 @@ import (
 // take. This is the same default used by Sourcegraph.
 const defaultTimeout = 20 * time.Second
 
+// defaultNumResults is the number of files a GET request to /search returns
+// unless it sets num, the same as in the web interface.
+const defaultNumResults = 50
+
 func JSONServer(searcher zoekt.Searcher) http.Handler {
 	s := jsonSearcher{searcher}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/search", s.jsonSearch)
 	mux.HandleFunc("/list", s.jsonList)
+	mux.HandleFunc("/batch", s.jsonBatch)
+	mux.HandleFunc("/duplicates", s.jsonDuplicates)
+	mux.HandleFunc("/source", s.jsonSource)
+	mux.HandleFunc("GET /repos/{id}/stats", s.jsonRepoStats)
 	return mux
 }
 
@@ -26,6 +39,59 @@ type jsonSearcher struct {
 	Searcher zoekt.Searcher
 }
 
+// URLParams are the parameters which the search endpoints of zoekt-webserver
+// accept in the URL, so that browsers and tools can link to searches, eg.
+// with the URL templates of /opensearch.xml.
+type URLParams struct {
+	// Num is the maximum number of files to return, or 0 for the default.
+	Num int
+
+	// Ctx is the number of context lines around each match.
+	Ctx int
+
+	// Format is the format of the results: "html", "json" or "ndjson", with
+	// one JSON object per line. It is empty if the request doesn't ask for a
+	// format.
+	Format string
+
+	// Fields are the parts of the file matches to return, see
+	// zoekt.SearchOptions.Fields. The web interface ignores them.
+	Fields zoekt.FileMatchFields
+}
+
+// MaxContextLines is the maximum of URLParams.Ctx.
+const MaxContextLines = 10
+
+// ParseURLParams parses the num, ctx, format and fields URL parameters. An invalid
+// num is ignored like a missing one, to keep old links working.
+func ParseURLParams(v url.Values) (URLParams, error) {
+	var p URLParams
+	if num, err := strconv.Atoi(v.Get("num")); err == nil && num > 0 {
+		p.Num = num
+	}
+
+	if ctxStr := v.Get("ctx"); ctxStr != "" {
+		ctx, err := strconv.Atoi(ctxStr)
+		if err != nil || ctx < 0 || ctx > MaxContextLines {
+			return p, fmt.Errorf("Number of context lines must be between 0 and %d", MaxContextLines)
+		}
+		p.Ctx = ctx
+	}
+
+	switch p.Format = v.Get("format"); p.Format {
+	case "", "html", "json", "ndjson":
+	default:
+		return p, fmt.Errorf("unknown format %q, want html, json or ndjson", p.Format)
+	}
+
+	fields, err := zoekt.ParseFileMatchFields(v.Get("fields"))
+	if err != nil {
+		return p, err
+	}
+	p.Fields = fields
+	return p, nil
+}
+
 type jsonSearchArgs struct {
 	Q       string
 	RepoIDs *[]uint32
@@ -36,6 +102,43 @@ type jsonSearchReply struct {
 	Result *zoekt.SearchResult
 }
 
+type jsonBatchArgs struct {
+	Queries []string
+	RepoIDs *[]uint32
+	Opts    *zoekt.SearchOptions
+}
+
+type jsonBatchReply struct {
+	// Results holds one result per query, in the same order as
+	// jsonBatchArgs.Queries.
+	Results []*zoekt.SearchResult
+}
+
+type jsonDuplicatesArgs struct {
+	// Content is the file content to look for.
+	Content string
+
+	// Q optionally restricts the documents searched, eg. to some
+	// repositories.
+	Q       string
+	RepoIDs *[]uint32
+	Opts    *zoekt.SearchOptions
+}
+
+type jsonDuplicatesReply struct {
+	Files []zoekt.FileMatch
+}
+
+type jsonSourceReply struct {
+	Document *zoekt.Document
+}
+
+type jsonRepoStatsReply struct {
+	ID    uint32
+	Name  string
+	Stats zoekt.RepoStats
+}
+
 type jsonListArgs struct {
 	Q    string
 	Opts *zoekt.ListOptions
@@ -45,19 +148,44 @@ type jsonListReply struct {
 	List *zoekt.RepoList
 }
 
+// jsonSearch searches with the arguments in the body of a POST request, or
+// with the q, num and ctx URL parameters of a GET request. With
+// format=ndjson it writes one file match per line instead of the whole
+// result.
 func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	w.Header().Add("Content-Type", "application/json")
 
-	if req.Method != "POST" {
-		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+	params, err := ParseURLParams(req.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Format == "html" {
+		jsonError(w, http.StatusBadRequest, "format html is not supported, want json or ndjson")
 		return
 	}
 
 	searchArgs := jsonSearchArgs{}
-	err := json.NewDecoder(req.Body).Decode(&searchArgs)
-	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+	switch req.Method {
+	case "GET":
+		searchArgs.Q = req.URL.Query().Get("q")
+		searchArgs.Opts = &zoekt.SearchOptions{
+			MaxDocDisplayCount: defaultNumResults,
+			NumContextLines:    params.Ctx,
+			Fields:             params.Fields,
+		}
+		if params.Num > 0 {
+			searchArgs.Opts.MaxDocDisplayCount = params.Num
+		}
+	case "POST":
+		err := json.NewDecoder(req.Body).Decode(&searchArgs)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		jsonError(w, http.StatusMethodNotAllowed, "Only GET and POST are supported")
 		return
 	}
 	if searchArgs.Q == "" {
@@ -92,7 +220,18 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 
 	searchResult, err := s.Searcher.Search(ctx, q, searchArgs.Opts)
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+		jsonError(w, searchErrorStatus(err), err.Error())
+		return
+	}
+
+	if params.Format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for i := range searchResult.Files {
+			if err := encoder.Encode(&searchResult.Files[i]); err != nil {
+				return
+			}
+		}
 		return
 	}
 
@@ -103,6 +242,220 @@ func (s *jsonSearcher) jsonSearch(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// jsonBatch evaluates several queries in one request, see zoekt.BatchSearch.
+func (s *jsonSearcher) jsonBatch(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	batchArgs := jsonBatchArgs{}
+	err := json.NewDecoder(req.Body).Decode(&batchArgs)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(batchArgs.Queries) == 0 {
+		jsonError(w, http.StatusBadRequest, "missing queries")
+		return
+	}
+	if len(batchArgs.Queries) > zoekt.MaxBatchSize {
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("too many queries: got %d, the maximum is %d", len(batchArgs.Queries), zoekt.MaxBatchSize))
+		return
+	}
+
+	// The rate limiter admitted this request as one search. Charge it for
+	// the remaining queries, since each of them is evaluated on its own.
+	if retryAfter, ok := ratelimit.Charge(ctx, len(batchArgs.Queries)-1); !ok {
+		ratelimit.RetryAfter(w, retryAfter)
+		return
+	}
+	if batchArgs.Opts == nil {
+		batchArgs.Opts = &zoekt.SearchOptions{}
+	}
+
+	qs := make([]query.Q, 0, len(batchArgs.Queries))
+	for _, raw := range batchArgs.Queries {
+		q, err := query.Parse(raw)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if batchArgs.RepoIDs != nil {
+			q = query.NewAnd(q, query.NewRepoIDs(*batchArgs.RepoIDs...))
+		}
+		qs = append(qs, q)
+	}
+
+	// Set a timeout if the user hasn't specified one.
+	if batchArgs.Opts.MaxWallTime == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	// A single estimate over the union of all queries, rather than one
+	// estimate per query, keeps the cost of a batch close to a single search.
+	if err := CalculateDefaultSearchLimits(ctx, query.NewOr(qs...), s.Searcher, batchArgs.Opts); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	results, err := zoekt.BatchSearch(ctx, s.Searcher, qs, batchArgs.Opts)
+	if err != nil {
+		jsonError(w, searchErrorStatus(err), err.Error())
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonBatchReply{results})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+// jsonDuplicates returns all documents whose content is identical to the
+// given content, see zoekt.FindDuplicates.
+func (s *jsonSearcher) jsonDuplicates(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "POST" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only POST is supported")
+		return
+	}
+
+	args := jsonDuplicatesArgs{}
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var q query.Q = &query.Const{Value: true}
+	if args.Q != "" {
+		q, err = query.Parse(args.Q)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if args.RepoIDs != nil {
+		q = query.NewAnd(q, query.NewRepoIDs(*args.RepoIDs...))
+	}
+
+	// Set a timeout if the user hasn't specified one.
+	if args.Opts == nil || args.Opts.MaxWallTime == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	files, err := zoekt.FindDuplicates(ctx, s.Searcher, q, []byte(args.Content), args.Opts)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonDuplicatesReply{files})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+// jsonSource returns the whole document given by the repo, path and optional
+// branch URL parameters, see zoekt.FetchDocument.
+func (s *jsonSearcher) jsonSource(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	if req.Method != "GET" {
+		jsonError(w, http.StatusMethodNotAllowed, "Only GET is supported")
+		return
+	}
+
+	v := req.URL.Query()
+	docReq := &zoekt.DocumentRequest{
+		Repository: v.Get("repo"),
+		FileName:   v.Get("path"),
+		Branch:     v.Get("branch"),
+	}
+	if docReq.Repository == "" || docReq.FileName == "" {
+		jsonError(w, http.StatusBadRequest, "repo and path are required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	doc, err := zoekt.FetchDocument(ctx, s.Searcher, docReq)
+	if errors.Is(err, zoekt.ErrDocumentNotFound) {
+		jsonError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(jsonSourceReply{doc})
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+// jsonRepoStats returns the statistics of the repository with the ID in
+// the URL path, including its languages, summed up across its shards.
+func (s *jsonSearcher) jsonRepoStats(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	id, err := strconv.ParseUint(req.PathValue("id"), 10, 32)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, fmt.Sprintf("invalid repository ID %q", req.PathValue("id")))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), defaultTimeout)
+	defer cancel()
+
+	rl, err := s.Searcher.List(ctx, query.NewRepoIDs(uint32(id)), nil)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(rl.Repos) == 0 {
+		jsonError(w, http.StatusNotFound, fmt.Sprintf("repository %d not found", id))
+		return
+	}
+
+	// The shards searcher merges the entries of a repository, other searchers
+	// may return one per shard.
+	reply := jsonRepoStatsReply{ID: uint32(id), Name: rl.Repos[0].Repository.Name}
+	for _, r := range rl.Repos {
+		reply.Stats.Add(&r.Stats)
+	}
+	reply.Stats.Repos = 1
+
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+// searchErrorStatus returns the status code for a failed search. Queries
+// rejected as too expensive are the fault of the client.
+func searchErrorStatus(err error) int {
+	var tooExpensive *zoekt.QueryTooExpensiveError
+	if errors.As(err, &tooExpensive) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func jsonError(w http.ResponseWriter, statusCode int, err string) {
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(struct{ Error string }{Error: err})
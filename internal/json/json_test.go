@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/sourcegraph/zoekt"
@@ -200,3 +201,215 @@ func mustParse(s string) query.Q {
 	}
 	return q
 }
+
+func TestClientServerBatch(t *testing.T) {
+	searchQuery := "hello"
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: mustParse(searchQuery),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{
+				{FileName: "bin.go"},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock))
+	defer ts.Close()
+
+	batchBody, err := json.Marshal(struct{ Queries []string }{Queries: []string{searchQuery, searchQuery}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.Post(ts.URL+"/batch", "application/json", bytes.NewBuffer(batchBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+
+	var batchResult struct{ Results []*zoekt.SearchResult }
+	err = json.NewDecoder(r.Body).Decode(&batchResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batchResult.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(batchResult.Results))
+	}
+	for _, sr := range batchResult.Results {
+		if !reflect.DeepEqual(sr, mock.SearchResult) {
+			t.Fatalf("\na %+v\nb %+v", sr, mock.SearchResult)
+		}
+	}
+
+	for _, body := range []string{"{}", `{"Queries": [` + strings.Repeat(`"hello",`, zoekt.MaxBatchSize) + `"hello"]}`} {
+		r, err = http.Post(ts.URL+"/batch", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Got status code %d, want %d", r.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestClientServerGet(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: mustParse("needle"),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{
+				{FileName: "a.go"},
+				{FileName: "b.go"},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock))
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL + "/search?q=needle&num=5&ctx=2&fields=filename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+	var searchResult struct{ Result *zoekt.SearchResult }
+	if err := json.NewDecoder(r.Body).Decode(&searchResult); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(searchResult.Result, mock.SearchResult) {
+		t.Fatalf("\na %+v\nb %+v", searchResult.Result, mock.SearchResult)
+	}
+
+	r, err = http.Get(ts.URL + "/search?q=needle&format=ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 2 {
+		t.Fatalf("got %d lines, want one per file:\n%s", len(lines), body)
+	}
+
+	for _, params := range []string{"format=html", "ctx=11", "format=xml", "fields=content"} {
+		r, err = http.Get(ts.URL + "/search?q=needle&" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: got status code %d, want %d", params, r.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantSearch: query.NewAnd(
+			query.NewRepoSet("repo"),
+			&query.FileNameSet{Set: map[string]struct{}{"a.go": {}}},
+		),
+		SearchResult: &zoekt.SearchResult{
+			Files: []zoekt.FileMatch{
+				{Repository: "repo", FileName: "a.go", Branches: []string{"dev"}, Content: []byte("dev")},
+				{Repository: "repo", FileName: "a.go", Branches: []string{"HEAD"}, Content: []byte("head")},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock))
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL + "/source?repo=repo&path=a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+	var reply struct{ Document *zoekt.Document }
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(reply.Document.Content); got != "head" {
+		t.Fatalf("got content %q, want the HEAD version", got)
+	}
+
+	mock.SearchResult = &zoekt.SearchResult{}
+	for params, want := range map[string]int{
+		"repo=repo&path=a.go": http.StatusNotFound,
+		"repo=repo":           http.StatusBadRequest,
+	} {
+		r, err := http.Get(ts.URL + "/source?" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.StatusCode != want {
+			t.Errorf("%s: got status code %d, want %d", params, r.StatusCode, want)
+		}
+	}
+}
+
+func TestRepoStats(t *testing.T) {
+	mock := &mockSearcher.MockSearcher{
+		WantList: query.NewRepoIDs(7),
+		RepoList: &zoekt.RepoList{
+			Repos: []*zoekt.RepoListEntry{{
+				Repository: zoekt.Repository{ID: 7, Name: "repo"},
+				Stats: zoekt.RepoStats{
+					Shards:    1,
+					Documents: 3,
+					Languages: map[string]zoekt.LanguageStats{
+						"Go":       {Files: 2, Bytes: 300},
+						"Markdown": {Files: 1, Bytes: 100},
+					},
+				},
+			}},
+		},
+	}
+
+	ts := httptest.NewServer(zjson.JSONServer(mock))
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL + "/repos/7/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != 200 {
+		body, _ := io.ReadAll(r.Body)
+		t.Fatalf("Got status code %d, err %s", r.StatusCode, string(body))
+	}
+	var reply struct {
+		ID    uint32
+		Name  string
+		Stats zoekt.RepoStats
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ID != 7 || reply.Name != "repo" {
+		t.Errorf("got repository %d %q, want 7 \"repo\"", reply.ID, reply.Name)
+	}
+	if want := mock.RepoList.Repos[0].Stats.Languages; !reflect.DeepEqual(reply.Stats.Languages, want) {
+		t.Errorf("got languages %v, want %v", reply.Stats.Languages, want)
+	}
+
+	mock.RepoList = &zoekt.RepoList{}
+	for path, want := range map[string]int{
+		"/repos/7/stats":   http.StatusNotFound,
+		"/repos/abc/stats": http.StatusBadRequest,
+	} {
+		r, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.StatusCode != want {
+			t.Errorf("%s: got status code %d, want %d", path, r.StatusCode, want)
+		}
+	}
+}
@@ -28,6 +28,18 @@ var unsupportedByLinguistAliasMap = map[string]string{
 	"magik": "Magik",
 }
 
+// commonAliasMap contains short names users commonly type in lang: filters
+// which linguist does not list as aliases. Keys must be lowercase.
+var commonAliasMap = map[string]string{
+	"cs":     "C#",
+	"jsx":    "JavaScript",
+	"kt":     "Kotlin",
+	"objcpp": "Objective-C++",
+	"py":     "Python",
+	"ps1":    "PowerShell",
+	"sc":     "Scala",
+}
+
 var unsupportedByLinguistExtensionToNameMap = map[string]string{
 	".apex":    "Apex",
 	".apxt":    "Apex",
@@ -41,34 +53,76 @@ var unsupportedByLinguistExtensionToNameMap = map[string]string{
 }
 
 // getLanguagesByAlias is a replacement for enry.GetLanguagesByAlias
-// It supports languages that are missing in linguist
+// It supports languages that are missing in linguist, as well as common short
+// names such as "py" or "kt".
 func GetLanguageByAlias(alias string) (language string, ok bool) {
 	language, ok = enry.GetLanguageByAlias(alias)
 	if !ok {
 		normalizedAlias := strings.ToLower(alias)
 		language, ok = unsupportedByLinguistAliasMap[normalizedAlias]
+		if !ok {
+			language, ok = commonAliasMap[normalizedAlias]
+		}
 	}
 
 	return
 }
 
+// filenamePrefixToNameMap maps prefixes of file names to languages, for
+// variants of well-known file names which linguist doesn't list, eg.
+// Dockerfile.prod. The prefixes include the separator.
+var filenamePrefixToNameMap = []struct {
+	prefix, language string
+}{
+	{"Dockerfile.", "Dockerfile"},
+	{"Dockerfile-", "Dockerfile"},
+	{"Containerfile.", "Dockerfile"},
+	{"Jenkinsfile.", "Groovy"},
+}
+
+// classifyLimit is the number of bytes of content go-enry looks at to
+// classify a file, so that detecting the language of large files stays cheap.
+const classifyLimit = 16 << 10
+
 // GetLanguage is a replacement for enry.GetLanguage
 // to find out the most probable language to return but includes support
 // for languages missing from linguist
+//
+// Files with an extension which only one language uses skip the content
+// based strategies of go-enry. Otherwise the language is detected from the
+// file name, a shebang or modeline and, if those are ambiguous, by
+// classifying the first classifyLimit bytes of content. This gives
+// extensionless scripts, Dockerfiles and extensions shared by several
+// languages, such as .h or .m, the language of their content.
 func GetLanguage(filename string, content []byte) (language string) {
+	if lang, safe := enry.GetLanguageByExtension(filename); safe {
+		return lang
+	}
+
+	if len(content) > classifyLimit {
+		content = content[:classifyLimit]
+	}
 	language = enry.GetLanguage(filename, content)
+	if language != "" {
+		return
+	}
+
+	base := filepath.Base(filename)
+	for _, p := range filenamePrefixToNameMap {
+		if strings.HasPrefix(base, p.prefix) {
+			return p.language
+		}
+	}
 
 	// If go-enry failed to find language, fall back on our
 	// internal check for languages missing in linguist
-	if language == "" {
-		ext := filepath.Ext(filename)
-		normalizedExt := strings.ToLower(ext)
-		if ext == "" {
-			return
-		}
-		if lang, ok := unsupportedByLinguistExtensionToNameMap[normalizedExt]; ok {
-			language = lang
-		}
+	ext := filepath.Ext(filename)
+	normalizedExt := strings.ToLower(ext)
+	if ext == "" {
+		return
+	}
+	if lang, ok := unsupportedByLinguistExtensionToNameMap[normalizedExt]; ok {
+		language = lang
 	}
 	return
 }
@@ -45,6 +45,18 @@ func TestGetLanguageByAlias(t *testing.T) {
 			want:   "Apex",
 			wantOk: true,
 		},
+		{
+			name:   "common alias",
+			alias:  "py",
+			want:   "Python",
+			wantOk: true,
+		},
+		{
+			name:   "common alias normalized",
+			alias:  "KT",
+			want:   "Kotlin",
+			wantOk: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -94,6 +106,42 @@ func TestGetLanguage(t *testing.T) {
 			content:  []byte(""),
 			want:     "Apex",
 		},
+		{
+			name:     "extensionless script",
+			filename: "bin/build",
+			content:  []byte("#!/usr/bin/env python3\nprint(1)\n"),
+			want:     "Python",
+		},
+		{
+			name:     "dockerfile",
+			filename: "Dockerfile",
+			content:  []byte("FROM alpine\n"),
+			want:     "Dockerfile",
+		},
+		{
+			name:     "dockerfile variant",
+			filename: "deploy/Dockerfile.prod",
+			content:  []byte("FROM alpine\n"),
+			want:     "Dockerfile",
+		},
+		{
+			name:     "ambiguous extension: C++ header",
+			filename: "foo.h",
+			content:  []byte("#include <iostream>\nnamespace foo {\nclass Bar { public: int x; };\n}\n"),
+			want:     "C++",
+		},
+		{
+			name:     "ambiguous extension: Objective-C header",
+			filename: "foo.h",
+			content:  []byte("#import <Foundation/Foundation.h>\n@interface Foo : NSObject\n@end\n"),
+			want:     "Objective-C",
+		},
+		{
+			name:     "modeline",
+			filename: "foo.txt",
+			content:  []byte("# vim: set ft=python:\nprint(1)\n"),
+			want:     "Python",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,161 @@
+package licenses
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// expression is a parsed SPDX license expression, see
+// https://spdx.github.io/spdx-spec/v2.3/SPDX-license-expressions/. Leaves hold
+// a lowercase license identifier, optionally with an exception added by WITH.
+// Inner nodes combine their arguments with "and" or "or".
+type expression struct {
+	id        string
+	exception string
+
+	op   string
+	args []*expression
+}
+
+var spdxTokenRe = regexp.MustCompile(`\(|\)|[A-Za-z0-9.+:-]+`)
+
+// parseExpression parses an SPDX license expression. AND binds tighter than
+// OR, and WITH binds tighter than both.
+func parseExpression(s string) (*expression, error) {
+	// Expressions in source files are often followed by the end of a
+	// comment, eg. "MIT */" or "MIT -->".
+	s = strings.TrimSpace(s)
+	for _, suffix := range []string{"*/", "-->"} {
+		s = strings.TrimSpace(strings.TrimSuffix(s, suffix))
+	}
+
+	p := &exprParser{tokens: spdxTokenRe.FindAllString(s, -1)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty license expression")
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in license expression %q", p.tokens[p.pos], s)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *exprParser) parseOr() (*expression, error) {
+	return p.parseBinary("or", p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (*expression, error) {
+	return p.parseBinary("and", p.parseWith)
+}
+
+func (p *exprParser) parseBinary(op string, next func() (*expression, error)) (*expression, error) {
+	e, err := next()
+	if err != nil {
+		return nil, err
+	}
+	args := []*expression{e}
+	for p.peek() == op {
+		p.pos++
+		e, err := next()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return &expression{op: op, args: args}, nil
+}
+
+func (p *exprParser) parseWith() (*expression, error) {
+	e, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() == "with" {
+		p.pos++
+		exception := p.peek()
+		if exception == "" || exception == "(" || exception == ")" {
+			return nil, fmt.Errorf("missing exception after WITH")
+		}
+		p.pos++
+		if e.id == "" {
+			return nil, fmt.Errorf("WITH must follow a license identifier")
+		}
+		e.exception = exception
+	}
+	return e, nil
+}
+
+func (p *exprParser) parseAtom() (*expression, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of license expression")
+	case "(":
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return e, nil
+	case ")", "and", "or", "with":
+		return nil, fmt.Errorf("unexpected %q in license expression", tok)
+	default:
+		p.pos++
+		return &expression{id: tok}, nil
+	}
+}
+
+// identifiers returns the license identifiers mentioned in e.
+func (e *expression) identifiers() []string {
+	if e.op == "" {
+		return []string{e.id}
+	}
+	var ids []string
+	for _, a := range e.args {
+		ids = append(ids, a.identifiers()...)
+	}
+	return ids
+}
+
+// String returns the canonical lowercase form of e.
+func (e *expression) String() string {
+	if e.op == "" {
+		if e.exception != "" {
+			return e.id + " with " + e.exception
+		}
+		return e.id
+	}
+	parts := make([]string, 0, len(e.args))
+	for _, a := range e.args {
+		// AND binds tighter than OR, so only OR needs parentheses inside
+		// AND.
+		if a.op == "or" && e.op == "and" {
+			parts = append(parts, "("+a.String()+")")
+		} else {
+			parts = append(parts, a.String())
+		}
+	}
+	return strings.Join(parts, " "+e.op+" ")
+}
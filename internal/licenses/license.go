@@ -0,0 +1,165 @@
+// Package licenses recognizes common open source licenses in LICENSE files.
+//
+// The classification is intentionally simple: we look for phrases which are
+// characteristic of a license text rather than doing a full SPDX template
+// match. This recognizes the unmodified license texts as published by the
+// license authors, which covers the vast majority of repositories.
+package licenses
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxLicenseSize is the largest file we consider when detecting a license.
+// Real license texts are much smaller, anything bigger is unlikely to be a
+// license on its own.
+const maxLicenseSize = 256 << 10
+
+var licenseFileRe = regexp.MustCompile(`(?i)^(un)?(licen[cs]e|copying)(\.(md|markdown|txt|rst))?$`)
+
+// IsLicenseFile returns true if name looks like the name of a file holding the
+// license of a repository, eg. LICENSE, COPYING or LICENSE.md.
+func IsLicenseFile(name string) bool {
+	return licenseFileRe.MatchString(path.Base(name))
+}
+
+var spdxIdentifierRe = regexp.MustCompile(`(?i)SPDX-License-Identifier:[ \t]*([^\r\n]+)`)
+
+var (
+	nonWordRe = regexp.MustCompile(`[^a-z0-9.]+`)
+	versionRe = regexp.MustCompile(` v([0-9])`)
+)
+
+// rule maps a set of phrases to an SPDX identifier. All phrases must occur in
+// the normalized license text and none of the exclusions for the rule to
+// match.
+type rule struct {
+	id         string
+	phrases    []string
+	exclusions []string
+}
+
+// rules are checked in order, so more specific licenses must come before
+// licenses that share phrases with them, eg. the LGPL before the GPL. The
+// phrases include the title and date lines of the license texts where
+// possible, so that a file which merely mentions a license, eg. in a license
+// header, isn't mistaken for the license text.
+var rules = []rule{
+	{id: "agpl-3.0", phrases: []string{"gnu affero general public license", "version 3 19 november 2007"}},
+	{id: "lgpl-3.0", phrases: []string{"gnu lesser general public license", "version 3 29 june 2007"}},
+	{id: "lgpl-2.1", phrases: []string{"gnu lesser general public license", "version 2.1 february 1999"}},
+	{id: "lgpl-2.0", phrases: []string{"gnu library general public license", "version 2 june 1991"}},
+	{id: "gpl-3.0", phrases: []string{"gnu general public license", "version 3 29 june 2007"}},
+	{id: "gpl-2.0", phrases: []string{"gnu general public license", "version 2 june 1991"}},
+	{id: "apache-2.0", phrases: []string{"apache license version 2.0 january 2004"}},
+	{id: "mpl-2.0", phrases: []string{"mozilla public license version 2.0"}, exclusions: []string{"incompatible with secondary licenses"}},
+	{id: "epl-2.0", phrases: []string{"eclipse public license v 2.0"}},
+	{id: "epl-1.0", phrases: []string{"eclipse public license v 1.0"}},
+	{id: "bsl-1.0", phrases: []string{"boost software license version 1.0"}},
+	{id: "cc0-1.0", phrases: []string{"cc0 1.0 universal"}},
+	{id: "unlicense", phrases: []string{"this is free and unencumbered software released into the public domain"}},
+	{
+		id:         "mit",
+		phrases:    []string{"permission is hereby granted free of charge to any person obtaining a copy", "the above copyright notice and this permission notice shall be included"},
+		exclusions: []string{"x consortium"},
+	},
+	{id: "mit-0", phrases: []string{"permission is hereby granted free of charge to any person obtaining a copy"}, exclusions: []string{"x consortium"}},
+	{id: "isc", phrases: []string{"permission to use copy modify and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{id: "bsd-4-clause", phrases: []string{"redistribution and use in source and binary forms with or without modification are permitted", "all advertising materials mentioning features or use of this software"}},
+	{id: "bsd-3-clause", phrases: []string{"redistribution and use in source and binary forms with or without modification are permitted", "endorse or promote products"}},
+	{id: "bsd-2-clause", phrases: []string{"redistribution and use in source and binary forms with or without modification are permitted"}},
+}
+
+// Detect returns the lowercase SPDX license expression of the license in
+// content, eg. "mit" or "mit or apache-2.0", or the empty string if the
+// license is not recognized.
+func Detect(content []byte) string {
+	if len(content) > maxLicenseSize {
+		return ""
+	}
+
+	if m := spdxIdentifierRe.FindSubmatch(content); m != nil {
+		if expr, err := parseExpression(string(m[1])); err == nil {
+			return expr.String()
+		}
+	}
+
+	text := normalize(string(content))
+	for _, r := range rules {
+		if containsAll(text, r.phrases) && !containsAny(text, r.exclusions) {
+			return r.id
+		}
+	}
+	return ""
+}
+
+// Matches returns true if the SPDX license expression expr, as returned by
+// Detect or Combine, mentions the license identifier id. Matching is case
+// insensitive and ignores "or later" suffixes, so "gpl-2.0" matches
+// "gpl-2.0+ or mit".
+func Matches(expr, id string) bool {
+	if expr == "" || id == "" {
+		return false
+	}
+	e, err := parseExpression(expr)
+	if err != nil {
+		return strings.EqualFold(expr, id)
+	}
+	id = strings.TrimSuffix(strings.ToLower(id), "+")
+	for _, got := range e.identifiers() {
+		if strings.TrimSuffix(got, "+") == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Combine joins the license expressions of several branches of a repository
+// with AND. Duplicates and empty expressions are dropped.
+func Combine(exprs []string) string {
+	seen := map[string]bool{}
+	var parts []*expression
+	for _, s := range exprs {
+		e, err := parseExpression(s)
+		if err != nil || seen[e.String()] {
+			continue
+		}
+		seen[e.String()] = true
+		parts = append(parts, e)
+	}
+	switch len(parts) {
+	case 0:
+		return ""
+	case 1:
+		return parts[0].String()
+	}
+	return (&expression{op: "and", args: parts}).String()
+}
+
+// normalize lowercases s and collapses punctuation and whitespace into single
+// spaces so that line wrapping and formatting don't affect matching.
+func normalize(s string) string {
+	s = nonWordRe.ReplaceAllString(strings.ToLower(s), " ")
+	// "v2.0" and "v 2.0" are both common spellings.
+	return versionRe.ReplaceAllString(s, " v $1")
+}
+
+func containsAny(s string, phrases []string) bool {
+	for _, p := range phrases {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(s string, phrases []string) bool {
+	for _, p := range phrases {
+		if !strings.Contains(s, p) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package licenses
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{{
+		name: "mit",
+		content: `MIT License
+
+Copyright (c) 2024 Foo
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+...
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.`,
+		want: "mit",
+	}, {
+		name: "mit-0 lacks the notice condition",
+		content: `Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction.`,
+		want: "mit-0",
+	}, {
+		name: "x11 is not mit",
+		content: `Permission is hereby granted, free of charge, to any person obtaining a copy
+...
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+...
+Except as contained in this notice, the name of the X Consortium shall not be`,
+		want: "",
+	}, {
+		name: "apache license header is not the license text",
+		content: `Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.`,
+		want: "",
+	}, {
+		name: "gpl-3.0 or later notice is not gpl-2.0",
+		content: `This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 2 of the License, or
+(at your option) any later version.`,
+		want: "",
+	}, {
+		name: "apache",
+		content: `
+                                 Apache License
+                           Version 2.0, January 2004
+                        http://www.apache.org/licenses/`,
+		want: "apache-2.0",
+	}, {
+		name: "lgpl before gpl",
+		content: `GNU LESSER GENERAL PUBLIC LICENSE
+                       Version 3, 29 June 2007
+
+  This version of the GNU Lesser General Public License incorporates
+the terms and conditions of version 3 of the GNU General Public
+License`,
+		want: "lgpl-3.0",
+	}, {
+		name: "gpl-2.0",
+		content: `		    GNU GENERAL PUBLIC LICENSE
+		       Version 2, June 1991`,
+		want: "gpl-2.0",
+	}, {
+		name: "bsd-3-clause",
+		content: `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+...
+3. Neither the name of the copyright holder nor the names of its
+   contributors may be used to endorse or promote products derived from`,
+		want: "bsd-3-clause",
+	}, {
+		name: "bsd-4-clause",
+		content: `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+...
+3. All advertising materials mentioning features or use of this software
+   must display the following acknowledgement:
+4. Neither the name of the copyright holder nor the names of its
+   contributors may be used to endorse or promote products derived from`,
+		want: "bsd-4-clause",
+	}, {
+		name:    "epl",
+		content: `Eclipse Public License - v2.0`,
+		want:    "epl-2.0",
+	}, {
+		name:    "spdx identifier",
+		content: "SPDX-License-Identifier: MPL-2.0\n",
+		want:    "mpl-2.0",
+	}, {
+		name:    "spdx expression",
+		content: "// SPDX-License-Identifier: MIT OR Apache-2.0\n",
+		want:    "mit or apache-2.0",
+	}, {
+		name:    "spdx expression with exception and parentheses",
+		content: "/* SPDX-License-Identifier: (GPL-2.0-only WITH Linux-syscall-note) AND (BSD-2-Clause OR MIT) */\n",
+		want:    "gpl-2.0-only with linux-syscall-note and (bsd-2-clause or mit)",
+	}, {
+		name:    "unknown",
+		content: "All rights reserved.",
+		want:    "",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect([]byte(tt.content)); got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsLicenseFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"LICENSE":     true,
+		"license.md":  true,
+		"LICENCE.txt": true,
+		"COPYING":     true,
+		"UNLICENSE":   true,
+		"LICENSE.go":  false,
+		"licenses":    false,
+		"README.md":   false,
+	} {
+		if got := IsLicenseFile(name); got != want {
+			t.Errorf("IsLicenseFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expr, id string
+		want     bool
+	}{
+		{"mit", "MIT", true},
+		{"mit or apache-2.0", "apache-2.0", true},
+		{"mit or apache-2.0", "mit", true},
+		{"mit or apache-2.0", "bsd-3-clause", false},
+		{"gpl-2.0+ and (bsd-2-clause or mit)", "gpl-2.0", true},
+		{"gpl-2.0-only with linux-syscall-note", "linux-syscall-note", false},
+		{"gpl-2.0-only with linux-syscall-note", "gpl-2.0-only", true},
+		{"mit-0", "mit", false},
+		{"", "mit", false},
+	}
+	for _, tt := range tests {
+		if got := Matches(tt.expr, tt.id); got != tt.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", tt.expr, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	tests := []struct {
+		exprs []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"", ""}, ""},
+		{[]string{"mit", "", "mit"}, "mit"},
+		{[]string{"mit", "apache-2.0"}, "mit and apache-2.0"},
+		{[]string{"mit or apache-2.0", "gpl-3.0"}, "(mit or apache-2.0) and gpl-3.0"},
+	}
+	for _, tt := range tests {
+		if got := Combine(tt.exprs); got != tt.want {
+			t.Errorf("Combine(%q) = %q, want %q", tt.exprs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package merging holds the rules which decide whether a shard may be merged
+// into a compound shard. They are shared by zoekt-sourcegraph-indexserver,
+// which merges shards continuously, and zoekt-merge-index plan.
+package merging
+
+import (
+	"os"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+var reShard = regexp.MustCompile(`\.[0-9]{5}\.zoekt$`)
+
+// HasMultipleShards returns true if the shard at path belongs to a
+// repository which is split over several shards.
+func HasMultipleShards(path string) bool {
+	if !reShard.MatchString(path) {
+		return false
+	}
+	secondShard := reShard.ReplaceAllString(path, ".00001.zoekt")
+	_, err := os.Stat(secondShard)
+	return !os.IsNotExist(err)
+}
+
+// Eligible returns the repository of the shard at path if the shard may be
+// merged. Repositories split over several shards, compound shards and
+// repositories with commits in the last minAgeDays are not eligible. The
+// error is non-nil if the metadata of the shard can't be read, in which case
+// the shard is not eligible either.
+func Eligible(path string, minAgeDays int) (*zoekt.Repository, bool, error) {
+	if HasMultipleShards(path) {
+		return nil, false, nil
+	}
+
+	repos, _, err := index.ReadMetadataPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Exclude compound shards from being merge targets. Why? We want repositories in a
+	// compound shard to be ordered based on their priority. The easiest way to
+	// enforce this is to delete the compound shard once it drops below a certain
+	// size (handled by cleanup), reindex the repositories and merge them with other
+	// shards in the correct order.
+	if len(repos) != 1 {
+		return nil, false, nil
+	}
+
+	if repos[0].LatestCommitDate.After(time.Now().AddDate(0, 0, -minAgeDays)) {
+		return nil, false, nil
+	}
+
+	return repos[0], true, nil
+}
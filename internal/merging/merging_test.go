@@ -0,0 +1,76 @@
+package merging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestHasMultipleShards(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		file                  string
+		wantHasMultipleShards bool
+	}{
+		{"large.00000.zoekt", true},
+		{"large.00001.zoekt", true},
+		{"small.00000.zoekt", false},
+		{"compound-foo.00000.zoekt", false},
+		{"else", false},
+	}
+
+	for _, c := range cases {
+		_, err := os.Create(filepath.Join(dir, c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := HasMultipleShards(filepath.Join(dir, tt.file)); got != tt.wantHasMultipleShards {
+				t.Fatalf("want %t, got %t", tt.wantHasMultipleShards, got)
+			}
+		})
+	}
+}
+
+func TestEligible(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, latestCommit time.Time) string {
+		t.Helper()
+		b, err := index.NewShardBuilder(&zoekt.Repository{Name: name, LatestCommitDate: latestCommit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name+"_v16.00000.zoekt")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := b.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	old := write("old", time.Now().AddDate(0, 0, -30))
+	recent := write("recent", time.Now())
+
+	if repo, ok, err := Eligible(old, 7); err != nil || !ok || repo.Name != "old" {
+		t.Errorf("old: got %v, %t, %v", repo, ok, err)
+	}
+	if _, ok, err := Eligible(recent, 7); err != nil || ok {
+		t.Errorf("recent: got %t, %v, want not eligible", ok, err)
+	}
+	if _, ok, err := Eligible(filepath.Join(dir, "missing_v16.00000.zoekt"), 7); err == nil || ok {
+		t.Errorf("missing: got %t, %v, want error", ok, err)
+	}
+}
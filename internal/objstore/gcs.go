@@ -0,0 +1,193 @@
+package objstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsReadWriteScope is the OAuth 2.0 scope of the access tokens we request.
+const gcsReadWriteScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsBucket reads objects with the JSON API of GCS.
+type gcsBucket struct {
+	bucket, prefix string
+
+	// endpoint is the URL of the API, without a trailing slash.
+	endpoint string
+
+	// tokens authorizes requests. Requests are anonymous if it is nil.
+	tokens oauth2.TokenSource
+
+	client *http.Client
+}
+
+// newGCSBucket returns the bucket with the application default credentials:
+// the service account key or user credentials in the file
+// GOOGLE_APPLICATION_CREDENTIALS names or gcloud created, or the default
+// service account when running on Google Cloud. It fails if there are none,
+// unless requests should be anonymous.
+func newGCSBucket(ctx context.Context, bucket, prefix string, getenv func(string) string, anonymous bool) (*gcsBucket, error) {
+	b := &gcsBucket{
+		bucket:   bucket,
+		prefix:   prefix,
+		endpoint: "https://storage.googleapis.com",
+		client:   http.DefaultClient,
+	}
+	if host := getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		b.endpoint = strings.TrimSuffix(host, "/")
+		anonymous = true
+	}
+	if anonymous {
+		return b, nil
+	}
+
+	var creds *google.Credentials
+	if path := getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("credentials for %s: %w", b, err)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, gcsReadWriteScope)
+		if err != nil {
+			return nil, fmt.Errorf("credentials for %s: %s: %w", b, path, err)
+		}
+	} else {
+		var err error
+		creds, err = google.FindDefaultCredentials(ctx, gcsReadWriteScope)
+		if err != nil {
+			return nil, fmt.Errorf("no credentials for %s, set GOOGLE_APPLICATION_CREDENTIALS or add ?anonymous=true to the URL of a public bucket: %w", b, err)
+		}
+	}
+
+	// Fail now rather than on the first request if the credentials don't
+	// work, eg. because the key was revoked.
+	if _, err := creds.TokenSource.Token(); err != nil {
+		return nil, fmt.Errorf("credentials for %s: %w", b, err)
+	}
+	b.tokens = creds.TokenSource
+	return b, nil
+}
+
+type gcsListResult struct {
+	NextPageToken string
+	Items         []struct {
+		Name    string
+		Size    string
+		Updated time.Time
+	}
+}
+
+func (b *gcsBucket) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		q := url.Values{"prefix": {b.prefix}, "delimiter": {"/"}, "fields": {"items(name,size,updated),nextPageToken"}}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		resp, err := b.do(ctx, http.MethodGet, b.endpoint+"/storage/v1/b/"+url.PathEscape(b.bucket)+"/o?"+q.Encode(), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var res gcsListResult
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding objects of %s: %w", b, err)
+		}
+
+		for _, item := range res.Items {
+			key, ok := relativeKey(b.prefix, item.Name)
+			if !ok {
+				continue
+			}
+			size, err := strconv.ParseInt(item.Size, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("size of %s: %w", item.Name, err)
+			}
+			objects = append(objects, Object{Key: key, Size: size, Updated: item.Updated})
+		}
+		if res.NextPageToken == "" {
+			return objects, nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+func (b *gcsBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.objectURL(key)+"?alt=media", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *gcsBucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	q := url.Values{"uploadType": {"media"}, "name": {b.prefix + key}}
+	resp, err := b.do(ctx, http.MethodPost, b.endpoint+"/upload/storage/v1/b/"+url.PathEscape(b.bucket)+"/o?"+q.Encode(), r, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *gcsBucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.objectURL(key), nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// objectURL returns the URL of the metadata of the object with key.
+func (b *gcsBucket) objectURL(key string) string {
+	return b.endpoint + "/storage/v1/b/" + url.PathEscape(b.bucket) + "/o/" + url.PathEscape(b.prefix+key)
+}
+
+func (b *gcsBucket) String() string {
+	return "gs://" + b.bucket + "/" + b.prefix
+}
+
+// do sends a request for rawURL with the size bytes of body, authorized
+// unless the bucket is anonymous.
+func (b *gcsBucket) do(ctx context.Context, method, rawURL string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	if b.tokens != nil {
+		token, err := b.tokens.Token()
+		if err != nil {
+			return nil, fmt.Errorf("access token for %s: %w", b, err)
+		}
+		token.SetAuthHeader(req)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,187 @@
+// Package objstore reads and writes objects in buckets of object storage
+// services like S3 and GCS, so that shards can be loaded and backed up
+// without a shared filesystem.
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object is an object in a bucket.
+type Object struct {
+	// Key is the name of the object relative to the prefix of the bucket.
+	Key     string
+	Size    int64
+	Updated time.Time
+}
+
+// Bucket is a view of the objects under a prefix of a bucket. Keys must not
+// contain slashes.
+type Bucket interface {
+	// List returns the objects directly under the prefix. Objects in
+	// "subdirectories" of the prefix are omitted.
+	List(ctx context.Context) ([]Object, error)
+
+	// Get returns the content of the object with key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Put stores the size bytes of r as the object with key, replacing the
+	// object if it exists.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Delete removes the object with key.
+	Delete(ctx context.Context, key string) error
+
+	String() string
+}
+
+// Open returns the bucket of rawURL, one of
+//
+//	s3://BUCKET/PREFIX
+//	gs://BUCKET/PREFIX
+//	file:///DIR
+//
+// S3 requests are signed with the credentials the AWS SDKs find by default,
+// eg. in AWS_ACCESS_KEY_ID, from a web identity token or from the instance
+// profile, see awsCredentialsChain. AWS_REGION selects the region and
+// AWS_ENDPOINT_URL an S3 compatible service, eg. MinIO. GCS requests use the
+// application default credentials, eg. in GOOGLE_APPLICATION_CREDENTIALS or
+// of the default service account when running on Google Cloud, and
+// STORAGE_EMULATOR_HOST selects a GCS emulator.
+//
+// Open fails if there are no credentials, so that a misconfigured server
+// doesn't silently send anonymous requests. Add ?anonymous=true to the URL to
+// read a public bucket without credentials.
+func Open(rawURL string) (Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	anonymous := false
+	if v := u.Query().Get("anonymous"); v != "" {
+		if anonymous, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid anonymous=%q in bucket URL %q", v, rawURL)
+		}
+	}
+
+	// The credentials keep using the context to refresh tokens, so it must
+	// not expire.
+	ctx := context.Background()
+	switch u.Scheme {
+	case "s3":
+		return newS3Bucket(ctx, u.Host, prefix, os.Getenv, anonymous)
+	case "gs":
+		return newGCSBucket(ctx, u.Host, prefix, os.Getenv, anonymous)
+	case "file":
+		return dirBucket(u.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported bucket URL %q, want s3://, gs:// or file://", rawURL)
+	}
+}
+
+// relativeKey returns key relative to prefix, or false if key is not directly
+// under prefix.
+func relativeKey(prefix, key string) (string, bool) {
+	rel, ok := strings.CutPrefix(key, prefix)
+	if !ok || rel == "" || strings.Contains(rel, "/") {
+		return "", false
+	}
+	return rel, true
+}
+
+// checkResponse returns an error for responses which are not successful, and
+// closes their body.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+}
+
+// validKey returns true if key names an object directly under the prefix of
+// a bucket.
+func validKey(key string) bool {
+	return fs.ValidPath(key) && key != "." && !strings.Contains(key, "/")
+}
+
+// dirBucket is a bucket backed by a local directory, eg. for tests or a
+// network filesystem.
+type dirBucket string
+
+func (d dirBucket) List(ctx context.Context) ([]Object, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, Object{Key: e.Name(), Size: fi.Size(), Updated: fi.ModTime()})
+	}
+	return objects, nil
+}
+
+func (d dirBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	return os.Open(filepath.Join(string(d), key))
+}
+
+// Put writes a temporary file first, so that List and Get never see a
+// partial object.
+func (d dirBucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	f, err := os.CreateTemp(string(d), "."+key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("%s: wrote %d bytes, want %d", key, n, size)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(string(d), key))
+}
+
+func (d dirBucket) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	return os.Remove(filepath.Join(string(d), key))
+}
+
+func (d dirBucket) String() string {
+	return "file://" + string(d)
+}
@@ -0,0 +1,267 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hex encoded SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload replaces the SHA-256 of payloads we stream, so that we
+// don't have to read them twice.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Bucket reads objects with the REST API of S3.
+type s3Bucket struct {
+	bucket, prefix string
+
+	// base is the URL of the bucket, without a trailing slash.
+	base string
+
+	region string
+
+	// creds signs requests, see awsCredentialsChain. Requests are anonymous
+	// if it is nil.
+	creds *awsCredentialsCache
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// newS3Bucket returns the bucket with the credentials of
+// awsCredentialsChain. It fails if there are none, unless requests should be
+// anonymous.
+func newS3Bucket(ctx context.Context, bucket, prefix string, getenv func(string) string, anonymous bool) (*s3Bucket, error) {
+	b := &s3Bucket{
+		bucket: bucket,
+		prefix: prefix,
+		region: getenv("AWS_REGION"),
+		client: http.DefaultClient,
+		now:    time.Now,
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	if endpoint := getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		// S3 compatible services usually only support path-style URLs.
+		b.base = strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	} else {
+		b.base = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, b.region)
+	}
+	if anonymous {
+		return b, nil
+	}
+
+	b.creds = awsCredentialsChain(getenv, b.region, b.client)
+	if b.creds == nil {
+		return nil, fmt.Errorf("no credentials for %s, set AWS_ACCESS_KEY_ID or add ?anonymous=true to the URL of a public bucket", b)
+	}
+	// Fail now rather than on the first request, eg. when we are not running
+	// on EC2.
+	if _, err := b.creds.get(ctx, b.now()); err != nil {
+		return nil, fmt.Errorf("no credentials for %s, set AWS_ACCESS_KEY_ID or add ?anonymous=true to the URL of a public bucket: %w", b, err)
+	}
+	return b, nil
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+func (b *s3Bucket) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {b.prefix}, "delimiter": {"/"}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := b.do(ctx, http.MethodGet, b.base+"/?"+q.Encode(), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var res s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding objects of %s: %w", b, err)
+		}
+
+		for _, c := range res.Contents {
+			if key, ok := relativeKey(b.prefix, c.Key); ok {
+				objects = append(objects, Object{Key: key, Size: c.Size, Updated: c.LastModified})
+			}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (b *s3Bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, b.base+"/"+s3Escape(b.prefix+key), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *s3Bucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := b.do(ctx, http.MethodPut, b.base+"/"+s3Escape(b.prefix+key), r, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *s3Bucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.base+"/"+s3Escape(b.prefix+key), nil, 0)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *s3Bucket) String() string {
+	return "s3://" + b.bucket + "/" + b.prefix
+}
+
+// do sends a request for rawURL with the size bytes of body, signed unless
+// the bucket is anonymous.
+func (b *s3Bucket) do(ctx context.Context, method, rawURL string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		// Without a length the body would be sent chunked, which S3 rejects.
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	if b.creds != nil {
+		now := b.now()
+		creds, err := b.creds.get(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		b.sign(req, creds, now)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 with creds to req. The payload of
+// requests with a body is not signed.
+func (b *s3Bucket) sign(req *http.Request, creds awsCredentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := emptySHA256
+	if req.Body != nil && req.Body != http.NoBody {
+		payloadHash = unsignedPayload
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.EscapedPath()),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape escapes path like S3 expects in URLs and canonical requests: all
+// bytes except unreserved characters and slashes are percent-encoded.
+func s3Escape(path string) string {
+	if p, err := url.PathUnescape(path); err == nil {
+		path = p
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the query string of a canonical request, which is
+// sorted by key and escapes spaces as %20.
+func canonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
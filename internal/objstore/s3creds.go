@@ -0,0 +1,282 @@
+package objstore
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials sign S3 requests.
+type awsCredentials struct {
+	accessKey, secretKey, sessionToken string
+
+	// expires is when temporary credentials expire. It is zero for
+	// credentials which don't.
+	expires time.Time
+}
+
+// awsCredentialsCache hands out the credentials fetch returns until they are
+// about to expire.
+type awsCredentialsCache struct {
+	// source describes where the credentials come from, for errors.
+	source string
+	fetch  func(ctx context.Context) (awsCredentials, error)
+
+	mu    sync.Mutex
+	creds awsCredentials
+}
+
+// get returns credentials which are valid for at least another five minutes
+// at now, so that they do not expire in flight.
+func (c *awsCredentialsCache) get(ctx context.Context, now time.Time) (awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds.accessKey != "" && (c.creds.expires.IsZero() || now.Add(5*time.Minute).Before(c.creds.expires)) {
+		return c.creds, nil
+	}
+	creds, err := c.fetch(ctx)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("credentials from %s: %w", c.source, err)
+	}
+	c.creds = creds
+	return creds, nil
+}
+
+// awsCredentialsChain returns the credentials of the first source of the AWS
+// SDKs' default chain which is configured:
+//
+//  1. AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+//  2. A web identity token, eg. of an EKS service account (IRSA), in
+//     AWS_WEB_IDENTITY_TOKEN_FILE exchanged for the role AWS_ROLE_ARN.
+//  3. The container credentials endpoint of ECS or EKS Pod Identity in
+//     AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or _FULL_URI.
+//  4. The instance profile of an EC2 instance, unless
+//     AWS_EC2_METADATA_DISABLED is true.
+//
+// We don't read the shared credentials and config files.
+func awsCredentialsChain(getenv func(string) string, region string, client *http.Client) *awsCredentialsCache {
+	if accessKey := getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		creds := awsCredentials{
+			accessKey:    accessKey,
+			secretKey:    getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: getenv("AWS_SESSION_TOKEN"),
+		}
+		return &awsCredentialsCache{
+			source: "AWS_ACCESS_KEY_ID",
+			fetch:  func(context.Context) (awsCredentials, error) { return creds, nil },
+		}
+	}
+
+	if tokenFile, roleARN := getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), getenv("AWS_ROLE_ARN"); tokenFile != "" && roleARN != "" {
+		endpoint := getenv("AWS_ENDPOINT_URL_STS")
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+		}
+		sessionName := getenv("AWS_ROLE_SESSION_NAME")
+		if sessionName == "" {
+			sessionName = fmt.Sprintf("zoekt-%d", time.Now().UnixNano())
+		}
+		return &awsCredentialsCache{
+			source: "AWS_WEB_IDENTITY_TOKEN_FILE",
+			fetch: func(ctx context.Context) (awsCredentials, error) {
+				return webIdentityCredentials(ctx, client, endpoint, tokenFile, roleARN, sessionName)
+			},
+		}
+	}
+
+	containerURL := getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri := getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		containerURL = "http://169.254.170.2" + uri
+	}
+	if containerURL != "" {
+		authToken := getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		authTokenFile := getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE")
+		return &awsCredentialsCache{
+			source: "the container credentials endpoint",
+			fetch: func(ctx context.Context) (awsCredentials, error) {
+				token := authToken
+				if authTokenFile != "" {
+					b, err := os.ReadFile(authTokenFile)
+					if err != nil {
+						return awsCredentials{}, err
+					}
+					token = strings.TrimSpace(string(b))
+				}
+				return containerCredentials(ctx, client, containerURL, token)
+			},
+		}
+	}
+
+	if strings.EqualFold(getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil
+	}
+	endpoint := getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	return &awsCredentialsCache{
+		source: "the EC2 instance profile",
+		fetch: func(ctx context.Context) (awsCredentials, error) {
+			return instanceProfileCredentials(ctx, client, strings.TrimSuffix(endpoint, "/"))
+		},
+	}
+}
+
+// webIdentityCredentials exchanges the web identity token in tokenFile for
+// temporary credentials of roleARN with AssumeRoleWithWebIdentity. The
+// request needs no signature.
+func webIdentityCredentials(ctx context.Context, client *http.Client, endpoint, tokenFile, roleARN, sessionName string) (awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return awsCredentials{}, err
+	}
+	c := res.Credentials
+	if c.AccessKeyId == "" {
+		return awsCredentials{}, errors.New("AssumeRoleWithWebIdentity returned no credentials")
+	}
+	return awsCredentials{accessKey: c.AccessKeyId, secretKey: c.SecretAccessKey, sessionToken: c.SessionToken, expires: c.Expiration}, nil
+}
+
+// containerCredentials gets temporary credentials from the container
+// credentials endpoint at rawURL.
+func containerCredentials(ctx context.Context, client *http.Client, rawURL, authToken string) (awsCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", authToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	return decodeAWSCredentials(resp)
+}
+
+// instanceProfileCredentials gets temporary credentials of the instance
+// profile from the EC2 instance metadata service at endpoint, with a session
+// token as IMDSv2 requires.
+func instanceProfileCredentials(ctx context.Context, client *http.Client, endpoint string) (awsCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	do := func(method, path string, header http.Header) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	readAll := func(resp *http.Response) (string, error) {
+		defer resp.Body.Close()
+		b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return strings.TrimSpace(string(b)), err
+	}
+
+	resp, err := do(http.MethodPut, "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}})
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	token, err := readAll(resp)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+
+	resp, err = do(http.MethodGet, "/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	roles, err := readAll(resp)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, _, _ := strings.Cut(roles, "\n")
+	if role == "" {
+		return awsCredentials{}, errors.New("the instance has no instance profile")
+	}
+
+	resp, err = do(http.MethodGet, "/latest/meta-data/iam/security-credentials/"+url.PathEscape(role), header)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	return decodeAWSCredentials(resp)
+}
+
+// decodeAWSCredentials decodes the JSON credentials the container
+// credentials endpoint and the instance metadata service reply with.
+func decodeAWSCredentials(resp *http.Response) (awsCredentials, error) {
+	if err := checkResponse(resp); err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+
+	var c struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return awsCredentials{}, err
+	}
+	if c.AccessKeyId == "" {
+		return awsCredentials{}, errors.New("no credentials in the response")
+	}
+	return awsCredentials{accessKey: c.AccessKeyId, secretKey: c.SecretAccessKey, sessionToken: c.Token, expires: c.Expiration}, nil
+}
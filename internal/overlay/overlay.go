@@ -0,0 +1,425 @@
+// Package overlay implements a zoekt.Streamer which layers the repositories
+// of one searcher over those of another. This allows searching a small index,
+// for example of preview builds, together with the main index without
+// modifying the main index.
+package overlay
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/internal/tenant"
+	"github.com/sourcegraph/zoekt/internal/trace"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Searcher searches an overlay and a base searcher. Repositories in the
+// overlay shadow repositories with the same ID in the base: results for them
+// only come from the overlay. Repositories without an ID shadow those with
+// the same name.
+type Searcher struct {
+	base    zoekt.Streamer
+	overlay zoekt.Streamer
+
+	// mu protects the cached set of shadowed repositories. It is valid as
+	// long as the shards of the overlay have version shadowedVersion.
+	mu              sync.Mutex
+	shadowed        query.Q
+	shadowedVersion uint64
+	shadowedValid   bool
+}
+
+var (
+	_ zoekt.Streamer      = (*Searcher)(nil)
+	_ zoekt.BatchSearcher = (*Searcher)(nil)
+	_ zoekt.Explainer     = (*Searcher)(nil)
+)
+
+// NewSearcher returns a Searcher which layers overlay over base.
+func NewSearcher(base, overlay zoekt.Streamer) *Searcher {
+	return &Searcher{base: base, overlay: overlay}
+}
+
+// shadowedRepos returns a query matching the repositories of the overlay, or
+// nil if it is empty. If the overlay reports the version of its shards, the
+// result is cached until shards are loaded or unloaded.
+func (s *Searcher) shadowedRepos(ctx context.Context) (query.Q, error) {
+	// With tenant enforcement List only returns the repositories of the tenant
+	// in ctx, so the result can't be shared between requests.
+	version, cacheable := shards.Version(s.overlay)
+	cacheable = cacheable && !tenant.EnforceTenant()
+
+	if cacheable {
+		s.mu.Lock()
+		if s.shadowedValid && s.shadowedVersion == version {
+			defer s.mu.Unlock()
+			return s.shadowed, nil
+		}
+		s.mu.Unlock()
+	}
+
+	rl, err := s.overlay.List(ctx, &query.Const{Value: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("overlay: listing shadowed repositories: %w", err)
+	}
+
+	var (
+		ids   []uint32
+		names []string
+	)
+	for _, r := range rl.Repos {
+		if r.Repository.ID != 0 {
+			ids = append(ids, r.Repository.ID)
+		} else {
+			names = append(names, r.Repository.Name)
+		}
+	}
+
+	var qs []query.Q
+	if len(ids) > 0 {
+		qs = append(qs, query.NewRepoIDs(ids...))
+	}
+	if len(names) > 0 {
+		qs = append(qs, query.NewRepoSet(names...))
+	}
+	var shadowed query.Q
+	if len(qs) > 0 {
+		shadowed = query.NewOr(qs...)
+	}
+
+	if cacheable {
+		// version was read before List, so if shards changed in between we
+		// list again on the next call.
+		s.mu.Lock()
+		s.shadowed, s.shadowedVersion, s.shadowedValid = shadowed, version, true
+		s.mu.Unlock()
+	}
+	return shadowed, nil
+}
+
+// baseQuery returns q restricted to the repositories of the base which are not
+// shadowed by the overlay.
+func (s *Searcher) baseQuery(ctx context.Context, q query.Q) (query.Q, error) {
+	shadowed, err := s.shadowedRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if shadowed == nil {
+		return q, nil
+	}
+	notShadowed := &query.Not{Child: shadowed}
+
+	// Keep type: at the root, where shards.typeRepoSearcher expects it.
+	if t, ok := q.(*query.Type); ok {
+		return &query.Type{Type: t.Type, Child: query.NewAnd(t.Child, notShadowed)}, nil
+	}
+	return query.NewAnd(q, notShadowed), nil
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	tr, ctx := trace.New(ctx, "overlay.Search", "")
+	defer tr.Finish()
+
+	start := time.Now()
+
+	bq, err := s.baseQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg                  sync.WaitGroup
+		baseRes, overlayRes *zoekt.SearchResult
+		baseErr, overlayErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		baseRes, baseErr = s.base.Search(ctx, bq, opts)
+	}()
+	go func() {
+		defer wg.Done()
+		overlayRes, overlayErr = s.overlay.Search(ctx, q, opts)
+	}()
+	wg.Wait()
+
+	if baseErr != nil {
+		return nil, baseErr
+	}
+	if overlayErr != nil {
+		return nil, overlayErr
+	}
+
+	agg := mergeResults(opts, baseRes, overlayRes)
+	agg.Stats.Duration = time.Since(start)
+
+	tr.LazyPrintf("num files: %d", len(agg.Files))
+
+	return agg, nil
+}
+
+// mergeResults combines the results of base and overlay for the same query.
+func mergeResults(opts *zoekt.SearchOptions, results ...*zoekt.SearchResult) *zoekt.SearchResult {
+	agg := &zoekt.SearchResult{
+		RepoURLs:      map[string]string{},
+		LineFragments: map[string]string{},
+	}
+	for _, r := range results {
+		agg.Stats.Add(r.Stats)
+		agg.Files = append(agg.Files, r.Files...)
+		for k, v := range r.RepoURLs {
+			agg.RepoURLs[k] = v
+		}
+		for k, v := range r.LineFragments {
+			agg.LineFragments[k] = v
+		}
+		agg.Priority = math.Max(agg.Priority, r.Priority)
+	}
+
+	agg.Files = index.SortAndTruncateFiles(agg.Files, opts)
+	return agg
+}
+
+// BatchSearch runs the batch on base and overlay in one go each, so that a
+// batch-aware base keeps its optimizations.
+func (s *Searcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	tr, ctx := trace.New(ctx, "overlay.BatchSearch", "")
+	defer tr.Finish()
+
+	start := time.Now()
+
+	bqs := make([]query.Q, 0, len(qs))
+	for _, q := range qs {
+		bq, err := s.baseQuery(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		bqs = append(bqs, bq)
+	}
+
+	var (
+		wg                  sync.WaitGroup
+		baseRes, overlayRes []*zoekt.SearchResult
+		baseErr, overlayErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		baseRes, baseErr = zoekt.BatchSearch(ctx, s.base, bqs, opts)
+	}()
+	go func() {
+		defer wg.Done()
+		overlayRes, overlayErr = zoekt.BatchSearch(ctx, s.overlay, qs, opts)
+	}()
+	wg.Wait()
+
+	if baseErr != nil {
+		return nil, baseErr
+	}
+	if overlayErr != nil {
+		return nil, overlayErr
+	}
+
+	results := make([]*zoekt.SearchResult, len(qs))
+	for i := range qs {
+		results[i] = mergeResults(opts, baseRes[i], overlayRes[i])
+		results[i].Stats.Duration = time.Since(start)
+	}
+	return results, nil
+}
+
+// Explain explains the evaluation in the shards of base and overlay. The
+// query of the explanation is the one of the overlay; the shards of the base
+// show how shadowed repositories are excluded.
+func (s *Searcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	tr, ctx := trace.New(ctx, "overlay.Explain", "")
+	defer tr.Finish()
+
+	bq, err := s.baseQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	be, err := zoekt.Explain(ctx, s.base, bq, opts)
+	if err != nil {
+		return nil, err
+	}
+	oe, err := zoekt.Explain(ctx, s.overlay, q, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &zoekt.Explanation{
+		Query:  oe.Query,
+		Shards: append(be.Shards, oe.Shards...),
+	}, nil
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	tr, ctx := trace.New(ctx, "overlay.StreamSearch", "")
+	defer tr.Finish()
+
+	bq, err := s.baseQuery(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	// pending holds the latest MaxPendingPriority reported by base and
+	// overlay, see federation.Searcher.StreamSearch.
+	var mu sync.Mutex
+	pending := []float64{math.Inf(1), math.Inf(1)}
+	maxPending := func() float64 {
+		return math.Max(pending[0], pending[1])
+	}
+	// progress holds the latest progress reported by base and overlay.
+	progress := make([]zoekt.SearchProgress, 2)
+
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, search := range []func(zoekt.Sender) error{
+		func(sender zoekt.Sender) error { return s.base.StreamSearch(ctx, bq, opts, sender) },
+		func(sender zoekt.Sender) error { return s.overlay.StreamSearch(ctx, q, opts, sender) },
+	} {
+		wg.Add(1)
+		go func(i int, search func(zoekt.Sender) error) {
+			defer wg.Done()
+
+			errs[i] = search(zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+				mu.Lock()
+				defer mu.Unlock()
+				pending[i] = sr.MaxPendingPriority
+				sr.MaxPendingPriority = maxPending()
+				if sr.SearchProgress != nil {
+					progress[i] = *sr.SearchProgress
+					sum := progress[0]
+					sum.Add(progress[1])
+					sr.SearchProgress = &sum
+				}
+				sender.Send(sr)
+			}))
+
+			mu.Lock()
+			defer mu.Unlock()
+			pending[i] = math.Inf(-1)
+			sender.Send(&zoekt.SearchResult{
+				Progress: zoekt.Progress{MaxPendingPriority: maxPending()},
+			})
+		}(i, search)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Searcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	tr, ctx := trace.New(ctx, "overlay.List", "")
+	defer tr.Finish()
+
+	bq, err := s.baseQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg                  sync.WaitGroup
+		baseRL, overlayRL   *zoekt.RepoList
+		baseErr, overlayErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		baseRL, baseErr = s.base.List(ctx, bq, opts)
+	}()
+	go func() {
+		defer wg.Done()
+		overlayRL, overlayErr = s.overlay.List(ctx, q, opts)
+	}()
+	wg.Wait()
+
+	if baseErr != nil {
+		return nil, baseErr
+	}
+	if overlayErr != nil {
+		return nil, overlayErr
+	}
+
+	agg := zoekt.RepoList{
+		ReposMap: zoekt.ReposMap{},
+		Repos:    []*zoekt.RepoListEntry{},
+	}
+	// The overlay comes last so that it wins if both contain a repository ID.
+	for _, rl := range []*zoekt.RepoList{baseRL, overlayRL} {
+		agg.Crashes += rl.Crashes
+		agg.Stats.Add(&rl.Stats)
+		agg.Repos = append(agg.Repos, rl.Repos...)
+		for id, r := range rl.ReposMap {
+			agg.ReposMap[id] = r
+		}
+	}
+
+	// See shardedSearcher.List: only one of Repos and ReposMap is populated.
+	agg.Stats.Repos = len(agg.Repos) + len(agg.ReposMap)
+
+	tr.LazyPrintf("repos.size=%d reposmap.size=%d crashes=%d", len(agg.Repos), len(agg.ReposMap), agg.Crashes)
+
+	return &agg, nil
+}
+
+func (s *Searcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	tr, ctx := trace.New(ctx, "overlay.StreamList", "")
+	defer tr.Finish()
+
+	bq, err := s.baseQuery(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	// baseQuery excludes the repositories of the overlay, so we can forward
+	// the batches of both as they come.
+	var mu sync.Mutex
+	send := zoekt.ListSenderFunc(func(rl *zoekt.RepoList) {
+		mu.Lock()
+		defer mu.Unlock()
+		sender.Send(rl)
+	})
+
+	var (
+		wg                  sync.WaitGroup
+		baseErr, overlayErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		baseErr = zoekt.StreamList(ctx, s.base, bq, opts, send)
+	}()
+	go func() {
+		defer wg.Done()
+		overlayErr = zoekt.StreamList(ctx, s.overlay, q, opts, send)
+	}()
+	wg.Wait()
+
+	if baseErr != nil {
+		return baseErr
+	}
+	return overlayErr
+}
+
+func (s *Searcher) Close() {
+	s.base.Close()
+	s.overlay.Close()
+}
+
+func (s *Searcher) String() string {
+	return fmt.Sprintf("overlay(%s over %s)", s.overlay, s.base)
+}
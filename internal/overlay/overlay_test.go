@@ -0,0 +1,249 @@
+package overlay
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func writeShard(t *testing.T, dir, repo, fileName, content string) {
+	t.Helper()
+	writeShardID(t, dir, 0, repo, fileName, content)
+}
+
+func writeShardID(t *testing.T, dir string, id uint32, repo, fileName, content string) {
+	t.Helper()
+
+	b, err := index.NewShardBuilder(&zoekt.Repository{ID: id, Name: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(index.Document{Name: fileName, Content: []byte(content)}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(index.ShardName(dir, repo, index.IndexFormatVersion, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func searcherForTest(t *testing.T) *Searcher {
+	t.Helper()
+	return searcherForTestWith(t, nil)
+}
+
+// searcherForTestWith is like searcherForTest, but calls extra with the base
+// and overlay directories before the searchers load them.
+func searcherForTestWith(t *testing.T, extra func(baseDir, overlayDir string)) *Searcher {
+	t.Helper()
+
+	baseDir := filepath.Join(t.TempDir(), "base")
+	overlayDir := filepath.Join(t.TempDir(), "overlay")
+	for _, dir := range []string{baseDir, overlayDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeShard(t, baseDir, "a", "base.go", "needle in base")
+	writeShard(t, baseDir, "b", "base.go", "needle in base")
+	writeShard(t, overlayDir, "a", "overlay.go", "needle in overlay")
+	if extra != nil {
+		extra(baseDir, overlayDir)
+	}
+
+	base, err := shards.NewDirectorySearcher(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov, err := shards.NewDirectorySearcher(overlayDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSearcher(base, ov)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func fileNames(files []zoekt.FileMatch) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Repository+"/"+f.FileName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSearch(t *testing.T) {
+	s := searcherForTest(t)
+	ctx := systemtenant.WithUnsafeContext(context.Background())
+
+	want := []string{"a/overlay.go", "b/base.go"}
+
+	sr, err := s.Search(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, fileNames(sr.Files)); d != "" {
+		t.Errorf("Search (-want, +got):\n%s", d)
+	}
+
+	var (
+		mu    sync.Mutex
+		files []zoekt.FileMatch
+	)
+	err = s.StreamSearch(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{}, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		files = append(files, sr.Files...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, fileNames(files)); d != "" {
+		t.Errorf("StreamSearch (-want, +got):\n%s", d)
+	}
+
+	// Shadowed repositories cannot be found in the base even when asked for
+	// explicitly.
+	sr, err = s.Search(ctx, query.NewAnd(query.NewRepoSet("a"), &query.Substring{Pattern: "base"}), &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 0 {
+		t.Errorf("got files from shadowed repository: %v", fileNames(sr.Files))
+	}
+}
+
+func TestSearch_ShadowByID(t *testing.T) {
+	s := searcherForTestWith(t, func(baseDir, overlayDir string) {
+		// A renamed repository still shadows the base.
+		writeShardID(t, baseDir, 7, "c", "base.go", "needle in base")
+		writeShardID(t, overlayDir, 7, "c-renamed", "overlay.go", "needle in overlay")
+	})
+	ctx := systemtenant.WithUnsafeContext(context.Background())
+
+	sr, err := s.Search(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/overlay.go", "b/base.go", "c-renamed/overlay.go"}
+	if d := cmp.Diff(want, fileNames(sr.Files)); d != "" {
+		t.Errorf("Search (-want, +got):\n%s", d)
+	}
+}
+
+func TestBatchSearchAndExplain(t *testing.T) {
+	s := searcherForTest(t)
+	ctx := systemtenant.WithUnsafeContext(context.Background())
+
+	results, err := zoekt.BatchSearch(ctx, s, []query.Q{
+		&query.Substring{Pattern: "needle"},
+		&query.Substring{Pattern: "base"},
+	}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, sr := range results {
+		got = append(got, fileNames(sr.Files))
+	}
+	want := [][]string{{"a/overlay.go", "b/base.go"}, {"b/base.go"}}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("BatchSearch (-want, +got):\n%s", d)
+	}
+
+	e, err := zoekt.Explain(ctx, s, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Shards) != 3 {
+		t.Errorf("got %d shard explanations, want 3", len(e.Shards))
+	}
+}
+
+// versionedStreamer counts the calls to List and reports a settable version
+// of its shards.
+type versionedStreamer struct {
+	zoekt.Streamer
+	version uint64
+	lists   int
+}
+
+func (s *versionedStreamer) Version() uint64 {
+	return s.version
+}
+
+func (s *versionedStreamer) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	s.lists++
+	return s.Streamer.List(ctx, q, opts)
+}
+
+func TestShadowedReposCache(t *testing.T) {
+	s := searcherForTest(t)
+	ov := &versionedStreamer{Streamer: s.overlay}
+	s.overlay = ov
+	ctx := systemtenant.WithUnsafeContext(context.Background())
+
+	search := func() {
+		t.Helper()
+		if _, err := s.Search(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search()
+	search()
+	if ov.lists != 1 {
+		t.Fatalf("got %d lists of the overlay, want 1", ov.lists)
+	}
+
+	// Loading shards into the overlay invalidates the cache.
+	ov.version++
+	search()
+	if ov.lists != 2 {
+		t.Fatalf("got %d lists of the overlay, want 2", ov.lists)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := searcherForTest(t)
+	ctx := systemtenant.WithUnsafeContext(context.Background())
+
+	rl, err := s.List(ctx, &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range rl.Repos {
+		got = append(got, r.Repository.Name)
+	}
+	sort.Strings(got)
+	if d := cmp.Diff([]string{"a", "b"}, got); d != "" {
+		t.Errorf("List (-want, +got):\n%s", d)
+	}
+	if rl.Stats.Repos != 2 {
+		t.Errorf("got %d repos in stats, want 2", rl.Stats.Repos)
+	}
+
+	// The documents of the shadowed repository are not counted.
+	if rl.Stats.Documents != 2 {
+		t.Errorf("got %d documents in stats, want 2", rl.Stats.Documents)
+	}
+}
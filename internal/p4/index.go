@@ -0,0 +1,237 @@
+// Package p4 indexes Perforce depots. It reads files with p4 print at a
+// submitted changelist, so it needs neither a workspace nor a git bridge.
+// The changelist number is the version of the indexed branch.
+package p4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// Options specify the Perforce specific indexing options.
+type Options struct {
+	// Incremental skips indexing if the shards are at the same changelist
+	// and were built with the same options.
+	Incremental bool
+
+	// Delta only indexes the files changed since the last indexed changelist
+	// into a new shard on top of the existing ones. It falls back to a full
+	// build if there are no shards to build on, or if they were built with
+	// different options.
+	Delta bool
+
+	// Paths are the file specs to index, eg. //depot/project/.... Specs in
+	// client syntax, eg. //my-client/..., index the view of a client spec.
+	Paths []string
+
+	// Changelist is the changelist to index. If zero, the last submitted
+	// changelist of Paths is indexed.
+	Changelist int
+
+	Name    string
+	RepoURL string
+	// Branch is the name of the indexed branch. Defaults to HEAD.
+	Branch string
+
+	// P4 is the p4 command line client. Defaults to p4 from PATH.
+	P4 string
+
+	// Port, User and Client are passed to p4 if set. Otherwise p4 uses its
+	// environment, eg. P4PORT, P4USER, P4CLIENT and P4CONFIG.
+	Port   string
+	User   string
+	Client string
+}
+
+// Index indexes the depot paths in opts using bopts.
+func Index(ctx context.Context, opts Options, bopts index.Options) error {
+	return indexWith(ctx, newExecRunner(opts), opts, bopts)
+}
+
+func indexWith(ctx context.Context, r runner, opts Options, bopts index.Options) error {
+	if len(opts.Paths) == 0 {
+		return errors.New("at least one depot path required")
+	}
+	if opts.Name == "" {
+		return errors.New("-name required")
+	}
+	if opts.Branch == "" {
+		opts.Branch = "HEAD"
+	}
+
+	specs := opts.Paths
+	if opts.Changelist > 0 {
+		specs = atChange(opts.Paths, opts.Changelist)
+	}
+	head, err := lastChange(ctx, r, specs)
+	if err != nil {
+		return err
+	}
+
+	bopts.RepositoryDescription.Name = opts.Name
+	bopts.RepositoryDescription.URL = opts.RepoURL
+	bopts.RepositoryDescription.Source = strings.Join(opts.Paths, " ")
+	bopts.SetDefaults()
+	bopts.RepositoryDescription.Branches = []zoekt.RepositoryBranch{{Name: opts.Branch, Version: strconv.Itoa(head.Number)}}
+	bopts.RepositoryDescription.LatestCommitDate = time.Unix(head.Time, 0)
+
+	if opts.Incremental && bopts.IncrementalSkipIndexing() {
+		return nil
+	}
+
+	var last int
+	if opts.Delta {
+		last, err = lastIndexedChange(&bopts)
+		if err != nil {
+			log.Printf("delta build: falling back to normal build, repository=%q, err=%s", opts.Name, err)
+		} else if last < head.Number {
+			bopts.IsDelta = true
+		}
+	}
+
+	builder, err := index.NewBuilder(bopts)
+	if err != nil {
+		return err
+	}
+
+	p := &printer{
+		opts:    &opts,
+		bopts:   &bopts,
+		builder: builder,
+	}
+	if bopts.IsDelta {
+		err = p.printChanged(ctx, r, last, head.Number)
+	} else {
+		err = p.print(ctx, r, nil, append([]string{"print"}, atChange(opts.Paths, head.Number)...)...)
+	}
+	if err != nil {
+		return err
+	}
+
+	return builder.Finish()
+}
+
+// lastIndexedChange returns the changelist of the shards on disk, which a
+// delta build can build on.
+func lastIndexedChange(bopts *index.Options) (int, error) {
+	existing, _, ok, err := bopts.FindRepositoryMetadata()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.New("no existing shards found for repository")
+	}
+	if !index.BranchNamesEqual(existing.Branches, bopts.RepositoryDescription.Branches) {
+		return 0, errors.New("the branch differs from the branch on disk")
+	}
+	if existing.IndexOptions != bopts.GetHash() {
+		return 0, errors.New("the index options differ from the options on disk")
+	}
+	return strconv.Atoi(existing.Branches[0].Version)
+}
+
+// atChange returns specs at changelist cl.
+func atChange(specs []string, cl int) []string {
+	at := make([]string, 0, len(specs))
+	for _, s := range specs {
+		at = append(at, fmt.Sprintf("%s@%d", s, cl))
+	}
+	return at
+}
+
+// printer adds the files printed by p4 to a builder.
+type printer struct {
+	opts    *Options
+	bopts   *index.Options
+	builder *index.Builder
+}
+
+// printChanged adds the files changed after changelist last up to head, and
+// tombstones the older versions of changed and deleted files.
+func (p *printer) printChanged(ctx context.Context, r runner, last, head int) error {
+	ranges := make([]string, 0, len(p.opts.Paths))
+	for _, s := range p.opts.Paths {
+		ranges = append(ranges, fmt.Sprintf("%s@%d,@%d", s, last+1, head))
+	}
+
+	var revs []string
+	err := forEach(ctx, r, nil, func(rec record) error {
+		name := p.docName(rec["depotFile"])
+		if !p.bopts.IncludePath(name) {
+			return nil
+		}
+		p.builder.MarkFileAsChangedOrRemoved(name)
+		if !isDeleted(rec["action"]) {
+			revs = append(revs, rec["depotFile"]+"#"+rec["rev"])
+		}
+		return nil
+	}, append([]string{"files"}, ranges...)...)
+	if err != nil {
+		return err
+	}
+
+	if len(revs) == 0 {
+		return nil
+	}
+	return p.print(ctx, r, revs, "-x", "-", "print")
+}
+
+// print adds the files printed by p4 with args, which runs print.
+func (p *printer) print(ctx context.Context, r runner, stdin []string, args ...string) error {
+	var (
+		cur     record
+		content []byte
+	)
+	flush := func() error {
+		if cur == nil || isDeleted(cur["action"]) {
+			return nil
+		}
+		name := p.docName(cur["depotFile"])
+		if !p.bopts.IncludePath(name) {
+			return nil
+		}
+		return p.builder.Add(index.Document{
+			Name:     name,
+			Content:  content,
+			Branches: []string{p.opts.Branch},
+		})
+	}
+
+	err := forEach(ctx, r, stdin, func(rec record) error {
+		if rec["code"] == "stat" {
+			if err := flush(); err != nil {
+				return err
+			}
+			cur, content = rec, nil
+			return nil
+		}
+		// The content of a file follows its stat record in one or more
+		// records, eg. with code text or binary.
+		content = append(content, rec["data"]...)
+		return nil
+	}, args...)
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// docName returns the name of depotFile in the index: its path below the
+// first of the paths of the form //prefix/... which contains it, or else the
+// depot path without the leading slashes.
+func (p *printer) docName(depotFile string) string {
+	for _, s := range p.opts.Paths {
+		if prefix, ok := strings.CutSuffix(s, "..."); ok && strings.HasSuffix(prefix, "/") && strings.HasPrefix(depotFile, prefix) {
+			return strings.TrimPrefix(depotFile, prefix)
+		}
+	}
+	return strings.TrimPrefix(depotFile, "//")
+}
@@ -0,0 +1,267 @@
+package p4
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// revision is a revision of a file in fakeDepot.
+type revision struct {
+	rev     int
+	change  int
+	action  string
+	content string
+}
+
+// fakeDepot answers the p4 commands this package runs from memory.
+type fakeDepot struct {
+	files map[string][]revision
+	// commands are the commands run, with the files printed through -x -.
+	commands []string
+}
+
+// submit submits a changelist which sets the content of files. An empty
+// content deletes the file.
+func (d *fakeDepot) submit(change int, files map[string]string) {
+	if d.files == nil {
+		d.files = map[string][]revision{}
+	}
+	for name, content := range files {
+		revs := d.files[name]
+		action := "edit"
+		if len(revs) == 0 {
+			action = "add"
+		}
+		if content == "" {
+			action = "delete"
+		}
+		d.files[name] = append(revs, revision{rev: len(revs) + 1, change: change, action: action, content: content})
+	}
+}
+
+// at returns the revision of name which is current at changelist cl.
+func (d *fakeDepot) at(name string, from, cl int) (revision, bool) {
+	var cur revision
+	for _, r := range d.files[name] {
+		if r.change <= cl {
+			cur = r
+		}
+	}
+	return cur, cur.rev > 0 && cur.change >= from
+}
+
+// parseSpec splits //prefix/...@from,@to.
+func parseSpec(spec string) (prefix string, from, to int) {
+	to = 1 << 30
+	if i := strings.Index(spec, "@"); i >= 0 {
+		rev := spec[i+1:]
+		spec = spec[:i]
+		if a, b, ok := strings.Cut(rev, ",@"); ok {
+			from, _ = strconv.Atoi(a)
+			to, _ = strconv.Atoi(b)
+		} else {
+			to, _ = strconv.Atoi(rev)
+		}
+	}
+	return strings.TrimSuffix(spec, "..."), from, to
+}
+
+func (d *fakeDepot) run(_ context.Context, stdin []string, args ...string) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	write := func(rec record) {
+		keys := make([]string, 0, len(rec))
+		for k := range rec {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeRecord(&buf, rec, keys...)
+	}
+	names := make([]string, 0, len(d.files))
+	for name := range d.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	printRev := func(name string, r revision) {
+		write(record{"code": "stat", "depotFile": name, "rev": strconv.Itoa(r.rev), "change": strconv.Itoa(r.change), "action": r.action})
+		if r.content != "" {
+			write(record{"code": "text", "data": r.content})
+		}
+	}
+
+	if len(args) > 2 && args[0] == "-x" {
+		d.commands = append(d.commands, args[2]+" "+strings.Join(stdin, " "))
+		for _, s := range stdin {
+			name, rev, _ := strings.Cut(s, "#")
+			n, _ := strconv.Atoi(rev)
+			printRev(name, d.files[name][n-1])
+		}
+		return io.NopCloser(&buf), nil
+	}
+
+	d.commands = append(d.commands, args[0])
+	switch args[0] {
+	case "changes":
+		prefix, _, to := parseSpec(args[len(args)-1])
+		var last int
+		for _, name := range names {
+			if r, ok := d.at(name, 0, to); ok && strings.HasPrefix(name, prefix) {
+				last = max(last, r.change)
+			}
+		}
+		if last > 0 {
+			write(record{"code": "stat", "change": strconv.Itoa(last), "time": strconv.Itoa(1700000000 + last)})
+		}
+	case "print", "files":
+		prefix, from, to := parseSpec(args[1])
+		for _, name := range names {
+			r, ok := d.at(name, from, to)
+			if !ok || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if args[0] == "print" {
+				printRev(name, r)
+			} else {
+				write(record{"code": "stat", "depotFile": name, "rev": strconv.Itoa(r.rev), "action": r.action})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected command %v", args)
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// writeRecord writes rec in the format of p4 -G, with the keys in the given
+// order.
+func writeRecord(w io.Writer, rec record, keys ...string) {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	for _, k := range keys {
+		for _, s := range []string{k, rec[k]} {
+			bw.WriteByte('s')
+			_ = binary.Write(bw, binary.LittleEndian, uint32(len(s)))
+			bw.WriteString(s)
+		}
+	}
+	bw.WriteByte('0')
+	bw.Flush()
+}
+
+func TestReadRecord(t *testing.T) {
+	var buf bytes.Buffer
+	writeRecord(&buf, record{"code": "text", "data": "a\x00b"}, "code", "data")
+	// An integer value and a null.
+	buf.Write([]byte{'{', 's', 3, 0, 0, 0, 'r', 'e', 'v', 'i', 0xff, 0xff, 0xff, 0xff, 's', 1, 0, 0, 0, 'x', 'N', '0'})
+
+	br := bufio.NewReader(&buf)
+	var got []record
+	for {
+		rec, err := readRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec)
+	}
+
+	want := []record{{"code": "text", "data": "a\x00b"}, {"rev": "-1", "x": ""}}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+
+	if _, err := readRecord(bufio.NewReader(strings.NewReader("{s\x01\x00\x00\x00k"))); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v for a truncated record, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	depot := &fakeDepot{}
+	depot.submit(1, map[string]string{
+		"//depot/game/a.txt":  "apple",
+		"//depot/game/b.txt":  "banana",
+		"//depot/other/z.txt": "zucchini",
+	})
+
+	opts := Options{
+		Incremental: true,
+		Delta:       true,
+		Paths:       []string{"//depot/game/..."},
+		Name:        "game",
+	}
+	build := func() {
+		t.Helper()
+		depot.commands = nil
+		if err := indexWith(context.Background(), depot, opts, index.Options{IndexDir: dir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func() (files []string, version string) {
+		t.Helper()
+		ss, err := shards.NewDirectorySearcher(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ss.Close()
+		res, err := ss.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{Whole: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range res.Files {
+			files = append(files, f.FileName+":"+string(f.Content))
+			version = f.Version
+		}
+		sort.Strings(files)
+		return files, version
+	}
+
+	build()
+	files, version := search()
+	if d := cmp.Diff([]string{"a.txt:apple", "b.txt:banana"}, files); d != "" || version != "1" {
+		t.Errorf("full build: got version %s, files mismatch (-want +got):\n%s", version, d)
+	}
+
+	// Nothing changed.
+	build()
+	if d := cmp.Diff([]string{"changes"}, depot.commands); d != "" {
+		t.Errorf("incremental build ran (-want +got):\n%s", d)
+	}
+
+	depot.submit(2, map[string]string{
+		"//depot/game/a.txt": "avocado",
+		"//depot/game/b.txt": "",
+		"//depot/game/c.txt": "cherry",
+	})
+	build()
+	if d := cmp.Diff([]string{"changes", "files", "print //depot/game/a.txt#2 //depot/game/c.txt#1"}, depot.commands); d != "" {
+		t.Errorf("delta build commands mismatch (-want +got):\n%s", d)
+	}
+	files, version = search()
+	if d := cmp.Diff([]string{"a.txt:avocado", "c.txt:cherry"}, files); d != "" || version != "2" {
+		t.Errorf("delta build: got version %s, files mismatch (-want +got):\n%s", version, d)
+	}
+
+	// An older changelist is a full build.
+	opts.Changelist = 1
+	build()
+	files, version = search()
+	if d := cmp.Diff([]string{"a.txt:apple", "b.txt:banana"}, files); d != "" || version != "1" {
+		t.Errorf("build of changelist 1: got version %s, files mismatch (-want +got):\n%s", version, d)
+	}
+}
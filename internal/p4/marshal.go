@@ -0,0 +1,90 @@
+package p4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// record is one result of a p4 command run with -G, which writes each result
+// as a Python marshaled dictionary. Integers are converted to strings, so
+// callers don't need to care which of the two p4 chose for a field.
+type record map[string]string
+
+// readRecord reads the next record from r. It returns io.EOF if there are no
+// more records.
+func readRecord(r *bufio.Reader) (record, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if typ != '{' {
+		return nil, fmt.Errorf("p4 -G: expected a dictionary, got type %q", typ)
+	}
+
+	rec := record{}
+	for {
+		key, end, err := readValue(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if end {
+			return rec, nil
+		}
+		value, end, err := readValue(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if end {
+			return nil, fmt.Errorf("p4 -G: missing value for key %q", key)
+		}
+		rec[key] = value
+	}
+}
+
+// readValue reads a scalar value. end is true for the null which ends a
+// dictionary.
+func readValue(r *bufio.Reader) (value string, end bool, err error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return "", false, err
+	}
+
+	switch typ {
+	case '0':
+		return "", true, nil
+	case 'N':
+		return "", false, nil
+	case 'T':
+		return "true", false, nil
+	case 'F':
+		return "false", false, nil
+	case 'i':
+		var n int32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", false, err
+		}
+		return strconv.Itoa(int(n)), false, nil
+	case 's', 't', 'u':
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", false, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", false, err
+		}
+		return string(b), false, nil
+	default:
+		return "", false, fmt.Errorf("p4 -G: unsupported type %q", typ)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package p4
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runner runs p4 commands with -G.
+type runner interface {
+	// run runs p4 with args and returns its output. stdin, if non-empty, is
+	// passed to p4 one argument per line, for use with the global option
+	// "-x -". Close waits for p4 to exit.
+	run(ctx context.Context, stdin []string, args ...string) (io.ReadCloser, error)
+}
+
+// execRunner runs the p4 command line client.
+type execRunner struct {
+	bin string
+	// global are the global options, eg. the server.
+	global []string
+}
+
+func newExecRunner(opts Options) *execRunner {
+	r := &execRunner{bin: opts.P4}
+	if r.bin == "" {
+		r.bin = "p4"
+	}
+	for _, o := range []struct{ flag, value string }{
+		{"-p", opts.Port},
+		{"-u", opts.User},
+		{"-c", opts.Client},
+	} {
+		if o.value != "" {
+			r.global = append(r.global, o.flag, o.value)
+		}
+	}
+	return r
+}
+
+func (r *execRunner) run(ctx context.Context, stdin []string, args ...string) (io.ReadCloser, error) {
+	cmdArgs := append([]string{"-G"}, r.global...)
+	cmd := exec.CommandContext(ctx, r.bin, append(cmdArgs, args...)...)
+	if len(stdin) > 0 {
+		cmd.Stdin = strings.NewReader(strings.Join(stdin, "\n") + "\n")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReadCloser{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+type cmdReadCloser struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (c *cmdReadCloser) Close() error {
+	// Drain stdout so that p4 doesn't block on writing if we stopped early.
+	_, _ = io.Copy(io.Discard, c.ReadCloser)
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(c.cmd.Args, " "), err, strings.TrimSpace(c.stderr.String()))
+	}
+	return nil
+}
+
+// severityFailed is the severity from which p4 errors are failures. Lower
+// severities are informational or warnings such as "no such file(s)".
+const severityFailed = 3
+
+// forEach calls fn with each record p4 returns for args. Errors reported by
+// p4 fail forEach, warnings are skipped.
+func forEach(ctx context.Context, r runner, stdin []string, fn func(record) error, args ...string) (err error) {
+	out, err := r.run(ctx, stdin, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	br := bufio.NewReader(out)
+	for {
+		rec, err := readRecord(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if rec["code"] == "error" {
+			if severity, _ := strconv.Atoi(rec["severity"]); severity >= severityFailed {
+				return fmt.Errorf("p4: %s", strings.TrimSpace(rec["data"]))
+			}
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// change is a submitted changelist.
+type change struct {
+	Number int
+	// Time is the time of submission in seconds since the epoch.
+	Time int64
+}
+
+// lastChange returns the last changelist submitted to any of specs.
+func lastChange(ctx context.Context, r runner, specs []string) (change, error) {
+	var c change
+	err := forEach(ctx, r, nil, func(rec record) error {
+		var err error
+		if c.Number, err = strconv.Atoi(rec["change"]); err != nil {
+			return fmt.Errorf("p4 changes: invalid change %q", rec["change"])
+		}
+		c.Time, _ = strconv.ParseInt(rec["time"], 10, 64)
+		return nil
+	}, append([]string{"changes", "-m1", "-s", "submitted"}, specs...)...)
+	if err != nil {
+		return change{}, err
+	}
+	if c.Number == 0 {
+		return change{}, fmt.Errorf("no submitted changelist found for %s", strings.Join(specs, " "))
+	}
+	return c, nil
+}
+
+// isDeleted returns true if action leaves no file behind.
+func isDeleted(action string) bool {
+	switch action {
+	case "delete", "move/delete", "purge", "archive":
+		return true
+	}
+	return false
+}
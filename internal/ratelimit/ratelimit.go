@@ -0,0 +1,419 @@
+// Package ratelimit limits the rate and concurrency of HTTP and gRPC requests
+// per client.
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	metricRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_ratelimit_rejected_total",
+		Help: "The total number of requests rejected by the rate limiter, by the limit which was exceeded",
+	}, []string{"limit"})
+	metricAllowedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_ratelimit_allowed_total",
+		Help: "The total number of requests which passed the rate limiter",
+	})
+	metricClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_ratelimit_clients",
+		Help: "The number of clients tracked by the rate limiter",
+	})
+)
+
+// idleTimeout is how long we keep the state of a client which has no
+// requests in flight.
+const idleTimeout = 10 * time.Minute
+
+// maxClients is the number of clients a Limiter tracks. Beyond it, the
+// clients which have been idle longest are forgotten early, so that a flood
+// of client keys cannot grow the state without bound.
+const maxClients = 100_000
+
+// Options configures a Limiter. A zero limit disables it.
+type Options struct {
+	// QPS is the sustained number of requests per second allowed per client.
+	QPS float64
+
+	// Burst is the number of requests a client may send at once on top of
+	// QPS. It defaults to 1.
+	Burst int
+
+	// MaxConcurrent is the number of requests a client may have in flight.
+	MaxConcurrent int
+
+	// ClientKey identifies the client of a request, see ClientKeyFunc. It
+	// defaults to the IP address of the client.
+	ClientKey func(*http.Request) string
+
+	// GRPCClientKey identifies the client of a gRPC call, see
+	// GRPCClientKeyFunc. It defaults to the IP address of the client.
+	GRPCClientKey func(context.Context) string
+}
+
+// Limiter enforces Options per client.
+type Limiter struct {
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*client
+
+	// idle holds the clients without requests in flight, most recently seen
+	// first.
+	idle       *list.List
+	maxClients int
+}
+
+type client struct {
+	key      string
+	limiter  *rate.Limiter
+	inflight int
+	lastSeen time.Time
+
+	// idle is the element of the client in Limiter.idle, or nil while the
+	// client has requests in flight.
+	idle *list.Element
+}
+
+// New returns a Limiter enforcing opts.
+func New(opts Options) *Limiter {
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	if opts.ClientKey == nil {
+		opts.ClientKey = remoteIP
+	}
+	if opts.GRPCClientKey == nil {
+		opts.GRPCClientKey = peerIP
+	}
+	return &Limiter{
+		opts:       opts,
+		now:        time.Now,
+		clients:    map[string]*client{},
+		idle:       list.New(),
+		maxClients: maxClients,
+	}
+}
+
+// Enabled returns true if l limits any requests.
+func (l *Limiter) Enabled() bool {
+	return l.opts.QPS > 0 || l.opts.MaxConcurrent > 0
+}
+
+// Middleware wraps next so that requests exceeding a limit are answered with
+// 429 Too Many Requests and a Retry-After header.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if !l.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := l.opts.ClientKey(r)
+		retryAfter, ok := l.acquire(key)
+		if !ok {
+			RetryAfter(w, retryAfter)
+			return
+		}
+		defer l.release(key)
+
+		next.ServeHTTP(w, r.WithContext(l.withCharge(r.Context(), key)))
+	})
+}
+
+// UnaryServerInterceptor applies the limits to unary gRPC calls. Calls
+// exceeding a limit fail with codes.ResourceExhausted.
+func (l *Limiter) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !l.Enabled() {
+		return handler(ctx, req)
+	}
+	key := l.opts.GRPCClientKey(ctx)
+	if retryAfter, ok := l.acquire(key); !ok {
+		return nil, resourceExhausted(retryAfter)
+	}
+	defer l.release(key)
+
+	return handler(l.withCharge(ctx, key), req)
+}
+
+// StreamServerInterceptor applies the limits to streaming gRPC calls, like
+// UnaryServerInterceptor. A stream counts as one request for as long as it is
+// open.
+func (l *Limiter) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !l.Enabled() {
+		return handler(srv, ss)
+	}
+	key := l.opts.GRPCClientKey(ss.Context())
+	if retryAfter, ok := l.acquire(key); !ok {
+		return resourceExhausted(retryAfter)
+	}
+	defer l.release(key)
+
+	return handler(srv, &chargedStream{ServerStream: ss, ctx: l.withCharge(ss.Context(), key)})
+}
+
+type chargedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *chargedStream) Context() context.Context {
+	return s.ctx
+}
+
+func resourceExhausted(retryAfter time.Duration) error {
+	return status.Errorf(codes.ResourceExhausted, "too many requests, retry after %s", retryAfter.Round(time.Second))
+}
+
+func (l *Limiter) withCharge(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, chargeKey{}, func(n int) (time.Duration, bool) {
+		return l.charge(key, n)
+	})
+}
+
+type chargeKey struct{}
+
+// Charge takes n additional requests from the quota of the client whose
+// request carries ctx. Handlers use it for requests which do the work of
+// several, eg. a batch of searches. If the quota is exhausted, Charge returns
+// false and how long the client should wait before retrying. Charge always
+// succeeds if ctx was not passed through a Limiter.
+func Charge(ctx context.Context, n int) (time.Duration, bool) {
+	charge, ok := ctx.Value(chargeKey{}).(func(int) (time.Duration, bool))
+	if !ok || n <= 0 {
+		return 0, true
+	}
+	return charge(n)
+}
+
+// RetryAfter sets the Retry-After header and answers with 429 Too Many
+// Requests, like Middleware does for rejected requests.
+func RetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// acquire admits a request of the client identified by key. If the request
+// exceeds a limit, acquire returns false and how long the client should wait
+// before retrying.
+func (l *Limiter) acquire(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	c, ok := l.clients[key]
+	if !ok {
+		l.evict()
+		c = &client{key: key}
+		if l.opts.QPS > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(l.opts.QPS), l.opts.Burst)
+		}
+		c.idle = l.idle.PushFront(c)
+		l.clients[key] = c
+	}
+	metricClients.Set(float64(len(l.clients)))
+	c.lastSeen = now
+	if c.idle != nil {
+		l.idle.MoveToFront(c.idle)
+	}
+
+	if l.opts.MaxConcurrent > 0 && c.inflight >= l.opts.MaxConcurrent {
+		metricRejectedTotal.WithLabelValues("concurrency").Inc()
+		return time.Second, false
+	}
+
+	if c.limiter != nil {
+		res := c.limiter.ReserveN(now, 1)
+		if delay := res.DelayFrom(now); delay > 0 {
+			res.CancelAt(now)
+			metricRejectedTotal.WithLabelValues("qps").Inc()
+			return delay, false
+		}
+	}
+
+	if c.idle != nil {
+		l.idle.Remove(c.idle)
+		c.idle = nil
+	}
+	c.inflight++
+	metricAllowedTotal.Inc()
+	return 0, true
+}
+
+// charge takes n tokens from the bucket of an admitted client. Concurrency is
+// not affected since the client already holds a slot for the request.
+func (l *Limiter) charge(key string, n int) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[key]
+	if !ok || c.limiter == nil {
+		return 0, true
+	}
+
+	// A bucket never holds more than the burst, so larger charges could never
+	// succeed. Instead they take the whole bucket.
+	n = min(n, l.opts.Burst)
+
+	now := l.now()
+	res := c.limiter.ReserveN(now, n)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		metricRejectedTotal.WithLabelValues("qps").Inc()
+		return delay, false
+	}
+	return 0, true
+}
+
+func (l *Limiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c, ok := l.clients[key]; ok {
+		c.inflight--
+		c.lastSeen = l.now()
+		if c.inflight == 0 {
+			c.idle = l.idle.PushFront(c)
+		}
+	}
+}
+
+// sweep forgets clients which have been idle for idleTimeout. Their token
+// buckets are full again by then, so this does not change any decisions.
+func (l *Limiter) sweep(now time.Time) {
+	for e := l.idle.Back(); e != nil; e = l.idle.Back() {
+		c := e.Value.(*client)
+		if now.Sub(c.lastSeen) < idleTimeout {
+			return
+		}
+		l.forget(c)
+	}
+}
+
+// evict makes room for a new client by forgetting the clients which have been
+// idle longest. They get a fresh quota if they return. Clients with requests
+// in flight are kept, so they may exceed maxClients.
+func (l *Limiter) evict() {
+	for len(l.clients) >= l.maxClients && l.idle.Len() > 0 {
+		l.forget(l.idle.Back().Value.(*client))
+	}
+}
+
+func (l *Limiter) forget(c *client) {
+	l.idle.Remove(c.idle)
+	delete(l.clients, c.key)
+}
+
+// ClientKeyFunc returns a function which identifies the client of a request
+// according to spec:
+//
+//	ip            the IP address of the client. IPv6 clients are identified by
+//	              their /64 prefix, since a host usually has all of it.
+//	header:NAME   the value of the request header NAME, eg. an API key. Requests
+//	              without the header are identified by their IP address.
+//
+// The limits are only as strong as the client key. A client which chooses
+// the value of header NAME gets a fresh quota for every value, so only use
+// header:NAME if a proxy in front of the server sets or validates the header.
+func ClientKeyFunc(spec string) (func(*http.Request) string, error) {
+	name, err := parseClientKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return remoteIP, nil
+	}
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return "header:" + v
+		}
+		return remoteIP(r)
+	}, nil
+}
+
+// GRPCClientKeyFunc is like ClientKeyFunc for gRPC calls. header:NAME reads
+// the metadata key NAME.
+func GRPCClientKeyFunc(spec string) (func(context.Context) string, error) {
+	name, err := parseClientKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return peerIP, nil
+	}
+	name = strings.ToLower(name)
+	return func(ctx context.Context) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(name); len(v) > 0 && v[0] != "" {
+			return "header:" + v[0]
+		}
+		return peerIP(ctx)
+	}, nil
+}
+
+// parseClientKey returns the header name of a header:NAME spec, or "" for
+// ip.
+func parseClientKey(spec string) (string, error) {
+	if spec == "ip" {
+		return "", nil
+	}
+	name, ok := strings.CutPrefix(spec, "header:")
+	if !ok || name == "" {
+		return "", fmt.Errorf("ratelimit: invalid client key %q, want ip or header:NAME", spec)
+	}
+	return name, nil
+}
+
+func remoteIP(r *http.Request) string {
+	return ipKey(hostOf(r.RemoteAddr))
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return ipKey(hostOf(p.Addr.String()))
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// ipKey returns the /64 prefix of an IPv6 address, so that a client cannot
+// get a fresh quota for each of the addresses of its network. Other hosts are
+// returned as is.
+func ipKey(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if addr = addr.Unmap(); addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr.WithZone(""), 64).Masked().String()
+}
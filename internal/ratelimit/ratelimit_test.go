@@ -0,0 +1,246 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiter_QPS(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Options{QPS: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.acquire("a"); !ok {
+			t.Fatalf("request %d within burst was rejected", i)
+		}
+		l.release("a")
+	}
+
+	retryAfter, ok := l.acquire("a")
+	if ok {
+		t.Fatal("request over burst was admitted")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("got retry after %s, want (0, 1s]", retryAfter)
+	}
+
+	// Other clients have their own quota.
+	if _, ok := l.acquire("b"); !ok {
+		t.Fatal("request of another client was rejected")
+	}
+	l.release("b")
+
+	now = now.Add(retryAfter)
+	if _, ok := l.acquire("a"); !ok {
+		t.Fatal("request after retry after was rejected")
+	}
+	l.release("a")
+}
+
+func TestLimiter_Concurrency(t *testing.T) {
+	l := New(Options{MaxConcurrent: 1})
+
+	if _, ok := l.acquire("a"); !ok {
+		t.Fatal("first request was rejected")
+	}
+	if _, ok := l.acquire("a"); ok {
+		t.Fatal("concurrent request was admitted")
+	}
+	l.release("a")
+	if _, ok := l.acquire("a"); !ok {
+		t.Fatal("request after release was rejected")
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Options{QPS: 1})
+	l.now = func() time.Time { return now }
+
+	l.acquire("idle")
+	l.release("idle")
+	l.acquire("busy")
+
+	now = now.Add(idleTimeout)
+	l.acquire("new")
+
+	if _, ok := l.clients["idle"]; ok {
+		t.Error("idle client was not forgotten")
+	}
+	if _, ok := l.clients["busy"]; !ok {
+		t.Error("client with request in flight was forgotten")
+	}
+}
+
+func TestLimiter_MaxClients(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Options{QPS: 1})
+	l.now = func() time.Time { return now }
+	l.maxClients = 3
+
+	l.acquire("busy")
+	for _, key := range []string{"a", "b"} {
+		now = now.Add(time.Second)
+		l.acquire(key)
+		l.release(key)
+	}
+
+	// a was idle longest, so it makes room for c.
+	now = now.Add(time.Second)
+	l.acquire("c")
+	for key, want := range map[string]bool{"busy": true, "a": false, "b": true, "c": true} {
+		if _, ok := l.clients[key]; ok != want {
+			t.Errorf("client %s tracked: got %t, want %t", key, ok, want)
+		}
+	}
+	if len(l.clients) != l.maxClients || l.idle.Len() != 1 {
+		t.Fatalf("got %d clients and %d idle, want 3 and 1", len(l.clients), l.idle.Len())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key, err := ClientKeyFunc("header:X-Api-Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Options{QPS: 0.1, ClientKey: key})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/search?q=foo", nil)
+		if apiKey != "" {
+			r.Header.Set("X-Api-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("secret"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	w := do("secret")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("got Retry-After %q, want 10", got)
+	}
+
+	// Requests without the header are identified by IP.
+	if w := do(""); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	key, err := GRPCClientKeyFunc("header:X-Api-Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Options{QPS: 0.1, GRPCClientKey: key})
+
+	do := func(apiKey string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+		if apiKey != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", apiKey))
+		}
+		_, err := l.UnaryServerInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	if err := do("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := do("secret"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted", err)
+	}
+
+	// Calls without the metadata key are identified by IP.
+	if err := do(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.clients["10.0.0.1"]; !ok {
+		t.Fatalf("got clients %v, want 10.0.0.1", l.clients)
+	}
+}
+
+func TestClientKeyFunc(t *testing.T) {
+	for _, spec := range []string{"", "header:", "apikey"} {
+		if _, err := ClientKeyFunc(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+		if _, err := GRPCClientKeyFunc(spec); err == nil {
+			t.Errorf("%q: expected gRPC error", spec)
+		}
+	}
+
+	key, err := ClientKeyFunc("ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if got := key(r); got != "10.0.0.1" {
+		t.Fatalf("got %q, want 10.0.0.1", got)
+	}
+
+	// IPv6 clients are identified by their /64.
+	for addr, want := range map[string]string{
+		"[2001:db8:1:2:a::1]:1234": "2001:db8:1:2::/64",
+		"[2001:db8:1:2:b::2]:1234": "2001:db8:1:2::/64",
+		"[2001:db8:1:3::1]:1234":   "2001:db8:1:3::/64",
+		"[::ffff:10.0.0.1]:1234":   "10.0.0.1",
+	} {
+		r.RemoteAddr = addr
+		if got := key(r); got != want {
+			t.Errorf("%s: got %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestCharge(t *testing.T) {
+	l := New(Options{QPS: 0.1, Burst: 3})
+
+	var charged []bool
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := Charge(r.Context(), 1)
+		charged = append(charged, ok)
+	}))
+
+	do := func() int {
+		r := httptest.NewRequest("POST", "/batch", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// The first request takes 2 tokens, the second one only gets admitted.
+	do()
+	do()
+	if want := []bool{true, false}; !reflect.DeepEqual(charged, want) {
+		t.Fatalf("got charges %v, want %v", charged, want)
+	}
+	if code := do(); code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want 429", code)
+	}
+
+	// Outside of the middleware charges are free.
+	if _, ok := Charge(context.Background(), 100); !ok {
+		t.Fatal("charge without limiter failed")
+	}
+}
@@ -32,8 +32,13 @@ func newCollectSender(opts *zoekt.SearchOptions) *collectSender {
 }
 
 // Send aggregates the new search result by adding it stats and ranking
-// and truncating its files according to the input SearchOptions.
+// and truncating its files according to the input SearchOptions. Progress
+// events are dropped, since the aggregate is sent when they are outdated.
 func (c *collectSender) Send(r *zoekt.SearchResult) {
+	if r.SearchProgress != nil {
+		return
+	}
+
 	if c.aggregate == nil {
 		c.aggregate = &zoekt.SearchResult{
 			RepoURLs:      map[string]string{},
@@ -80,7 +85,7 @@ func (c *collectSender) Done() (_ *zoekt.SearchResult, ok bool) {
 
 // newFlushCollectSender creates a sender which will collect and rank results
 // until opts.FlushWallTime. After that it will stream each result as it is
-// sent.
+// sent. Progress events are always sent right away.
 func newFlushCollectSender(opts *zoekt.SearchOptions, sender zoekt.Sender) (zoekt.Sender, func()) {
 	// We don't need to do any collecting, so just pass back the sender to use
 	// directly.
@@ -139,7 +144,7 @@ func newFlushCollectSender(opts *zoekt.SearchOptions, sender zoekt.Sender) (zoek
 
 	return zoekt.SenderFunc(func(event *zoekt.SearchResult) {
 		mu.Lock()
-		if collectSender != nil {
+		if collectSender != nil && event.SearchProgress == nil {
 			collectSender.Send(event)
 		} else {
 			sender.Send(event)
@@ -152,6 +157,11 @@ func newFlushCollectSender(opts *zoekt.SearchOptions, sender zoekt.Sender) (zoek
 // truncating.
 func limitSender(cancel context.CancelFunc, sender zoekt.Sender, truncator index.DisplayTruncator) zoekt.Sender {
 	return zoekt.SenderFunc(func(result *zoekt.SearchResult) {
+		if result.SearchProgress != nil {
+			sender.Send(result)
+			return
+		}
+
 		var hasMore bool
 		result.Files, hasMore = truncator(result.Files)
 		if !hasMore {
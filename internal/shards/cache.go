@@ -0,0 +1,238 @@
+package shards
+
+import (
+	"container/list"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+var (
+	metricResultCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_result_cache_hits_total",
+		Help: "The total number of searches answered from the result cache",
+	})
+	metricResultCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_result_cache_misses_total",
+		Help: "The total number of cacheable searches not found in the result cache",
+	})
+)
+
+// resultCacheSize is the maximum number of search results kept in the result
+// cache. The cache is disabled if it is 0, which is the default.
+var resultCacheSize = parseResultCacheSize(os.Getenv("ZOEKT_RESULT_CACHE_SIZE"))
+
+// resultCacheBytes is the maximum total size of the results kept in the
+// result cache, as estimated by SearchResult.SizeBytes. It defaults to 256
+// MiB.
+var resultCacheBytes = parseResultCacheBytes(os.Getenv("ZOEKT_RESULT_CACHE_BYTES"))
+
+func parseResultCacheSize(v string) int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func parseResultCacheBytes(v string) uint64 {
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || n == 0 {
+		return 256 << 20
+	}
+	return n
+}
+
+// resultCache is an LRU cache of search results. Keys must include the
+// version of the shard set the result was computed on, so that results are
+// never served once shards have been added, removed or replaced.
+type resultCache struct {
+	mu       sync.Mutex
+	max      int
+	maxBytes uint64
+	bytes    uint64
+	ll       *list.List
+	items    map[resultCacheKey]*list.Element
+}
+
+type resultCacheKey struct {
+	q       string
+	opts    string
+	version uint64
+}
+
+type resultCacheEntry struct {
+	key  resultCacheKey
+	sr   *zoekt.SearchResult
+	size uint64
+}
+
+// newResultCache returns a cache holding up to max results of at most
+// maxBytes in total. It returns nil if max is 0, and all methods on a nil
+// cache are no-ops.
+func newResultCache(max int, maxBytes uint64) *resultCache {
+	if max <= 0 || maxBytes == 0 {
+		return nil
+	}
+	return &resultCache{
+		max:      max,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[resultCacheKey]*list.Element),
+	}
+}
+
+// get returns a copy of the cached result for key. The matches are copied,
+// but the file and line contents are shared and must not be mutated.
+func (c *resultCache) get(key resultCacheKey) (*zoekt.SearchResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		metricResultCacheMissesTotal.Inc()
+		return nil, false
+	}
+	metricResultCacheHitsTotal.Inc()
+	c.ll.MoveToFront(e)
+
+	return copyResult(e.Value.(*resultCacheEntry).sr), true
+}
+
+// add stores sr under key, evicting the least recently used entries until
+// the cache is within its limits again. Results larger than the byte limit
+// are not cached. sr must not reference mmap'd shard data.
+func (c *resultCache) add(key resultCacheKey, sr *zoekt.SearchResult) {
+	if c == nil {
+		return
+	}
+
+	size := sr.SizeBytes()
+	if size > c.maxBytes {
+		return
+	}
+
+	sr = copyResult(sr)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*resultCacheEntry)
+		c.bytes = c.bytes - entry.size + size
+		entry.sr, entry.size = sr, size
+	} else {
+		c.items[key] = c.ll.PushFront(&resultCacheEntry{key: key, sr: sr, size: size})
+		c.bytes += size
+	}
+
+	for c.ll.Len() > c.max || c.bytes > c.maxBytes {
+		oldest := c.ll.Back()
+		entry := oldest.Value.(*resultCacheEntry)
+		c.ll.Remove(oldest)
+		delete(c.items, entry.key)
+		c.bytes -= entry.size
+	}
+}
+
+// purge drops all entries. Entries for old shard set versions can never be
+// hit again, so we free their memory as soon as the shard set changes.
+func (c *resultCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[resultCacheKey]*list.Element)
+	c.bytes = 0
+}
+
+// copyResult copies sr down to the individual matches, so that callers may
+// modify the copy, eg. when sorting or truncating matches. Byte slices holding
+// file and line contents are shared.
+func copyResult(sr *zoekt.SearchResult) *zoekt.SearchResult {
+	cp := *sr
+	cp.Files = make([]zoekt.FileMatch, len(sr.Files))
+	for i := range sr.Files {
+		cp.Files[i] = copyFileMatch(&sr.Files[i])
+	}
+	cp.RepoURLs = make(map[string]string, len(sr.RepoURLs))
+	for k, v := range sr.RepoURLs {
+		cp.RepoURLs[k] = v
+	}
+	cp.LineFragments = make(map[string]string, len(sr.LineFragments))
+	for k, v := range sr.LineFragments {
+		cp.LineFragments[k] = v
+	}
+	return &cp
+}
+
+func copyFileMatch(fm *zoekt.FileMatch) zoekt.FileMatch {
+	cp := *fm
+	cp.Branches = slices.Clone(fm.Branches)
+	cp.LineMatches = copyLineMatches(fm.LineMatches)
+	cp.ChunkMatches = copyChunkMatches(fm.ChunkMatches)
+	if fm.BranchMatches != nil {
+		cp.BranchMatches = make([]zoekt.BranchMatch, len(fm.BranchMatches))
+		for i, bm := range fm.BranchMatches {
+			bm.Branches = slices.Clone(bm.Branches)
+			bm.LineMatches = copyLineMatches(bm.LineMatches)
+			bm.ChunkMatches = copyChunkMatches(bm.ChunkMatches)
+			cp.BranchMatches[i] = bm
+		}
+	}
+	return cp
+}
+
+func copyLineMatches(lms []zoekt.LineMatch) []zoekt.LineMatch {
+	if lms == nil {
+		return nil
+	}
+	cp := make([]zoekt.LineMatch, len(lms))
+	for i, lm := range lms {
+		lm.LineFragments = slices.Clone(lm.LineFragments)
+		for j := range lm.LineFragments {
+			lm.LineFragments[j].SymbolInfo = copySymbol(lm.LineFragments[j].SymbolInfo)
+		}
+		cp[i] = lm
+	}
+	return cp
+}
+
+func copyChunkMatches(cms []zoekt.ChunkMatch) []zoekt.ChunkMatch {
+	if cms == nil {
+		return nil
+	}
+	cp := make([]zoekt.ChunkMatch, len(cms))
+	for i, cm := range cms {
+		cm.Ranges = slices.Clone(cm.Ranges)
+		cm.SymbolInfo = slices.Clone(cm.SymbolInfo)
+		for j := range cm.SymbolInfo {
+			cm.SymbolInfo[j] = copySymbol(cm.SymbolInfo[j])
+		}
+		cp[i] = cm
+	}
+	return cp
+}
+
+func copySymbol(s *zoekt.Symbol) *zoekt.Symbol {
+	if s == nil {
+		return nil
+	}
+	cp := *s
+	return &cp
+}
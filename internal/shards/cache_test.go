@@ -0,0 +1,163 @@
+package shards
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestResultCache_Evict(t *testing.T) {
+	c := newResultCache(2, 1<<20)
+
+	key := func(q string) resultCacheKey {
+		return resultCacheKey{q: q}
+	}
+
+	c.add(key("a"), &zoekt.SearchResult{})
+	c.add(key("b"), &zoekt.SearchResult{})
+	if _, ok := c.get(key("a")); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	// b is now the least recently used entry.
+	c.add(key("c"), &zoekt.SearchResult{})
+	if _, ok := c.get(key("b")); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.get(key(k)); !ok {
+			t.Fatalf("expected %s to be cached", k)
+		}
+	}
+
+	c.purge()
+	if _, ok := c.get(key("a")); ok {
+		t.Fatal("expected purge to drop a")
+	}
+}
+
+func TestResultCache_Bytes(t *testing.T) {
+	sr := &zoekt.SearchResult{Files: []zoekt.FileMatch{{Content: make([]byte, 100)}}}
+	size := sr.SizeBytes()
+	c := newResultCache(10, 2*size)
+
+	key := func(q string) resultCacheKey {
+		return resultCacheKey{q: q}
+	}
+
+	c.add(key("a"), sr)
+	c.add(key("b"), sr)
+	c.add(key("c"), sr)
+	if _, ok := c.get(key("a")); ok {
+		t.Fatal("expected a to be evicted")
+	}
+	if c.bytes != 2*size {
+		t.Fatalf("got %d bytes, want %d", c.bytes, 2*size)
+	}
+
+	// Results larger than the cache are not stored.
+	c.add(key("big"), &zoekt.SearchResult{Files: []zoekt.FileMatch{{Content: make([]byte, 3*size)}}})
+	if _, ok := c.get(key("big")); ok {
+		t.Fatal("expected oversized result to be skipped")
+	}
+	for _, k := range []string{"b", "c"} {
+		if _, ok := c.get(key(k)); !ok {
+			t.Fatalf("expected %s to be cached", k)
+		}
+	}
+}
+
+func TestResultCache_DeepCopy(t *testing.T) {
+	c := newResultCache(1, 1<<20)
+	c.add(resultCacheKey{}, &zoekt.SearchResult{Files: []zoekt.FileMatch{{
+		LineMatches:  []zoekt.LineMatch{{LineFragments: []zoekt.LineFragmentMatch{{MatchLength: 1}}}},
+		ChunkMatches: []zoekt.ChunkMatch{{Ranges: []zoekt.Range{{}}}},
+	}}})
+
+	sr, _ := c.get(resultCacheKey{})
+	sr.Files[0].LineMatches[0].LineFragments[0].MatchLength = 2
+	sr.Files[0].ChunkMatches[0].Ranges = nil
+
+	sr, _ = c.get(resultCacheKey{})
+	if got := sr.Files[0].LineMatches[0].LineFragments[0].MatchLength; got != 1 {
+		t.Errorf("got cached match length %d, want 1", got)
+	}
+	if got := len(sr.Files[0].ChunkMatches[0].Ranges); got != 1 {
+		t.Errorf("got %d cached ranges, want 1", got)
+	}
+}
+
+func TestResultCacheKeyFor_Tracing(t *testing.T) {
+	q := &query.Substring{Pattern: "needle"}
+	a, err := resultCacheKeyFor(q, &zoekt.SearchOptions{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := resultCacheKeyFor(q, &zoekt.SearchOptions{Trace: true, SpanContext: map[string]string{"uber-trace-id": "1"}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatalf("tracing options changed the key: %v != %v", a, b)
+	}
+}
+
+func TestResultCache_Nil(t *testing.T) {
+	c := newResultCache(0, 1<<20)
+	if c != nil {
+		t.Fatal("expected size 0 to disable the cache")
+	}
+	c.add(resultCacheKey{}, &zoekt.SearchResult{})
+	if _, ok := c.get(resultCacheKey{}); ok {
+		t.Fatal("nil cache returned a result")
+	}
+	c.purge()
+}
+
+func TestShardedSearcher_ResultCache(t *testing.T) {
+	ss := newShardedSearcher(1)
+	ss.cache = newResultCache(10, 1<<20)
+
+	addShard := func(key, repo string, docs ...index.Document) {
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		ss.replace(map[string]zoekt.Searcher{
+			key: searcherForTest(t, testShardBuilder(t, r, docs...)),
+		})
+	}
+
+	addShard("key-1", "repo1", index.Document{Name: "f1", Content: []byte("needle")})
+	ss.markReady()
+
+	q := &query.Substring{Pattern: "needle"}
+	search := func() *zoekt.SearchResult {
+		t.Helper()
+		sr, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sr
+	}
+
+	if got := len(search().Files); got != 1 {
+		t.Fatalf("got %d files, want 1", got)
+	}
+	if got := ss.cache.ll.Len(); got != 1 {
+		t.Fatalf("got %d cache entries, want 1", got)
+	}
+
+	// Mutating a returned result must not affect the cached result.
+	sr := search()
+	sr.Files = nil
+	if got := len(search().Files); got != 1 {
+		t.Fatalf("got %d files from cache, want 1", got)
+	}
+
+	// Adding a shard must invalidate the cached result.
+	addShard("key-2", "repo2", index.Document{Name: "f2", Content: []byte("needle")})
+	if got := len(search().Files); got != 2 {
+		t.Fatalf("got %d files after adding a shard, want 2", got)
+	}
+}
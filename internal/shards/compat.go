@@ -0,0 +1,95 @@
+package shards
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+var metricOldShardsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zoekt_old_shards_total",
+	Help: "The total number of loaded shards of an older feature version, by what happened to them",
+}, []string{"action"})
+
+// oldShardsPolicy is what the searcher does with shards of an older feature
+// version.
+type oldShardsPolicy string
+
+const (
+	// oldShardsLoad searches old shards as they are.
+	oldShardsLoad oldShardsPolicy = "load"
+
+	// oldShardsRefuse doesn't load old shards.
+	oldShardsRefuse oldShardsPolicy = "refuse"
+
+	// oldShardsUpgrade rewrites old shards at the current feature version
+	// before loading them, see index.UpgradeShard. Shards which can only be
+	// upgraded by reindexing are loaded as they are.
+	oldShardsUpgrade oldShardsPolicy = "upgrade"
+)
+
+// oldShards is set with ZOEKT_OLD_SHARDS to load, refuse or upgrade. It
+// defaults to load.
+var oldShards = parseOldShardsPolicy(os.Getenv("ZOEKT_OLD_SHARDS"))
+
+func parseOldShardsPolicy(v string) oldShardsPolicy {
+	switch p := oldShardsPolicy(v); p {
+	case oldShardsLoad, oldShardsRefuse, oldShardsUpgrade:
+		return p
+	case "":
+	default:
+		log.Printf("WARN ignoring ZOEKT_OLD_SHARDS=%q, want load, refuse or upgrade", v)
+	}
+	return oldShardsLoad
+}
+
+// checkOldShard applies policy to the shard fn opened as f. It returns the
+// index file to load, which is f unless the shard was upgraded. f is closed
+// if it isn't returned.
+func checkOldShard(fn string, f index.IndexFile, policy oldShardsPolicy) (index.IndexFile, error) {
+	if policy == oldShardsLoad {
+		return f, nil
+	}
+
+	_, md, err := index.ReadMetadata(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Incompatible shards fail to load with a better error in NewSearcher.
+	compat, _ := index.CheckShardCompatibility(md)
+	if compat == index.ShardCurrent || compat == index.ShardIncompatible {
+		return f, nil
+	}
+
+	if policy == oldShardsRefuse {
+		f.Close()
+		metricOldShardsTotal.WithLabelValues("refused").Inc()
+		return nil, fmt.Errorf("%s has feature version %d, want %d (ZOEKT_OLD_SHARDS=refuse)", fn, md.IndexFeatureVersion, index.FeatureVersion)
+	}
+
+	if compat == index.ShardNeedsReindex {
+		metricOldShardsTotal.WithLabelValues("loaded").Inc()
+		return f, nil
+	}
+
+	err = index.UpgradeShard(fn, f)
+	if err != nil {
+		log.Printf("WARN loading %s without upgrading it: %v", fn, err)
+		metricOldShardsTotal.WithLabelValues("upgrade_failed").Inc()
+		return f, nil
+	}
+	f.Close()
+	metricOldShardsTotal.WithLabelValues("upgraded").Inc()
+
+	upgraded, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	return index.NewIndexFile(upgraded)
+}
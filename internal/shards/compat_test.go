@@ -0,0 +1,62 @@
+package shards
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestParseOldShardsPolicy(t *testing.T) {
+	for v, want := range map[string]oldShardsPolicy{
+		"":        oldShardsLoad,
+		"load":    oldShardsLoad,
+		"refuse":  oldShardsRefuse,
+		"upgrade": oldShardsUpgrade,
+		"bogus":   oldShardsLoad,
+	} {
+		if got := parseOldShardsPolicy(v); got != want {
+			t.Errorf("parseOldShardsPolicy(%q) = %s, want %s", v, got, want)
+		}
+	}
+}
+
+func TestCheckOldShard(t *testing.T) {
+	open := func(fn string) index.IndexFile {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iFile, err := index.NewIndexFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iFile
+	}
+
+	// ctagsrepo_v16 is at feature version 9, which needs reindexing to
+	// upgrade.
+	old := filepath.Join("..", "..", "testdata", "shards", "ctagsrepo_v16.00000.zoekt")
+	current := filepath.Join("..", "..", "testdata", "shards", "repo_v16.00000.zoekt")
+
+	for _, tc := range []struct {
+		fn      string
+		policy  oldShardsPolicy
+		wantErr bool
+	}{
+		{old, oldShardsLoad, false},
+		{old, oldShardsRefuse, true},
+		{old, oldShardsUpgrade, false},
+		{current, oldShardsRefuse, false},
+		{current, oldShardsUpgrade, false},
+	} {
+		iFile, err := checkOldShard(tc.fn, open(tc.fn), tc.policy)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s with %s: got error %v, want error %t", filepath.Base(tc.fn), tc.policy, err, tc.wantErr)
+		}
+		if iFile != nil {
+			iFile.Close()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package shards
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var metricSearchTooExpensiveTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "zoekt_search_too_expensive_total",
+	Help: "The total number of searches rejected because their estimated cost exceeded SearchOptions.MaxEstimatedCost",
+})
+
+// checkCost returns a *zoekt.QueryTooExpensiveError if the estimated cost of
+// q summed over shards exceeds opts.MaxEstimatedCost. Estimation stops as soon
+// as the limit is exceeded. Shards which can't estimate their cost count as
+// free.
+func checkCost(ctx context.Context, shards []*rankedShard, q query.Q, opts *zoekt.SearchOptions) error {
+	// Estimating the number of documents is cheaper than estimating the cost.
+	if opts.MaxEstimatedCost <= 0 || opts.EstimateDocCount {
+		return nil
+	}
+
+	var total atomic.Int64
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for _, s := range shards {
+		if total.Load() > opts.MaxEstimatedCost {
+			break
+		}
+		g.Go(func() error {
+			if total.Load() > opts.MaxEstimatedCost {
+				return nil
+			}
+			cost, err := estimateShardCost(ctx, s, q, opts)
+			if err != nil {
+				return err
+			}
+			total.Add(cost)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	if cost := total.Load(); cost > opts.MaxEstimatedCost {
+		metricSearchTooExpensiveTotal.Inc()
+		return &zoekt.QueryTooExpensiveError{Cost: cost, MaxCost: opts.MaxEstimatedCost}
+	}
+	return nil
+}
+
+func estimateShardCost(ctx context.Context, s *rankedShard, q query.Q, opts *zoekt.SearchOptions) (cost int64, err error) {
+	ce, ok := s.Searcher.(zoekt.CostEstimator)
+	if !ok {
+		return 0, nil
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			// The search of the shard will crash as well and be reported
+			// then.
+			log.Printf("[ERROR] crashed shard: %s: %#v, %s", s, e, debug.Stack())
+			cost, err = 0, nil
+		}
+	}()
+
+	return ce.EstimateCost(ctx, q, opts)
+}
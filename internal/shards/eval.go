@@ -71,6 +71,63 @@ func (s *typeRepoSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	}))
 }
 
+func (s *typeRepoSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) (results []*zoekt.SearchResult, err error) {
+	tr, ctx := trace.New(ctx, "typeRepoSearcher.BatchSearch", "")
+	tr.LazyPrintf("queries: %d", len(qs))
+	tr.LazyPrintf("opts: %+v", opts)
+	if tenant.EnforceTenant() {
+		tenant.Log(ctx, tr)
+	}
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	evaluated := make([]query.Q, len(qs))
+	for i, q := range qs {
+		evaluated[i], err = s.eval(ctx, tr, q)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return zoekt.BatchSearch(ctx, s.Streamer, evaluated, opts)
+}
+
+func (s *typeRepoSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (e *zoekt.Explanation, err error) {
+	tr, ctx := trace.New(ctx, "typeRepoSearcher.Explain", "")
+	tr.LazyLog(q, true)
+	if tenant.EnforceTenant() {
+		tenant.Log(ctx, tr)
+	}
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	q, err = s.eval(ctx, tr, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s *typeRepoSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+func (s *typeRepoSearcher) Version() uint64 {
+	v, _ := Version(s.Streamer)
+	return v
+}
+
 func (s *typeRepoSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (rl *zoekt.RepoList, err error) {
 	tr, ctx := trace.New(ctx, "typeRepoSearcher.List", "")
 	tr.LazyLog(q, true)
@@ -97,6 +154,29 @@ func (s *typeRepoSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 	return s.Streamer.List(ctx, q, opts)
 }
 
+func (s *typeRepoSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) (err error) {
+	tr, ctx := trace.New(ctx, "typeRepoSearcher.StreamList", "")
+	tr.LazyLog(q, true)
+	tr.LazyPrintf("opts: %s", opts)
+	if tenant.EnforceTenant() {
+		tenant.Log(ctx, tr)
+	}
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	q, err = s.eval(ctx, tr, q)
+	if err != nil {
+		return err
+	}
+
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, sender)
+}
+
 func (s *typeRepoSearcher) eval(ctx context.Context, tr *trace.Trace, q query.Q) (query.Q, error) {
 	var err error
 	q = query.Map(q, func(q query.Q) query.Q {
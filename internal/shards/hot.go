@@ -0,0 +1,204 @@
+package shards
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+var (
+	metricShardSearchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_shard_searches_total",
+		Help: "The total number of searches served by a shard",
+	}, []string{"shard"})
+	metricShardIndexBytesLoadedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_shard_index_bytes_loaded_total",
+		Help: "The total number of index bytes loaded by searches of a shard",
+	}, []string{"shard"})
+	metricShardContentBytesLoadedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_shard_content_bytes_loaded_total",
+		Help: "The total number of content bytes loaded by searches of a shard",
+	}, []string{"shard"})
+	metricShardSearchDurationSecondsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_shard_search_duration_seconds_total",
+		Help: "The total time spent searching a shard. Divide by zoekt_shard_searches_total for the average latency",
+	}, []string{"shard"})
+)
+
+// hotShardOther is the shard label of the shards which didn't get a label of
+// their own, see hotShardLabels.
+const hotShardOther = "other"
+
+// hotShardLabels is the maximum number of shards with their own label in the
+// zoekt_shard_* metrics. Further shards are counted under the label "other",
+// which bounds the cardinality on instances with many shards. /debug/hot
+// always lists every shard. It defaults to 100, and 0 counts all shards as
+// "other".
+var hotShardLabels = parseHotShardLabels(os.Getenv("ZOEKT_HOT_SHARD_LABELS"))
+
+func parseHotShardLabels(v string) int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 100
+	}
+	return n
+}
+
+// hotness tracks how much each shard is searched, so operators can tell which
+// repositories to move to faster storage or keep out of compound shards.
+type hotness struct {
+	mu        sync.Mutex
+	maxLabels int
+	shards    map[string]*shardHotness
+	// labels are the shards which have their own label in the metrics.
+	labels map[string]struct{}
+}
+
+// shardHotness are the statistics of searches of one shard.
+type shardHotness struct {
+	Name string
+	// Repos are the names of the repositories in the shard.
+	Repos []string
+
+	Searches           int64
+	IndexBytesLoaded   int64
+	ContentBytesLoaded int64
+	Duration           time.Duration
+}
+
+// AvgDuration is the average latency of searches of the shard.
+func (h *shardHotness) AvgDuration() time.Duration {
+	if h.Searches == 0 {
+		return 0
+	}
+	return h.Duration / time.Duration(h.Searches)
+}
+
+// shardHot tracks the shards of all shardedSearchers in this process, like
+// the metrics it updates.
+var shardHot = newHotness(hotShardLabels)
+
+func newHotness(maxLabels int) *hotness {
+	return &hotness{
+		maxLabels: maxLabels,
+		shards:    map[string]*shardHotness{},
+		labels:    map[string]struct{}{},
+	}
+}
+
+// observe records a search of the shard s which took d.
+func (h *hotness) observe(s *rankedShard, sr *zoekt.SearchResult, d time.Duration) {
+	name := s.name
+	if name == "" {
+		name = s.String()
+	}
+
+	h.mu.Lock()
+	sh, ok := h.shards[name]
+	if !ok {
+		sh = &shardHotness{Name: name}
+		for _, r := range s.repos {
+			sh.Repos = append(sh.Repos, r.Name)
+		}
+		h.shards[name] = sh
+	}
+	sh.Searches++
+	sh.IndexBytesLoaded += int64(sr.Stats.IndexBytesLoaded)
+	sh.ContentBytesLoaded += sr.Stats.ContentBytesLoaded
+	sh.Duration += d
+
+	label := hotShardOther
+	if _, ok := h.labels[name]; ok {
+		label = name
+	} else if len(h.labels) < h.maxLabels {
+		h.labels[name] = struct{}{}
+		label = name
+	}
+	h.mu.Unlock()
+
+	metricShardSearchesTotal.WithLabelValues(label).Inc()
+	metricShardIndexBytesLoadedTotal.WithLabelValues(label).Add(float64(sr.Stats.IndexBytesLoaded))
+	metricShardContentBytesLoadedTotal.WithLabelValues(label).Add(float64(sr.Stats.ContentBytesLoaded))
+	metricShardSearchDurationSecondsTotal.WithLabelValues(label).Add(d.Seconds())
+}
+
+// forget drops the statistics of the shard name once it is unloaded, which
+// frees its label for another shard.
+func (h *hotness) forget(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.shards, name)
+	if _, ok := h.labels[name]; ok {
+		delete(h.labels, name)
+		metricShardSearchesTotal.DeleteLabelValues(name)
+		metricShardIndexBytesLoadedTotal.DeleteLabelValues(name)
+		metricShardContentBytesLoadedTotal.DeleteLabelValues(name)
+		metricShardSearchDurationSecondsTotal.DeleteLabelValues(name)
+	}
+}
+
+// hottest returns the statistics of all shards, most searched first.
+func (h *hotness) hottest() []shardHotness {
+	h.mu.Lock()
+	all := make([]shardHotness, 0, len(h.shards))
+	for _, sh := range h.shards {
+		all = append(all, *sh)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Searches != all[j].Searches {
+			return all[i].Searches > all[j].Searches
+		}
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// maxHotRepos is the number of repository names /debug/hot shows per shard.
+const maxHotRepos = 3
+
+func (h *hotness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	all := h.hottest()
+	if num, err := strconv.Atoi(r.URL.Query().Get("num")); err == nil && num > 0 && num < len(all) {
+		all = all[:num]
+	}
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 16, 8, 4, ' ', 0)
+	fmt.Fprintf(tw, "Shard\tSearches\tIndexBytesLoaded\tContentBytesLoaded\tAvgDuration\tRepos\t\n")
+	for _, sh := range all {
+		repos := sh.Repos
+		if len(repos) > maxHotRepos {
+			repos = append(repos[:maxHotRepos:maxHotRepos], fmt.Sprintf("... %d more", len(sh.Repos)-maxHotRepos))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t\n", sh.Name, sh.Searches, sh.IndexBytesLoaded, sh.ContentBytesLoaded, sh.AvgDuration(), strings.Join(repos, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// HotHandler serves /debug/hot, which lists the shards of this process by
+// the number of searches they served, with the bytes they loaded and their
+// average latency. The num parameter limits the number of shards listed.
+func HotHandler() http.Handler {
+	return shardHot
+}
@@ -0,0 +1,52 @@
+package shards
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestHotness(t *testing.T) {
+	h := newHotness(1)
+	a := &rankedShard{name: "a.zoekt", repos: []*zoekt.Repository{{Name: "repo-a"}}}
+	b := &rankedShard{name: "b.zoekt", repos: []*zoekt.Repository{{Name: "repo-b"}}}
+
+	sr := &zoekt.SearchResult{Stats: zoekt.Stats{IndexBytesLoaded: 10, ContentBytesLoaded: 100}}
+	h.observe(a, sr, time.Second)
+	h.observe(b, sr, time.Second)
+	h.observe(b, sr, 3*time.Second)
+
+	all := h.hottest()
+	if len(all) != 2 || all[0].Name != "b.zoekt" || all[1].Name != "a.zoekt" {
+		t.Fatalf("got %+v, want b.zoekt before a.zoekt", all)
+	}
+	if got := all[0]; got.Searches != 2 || got.IndexBytesLoaded != 20 || got.ContentBytesLoaded != 200 || got.AvgDuration() != 2*time.Second {
+		t.Errorf("unexpected statistics %+v", got)
+	}
+
+	// Only the first shard got a label of its own.
+	if _, ok := h.labels["a.zoekt"]; !ok || len(h.labels) != 1 {
+		t.Errorf("got labels %v, want only a.zoekt", h.labels)
+	}
+
+	h.forget("a.zoekt")
+	if all := h.hottest(); len(all) != 1 || all[0].Name != "b.zoekt" {
+		t.Errorf("got %+v after forget, want only b.zoekt", all)
+	}
+	h.observe(b, sr, time.Second)
+	if _, ok := h.labels["b.zoekt"]; !ok {
+		t.Errorf("b.zoekt didn't get the free label: %v", h.labels)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/hot", nil))
+	body := w.Body.String()
+	for _, want := range []string{"Shard", "b.zoekt", "repo-b"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/debug/hot doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
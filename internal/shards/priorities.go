@@ -0,0 +1,230 @@
+package shards
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var (
+	metricPrioritiesLoadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_priorities_loads_total",
+		Help: "The total number of times the priorities file was loaded, by result",
+	}, []string{"result"})
+	metricPrioritiesLastLoadTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_priorities_last_load_timestamp_seconds",
+		Help: "The time the priorities file was last loaded successfully",
+	})
+)
+
+// prioritiesFile is the path of the priorities file. Ranking signals like
+// view counts or stars change more often than repositories are reindexed.
+// The file lets them take effect without reindexing, layered on top of the
+// priorities in the index. It is disabled if empty, which is the default.
+var prioritiesFile = os.Getenv("ZOEKT_PRIORITIES_FILE")
+
+// prioritiesInterval is how often the priorities file is checked for
+// changes. It defaults to a minute.
+var prioritiesInterval = parsePrioritiesInterval(os.Getenv("ZOEKT_PRIORITIES_INTERVAL"))
+
+func parsePrioritiesInterval(v string) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// priorities are the contents of a priorities file. A nil *priorities has
+// no priorities.
+//
+// The file is JSON, or CSV if its name ends in ".csv". In JSON, Repos maps
+// repository IDs to their priority and Paths maps path patterns to boosts,
+// see query.PathBoost:
+//
+//	{"Repos": {"1234": 500}, "Paths": {"vendor/": 0.5, "*_test.go": 0.8}}
+//
+// In CSV every record is either "repo,<id>,<priority>" or
+// "path,<pattern>,<boost>".
+type priorities struct {
+	// repos maps repository IDs to their priority, which replaces the one of
+	// the index.
+	repos map[uint32]float64
+
+	// paths are sorted by decreasing length of their pattern, so that the
+	// longest matching pattern wins.
+	paths []query.PathBoost
+}
+
+// shardPriority returns the maximum priority across repos.
+func (p *priorities) shardPriority(repos []*zoekt.Repository) float64 {
+	var maxPriority float64
+	for _, repo := range repos {
+		maxPriority = max(maxPriority, p.repoPriority(repo))
+	}
+	return maxPriority
+}
+
+// repoPriority returns the priority of repo in p, or the one of its index
+// configuration.
+func (p *priorities) repoPriority(repo *zoekt.Repository) float64 {
+	if p != nil {
+		if priority, ok := p.repos[repo.ID]; ok {
+			return priority
+		}
+	}
+	priority, _ := strconv.ParseFloat(repo.RawConfig["priority"], 64)
+	return priority
+}
+
+// apply sets the repository priorities of the files in sr and boosts their
+// scores.
+func (p *priorities) apply(sr *zoekt.SearchResult) {
+	if p == nil || sr == nil {
+		return
+	}
+	for i := range sr.Files {
+		f := &sr.Files[i]
+		if priority, ok := p.repos[f.RepositoryID]; ok {
+			f.RepositoryPriority = priority
+		}
+		f.Score *= query.PathBoostFactor(p.paths, f.FileName)
+	}
+}
+
+// parsePriorities parses the priorities file name with content data.
+func parsePriorities(name string, data []byte) (*priorities, error) {
+	p := &priorities{repos: map[uint32]float64{}}
+	paths := map[string]float64{}
+
+	if filepath.Ext(name) == ".csv" {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = 3
+		r.Comment = '#'
+		for {
+			record, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseFloat(record[2], 64)
+			if err != nil {
+				return nil, err
+			}
+			switch record[0] {
+			case "repo":
+				id, err := strconv.ParseUint(record[1], 10, 32)
+				if err != nil {
+					return nil, err
+				}
+				p.repos[uint32(id)] = v
+			case "path":
+				paths[record[1]] = v
+			default:
+				return nil, fmt.Errorf("unknown record type %q, want repo or path", record[0])
+			}
+		}
+	} else {
+		var file struct {
+			Repos map[uint32]float64
+			Paths map[string]float64
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		for id, v := range file.Repos {
+			p.repos[id] = v
+		}
+		paths = file.Paths
+	}
+
+	for pattern, boost := range paths {
+		pb := query.PathBoost{Pattern: pattern, Boost: boost}
+		if err := pb.Validate(); err != nil {
+			return nil, err
+		}
+		p.paths = append(p.paths, pb)
+	}
+	sort.Slice(p.paths, func(i, j int) bool {
+		a, b := p.paths[i].Pattern, p.paths[j].Pattern
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	return p, nil
+}
+
+// watchPriorities loads the priorities file name into ss whenever its
+// modification time changes, checking every interval until quit is closed.
+// A file which fails to load or is removed keeps the previous priorities.
+func watchPriorities(ss *shardedSearcher, name string, interval time.Duration, quit <-chan struct{}) {
+	var (
+		modTime time.Time
+		statErr string
+	)
+	load := func() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			// Only log when the error changes, eg. while the file doesn't
+			// exist yet.
+			if err.Error() != statErr {
+				log.Printf("[WARN] priorities file: %v", err)
+				statErr = err.Error()
+			}
+			return
+		}
+		statErr = ""
+		if fi.ModTime().Equal(modTime) {
+			return
+		}
+
+		data, err := os.ReadFile(name)
+		if err == nil {
+			var p *priorities
+			p, err = parsePriorities(name, data)
+			if err == nil {
+				ss.setPriorities(p)
+			}
+		}
+		if err != nil {
+			log.Printf("[ERROR] loading priorities file %s: %v", name, err)
+			metricPrioritiesLoadsTotal.WithLabelValues("error").Inc()
+		} else {
+			metricPrioritiesLoadsTotal.WithLabelValues("success").Inc()
+			metricPrioritiesLastLoadTimestamp.SetToCurrentTime()
+		}
+		// Don't retry a broken file until it changes.
+		modTime = fi.ModTime()
+	}
+
+	load()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			load()
+		case <-quit:
+			return
+		}
+	}
+}
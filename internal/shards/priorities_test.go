@@ -0,0 +1,148 @@
+package shards
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestParsePriorities(t *testing.T) {
+	want := &priorities{
+		repos: map[uint32]float64{1: 500, 2: 0},
+		paths: []query.PathBoost{{Pattern: "vendor/foo/", Boost: 2}, {Pattern: "*_test.go", Boost: 0.8}, {Pattern: "vendor/", Boost: 0.5}},
+	}
+
+	for name, data := range map[string]string{
+		"prio.json": `{"Repos": {"1": 500, "2": 0}, "Paths": {"vendor/": 0.5, "vendor/foo/": 2, "*_test.go": 0.8}}`,
+		"prio.csv":  "# kind,key,value\nrepo,1,500\nrepo,2,0\npath,vendor/,0.5\npath,vendor/foo/,2\npath,*_test.go,0.8\n",
+	} {
+		got, err := parsePriorities(name, []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"bad.json":   `{"Repos": {"repo": 1}}`,
+		"boost.json": `{"Paths": {"vendor/": 0}}`,
+		"glob.json":  `{"Paths": {"[vendor/": 0.5}}`,
+		"kind.csv":   "stars,1,500\n",
+		"id.csv":     "repo,-1,500\n",
+		"value.csv":  "repo,1,many\n",
+		"fields.csv": "repo,1\n",
+	} {
+		if _, err := parsePriorities(name, []byte(data)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestShardedSearcher_Priorities(t *testing.T) {
+	ss := newShardedSearcher(1)
+
+	repos := map[string]uint32{}
+	for i, repo := range []string{"popular", "rising", "quiet"} {
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		r.RawConfig = map[string]string{
+			"public":   "1",
+			"priority": strconv.Itoa(300 - 100*i),
+		}
+		repos[repo] = r.ID
+		b := testShardBuilder(t, r,
+			index.Document{Name: "main.go", Content: []byte("needle")},
+			index.Document{Name: "vendor/dep.go", Content: []byte("needle")})
+		ss.replace(map[string]zoekt.Searcher{fmt.Sprintf("key-%d", i): searcherForTest(t, b)})
+	}
+
+	order := func() []string {
+		var names []string
+		for _, s := range ss.getLoaded().shards {
+			names = append(names, s.repos[0].Name)
+		}
+		return names
+	}
+	if got, want := order(), []string{"popular", "rising", "quiet"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got shards %v before loading priorities, want %v", got, want)
+	}
+
+	search := func() map[string]zoekt.FileMatch {
+		sr, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]zoekt.FileMatch{}
+		for _, f := range sr.Files {
+			files[f.Repository+"/"+f.FileName] = f
+		}
+		return files
+	}
+	before := search()
+
+	version := ss.Version()
+	ss.setPriorities(&priorities{
+		repos: map[uint32]float64{repos["rising"]: 1000},
+		paths: []query.PathBoost{{Pattern: "vendor/", Boost: 0.5}},
+	})
+	if ss.Version() == version {
+		t.Error("setPriorities didn't change the version")
+	}
+	if got, want := order(), []string{"rising", "popular", "quiet"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got shards %v, want %v", got, want)
+	}
+
+	after := search()
+	if got := after["rising/main.go"].RepositoryPriority; got != 1000 {
+		t.Errorf("got priority %g for rising, want 1000", got)
+	}
+	if got := after["quiet/main.go"].RepositoryPriority; got != 100 {
+		t.Errorf("got priority %g for quiet, want the static 100", got)
+	}
+	if got, want := after["quiet/vendor/dep.go"].Score, before["quiet/vendor/dep.go"].Score/2; got != want {
+		t.Errorf("got score %g for a vendored file, want %g", got, want)
+	}
+	if got, want := after["quiet/main.go"].Score, before["quiet/main.go"].Score; got != want {
+		t.Errorf("got score %g for main.go, want the unchanged %g", got, want)
+	}
+
+	// Shards loaded later get the priorities as well.
+	r := &zoekt.Repository{ID: hash("new"), Name: "new", RawConfig: map[string]string{"priority": "10"}}
+	ss.setPriorities(&priorities{repos: map[uint32]float64{r.ID: 2000}})
+	ss.replace(map[string]zoekt.Searcher{"key-new": searcherForTest(t, testShardBuilder(t, r))})
+	if got := order()[0]; got != "new" {
+		t.Errorf("got first shard %s, want new", got)
+	}
+}
+
+func TestWatchPriorities(t *testing.T) {
+	ss := newShardedSearcher(1)
+	name := filepath.Join(t.TempDir(), "priorities.csv")
+
+	// A closed quit loads the file once.
+	quit := make(chan struct{})
+	close(quit)
+
+	watchPriorities(ss, name, time.Hour, quit)
+	if p := ss.getLoaded().priorities; p != nil {
+		t.Fatalf("got priorities %+v without a file", p)
+	}
+
+	if err := os.WriteFile(name, []byte("repo,1,500\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	watchPriorities(ss, name, time.Hour, quit)
+	if p := ss.getLoaded().priorities; p == nil || p.repos[1] != 500 {
+		t.Fatalf("got priorities %+v, want repo 1 with 500", p)
+	}
+}
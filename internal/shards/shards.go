@@ -16,15 +16,18 @@ package shards
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"slices"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,8 +37,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/tenant"
 	"github.com/sourcegraph/zoekt/internal/tenant/systemtenant"
 	"github.com/sourcegraph/zoekt/internal/trace"
 	"github.com/sourcegraph/zoekt/query"
@@ -177,7 +182,12 @@ var (
 type rankedShard struct {
 	zoekt.Searcher
 
-	priority float64 // maximum priority across all repos in the shard
+	// priority is the maximum priority across all repos in the shard. It
+	// changes when the priorities file is reloaded, see setPriorities.
+	priority atomic.Float64
+
+	// name is the file name of the shard, which keys its hotness.
+	name string
 
 	// We have out of band ranking on compound shards which can change even if
 	// the shard file does not. So we compute a rank in getShards. We store
@@ -197,6 +207,13 @@ type loaded struct {
 	// ready is true if sharded searcher has finished loading all initial
 	// shards on startup.
 	ready bool
+
+	// version changes every time the set of shards or their priorities
+	// change. It is used to key the result cache.
+	version uint64
+
+	// priorities are the priorities of the priorities file, or nil.
+	priorities *priorities
 }
 
 type shardedSearcher struct {
@@ -209,14 +226,23 @@ type shardedSearcher struct {
 	mu     sync.Mutex // protects writes to shards
 	shards map[string]*rankedShard
 
-	ready  atomic.Bool
-	ranked atomic.Value
+	ready   atomic.Bool
+	ranked  atomic.Value
+	version atomic.Uint64
+
+	// prio holds the *priorities of the priorities file, see setPriorities.
+	prio atomic.Value
+
+	// cache is nil unless enabled via ZOEKT_RESULT_CACHE_SIZE. Its total size is
+	// bounded by ZOEKT_RESULT_CACHE_BYTES.
+	cache *resultCache
 }
 
 func newShardedSearcher(n int64) *shardedSearcher {
 	ss := &shardedSearcher{
 		shards: make(map[string]*rankedShard),
 		sched:  newScheduler(n),
+		cache:  newResultCache(resultCacheSize, resultCacheBytes),
 	}
 	return ss
 }
@@ -237,12 +263,23 @@ func NewDirectorySearcherFast(dir string) (zoekt.Streamer, error) {
 	return newDirectorySearcher(dir, false)
 }
 
+// NewStorageSearcherFast is like NewDirectorySearcherFast, but loads the
+// shards of storage, eg. a bucket.
+func NewStorageSearcherFast(storage Storage) (zoekt.Streamer, error) {
+	return newStorageSearcher(storage, false)
+}
+
 func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, error) {
+	return newStorageSearcher(NewDirStorage(dir), waitUntilReady)
+}
+
+func newStorageSearcher(storage Storage, waitUntilReady bool) (zoekt.Streamer, error) {
 	ss := newShardedSearcher(int64(runtime.GOMAXPROCS(0)))
 	tl := &loader{
-		ss: ss,
+		ss:      ss,
+		storage: storage,
 	}
-	dw, err := newDirectoryWatcher(dir, tl)
+	dw, err := newStorageWatcher(storage, tl)
 	if err != nil {
 		return nil, err
 	}
@@ -258,6 +295,15 @@ func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, erro
 		directoryWatcher: dw,
 	}
 
+	if prioritiesFile != "" {
+		ds.stopPriorities = make(chan struct{})
+		ds.prioritiesStopped = make(chan struct{})
+		go func() {
+			defer close(ds.prioritiesStopped)
+			watchPriorities(ss, prioritiesFile, prioritiesInterval, ds.stopPriorities)
+		}()
+	}
+
 	return &typeRepoSearcher{Streamer: ds}, nil
 }
 
@@ -265,20 +311,60 @@ type directorySearcher struct {
 	zoekt.Streamer
 
 	directoryWatcher *DirectoryWatcher
+
+	// stopPriorities is closed to stop watching the priorities file. It is
+	// nil if there is no priorities file.
+	stopPriorities    chan struct{}
+	prioritiesStopped chan struct{}
 }
 
 func (s *directorySearcher) Close() {
 	// We need to Stop directoryWatcher first since it calls load/unload on
-	// Searcher.
+	// Searcher. The same goes for the priorities.
 	s.directoryWatcher.Stop()
+	if s.stopPriorities != nil {
+		close(s.stopPriorities)
+		<-s.prioritiesStopped
+	}
 	s.Streamer.Close()
 }
 
+func (s *directorySearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	return zoekt.BatchSearch(ctx, s.Streamer, qs, opts)
+}
+
+func (s *directorySearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s *directorySearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+func (s *directorySearcher) Version() uint64 {
+	v, _ := Version(s.Streamer)
+	return v
+}
+
+// Version returns a number which changes whenever the shards searched by s
+// are loaded or unloaded. It returns false if s does not track its shards,
+// eg. because it is not a directory searcher.
+func Version(s zoekt.Searcher) (uint64, bool) {
+	if v, ok := s.(interface{ Version() uint64 }); ok {
+		return v.Version(), true
+	}
+	return 0, false
+}
+
 type loader struct {
 	ss *shardedSearcher
+
+	// storage, if not nil, makes the files available locally before they are
+	// loaded, see Storage.Open.
+	storage Storage
 }
 
-func (tl *loader) load(keys ...string) {
+func (tl *loader) load(dropped []string, keys ...string) (failed []string) {
 	// This is called with all keys on startup, so once this function has
 	// finished running shardedSearcher will be ready.
 	defer tl.ss.markReady()
@@ -286,11 +372,12 @@ func (tl *loader) load(keys ...string) {
 	if len(keys) == 0 {
 		// If there's nothing to load, we exit early here, but we want to mark
 		// ourselves as ready.
-		return
+		tl.drop(dropped...)
+		return nil
 	}
 
 	var (
-		mu           sync.Mutex     // synchronizes writes to the shards map
+		mu           sync.Mutex     // synchronizes writes to the shards map and failed
 		wg           sync.WaitGroup // used to wait for all shards to load
 		sem          = semaphore.NewWeighted(int64(runtime.GOMAXPROCS(0)))
 		loadedShards = make(map[string]zoekt.Searcher)
@@ -323,10 +410,20 @@ func (tl *loader) load(keys ...string) {
 			defer sem.Release(1)
 			defer wg.Done()
 
-			shard, err := loadShard(key)
+			var shard zoekt.Searcher
+			var err error
+			if tl.storage != nil {
+				err = tl.storage.Open(key)
+			}
+			if err == nil {
+				shard, err = loadShard(key)
+			}
 			if err != nil {
 				metricShardsLoadFailedTotal.Inc()
 				log.Printf("[ERROR] reloading: %s, err %v ", key, err)
+				mu.Lock()
+				failed = append(failed, key)
+				mu.Unlock()
 				return
 			}
 			metricShardsLoadedTotal.Inc()
@@ -339,7 +436,18 @@ func (tl *loader) load(keys ...string) {
 
 	wg.Wait()
 
+	// The dropped shards are replaced in the same step as the last loaded
+	// ones, so that searches see either the old or the new shards of a
+	// repository which moved between them. In-flight searches keep using the
+	// dropped shards until they finish, see shardedSearcher.replace.
+	mu.Lock()
+	for _, key := range dropped {
+		loadedShards[key] = nil
+	}
+	mu.Unlock()
+
 	publishLoaded()
+	return failed
 }
 
 func (tl *loader) drop(keys ...string) {
@@ -366,6 +474,16 @@ func (ss *shardedSearcher) Close() {
 	ss.replace(shards)
 }
 
+// withRepoFilter restricts q to opts.RepoFilter. The filter comes first in
+// the conjunction, so selectRepoSet skips the shards without any of the
+// allowed repositories.
+func withRepoFilter(q query.Q, opts *zoekt.SearchOptions) query.Q {
+	if opts == nil || opts.RepoFilter == nil {
+		return q
+	}
+	return query.NewAnd(&query.RepoIDs{Repos: opts.RepoFilter}, q)
+}
+
 func selectRepoSet(shards []*rankedShard, q query.Q) ([]*rankedShard, query.Q) {
 	and, ok := q.(*query.And)
 	if ok {
@@ -531,9 +649,25 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	start := time.Now()
+
+	// Results depend on the tenant in ctx and on the repository filter, which
+	// are not part of the key, so we only cache if neither applies.
+	useCache := ss.cache != nil && !tenant.EnforceTenant() && opts.RepoFilter == nil
+	if useCache {
+		key, err := resultCacheKeyFor(q, opts, ss.getLoaded().version)
+		if err != nil {
+			useCache = false
+		} else if cached, ok := ss.cache.get(key); ok {
+			tr.LazyPrintf("result cache hit")
+			cached.Stats.Wait = 0
+			cached.Stats.Duration = time.Since(start)
+			return cached, nil
+		}
+	}
+
 	collectSender := newCollectSender(opts)
 
-	start := time.Now()
 	proc, err := ss.sched.Acquire(ctx)
 	if err != nil {
 		return nil, err
@@ -545,7 +679,7 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	start = time.Now()
 
 	loaded := ss.getLoaded()
-	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, collectSender)
+	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, loaded.priorities, collectSender)
 	defer done()
 	if err != nil {
 		return nil, err
@@ -566,12 +700,45 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 		aggregate.Stats.Crashes++
 	}
 
+	if opts != nil && opts.MaxSuggestions > 0 && !opts.EstimateDocCount && len(aggregate.Files) == 0 && aggregate.Stats.Crashes == 0 {
+		if suggestions, err := suggest(ctx, proc, q, opts, loaded); err != nil {
+			tr.LazyPrintf("suggest: %v", err)
+		} else {
+			aggregate.Suggestions = suggestions
+		}
+	}
+
 	aggregate.Stats.Wait = wait
 	aggregate.Stats.Duration = time.Since(start)
 
+	// Only complete results are cached: a search over partially loaded shards
+	// or with crashed shards may succeed on retry.
+	if useCache && loaded.ready && aggregate.Stats.Crashes == 0 {
+		if key, err := resultCacheKeyFor(q, opts, loaded.version); err == nil {
+			ss.cache.add(key, aggregate)
+		}
+	}
+
 	return aggregate, nil
 }
 
+// resultCacheKeyFor returns the result cache key for q. We can't use
+// q.String() since it abbreviates large sets, eg. in RepoSet. The tracing
+// options don't change the result, and the span context differs for every
+// request, so they are left out of the key.
+func resultCacheKeyFor(q query.Q, opts *zoekt.SearchOptions, version uint64) (resultCacheKey, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(query.QToProto(q))
+	if err != nil {
+		return resultCacheKey{}, err
+	}
+
+	keyOpts := *opts
+	keyOpts.Trace = false
+	keyOpts.SpanContext = nil
+
+	return resultCacheKey{q: string(b), opts: keyOpts.String(), version: version}, nil
+}
+
 func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) (err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.StreamSearch", "")
 	defer func() {
@@ -595,7 +762,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 
 	maxPendingPriority := math.Inf(-1)
 	if len(shards) > 0 {
-		maxPendingPriority = shards[0].priority
+		maxPendingPriority = shards[0].priority.Load()
 	}
 
 	stillLoadingCrashes := 0
@@ -633,7 +800,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 
 	sender, flush := newFlushCollectSender(opts, sender)
 
-	done, err := streamSearch(ctx, proc, q, opts, shards, sender)
+	done, err := streamSearch(ctx, proc, q, opts, shards, loaded.priorities, sender)
 
 	// Even though streaming is done, we may have results sitting in a buffer we
 	// need to flush. So we need to send those before calling done.
@@ -643,6 +810,346 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	return err
 }
 
+// BatchSearch evaluates qs under a single scheduler slot. The queries of a
+// shard are searched back to back, but each query is evaluated on its own,
+// with its own iteration over the shard, so a batch costs about as much as the
+// same number of searches and qs is limited to zoekt.MaxBatchSize queries. The
+// returned results are in the same order as qs.
+func (ss *shardedSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) (results []*zoekt.SearchResult, err error) {
+	if len(qs) > zoekt.MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d queries exceeds the maximum of %d", len(qs), zoekt.MaxBatchSize)
+	}
+
+	tr, ctx := trace.New(ctx, "shardedSearcher.BatchSearch", "")
+	tr.LazyPrintf("queries: %d", len(qs))
+	tr.LazyPrintf("opts: %+v", opts)
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	start := time.Now()
+	proc, err := ss.sched.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Release()
+	tr.LazyPrintf("acquired process")
+
+	wait := time.Since(start)
+	start = time.Now()
+
+	loaded := ss.getLoaded()
+	done, results, err := batchSearch(ctx, proc, qs, opts, loaded.shards, loaded.priorities)
+	defer done()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sr := range results {
+		copyFiles(sr)
+
+		if !loaded.ready {
+			// We may have missed results due to not being fully loaded.
+			sr.Stats.Crashes++
+		}
+
+		sr.Stats.Wait = wait
+		sr.Stats.Duration = time.Since(start)
+	}
+
+	return results, nil
+}
+
+// Explain explains how the loaded shards evaluate q. Shards which
+// selectRepoSet excludes without searching them are reported as skipped.
+func (ss *shardedSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (e *zoekt.Explanation, err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.Explain", "")
+	tr.LazyLog(q, true)
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	proc, err := ss.sched.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Release()
+
+	shards := ss.getLoaded().shards
+	selected, rewritten := selectRepoSet(shards, withRepoFilter(q, opts))
+	isSelected := make(map[*rankedShard]bool, len(selected))
+	for _, s := range selected {
+		isSelected[s] = true
+	}
+
+	// Shards are explained in parallel like they are searched, but reported
+	// in the order of shards.
+	results := make([][]zoekt.ShardExplanation, len(shards))
+	errs := make([]error, len(shards))
+	feeder := make(chan int, len(shards))
+	for i := range shards {
+		feeder <- i
+	}
+	close(feeder)
+
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(shards)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range feeder {
+				if ctx.Err() != nil {
+					errs[i] = ctx.Err()
+					continue
+				}
+				results[i], errs[i] = explainShard(ctx, shards[i], isSelected[shards[i]], rewritten, opts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	e = &zoekt.Explanation{Query: rewritten.String()}
+	for i, s := range shards {
+		if errs[i] != nil {
+			if errs[i] == ctx.Err() {
+				return nil, errs[i]
+			}
+			return nil, fmt.Errorf("%s: %w", s, errs[i])
+		}
+		e.Shards = append(e.Shards, results[i]...)
+	}
+
+	return e, nil
+}
+
+// FetchDocument looks the document up in the loaded shards which hold its
+// repository, highest ranked first.
+func (ss *shardedSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (doc *zoekt.Document, err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.FetchDocument", "")
+	tr.LazyPrintf("repo: %s, file: %s, branch: %s", req.Repository, req.FileName, req.Branch)
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	proc, err := ss.sched.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Release()
+
+	for _, s := range ss.getLoaded().shards {
+		if s.repos != nil && !slices.ContainsFunc(s.repos, func(r *zoekt.Repository) bool { return r.Name == req.Repository }) {
+			continue
+		}
+		doc, err := zoekt.FetchDocument(ctx, s.Searcher, req)
+		if errors.Is(err, zoekt.ErrDocumentNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s, err)
+		}
+		return doc, nil
+	}
+	return nil, zoekt.ErrDocumentNotFound
+}
+
+// explainShard explains how s evaluates q. Shards which are not selected are
+// reported as skipped.
+func explainShard(ctx context.Context, s *rankedShard, selected bool, q query.Q, opts *zoekt.SearchOptions) ([]zoekt.ShardExplanation, error) {
+	if !selected {
+		var repos []string
+		for _, r := range s.repos {
+			// 🚨 SECURITY: Only describe shards of repositories the tenant
+			// can access and the repository filter allows.
+			if tenant.HasAccess(ctx, r.TenantID) && (opts.RepoFilter == nil || opts.RepoFilter.Contains(r.ID)) {
+				repos = append(repos, r.Name)
+			}
+		}
+		if len(repos) == 0 && (tenant.EnforceTenant() || opts.RepoFilter != nil) {
+			return nil, nil
+		}
+		return []zoekt.ShardExplanation{{
+			Shard:        s.String(),
+			Repositories: repos,
+			Query:        (&query.Const{Value: false}).String(),
+			Skipped:      true,
+			SkipReason:   "the shard contains none of the repositories the query is restricted to",
+		}}, nil
+	}
+
+	se, err := explainOneShard(ctx, s.Searcher, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	return se.Shards, nil
+}
+
+func explainOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.SearchOptions) (e *zoekt.Explanation, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] crashed shard: %s: %#v, %s", s, r, debug.Stack())
+			e = &zoekt.Explanation{Shards: []zoekt.ShardExplanation{{
+				Shard:      s.String(),
+				Skipped:    true,
+				SkipReason: fmt.Sprintf("the shard crashed: %v", r),
+			}}}
+			err = nil
+		}
+	}()
+
+	return zoekt.Explain(ctx, s, q, opts)
+}
+
+// batchSearch is the batch equivalent of streamSearch. The same rules about
+// calling done and copyFiles apply.
+func batchSearch(ctx context.Context, proc *process, qs []query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, prio *priorities) (done func(), results []*zoekt.SearchResult, err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.batchSearch", "")
+	defer func() {
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	type job struct {
+		idx int // index into qs
+		q   query.Q
+	}
+
+	// Select the shards for every query. A shard only appears once in work,
+	// but carries the jobs of every query which selected it.
+	var (
+		work []*rankedShard
+		jobs = map[*rankedShard][]job{}
+	)
+	for i, q := range qs {
+		q = withRepoFilter(q, opts)
+		selected, rewritten := selectRepoSet(shards, q)
+		tr.LazyPrintf("selectRepoSet q[%d] shards=%d->%d q=%s->%s", i, len(shards), len(selected), q, rewritten)
+		if err := checkCost(ctx, selected, rewritten, opts); err != nil {
+			return func() {}, nil, fmt.Errorf("query %d: %w", i, err)
+		}
+		for _, s := range selected {
+			jobs[s] = append(jobs[s], job{idx: i, q: rewritten})
+		}
+	}
+	for _, s := range shards {
+		if len(jobs[s]) > 0 {
+			work = append(work, s)
+		}
+	}
+
+	var (
+		mu         sync.Mutex
+		senders    = make([]*collectSender, len(qs))
+		matchCount = make([]int, len(qs))
+	)
+	for i := range senders {
+		senders[i] = newCollectSender(opts)
+	}
+
+	if len(work) > 0 {
+		var cancel context.CancelFunc
+		if opts.MaxWallTime == 0 {
+			ctx, cancel = context.WithCancel(ctx)
+		} else {
+			ctx, cancel = context.WithTimeout(ctx, opts.MaxWallTime)
+		}
+		defer cancel()
+
+		workers := runtime.GOMAXPROCS(0)
+		if workers > len(work) {
+			workers = len(work)
+		}
+
+		var (
+			search = make(chan *rankedShard, workers)
+			wg     sync.WaitGroup
+		)
+
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer wg.Done()
+				for s := range search {
+					for _, j := range jobs[s] {
+						mu.Lock()
+						full := err != nil || (opts.TotalMaxMatchCount > 0 && matchCount[j.idx] > opts.TotalMaxMatchCount)
+						mu.Unlock()
+						if full {
+							continue
+						}
+
+						sr, searchErr := searchOneShard(ctx, s, j.q, opts)
+
+						mu.Lock()
+						if searchErr != nil {
+							if err == nil {
+								err = searchErr
+							}
+						} else {
+							observeMetrics(sr)
+							matchCount[j.idx] += sr.Stats.MatchCount
+							prio.apply(sr)
+							sr.Priority = s.priority.Load()
+							senders[j.idx].Send(sr)
+						}
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+
+		for _, s := range work {
+			// Like in streamSearch, yield our timeslice between shards so other
+			// searches can make progress.
+			_ = proc.Yield(ctx)
+
+			mu.Lock()
+			stop := err != nil
+			mu.Unlock()
+			if stop {
+				break
+			}
+
+			search <- s
+		}
+		close(search)
+		wg.Wait()
+	}
+
+	done = func() { runtime.KeepAlive(shards) }
+	if err != nil {
+		return done, nil, err
+	}
+
+	results = make([]*zoekt.SearchResult, len(qs))
+	for i, c := range senders {
+		sr, ok := c.Done()
+		if !ok {
+			sr = &zoekt.SearchResult{
+				RepoURLs:      map[string]string{},
+				LineFragments: map[string]string{},
+			}
+		}
+		results[i] = sr
+	}
+	return done, results, nil
+}
+
 // streamSearch is an internal helper since both Search and StreamSearch are
 // largely similar.
 //
@@ -651,7 +1158,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 // collector can't see. Calling done informs the garbage collector it is free
 // to collect those shards. The caller must call copyFiles on any
 // SearchResults it returns/streams out before calling done.
-func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, sender zoekt.Sender) (done func(), err error) {
+func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, prio *priorities, sender zoekt.Sender) (done func(), err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.streamSearch", "")
 	overallStart := time.Now()
 	metricSearchRunning.Inc()
@@ -669,6 +1176,7 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 
 	// Select the subset of shards that we will search over for the given query.
 	{
+		q = withRepoFilter(q, opts)
 		beforeLen := len(shards)
 		beforeQ := q
 		shards, q = selectRepoSet(shards, q)
@@ -679,6 +1187,10 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		return func() {}, nil
 	}
 
+	if err := checkCost(ctx, shards, q, opts); err != nil {
+		return func() {}, err
+	}
+
 	var cancel context.CancelFunc
 	if opts.MaxWallTime == 0 {
 		ctx, cancel = context.WithCancel(ctx)
@@ -695,12 +1207,25 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		workers = len(shards)
 	}
 
+	// With adaptive limits we search each shard with its own share of the
+	// total match budget.
+	var shardOpts map[*rankedShard]*zoekt.SearchOptions
+	if opts.AdaptiveShardMaxMatchCount && opts.TotalMaxMatchCount > 0 {
+		shardOpts = adaptiveShardOpts(q, opts, shards)
+		tr.LazyPrintf("adaptive ShardMaxMatchCount: %d shards", len(shardOpts))
+	}
+
 	type result struct {
-		priority float64
+		shard *rankedShard
 		*zoekt.SearchResult
 		err error
 	}
 
+	// dispatched is the priority of each shard when it was dispatched. It
+	// can change while the shard is searched, but has to match the one in
+	// pending. Only accessed by this goroutine.
+	dispatched := make(map[*rankedShard]float64, workers)
+
 	var (
 		// buffered channels to continue searching when sending back results
 		// takes a while / blocks. The maximum pending result set is workers * 2.
@@ -720,8 +1245,17 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		go func() {
 			defer wg.Done()
 			for s := range search {
-				sr, err := searchOneShard(ctx, s, q, opts)
-				r := &result{priority: s.priority, SearchResult: sr, err: err}
+				o := opts
+				if so, ok := shardOpts[s]; ok {
+					o = so
+				}
+				start := time.Now()
+				sr, err := searchOneShard(ctx, s, q, o)
+				if err == nil && sr != nil {
+					shardHot.observe(s, sr, time.Since(start))
+					prio.apply(sr)
+				}
+				r := &result{shard: s, SearchResult: sr, err: err}
 				results <- r
 			}
 		}()
@@ -754,6 +1288,15 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 	// tracked so we can stop when we hit TotalMaxMatchCount
 	var totalMatchCount int
 
+	// progress is reported every opts.ProgressInterval, see sendProgress.
+	progress := zoekt.SearchProgress{ShardsTotal: len(shards)}
+	var progressTick <-chan time.Time
+	if opts.ProgressInterval > 0 {
+		ticker := time.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+		progressTick = ticker.C
+	}
+
 search:
 	for {
 		// At the top of each iteration, have the proc associated with this search yield its won "timeslice"
@@ -762,7 +1305,8 @@ search:
 
 		select {
 		case work <- next: // is there a worker available to search the next shard?
-			pending.append(next.priority)
+			dispatched[next] = next.priority.Load()
+			pending.append(dispatched[next])
 
 			shard++
 			if shard == len(shards) {
@@ -776,7 +1320,10 @@ search:
 			}
 
 			// delete this result's priority from pending before computing the new max pending priority
-			pending.remove(r.priority)
+			priority := dispatched[r.shard]
+			delete(dispatched, r.shard)
+			pending.remove(priority)
+			progress.ShardsScanned++
 
 			if r.err != nil {
 				// Set final error and stop searching new shards, but consume any pending
@@ -793,18 +1340,90 @@ search:
 				stop()
 			}
 
+			progress.MatchCount = totalMatchCount
+			progress.BytesLoaded += r.SearchResult.Stats.ContentBytesLoaded + r.SearchResult.Stats.IndexBytesLoaded
+
 			observeMetrics(r.SearchResult)
 
-			r.Priority = r.priority
+			r.Priority = priority
 			r.MaxPendingPriority = pending.max()
 
 			sendByRepository(r.SearchResult, opts, sender) // send the result back to the client
+		case <-progressTick:
+			sendProgress(sender, progress, pending.max())
 		}
 	}
 
 	return func() { runtime.KeepAlive(shards) }, err
 }
 
+// sendProgress sends a progress event reporting p. The event carries no
+// results, so its priority is the maximum pending priority.
+func sendProgress(sender zoekt.Sender, p zoekt.SearchProgress, maxPendingPriority float64) {
+	sender.Send(&zoekt.SearchResult{
+		Progress: zoekt.Progress{
+			Priority:           maxPendingPriority,
+			MaxPendingPriority: maxPendingPriority,
+		},
+		SearchProgress: &p,
+	})
+}
+
+// adaptiveShardOpts returns search options for each shard whose
+// ShardMaxMatchCount is the shard's share of opts.TotalMaxMatchCount, see
+// allocateMatchBudget. The shares are based on index.MatchEstimator, which
+// only looks up the frequencies of the query's ngrams. It returns nil if a
+// shard can't estimate its matches, in which case every shard is searched
+// with opts.
+func adaptiveShardOpts(q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard) map[*rankedShard]*zoekt.SearchOptions {
+	estimates := make([]int, len(shards))
+	for i, s := range shards {
+		e, ok := s.Searcher.(index.MatchEstimator)
+		if !ok {
+			return nil
+		}
+		estimates[i] = e.EstimateMatches(q)
+	}
+
+	limits := allocateMatchBudget(estimates, opts.TotalMaxMatchCount, opts.ShardMaxMatchCount)
+	if limits == nil {
+		return nil
+	}
+
+	shardOpts := make(map[*rankedShard]*zoekt.SearchOptions, len(shards))
+	for i, s := range shards {
+		o := *opts
+		o.ShardMaxMatchCount = limits[i]
+		shardOpts[s] = &o
+	}
+	return shardOpts
+}
+
+// allocateMatchBudget divides total among the shards proportionally to their
+// estimated number of matches. Each limit is rounded up, so
+// every shard with candidates may contribute a match, and is capped at
+// shardMax if that is set. Shards without candidates get a limit of 1, since
+// 0 means unlimited. It returns nil if no shard has candidates.
+func allocateMatchBudget(estimates []int, total, shardMax int) []int {
+	sum := 0
+	for _, e := range estimates {
+		sum += e
+	}
+	if sum == 0 {
+		return nil
+	}
+
+	limits := make([]int, len(estimates))
+	for i, e := range estimates {
+		limit := int((int64(total)*int64(e) + int64(sum) - 1) / int64(sum))
+		if shardMax > 0 && limit > shardMax {
+			limit = shardMax
+		}
+		limits[i] = max(limit, 1)
+	}
+	return limits
+}
+
 // sendByRepository splits a zoekt.SearchResult by repository and calls
 // sender.Send for each batch. Ranking in Sourcegraph expects zoekt.SearchResult
 // to contain results with the same zoekt.SearchResult.priority only.
@@ -910,24 +1529,45 @@ func searchOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoek
 }
 
 type shardListResult struct {
-	rl  *zoekt.RepoList
-	err error
+	shard *rankedShard
+	rl    *zoekt.RepoList
+	err   error
 }
 
-func listOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.ListOptions, sink chan shardListResult) {
+func listOneShard(ctx context.Context, s *rankedShard, q query.Q, opts *zoekt.ListOptions, sink chan shardListResult) {
 	metricListShardRunning.Inc()
 	defer func() {
 		metricListShardRunning.Dec()
 		if r := recover(); r != nil {
 			log.Printf("[ERROR] crashed shard: %s: %s, %s", s.String(), r, debug.Stack())
 			sink <- shardListResult{
-				&zoekt.RepoList{Crashes: 1}, nil,
+				s, &zoekt.RepoList{Crashes: 1}, nil,
 			}
 		}
 	}()
 
 	ms, err := s.List(ctx, q, opts)
-	sink <- shardListResult{ms, err}
+	sink <- shardListResult{s, ms, err}
+}
+
+// listShards lists all shards concurrently. The returned channel receives
+// one result per shard.
+func listShards(ctx context.Context, shards []*rankedShard, q query.Q, opts *zoekt.ListOptions) <-chan shardListResult {
+	all := make(chan shardListResult, len(shards))
+	feeder := make(chan *rankedShard, len(shards))
+	for _, s := range shards {
+		feeder <- s
+	}
+	close(feeder)
+
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		go func() {
+			for s := range feeder {
+				listOneShard(ctx, s, q, opts, all)
+			}
+		}()
+	}
+	return all
 }
 
 func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (rl *zoekt.RepoList, err error) {
@@ -988,21 +1628,7 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 		return &agg, nil
 	}
 
-	shardCount := len(shards)
-	all := make(chan shardListResult, shardCount)
-	feeder := make(chan zoekt.Searcher, len(shards))
-	for _, s := range shards {
-		feeder <- s
-	}
-	close(feeder)
-
-	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
-		go func() {
-			for s := range feeder {
-				listOneShard(ctx, s, q, opts, all)
-			}
-		}()
-	}
+	all := listShards(ctx, shards, q, opts)
 
 	uniq := map[string]*zoekt.RepoListEntry{}
 
@@ -1019,6 +1645,7 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 			prev, ok := uniq[r.Repository.Name]
 			if !ok {
 				cp := *r // We need to copy because we mutate r.Stats when merging duplicates
+				cp.Stats.Languages = maps.Clone(r.Stats.Languages)
 				uniq[r.Repository.Name] = &cp
 			} else {
 				prev.Stats.Add(&r.Stats)
@@ -1046,18 +1673,192 @@ func (ss *shardedSearcher) List(ctx context.Context, q query.Q, opts *zoekt.List
 	agg.Stats.Repos = len(uniq) + len(agg.ReposMap)
 
 	if isAll && len(agg.Repos) > 0 {
-		reportListAllMetrics(agg.Repos)
+		var stats zoekt.RepoStats
+		for _, r := range agg.Repos {
+			stats.Add(&r.Stats)
+		}
+		reportListAllMetrics(&stats)
 	}
 
 	return &agg, nil
 }
 
-func reportListAllMetrics(repos []*zoekt.RepoListEntry) {
-	var stats zoekt.RepoStats
-	for _, r := range repos {
-		stats.Add(&r.Stats)
+// repoStatsZero returns true if s is empty. RepoStats is not comparable
+// because of Languages, so we compare the other fields in a struct which is.
+func repoStatsZero(s *zoekt.RepoStats) bool {
+	type counts struct {
+		repos, shards, documents                                    int
+		indexBytes, contentBytes                                    int64
+		newLinesCount, defaultBranchNewLines, otherBranchesNewLines uint64
+	}
+	return len(s.Languages) == 0 && counts{
+		s.Repos, s.Shards, s.Documents,
+		s.IndexBytes, s.ContentBytes,
+		s.NewLinesCount, s.DefaultBranchNewLinesCount, s.OtherBranchesNewLinesCount,
+	} == counts{}
+}
+
+// listBatchSize is the maximum number of repositories StreamList sends in one
+// batch.
+const listBatchSize = 1000
+
+// StreamList is like List, but sends the repositories in batches of at most
+// listBatchSize. A repository is added to a batch once all of its shards are
+// listed, so the batches contain each repository at most once and its Stats
+// cover all of its shards. The batches are sent after the scheduler slot is
+// released, so a slow receiver doesn't hold up other requests.
+func (ss *shardedSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) (err error) {
+	tr, ctx := trace.New(ctx, "shardedSearcher.StreamList", "")
+	metricListRunning.Inc()
+	var (
+		batches, repos int
+		total          zoekt.RepoStats
+	)
+	defer func() {
+		metricListRunning.Dec()
+		tr.LazyPrintf("batches=%d repos=%d", batches, repos)
+		if err != nil {
+			tr.LazyPrintf("error: %v", err)
+			tr.SetError(err)
+		}
+		tr.Finish()
+	}()
+
+	q = query.Simplify(q)
+	isAll := false
+	if c, ok := q.(*query.Const); ok {
+		isAll = c.Value
+	}
+
+	proc, err := ss.sched.Acquire(ctx)
+	if err != nil {
+		return err
 	}
+	release := sync.OnceFunc(proc.Release)
+	defer release()
+	tr.LazyPrintf("acquired process")
+
+	loaded := ss.getLoaded()
+	shards := loaded.shards
 
+	var (
+		batch   zoekt.RepoList
+		pending []*zoekt.RepoList
+	)
+	if !loaded.ready {
+		// We may have missed results due to not being fully loaded.
+		batch.Crashes++
+	}
+
+	// flush queues batch if it carries anything, even if it is not full.
+	flush := func() {
+		if len(batch.Repos) == 0 && len(batch.ReposMap) == 0 && batch.Crashes == 0 && repoStatsZero(&batch.Stats) {
+			return
+		}
+		batch.Stats.Repos = len(batch.Repos) + len(batch.ReposMap)
+		repos += batch.Stats.Repos
+		for _, r := range batch.Repos {
+			total.Add(&r.Stats)
+		}
+		b := batch
+		pending = append(pending, &b)
+		batch = zoekt.RepoList{}
+	}
+
+	{
+		beforeLen := len(shards)
+		beforeQ := q
+		shards, q = selectRepoSet(shards, q)
+		tr.LazyPrintf("selectRepoSet shards=%d->%d q=%s->%s", beforeLen, len(shards), beforeQ, q)
+	}
+
+	// remaining counts the shards of each repository which are not listed
+	// yet. Repositories of a shard whose metadata we couldn't read are only
+	// sent after all shards are listed.
+	remaining := map[string]int{}
+	for _, s := range shards {
+		for _, r := range s.repos {
+			remaining[r.Name]++
+		}
+	}
+
+	all := listShards(ctx, shards, q, opts)
+
+	uniq := map[string]*zoekt.RepoListEntry{}
+	seenIDs := map[uint32]struct{}{}
+
+	for range shards {
+		r := <-all
+		if r.err != nil {
+			return r.err
+		}
+
+		batch.Crashes += r.rl.Crashes
+		batch.Stats.Add(&r.rl.Stats)
+
+		for _, r := range r.rl.Repos {
+			prev, ok := uniq[r.Repository.Name]
+			if !ok {
+				cp := *r // We need to copy because we mutate r.Stats when merging duplicates
+				cp.Stats.Languages = maps.Clone(r.Stats.Languages)
+				uniq[r.Repository.Name] = &cp
+			} else {
+				prev.Stats.Add(&r.Stats)
+			}
+		}
+
+		for id, r := range r.rl.ReposMap {
+			if _, ok := seenIDs[id]; ok {
+				continue
+			}
+			seenIDs[id] = struct{}{}
+			if batch.ReposMap == nil {
+				batch.ReposMap = zoekt.ReposMap{}
+			}
+			batch.ReposMap[id] = r
+		}
+
+		for _, repo := range r.shard.repos {
+			if remaining[repo.Name]--; remaining[repo.Name] > 0 {
+				continue
+			}
+			delete(remaining, repo.Name)
+			if e, ok := uniq[repo.Name]; ok {
+				batch.Repos = append(batch.Repos, e)
+				delete(uniq, repo.Name)
+			}
+		}
+
+		if len(batch.Repos)+len(batch.ReposMap) >= listBatchSize {
+			flush()
+		}
+	}
+
+	for _, e := range uniq {
+		batch.Repos = append(batch.Repos, e)
+		if len(batch.Repos) >= listBatchSize {
+			flush()
+		}
+	}
+	flush()
+	release()
+
+	if isAll && repos > 0 {
+		reportListAllMetrics(&total)
+	}
+
+	for _, b := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sender.Send(b)
+		batches++
+	}
+
+	return nil
+}
+
+func reportListAllMetrics(stats *zoekt.RepoStats) {
 	metricListAllRepos.Set(float64(stats.Repos))
 	metricListAllIndexBytes.Set(float64(stats.IndexBytes))
 	metricListAllContentBytes.Set(float64(stats.ContentBytes))
@@ -1073,12 +1874,19 @@ func (s *shardedSearcher) getLoaded() loaded {
 	// next commit will store the true value of this, for now we keep the
 	// backwards compatible behaviour.
 	ready := s.ready.Load()
+	// version is loaded before ranked, while replace stores it after ranked.
+	// This way we never pair a new version with an old set of shards, which
+	// would put stale results in the result cache.
+	version := s.version.Load()
 	// ranked is loaded after ready to avoid a race were ready is true but
 	// ranked is still not the final set of shards.
 	ranked, _ := s.ranked.Load().([]*rankedShard)
+	prio, _ := s.prio.Load().(*priorities)
 	return loaded{
-		shards: ranked,
-		ready:  ready,
+		shards:     ranked,
+		ready:      ready,
+		version:    version,
+		priorities: prio,
 	}
 }
 
@@ -1093,28 +1901,22 @@ func mkRankedShard(s zoekt.Searcher) *rankedShard {
 		return &rankedShard{Searcher: s}
 	}
 
-	var (
-		maxPriority float64
-		repos       = make([]*zoekt.Repository, 0, len(result.Repos))
-	)
+	repos := make([]*zoekt.Repository, 0, len(result.Repos))
 	for i := range result.Repos {
-		repo := &result.Repos[i].Repository
-		repos = append(repos, repo)
-		if repo.RawConfig != nil {
-			priority, _ := strconv.ParseFloat(repo.RawConfig["priority"], 64)
-			if priority > maxPriority {
-				maxPriority = priority
-			}
-		}
+		repos = append(repos, &result.Repos[i].Repository)
 	}
 
 	return &rankedShard{
 		Searcher: s,
 		repos:    repos,
-		priority: maxPriority,
 	}
 }
 
+// Version returns the version of the set of loaded shards, see Version.
+func (s *shardedSearcher) Version() uint64 {
+	return s.version.Load()
+}
+
 // markReady should be called once all shards have been passed into replace on
 // startup. Once s is marked as ready it stops reporting a Crash in the
 // response Stats.
@@ -1134,15 +1936,19 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	prio, _ := s.prio.Load().(*priorities)
 	for key, shard := range shards {
 		var r *rankedShard
 		if shard != nil {
 			r = mkRankedShard(shard)
+			r.name = filepath.Base(key)
+			r.priority.Store(prio.shardPriority(r.repos))
 		}
 
 		old := s.shards[key]
 		if shard == nil {
 			delete(s.shards, key)
+			shardHot.forget(filepath.Base(key))
 		} else {
 			s.shards[key] = r
 		}
@@ -1175,13 +1981,32 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 		}
 	}
 
+	s.rank()
+}
+
+// setPriorities makes p the priorities of the priorities file and re-ranks
+// the shards.
+func (s *shardedSearcher) setPriorities(p *priorities) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prio.Store(p)
+	for _, r := range s.shards {
+		r.priority.Store(p.shardPriority(r.repos))
+	}
+	s.rank()
+}
+
+// rank sorts the shards by decreasing priority and publishes them. It must
+// be called with s.mu held.
+func (s *shardedSearcher) rank() {
 	ranked := make([]*rankedShard, 0, len(s.shards))
 	for _, r := range s.shards {
 		ranked = append(ranked, r)
 	}
 
 	sort.Slice(ranked, func(i, j int) bool {
-		priorityDiff := ranked[i].priority - ranked[j].priority
+		priorityDiff := ranked[i].priority.Load() - ranked[j].priority.Load()
 		if priorityDiff != 0 {
 			return priorityDiff > 0
 		}
@@ -1194,11 +2019,17 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 	})
 
 	s.ranked.Store(ranked)
+	s.version.Inc()
+	s.cache.purge()
 
 	metricShardsLoaded.Set(float64(len(ranked)))
 }
 
 func loadShard(fn string) (zoekt.Searcher, error) {
+	if strings.HasSuffix(fn, index.RepoStateSuffix) {
+		return index.NewRepoStateSearcher(fn)
+	}
+
 	f, err := os.Open(fn)
 	if err != nil {
 		return nil, err
@@ -1208,6 +2039,10 @@ func loadShard(fn string) (zoekt.Searcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	iFile, err = checkOldShard(fn, iFile, oldShards)
+	if err != nil {
+		return nil, err
+	}
 	s, err := index.NewSearcher(iFile)
 	if err != nil {
 		iFile.Close()
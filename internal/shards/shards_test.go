@@ -17,17 +17,21 @@ package shards
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"os"
 	"reflect"
+	"regexp/syntax"
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"testing/quick"
 	"time"
@@ -296,6 +300,305 @@ func TestShardedSearcher_DocumentRanking(t *testing.T) {
 	}
 }
 
+func TestShardedSearcher_BatchSearch(t *testing.T) {
+	ss := newShardedSearcher(1)
+
+	var nextShardNum int
+	addShard := func(repo string, docs ...index.Document) {
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		b := testShardBuilder(t, r, docs...)
+		ss.replace(map[string]zoekt.Searcher{
+			fmt.Sprintf("key-%d", nextShardNum): searcherForTest(t, b),
+		})
+		nextShardNum++
+	}
+
+	addShard("repo1", index.Document{Name: "f1", Content: []byte("apple banana")})
+	addShard("repo2", index.Document{Name: "f2", Content: []byte("banana cherry")})
+	addShard("repo3", index.Document{Name: "f3", Content: []byte("cherry apple")})
+
+	qs := []query.Q{
+		&query.Substring{Pattern: "apple"},
+		&query.Substring{Pattern: "cherry"},
+		query.NewAnd(&query.Substring{Pattern: "banana"}, query.NewRepoSet("repo2")),
+		&query.Substring{Pattern: "durian"},
+	}
+
+	results, err := ss.BatchSearch(context.Background(), qs, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(qs) {
+		t.Fatalf("got %d results, want %d", len(results), len(qs))
+	}
+
+	// Every query must return the same files as searching it on its own.
+	for i, q := range qs {
+		sr, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, want []string
+		for _, f := range results[i].Files {
+			got = append(got, f.FileName)
+		}
+		for _, f := range sr.Files {
+			want = append(want, f.FileName)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("query %s: got %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestShardedSearcher_AdaptiveShardMaxMatchCount(t *testing.T) {
+	ss := newShardedSearcher(1)
+
+	addShard := func(repo string, numDocs int) {
+		var docs []index.Document
+		for i := 0; i < numDocs; i++ {
+			docs = append(docs, index.Document{Name: fmt.Sprintf("f%d", i), Content: []byte("needle")})
+		}
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		ss.replace(map[string]zoekt.Searcher{
+			repo: searcherForTest(t, testShardBuilder(t, r, docs...)),
+		})
+	}
+
+	addShard("big", 20)
+	addShard("small1", 2)
+	addShard("small2", 2)
+
+	countByRepo := func(opts *zoekt.SearchOptions) map[string]int {
+		t.Helper()
+		res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := map[string]int{}
+		for _, f := range res.Files {
+			counts[f.Repository]++
+		}
+		return counts
+	}
+
+	got := countByRepo(&zoekt.SearchOptions{ShardMaxMatchCount: 100, TotalMaxMatchCount: 6})
+	if got["big"] != 20 {
+		t.Errorf("uniform: got %d matches in big, want 20", got["big"])
+	}
+
+	// The budget of 6 is split 20:2:2, rounded up.
+	got = countByRepo(&zoekt.SearchOptions{ShardMaxMatchCount: 100, TotalMaxMatchCount: 6, AdaptiveShardMaxMatchCount: true})
+	want := map[string]int{"big": 5, "small1": 1, "small2": 1}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("adaptive: mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestShardedSearcher_AdaptiveShardMaxMatchCount_Sparse(t *testing.T) {
+	ss := newShardedSearcher(1)
+
+	// "large" has many documents, but as few matches as "small". The budget
+	// follows the matches, not the size of the shards.
+	addShard := func(repo string, numDocs, numMatches int) {
+		var docs []index.Document
+		for i := 0; i < numDocs; i++ {
+			content := "haystack"
+			if i < numMatches {
+				content = "needle"
+			}
+			docs = append(docs, index.Document{Name: fmt.Sprintf("f%d", i), Content: []byte(content)})
+		}
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		ss.replace(map[string]zoekt.Searcher{
+			repo: searcherForTest(t, testShardBuilder(t, r, docs...)),
+		})
+	}
+	addShard("large", 100, 4)
+	addShard("small", 4, 4)
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"},
+		&zoekt.SearchOptions{ShardMaxMatchCount: 100, TotalMaxMatchCount: 4, AdaptiveShardMaxMatchCount: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, f := range res.Files {
+		got[f.Repository]++
+	}
+	if want := map[string]int{"large": 2, "small": 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAllocateMatchBudget(t *testing.T) {
+	cases := []struct {
+		name      string
+		estimates []int
+		total     int
+		shardMax  int
+		want      []int
+	}{{
+		name:      "proportional",
+		estimates: []int{60, 30, 10},
+		total:     100,
+		want:      []int{60, 30, 10},
+	}, {
+		name:      "rounded up",
+		estimates: []int{20, 2, 2},
+		total:     6,
+		want:      []int{5, 1, 1},
+	}, {
+		name:      "capped",
+		estimates: []int{90, 10},
+		total:     100,
+		shardMax:  50,
+		want:      []int{50, 10},
+	}, {
+		name:      "no candidates",
+		estimates: []int{10, 0},
+		total:     100,
+		want:      []int{100, 1},
+	}, {
+		name:      "empty",
+		estimates: []int{0, 0},
+		total:     100,
+		want:      nil,
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := allocateMatchBudget(tc.estimates, tc.total, tc.shardMax)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestShardedSearcher_Explain(t *testing.T) {
+	ss := newShardedSearcher(1)
+	for _, repo := range []string{"repo1", "repo2"} {
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		ss.replace(map[string]zoekt.Searcher{
+			repo: searcherForTest(t, testShardBuilder(t, r, index.Document{Name: "f", Content: []byte("needle")})),
+		})
+	}
+
+	q := query.NewAnd(&query.Substring{Pattern: "needle", Content: true}, query.NewRepoSet("repo2"))
+	e, err := ss.Explain(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Query != `content_substr:"needle"` {
+		t.Errorf("got query %s, want the repo set applied by shard selection", e.Query)
+	}
+
+	got := map[string]zoekt.ShardExplanation{}
+	for _, se := range e.Shards {
+		got[strings.Join(se.Repositories, ",")] = se
+	}
+	if se := got["repo1"]; !se.Skipped || !strings.Contains(se.SkipReason, "none of the repositories") {
+		t.Errorf("got %+v for repo1, want it skipped by the repo set", se)
+	}
+	if se := got["repo2"]; se.Skipped || len(se.Atoms) != 1 || se.Atoms[0].Method != "ngram" {
+		t.Errorf("got %+v for repo2, want it searched with an ngram atom", se)
+	}
+}
+
+func TestShardedSearcher_MaxEstimatedCost(t *testing.T) {
+	ss := newShardedSearcher(1)
+	for _, repo := range []string{"repo1", "repo2"} {
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		ss.replace(map[string]zoekt.Searcher{
+			repo: searcherForTest(t, testShardBuilder(t, r, index.Document{Name: "f", Content: []byte("needle in a haystack")})),
+		})
+	}
+
+	regexpQ := func(s string) query.Q {
+		re, err := syntax.Parse(s, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &query.Regexp{Regexp: re, Content: true}
+	}
+
+	e, err := ss.Explain(context.Background(), regexpQ("."), &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardCost := e.Shards[0].Cost
+
+	// A scan of both shards with a repetition costs 4 times the content of
+	// one shard.
+	q := regexpQ("n.*e")
+	_, err = ss.Search(context.Background(), q, &zoekt.SearchOptions{MaxEstimatedCost: 3 * shardCost})
+	var tooExpensive *zoekt.QueryTooExpensiveError
+	if !errors.As(err, &tooExpensive) || tooExpensive.MaxCost != 3*shardCost {
+		t.Fatalf("got error %v, want a QueryTooExpensiveError", err)
+	}
+
+	sr, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{MaxEstimatedCost: 4 * shardCost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 2 {
+		t.Errorf("got %d files, want 2", len(sr.Files))
+	}
+
+	// Restricting the query to one repository halves its cost.
+	_, err = ss.Search(context.Background(), query.NewAnd(q, query.NewRepoSet("repo1")), &zoekt.SearchOptions{MaxEstimatedCost: 3 * shardCost})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedSearcher_Suggestions(t *testing.T) {
+	ss := newShardedSearcher(1)
+	for _, repo := range []string{"repo1", "repo2"} {
+		r := &zoekt.Repository{ID: hash(repo), Name: repo}
+		ss.replace(map[string]zoekt.Searcher{
+			repo: searcherForTest(t, testShardBuilder(t, r, index.Document{Name: "f", Content: []byte("func " + repo + "Handler() {}")})),
+		})
+	}
+	// Suggestions are only computed for complete results.
+	ss.markReady()
+
+	q := &query.Substring{Pattern: "repo1Handlr", Content: true}
+	sr, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{MaxSuggestions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []zoekt.Suggestion{{Pattern: "repo1Handlr", Replacement: "repo1Handler"}}
+	if d := cmp.Diff(want, sr.Suggestions); d != "" {
+		t.Errorf("suggestions mismatch (-want +got):\n%s", d)
+	}
+
+	// Suggestions without results in the repositories of the filter are
+	// dropped.
+	opts := &zoekt.SearchOptions{MaxSuggestions: 1, RepoFilter: roaring.BitmapOf(hash("repo2"))}
+	sr, err = ss.Search(context.Background(), q, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Suggestions) != 0 {
+		t.Errorf("got suggestions %v, want none", sr.Suggestions)
+	}
+
+	// Searches with results have no suggestions.
+	sr, err = ss.Search(context.Background(), &query.Substring{Pattern: "Handler", Content: true}, &zoekt.SearchOptions{MaxSuggestions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 2 || len(sr.Suggestions) != 0 {
+		t.Errorf("got %d files and suggestions %v, want 2 files and none", len(sr.Files), sr.Suggestions)
+	}
+}
+
 func TestFilteringShardsByRepoSetOrBranchesReposOrRepoIDs(t *testing.T) {
 	ss := newShardedSearcher(1)
 
@@ -496,9 +799,12 @@ func TestShardedSearcher_List(t *testing.T) {
 		NewLinesCount:              1,
 		DefaultBranchNewLinesCount: 1,
 		OtherBranchesNewLinesCount: 1,
+		Languages:                  map[string]zoekt.LanguageStats{"Go": {Files: 1, Bytes: 7}},
 	}
 
 	aggStats := stats
+	// Add changes Languages in place, so aggStats needs its own.
+	aggStats.Languages = maps.Clone(stats.Languages)
 	aggStats.Add(&aggStats) // since both repos have the exact same stats, this works
 	aggStats.Repos = 2      // Add doesn't populate Repos, this is done in Shards	based on the response sizes.
 
@@ -609,6 +915,233 @@ func TestShardedSearcher_List(t *testing.T) {
 	}
 }
 
+func TestShardedSearcher_RepoFilter(t *testing.T) {
+	ss := newShardedSearcher(4)
+	shards := map[string]zoekt.Searcher{}
+	for i, name := range []string{"repo-a", "repo-b", "repo-c"} {
+		shards[name] = searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: uint32(i + 1), Name: name},
+			index.Document{Name: "f", Content: []byte("needle")}))
+	}
+	ss.replace(shards)
+	ss.markReady()
+
+	q := &query.Substring{Pattern: "needle", Content: true}
+	for _, tc := range []struct {
+		name      string
+		filter    *roaring.Bitmap
+		wantRepos []string
+	}{
+		{name: "no filter", wantRepos: []string{"repo-a", "repo-b", "repo-c"}},
+		{name: "filter", filter: roaring.BitmapOf(1, 3, 42), wantRepos: []string{"repo-a", "repo-c"}},
+		{name: "empty filter", filter: roaring.New()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &zoekt.SearchOptions{RepoFilter: tc.filter}
+
+			res, err := ss.Search(context.Background(), q, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var repos []string
+			for _, f := range res.Files {
+				repos = append(repos, f.Repository)
+			}
+			sort.Strings(repos)
+			if d := cmp.Diff(tc.wantRepos, repos); d != "" {
+				t.Errorf("Search mismatch (-want +got):\n%s", d)
+			}
+
+			// Shards without an allowed repository are not searched at all.
+			if res.Stats.ShardsScanned != len(tc.wantRepos) {
+				t.Errorf("got %d shards scanned, want %d", res.Stats.ShardsScanned, len(tc.wantRepos))
+			}
+
+			results, err := ss.BatchSearch(context.Background(), []query.Q{q, q}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, res := range results {
+				if len(res.Files) != len(tc.wantRepos) {
+					t.Errorf("BatchSearch: got %d files, want %d", len(res.Files), len(tc.wantRepos))
+				}
+			}
+		})
+	}
+}
+
+func TestShardedSearcher_StreamList(t *testing.T) {
+	repos := []*zoekt.Repository{
+		{ID: 1, Name: "repo-a", Branches: []zoekt.RepositoryBranch{{Name: "main"}}},
+		{ID: 2, Name: "repo-b", Branches: []zoekt.RepositoryBranch{{Name: "main"}}},
+		{ID: 3, Name: "other", Branches: []zoekt.RepositoryBranch{{Name: "main"}}},
+	}
+	doc := index.Document{
+		Name:     "foo.go",
+		Content:  []byte("bar\nbaz"),
+		Branches: []string{"main"},
+	}
+
+	ss := newShardedSearcher(4)
+	ss.replace(map[string]zoekt.Searcher{
+		"1": searcherForTest(t, testShardBuilder(t, repos[0], doc)),
+		"2": searcherForTest(t, testShardBuilder(t, repos[0], doc)),
+		"3": searcherForTest(t, testShardBuilder(t, repos[1], doc)),
+		"4": searcherForTest(t, testShardBuilder(t, repos[2], doc)),
+	})
+	ss.markReady()
+
+	for _, opts := range []*zoekt.ListOptions{
+		nil,
+		{Field: zoekt.RepoListFieldRepos},
+		{Field: zoekt.RepoListFieldReposMap},
+	} {
+		for _, q := range []query.Q{
+			&query.Const{Value: true},
+			&query.Repo{Regexp: regexp.MustCompile("repo")},
+		} {
+			want, err := ss.List(context.Background(), q, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Adding up the batches yields the result of List.
+			got := &zoekt.RepoList{ReposMap: zoekt.ReposMap{}}
+			err = ss.StreamList(context.Background(), q, opts, zoekt.ListSenderFunc(func(rl *zoekt.RepoList) {
+				got.Crashes += rl.Crashes
+				got.Stats.Add(&rl.Stats)
+				got.Stats.Repos += rl.Stats.Repos
+				got.Repos = append(got.Repos, rl.Repos...)
+				for id, r := range rl.ReposMap {
+					if _, ok := got.ReposMap[id]; ok {
+						t.Errorf("repository %d sent twice", id)
+					}
+					got.ReposMap[id] = r
+				}
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, rl := range []*zoekt.RepoList{want, got} {
+				sort.Slice(rl.Repos, func(i, j int) bool {
+					return rl.Repos[i].Repository.Name < rl.Repos[j].Repository.Name
+				})
+			}
+			if d := cmp.Diff(want, got, cmpopts.EquateEmpty(), cmpopts.IgnoreUnexported(zoekt.Repository{})); d != "" {
+				t.Errorf("%s %v: mismatch (-List +StreamList):\n%s", q, opts, d)
+			}
+		}
+	}
+}
+
+func TestShardedSearcher_DedupByChecksum(t *testing.T) {
+	ss := newShardedSearcher(4)
+	shards := map[string]zoekt.Searcher{}
+	for i, name := range []string{"repo", "fork-a", "fork-b"} {
+		content := []byte("needle\n")
+		if name == "fork-b" {
+			content = []byte("needle, changed\n")
+		}
+		shards[name] = searcherForTest(t, testShardBuilder(t, &zoekt.Repository{ID: uint32(i + 1), Name: name},
+			index.Document{Name: "f", Content: content}))
+	}
+	ss.replace(shards)
+	ss.markReady()
+
+	q := &query.Substring{Pattern: "needle", Content: true}
+	res, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{DedupByChecksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]string{}
+	for _, f := range res.Files {
+		got[f.Repository] = f.DuplicateRepositories
+	}
+	// repo and fork-a have the same score, so either may be kept.
+	want := map[string][]string{"repo": {"fork-a"}, "fork-b": nil}
+	if _, ok := got["fork-a"]; ok {
+		want = map[string][]string{"fork-a": {"repo"}, "fork-b": nil}
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+	if res.Stats.FileCount != 3 {
+		t.Errorf("got FileCount %d, want all 3 copies counted", res.Stats.FileCount)
+	}
+}
+
+// slowSearcher is a rankSearcher which takes delay to search.
+type slowSearcher struct {
+	rankSearcher
+	delay time.Duration
+}
+
+func (s *slowSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	time.Sleep(s.delay)
+	sr, err := s.rankSearcher.Search(ctx, q, opts)
+	if sr != nil {
+		sr.Stats.ContentBytesLoaded = 10
+	}
+	return sr, err
+}
+
+func TestShardedSearcher_Progress(t *testing.T) {
+	ss := newShardedSearcher(1)
+	n := 4 * runtime.GOMAXPROCS(0)
+	shards := map[string]zoekt.Searcher{}
+	for i := 0; i < n; i++ {
+		shards[fmt.Sprintf("shard%d", i)] = &slowSearcher{rankSearcher: rankSearcher{rank: uint16(i)}, delay: 5 * time.Millisecond}
+	}
+	ss.replace(shards)
+	ss.markReady()
+
+	q := &query.Substring{Pattern: "bla"}
+	opts := &zoekt.SearchOptions{ProgressInterval: time.Millisecond}
+
+	var progress []zoekt.SearchProgress
+	files := 0
+	err := ss.StreamSearch(context.Background(), q, opts, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		if sr.SearchProgress == nil {
+			files += len(sr.Files)
+			return
+		}
+		if len(sr.Files) > 0 || !sr.Stats.Zero() {
+			t.Errorf("got progress event with results: %+v", sr)
+		}
+		progress = append(progress, *sr.SearchProgress)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != n {
+		t.Errorf("got %d files, want %d", files, n)
+	}
+	if len(progress) == 0 {
+		t.Fatal("got no progress events")
+	}
+
+	var last zoekt.SearchProgress
+	for _, p := range progress {
+		if p.ShardsTotal != n || p.ShardsScanned < last.ShardsScanned || p.ShardsScanned > n {
+			t.Errorf("got progress %+v after %+v for %d shards", p, last, n)
+		}
+		if p.MatchCount != p.ShardsScanned || p.BytesLoaded != 10*int64(p.ShardsScanned) {
+			t.Errorf("got progress %+v, want a match and 10 bytes per scanned shard", p)
+		}
+		last = p
+	}
+
+	// Search aggregates the results, so it drops progress events.
+	res, err := ss.Search(context.Background(), q, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SearchProgress != nil || len(res.Files) != n {
+		t.Errorf("got progress %+v and %d files from Search, want no progress and %d files", res.SearchProgress, len(res.Files), n)
+	}
+}
+
 func testShardBuilder(t testing.TB, repo *zoekt.Repository, docs ...index.Document) *index.ShardBuilder {
 	b, err := index.NewShardBuilder(repo)
 	if err != nil {
@@ -1226,6 +1759,56 @@ func TestNewDirectorySearcher_empty(t *testing.T) {
 	})
 }
 
+func TestNewDirectorySearcher_repoState(t *testing.T) {
+	dir := t.TempDir()
+	opts := index.Options{
+		IndexDir:              dir,
+		RepositoryDescription: zoekt.Repository{ID: 1, Name: "empty"},
+	}
+	if err := opts.WriteRepoState(index.RepoStateEmpty, ""); err != nil {
+		t.Fatal(err)
+	}
+	opts.RepositoryDescription = zoekt.Repository{ID: 2, Name: "full"}
+	opts.SetDefaults()
+	b, err := index.NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("a.go", []byte("needle")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	ctx := context.Background()
+	rl, err := ss.List(ctx, &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := map[string]string{}
+	for _, r := range rl.Repos {
+		states[r.Repository.Name] = r.Repository.State
+	}
+	if d := cmp.Diff(map[string]string{"empty": "empty", "full": ""}, states); d != "" {
+		t.Errorf("List mismatch (-want +got):\n%s", d)
+	}
+
+	res, err := ss.Search(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].Repository != "full" {
+		t.Errorf("got %v, want a.go in full", res.Files)
+	}
+}
+
 // testDeadline returns the deadline for t, but ensures it is no longer than
 // maxTimeout away.
 func testDeadline(t *testing.T, maxTimeout time.Duration) time.Time {
@@ -0,0 +1,315 @@
+package shards
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/objstore"
+)
+
+// Storage holds the shards a searcher loads. Shards are memory mapped, so
+// they are named by local file names. Storages which keep shards elsewhere,
+// eg. in a bucket, download them to a local cache in Open.
+type Storage interface {
+	// List returns the shards (*.zoekt) and repository state files with their
+	// modification times. The time of a shard accounts for its .meta file, so
+	// that shards are reloaded when their metadata changes.
+	List() (map[string]time.Time, error)
+
+	// Open makes the file name returned by List available locally. It is
+	// called before the file is loaded.
+	Open(name string) error
+
+	// Watch calls notify whenever the files may have changed, until stop is
+	// closed. It returns once watching started.
+	Watch(notify func(), stop <-chan struct{}) error
+
+	String() string
+}
+
+// NewDirStorage returns the storage of the shards in the local directory dir.
+func NewDirStorage(dir string) Storage {
+	return dirStorage(dir)
+}
+
+type dirStorage string
+
+func (d dirStorage) List() (map[string]time.Time, error) {
+	// NOTE: if you change which file extensions are read, please update the
+	// Watch implementation.
+	fs, err := filepath.Glob(filepath.Join(string(d), "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+
+	ts := map[string]time.Time{}
+	for _, fn := range fs {
+		fi, err := os.Lstat(fn)
+		if err != nil {
+			continue
+		}
+
+		ts[fn] = fi.ModTime()
+
+		fiMeta, err := os.Lstat(fn + ".meta")
+		if err != nil {
+			continue
+		}
+		if fiMeta.ModTime().After(fi.ModTime()) {
+			ts[fn] = fiMeta.ModTime()
+		}
+	}
+
+	// Repositories which could not be indexed have a state file instead of
+	// shards.
+	states, err := filepath.Glob(filepath.Join(string(d), "*"+index.RepoStateSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range states {
+		if fi, err := os.Lstat(fn); err == nil {
+			ts[fn] = fi.ModTime()
+		}
+	}
+	return ts, nil
+}
+
+func (d dirStorage) Open(name string) error {
+	return nil
+}
+
+func (d dirStorage) Watch(notify func(), stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(string(d)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+
+		for {
+			select {
+			case event := <-watcher.Events:
+				// Only notify if a file we read in has changed. This is important to
+				// avoid all the events writing to temporary files.
+				if strings.HasSuffix(event.Name, ".zoekt") || strings.HasSuffix(event.Name, ".meta") {
+					notify()
+				}
+
+			case <-ticker.C:
+				// Periodically just double check the disk
+				notify()
+
+			case err := <-watcher.Errors:
+				// Ignore ErrEventOverflow since we rely on the presence of events so
+				// safe to ignore.
+				if err != nil && err != fsnotify.ErrEventOverflow {
+					log.Println("[ERROR] watcher error:", err)
+				}
+
+			case <-stop:
+				watcher.Close()
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (d dirStorage) String() string {
+	return string(d)
+}
+
+// Requests to buckets are canceled after these timeouts, so that a hanging
+// request does not stop the watcher for good.
+const (
+	bucketListTimeout     = time.Minute
+	bucketDownloadTimeout = 30 * time.Minute
+)
+
+// NewBucketStorage returns the storage of the shards in bucket. They are
+// downloaded to cacheDir, which must not be shared with other storages: files
+// in it which are no longer in the bucket are deleted. The bucket is listed
+// every interval.
+func NewBucketStorage(bucket objstore.Bucket, cacheDir string, interval time.Duration) (Storage, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &bucketStorage{
+		bucket:   bucket,
+		dir:      cacheDir,
+		interval: interval,
+	}, nil
+}
+
+type bucketStorage struct {
+	bucket   objstore.Bucket
+	dir      string
+	interval time.Duration
+
+	// mu protects objects, the objects of the last List by key.
+	mu      sync.Mutex
+	objects map[string]objstore.Object
+}
+
+// isShardFile returns true for the files of the bucket which we load or
+// which belong to loaded files.
+func isShardFile(name string) bool {
+	return strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".zoekt.meta") || strings.HasSuffix(name, index.RepoStateSuffix)
+}
+
+func (b *bucketStorage) List() (map[string]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bucketListTimeout)
+	defer cancel()
+	list, err := b.bucket.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", b.bucket, err)
+	}
+
+	objects := map[string]objstore.Object{}
+	ts := map[string]time.Time{}
+	for _, o := range list {
+		if !isShardFile(o.Key) {
+			continue
+		}
+		objects[o.Key] = o
+		if !strings.HasSuffix(o.Key, ".meta") {
+			ts[filepath.Join(b.dir, o.Key)] = o.Updated
+		}
+	}
+	for key, o := range objects {
+		fn := filepath.Join(b.dir, strings.TrimSuffix(key, ".meta"))
+		if t, ok := ts[fn]; ok && o.Updated.After(t) {
+			ts[fn] = o.Updated
+		}
+	}
+
+	b.mu.Lock()
+	b.objects = objects
+	b.mu.Unlock()
+
+	b.prune(objects)
+	return ts, nil
+}
+
+// prune deletes the cached files which are no longer in the bucket. Loaded
+// shards stay usable, since they are memory mapped.
+func (b *bucketStorage) prune(objects map[string]objstore.Object) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		log.Printf("[ERROR] pruning %s: %v", b.dir, err)
+		return
+	}
+	for _, e := range entries {
+		if _, ok := objects[e.Name()]; ok || !isShardFile(e.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.dir, e.Name())); err != nil {
+			log.Printf("[ERROR] pruning %s: %v", e.Name(), err)
+		}
+	}
+}
+
+func (b *bucketStorage) Open(name string) error {
+	key := filepath.Base(name)
+
+	b.mu.Lock()
+	o, ok := b.objects[key]
+	meta, hasMeta := b.objects[key+".meta"]
+	b.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s is not in %s", key, b.bucket)
+	}
+	if err := b.download(o); err != nil {
+		return err
+	}
+	if hasMeta {
+		return b.download(meta)
+	}
+	if err := os.Remove(filepath.Join(b.dir, key+".meta")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// download copies o into the cache directory unless the cached copy is
+// current. The modification time of the copy is the one of o.
+func (b *bucketStorage) download(o objstore.Object) (err error) {
+	dst := filepath.Join(b.dir, o.Key)
+	if fi, err := os.Stat(dst); err == nil && fi.Size() == o.Size && fi.ModTime().Equal(o.Updated) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bucketDownloadTimeout)
+	defer cancel()
+	r, err := b.bucket.Get(ctx, o.Key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp(b.dir, o.Key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", o.Key, err)
+	}
+	if n != o.Size {
+		return fmt.Errorf("downloading %s: got %d bytes, want %d", o.Key, n, o.Size)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), o.Updated, o.Updated); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (b *bucketStorage) Watch(notify func(), stop <-chan struct{}) error {
+	go func() {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				notify()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *bucketStorage) String() string {
+	return b.bucket.String()
+}
@@ -0,0 +1,89 @@
+package shards
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/objstore"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestBucketStorage(t *testing.T) {
+	bucketDir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	b := testShardBuilder(t, &zoekt.Repository{ID: 1, Name: "repo"},
+		index.Document{Name: "f1", Content: []byte("needle in a haystack")})
+	f, err := os.Create(filepath.Join(bucketDir, "repo_v16.00000.zoekt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Files we don't load are neither listed nor downloaded.
+	if err := os.WriteFile(filepath.Join(bucketDir, "README"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket, err := objstore.Open("file://" + bucketDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewBucketStorage(bucket, cacheDir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := newShardedSearcher(1)
+	defer ss.Close()
+	dw, err := newStorageWatcher(storage, &loader{ss: ss, storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dw.Stop()
+	if err := dw.WaitUntilReady(); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func() int {
+		t.Helper()
+		res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Files)
+	}
+	if got := search(); got != 1 {
+		t.Fatalf("got %d matches, want 1", got)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "repo_v16.00000.zoekt" {
+		t.Fatalf("got cache entries %v, want only the shard", entries)
+	}
+
+	// Shards deleted from the bucket are unloaded and removed from the cache.
+	if err := os.Remove(filepath.Join(bucketDir, "repo_v16.00000.zoekt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dw.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); got != 0 {
+		t.Fatalf("got %d matches after deleting the shard, want 0", got)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "repo_v16.00000.zoekt")); !os.IsNotExist(err) {
+		t.Fatalf("shard is still cached: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package shards
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// suggestionCandidates is how many candidates we search per suggestion
+// before giving up.
+const suggestionCandidates = 4
+
+// suggest returns up to opts.MaxSuggestions suggestions for q, which has no
+// results. The shards propose candidates, see zoekt.Suggester, and the ones
+// proposed by the most shards are searched first. Only candidates with
+// results are returned, so the suggestions respect the tenant and the
+// repository filter of opts like any search.
+func suggest(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, loaded loaded) ([]zoekt.Suggestion, error) {
+	var mu sync.Mutex
+	counts := map[zoekt.Suggestion]int{}
+	var candidates []zoekt.Suggestion
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for _, s := range loaded.shards {
+		g.Go(func() error {
+			suggestions, err := suggestShard(gctx, s, q, opts)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, c := range suggestions {
+				if counts[c] == 0 {
+					candidates = append(candidates, c)
+				}
+				counts[c]++
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(candidates, func(a, b zoekt.Suggestion) int {
+		return cmp.Compare(counts[b], counts[a])
+	})
+
+	// We only need to know whether a candidate has any results.
+	checkOpts := *opts
+	checkOpts.ShardMaxMatchCount = 1
+	checkOpts.TotalMaxMatchCount = 1
+	checkOpts.MaxDocDisplayCount = 1
+	checkOpts.MaxSuggestions = 0
+
+	var suggestions []zoekt.Suggestion
+	candidates = candidates[:min(len(candidates), suggestionCandidates*opts.MaxSuggestions)]
+	for _, c := range candidates {
+		if len(suggestions) >= opts.MaxSuggestions {
+			break
+		}
+		sender := newCollectSender(&checkOpts)
+		done, err := streamSearch(ctx, proc, c.Apply(q), &checkOpts, loaded.shards, loaded.priorities, sender)
+		done()
+		if err != nil {
+			return nil, err
+		}
+		if sr, ok := sender.Done(); ok && len(sr.Files) > 0 {
+			suggestions = append(suggestions, c)
+		}
+	}
+	return suggestions, nil
+}
+
+func suggestShard(ctx context.Context, s *rankedShard, q query.Q, opts *zoekt.SearchOptions) (suggestions []zoekt.Suggestion, err error) {
+	sg, ok := s.Searcher.(zoekt.Suggester)
+	if !ok {
+		return nil, nil
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("[ERROR] crashed shard: %s: %#v, %s", s, e, debug.Stack())
+			suggestions, err = nil, nil
+		}
+	}()
+
+	return sg.Suggest(ctx, q, opts)
+}
@@ -17,7 +17,6 @@ package shards
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -25,21 +24,47 @@ import (
 	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/sourcegraph/zoekt/index"
 )
 
 type shardLoader interface {
-	// Load a new file.
-	load(filenames ...string)
-	drop(filenames ...string)
+	// Load new files and unload the dropped ones. The dropped files are
+	// unloaded together with the last of the new files, so that searches
+	// don't miss repositories which moved between them, eg. when simple
+	// shards are merged into a compound shard. It returns the files which
+	// failed to load.
+	load(dropped []string, filenames ...string) (failed []string)
+}
+
+// scanDelay is how long the watcher waits after a change before it scans, so
+// that related changes are seen by the same scan. Merging, for example,
+// renames a compound shard into place and then removes its simple shards.
+const scanDelay = 100 * time.Millisecond
+
+// Shards which fail to load are retried with exponential backoff, since a
+// shard may be seen while it is still being written but may also be
+// corrupt for good.
+const (
+	minLoadRetryInterval = 10 * time.Second
+	maxLoadRetryInterval = time.Hour
+)
+
+// loadFailure tracks a shard which failed to load.
+type loadFailure struct {
+	mtime    time.Time
+	attempts int
+	retryAt  time.Time
 }
 
 type DirectoryWatcher struct {
-	dir        string
+	storage    Storage
 	timestamps map[string]time.Time
+	failed     map[string]loadFailure
 	loader     shardLoader
 
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+
 	// closed once ready
 	ready    chan struct{}
 	readyErr error
@@ -59,10 +84,18 @@ func (sw *DirectoryWatcher) Stop() {
 }
 
 func newDirectoryWatcher(dir string, loader shardLoader) (*DirectoryWatcher, error) {
+	return newStorageWatcher(NewDirStorage(dir), loader)
+}
+
+// newStorageWatcher returns a watcher which loads the shards of storage with
+// loader and keeps them in sync.
+func newStorageWatcher(storage Storage, loader shardLoader) (*DirectoryWatcher, error) {
 	sw := &DirectoryWatcher{
-		dir:        dir,
+		storage:    storage,
 		timestamps: map[string]time.Time{},
+		failed:     map[string]loadFailure{},
 		loader:     loader,
+		now:        time.Now,
 		ready:      make(chan struct{}),
 		quit:       make(chan struct{}),
 		stopped:    make(chan struct{}),
@@ -91,7 +124,7 @@ func (s *DirectoryWatcher) WaitUntilReady() error {
 }
 
 func (s *DirectoryWatcher) String() string {
-	return fmt.Sprintf("shardWatcher(%s)", s.dir)
+	return fmt.Sprintf("shardWatcher(%s)", s.storage)
 }
 
 // versionFromPath extracts url encoded repository name and
@@ -117,15 +150,16 @@ func versionFromPath(path string) (string, int) {
 }
 
 func (s *DirectoryWatcher) scan() error {
-	// NOTE: if you change which file extensions are read, please update the
-	// watch implementation.
-	fs, err := filepath.Glob(filepath.Join(s.dir, "*.zoekt"))
+	ts, err := s.storage.List()
 	if err != nil {
 		return err
 	}
 
 	latest := map[string]int{}
-	for _, fn := range fs {
+	for fn := range ts {
+		if !strings.HasSuffix(fn, ".zoekt") {
+			continue
+		}
 		name, version := versionFromPath(fn)
 
 		// In the case of downgrades, avoid reading
@@ -138,34 +172,23 @@ func (s *DirectoryWatcher) scan() error {
 			latest[name] = version
 		}
 	}
-
-	ts := map[string]time.Time{}
-	for _, fn := range fs {
-		if name, version := versionFromPath(fn); latest[name] != version {
-			continue
-		}
-
-		fi, err := os.Lstat(fn)
-		if err != nil {
+	for fn := range ts {
+		if !strings.HasSuffix(fn, ".zoekt") {
 			continue
 		}
-
-		ts[fn] = fi.ModTime()
-
-		fiMeta, err := os.Lstat(fn + ".meta")
-		if err != nil {
-			continue
-		}
-		if fiMeta.ModTime().After(fi.ModTime()) {
-			ts[fn] = fiMeta.ModTime()
+		if name, version := versionFromPath(fn); latest[name] != version {
+			delete(ts, fn)
 		}
 	}
 
+	now := s.now()
 	var toLoad []string
 	for k, mtime := range ts {
 		if t, ok := s.timestamps[k]; !ok || t != mtime {
 			toLoad = append(toLoad, k)
 			s.timestamps[k] = mtime
+		} else if f, ok := s.failed[k]; ok && !now.Before(f.retryAt) {
+			toLoad = append(toLoad, k)
 		}
 	}
 
@@ -175,6 +198,7 @@ func (s *DirectoryWatcher) scan() error {
 		if _, ok := ts[k]; !ok {
 			toDrop = append(toDrop, k)
 			delete(s.timestamps, k)
+			delete(s.failed, k)
 		}
 	}
 
@@ -182,12 +206,43 @@ func (s *DirectoryWatcher) scan() error {
 		log.Printf("[INFO] unloading %d shard(s): %s", len(toDrop), humanTruncateList(toDrop, 5))
 	}
 
-	s.loader.drop(toDrop...)
-	s.loader.load(toLoad...)
+	// A shard may be seen while it is still being written, eg. if it is
+	// copied into place without a rename, so we retry shards which failed to
+	// load. A shard which keeps failing without changing is likely corrupt, so
+	// we back off to avoid reading it over and over.
+	failed := map[string]bool{}
+	for _, k := range s.loader.load(toDrop, toLoad...) {
+		failed[k] = true
+	}
+	for _, k := range toLoad {
+		if !failed[k] {
+			delete(s.failed, k)
+			continue
+		}
+
+		f := s.failed[k]
+		if f.mtime != ts[k] {
+			// The shard changed since it last failed, start over.
+			f = loadFailure{mtime: ts[k]}
+		}
+		f.attempts++
+		f.retryAt = now.Add(loadRetryInterval(f.attempts))
+		s.failed[k] = f
+	}
 
 	return nil
 }
 
+// loadRetryInterval returns how long to wait before loading a shard again
+// which failed to load the given number of times.
+func loadRetryInterval(attempts int) time.Duration {
+	d := minLoadRetryInterval
+	for i := 1; i < attempts && d < maxLoadRetryInterval; i++ {
+		d *= 2
+	}
+	return min(d, maxLoadRetryInterval)
+}
+
 func humanTruncateList(paths []string, max int) string {
 	sort.Strings(paths)
 	var b strings.Builder
@@ -205,65 +260,43 @@ func humanTruncateList(paths []string, max int) string {
 }
 
 func (s *DirectoryWatcher) watch() error {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
+	// intermediate signal channel so if there are multiple changes we only
+	// call scan once.
+	signal := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
 	}
-	if err := watcher.Add(s.dir); err != nil {
+
+	if err := s.storage.Watch(notify, s.quit); err != nil {
 		return err
 	}
 
-	// intermediate signal channel so if there are multiple watcher.Events we
-	// only call scan once.
-	signal := make(chan struct{}, 1)
-
 	go func() {
-		notify := func() {
-			select {
-			case signal <- struct{}{}:
-			default:
-			}
-		}
-
-		ticker := time.NewTicker(time.Minute)
-
+		defer close(s.stopped)
 		for {
 			select {
-			case event := <-watcher.Events:
-				// Only notify if a file we read in has changed. This is important to
-				// avoid all the events writing to temporary files.
-				if strings.HasSuffix(event.Name, ".zoekt") || strings.HasSuffix(event.Name, ".meta") {
-					notify()
+			case <-signal:
+				select {
+				case <-time.After(scanDelay):
+				case <-s.quit:
+					return
 				}
-
-			case <-ticker.C:
-				// Periodically just double check the disk
-				notify()
-
-			case err := <-watcher.Errors:
-				// Ignore ErrEventOverflow since we rely on the presence of events so
-				// safe to ignore.
-				if err != nil && err != fsnotify.ErrEventOverflow {
+				// The changes during the delay are covered by this scan.
+				select {
+				case <-signal:
+				default:
+				}
+				if err := s.scan(); err != nil {
 					log.Println("[ERROR] watcher error:", err)
 				}
-
 			case <-s.quit:
-				watcher.Close()
-				ticker.Stop()
-				close(signal)
 				return
 			}
 		}
 	}()
 
-	go func() {
-		defer close(s.stopped)
-		for range signal {
-			if err := s.scan(); err != nil {
-				log.Println("[ERROR] watcher error:", err)
-			}
-		}
-	}()
-
 	return nil
 }
@@ -18,6 +18,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,18 +30,22 @@ import (
 type loggingLoader struct {
 	loads chan string
 	drops chan string
+
+	// fail, if set, reports whether loading key fails.
+	fail func(key string) bool
 }
 
-func (l *loggingLoader) load(keys ...string) {
+func (l *loggingLoader) load(dropped []string, keys ...string) (failed []string) {
 	for _, key := range keys {
 		l.loads <- key
+		if l.fail != nil && l.fail(key) {
+			failed = append(failed, key)
+		}
 	}
-}
-
-func (l *loggingLoader) drop(keys ...string) {
-	for _, key := range keys {
+	for _, key := range dropped {
 		l.drops <- key
 	}
+	return failed
 }
 
 func advanceFS() {
@@ -108,6 +115,84 @@ func TestDirWatcherUnloadOnce(t *testing.T) {
 	}
 }
 
+func TestDirWatcherRetryFailedLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	var failing atomic.Bool
+	failing.Store(true)
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+		fail:  func(string) bool { return failing.Load() },
+	}
+
+	// The shard is only partially written when it is first seen.
+	shard := filepath.Join(dir, "foo.zoekt")
+	if err := os.WriteFile(shard, []byte("hel"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	dw, err := newDirectoryWatcher(dir, logger)
+	if err != nil {
+		t.Fatalf("NewDirectoryWatcher: %v", err)
+	}
+	defer dw.Stop()
+	if err := dw.WaitUntilReady(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-logger.loads; got != shard {
+		t.Fatalf("got load event %v, want %v", got, shard)
+	}
+
+	now := time.Now()
+	dw.now = func() time.Time { return now }
+	scan := func() {
+		t.Helper()
+		if err := dw.scan(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertLoad := func(want bool) {
+		t.Helper()
+		select {
+		case k := <-logger.loads:
+			if !want {
+				t.Fatalf("spurious load of %q", k)
+			}
+			if k != shard {
+				t.Fatalf("got load event %v, want %v", k, shard)
+			}
+		default:
+			if want {
+				t.Fatal("shard was not loaded again")
+			}
+		}
+	}
+
+	// The load is retried with backoff even though the shard did not change.
+	scan()
+	assertLoad(false)
+
+	now = now.Add(minLoadRetryInterval)
+	scan()
+	assertLoad(true)
+
+	now = now.Add(minLoadRetryInterval)
+	scan()
+	assertLoad(false)
+
+	failing.Store(false)
+	now = now.Add(minLoadRetryInterval)
+	scan()
+	assertLoad(true)
+
+	// Once loaded, the shard is not loaded again.
+	now = now.Add(maxLoadRetryInterval)
+	scan()
+	assertLoad(false)
+}
+
 func TestDirWatcherLoadEmpty(t *testing.T) {
 	dir := t.TempDir()
 
@@ -131,6 +216,60 @@ func TestDirWatcherLoadEmpty(t *testing.T) {
 	}
 }
 
+type loadCall struct {
+	dropped, loaded []string
+}
+
+type recordingLoader struct {
+	calls chan loadCall
+}
+
+func (l *recordingLoader) load(dropped []string, keys ...string) []string {
+	sort.Strings(dropped)
+	sort.Strings(keys)
+	l.calls <- loadCall{dropped: dropped, loaded: keys}
+	return nil
+}
+
+func TestDirWatcherSwapMerged(t *testing.T) {
+	dir := t.TempDir()
+
+	simple := []string{filepath.Join(dir, "a.zoekt"), filepath.Join(dir, "b.zoekt")}
+	for _, shard := range simple {
+		if err := os.WriteFile(shard, []byte("hello"), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	logger := &recordingLoader{calls: make(chan loadCall, 10)}
+	dw, err := newDirectoryWatcher(dir, logger)
+	if err != nil {
+		t.Fatalf("NewDirectoryWatcher: %v", err)
+	}
+	defer dw.Stop()
+
+	if got := <-logger.calls; !reflect.DeepEqual(got.loaded, simple) {
+		t.Fatalf("got initial load of %v, want %v", got.loaded, simple)
+	}
+
+	// Swap the simple shards for a compound shard like a merge does.
+	advanceFS()
+	compound := filepath.Join(dir, "compound-ab.zoekt")
+	if err := os.WriteFile(compound, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	for _, shard := range simple {
+		if err := os.Remove(shard); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+	}
+
+	want := loadCall{dropped: simple, loaded: []string{compound}}
+	if got := <-logger.calls; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want the compound shard to be loaded and the simple shards dropped in one call %+v", got, want)
+	}
+}
+
 func TestVersionFromPath(t *testing.T) {
 	cases := map[string]struct {
 		name    string
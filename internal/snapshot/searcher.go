@@ -0,0 +1,268 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// maxOpen is the number of snapshots Searcher keeps loaded. Searching a past
+// snapshot is rare, and most searches of a past date ask for the same one.
+const maxOpen = 2
+
+// Searcher searches the live index, or a snapshot of it for queries with an
+// index_at: atom.
+type Searcher struct {
+	live     zoekt.Streamer
+	indexDir string
+
+	// open loads the searcher of a snapshot directory.
+	open func(dir string) (zoekt.Streamer, error)
+
+	// mu protects loaded and clock.
+	mu     sync.Mutex
+	loaded map[string]*loadedSnapshot
+	clock  uint64
+}
+
+// loadedSnapshot is the searcher of a snapshot. It is closed once it is
+// evicted and no search uses it anymore.
+type loadedSnapshot struct {
+	searcher zoekt.Streamer
+	refs     int
+	lastUsed uint64
+	evicted  bool
+}
+
+var (
+	_ zoekt.Streamer        = (*Searcher)(nil)
+	_ zoekt.BatchSearcher   = (*Searcher)(nil)
+	_ zoekt.Explainer       = (*Searcher)(nil)
+	_ zoekt.ListStreamer    = (*Searcher)(nil)
+	_ zoekt.DocumentFetcher = (*Searcher)(nil)
+)
+
+// NewSearcher returns a Searcher which searches live, or the snapshots of
+// indexDir.
+func NewSearcher(indexDir string, live zoekt.Streamer) *Searcher {
+	return &Searcher{
+		live:     live,
+		indexDir: indexDir,
+		open:     shards.NewDirectorySearcher,
+		loaded:   map[string]*loadedSnapshot{},
+	}
+}
+
+// splitIndexAt removes the index_at: atom from q. It returns nil if q has
+// none. The atom must be a conjunct of the whole query, since the snapshot
+// applies to all of it.
+func splitIndexAt(q query.Q) (query.Q, *query.IndexAt, error) {
+	var at *query.IndexAt
+	var split func(q query.Q) (query.Q, error)
+	split = func(q query.Q) (query.Q, error) {
+		switch s := q.(type) {
+		case *query.IndexAt:
+			if at != nil && !at.Time.Equal(s.Time) {
+				return nil, fmt.Errorf("query has more than one index_at: atom")
+			}
+			at = s
+			return &query.Const{Value: true}, nil
+		case *query.And:
+			children := make([]query.Q, 0, len(s.Children))
+			for _, ch := range s.Children {
+				ch, err := split(ch)
+				if err != nil {
+					return nil, err
+				}
+				children = append(children, ch)
+			}
+			return query.NewAnd(children...), nil
+		}
+
+		nested := false
+		query.VisitAtoms(q, func(q query.Q) {
+			if _, ok := q.(*query.IndexAt); ok {
+				nested = true
+			}
+		})
+		if nested {
+			return nil, fmt.Errorf("index_at: must apply to the whole query, it can't be negated or part of an or")
+		}
+		return q, nil
+	}
+
+	q, err := split(q)
+	if err != nil || at == nil {
+		return q, nil, err
+	}
+	return query.Simplify(q), at, nil
+}
+
+// searcherFor returns the searcher for q, and q without its index_at: atom.
+// Call release once the search is done.
+func (s *Searcher) searcherFor(q query.Q) (query.Q, zoekt.Streamer, func(), error) {
+	q, at, err := splitIndexAt(q)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if at == nil {
+		return q, s.live, func() {}, nil
+	}
+
+	snap, err := Resolve(s.indexDir, at.Time)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ls, err := s.acquire(snap.Dir)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("loading snapshot %s: %w", snap.Date.Format(dateLayout), err)
+	}
+	return q, ls.searcher, func() { s.release(ls) }, nil
+}
+
+func (s *Searcher) acquire(dir string) (*loadedSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock++
+
+	if ls, ok := s.loaded[dir]; ok {
+		ls.refs++
+		ls.lastUsed = s.clock
+		return ls, nil
+	}
+
+	// Loading blocks other searches of snapshots, but not those of the live
+	// index, which are the vast majority.
+	searcher, err := s.open(dir)
+	if err != nil {
+		return nil, err
+	}
+	ls := &loadedSnapshot{searcher: searcher, refs: 1, lastUsed: s.clock}
+	s.loaded[dir] = ls
+
+	for len(s.loaded) > maxOpen {
+		var oldestDir string
+		var oldest *loadedSnapshot
+		for d, o := range s.loaded {
+			if oldest == nil || o.lastUsed < oldest.lastUsed {
+				oldestDir, oldest = d, o
+			}
+		}
+		delete(s.loaded, oldestDir)
+		oldest.evicted = true
+		if oldest.refs == 0 {
+			oldest.searcher.Close()
+		}
+	}
+	return ls, nil
+}
+
+func (s *Searcher) release(ls *loadedSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ls.refs--
+	if ls.evicted && ls.refs == 0 {
+		ls.searcher.Close()
+	}
+}
+
+func (s *Searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	q, searcher, release, err := s.searcherFor(q)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return searcher.Search(ctx, q, opts)
+}
+
+func (s *Searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	q, searcher, release, err := s.searcherFor(q)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return searcher.StreamSearch(ctx, q, opts, sender)
+}
+
+// BatchSearch forwards the batch to the live index if none of its queries has
+// an index_at: atom, and searches the queries one by one otherwise.
+func (s *Searcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	snapshots := false
+	for _, q := range qs {
+		if _, at, err := splitIndexAt(q); err != nil || at != nil {
+			snapshots = true
+		}
+	}
+	if !snapshots {
+		return zoekt.BatchSearch(ctx, s.live, qs, opts)
+	}
+
+	if len(qs) > zoekt.MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d queries exceeds the maximum of %d", len(qs), zoekt.MaxBatchSize)
+	}
+	results := make([]*zoekt.SearchResult, 0, len(qs))
+	for _, q := range qs {
+		sr, err := s.Search(ctx, q, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, sr)
+	}
+	return results, nil
+}
+
+func (s *Searcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	q, searcher, release, err := s.searcherFor(q)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return zoekt.Explain(ctx, searcher, q, opts)
+}
+
+// List lists the repositories of the live index, or of a snapshot if q has an
+// index_at: atom.
+func (s *Searcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	q, searcher, release, err := s.searcherFor(q)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return searcher.List(ctx, q, opts)
+}
+
+func (s *Searcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	q, searcher, release, err := s.searcherFor(q)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return zoekt.StreamList(ctx, searcher, q, opts, sender)
+}
+
+// FetchDocument fetches the document from the live index.
+func (s *Searcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.live, req)
+}
+
+func (s *Searcher) Close() {
+	s.live.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dir, ls := range s.loaded {
+		delete(s.loaded, dir)
+		ls.evicted = true
+		if ls.refs == 0 {
+			ls.searcher.Close()
+		}
+	}
+}
+
+func (s *Searcher) String() string {
+	return fmt.Sprintf("snapshot(%s)", s.live)
+}
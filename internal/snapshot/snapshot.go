@@ -0,0 +1,173 @@
+// Package snapshot keeps dated copies of an index directory, so that the
+// corpus can be searched as it was in the past with the index_at: atom.
+//
+// A snapshot is a directory in the .snapshots directory of the index
+// directory, named after the day it was taken, eg.
+// .snapshots/2024-01-01. It holds hard links to the shards and metadata
+// files of the index directory. Since shards and their metadata are never
+// modified in place but replaced by renames, the links keep the files as they
+// were when the snapshot was taken, and a snapshot only costs disk space for
+// the shards which changed since.
+//
+// The indexserver takes the snapshots, see Take and Prune. Searcher routes
+// queries with an index_at: atom to the snapshot which was current at the
+// time.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirName is the directory in an index directory which holds the snapshots.
+// Searchers of the index directory ignore it, since they only load the files
+// at its top level.
+const DirName = ".snapshots"
+
+// dateLayout is the name of a snapshot directory.
+const dateLayout = "2006-01-02"
+
+// Snapshot is a dated copy of an index directory.
+type Snapshot struct {
+	// Date is the UTC day the snapshot was taken.
+	Date time.Time
+	// Dir is the directory of the snapshot, which can be searched like an
+	// index directory.
+	Dir string
+}
+
+func day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Take snapshots indexDir for the day of now. If there already is a snapshot
+// for the day, it is returned unchanged. The caller must make sure that the
+// shards of the index directory are not changed while it runs, so that the
+// snapshot doesn't hold a partial update of a repository.
+func Take(indexDir string, now time.Time) (Snapshot, error) {
+	date := day(now)
+	snap := Snapshot{
+		Date: date,
+		Dir:  filepath.Join(indexDir, DirName, date.Format(dateLayout)),
+	}
+	if _, err := os.Stat(snap.Dir); err == nil {
+		return snap, nil
+	}
+
+	root := filepath.Join(indexDir, DirName)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return Snapshot{}, err
+	}
+
+	// Link into a temporary directory and rename it once complete, so that
+	// searchers never see a partial snapshot.
+	tmp, err := os.MkdirTemp(root, "tmp-")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	entries, err := os.ReadDir(indexDir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !(strings.HasSuffix(name, ".zoekt") || strings.HasSuffix(name, ".meta")) {
+			continue
+		}
+		err := os.Link(filepath.Join(indexDir, name), filepath.Join(tmp, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Snapshot{}, fmt.Errorf("snapshot %s: %w", name, err)
+		}
+	}
+
+	if err := os.Rename(tmp, snap.Dir); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// List returns the snapshots of indexDir, oldest first.
+func List(indexDir string) ([]Snapshot, error) {
+	root := filepath.Join(indexDir, DirName)
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snaps []Snapshot
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		date, err := time.Parse(dateLayout, e.Name())
+		if err != nil {
+			// Temporary directories of Take.
+			continue
+		}
+		snaps = append(snaps, Snapshot{Date: date, Dir: filepath.Join(root, e.Name())})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Date.Before(snaps[j].Date) })
+	return snaps, nil
+}
+
+// ErrNoSnapshot is returned by Resolve if there is no snapshot as old as the
+// requested time.
+var ErrNoSnapshot = errors.New("no snapshot of the index")
+
+// Resolve returns the snapshot of indexDir which was current at t, ie. the
+// latest one taken on or before the day of t.
+func Resolve(indexDir string, t time.Time) (Snapshot, error) {
+	snaps, err := List(indexDir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	t = day(t)
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if !snaps[i].Date.After(t) {
+			return snaps[i], nil
+		}
+	}
+	if len(snaps) == 0 {
+		return Snapshot{}, ErrNoSnapshot
+	}
+	return Snapshot{}, fmt.Errorf("%w as of %s, the oldest is from %s", ErrNoSnapshot, t.Format(dateLayout), snaps[0].Date.Format(dateLayout))
+}
+
+// Prune removes the snapshots of indexDir which are older than retention at
+// now, and the leftovers of interrupted calls to Take. It returns the removed
+// snapshots.
+func Prune(indexDir string, now time.Time, retention time.Duration) ([]Snapshot, error) {
+	root := filepath.Join(indexDir, DirName)
+	if tmps, err := filepath.Glob(filepath.Join(root, "tmp-*")); err == nil {
+		for _, tmp := range tmps {
+			_ = os.RemoveAll(tmp)
+		}
+	}
+
+	snaps, err := List(indexDir)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := day(now.Add(-retention))
+	var removed []Snapshot
+	for _, snap := range snaps {
+		if !snap.Date.Before(cutoff) {
+			break
+		}
+		if err := os.RemoveAll(snap.Dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, snap)
+	}
+	return removed, nil
+}
@@ -0,0 +1,239 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+	"github.com/sourcegraph/zoekt/internal/shards"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// writeShard replaces the shard of repo in dir with one holding fileName.
+func writeShard(t *testing.T, dir, repo, fileName string) {
+	t.Helper()
+
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(index.Document{Name: fileName, Content: []byte("needle")}); err != nil {
+		t.Fatal(err)
+	}
+	path := index.ShardName(dir, repo, index.IndexFormatVersion, 0)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestTakeResolvePrune(t *testing.T) {
+	dir := t.TempDir()
+	writeShard(t, dir, "repo", "old.go")
+
+	snap, err := Take(dir, date("2024-01-01").Add(15*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, DirName, "2024-01-01"); snap.Dir != want {
+		t.Fatalf("got snapshot dir %s, want %s", snap.Dir, want)
+	}
+
+	// A second snapshot of the same day keeps the first.
+	writeShard(t, dir, "repo", "new.go")
+	if _, err := Take(dir, date("2024-01-01").Add(20*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Take(dir, date("2024-02-01")); err != nil {
+		t.Fatal(err)
+	}
+	// Leftover of an interrupted Take.
+	if err := os.Mkdir(filepath.Join(dir, DirName, "tmp-123"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		at   string
+		want string
+	}{
+		{"2024-01-01", "2024-01-01"},
+		{"2024-01-31", "2024-01-01"},
+		{"2024-02-01", "2024-02-01"},
+		{"2025-01-01", "2024-02-01"},
+	} {
+		snap, err := Resolve(dir, date(tc.at).Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := snap.Date.Format(dateLayout); got != tc.want {
+			t.Errorf("Resolve(%s): got %s, want %s", tc.at, got, tc.want)
+		}
+	}
+	if _, err := Resolve(dir, date("2023-12-31")); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("got %v for a time before the first snapshot, want ErrNoSnapshot", err)
+	}
+
+	removed, err := Prune(dir, date("2024-02-10"), 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Date != date("2024-01-01") {
+		t.Fatalf("got removed %+v, want the snapshot of 2024-01-01", removed)
+	}
+	snaps, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || snaps[0].Date != date("2024-02-01") {
+		t.Fatalf("got snapshots %+v after pruning", snaps)
+	}
+	if _, err := os.Stat(filepath.Join(dir, DirName, "tmp-123")); !os.IsNotExist(err) {
+		t.Errorf("Prune left the temporary directory: %v", err)
+	}
+}
+
+func searchFiles(t *testing.T, s zoekt.Searcher, q string) []string {
+	t.Helper()
+	parsed, err := query.Parse(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := s.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	var names []string
+	for _, f := range sr.Files {
+		names = append(names, f.FileName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSearcher(t *testing.T) {
+	dir := t.TempDir()
+	writeShard(t, dir, "repo", "old.go")
+	if _, err := Take(dir, date("2024-01-01")); err != nil {
+		t.Fatal(err)
+	}
+	writeShard(t, dir, "repo", "new.go")
+	if _, err := Take(dir, date("2024-02-01")); err != nil {
+		t.Fatal(err)
+	}
+	writeShard(t, dir, "repo", "live.go")
+
+	live, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSearcher(dir, live)
+	defer s.Close()
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{"needle", []string{"live.go"}},
+		{"needle index_at:2024-01-15", []string{"old.go"}},
+		{"index_at:2024-02-01 (needle or f:new)", []string{"new.go"}},
+		{"index_at:2024-03-01 needle", []string{"new.go"}},
+	} {
+		if d := cmp.Diff(tc.want, searchFiles(t, s, tc.q)); d != "" {
+			t.Errorf("%s: files mismatch (-want +got):\n%s", tc.q, d)
+		}
+	}
+
+	for _, q := range []string{
+		"needle or index_at:2024-01-15",
+		"-index_at:2024-01-15 needle",
+		"index_at:2023-01-01 needle",
+	} {
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), parsed, &zoekt.SearchOptions{}); err == nil {
+			t.Errorf("%s: got no error", q)
+		}
+	}
+
+	rl, err := s.List(context.Background(), query.NewAnd(&query.IndexAt{Time: date("2024-01-01")}, &query.Const{Value: true}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 1 || rl.Repos[0].Repository.Name != "repo" {
+		t.Errorf("got %+v from List of a snapshot", rl.Repos)
+	}
+}
+
+func TestSearcherEvicts(t *testing.T) {
+	dir := t.TempDir()
+	writeShard(t, dir, "repo", "a.go")
+	for _, d := range []string{"2024-01-01", "2024-01-02", "2024-01-03"} {
+		if _, err := Take(dir, date(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewSearcher(dir, nil)
+	closed := map[string]bool{}
+	s.open = func(dir string) (zoekt.Streamer, error) {
+		return &closeRecorder{dir: dir, closed: closed}, nil
+	}
+
+	var inUse func()
+	for i, d := range []string{"2024-01-01", "2024-01-02", "2024-01-03"} {
+		_, _, release, err := s.searcherFor(&query.IndexAt{Time: date(d)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			inUse = release
+		} else {
+			release()
+		}
+	}
+
+	// The first snapshot is evicted, but only closed once its search is done.
+	first := filepath.Join(dir, DirName, "2024-01-01")
+	if len(s.loaded) != maxOpen || closed[first] {
+		t.Fatalf("got %d loaded snapshots, closed %v", len(s.loaded), closed)
+	}
+	inUse()
+	if !closed[first] {
+		t.Fatalf("evicted snapshot was not closed once released")
+	}
+}
+
+type closeRecorder struct {
+	zoekt.Streamer
+	dir    string
+	closed map[string]bool
+}
+
+func (c *closeRecorder) Close() { c.closed[c.dir] = true }
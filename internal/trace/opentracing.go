@@ -47,3 +47,10 @@ func StartSpanFromContextWithTracer(ctx context.Context, tracer opentracing.Trac
 func StartSpanFromContext(ctx context.Context, operationName string, opts ...opentracing.StartSpanOption) (opentracing.Span, context.Context) {
 	return StartSpanFromContextWithTracer(ctx, GetOpenTracer(ctx, nil), operationName, opts...)
 }
+
+// IsRecording returns false if span is a no-op span, eg. because opentracing
+// is disabled for the request. Use it to skip work which only feeds the span.
+func IsRecording(span opentracing.Span) bool {
+	_, noop := span.Tracer().(opentracing.NoopTracer)
+	return !noop
+}
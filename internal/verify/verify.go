@@ -0,0 +1,90 @@
+// Package verify checks the integrity of the shards in an index directory and
+// quarantines corrupt ones. It is shared by zoekt-merge-index verify and the
+// /debug/verify endpoint of zoekt-sourcegraph-indexserver.
+package verify
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sourcegraph/zoekt/index"
+)
+
+// QuarantineDirName is the directory in the index directory which corrupt
+// shards are moved to. Neither the webserver nor the indexserver look at it,
+// so the indexserver rebuilds the repositories of quarantined shards.
+const QuarantineDirName = ".quarantine"
+
+// Report lists the corrupt shards of an index directory.
+type Report struct {
+	IndexDir string
+
+	// Shards is the number of shards which were checked.
+	Shards int
+
+	Corrupt []CorruptShard
+}
+
+// CorruptShard is a shard which failed index.VerifyShard.
+type CorruptShard struct {
+	Path  string
+	Error string
+
+	// Quarantined is the path the shard was moved to, if it was quarantined.
+	Quarantined string `json:",omitempty"`
+
+	// QuarantineError is set if the shard could not be quarantined.
+	QuarantineError string `json:",omitempty"`
+}
+
+// Dir checks every shard in dir with index.VerifyShard. If quarantine is
+// true, corrupt shards and their .meta files are moved to QuarantineDirName.
+func Dir(dir string, quarantine bool) (*Report, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.zoekt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	r := &Report{IndexDir: dir, Shards: len(paths)}
+	for _, p := range paths {
+		err := index.VerifyShard(p)
+		if err == nil {
+			continue
+		}
+
+		c := CorruptShard{Path: p, Error: err.Error()}
+		if quarantine {
+			if dst, err := quarantineShard(dir, p); err != nil {
+				c.QuarantineError = err.Error()
+			} else {
+				c.Quarantined = dst
+			}
+		}
+		r.Corrupt = append(r.Corrupt, c)
+	}
+	return r, nil
+}
+
+// quarantineShard moves the shard at path and its .meta file to the
+// quarantine directory of dir and returns the new path of the shard.
+func quarantineShard(dir, path string) (string, error) {
+	qdir := filepath.Join(dir, QuarantineDirName)
+	if err := os.MkdirAll(qdir, 0o755); err != nil {
+		return "", err
+	}
+
+	paths, err := index.IndexFilePaths(path)
+	if err != nil {
+		return "", err
+	}
+	// IndexFilePaths lists the shard first, so it is out of the way even if
+	// moving its .meta file fails.
+	for _, p := range paths {
+		if err := os.Rename(p, filepath.Join(qdir, filepath.Base(p))); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(qdir, filepath.Base(path)), nil
+}
@@ -0,0 +1,90 @@
+package verify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"repo-a", "repo-b"} {
+		b, err := index.NewBuilder(index.Options{
+			IndexDir:              dir,
+			RepositoryDescription: zoekt.Repository{Name: name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFile("f", []byte("content of "+name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	corrupt := filepath.Join(dir, "repo-b_v16.00000.zoekt")
+	data, err := os.ReadFile(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("content of repo-b"))
+	if i < 0 {
+		t.Fatal("content not found")
+	}
+	data[i] = 'C'
+	if err := os.WriteFile(corrupt, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(corrupt+".meta", []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Dir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Shards != 2 || len(r.Corrupt) != 1 || r.Corrupt[0].Path != corrupt || r.Corrupt[0].Quarantined != "" {
+		t.Fatalf("got %+v, want only %s corrupt", r, corrupt)
+	}
+	if _, err := os.Stat(corrupt); err != nil {
+		t.Fatalf("shard was moved without -quarantine: %v", err)
+	}
+
+	r, err = Dir(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, QuarantineDirName, "repo-b_v16.00000.zoekt")
+	if len(r.Corrupt) != 1 || r.Corrupt[0].Quarantined != want {
+		t.Fatalf("got %+v, want %s quarantined to %s", r, corrupt, want)
+	}
+	for _, p := range []string{want, want + ".meta"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("quarantined file missing: %v", err)
+		}
+	}
+
+	r, err = Dir(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Shards != 1 || len(r.Corrupt) != 0 {
+		t.Fatalf("got %+v after quarantine, want 1 intact shard", r)
+	}
+}
+
+func TestDir_Testdata(t *testing.T) {
+	r, err := Dir("../../testdata/shards", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Shards == 0 || len(r.Corrupt) > 0 {
+		t.Fatalf("got %+v, want only intact shards", r)
+	}
+}
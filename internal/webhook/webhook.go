@@ -0,0 +1,217 @@
+// Package webhook notifies external systems of indexing lifecycle events, so
+// they can track the freshness of the index without scraping logs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	IndexStarted   EventType = "index.started"
+	IndexSucceeded EventType = "index.succeeded"
+	IndexFailed    EventType = "index.failed"
+	ShardMerged    EventType = "shard.merged"
+	RepoCleanedUp  EventType = "repo.cleaned_up"
+)
+
+// Event is the JSON body POSTed to the endpoints of a Notifier.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Hostname is the host of the indexserver which sent the event.
+	Hostname string `json:",omitempty"`
+
+	RepoID   uint32 `json:",omitempty"`
+	RepoName string `json:",omitempty"`
+
+	// Branches are the indexed branches as name=version.
+	Branches []string `json:",omitempty"`
+
+	// Shard is the path of the compound shard of a ShardMerged event.
+	Shard string `json:",omitempty"`
+
+	// Action and Reason describe what cleanup did to a repository, eg. "trash"
+	// and "unassigned".
+	Action string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+
+	// DurationSeconds is the time indexing or merging took.
+	DurationSeconds float64 `json:",omitempty"`
+
+	// Error is set for IndexFailed events.
+	Error string `json:",omitempty"`
+}
+
+// Options configure a Notifier.
+type Options struct {
+	// Endpoints are the URLs each event is POSTed to.
+	Endpoints []string
+
+	// Hostname is set on every event.
+	Hostname string
+
+	// MaxAttempts is the number of times delivery to an endpoint is tried.
+	// Defaults to 5.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, which doubles for each
+	// further retry. Defaults to 1s.
+	Backoff time.Duration
+
+	// Timeout bounds each request. Defaults to 10s.
+	Timeout time.Duration
+
+	// QueueSize is the number of events which wait for delivery before new
+	// events are dropped. Defaults to 1000.
+	QueueSize int
+}
+
+// ParseEndpoints splits a comma separated list of URLs, eg. the value of a
+// flag.
+func ParseEndpoints(s string) []string {
+	var endpoints []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// Notifier delivers events to its endpoints in the background, in the order
+// they were sent. A nil Notifier drops all events, so callers don't have to
+// check whether webhooks are configured.
+type Notifier struct {
+	opts   Options
+	client *http.Client
+
+	events chan Event
+	done   chan struct{}
+
+	// closeOnce guards closing events.
+	closeOnce sync.Once
+}
+
+// New returns a Notifier which POSTs events to opts.Endpoints. It returns nil
+// if there are no endpoints.
+func New(opts Options) *Notifier {
+	if len(opts.Endpoints) == 0 {
+		return nil
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+
+	n := &Notifier{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		events: make(chan Event, opts.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues e for delivery. It sets the time and hostname of e if they
+// are unset. Notify never blocks: if the queue is full, e is dropped.
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Hostname == "" {
+		e.Hostname = n.opts.Hostname
+	}
+
+	select {
+	case n.events <- e:
+	default:
+		log.Printf("webhook: queue full, dropping %s event for %q", e.Type, e.RepoName)
+	}
+}
+
+// Close delivers the queued events and then stops n. Notify must not be
+// called after Close.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.closeOnce.Do(func() { close(n.events) })
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for e := range n.events {
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("webhook: marshal %s event: %v", e.Type, err)
+			continue
+		}
+		for _, endpoint := range n.opts.Endpoints {
+			if err := n.deliver(endpoint, body); err != nil {
+				log.Printf("webhook: giving up on %s event for %q: %v", e.Type, e.RepoName, err)
+			}
+		}
+	}
+}
+
+// deliver POSTs body to endpoint, retrying with exponential backoff on
+// network errors, 429 and 5xx responses.
+func (n *Notifier) deliver(endpoint string, body []byte) error {
+	backoff := n.opts.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = n.post(endpoint, body)
+		if err == nil || !retry || attempt == n.opts.MaxAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one request. retry is true if the error is worth retrying.
+func (n *Notifier) post(endpoint string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("POST %s: %s", endpoint, resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
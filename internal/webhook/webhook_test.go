@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNotifier(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		got      []Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// Fail the first attempt to exercise retries.
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got = append(got, e)
+	}))
+	defer srv.Close()
+
+	n := New(Options{
+		Endpoints: []string{srv.URL},
+		Hostname:  "indexserver-0",
+		Backoff:   time.Millisecond,
+	})
+	now := time.Unix(1700000000, 0).UTC()
+	n.Notify(Event{Type: IndexStarted, Time: now, RepoID: 1, RepoName: "repo"})
+	n.Notify(Event{Type: IndexFailed, Time: now, RepoID: 1, RepoName: "repo", Error: "boom"})
+	n.Close()
+
+	want := []Event{
+		{Type: IndexStarted, Time: now, Hostname: "indexserver-0", RepoID: 1, RepoName: "repo"},
+		{Type: IndexFailed, Time: now, Hostname: "indexserver-0", RepoID: 1, RepoName: "repo", Error: "boom"},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+}
+
+func TestNotifier_noRetryOnClientError(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n := New(Options{Endpoints: []string{srv.URL}, Backoff: time.Millisecond})
+	n.Notify(Event{Type: ShardMerged})
+	n.Close()
+
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
+
+func TestNotifier_nil(t *testing.T) {
+	n := New(Options{Endpoints: ParseEndpoints(" , ")})
+	if n != nil {
+		t.Fatal("expected a nil Notifier without endpoints")
+	}
+	n.Notify(Event{Type: IndexStarted})
+	n.Close()
+}
@@ -0,0 +1,209 @@
+package query
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/sourcegraph/zoekt/internal/languages"
+)
+
+// Builder constructs queries without depending on the concrete types
+// implementing Q. Every method adds a term which must match, and the result
+// is the same as parsing the string returned by String:
+//
+//	q, err := query.NewBuilder().Repo("^github\\.com/sourcegraph/").Lang("go").Content("foo").Build()
+//
+// Invalid arguments are reported by Build.
+type Builder struct {
+	caseMode string
+	terms    []string
+	negate   bool
+	err      error
+}
+
+// NewBuilder returns an empty Builder. Building it yields a query matching
+// everything.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Repo restricts results to repositories whose name matches the regular
+// expression pattern, like repo:.
+func (b *Builder) Repo(pattern string) *Builder {
+	return b.regexpTerm("repo:", pattern)
+}
+
+// Branch restricts results to branches containing name, like branch:.
+func (b *Builder) Branch(name string) *Builder {
+	if name == "" {
+		return b.fail(fmt.Errorf("query: branch must not be empty"))
+	}
+	return b.term("branch:", name)
+}
+
+// File matches file names against the regular expression pattern, like
+// file:.
+func (b *Builder) File(pattern string) *Builder {
+	return b.regexpTerm("file:", pattern)
+}
+
+// Content matches file contents against the regular expression pattern,
+// like content:. Use regexp.QuoteMeta to search for a literal string.
+func (b *Builder) Content(pattern string) *Builder {
+	return b.regexpTerm("content:", pattern)
+}
+
+// Text matches file names or contents against the regular expression
+// pattern, like an unqualified search term.
+func (b *Builder) Text(pattern string) *Builder {
+	return b.regexpTerm("regex:", pattern)
+}
+
+// Sym matches symbol definitions against the regular expression pattern,
+// like sym:.
+func (b *Builder) Sym(pattern string) *Builder {
+	return b.regexpTerm("sym:", pattern)
+}
+
+// Lang restricts results to a language, given by name or alias, like lang:.
+func (b *Builder) Lang(lang string) *Builder {
+	if lang == "" {
+		return b.fail(fmt.Errorf("query: language must not be empty"))
+	}
+	if canonical, ok := languages.GetLanguageByAlias(lang); ok {
+		lang = canonical
+	}
+	return b.term("lang:", lang)
+}
+
+// Archived restricts results to archived repositories if yes is true, and to
+// non-archived repositories otherwise.
+func (b *Builder) Archived(yes bool) *Builder {
+	return b.term("archived:", yesNo(yes))
+}
+
+// Fork restricts results to forks if yes is true, and to repositories which
+// are not forks otherwise.
+func (b *Builder) Fork(yes bool) *Builder {
+	return b.term("fork:", yesNo(yes))
+}
+
+// Case sets the case sensitivity of all patterns to "yes", "no" or "auto".
+func (b *Builder) Case(mode string) *Builder {
+	switch mode {
+	case "yes", "no", "auto":
+		b.caseMode = mode
+		return b
+	default:
+		return b.fail(fmt.Errorf("query: unknown case mode %q, want {yes,no,auto}", mode))
+	}
+}
+
+// Not negates the next term.
+func (b *Builder) Not() *Builder {
+	b.negate = !b.negate
+	return b
+}
+
+// Or adds a term matching if any of alts matches. Case cannot be set on
+// alternatives.
+func (b *Builder) Or(alts ...*Builder) *Builder {
+	if len(alts) == 0 {
+		return b.fail(fmt.Errorf("query: Or needs at least one alternative"))
+	}
+
+	parts := make([]string, 0, len(alts))
+	for _, alt := range alts {
+		if alt.err != nil {
+			return b.fail(alt.err)
+		}
+		if alt.caseMode != "" {
+			return b.fail(fmt.Errorf("query: case can only be set on the outermost builder"))
+		}
+		if len(alt.terms) == 0 {
+			return b.fail(fmt.Errorf("query: Or alternative must not be empty"))
+		}
+		if len(alt.terms) == 1 {
+			parts = append(parts, alt.terms[0])
+		} else {
+			parts = append(parts, "("+strings.Join(alt.terms, " ")+")")
+		}
+	}
+	return b.raw("(" + strings.Join(parts, " or ") + ")")
+}
+
+// String returns the query in the syntax accepted by Parse.
+func (b *Builder) String() string {
+	terms := b.terms
+	if b.caseMode != "" {
+		terms = append([]string{"case:" + b.caseMode}, terms...)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Build returns the query, or the first error caused by an invalid argument.
+func (b *Builder) Build() (Q, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.negate {
+		return nil, fmt.Errorf("query: Not must be followed by a term")
+	}
+	return Parse(b.String())
+}
+
+func (b *Builder) regexpTerm(field, pattern string) *Builder {
+	if pattern == "" {
+		return b.fail(fmt.Errorf("query: %s pattern must not be empty", strings.TrimSuffix(field, ":")))
+	}
+	if _, err := syntax.Parse(pattern, regexpFlags); err != nil {
+		return b.fail(fmt.Errorf("query: invalid %s pattern %q: %w", strings.TrimSuffix(field, ":"), pattern, err))
+	}
+	return b.term(field, pattern)
+}
+
+func (b *Builder) term(field, value string) *Builder {
+	return b.raw(field + quoteValue(value))
+}
+
+func (b *Builder) raw(term string) *Builder {
+	if b.negate {
+		term = "-" + term
+		b.negate = false
+	}
+	b.terms = append(b.terms, term)
+	return b
+}
+
+func (b *Builder) fail(err error) *Builder {
+	if b.err == nil {
+		b.err = err
+	}
+	b.negate = false
+	return b
+}
+
+func yesNo(yes bool) string {
+	if yes {
+		return "yes"
+	}
+	return "no"
+}
+
+// quoteValue quotes v if the tokenizer would not read it back verbatim.
+func quoteValue(v string) string {
+	if !strings.ContainsAny(v, " \t\n\"\\()") {
+		return v
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		if v[i] == '"' || v[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(v[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
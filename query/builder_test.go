@@ -0,0 +1,97 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/regexp"
+)
+
+func TestBuilder(t *testing.T) {
+	for _, c := range []struct {
+		b       *Builder
+		wantStr string
+		want    Q
+	}{
+		{
+			b:       NewBuilder(),
+			wantStr: "",
+			want:    &Const{Value: true},
+		},
+		{
+			b:       NewBuilder().Repo("foo").Lang("go").Content("bar"),
+			wantStr: "repo:foo lang:Go content:bar",
+			want: NewAnd(
+				&Repo{Regexp: regexp.MustCompile("foo")},
+				&Language{Language: "Go"},
+				&Substring{Pattern: "bar", Content: true}),
+		},
+		{
+			b:       NewBuilder().Content(`a "b"`).File(`\.go$`),
+			wantStr: `content:"a \"b\"" file:"\\.go$"`,
+			want: NewAnd(
+				&Substring{Pattern: `a "b"`, Content: true},
+				&Regexp{Regexp: mustParseRE(`\.go$`), FileName: true}),
+		},
+		{
+			b:       NewBuilder().Text("foo").Not().File("_test"),
+			wantStr: "regex:foo -file:_test",
+			want: NewAnd(
+				&Substring{Pattern: "foo"},
+				&Not{&Substring{Pattern: "_test", FileName: true}}),
+		},
+		{
+			b:       NewBuilder().Case("yes").Sym("Foo"),
+			wantStr: "case:yes sym:Foo",
+			want:    &Symbol{&Substring{Pattern: "Foo", CaseSensitive: true}},
+		},
+		{
+			b:       NewBuilder().Archived(false).Fork(true).Branch("main"),
+			wantStr: "archived:no fork:yes branch:main",
+			want: NewAnd(
+				RawConfig(RcNoArchived),
+				RawConfig(RcOnlyForks),
+				&Branch{Pattern: "main"}),
+		},
+		{
+			b: NewBuilder().Not().Or(
+				NewBuilder().Lang("py"),
+				NewBuilder().Lang("go").File("x"),
+			),
+			wantStr: "-(lang:Python or (lang:Go file:x))",
+			want: &Not{NewOr(
+				&Language{Language: "Python"},
+				NewAnd(&Language{Language: "Go"}, &Substring{Pattern: "x", FileName: true}))},
+		},
+	} {
+		if got := c.b.String(); got != c.wantStr {
+			t.Errorf("got string %q, want %q", got, c.wantStr)
+		}
+		got, err := c.b.Build()
+		if err != nil {
+			t.Errorf("%s: %v", c.wantStr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %s, want %s", c.wantStr, got, c.want)
+		}
+	}
+}
+
+func TestBuilder_Errors(t *testing.T) {
+	for name, b := range map[string]*Builder{
+		"empty repo":      NewBuilder().Repo(""),
+		"invalid regexp":  NewBuilder().Lang("go").Content("a("),
+		"empty lang":      NewBuilder().Lang(""),
+		"case mode":       NewBuilder().Case("sometimes"),
+		"dangling not":    NewBuilder().Content("a").Not(),
+		"empty or":        NewBuilder().Or(),
+		"case in or":      NewBuilder().Or(NewBuilder().Case("yes").Content("a")),
+		"invalid alt":     NewBuilder().Or(NewBuilder().File("[")),
+		"empty alt in or": NewBuilder().Or(NewBuilder()),
+	} {
+		if q, err := b.Build(); err == nil {
+			t.Errorf("%s: got %s, want error", name, q)
+		}
+	}
+}
@@ -11,6 +11,6 @@
 // limitations under the License.
 
 // Package query contains the API for creating Zoekt queries. Queries can be
-// constructed directly through query.Q objects, or by parsing a string using
-// query.Parse
+// constructed directly through query.Q objects, by parsing a string using
+// query.Parse, or with a query.Builder.
 package query
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"strings"
+	"time"
 
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/internal/languages"
@@ -67,15 +69,41 @@ func (o *orOperator) String() string {
 	return "orOp"
 }
 
+// andOperator is the placeholder for an explicit "and" between two
+// expressions, which is otherwise implied.
+type andOperator struct{}
+
+func (o *andOperator) String() string {
+	return "andOp"
+}
+
 func isSpace(c byte) bool {
 	return c == ' ' || c == '\t'
 }
 
+// parser holds the state of a single Parse call.
+type parser struct {
+	// patternType is set by patterntype:, see scanPatternType. It applies to
+	// the whole query, also to the atoms before it.
+	patternType string
+
+	// depth is the number of enclosing parentheses.
+	depth int
+
+	// caseSet holds the atoms whose case sensitivity was set by a case: atom
+	// in their own group, which takes precedence over the enclosing groups.
+	caseSet map[Q]bool
+}
+
 // Parse parses a string into a query.
 func Parse(qStr string) (Q, error) {
 	b := []byte(qStr)
 
-	qs, _, err := parseExprList(b)
+	p := &parser{
+		patternType: scanPatternType(b),
+		caseSet:     map[Q]bool{},
+	}
+	qs, _, err := p.parseExprList(b)
 	if err != nil {
 		return nil, err
 	}
@@ -88,9 +116,30 @@ func Parse(qStr string) (Q, error) {
 	return Simplify(q), nil
 }
 
+// scanPatternType returns the value of the last patterntype: atom in the
+// query, or "" if there is none. Errors are left to the parser.
+func scanPatternType(in []byte) string {
+	patternType := ""
+	for b := in; len(b) > 0; {
+		if isSpace(b[0]) {
+			b = b[1:]
+			continue
+		}
+		tok, err := nextToken(b)
+		if err != nil || tok == nil {
+			break
+		}
+		if tok.Type == tokPatternType {
+			patternType = string(tok.Text)
+		}
+		b = b[len(tok.Input):]
+	}
+	return patternType
+}
+
 // parseExpr parses a single expression, returning the result, and the
 // number of bytes consumed.
-func parseExpr(in []byte) (Q, int, error) {
+func (p *parser) parseExpr(in []byte) (Q, int, error) {
 	b := in[:]
 	var expr Q
 	for len(b) > 0 && isSpace(b[0]) {
@@ -117,6 +166,24 @@ func parseExpr(in []byte) (Q, int, error) {
 			return nil, 0, fmt.Errorf("query: unknown case argument %q, want {yes,no,auto}", text)
 		}
 		expr = &caseQ{text}
+	case tokPatternType:
+		switch text {
+		case "literal", "structural":
+			expr = &patternTypeQ{Type: text}
+		case "regexp", "regex":
+			expr = &patternTypeQ{Type: "regexp"}
+		default:
+			return nil, 0, fmt.Errorf("query: unknown patterntype argument %q, want {literal,regexp,structural}", text)
+		}
+	case tokMultiline:
+		switch text {
+		case "yes":
+			expr = &multilineQ{Multiline: true}
+		case "no":
+			expr = &multilineQ{Multiline: false}
+		default:
+			return nil, 0, fmt.Errorf("query: unknown multiline argument %q, want {yes,no}", text)
+		}
 	case tokRepo:
 		r, err := regexp.Compile(text)
 		if err != nil {
@@ -151,9 +218,103 @@ func parseExpr(in []byte) (Q, int, error) {
 		default:
 			return nil, 0, fmt.Errorf("query: unknown public argument %q, want {yes,no}", text)
 		}
+	case tokLicense:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the license: atom must have an argument")
+		}
+		expr = &License{License: strings.ToLower(text)}
+	case tokHash:
+		// Checksums are 64 bits, ie. 16 hex digits.
+		prefix := strings.ToLower(text)
+		if prefix == "" || len(prefix) > 16 || strings.Trim(prefix, "0123456789abcdef") != "" {
+			return nil, 0, fmt.Errorf("query: hash: wants a hex prefix of at most 16 digits, got %q", text)
+		}
+		expr = &Checksum{Prefix: prefix}
+	case tokTest:
+		switch text {
+		case "only":
+			expr = &TestFile{}
+		case "no":
+			expr = &Not{Child: &TestFile{}}
+		default:
+			// Searching for eg. "test:foo" in code is common, so unknown values
+			// are not an error.
+			expr = &Substring{Pattern: "test:" + text}
+		}
+	case tokGenerated:
+		switch text {
+		case "only":
+			expr = &GeneratedFile{}
+		case "no":
+			expr = &Not{Child: &GeneratedFile{}}
+		default:
+			expr = &Substring{Pattern: "generated:" + text}
+		}
 	case tokBranch:
 		expr = &Branch{Pattern: text}
-	case tokText, tokRegex:
+	case tokCommit:
+		if text == "" {
+			expr = &Commit{}
+			break
+		}
+		// Commit messages and changed paths are part of the content of commit
+		// documents.
+		q, err := RegexpQuery(text, true, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = NewAnd(&Commit{}, q)
+	case tokAuthor:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the author: atom must have an argument")
+		}
+		expr = &Commit{Author: text}
+	case tokBefore, tokAfter:
+		t, err := parseDate(text)
+		if err != nil {
+			return nil, 0, err
+		}
+		if tok.Type == tokBefore {
+			expr = &Commit{Before: t}
+		} else {
+			expr = &Commit{After: t}
+		}
+	case tokIndexAt:
+		t, err := parseDate(text)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = &IndexAt{Time: t}
+	case tokRev:
+		if len(text) < 4 || len(text) > 64 || strings.Trim(text, "0123456789abcdefABCDEF") != "" {
+			return nil, 0, fmt.Errorf("query: rev: wants a commit hash prefix of at least 4 hex digits, got %q", text)
+		}
+		expr = &Rev{Revision: strings.ToLower(text)}
+	case tokContext:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the context: atom must have an argument")
+		}
+		expr = &SearchContext{Name: text}
+	case tokBoost:
+		q, err := parsePathBoost(text)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = q
+	case tokText:
+		switch p.patternType {
+		case "literal":
+			expr = &Substring{Pattern: text}
+		case "structural":
+			expr = &Structural{Pattern: text}
+		default:
+			q, err := RegexpQuery(text, false, false)
+			if err != nil {
+				return nil, 0, err
+			}
+			expr = q
+		}
+	case tokRegex:
 		q, err := RegexpQuery(text, false, false)
 		if err != nil {
 			return nil, 0, err
@@ -166,18 +327,29 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 		expr = q
 	case tokContent:
-		q, err := RegexpQuery(text, true, false)
-		if err != nil {
-			return nil, 0, err
+		switch p.patternType {
+		case "literal":
+			expr = &Substring{Pattern: text, Content: true}
+		case "structural":
+			expr = &Structural{Pattern: text}
+		default:
+			q, err := RegexpQuery(text, true, false)
+			if err != nil {
+				return nil, 0, err
+			}
+			expr = q
 		}
-		expr = q
 	case tokLang:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the lang: atom must have an argument")
+		}
+		// Languages we don't know an alias for are resolved against the
+		// languages stored in each shard, see indexData.resolveLanguage.
 		canonical, ok := languages.GetLanguageByAlias(text)
 		if !ok {
-			expr = &Const{false}
-		} else {
-			expr = &Language{Language: canonical}
+			canonical = text
 		}
+		expr = &Language{Language: canonical}
 
 	case tokSym:
 		if text == "" {
@@ -190,12 +362,25 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 
 		expr = &Symbol{q}
+
+	case tokSubToken:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the subtoken: atom must have an argument")
+		}
+		expr = &SubToken{Pattern: text}
+	case tokFilenameFuzzy:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the f~: atom must have an argument")
+		}
+		expr = &FilenameFuzzy{Pattern: text}
 	case tokParenClose:
 		// Caller must consume paren.
 		expr = nil
 
 	case tokParenOpen:
-		qs, n, err := parseExprList(b)
+		p.depth++
+		qs, n, err := p.parseExprList(b)
+		p.depth--
 		b = b[n:]
 		if err != nil {
 			return nil, 0, err
@@ -214,13 +399,16 @@ func parseExpr(in []byte) (Q, int, error) {
 		if err != nil {
 			return nil, 0, err
 		}
-	case tokNegate:
-		subQ, n, err := parseExpr(b)
+	case tokNegate, tokNot:
+		subQ, n, err := p.parseExpr(b)
 		if err != nil {
 			return nil, 0, err
 		}
 		if subQ == nil {
-			return nil, 0, fmt.Errorf("query: '-' operator needs an argument")
+			return nil, 0, fmt.Errorf("query: %q operator needs an argument", text)
+		}
+		if _, ok := subQ.(*PathBoost); ok {
+			return nil, 0, fmt.Errorf("query: boost: cannot be negated, demote files with a boost below 1 instead")
 		}
 		b = b[n:]
 		expr = &Not{subQ}
@@ -244,6 +432,25 @@ func parseExpr(in []byte) (Q, int, error) {
 	return expr, len(in) - len(b), nil
 }
 
+// dateLayouts are the formats accepted by the before:, after: and index_at:
+// atoms.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseDate parses the argument of a before:, after: or index_at: atom. Dates without a
+// time zone are interpreted as UTC.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("query: invalid date %q, want YYYY-MM-DD or RFC 3339", s)
+}
+
 const regexpFlags syntax.Flags = syntax.ClassNL | syntax.PerlX | syntax.UnicodeGroups
 
 // RegexpQuery parses an atom into either a regular expression, or a
@@ -275,25 +482,38 @@ func RegexpQuery(text string, content, file bool) (Q, error) {
 	return expr, nil
 }
 
-// parseOperators interprets the orOperator in a list of queries.
+// parseOperators interprets the orOperator and andOperator in a list of
+// queries. AND binds more tightly than OR, so "a or b and c" is
+// "a or (b and c)".
 func parseOperators(in []Q) (Q, error) {
 	top := &Or{}
 	cur := &And{}
 
 	seenOr := false
+	pendingAnd := false
 	for _, q := range in {
-		if _, ok := q.(*orOperator); ok {
+		switch q.(type) {
+		case *orOperator:
 			seenOr = true
-			if len(cur.Children) == 0 {
+			if len(cur.Children) == 0 || pendingAnd {
 				return nil, fmt.Errorf("query: OR operator should have operand")
 			}
 			top.Children = append(top.Children, cur)
 			cur = &And{}
-		} else {
+		case *andOperator:
+			if len(cur.Children) == 0 || pendingAnd {
+				return nil, fmt.Errorf("query: AND operator should have operand")
+			}
+			pendingAnd = true
+		default:
+			pendingAnd = false
 			cur.Children = append(cur.Children, q)
 		}
 	}
 
+	if pendingAnd {
+		return nil, fmt.Errorf("query: AND operator should have operand")
+	}
 	if seenOr && len(cur.Children) == 0 {
 		return nil, fmt.Errorf("query: OR operator should have operand")
 	}
@@ -303,7 +523,7 @@ func parseOperators(in []Q) (Q, error) {
 
 // parseExprList parses a list of query expressions. It is the
 // workhorse of the Parse function.
-func parseExprList(in []byte) ([]Q, int, error) {
+func (p *parser) parseExprList(in []byte) ([]Q, int, error) {
 	b := in[:]
 	var qs []Q
 	for len(b) > 0 {
@@ -317,9 +537,13 @@ func parseExprList(in []byte) ([]Q, int, error) {
 			qs = append(qs, &orOperator{})
 			b = b[len(tok.Input):]
 			continue
+		} else if tok != nil && tok.Type == tokAnd {
+			qs = append(qs, &andOperator{})
+			b = b[len(tok.Input):]
+			continue
 		}
 
-		q, n, err := parseExpr(b)
+		q, n, err := p.parseExpr(b)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -332,13 +556,18 @@ func parseExprList(in []byte) ([]Q, int, error) {
 		b = b[n:]
 	}
 
-	setCase := "auto"
+	setCase := ""
+	multiline := false
 	newQS := qs[:0]
 	typeT := uint8(100)
 	for _, q := range qs {
 		switch s := q.(type) {
 		case *caseQ:
 			setCase = s.Flavor
+		case *patternTypeQ:
+			// Applied by Parse, see scanPatternType.
+		case *multilineQ:
+			multiline = s.Multiline
 		case *Type:
 			if s.Type < typeT {
 				typeT = s.Type
@@ -347,14 +576,26 @@ func parseExprList(in []byte) ([]Q, int, error) {
 			newQS = append(newQS, q)
 		}
 	}
+	// Atoms without a case: in any enclosing group are case:auto.
+	if setCase == "" && p.depth == 0 {
+		setCase = "auto"
+	}
 	qs = mapQueryList(newQS, func(q Q) Q {
-		if sc, ok := q.(setCaser); ok {
+		if sc, ok := q.(setCaser); ok && setCase != "" && !p.caseSet[q] {
 			sc.setCase(setCase)
+			p.caseSet[q] = true
+		}
+		if re, ok := q.(*Regexp); ok && multiline {
+			re.Multiline = true
 		}
 		return q
 	})
 	if typeT != 100 {
-		qs = []Q{&Type{Type: typeT, Child: NewAnd(qs...)}}
+		child, err := parseOperators(qs)
+		if err != nil {
+			return nil, 0, err
+		}
+		qs = []Q{&Type{Type: typeT, Child: child}}
 	}
 	return qs, len(in) - len(b), nil
 }
@@ -374,68 +615,124 @@ func (t *token) String() string {
 
 // token types.
 const (
-	tokText       = 0
-	tokFile       = 1
-	tokRepo       = 2
-	tokCase       = 3
-	tokBranch     = 4
-	tokParenOpen  = 5
-	tokParenClose = 6
-	tokError      = 7
-	tokNegate     = 8
-	tokRegex      = 9
-	tokOr         = 10
-	tokContent    = 11
-	tokLang       = 12
-	tokSym        = 13
-	tokType       = 14
-	tokArchived   = 15
-	tokPublic     = 16
-	tokFork       = 17
+	tokText          = 0
+	tokFile          = 1
+	tokRepo          = 2
+	tokCase          = 3
+	tokBranch        = 4
+	tokParenOpen     = 5
+	tokParenClose    = 6
+	tokError         = 7
+	tokNegate        = 8
+	tokRegex         = 9
+	tokOr            = 10
+	tokContent       = 11
+	tokLang          = 12
+	tokSym           = 13
+	tokType          = 14
+	tokArchived      = 15
+	tokPublic        = 16
+	tokFork          = 17
+	tokLicense       = 18
+	tokCommit        = 19
+	tokAuthor        = 20
+	tokBefore        = 21
+	tokAfter         = 22
+	tokTest          = 23
+	tokSubToken      = 24
+	tokMultiline     = 25
+	tokAnd           = 26
+	tokNot           = 27
+	tokPatternType   = 28
+	tokHash          = 29
+	tokFilenameFuzzy = 30
+	tokIndexAt       = 31
+	tokContext       = 32
+	tokBoost         = 33
+	tokGenerated     = 34
+	tokRev           = 35
 )
 
 var tokNames = map[int]string{
-	tokArchived:   "Archived",
-	tokBranch:     "Branch",
-	tokCase:       "Case",
-	tokError:      "Error",
-	tokFile:       "File",
-	tokFork:       "Fork",
-	tokNegate:     "Negate",
-	tokOr:         "Or",
-	tokParenClose: "ParenClose",
-	tokParenOpen:  "ParenOpen",
-	tokPublic:     "Public",
-	tokRegex:      "Regex",
-	tokRepo:       "Repo",
-	tokText:       "Text",
-	tokLang:       "Language",
-	tokSym:        "Symbol",
-	tokType:       "Type",
+	tokAfter:         "After",
+	tokAnd:           "And",
+	tokArchived:      "Archived",
+	tokAuthor:        "Author",
+	tokBefore:        "Before",
+	tokBoost:         "Boost",
+	tokBranch:        "Branch",
+	tokCase:          "Case",
+	tokCommit:        "Commit",
+	tokContext:       "Context",
+	tokError:         "Error",
+	tokFile:          "File",
+	tokFilenameFuzzy: "FilenameFuzzy",
+	tokFork:          "Fork",
+	tokGenerated:     "Generated",
+	tokHash:          "Hash",
+	tokIndexAt:       "IndexAt",
+	tokNegate:        "Negate",
+	tokNot:           "Not",
+	tokOr:            "Or",
+	tokParenClose:    "ParenClose",
+	tokParenOpen:     "ParenOpen",
+	tokPatternType:   "PatternType",
+	tokPublic:        "Public",
+	tokRegex:         "Regex",
+	tokRepo:          "Repo",
+	tokRev:           "Rev",
+	tokText:          "Text",
+	tokLang:          "Language",
+	tokLicense:       "License",
+	tokMultiline:     "Multiline",
+	tokSubToken:      "SubToken",
+	tokSym:           "Symbol",
+	tokTest:          "Test",
+	tokType:          "Type",
 }
 
 var prefixes = map[string]int{
-	"archived:": tokArchived,
-	"b:":        tokBranch,
-	"branch:":   tokBranch,
-	"c:":        tokContent,
-	"case:":     tokCase,
-	"content:":  tokContent,
-	"f:":        tokFile,
-	"file:":     tokFile,
-	"fork:":     tokFork,
-	"public:":   tokPublic,
-	"r:":        tokRepo,
-	"regex:":    tokRegex,
-	"repo:":     tokRepo,
-	"lang:":     tokLang,
-	"sym:":      tokSym,
-	"t:":        tokType,
-	"type:":     tokType,
+	"after:":       tokAfter,
+	"archived:":    tokArchived,
+	"author:":      tokAuthor,
+	"b:":           tokBranch,
+	"before:":      tokBefore,
+	"boost:":       tokBoost,
+	"branch:":      tokBranch,
+	"c:":           tokContent,
+	"case:":        tokCase,
+	"commit:":      tokCommit,
+	"content:":     tokContent,
+	"context:":     tokContext,
+	"f:":           tokFile,
+	"f~:":          tokFilenameFuzzy,
+	"file:":        tokFile,
+	"fork:":        tokFork,
+	"generated:":   tokGenerated,
+	"hash:":        tokHash,
+	"index_at:":    tokIndexAt,
+	"public:":      tokPublic,
+	"r:":           tokRepo,
+	"regex:":       tokRegex,
+	"repo:":        tokRepo,
+	"rev:":         tokRev,
+	"lang:":        tokLang,
+	"license:":     tokLicense,
+	"multiline:":   tokMultiline,
+	"patterntype:": tokPatternType,
+	"subtoken:":    tokSubToken,
+	"sym:":         tokSym,
+	"t:":           tokType,
+	"test:":        tokTest,
+	"type:":        tokType,
 }
 
+// reservedWords are the keywords of the query language. Like in Sourcegraph,
+// they are case-insensitive and must be quoted to search for them.
 var reservedWords = map[string]int{
-	"or": tokOr,
+	"and": tokAnd,
+	"not": tokNot,
+	"or":  tokOr,
 }
 
 func (t *token) setType() {
@@ -450,7 +747,7 @@ func (t *token) setType() {
 	}
 
 	for w, typ := range reservedWords {
-		if string(t.Text) == w && string(t.Input) == w {
+		if strings.EqualFold(string(t.Text), w) && string(t.Input) == string(t.Text) {
 			t.Type = typ
 			break
 		}
@@ -538,7 +835,10 @@ loop:
 		return nil, nil
 	}
 
-	if foundSpace && cur.Text[0] == '(' {
+	// "(lang:go)" and "(-lang:go)" are groups rather than regexes, even
+	// without a space inside the parentheses. Other fields keep their old
+	// meaning, since eg. "(t:string)" is a common regex.
+	if (foundSpace || startsWithLang(cur.Text[1:])) && cur.Text[0] == '(' {
 		cur.Text = cur.Text[:1]
 		cur.Input = in[:1]
 	} else {
@@ -547,3 +847,8 @@ loop:
 	cur.setType()
 	return &cur, nil
 }
+
+// startsWithLang returns true if b starts with "lang:" or "-lang:".
+func startsWithLang(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimPrefix(b, []byte{'-'}), []byte("lang:"))
+}
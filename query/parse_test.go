@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"regexp/syntax"
 	"testing"
+	"time"
 
 	"github.com/grafana/regexp"
 )
@@ -42,6 +43,18 @@ func TestParseQuery(t *testing.T) {
 		{"fi\"le:bla\"", &Substring{Pattern: "file:bla"}},
 		{"abc or def", NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})},
 		{"(abc or def)", NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})},
+		{"abc OR def", NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})},
+		{"abc and def", NewAnd(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})},
+		{"abc or def AND ghi", NewOr(
+			&Substring{Pattern: "abc"},
+			NewAnd(&Substring{Pattern: "def"}, &Substring{Pattern: "ghi"}))},
+		{"(abc or def) and ghi", NewAnd(
+			NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"}),
+			&Substring{Pattern: "ghi"})},
+		{"not abc", &Not{&Substring{Pattern: "abc"}}},
+		{"NOT (abc or def)", &Not{NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
+		{"\"and\" abc", NewAnd(&Substring{Pattern: "and"}, &Substring{Pattern: "abc"})},
+		{"android", &Substring{Pattern: "android"}},
 		{"(ppp qqq or rrr sss)", NewOr(
 			NewAnd(&Substring{Pattern: "ppp"}, &Substring{Pattern: "qqq"}),
 			NewAnd(&Substring{Pattern: "rrr"}, &Substring{Pattern: "sss"}))},
@@ -86,6 +99,9 @@ func TestParseQuery(t *testing.T) {
 
 		{"file:\"\"", &Const{true}},
 		{"abc.*def", &Regexp{Regexp: mustParseRE("abc.*def")}},
+		{"abc.*def multiline:yes", &Regexp{Regexp: mustParseRE("abc.*def"), Multiline: true}},
+		{"abc.*def multiline:no", &Regexp{Regexp: mustParseRE("abc.*def")}},
+		{"abc multiline:yes", &Substring{Pattern: "abc"}},
 		{"abc\\.\\*def", &Substring{Pattern: "abc.*def"}},
 		{"(abc)", &Substring{Pattern: "abc"}},
 
@@ -94,10 +110,65 @@ func TestParseQuery(t *testing.T) {
 
 		{"lang:c++", &Language{"C++"}},
 		{"lang:cpp", &Language{"C++"}},
+		{"lang:py", &Language{"Python"}},
+		{"lang:mylang", &Language{"mylang"}},
+		{"lang:", nil},
+		{"-lang:go", &Not{&Language{"Go"}}},
+		{"(-lang:go)", &Not{&Language{"Go"}}},
+		{"(lang:golang)", &Language{"Go"}},
+
+		// Only lang: starts a group without a space, other fields stay
+		// regexes.
+		{"(t:string)", &Substring{Pattern: "t:string"}},
+		{"(b:1)", &Substring{Pattern: "b:1"}},
+		{"(c:foo)", &Substring{Pattern: "c:foo"}},
+		{"abc (-lang:js)", NewAnd(&Substring{Pattern: "abc"}, &Not{&Language{"JavaScript"}})},
+		{"license:Apache-2.0", &License{"apache-2.0"}},
+		{"commit:", &Commit{}},
+		{"commit:fix", NewAnd(&Commit{}, &Substring{Pattern: "fix", Content: true})},
+		{"author:jane", &Commit{Author: "jane"}},
+		{"author:", nil},
+		{"after:2024-01-02", &Commit{After: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		{"before:2024-01-02T10:00:00Z", &Commit{Before: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}},
+		{"before:yesterday", nil},
+		{"test:only", &TestFile{}},
+		{"index_at:2024-01-01", &IndexAt{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{"index_at:2024-01-01 foo", NewAnd(&IndexAt{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, &Substring{Pattern: "foo"})},
+		{"index_at:last-week", nil},
+		{"context:payments foo", NewAnd(&SearchContext{Name: "payments"}, &Substring{Pattern: "foo"})},
+		{"context:", nil},
+		{"rev:0123ABCD foo", NewAnd(&Rev{Revision: "0123abcd"}, &Substring{Pattern: "foo"})},
+		{"rev:", nil},
+		{"rev:abc", nil},
+		{"rev:-foo", nil},
+		{"boost:vendor/:0.1 foo", NewAnd(&PathBoost{Pattern: "vendor/", Boost: 0.1}, &Substring{Pattern: "foo"})},
+		{"boost:docs/", &PathBoost{Pattern: "docs/", Boost: 2}},
+		{"boost:a:b", &PathBoost{Pattern: "a:b", Boost: 2}},
+		{"boost:", nil},
+		{"boost:vendor/:0", nil},
+		{"boost:[vendor/", nil},
+		{"-boost:vendor/", nil},
+		{"hash:0123ABCD", &Checksum{Prefix: "0123abcd"}},
+		{"hash:", nil},
+		{"hash:xyz", nil},
+		{"hash:0123456789abcdef0", nil},
+		{"abc test:no", NewAnd(&Substring{Pattern: "abc"}, &Not{&TestFile{}})},
+		{"test:maybe", &Substring{Pattern: "test:maybe"}},
+		{"test:", &Substring{Pattern: "test:"}},
+		{"abc generated:no", NewAnd(&Substring{Pattern: "abc"}, &Not{&GeneratedFile{}})},
+		{"generated:only", &GeneratedFile{}},
+		{"generated:maybe", &Substring{Pattern: "generated:maybe"}},
 		{"sym:pqr", &Symbol{&Substring{Pattern: "pqr"}}},
 		{"sym:Pqr", &Symbol{&Substring{Pattern: "Pqr", CaseSensitive: true}}},
 		{"sym:.*", &Symbol{&Regexp{Regexp: mustParseRE(".*")}}},
 		{"sym:a(b|d)e", &Symbol{&Regexp{Regexp: mustParseRE("a[bd]e")}}},
+		{"subtoken:barbaz", &SubToken{Pattern: "barbaz"}},
+		{"subtoken:BarBaz", &SubToken{Pattern: "BarBaz", CaseSensitive: true}},
+		{"subtoken:BarBaz case:no", &SubToken{Pattern: "BarBaz"}},
+		{"subtoken:", nil},
+		{"f~:zkwbsrv", &FilenameFuzzy{Pattern: "zkwbsrv"}},
+		{"f~:ZkWeb", &FilenameFuzzy{Pattern: "ZkWeb", CaseSensitive: true}},
+		{"f~:", nil},
 
 		// case
 		{"abc case:yes", &Substring{Pattern: "abc", CaseSensitive: true}},
@@ -113,17 +184,47 @@ func TestParseQuery(t *testing.T) {
 		{"type:repo abc", &Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}},
 		{"type:file abc def", &Type{Type: TypeFileName, Child: NewAnd(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
 		{"(type:repo abc) def", NewAnd(&Type{Type: TypeRepo, Child: &Substring{Pattern: "abc"}}, &Substring{Pattern: "def"})},
+		{"type:file abc or def", &Type{Type: TypeFileName, Child: NewOr(&Substring{Pattern: "abc"}, &Substring{Pattern: "def"})}},
+
+		// case: applies to its group, and takes precedence over the case: of
+		// enclosing groups.
+		{"(case:yes abc) def", NewAnd(
+			&Substring{Pattern: "abc", CaseSensitive: true},
+			&Substring{Pattern: "def"})},
+		{"case:no (case:yes abc) DEF", NewAnd(
+			&Substring{Pattern: "abc", CaseSensitive: true},
+			&Substring{Pattern: "DEF"})},
+		{"(abc) case:yes", &Substring{Pattern: "abc", CaseSensitive: true}},
+
+		// patterntype
+		{"patterntype:literal foo(", &Substring{Pattern: "foo("}},
+		{"a.*b patterntype:literal", &Substring{Pattern: "a.*b"}},
+		{"patterntype:literal content:a.b regex:a.b", NewAnd(
+			&Substring{Pattern: "a.b", Content: true},
+			&Regexp{Regexp: mustParseRE("a.b")})},
+		{"patterntype:literal file:a.b", &Regexp{Regexp: mustParseRE("a.b"), FileName: true}},
+		{"patterntype:regexp a.b", &Regexp{Regexp: mustParseRE("a.b")}},
+		{"patterntype:structural foo(:[args])", &Structural{Pattern: "foo(:[args])"}},
+		{`patterntype:structural "foo(:[a], :[b])" lang:go`, NewAnd(
+			&Structural{Pattern: "foo(:[a], :[b])"},
+			&Language{Language: "Go"})},
+		{"patterntype:foo", nil},
 
 		// errors.
 		{"--", nil},
 		{"\"abc", nil},
 		{"\"a\\", nil},
 		{"case:foo", nil},
+		{"multiline:foo", nil},
 
 		{"sym:", nil},
 		{"abc or", nil},
 		{"or abc", nil},
 		{"def or or abc", nil},
+		{"abc and", nil},
+		{"and abc", nil},
+		{"abc and or def", nil},
+		{"not", nil},
 
 		{"", &Const{Value: true}},
 	} {
@@ -154,6 +255,13 @@ func TestTokenize(t *testing.T) {
 		{"(abc)(de)", tokText, "(abc)(de)"},
 		{"(ab(c)def) ", tokText, "(ab(c)def)"},
 		{"(ab\\ def) ", tokText, "(ab\\ def)"},
+		{"(lang:go)", tokParenOpen, "("},
+		{"(-lang:go)", tokParenOpen, "("},
+		{"(-file:bla)", tokText, "(-file:bla)"},
+		{"(t:string)", tokText, "(t:string)"},
+		{"(b:1)", tokText, "(b:1)"},
+		{"(c:foo)", tokText, "(c:foo)"},
+		{"(-abc)", tokText, "(-abc)"},
 		{") ", tokParenClose, ")"},
 		{"a(bc))", tokText, "a(bc)"},
 		{"abc) ", tokText, "abc"},
@@ -162,6 +270,10 @@ func TestTokenize(t *testing.T) {
 		{"\\", tokError, ""},
 		{"o\"r\" bla", tokText, "or"},
 		{"or bla", tokOr, "or"},
+		{"OR bla", tokOr, "OR"},
+		{"and bla", tokAnd, "and"},
+		{"Not bla", tokNot, "Not"},
+		{"patterntype:literal", tokPatternType, "literal"},
 		{"ar bla", tokText, "ar"},
 	}
 	for _, c := range cases {
@@ -0,0 +1,127 @@
+package query
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultPathBoost is the boost of boost:PATTERN atoms without a factor.
+const defaultPathBoost = 2
+
+// PathBoost multiplies the score of the files whose path matches Pattern by
+// Boost, eg. to demote vendored code with a Boost below 1. Unlike a file:
+// filter it does not change which files match: shards take PathBoost atoms
+// out of the query and apply them like zoekt.SearchOptions.PathBoosts.
+//
+// Patterns are globs in the syntax of path.Match which, like gitignore
+// patterns, match the path of a file or any of its trailing parts starting
+// at a directory, so "*_test.go" matches "a_test.go" and "pkg/a_test.go". A
+// pattern ending in "/" matches the files below a matching directory, eg.
+// "vendor/" matches "vendor/a.go" and "pkg/vendor/b/c.go". A leading "/"
+// anchors the pattern at the root, so "/docs/" only matches the top-level
+// docs directory.
+type PathBoost struct {
+	Pattern string
+	Boost   float64
+}
+
+func (q *PathBoost) String() string {
+	return "boost:" + q.Pattern + ":" + strconv.FormatFloat(q.Boost, 'g', -1, 64)
+}
+
+// parsePathBoost parses the argument of a boost: atom, PATTERN or
+// PATTERN:FACTOR.
+func parsePathBoost(text string) (*PathBoost, error) {
+	q := &PathBoost{Pattern: text, Boost: defaultPathBoost}
+	if i := strings.LastIndexByte(text, ':'); i >= 0 {
+		if boost, err := strconv.ParseFloat(text[i+1:], 64); err == nil {
+			q.Pattern, q.Boost = text[:i], boost
+		}
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Validate returns an error if Pattern is empty or malformed, or if Boost
+// is not positive.
+func (q *PathBoost) Validate() error {
+	pattern := strings.Trim(q.Pattern, "/")
+	if pattern == "" {
+		return fmt.Errorf("boost: pattern %q must not be empty", q.Pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("boost: pattern %q: %w", q.Pattern, err)
+	}
+	if !(q.Boost > 0) {
+		return fmt.Errorf("boost: boost of %q must be positive, got %g", q.Pattern, q.Boost)
+	}
+	return nil
+}
+
+// Match returns true if the file name matches Pattern.
+func (q *PathBoost) Match(name string) bool {
+	pattern, anchored := strings.CutPrefix(q.Pattern, "/")
+	pattern, dir := strings.CutSuffix(pattern, "/")
+	for {
+		if matchPathPattern(pattern, dir, name) {
+			return true
+		}
+		i := strings.IndexByte(name, '/')
+		if anchored || i < 0 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
+
+// matchPathPattern returns true if pattern matches name, or one of its
+// directories if dir is set.
+func matchPathPattern(pattern string, dir bool, name string) bool {
+	if !dir {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	for i := range len(name) {
+		if name[i] != '/' {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name[:i]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PathBoostFactor returns the Boost of the rule with the longest Pattern
+// which matches the file name, or 1 if none does. Rules with a Boost which
+// is not positive are ignored.
+func PathBoostFactor(boosts []PathBoost, name string) float64 {
+	factor, longest := 1.0, -1
+	for i := range boosts {
+		b := &boosts[i]
+		if b.Boost > 0 && len(b.Pattern) > longest && b.Match(name) {
+			factor, longest = b.Boost, len(b.Pattern)
+		}
+	}
+	return factor
+}
+
+// ExtractPathBoosts returns q without its PathBoost atoms, and the atoms.
+func ExtractPathBoosts(q Q) (Q, []PathBoost) {
+	var boosts []PathBoost
+	q = Map(q, func(q Q) Q {
+		if b, ok := q.(*PathBoost); ok {
+			boosts = append(boosts, *b)
+			return &Const{Value: true}
+		}
+		return q
+	})
+	if len(boosts) == 0 {
+		return q, nil
+	}
+	return Simplify(q), boosts
+}
@@ -0,0 +1,65 @@
+package query
+
+import "testing"
+
+func TestPathBoostMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"vendor/", "vendor/a.go", true},
+		{"vendor/", "pkg/vendor/b/c.go", true},
+		{"vendor/", "vendor.go", false},
+		{"vendor/", "pkg/vendorx/a.go", false},
+		{"/vendor/", "pkg/vendor/a.go", false},
+		{"/vendor/", "vendor/a.go", true},
+		{"docs/api/", "a/docs/api/x.md", true},
+		{"docs/api/", "docs/x.md", false},
+		{"*_test.go", "a_test.go", true},
+		{"*_test.go", "pkg/a_test.go", true},
+		{"*_test.go", "a_test.go.orig", false},
+		{"cmd/*.go", "cmd/main.go", true},
+		{"cmd/*.go", "x/cmd/main.go", true},
+		{"cmd/*.go", "cmd/sub/main.go", false},
+		{"README.md", "README.md", true},
+		{"README.md", "docs/README.md", true},
+	} {
+		q := &PathBoost{Pattern: tc.pattern, Boost: 2}
+		if got := q.Match(tc.name); got != tc.want {
+			t.Errorf("%q.Match(%q) = %t, want %t", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPathBoostFactor(t *testing.T) {
+	boosts := []PathBoost{
+		{Pattern: "vendor/", Boost: 0.5},
+		{Pattern: "vendor/foo/", Boost: 2},
+		{Pattern: "*.md", Boost: 0},
+	}
+	for name, want := range map[string]float64{
+		"vendor/a.go":     0.5,
+		"vendor/foo/a.go": 2,
+		"main.go":         1,
+		"README.md":       1,
+	} {
+		if got := PathBoostFactor(boosts, name); got != want {
+			t.Errorf("PathBoostFactor(%q) = %g, want %g", name, got, want)
+		}
+	}
+}
+
+func TestExtractPathBoosts(t *testing.T) {
+	q, err := Parse("boost:vendor/:0.1 foo boost:docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, boosts := ExtractPathBoosts(q)
+	if got, want := q.String(), `substr:"foo"`; got != want {
+		t.Errorf("got query %s, want %s", got, want)
+	}
+	if len(boosts) != 2 || boosts[0] != (PathBoost{Pattern: "vendor/", Boost: 0.1}) || boosts[1] != (PathBoost{Pattern: "docs/", Boost: 2}) {
+		t.Errorf("got boosts %v", boosts)
+	}
+}
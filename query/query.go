@@ -23,6 +23,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
@@ -76,6 +77,12 @@ type Regexp struct {
 	FileName      bool
 	Content       bool
 	CaseSensitive bool
+
+	// Multiline lets matches span lines, like ripgrep's --multiline
+	// --multiline-dotall: "." also matches newlines and the ngrams of all
+	// literals narrow down the candidates, not only those on one line. With
+	// ChunkMatches, the ranges of a match may cover several lines.
+	Multiline bool
 }
 
 func (q *Regexp) String() string {
@@ -86,6 +93,9 @@ func (q *Regexp) String() string {
 	if q.CaseSensitive {
 		pref = "case_" + pref
 	}
+	if q.Multiline {
+		pref = "multiline_" + pref
+	}
 	return fmt.Sprintf("%sregex:%q", pref, syntaxutil.RegexpString(q.Regexp))
 }
 
@@ -136,6 +146,29 @@ func (c *caseQ) String() string {
 	return "case:" + c.Flavor
 }
 
+// patternTypeQ is the patterntype: atom, which selects whether patterns are
+// regular expressions, literal strings or structural patterns for the whole
+// query.
+type patternTypeQ struct {
+	Type string
+}
+
+func (p *patternTypeQ) String() string {
+	return "patterntype:" + p.Type
+}
+
+// multilineQ sets Regexp.Multiline of the regexps next to it.
+type multilineQ struct {
+	Multiline bool
+}
+
+func (m *multilineQ) String() string {
+	if m.Multiline {
+		return "multiline:yes"
+	}
+	return "multiline:no"
+}
+
 type Language struct {
 	Language string
 }
@@ -144,6 +177,108 @@ func (l *Language) String() string {
 	return "lang:" + l.Language
 }
 
+// License matches repositories whose detected license expression mentions
+// the given SPDX identifier, eg. license:mit matches "mit or apache-2.0". The
+// comparison is case-insensitive.
+type License struct {
+	License string
+}
+
+func (l *License) String() string {
+	return "license:" + l.License
+}
+
+// TestFile matches documents which look like tests according to the test file
+// conventions of their language, eg. foo_test.go or src/test/java/FooTest.java.
+type TestFile struct{}
+
+func (t *TestFile) String() string {
+	return "test:only"
+}
+
+// GeneratedFile matches documents which look generated or minified, eg.
+// bundle.js or files starting with "Code generated ... DO NOT EDIT.", and
+// documents the repository declares as generated. See index.IsGeneratedFile.
+type GeneratedFile struct{}
+
+func (g *GeneratedFile) String() string {
+	return "generated:only"
+}
+
+// Checksum matches documents whose content checksum, see
+// zoekt.FileMatch.Checksum, starts with Prefix, a lowercase hex string. The
+// checksum is a CRC-64 (ISO) of the content, so it finds copies of a file
+// cheaply, but matches must be compared to the content to rule out
+// collisions, see zoekt.FindDuplicates.
+type Checksum struct {
+	Prefix string
+}
+
+func (c *Checksum) String() string {
+	return "hash:" + c.Prefix
+}
+
+// Commit matches documents describing commits, as indexed by zoekt-git-index
+// -index_history. Commit messages and changed paths are matched by combining
+// Commit with content queries.
+type Commit struct {
+	// Author is a case-insensitive substring of the commit author's name and
+	// email. Empty matches any author.
+	Author string
+
+	// After and Before bound the commit date if they are not zero. After is
+	// inclusive, Before is exclusive.
+	After, Before time.Time
+}
+
+func (c *Commit) String() string {
+	parts := []string{"commit"}
+	if c.Author != "" {
+		parts = append(parts, "author:"+strconv.Quote(c.Author))
+	}
+	if !c.After.IsZero() {
+		parts = append(parts, "after:"+c.After.Format(time.RFC3339))
+	}
+	if !c.Before.IsZero() {
+		parts = append(parts, "before:"+c.Before.Format(time.RFC3339))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// IndexAt searches the index as it was at Time, rather than the current
+// index. It is resolved by the snapshot layer of zoekt-webserver, see
+// package snapshot, and must be a conjunct of the whole query.
+type IndexAt struct {
+	Time time.Time
+}
+
+func (q *IndexAt) String() string {
+	return "index_at:" + q.Time.Format(time.RFC3339)
+}
+
+// SearchContext searches the repositories of the named search context, eg.
+// the ones owned by a team. The contexts are defined server-side, and
+// zoekt-webserver expands the atom into their repositories, see
+// web.SearchContexts.
+type SearchContext struct {
+	Name string
+}
+
+func (q *SearchContext) String() string {
+	return "context:" + q.Name
+}
+
+// Rev searches the commit whose hash starts with Revision. Shards only know
+// the commits of their indexed branches, so zoekt-webserver resolves the atom
+// into these branches, see web.NewRevSearcher.
+type Rev struct {
+	Revision string
+}
+
+func (q *Rev) String() string {
+	return "rev:" + q.Revision
+}
+
 type Const struct {
 	Value bool
 }
@@ -415,6 +550,59 @@ func (q *Substring) String() string {
 	return s
 }
 
+// SubToken matches Pattern in file contents if the match starts and ends
+// at sub-token boundaries of identifiers. Identifiers are split at
+// underscores and case changes, so "BarBaz" matches in FooBarBaz and
+// "bar" in foo_bar_baz, but neither matches in FooBarBazooka.
+type SubToken struct {
+	Pattern       string
+	CaseSensitive bool
+}
+
+func (q *SubToken) String() string {
+	s := fmt.Sprintf("subtoken:%q", q.Pattern)
+	if q.CaseSensitive {
+		s = "case_" + s
+	}
+	return s
+}
+
+// FilenameFuzzy matches file names which contain the characters of Pattern
+// in order, but not necessarily adjacent, like the file finders of editors:
+// "zkwbsrv" matches "zoekt-webserver". Matches are ranked by how well the
+// characters line up, eg. at word boundaries or in a run.
+type FilenameFuzzy struct {
+	Pattern       string
+	CaseSensitive bool
+}
+
+func (q *FilenameFuzzy) String() string {
+	s := fmt.Sprintf("file_fuzzy:%q", q.Pattern)
+	if q.CaseSensitive {
+		s = "case_" + s
+	}
+	return s
+}
+
+// Structural matches file contents against a structural pattern, a
+// subset of the syntax of comby. The pattern is literal text with holes:
+// :[name] matches any text in which parentheses, brackets, braces and
+// string literals are balanced, and :[[name]] matches an identifier. Holes
+// with the same name must match the same text, except for :[_]. Whitespace
+// matches any amount of whitespace, so "foo(:[args])" matches calls of foo
+// with any arguments, even across lines. Matching is case sensitive.
+//
+// Structural is experimental: only documents which contain the literal
+// text of the pattern are matched, so patterns without any such text
+// longer than an ngram are expensive.
+type Structural struct {
+	Pattern string
+}
+
+func (q *Structural) String() string {
+	return fmt.Sprintf("structural:%q", q.Pattern)
+}
+
 type setCaser interface {
 	setCase(string)
 }
@@ -431,6 +619,28 @@ func (q *Substring) setCase(k string) {
 	}
 }
 
+func (q *SubToken) setCase(k string) {
+	switch k {
+	case "yes":
+		q.CaseSensitive = true
+	case "no":
+		q.CaseSensitive = false
+	case "auto":
+		q.CaseSensitive = (q.Pattern != string(toLower([]byte(q.Pattern))))
+	}
+}
+
+func (q *FilenameFuzzy) setCase(k string) {
+	switch k {
+	case "yes":
+		q.CaseSensitive = true
+	case "no":
+		q.CaseSensitive = false
+	case "auto":
+		q.CaseSensitive = (q.Pattern != string(toLower([]byte(q.Pattern))))
+	}
+}
+
 func (q *Symbol) setCase(k string) {
 	if sc, ok := q.Expr.(setCaser); ok {
 		sc.setCase(k)
@@ -643,6 +853,18 @@ func evalConstants(q Q) Q {
 		if len(s.Pattern) == 0 {
 			return &Const{true}
 		}
+	case *SubToken:
+		if len(s.Pattern) == 0 {
+			return &Const{true}
+		}
+	case *FilenameFuzzy:
+		if len(s.Pattern) == 0 {
+			return &Const{true}
+		}
+	case *Structural:
+		if len(s.Pattern) == 0 {
+			return &Const{true}
+		}
 	case *Regexp:
 		if s.Regexp.Op == syntax.OpEmptyMatch {
 			return &Const{true}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	proto "github.com/sourcegraph/zoekt/grpc/protos/zoekt/webserver/v1"
 )
@@ -48,9 +49,31 @@ func QToProto(q Q) *proto.Q {
 		return &proto.Q{Query: &proto.Q_Branch{Branch: v.ToProto()}}
 	case *Boost:
 		return &proto.Q{Query: &proto.Q_Boost{Boost: v.ToProto()}}
+	case *License:
+		return &proto.Q{Query: &proto.Q_License{License: v.ToProto()}}
+	case *Commit:
+		return &proto.Q{Query: &proto.Q_Commit{Commit: v.ToProto()}}
+	case *TestFile:
+		return &proto.Q{Query: &proto.Q_TestFile{TestFile: &proto.TestFile{}}}
+	case *GeneratedFile:
+		return &proto.Q{Query: &proto.Q_GeneratedFile{GeneratedFile: &proto.GeneratedFile{}}}
+	case *SubToken:
+		return &proto.Q{Query: &proto.Q_SubToken{SubToken: v.ToProto()}}
+	case *Checksum:
+		return &proto.Q{Query: &proto.Q_Checksum{Checksum: v.ToProto()}}
+	case *FilenameFuzzy:
+		return &proto.Q{Query: &proto.Q_FilenameFuzzy{FilenameFuzzy: v.ToProto()}}
+	case *PathBoost:
+		return &proto.Q{Query: &proto.Q_PathBoost{PathBoost: v.ToProto()}}
+	case *Structural:
+		return &proto.Q{Query: &proto.Q_Structural{Structural: v.ToProto()}}
 	default:
 		// The following nodes do not have a proto representation:
 		// - caseQ: only used internally, not by the RPC layer
+		// - multilineQ: only used internally, not by the RPC layer
+		// - IndexAt: resolved by the snapshot layer before searching shards
+		// - SearchContext: expanded by the web server before searching shards
+		// - Rev: resolved by the web server before searching shards
 		panic(fmt.Sprintf("unknown query node %T", v))
 	}
 }
@@ -93,6 +116,24 @@ func QFromProto(p *proto.Q) (Q, error) {
 		return BranchFromProto(v.Branch), nil
 	case *proto.Q_Boost:
 		return BoostFromProto(v.Boost)
+	case *proto.Q_License:
+		return LicenseFromProto(v.License), nil
+	case *proto.Q_Commit:
+		return CommitFromProto(v.Commit), nil
+	case *proto.Q_TestFile:
+		return &TestFile{}, nil
+	case *proto.Q_GeneratedFile:
+		return &GeneratedFile{}, nil
+	case *proto.Q_SubToken:
+		return SubTokenFromProto(v.SubToken), nil
+	case *proto.Q_Checksum:
+		return ChecksumFromProto(v.Checksum), nil
+	case *proto.Q_FilenameFuzzy:
+		return FilenameFuzzyFromProto(v.FilenameFuzzy), nil
+	case *proto.Q_PathBoost:
+		return PathBoostFromProto(v.PathBoost), nil
+	case *proto.Q_Structural:
+		return StructuralFromProto(v.Structural), nil
 	default:
 		panic(fmt.Sprintf("unknown query node %T", p.Query))
 	}
@@ -108,6 +149,7 @@ func RegexpFromProto(p *proto.Regexp) (*Regexp, error) {
 		FileName:      p.GetFileName(),
 		Content:       p.GetContent(),
 		CaseSensitive: p.GetCaseSensitive(),
+		Multiline:     p.GetMultiline(),
 	}, nil
 }
 
@@ -117,6 +159,7 @@ func (r *Regexp) ToProto() *proto.Regexp {
 		FileName:      r.FileName,
 		Content:       r.Content,
 		CaseSensitive: r.CaseSensitive,
+		Multiline:     r.Multiline,
 	}
 }
 
@@ -147,6 +190,48 @@ func (l *Language) ToProto() *proto.Language {
 	return &proto.Language{Language: l.Language}
 }
 
+func LicenseFromProto(p *proto.License) *License {
+	return &License{
+		License: p.GetLicense(),
+	}
+}
+
+func (l *License) ToProto() *proto.License {
+	return &proto.License{License: l.License}
+}
+
+func ChecksumFromProto(p *proto.Checksum) *Checksum {
+	return &Checksum{
+		Prefix: p.GetPrefix(),
+	}
+}
+
+func (c *Checksum) ToProto() *proto.Checksum {
+	return &proto.Checksum{Prefix: c.Prefix}
+}
+
+func CommitFromProto(p *proto.Commit) *Commit {
+	c := &Commit{Author: p.GetAuthor()}
+	if p.GetAfter() != nil {
+		c.After = p.GetAfter().AsTime()
+	}
+	if p.GetBefore() != nil {
+		c.Before = p.GetBefore().AsTime()
+	}
+	return c
+}
+
+func (c *Commit) ToProto() *proto.Commit {
+	p := &proto.Commit{Author: c.Author}
+	if !c.After.IsZero() {
+		p.After = timestamppb.New(c.After)
+	}
+	if !c.Before.IsZero() {
+		p.Before = timestamppb.New(c.Before)
+	}
+	return p
+}
+
 func RepoFromProto(p *proto.Repo) (*Repo, error) {
 	r, err := regexp.Compile(p.GetRegexp())
 	if err != nil {
@@ -340,6 +425,60 @@ func (q *Substring) ToProto() *proto.Substring {
 	}
 }
 
+func SubTokenFromProto(p *proto.SubToken) *SubToken {
+	return &SubToken{
+		Pattern:       p.GetPattern(),
+		CaseSensitive: p.GetCaseSensitive(),
+	}
+}
+
+func (q *SubToken) ToProto() *proto.SubToken {
+	return &proto.SubToken{
+		Pattern:       q.Pattern,
+		CaseSensitive: q.CaseSensitive,
+	}
+}
+
+func FilenameFuzzyFromProto(p *proto.FilenameFuzzy) *FilenameFuzzy {
+	return &FilenameFuzzy{
+		Pattern:       p.GetPattern(),
+		CaseSensitive: p.GetCaseSensitive(),
+	}
+}
+
+func (q *FilenameFuzzy) ToProto() *proto.FilenameFuzzy {
+	return &proto.FilenameFuzzy{
+		Pattern:       q.Pattern,
+		CaseSensitive: q.CaseSensitive,
+	}
+}
+
+func PathBoostFromProto(p *proto.PathBoost) *PathBoost {
+	return &PathBoost{
+		Pattern: p.GetPattern(),
+		Boost:   p.GetBoost(),
+	}
+}
+
+func (q *PathBoost) ToProto() *proto.PathBoost {
+	return &proto.PathBoost{
+		Pattern: q.Pattern,
+		Boost:   q.Boost,
+	}
+}
+
+func StructuralFromProto(p *proto.Structural) *Structural {
+	return &Structural{
+		Pattern: p.GetPattern(),
+	}
+}
+
+func (q *Structural) ToProto() *proto.Structural {
+	return &proto.Structural{
+		Pattern: q.Pattern,
+	}
+}
+
 func OrFromProto(p *proto.Or) (*Or, error) {
 	children := make([]Q, len(p.GetChildren()))
 	for i, child := range p.GetChildren() {
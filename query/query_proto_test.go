@@ -3,6 +3,7 @@ package query
 import (
 	"regexp/syntax"
 	"testing"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/google/go-cmp/cmp"
@@ -17,6 +18,11 @@ func TestQueryRoundtrip(t *testing.T) {
 			Content:       true,
 			CaseSensitive: true,
 		},
+		&Regexp{
+			Regexp:    regexpMustParse("foo.*bar"),
+			Content:   true,
+			Multiline: true,
+		},
 		&Symbol{
 			Expr: &Language{
 				Language: "go",
@@ -25,6 +31,28 @@ func TestQueryRoundtrip(t *testing.T) {
 		&Language{
 			Language: "typescript",
 		},
+		&License{
+			License: "apache-2.0",
+		},
+		&Commit{
+			Author: "jane",
+			After:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		&TestFile{},
+		&GeneratedFile{},
+		&Checksum{
+			Prefix: "0123abc",
+		},
+		&SubToken{
+			Pattern:       "BarBaz",
+			CaseSensitive: true,
+		},
+		&FilenameFuzzy{
+			Pattern: "zkwbsrv",
+		},
+		&Structural{
+			Pattern: "foo(:[args])",
+		},
 		&Const{
 			Value: true,
 		},
@@ -87,6 +115,7 @@ func TestQueryRoundtrip(t *testing.T) {
 			},
 			Boost: 20,
 		},
+		&PathBoost{Pattern: "vendor/", Boost: 0.5},
 	}
 
 	for _, q := range testCases {
@@ -45,6 +45,25 @@ func LowerRegexp(r *syntax.Regexp) *syntax.Regexp {
 	return &newRE
 }
 
+// DotMatchesNewline returns a copy of r in which "." also matches newlines,
+// as if r had been parsed with the (?s) flag. It is used for multiline
+// regexps, see Regexp.Multiline.
+func DotMatchesNewline(r *syntax.Regexp) *syntax.Regexp {
+	newRE := *r
+	switch r.Op {
+	case syntax.OpAnyCharNotNL:
+		newRE.Op = syntax.OpAnyChar
+	default:
+		newRE.Sub = make([]*syntax.Regexp, len(newRE.Sub))
+		for i, s := range r.Sub {
+			newRE.Sub[i] = DotMatchesNewline(s)
+		}
+	}
+	newRE.Flags |= syntax.DotNL
+
+	return &newRE
+}
+
 // OptimizeRegexp converts capturing groups to non-capturing groups.
 // Returns original input if an error is encountered
 func OptimizeRegexp(re *syntax.Regexp, flags syntax.Flags) *syntax.Regexp {
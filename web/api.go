@@ -15,6 +15,7 @@
 package web
 
 import (
+	"html/template"
 	"time"
 
 	"github.com/sourcegraph/zoekt"
@@ -45,6 +46,32 @@ type ResultInput struct {
 	Stats       zoekt.Stats
 	Duration    time.Duration
 	FileMatches []*FileMatch
+
+	// Facets break down FileMatches, so users can narrow the query with a
+	// click.
+	Facets Facets
+
+	// Suggestions are queries similar to QueryStr which have results, shown
+	// if QueryStr has none.
+	Suggestions []string
+}
+
+// Facets holds the values of the facets shown next to the search results.
+type Facets struct {
+	Repos     []Facet
+	Languages []Facet
+	// Paths are directory prefixes, such as "cmd/".
+	Paths []Facet
+}
+
+// Facet is a value which occurs among the search results.
+type Facet struct {
+	Value string
+	// Count is the number of shown files with this value. It is 0 for repos
+	// which only have matches beyond the shown files.
+	Count int
+	// Query is the current query restricted to Value.
+	Query string
 }
 
 // FileMatch holds the per file data provided to search results template
@@ -61,11 +88,45 @@ type FileMatch struct {
 	Matches  []Match
 	URL      string
 
+	// Version is the commit the file was indexed at, and CommitDate its
+	// commit date. IndexTime is when the shard holding the file was built.
+	Version    string `json:",omitempty"`
+	CommitDate time.Time
+	IndexTime  time.Time
+
+	// Versions is set if the file differs between the matching branches. The
+	// first version is the one described by Matches and URL.
+	Versions []BranchVersion `json:",omitempty"`
+
+	// MatchCount is the number of matches in the file, including those
+	// left out of Matches by MaxMatchesPerFile. LineCount and FileSize
+	// describe the whole file.
+	MatchCount int `json:",omitempty"`
+	LineCount  int `json:",omitempty"`
+	FileSize   int `json:",omitempty"`
+
+	// Unindexed is set if the file is from a commit which is not indexed, and
+	// was searched with Server.UnindexedSearch.
+	Unindexed bool `json:",omitempty"`
+
+	// MatchesShown is the number of matches in Matches.
+	MatchesShown int `json:"-"`
+
 	// Don't expose to caller of JSON API
 	Score      float64 `json:"-"`
 	ScoreDebug string  `json:"-"`
 }
 
+// BranchVersion holds the matches within a version of a file, which is the
+// same on all of Branches.
+type BranchVersion struct {
+	// ID identifies the version in the results template.
+	ID       string `json:"-"`
+	Branches []string
+	Matches  []Match
+	URL      string
+}
+
 // Match holds the per line data provided to the search results template
 type Match struct {
 	URL      string
@@ -76,17 +137,40 @@ type Match struct {
 	Before    string `json:",omitempty"`
 	After     string `json:",omitempty"`
 
+	// BeforeLines and AfterLines split Before and After into numbered lines
+	// for the results template.
+	BeforeLines []ContextLine `json:"-"`
+	AfterLines  []ContextLine `json:"-"`
+
+	// Highlighted is the syntax highlighted line with its fragments, if
+	// Server.HighlightStyle is set.
+	Highlighted template.HTML `json:"-"`
+
 	// Don't expose to caller of JSON API
 	Score      float64 `json:"-"`
 	ScoreDebug string  `json:"-"`
 }
 
+// ContextLine holds a line shown around a match in the results template.
+type ContextLine struct {
+	LineNum int
+	Line    string
+
+	// Highlighted is the syntax highlighted Line, if Server.HighlightStyle
+	// is set.
+	Highlighted template.HTML
+}
+
 // Fragment holds data of a single contiguous match within in a line
 // for the results template.
 type Fragment struct {
 	Pre   string
 	Match string
 	Post  string
+
+	// SymbolInfo is set if Match is a symbol definition, eg. for sym:
+	// queries.
+	SymbolInfo *zoekt.Symbol `json:",omitempty"`
 }
 
 // SearchBoxInput is provided to the SearchBox template.
@@ -113,16 +197,30 @@ type Branch struct {
 
 // Repository holds the metadata for an indexed repository.
 type Repository struct {
-	Name      string
-	URL       string
-	IndexTime time.Time
-	Branches  []Branch
-	Files     int64
+	Name        string
+	URL         string
+	Description string `json:",omitempty"`
+	Homepage    string `json:",omitempty"`
+	IndexTime   time.Time
+	Branches    []Branch
+	Files       int64
 
 	// Total amount of content bytes.
 	Size int64
 	// Total resident RAM usage in bytes.
 	MemorySize int64
+
+	// Languages are the languages of the content, most bytes first.
+	Languages []Language `json:",omitempty"`
+}
+
+// Language is the share of a language in the content of a repository, see
+// zoekt.RepoStats.Languages.
+type Language struct {
+	Name    string
+	Percent float64
+	// Color is the HTML color code of the language, like on GitHub.
+	Color string
 }
 
 // PrintInput is provided to the server.Print template.
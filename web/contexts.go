@@ -0,0 +1,216 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// SearchContext is a named set of repositories, eg. the ones owned by a team.
+// Queries search it with context:NAME.
+type SearchContext struct {
+	Name        string
+	Description string `json:",omitempty"`
+
+	// Repos are regular expressions matching repository names.
+	Repos []string `json:",omitempty"`
+
+	// RepoIDs are repository IDs.
+	RepoIDs []uint32 `json:",omitempty"`
+}
+
+// query returns the query matching the repositories of c.
+func (c *SearchContext) query() (query.Q, error) {
+	var qs []query.Q
+	for _, r := range c.Repos {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("search context %q: %w", c.Name, err)
+		}
+		qs = append(qs, &query.RepoRegexp{Regexp: re})
+	}
+	if len(c.RepoIDs) > 0 {
+		qs = append(qs, &query.RepoIDs{Repos: roaring.BitmapOf(c.RepoIDs...)})
+	}
+	if len(qs) == 0 {
+		return nil, fmt.Errorf("search context %q has no repositories", c.Name)
+	}
+	return query.NewOr(qs...), nil
+}
+
+// SearchContexts holds the search contexts of a server. It is safe for
+// concurrent use, and the contexts can be replaced while searches run.
+type SearchContexts struct {
+	mu       sync.Mutex
+	contexts []SearchContext
+	queries  map[string]query.Q
+}
+
+// Set replaces the search contexts with contexts. It returns an error and
+// keeps the current contexts if one of them is invalid.
+func (s *SearchContexts) Set(contexts []SearchContext) error {
+	queries := make(map[string]query.Q, len(contexts))
+	for i := range contexts {
+		c := &contexts[i]
+		if c.Name == "" {
+			return fmt.Errorf("search context %d has no name", i)
+		}
+		if _, ok := queries[c.Name]; ok {
+			return fmt.Errorf("search context %q is defined twice", c.Name)
+		}
+		q, err := c.query()
+		if err != nil {
+			return err
+		}
+		queries[c.Name] = q
+	}
+
+	sorted := append([]SearchContext(nil), contexts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contexts = sorted
+	s.queries = queries
+	return nil
+}
+
+// Load replaces the search contexts with the ones in the JSON file name,
+// which holds a list of SearchContext:
+//
+//	[{"Name": "payments", "Repos": ["^github\\.com/acme/pay"], "RepoIDs": [42]}]
+func (s *SearchContexts) Load(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var contexts []SearchContext
+	if err := json.Unmarshal(data, &contexts); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return s.Set(contexts)
+}
+
+// List returns the search contexts, sorted by name.
+func (s *SearchContexts) List() []SearchContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contexts
+}
+
+// Expand replaces the context: atoms of q with the repositories of their
+// search contexts. It returns an error for contexts which are not defined.
+func (s *SearchContexts) Expand(q query.Q) (query.Q, error) {
+	s.mu.Lock()
+	queries := s.queries
+	s.mu.Unlock()
+
+	var err error
+	q = query.Map(q, func(q query.Q) query.Q {
+		c, ok := q.(*query.SearchContext)
+		if !ok {
+			return q
+		}
+		expanded, ok := queries[c.Name]
+		if !ok && err == nil {
+			err = fmt.Errorf("unknown search context %q", c.Name)
+		}
+		if !ok {
+			return q
+		}
+		return expanded
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query.Simplify(q), nil
+}
+
+// serveSearchContexts lists the search contexts as JSON.
+func (s *Server) serveSearchContexts(w http.ResponseWriter, r *http.Request) {
+	contexts := s.SearchContexts.List()
+	if contexts == nil {
+		contexts = []SearchContext{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(contexts)
+}
+
+// NewSearchContextSearcher returns a searcher which expands the context:
+// atoms of every query with contexts before passing it on to s.
+func NewSearchContextSearcher(s zoekt.Streamer, contexts *SearchContexts) zoekt.Streamer {
+	return searchContextSearcher{Streamer: s, contexts: contexts}
+}
+
+type searchContextSearcher struct {
+	zoekt.Streamer
+	contexts *SearchContexts
+}
+
+func (s searchContextSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	q, err := s.contexts.Expand(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s searchContextSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	q, err := s.contexts.Expand(q)
+	if err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s searchContextSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	expanded := make([]query.Q, len(qs))
+	for i, q := range qs {
+		q, err := s.contexts.Expand(q)
+		if err != nil {
+			return nil, err
+		}
+		expanded[i] = q
+	}
+	return zoekt.BatchSearch(ctx, s.Streamer, expanded, opts)
+}
+
+func (s searchContextSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	q, err := s.contexts.Expand(q)
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s searchContextSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	q, err := s.contexts.Expand(q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.List(ctx, q, opts)
+}
+
+func (s searchContextSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	q, err := s.contexts.Expand(q)
+	if err != nil {
+		return err
+	}
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, sender)
+}
+
+func (s searchContextSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// NewCostLimitSearcher returns a searcher which rejects searches whose
+// estimated cost exceeds maxCost with a *zoekt.QueryTooExpensiveError, so
+// that a few overly broad queries can't saturate the CPUs. Searches which set
+// a SearchOptions.MaxEstimatedCost of their own keep it.
+func NewCostLimitSearcher(s zoekt.Streamer, maxCost int64) zoekt.Streamer {
+	return costLimitSearcher{Streamer: s, maxCost: maxCost}
+}
+
+type costLimitSearcher struct {
+	zoekt.Streamer
+	maxCost int64
+}
+
+// limitOpts returns a copy of opts limited to s.maxCost.
+func (s costLimitSearcher) limitOpts(opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	if opts.MaxEstimatedCost != 0 {
+		return opts
+	}
+	o := *opts
+	o.MaxEstimatedCost = s.maxCost
+	return &o
+}
+
+func (s costLimitSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return s.Streamer.Search(ctx, q, s.limitOpts(opts))
+}
+
+func (s costLimitSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return s.Streamer.StreamSearch(ctx, q, s.limitOpts(opts), sender)
+}
+
+func (s costLimitSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	return zoekt.BatchSearch(ctx, s.Streamer, qs, s.limitOpts(opts))
+}
+
+func (s costLimitSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s costLimitSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+func (s costLimitSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, sender)
+}
@@ -18,21 +18,30 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/index"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/shards"
 	"github.com/sourcegraph/zoekt/query"
 )
 
@@ -83,10 +92,16 @@ func (a adapter) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.Search
 	return nil
 }
 
+func (a adapter) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	return zoekt.Explain(ctx, a.Searcher, q, opts)
+}
+
 func TestBasic(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name:                 "name",
 		URL:                  "repo-url",
+		Description:          "a repo for carrying water",
+		Homepage:             "https://example.com/water",
 		CommitURLTemplate:    `{{ URLJoinPath "https://github.com/org/repo/commit/" .Version}}`,
 		FileURLTemplate:      `{{ URLJoinPath "https://github.com/org/repo/blob/" .Version .Path}}`,
 		LineFragmentTemplate: "#L{{.LineNumber}}",
@@ -133,6 +148,8 @@ func TestBasic(t *testing.T) {
 			"Found 1 repositories",
 			nowStr,
 			"repo-url\">name",
+			`<a href="https://example.com/water">homepage</a>`,
+			"a repo for carrying water",
 			"1 files (45B)",
 		},
 		"/search?q=magic": {
@@ -149,6 +166,43 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+func TestArchiveMemberURL(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name:                 "name",
+		FileURLTemplate:      `{{ URLJoinPath "https://github.com/org/repo/blob/" .Version .Path}}`,
+		LineFragmentTemplate: "#L{{.LineNumber}}",
+		Branches:             []zoekt.RepositoryBranch{{Name: "master", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{
+		Name:     "lib/foo.jar" + index.ArchiveSeparator + "com/foo/Bar.java",
+		Content:  []byte("class Bar {}"),
+		Branches: []string{"master"},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	mux, err := NewMux(&Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	})
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// The code host can't show files inside archives, so they link to the
+	// print page.
+	checkNeedles(t, ts, "/search?q=Bar", []string{
+		`href="print?b=master&amp;f=lib%2Ffoo.jar%21com%2Ffoo%2FBar.java&amp;q=Bar&amp;r=name#l1"`,
+	})
+	checkNeedles(t, ts, "/print?r=name&f=lib/foo.jar!com/foo/Bar.java", []string{"class Bar {}"})
+}
+
 func TestPrint(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name:                 "name",
@@ -327,6 +381,182 @@ func TestFormatJson(t *testing.T) {
 	checkResultMatches(t, ts, "/search?q=water&format=json", expected)
 }
 
+func TestFormatNDJSON(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	for _, name := range []string{"f1", "f2", "f3"} {
+		if err := b.Add(index.Document{Name: name, Content: []byte("water")}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/search?q=water&format=ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if got := res.Header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("got Content-Type %q, want application/x-ndjson", got)
+	}
+
+	var files []string
+	decoder := json.NewDecoder(res.Body)
+	for decoder.More() {
+		var f FileMatch
+		if err := decoder.Decode(&f); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f.FileName)
+	}
+	sort.Strings(files)
+	if want := []string{"f1", "f2", "f3"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+
+	if code := getHttpStatusCode(t, ts, "/search?q=water&format=xml"); code != http.StatusTeapot {
+		t.Errorf("unknown format: got status %d, want %d", code, http.StatusTeapot)
+	}
+}
+
+func TestOpenSearch(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "f1", Content: []byte("water")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/opensearch.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var desc openSearchDescription
+	if err := xml.NewDecoder(res.Body).Decode(&desc); err != nil {
+		t.Fatal(err)
+	}
+	templates := map[string]string{}
+	for _, u := range desc.URLs {
+		templates[u.Type] = u.Template
+	}
+	if got, want := templates["text/html"], ts.URL+"/search?q={searchTerms}&num={count?}"; got != want {
+		t.Errorf("got HTML template %q, want %q", got, want)
+	}
+	if got := templates["application/json"]; !strings.HasSuffix(got, "&format=json") {
+		t.Errorf("got JSON template %q, want format=json", got)
+	}
+
+	// Clients which leave out optional parameters send them empty.
+	if code := getHttpStatusCode(t, ts, "/search?q=water&num="); code != http.StatusOK {
+		t.Errorf("empty num: got status %d, want %d", code, http.StatusOK)
+	}
+
+	checkNeedles(t, ts, "/", []string{`href="opensearch.xml"`})
+}
+
+func TestFormatJsonSymbolInfo(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name:     "name",
+		URL:      "repo-url",
+		Branches: []zoekt.RepositoryBranch{{Name: "master", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{
+		Name:     "f2",
+		Content:  []byte("func (b *Bar) foo(x int) {}"),
+		Branches: []string{"master"},
+		Symbols:  []index.DocumentSection{{Start: 14, End: 17}},
+		SymbolsMetaData: []*zoekt.Symbol{{
+			Sym:        "foo",
+			Kind:       "method",
+			Parent:     "Bar",
+			ParentKind: "struct",
+			Signature:  "(x int)",
+		}},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher: s,
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	expected := Expectation{
+		"json symbol info",
+		FileMatch{
+			FileName: "f2",
+			Repo:     "name",
+			Matches: []Match{
+				{
+					FileName: "f2",
+					LineNum:  1,
+					Fragments: []Fragment{
+						{
+							Pre:   "func (b *Bar) ",
+							Match: "foo",
+							Post:  "(x int) {}",
+							SymbolInfo: &zoekt.Symbol{
+								Sym:        "foo",
+								Kind:       "method",
+								Parent:     "Bar",
+								ParentKind: "struct",
+								Signature:  "(x int)",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	checkResultMatches(t, ts, "/search?q=sym:foo&format=json", expected)
+	checkNeedles(t, ts, "/search?q=sym:foo", []string{
+		`title="(x int)">method foo in struct Bar</span>`,
+	})
+}
+
 func TestContextLines(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name:     "name",
@@ -651,6 +881,46 @@ func checkResultMatches(t *testing.T, ts *httptest.Server, req string, expected
 		cmp.Diff(expected.fileMatch.Matches, result.Result.FileMatches[0].Matches))
 }
 
+func TestHighlightHTML(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{
+		Name:    "f1.go",
+		Content: []byte("package main\n\nfunc needle() string { return \"<x>\" }\n"),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher:       s,
+		Top:            Top,
+		HTML:           true,
+		HighlightStyle: "github",
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=needle&ctx=2", []string{
+		`<u>1</u>- </span><span style="color:#000000;font-weight:bold">package</span>`,
+		`<span style="color:#000000;font-weight:bold">func</span> <b><span style="color:#990000;font-weight:bold">needle</span></b>`,
+		`<span style="color:#dd1144">&#34;&lt;x&gt;&#34;</span>`,
+	})
+
+	if _, err := NewMux(&Server{Searcher: s, Top: Top, HighlightStyle: "bogus"}); err == nil {
+		t.Error("NewMux: got no error for an unknown highlight style")
+	}
+}
+
 func TestContextLinesMustBeValid(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name:     "name",
@@ -682,7 +952,7 @@ func TestContextLinesMustBeValid(t *testing.T) {
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	// Don't care about ctx if format is not json
+	// The HTML interface also accepts ctx.
 	code := getHttpStatusCode(t, ts, "/search?q=water&ctx=10")
 	if code != 200 {
 		t.Errorf("Expected 200 but got %v", code)
@@ -697,27 +967,22 @@ func TestContextLinesMustBeValid(t *testing.T) {
 	}
 }
 
-func getHttpStatusCode(t *testing.T, ts *httptest.Server, req string) int {
-	res, err := http.Get(ts.URL + req)
+func TestContextLinesHTML(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("NewShardBuilder: %v", err)
 	}
-	return res.StatusCode
-}
-
-type crashSearcher struct {
-	zoekt.Streamer
-}
-
-func (s *crashSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
-	res := zoekt.SearchResult{}
-	res.Stats.Crashes = 1
-	return &res, nil
-}
-
-func TestCrash(t *testing.T) {
+	if err := b.Add(index.Document{
+		Name:    "f1",
+		Content: []byte("one\ntwo\nthree\nfour\nfive\n"),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s := searcherForTest(t, b)
 	srv := Server{
-		Searcher: &crashSearcher{},
+		Searcher: s,
 		Top:      Top,
 		HTML:     true,
 	}
@@ -730,44 +995,72 @@ func TestCrash(t *testing.T) {
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	res, err := http.Get(ts.URL + "/search?q=water")
+	checkNeedles(t, ts, "/search?q=three&num=20&ctx=1", []string{
+		`<u>2</u>- </span>two</pre>`,
+		`<u>3</u></a>: </span><b>three</b>`,
+		`<u>4</u>- </span>four</pre>`,
+		`name="ctx" min="0" max="10" value="1"`,
+		`<a href="search?q=%28three%29%20repo%3a%5ename%24&num=20&ctx=1">name</a>`,
+	})
+
+	res, err := http.Get(ts.URL + "/search?q=three")
 	if err != nil {
 		t.Fatal(err)
 	}
-	resultBytes, err := io.ReadAll(res.Body)
-	res.Body.Close()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if strings.Contains(string(body), "</span>two</pre>") {
+		t.Errorf("got context lines without ctx: %s", body)
+	}
 
-	result := string(resultBytes)
-	if want := "1 shards crashed"; !strings.Contains(result, want) {
-		t.Errorf("result did not have %q: %s", want, result)
+	// The context of "two" and "four" overlaps, every line is shown once
+	// and in order.
+	res, err = http.Get(ts.URL + "/search?q=" + url.QueryEscape("four|two") + "&ctx=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err = io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, m := range regexp.MustCompile(`<u>(\d+)</u>`).FindAllStringSubmatch(string(body), -1) {
+		lines = append(lines, m[1])
+	}
+	if got, want := strings.Join(lines, ","), "1,2,3,4,5"; got != want {
+		t.Errorf("got lines %s, want %s: %s", got, want, body)
 	}
 }
 
-func TestHostCustomization(t *testing.T) {
+func TestBranchSelectorHTML(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name: "name",
+		Branches: []zoekt.RepositoryBranch{
+			{Name: "main", Version: "v-main"},
+			{Name: "dev", Version: "v-dev"},
+		},
 	})
 	if err != nil {
 		t.Fatalf("NewShardBuilder: %v", err)
 	}
-	if err := b.Add(index.Document{
-		Name:    "file",
-		Content: []byte("bla"),
-	}); err != nil {
-		t.Fatalf("Add: %v", err)
+	for _, doc := range []index.Document{
+		{Name: "f1", Content: []byte("needle on main\n"), Branches: []string{"main"}},
+		{Name: "f1", Content: []byte("needle on dev\n"), Branches: []string{"dev"}},
+		{Name: "f2", Content: []byte("needle everywhere\n"), Branches: []string{"main", "dev"}},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
 	}
-
 	s := searcherForTest(t, b)
 	srv := Server{
 		Searcher: s,
 		Top:      Top,
 		HTML:     true,
-		HostCustomQueries: map[string]string{
-			"myproject.io": "r:myproject",
-		},
 	}
 
 	mux, err := NewMux(&srv)
@@ -778,40 +1071,207 @@ func TestHostCustomization(t *testing.T) {
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
-	req, err := http.NewRequest("GET", ts.URL, &bytes.Buffer{})
-	if err != nil {
-		t.Fatalf("NewRequest: %v", err)
-	}
-	req.Host = "myproject.io"
-	res, err := (&http.Client{}).Do(req)
-	if err != nil {
-		t.Fatalf("Do(%v): %v", req, err)
-	}
-	resultBytes, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		t.Fatalf("ReadAll: %v", err)
-	}
-
-	if got, want := string(resultBytes), "r:myproject"; !strings.Contains(got, want) {
-		t.Fatalf("got %s, want substring %q", got, want)
-	}
+	checkNeedles(t, ts, "/search?q=needle", []string{
+		`<a name="name:f2" class="result">`,
+		`onchange="zoektShowVersion(this)"`,
+		`<option value="name:f1@0">main</option>`,
+		`<option value="name:f1@1">dev</option>`,
+		`<tbody id="name:f1@0" class="branch-version">`,
+		`<tbody id="name:f1@1" class="branch-version" style="display: none">`,
+		"<b>needle</b> on dev",
+	})
 }
 
-func TestDupResult(t *testing.T) {
+func TestIndexAgeHTML(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name: "name",
+		Branches: []zoekt.RepositoryBranch{
+			{Name: "main", Version: "0123456789abcdef", CommitDate: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		},
 	})
 	if err != nil {
 		t.Fatalf("NewShardBuilder: %v", err)
 	}
-
-	for i := 0; i < 2; i++ {
-		if err := b.Add(index.Document{
-			Name:    fmt.Sprintf("file%d", i),
-			Content: []byte("bla"),
-		}); err != nil {
-			t.Fatalf("Add: %v", err)
+	b.IndexTime = time.Now().Add(-2*time.Hour - time.Minute)
+	if err := b.Add(index.Document{Name: "f1", Content: []byte("needle\n"), Branches: []string{"main"}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher: s,
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=needle", []string{
+		`title="committed 2024-03-01 12:30"`,
+		"indexed 2h ago @ 0123456",
+	})
+}
+
+// maxMatchesSearcher caps the matches per file, like a backend which
+// truncates its results.
+type maxMatchesSearcher struct {
+	zoekt.Streamer
+	max int
+}
+
+func (s *maxMatchesSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	o := *opts
+	o.MaxMatchesPerFile = s.max
+	return s.Streamer.Search(ctx, q, &o)
+}
+
+func TestMatchCountHTML(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "f1", Content: bytes.Repeat([]byte("needle\n"), 87)}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	srv := Server{
+		Searcher: &maxMatchesSearcher{Streamer: searcherForTest(t, b), max: 10},
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=needle", []string{
+		"87 matches in 87 lines (showing 10)",
+	})
+}
+
+func getHttpStatusCode(t *testing.T, ts *httptest.Server, req string) int {
+	res, err := http.Get(ts.URL + req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode
+}
+
+type crashSearcher struct {
+	zoekt.Streamer
+}
+
+func (s *crashSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	res := zoekt.SearchResult{}
+	res.Stats.Crashes = 1
+	return &res, nil
+}
+
+func TestCrash(t *testing.T) {
+	srv := Server{
+		Searcher: &crashSearcher{},
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/search?q=water")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultBytes, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := string(resultBytes)
+	if want := "1 shards crashed"; !strings.Contains(result, want) {
+		t.Errorf("result did not have %q: %s", want, result)
+	}
+}
+
+func TestHostCustomization(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{
+		Name:    "file",
+		Content: []byte("bla"),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher: s,
+		Top:      Top,
+		HTML:     true,
+		HostCustomQueries: map[string]string{
+			"myproject.io": "r:myproject",
+		},
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Host = "myproject.io"
+	res, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Fatalf("Do(%v): %v", req, err)
+	}
+	resultBytes, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	if got, want := string(resultBytes), "r:myproject"; !strings.Contains(got, want) {
+		t.Fatalf("got %s, want substring %q", got, want)
+	}
+}
+
+func TestDupResult(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Add(index.Document{
+			Name:    fmt.Sprintf("file%d", i),
+			Content: []byte("bla"),
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
 		}
 	}
 	s := searcherForTest(t, b)
@@ -848,6 +1308,131 @@ func TestDupResult(t *testing.T) {
 	}
 }
 
+func TestFacets(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+
+	for _, doc := range []index.Document{
+		{Name: "src/cmd/main.go", Content: []byte("package main // needle")},
+		{Name: "src/cmd/flags.go", Content: []byte("package main // needle")},
+		{Name: "src/web/app.py", Content: []byte("needle = 1")},
+		{Name: "README", Content: []byte("no needle here")},
+	} {
+		if err := b.Add(doc); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	s := searcherForTest(t, b)
+	srv := Server{
+		Searcher: s,
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=needle&num=20", []string{
+		`<h6>Repository</h6>`,
+		`<a href="search?q=%28needle%29%20repo%3a%5ename%24&num=20">name</a> <span class="badge">4</span>`,
+		`<h6>Language</h6>`,
+		`<a href="search?q=%28needle%29%20lang%3aGo&num=20">Go</a> <span class="badge">2</span>`,
+		`<a href="search?q=%28needle%29%20lang%3aPython&num=20">Python</a> <span class="badge">1</span>`,
+		`<h6>Path</h6>`,
+		// All results below src/ are broken down further.
+		`<a href="search?q=%28needle%29%20file%3a%5esrc%2fcmd%2f&num=20">src/cmd/</a> <span class="badge">2</span>`,
+		`<a href="search?q=%28needle%29%20file%3a%5esrc%2fweb%2f&num=20">src/web/</a> <span class="badge">1</span>`,
+	})
+}
+
+// suggestSearcher suggests replacing "neelde" with "needle", like the
+// sharded searcher would.
+type suggestSearcher struct {
+	zoekt.Streamer
+}
+
+func (s suggestSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	sr, err := s.Streamer.Search(ctx, q, opts)
+	if err == nil && len(sr.Files) == 0 && opts.MaxSuggestions > 0 {
+		sr.Suggestions = []zoekt.Suggestion{{Pattern: "neelde", Replacement: "needle"}}
+	}
+	return sr, err
+}
+
+func TestSuggestions(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "f", Content: []byte("needle")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	srv := Server{
+		Searcher: suggestSearcher{searcherForTest(t, b)},
+		Top:      Top,
+		HTML:     true,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=neelde+lang:go&num=20", []string{
+		`Did you mean`,
+		`<a href="search?q=needle%20lang%3ago&num=20"><i>needle lang:go</i></a>?`,
+	})
+	checkNeedles(t, ts, "/search?q=needle&num=20", []string{
+		`Found 1 results in 1 files`,
+	})
+}
+
+func TestComputeFacets(t *testing.T) {
+	files := []zoekt.FileMatch{
+		{Repository: "a", FileName: "x/1.go", Language: "Go"},
+		{Repository: "a", FileName: "y/2.go", Language: "Go"},
+		{Repository: "b.com/c", FileName: "x/3 4.txt", Language: "Text"},
+		{Repository: "b.com/c", FileName: "5"},
+		{Repository: "b.com/c", FileName: "6"},
+	}
+
+	// "d" only has matches beyond the shown files.
+	repos := []string{"a", "b.com/c", "d"}
+
+	want := Facets{
+		Repos: []Facet{
+			{Value: "b.com/c", Count: 3, Query: `(needle or haystack) repo:"^b\\.com/c$"`},
+			{Value: "a", Count: 2, Query: `(needle or haystack) repo:^a$`},
+			{Value: "d", Count: 0, Query: `(needle or haystack) repo:^d$`},
+		},
+		Languages: []Facet{
+			{Value: "Go", Count: 2, Query: `(needle or haystack) lang:Go`},
+			{Value: "Text", Count: 1, Query: `(needle or haystack) lang:Text`},
+		},
+		Paths: []Facet{
+			{Value: "x/", Count: 2, Query: `(needle or haystack) file:^x/`},
+			{Value: "y/", Count: 1, Query: `(needle or haystack) file:^y/`},
+		},
+	}
+	if d := cmp.Diff(want, computeFacets("needle or haystack ", files, repos)); d != "" {
+		t.Fatalf("-want, +got: %s", d)
+	}
+}
+
 func TestTruncateLine(t *testing.T) {
 	b, err := index.NewShardBuilder(&zoekt.Repository{
 		Name: "name",
@@ -963,20 +1548,878 @@ func TestHealthz(t *testing.T) {
 	}
 }
 
-func assertResults(t *testing.T, files []zoekt.FileMatch, want string) {
-	t.Helper()
+func TestReadyzLameDuck(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "file", Content: []byte("bla")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
 
-	var lines []string
-	for _, fm := range files {
-		for _, cm := range fm.ChunkMatches {
-			lines = append(lines, fmt.Sprintf("%s: %s", fm.FileName, string(cm.Content)))
-		}
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
 	}
-	sort.Strings(lines)
-	got := strings.TrimSpace(strings.Join(lines, "\n"))
-	want = strings.TrimSpace(want)
 
-	if d := cmp.Diff(want, got); d != "" {
-		t.Fatalf("unexpected results (-want, +got):\n%s", d)
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	checkStatus := func(path string, want int) {
+		t.Helper()
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", path, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s: got status %d, want %d", path, res.StatusCode, want)
+		}
+	}
+
+	checkStatus("/livez", http.StatusOK)
+	checkStatus("/readyz", http.StatusOK)
+
+	srv.EnterLameDuck()
+
+	checkStatus("/livez", http.StatusOK)
+	checkStatus("/readyz", http.StatusServiceUnavailable)
+	checkStatus("/healthz", http.StatusOK)
+}
+
+type countingSearcher struct {
+	zoekt.Streamer
+	searches atomic.Int64
+}
+
+func (s *countingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.searches.Add(1)
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func TestReadyzSearchesUntilReady(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+
+	searcher := &countingSearcher{Streamer: searcherForTest(t, b)}
+	srv := Server{
+		Searcher: searcher,
+		Top:      Top,
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	for range 3 {
+		res, err := http.Get(ts.URL + "/readyz")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+		}
+	}
+
+	if got := searcher.searches.Load(); got != 1 {
+		t.Fatalf("got %d searches, want 1", got)
+	}
+}
+
+func assertResults(t *testing.T, files []zoekt.FileMatch, want string) {
+	t.Helper()
+
+	var lines []string
+	for _, fm := range files {
+		for _, cm := range fm.ChunkMatches {
+			lines = append(lines, fmt.Sprintf("%s: %s", fm.FileName, string(cm.Content)))
+		}
+	}
+	sort.Strings(lines)
+	got := strings.TrimSpace(strings.Join(lines, "\n"))
+	want = strings.TrimSpace(want)
+
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("unexpected results (-want, +got):\n%s", d)
+	}
+}
+
+func TestRerank(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	for _, d := range []index.Document{
+		{Name: "f1", Content: []byte("water water water")},
+		{Name: "f2", Content: []byte("some water")},
+	} {
+		if err := b.Add(d); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	// The rerank service prefers f2, which zoekt ranks last.
+	rerank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args rerankRequest
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if args.Query != "water" {
+			http.Error(w, "unexpected query "+args.Query, http.StatusBadRequest)
+			return
+		}
+		var reply rerankReply
+		for _, c := range args.Candidates {
+			score := 1.0
+			if c.FileName == "f2" {
+				score = 2
+			}
+			reply.Scores = append(reply.Scores, score)
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+	defer rerank.Close()
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	fileNames := func() []string {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/search?q=water&format=json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var result ApiSearchResult
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range result.Result.FileMatches {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	if got, want := fileNames(), []string{"f1", "f2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("without reranker: got %v, want %v", got, want)
+	}
+
+	srv.Reranker = NewHTTPReranker(rerank.URL, time.Second)
+	if got, want := fileNames(), []string{"f2", "f1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("with reranker: got %v, want %v", got, want)
+	}
+
+	// A failing rerank service falls back to zoekt's ranking.
+	srv.Reranker = NewHTTPReranker(ts.URL+"/does-not-exist", time.Second)
+	if got, want := fileNames(), []string{"f1", "f2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("with failing reranker: got %v, want %v", got, want)
+	}
+}
+
+func TestRerankSnippet(t *testing.T) {
+	// The limit falls into the middle of a rune, which must be dropped.
+	long := "x" + strings.Repeat("é", maxSnippetBytes)
+	got := snippet(&zoekt.FileMatch{LineMatches: []zoekt.LineMatch{{Line: []byte(long)}}})
+	if len(got) != maxSnippetBytes-1 || !utf8.ValidString(got) {
+		t.Fatalf("got snippet of %d bytes, valid UTF-8 %v, want %d valid bytes", len(got), utf8.ValidString(got), maxSnippetBytes-1)
+	}
+
+	got = snippet(&zoekt.FileMatch{ChunkMatches: []zoekt.ChunkMatch{
+		{Content: []byte("first\n")},
+		{Content: []byte(strings.Repeat("x", maxSnippetBytes))},
+	}})
+	if !strings.HasPrefix(got, "first\nxxx") || len(got) != maxSnippetBytes {
+		t.Fatalf("got snippet of %d bytes starting with %q", len(got), got[:10])
+	}
+}
+
+func TestExplain(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{Name: "name"})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	if err := b.Add(index.Document{Name: "f1", Content: []byte("to carry water in the no later bla")}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	explain := func(q string) (*ExplainResult, int) {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/debug/explain?q=" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, res.StatusCode
+		}
+
+		var result ExplainResult
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return &result, res.StatusCode
+	}
+
+	result, _ := explain("water+(r:name+or+f:bla)")
+	// The content-or-filename atom is split up.
+	if want := "and\n  or\n    file_substr:\"water\"\n    content_substr:\"water\"\n  or\n    repo:name\n    file_substr:\"bla\"\n"; result.Tree != want {
+		t.Errorf("got tree:\n%s\nwant:\n%s", result.Tree, want)
+	}
+	if result.ShardsTotal != 1 || result.ShardsSkipped != 0 || len(result.Explanation.Shards) != 1 {
+		t.Fatalf("got %+v, want one searched shard", result)
+	}
+	se := result.Explanation.Shards[0]
+	if se.Query != `substr:"water"` || se.ScansAllDocuments || se.Cost == 0 {
+		t.Errorf("got shard explanation %+v, want the repo: atom simplified away and a cost", se)
+	}
+	wantAtoms := []zoekt.AtomExplanation{
+		{Atom: `file_substr:"water"`, Method: "ngram, no matches (freq=0)"},
+		{Atom: `content_substr:"water"`, Method: "ngram", PostingBytes: se.Cost},
+	}
+	if d := cmp.Diff(wantAtoms, se.Atoms); d != "" {
+		t.Errorf("atoms mismatch (-want +got):\n%s", d)
+	}
+
+	for q, reason := range map[string]string{
+		"r:other+water": "simplifies to false",
+		"nomatch":       "no matching ngrams",
+	} {
+		result, _ := explain(q)
+		if se := result.Explanation.Shards[0]; !se.Skipped || !strings.Contains(se.SkipReason, reason) {
+			t.Errorf("%s: got %+v, want skipped because %s", q, se, reason)
+		}
+	}
+
+	// A regexp without ngrams scans every document.
+	result, _ = explain(`/./`)
+	if se := result.Explanation.Shards[0]; !se.ScansAllDocuments || se.Cost != int64(len("to carry water in the no later bla")) {
+		t.Errorf("got %+v, want a scan of all content", se)
+	}
+
+	for _, q := range []string{"", "(foo"} {
+		if _, code := explain(q); code != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", q, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRepoFilterHeader(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"repo-a", "repo-b"} {
+		b, err := index.NewBuilder(index.Options{
+			IndexDir:              dir,
+			RepositoryDescription: zoekt.Repository{ID: uint32(i + 1), Name: name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFile("f", []byte("needle")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	searcher, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	srv := Server{
+		Searcher:         searcher,
+		Top:              Top,
+		HTML:             true,
+		RPC:              true,
+		RepoFilterHeader: "X-Repo-IDs",
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, header, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != "" {
+			req.Header.Set("X-Repo-IDs", header)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(b)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// allowed is in the response if repo-a is allowed, denied is never in
+		// the response.
+		allowed, denied string
+	}{
+		{"search", "GET", "/search?q=needle&format=json", "", "repo-a", "repo-b"},
+		{"list", "GET", "/search?q=r:repo&format=json", "", "repo-a", "repo-b"},
+		{"api", "POST", "/api/search", `{"Q": "needle"}`, "repo-a", "repo-b"},
+		{"explain", "GET", "/debug/explain?q=needle", "", "repo-a", "repo-b"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, header := range []string{"1", ""} {
+				code, body := do(tc.method, tc.path, header, tc.body)
+				if code != http.StatusOK {
+					t.Fatalf("header %q: got %d: %s", header, code, body)
+				}
+				if strings.Contains(body, tc.denied) {
+					t.Errorf("header %q: got %q: %s", header, tc.denied, body)
+				}
+				if header != "" && !strings.Contains(body, tc.allowed) {
+					t.Errorf("header %q: want %q: %s", header, tc.allowed, body)
+				}
+			}
+		})
+	}
+
+	// Files of other repositories can't be printed.
+	if code, body := do("GET", "/print?r=repo-b&f=f", "2", ""); code != http.StatusOK || !strings.Contains(body, "needle") {
+		t.Errorf("print allowed: got %d: %s", code, body)
+	}
+	if code, body := do("GET", "/print?r=repo-b&f=f", "1", ""); code == http.StatusOK {
+		t.Errorf("print denied: got %d: %s", code, body)
+	}
+
+	if code, _ := do("GET", "/search?q=needle", "1,x", ""); code != http.StatusBadRequest {
+		t.Errorf("invalid header: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// The repository stats of the search box and /about don't count other
+	// repositories, also not through the cache of the stats.
+	for _, path := range []string{"/", "/about"} {
+		for _, tc := range []struct{ header, want string }{
+			{"1,2", "from 2 repositories"},
+			{"1", "from 1 repositories"},
+			{"", "from 0 repositories"},
+		} {
+			if code, body := do("GET", path, tc.header, ""); code != http.StatusOK || !strings.Contains(body, tc.want) {
+				t.Errorf("%s with header %q: got %d, want %q: %s", path, tc.header, code, tc.want, body)
+			}
+		}
+	}
+
+	// The searches of other endpoints are not restricted.
+	if code, body := do("GET", "/healthz", "", ""); code != http.StatusOK || !strings.Contains(body, "repo-b") {
+		t.Errorf("healthz: got %d: %s", code, body)
+	}
+
+	// NewMux leaves Searcher unrestricted, the gRPC server restricts it with
+	// NewRepoFilterSearcher instead.
+	if _, ok := srv.Searcher.(repoFilterSearcher); ok {
+		t.Errorf("NewMux wrapped Searcher")
+	}
+	filtered := NewRepoFilterSearcher(searcher)
+	for _, tc := range []struct {
+		filter string
+		want   int
+	}{
+		{"1", 1},
+		{"1,2", 2},
+		{"", 0},
+	} {
+		ctx, err := NewRepoFilterContext(context.Background(), tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := filtered.Search(ctx, &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != tc.want {
+			t.Errorf("filter %q: got %d files, want %d", tc.filter, len(res.Files), tc.want)
+		}
+	}
+	if _, err := NewRepoFilterContext(context.Background(), "1,x"); err == nil {
+		t.Errorf("want error for invalid filter")
+	}
+}
+
+func TestMaxEstimatedCost(t *testing.T) {
+	dir := t.TempDir()
+	b, err := index.NewBuilder(index.Options{
+		IndexDir:              dir,
+		RepositoryDescription: zoekt.Repository{ID: 1, Name: "repo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("f", []byte("needle in a haystack")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	searcher, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	srv := Server{
+		Searcher:         searcher,
+		Top:              Top,
+		RPC:              true,
+		MaxEstimatedCost: 1,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		// A substring without ngram matches costs nothing.
+		{`{"Q": "nomatch"}`, http.StatusOK},
+		{`{"Q": "needle"}`, http.StatusBadRequest},
+		// Searches may ask for a higher limit.
+		{`{"Q": "needle", "Opts": {"MaxEstimatedCost": 1000000}}`, http.StatusOK},
+	} {
+		res, err := http.Post(ts.URL+"/api/search", "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.body, res.StatusCode, tc.want, body)
+		}
+		if tc.want == http.StatusBadRequest && !strings.Contains(string(body), "too expensive") {
+			t.Errorf("%s: got %s, want an explanation", tc.body, body)
+		}
+	}
+}
+
+// blockingSearcher blocks searches until their context is cancelled.
+type blockingSearcher struct {
+	zoekt.Streamer
+}
+
+func (s *blockingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *blockingSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{}, nil
+}
+
+func TestLiveQueries(t *testing.T) {
+	srv := Server{
+		Searcher: &blockingSearcher{},
+		Top:      Top,
+		RPC:      true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	listQueries := func() []LiveQuery {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/debug/queries")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var queries []LiveQuery
+		if err := json.NewDecoder(res.Body).Decode(&queries); err != nil {
+			t.Fatal(err)
+		}
+		return queries
+	}
+	cancelQuery := func(id int64) int {
+		t.Helper()
+		res, err := http.Post(fmt.Sprintf("%s/debug/queries/%d/cancel", ts.URL, id), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	done := make(chan int)
+	go func() {
+		req, _ := http.NewRequest("POST", ts.URL+"/api/search", strings.NewReader(`{"Q": "needle"}`))
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		res.Body.Close()
+		done <- res.StatusCode
+	}()
+
+	var queries []LiveQuery
+	for i := 0; i < 100 && len(queries) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		queries = listQueries()
+	}
+	if len(queries) != 1 {
+		t.Fatalf("got %d live queries, want 1", len(queries))
+	}
+	if q := queries[0]; q.Query != `substr:"needle"` || q.Client != "10.0.0.1" {
+		t.Errorf("got query %q from %q", q.Query, q.Client)
+	}
+
+	if got := cancelQuery(queries[0].ID + 1); got != http.StatusNotFound {
+		t.Errorf("cancelling an unknown query: got status %d, want %d", got, http.StatusNotFound)
+	}
+	if got := cancelQuery(queries[0].ID); got != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", got, http.StatusNoContent)
+	}
+	if status := <-done; status == http.StatusOK {
+		t.Errorf("cancelled search succeeded")
+	}
+	if queries := listQueries(); len(queries) != 0 {
+		t.Errorf("got live queries %+v after cancel", queries)
+	}
+}
+
+func TestSearchContexts(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"repo-a", "repo-b", "third"} {
+		b, err := index.NewBuilder(index.Options{
+			IndexDir:              dir,
+			RepositoryDescription: zoekt.Repository{ID: uint32(i + 1), Name: name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddFile("f", []byte("needle")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	searcher, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	contexts := &SearchContexts{}
+	if err := contexts.Set([]SearchContext{
+		{Name: "b", Repos: []string{"^repo-b$"}},
+		{Name: "ab", Description: "a and b", Repos: []string{"^repo-a$"}, RepoIDs: []uint32{2}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := Server{
+		Searcher:       searcher,
+		Top:            Top,
+		HTML:           true,
+		RPC:            true,
+		SearchContexts: contexts,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(b)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		body         string
+		want, denied []string
+	}{
+		{"search", "GET", "/search?q=" + url.QueryEscape("needle context:ab") + "&format=json", "", []string{"repo-a", "repo-b"}, []string{"third"}},
+		{"list", "GET", "/search?q=context:ab&format=json", "", []string{"repo-a", "repo-b"}, []string{"third"}},
+		{"negated", "GET", "/search?q=" + url.QueryEscape("needle -context:ab") + "&format=json", "", []string{"third"}, []string{"repo-a", "repo-b"}},
+		{"api", "POST", "/api/search", `{"Q": "needle context:b"}`, []string{"repo-b"}, []string{"repo-a", "third"}},
+		{"explain", "GET", "/debug/explain?q=" + url.QueryEscape("needle context:b"), "", []string{`reporegex:\"^repo-b$\"`}, nil},
+		{"contexts", "GET", "/api/contexts", "", []string{`"Name": "ab"`, `"Description": "a and b"`, `"Name": "b"`}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, body := do(tc.method, tc.path, tc.body)
+			if code != http.StatusOK {
+				t.Fatalf("got %d: %s", code, body)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("want %q: %s", want, body)
+				}
+			}
+			for _, denied := range tc.denied {
+				if strings.Contains(body, denied) {
+					t.Errorf("got %q: %s", denied, body)
+				}
+			}
+		})
+	}
+
+	if code, body := do("GET", "/search?q="+url.QueryEscape("needle context:nope"), ""); code == http.StatusOK || !strings.Contains(body, `unknown search context "nope"`) {
+		t.Errorf("unknown context: got %d: %s", code, body)
+	}
+
+	// Invalid contexts are refused and keep the current ones.
+	for _, invalid := range [][]SearchContext{
+		{{Repos: []string{"x"}}},
+		{{Name: "x", Repos: []string{"x"}}, {Name: "x", Repos: []string{"y"}}},
+		{{Name: "empty"}},
+		{{Name: "bad", Repos: []string{"("}}},
+	} {
+		if err := contexts.Set(invalid); err == nil {
+			t.Errorf("Set(%+v): got no error", invalid)
+		}
+	}
+	if got := len(contexts.List()); got != 2 {
+		t.Errorf("got %d contexts after invalid updates, want 2", got)
+	}
+
+	// Contexts are loaded from a JSON file.
+	fn := filepath.Join(t.TempDir(), "contexts.json")
+	if err := os.WriteFile(fn, []byte(`[{"Name": "third", "RepoIDs": [3]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := contexts.Load(fn); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := do("GET", "/search?q="+url.QueryEscape("needle context:third")+"&format=json", ""); code != http.StatusOK || !strings.Contains(body, "third") || strings.Contains(body, "repo-a") {
+		t.Errorf("loaded context: got %d: %s", code, body)
+	}
+
+	// Contexts are expanded within the repository filter.
+	if err := contexts.Set([]SearchContext{{Name: "ab", Repos: []string{"^repo-a$"}, RepoIDs: []uint32{2}}}); err != nil {
+		t.Fatal(err)
+	}
+	filtered := Server{
+		Searcher:         searcher,
+		Top:              Top,
+		HTML:             true,
+		SearchContexts:   contexts,
+		RepoFilterHeader: "X-Repos",
+	}
+	filteredMux, err := NewMux(&filtered)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape("needle context:ab")+"&format=json", nil)
+	req.Header.Set("X-Repos", "2,3")
+	rec := httptest.NewRecorder()
+	filteredMux.ServeHTTP(rec, req)
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, "repo-b") || strings.Contains(body, "repo-a") || strings.Contains(body, "third") {
+		t.Errorf("filtered context: got %d: %s", rec.Code, body)
+	}
+}
+
+func TestRepoListLanguages(t *testing.T) {
+	b, err := index.NewShardBuilder(&zoekt.Repository{
+		Name:     "name",
+		Branches: []zoekt.RepositoryBranch{{Name: "master", Version: "1234"}},
+	})
+	if err != nil {
+		t.Fatalf("NewShardBuilder: %v", err)
+	}
+	for _, doc := range []index.Document{
+		{Name: "main.go", Content: []byte("package main\n\nfunc main() {}\n")},
+		{Name: "README.md", Content: []byte("# name\n")},
+	} {
+		doc.Branches = []string{"master"}
+		if err := b.Add(doc); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	mux, err := NewMux(&Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	})
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/search?q=r:", []string{
+		`class="language-bar"`,
+		`style="width: 80.56%; background-color: #00ADD8;" title="Go 80.6%"`,
+		"Go 80.6%, Markdown 19.4%",
+	})
+}
+
+func TestRevUnindexed(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=a", "-c", "user.email=a@b"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello Needle\nbye\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "a.txt")
+	git("commit", "-q", "-m", "first")
+	commit := git("rev-parse", "HEAD")
+
+	for _, id := range []uint32{0, 1} {
+		t.Run(fmt.Sprint("id=", id), func(t *testing.T) {
+			b, err := index.NewShardBuilder(&zoekt.Repository{
+				ID:       id,
+				Name:     "repo",
+				Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "1234abcd5678"}},
+			})
+			if err != nil {
+				t.Fatalf("NewShardBuilder: %v", err)
+			}
+			if err := b.Add(index.Document{Name: "a.txt", Content: []byte("indexed Needle\n"), Branches: []string{"main"}}); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+
+			mux, err := NewMux(&Server{
+				Searcher: searcherForTest(t, b),
+				Top:      Top,
+				HTML:     true,
+				UnindexedSearch: &UnindexedSearch{
+					// $0 is the repository, followed by the name, commit and pattern.
+					Command: []string{"/bin/sh", "-c", `exec git -C "$0" grep --null --line-number -I -e "$3" "$2"`, dir},
+				},
+			})
+			if err != nil {
+				t.Fatalf("NewMux: %v", err)
+			}
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+
+			search := func(q string) *ResultInput {
+				t.Helper()
+				res, err := http.Get(ts.URL + "/search?format=json&q=" + url.QueryEscape(q))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer res.Body.Close()
+				var result ApiSearchResult
+				if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+					t.Fatal(err)
+				}
+				return result.Result
+			}
+
+			// The indexed commit is searched in the index.
+			res := search("rev:1234abcd Needle")
+			if len(res.FileMatches) != 1 || res.FileMatches[0].Unindexed || res.FileMatches[0].Matches[0].Fragments[0].Pre != "indexed " {
+				t.Errorf("indexed: got %+v", res.FileMatches)
+			}
+
+			// Other commits are searched with the command.
+			res = search("rev:" + commit[:8] + " Needle")
+			if len(res.FileMatches) != 1 || res.Stats.FileCount != 1 {
+				t.Fatalf("unindexed: got %+v", res)
+			}
+			f := res.FileMatches[0]
+			if !f.Unindexed || f.FileName != "a.txt" || f.URL != "" || len(f.Matches) != 1 {
+				t.Errorf("unindexed: got %+v", f)
+			}
+			if m := f.Matches[0]; m.LineNum != 1 || len(m.Fragments) != 1 || m.Fragments[0].Pre != "hello " || m.Fragments[0].Match != "Needle" {
+				t.Errorf("unindexed: got match %+v", m)
+			}
+
+			if res := search("rev:" + commit[:8] + " nomatch"); len(res.FileMatches) != 0 {
+				t.Errorf("no match: got %+v", res.FileMatches)
+			}
+			if res := search("rev:" + commit[:8] + " Needle -file:a"); len(res.FileMatches) != 0 {
+				t.Errorf("unsupported query: got %+v", res.FileMatches)
+			}
+
+			checkNeedles(t, ts, "/search?q="+url.QueryEscape("rev:"+commit[:8]+" Needle"), []string{
+				`>unindexed</span>`,
+				"hello ",
+			})
+		})
 	}
 }
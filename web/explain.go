@@ -0,0 +1,145 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const defaultNumExplainShards = 100
+
+// ExplainResult is the response of /debug/explain. It shows how a query
+// string is parsed and rewritten, and how the shards evaluate it, to debug
+// queries which don't match what the user expects.
+type ExplainResult struct {
+	// Query is the query string as entered.
+	Query string
+
+	// Parsed, Simplified and Expanded are the query after parsing, after
+	// query.Simplify and after query.ExpandFileContent, which splits
+	// content-or-filename atoms into a content and a filename atom.
+	Parsed     string
+	Simplified string
+	Expanded   string
+
+	// Tree is Expanded formatted with one node per line.
+	Tree string
+
+	// ShardsTotal and ShardsSkipped count all shards the searcher explained.
+	// Shards only contains the num most expensive ones.
+	ShardsTotal   int
+	ShardsSkipped int
+
+	// Explanation is the searcher's explanation, if it supports explaining
+	// queries, else ExplainError says why it is missing.
+	Explanation  *zoekt.Explanation `json:",omitempty"`
+	ExplainError string             `json:",omitempty"`
+}
+
+// serveExplain explains the query in the q parameter. The num parameter
+// limits the number of shards described, most expensive first.
+func (s *Server) serveExplain(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	queryStr := qvals.Get("q")
+	if queryStr == "" {
+		http.Error(w, "no query found, pass it in the q parameter", http.StatusBadRequest)
+		return
+	}
+
+	num, err := strconv.Atoi(qvals.Get("num"))
+	if err != nil || num <= 0 {
+		num = defaultNumExplainShards
+	}
+
+	q, err := query.Parse(queryStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	simplified := query.Simplify(q)
+	expanded := query.Map(simplified, query.ExpandFileContent)
+	result := ExplainResult{
+		Query:      queryStr,
+		Parsed:     q.String(),
+		Simplified: simplified.String(),
+		Expanded:   expanded.String(),
+		Tree:       formatQueryTree(expanded),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	e, err := zoekt.Explain(ctx, s.searcher, q, &zoekt.SearchOptions{})
+	if err != nil {
+		result.ExplainError = err.Error()
+	} else {
+		result.ShardsTotal = len(e.Shards)
+		for _, se := range e.Shards {
+			if se.Skipped {
+				result.ShardsSkipped++
+			}
+		}
+		sort.SliceStable(e.Shards, func(i, j int) bool { return e.Shards[i].Cost > e.Shards[j].Cost })
+		if len(e.Shards) > num {
+			e.Shards = e.Shards[:num]
+		}
+		result.Explanation = e
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
+
+// formatQueryTree formats q with one node per line, indenting children.
+func formatQueryTree(q query.Q) string {
+	var b strings.Builder
+	var format func(q query.Q, depth int)
+	format = func(q query.Q, depth int) {
+		b.WriteString(strings.Repeat("  ", depth))
+		switch s := q.(type) {
+		case *query.And:
+			b.WriteString("and\n")
+			for _, ch := range s.Children {
+				format(ch, depth+1)
+			}
+		case *query.Or:
+			b.WriteString("or\n")
+			for _, ch := range s.Children {
+				format(ch, depth+1)
+			}
+		case *query.Not:
+			b.WriteString("not\n")
+			format(s.Child, depth+1)
+		case *query.Boost:
+			fmt.Fprintf(&b, "boost %0.2f\n", s.Boost)
+			format(s.Child, depth+1)
+		case *query.Type:
+			name := "UNKNOWN"
+			switch s.Type {
+			case query.TypeFileMatch:
+				name = "filematch"
+			case query.TypeFileName:
+				name = "filename"
+			case query.TypeRepo:
+				name = "repo"
+			}
+			fmt.Fprintf(&b, "type:%s\n", name)
+			format(s.Child, depth+1)
+		default:
+			b.WriteString(q.String())
+			b.WriteByte('\n')
+		}
+	}
+	format(q, 0)
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// maxFacetValues is the number of values we show per facet.
+const maxFacetValues = 10
+
+// maxPathFacetDepth is the deepest directory level offered as path facet.
+const maxPathFacetDepth = 4
+
+// computeFacets aggregates files by repository, language and directory. Each
+// facet value links to queryStr narrowed down to that value.
+//
+// files is usually truncated, so the repository facet lists all repos that
+// match the query. Repos without shown files have a count of 0.
+func computeFacets(queryStr string, files []zoekt.FileMatch, repos []string) Facets {
+	repoCounts := map[string]int{}
+	for _, r := range repos {
+		repoCounts[r] = 0
+	}
+	langs := map[string]int{}
+	for _, f := range files {
+		repoCounts[f.Repository]++
+		if f.Language != "" {
+			langs[f.Language]++
+		}
+	}
+
+	// The parentheses keep eg. "a or b" from binding to the facet.
+	narrow := func(b *query.Builder) string {
+		return "(" + strings.TrimSpace(queryStr) + ") " + b.String()
+	}
+
+	return Facets{
+		Repos: topFacets(repoCounts, func(repo string) string {
+			return narrow(query.NewBuilder().Repo("^" + regexp.QuoteMeta(repo) + "$"))
+		}),
+		Languages: topFacets(langs, func(lang string) string {
+			return narrow(query.NewBuilder().Lang(lang))
+		}),
+		Paths: topFacets(pathCounts(files), func(dir string) string {
+			return narrow(query.NewBuilder().File("^" + regexp.QuoteMeta(dir)))
+		}),
+	}
+}
+
+// pathCounts counts files per directory. It uses the shallowest directory
+// level at which the files are spread over more than one directory, so that
+// results which all live below eg. "src/" are broken down further.
+func pathCounts(files []zoekt.FileMatch) map[string]int {
+	var counts map[string]int
+	for depth := 1; depth <= maxPathFacetDepth; depth++ {
+		counts = map[string]int{}
+		for _, f := range files {
+			if dir, ok := dirPrefix(f.FileName, depth); ok {
+				counts[dir]++
+			}
+		}
+		if len(counts) != 1 {
+			break
+		}
+	}
+	return counts
+}
+
+// dirPrefix returns the first depth directories of name, including a trailing
+// slash. It returns false if name is not nested that deeply.
+func dirPrefix(name string, depth int) (string, bool) {
+	end := 0
+	for i := 0; i < depth; i++ {
+		j := strings.IndexByte(name[end:], '/')
+		if j < 0 {
+			return "", false
+		}
+		end += j + 1
+	}
+	return name[:end], true
+}
+
+// topFacets returns the maxFacetValues most frequent values in counts.
+func topFacets(counts map[string]int, narrow func(string) string) []Facet {
+	facets := make([]Facet, 0, len(counts))
+	for v, c := range counts {
+		facets = append(facets, Facet{Value: v, Count: c})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	if len(facets) > maxFacetValues {
+		facets = facets[:maxFacetValues]
+	}
+	for i := range facets {
+		facets[i].Query = narrow(facets[i].Value)
+	}
+	return facets
+}
+
+// facetInput is provided to the facet template.
+type facetInput struct {
+	Title  string
+	Values []Facet
+	Last   LastInput
+}
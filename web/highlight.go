@@ -0,0 +1,227 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// highlighter renders the lines of result snippets as HTML, syntax
+// highlighted with chroma.
+type highlighter struct {
+	// css holds the inline style of every token type.
+	css map[chroma.TokenType]string
+
+	// lexers caches the lexer of every language looked up, nil if chroma
+	// has none.
+	lexersMu sync.Mutex
+	lexers   map[string]chroma.Lexer
+}
+
+func newHighlighter(styleName string) (*highlighter, error) {
+	style, ok := styles.Registry[styleName]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style %q, want one of %s", styleName, strings.Join(styles.Names(), ", "))
+	}
+
+	h := &highlighter{
+		css:    map[chroma.TokenType]string{},
+		lexers: map[string]chroma.Lexer{},
+	}
+	for tt := range chroma.StandardTypes {
+		if css := styleEntryCSS(style.Get(tt)); css != "" {
+			h.css[tt] = css
+		}
+	}
+	return h, nil
+}
+
+// styleEntryCSS returns the inline style for e. Backgrounds are left out, so
+// the highlighting of the results page stays visible.
+func styleEntryCSS(e chroma.StyleEntry) string {
+	var css []string
+	if e.Colour.IsSet() {
+		css = append(css, "color:"+e.Colour.String())
+	}
+	if e.Bold == chroma.Yes {
+		css = append(css, "font-weight:bold")
+	}
+	if e.Italic == chroma.Yes {
+		css = append(css, "font-style:italic")
+	}
+	if e.Underline == chroma.Yes {
+		css = append(css, "text-decoration:underline")
+	}
+	return strings.Join(css, ";")
+}
+
+// lexer returns the lexer for the detected language of a file, falling back
+// to its file name. It returns nil if chroma doesn't know the language.
+func (h *highlighter) lexer(language, fileName string) chroma.Lexer {
+	key := language
+	if key == "" {
+		key = "file:" + fileName
+	}
+
+	h.lexersMu.Lock()
+	defer h.lexersMu.Unlock()
+	if l, ok := h.lexers[key]; ok {
+		return l
+	}
+
+	var l chroma.Lexer
+	if language != "" {
+		l = lexers.Get(language)
+	}
+	if l == nil {
+		l = lexers.Match(fileName)
+	}
+	if l != nil {
+		l = chroma.Coalesce(l)
+	}
+	// Without a language the lexer depends on the file name, so only the
+	// language lookups are cached.
+	if language != "" {
+		h.lexers[key] = l
+	}
+	return l
+}
+
+// highlightSpan is a part of a line shown in the results template.
+type highlightSpan struct {
+	// start and end are the byte offsets of the span in the line. If they
+	// are equal, text is shown instead.
+	start, end int
+	text       string
+
+	// match is set for the matched parts of a line, which are shown in bold.
+	match bool
+}
+
+// highlight returns line as HTML, showing the spans of it. Every line is
+// lexed on its own, so constructs spanning lines, like block comments, may be
+// highlighted wrongly. It returns "" if the lexer changes line, eg. by
+// normalizing its line endings, in which case the caller shows line without
+// highlighting.
+func (h *highlighter) highlight(lexer chroma.Lexer, line string, spans []highlightSpan) template.HTML {
+	it, err := lexer.Tokenise(nil, line)
+	if err != nil {
+		return ""
+	}
+
+	type token struct {
+		start, end int
+		css        string
+	}
+	var tokens []token
+	var lexed strings.Builder
+	for t := it(); t != chroma.EOF; t = it() {
+		tokens = append(tokens, token{start: lexed.Len(), end: lexed.Len() + len(t.Value), css: h.css[t.Type]})
+		lexed.WriteString(t.Value)
+	}
+	if !strings.HasPrefix(lexed.String(), line) {
+		return ""
+	}
+
+	var buf strings.Builder
+	i := 0
+	for _, s := range spans {
+		if s.start == s.end {
+			buf.WriteString(template.HTMLEscapeString(s.text))
+			continue
+		}
+		if s.match {
+			buf.WriteString("<b>")
+		}
+		for ; i < len(tokens) && tokens[i].end <= s.start; i++ {
+		}
+		for j := i; j < len(tokens) && tokens[j].start < s.end; j++ {
+			t := tokens[j]
+			text := template.HTMLEscapeString(line[max(t.start, s.start):min(t.end, s.end, len(line))])
+			if t.css == "" {
+				buf.WriteString(text)
+			} else {
+				fmt.Fprintf(&buf, `<span style="%s">%s</span>`, t.css, text)
+			}
+		}
+		if s.match {
+			buf.WriteString("</b>")
+		}
+	}
+	return template.HTML(buf.String())
+}
+
+// limitPre returns the span [start, end) of a line, shortened at the front
+// like the LimitPre template function.
+func limitPre(limit, start, end int) []highlightSpan {
+	if end-start < limit {
+		return []highlightSpan{{start: start, end: end}}
+	}
+	return []highlightSpan{
+		{text: fmt.Sprintf("...(%d bytes skipped)...", end-start-limit)},
+		{start: end - limit, end: end},
+	}
+}
+
+// limitPost returns the span [start, end) of a line, shortened at the back
+// like the LimitPost template function.
+func limitPost(limit, start, end int) []highlightSpan {
+	if end-start < limit {
+		return []highlightSpan{{start: start, end: end}}
+	}
+	return []highlightSpan{
+		{start: start, end: start + limit},
+		{text: fmt.Sprintf("...(%d bytes skipped)...", end-start-limit)},
+	}
+}
+
+// highlightMatch returns the matching line of m as HTML, shown like the
+// fragments of m in the "match" template.
+func (h *highlighter) highlightMatch(lexer chroma.Lexer, m *Match) template.HTML {
+	var line strings.Builder
+	var spans []highlightSpan
+	for _, f := range m.Fragments {
+		start := line.Len()
+		line.WriteString(f.Pre)
+		spans = append(spans, limitPre(100, start, line.Len())...)
+
+		start = line.Len()
+		line.WriteString(f.Match)
+		spans = append(spans, highlightSpan{start: start, end: line.Len(), match: true})
+
+		start = line.Len()
+		line.WriteString(f.Post)
+		spans = append(spans, limitPost(100, start, start+len(strings.TrimSuffix(f.Post, "\n")))...)
+	}
+	return h.highlight(lexer, line.String(), spans)
+}
+
+// highlightMatches sets the highlighted lines of matches, which are lines of
+// the file fileName in language.
+func (h *highlighter) highlightMatches(language, fileName string, matches []Match) {
+	lexer := h.lexer(language, fileName)
+	if lexer == nil {
+		return
+	}
+
+	highlightContext := func(lines []ContextLine) {
+		for i := range lines {
+			l := &lines[i]
+			l.Highlighted = h.highlight(lexer, l.Line, limitPost(200, 0, len(l.Line)))
+		}
+	}
+	for i := range matches {
+		m := &matches[i]
+		if m.LineNum <= 0 {
+			continue
+		}
+		m.Highlighted = h.highlightMatch(lexer, m)
+		highlightContext(m.BeforeLines)
+		highlightContext(m.AfterLines)
+	}
+}
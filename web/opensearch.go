@@ -0,0 +1,59 @@
+package web
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// openSearchDescription is an OpenSearch 1.1 description document, which lets
+// browsers and other tools add zoekt as a search provider, see
+// https://github.com/dewitt/opensearch.
+type openSearchDescription struct {
+	XMLName       xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string
+	Description   string
+	InputEncoding string
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Rel      string `xml:"rel,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// serveOpenSearch serves the OpenSearch description of this server. Its URL
+// templates point to /search in the formats it supports. Clients leave out
+// optional parameters like {count?} or send them empty, which /search treats
+// like missing ones.
+func (s *Server) serveOpenSearch(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	base := scheme + "://" + r.Host
+
+	desc := openSearchDescription{
+		ShortName:     "Zoekt",
+		Description:   "Code search with Zoekt",
+		InputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: "text/html", Template: base + "/search?q={searchTerms}&num={count?}"},
+			{Type: "application/json", Template: base + "/search?q={searchTerms}&num={count?}&format=json"},
+			{Type: "application/x-ndjson", Template: base + "/search?q={searchTerms}&num={count?}&format=ndjson"},
+			{Type: "application/opensearchdescription+xml", Rel: "self", Template: base + "/opensearch.xml"},
+		},
+	}
+
+	out, err := xml.MarshalIndent(&desc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
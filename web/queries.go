@@ -0,0 +1,210 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// LiveQuery describes a search in progress, as listed by /debug/queries.
+type LiveQuery struct {
+	ID     int64
+	Query  string
+	Client string
+	Start  time.Time
+	// Elapsed is the time since Start, eg. "1.5s".
+	Elapsed string
+
+	// BytesLoaded is the number of index and content bytes the search has
+	// loaded so far. Streamed searches update it as results arrive, other
+	// searches only once they finish.
+	BytesLoaded int64
+}
+
+// liveQuery is a search in progress.
+type liveQuery struct {
+	id     int64
+	query  string
+	client string
+	start  time.Time
+	cancel context.CancelFunc
+
+	bytesLoaded atomic.Int64
+}
+
+func (q *liveQuery) addStats(stats *zoekt.Stats) {
+	q.bytesLoaded.Add(stats.IndexBytesLoaded + stats.ContentBytesLoaded)
+}
+
+// liveQueries tracks the searches in progress, so that operators can find
+// and cancel pathological queries without restarting the server.
+type liveQueries struct {
+	mu      sync.Mutex
+	lastID  int64
+	queries map[int64]*liveQuery
+}
+
+// start registers a search of q. The returned context is cancelled if the
+// search is cancelled through /debug/queries, and done must be called once
+// the search finished.
+func (l *liveQueries) start(ctx context.Context, q string) (context.Context, *liveQuery, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	client, _ := ctx.Value(queryClientKey{}).(string)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.queries == nil {
+		l.queries = map[int64]*liveQuery{}
+	}
+	l.lastID++
+	lq := &liveQuery{
+		id:     l.lastID,
+		query:  q,
+		client: client,
+		start:  time.Now(),
+		cancel: cancel,
+	}
+	l.queries[lq.id] = lq
+
+	return ctx, lq, func() {
+		cancel()
+		l.mu.Lock()
+		delete(l.queries, lq.id)
+		l.mu.Unlock()
+	}
+}
+
+// list returns the searches in progress, oldest first.
+func (l *liveQueries) list() []LiveQuery {
+	now := time.Now()
+	l.mu.Lock()
+	all := make([]LiveQuery, 0, len(l.queries))
+	for _, q := range l.queries {
+		all = append(all, LiveQuery{
+			ID:          q.id,
+			Query:       q.query,
+			Client:      q.client,
+			Start:       q.start,
+			Elapsed:     now.Sub(q.start).String(),
+			BytesLoaded: q.bytesLoaded.Load(),
+		})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// cancel cancels the search with the given ID. It returns false if there is
+// no such search.
+func (l *liveQueries) cancel(id int64) bool {
+	l.mu.Lock()
+	q, ok := l.queries[id]
+	l.mu.Unlock()
+	if ok {
+		q.cancel()
+	}
+	return ok
+}
+
+type queryClientKey struct{}
+
+// withQueryClient records the client of the request, which /debug/queries
+// lists for its searches. Behind a proxy, the client is the first address of
+// the X-Forwarded-For header.
+func withQueryClient(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.RemoteAddr
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			client, _, _ = strings.Cut(fwd, ",")
+			client = strings.TrimSpace(client)
+		}
+		ctx := context.WithValue(r.Context(), queryClientKey{}, client)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// liveQuerySearcher registers every search with queries while it runs.
+type liveQuerySearcher struct {
+	zoekt.Streamer
+	queries *liveQueries
+}
+
+func (s liveQuerySearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	ctx, lq, done := s.queries.start(ctx, q.String())
+	defer done()
+	sr, err := s.Streamer.Search(ctx, q, opts)
+	if sr != nil {
+		lq.addStats(&sr.Stats)
+	}
+	return sr, err
+}
+
+func (s liveQuerySearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	ctx, lq, done := s.queries.start(ctx, q.String())
+	defer done()
+	return s.Streamer.StreamSearch(ctx, q, opts, zoekt.SenderFunc(func(sr *zoekt.SearchResult) {
+		lq.addStats(&sr.Stats)
+		sender.Send(sr)
+	}))
+}
+
+func (s liveQuerySearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	strs := make([]string, 0, len(qs))
+	for _, q := range qs {
+		strs = append(strs, q.String())
+	}
+	ctx, lq, done := s.queries.start(ctx, strings.Join(strs, "; "))
+	defer done()
+	srs, err := zoekt.BatchSearch(ctx, s.Streamer, qs, opts)
+	for _, sr := range srs {
+		if sr != nil {
+			lq.addStats(&sr.Stats)
+		}
+	}
+	return srs, err
+}
+
+func (s liveQuerySearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s liveQuerySearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+func (s liveQuerySearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, sender)
+}
+
+// serveQueries lists the searches in progress as JSON, oldest first.
+func (s *Server) serveQueries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(s.queries.list())
+}
+
+// serveCancelQuery cancels the search with the ID in the path. The search
+// returns with a context cancellation error to its client.
+func (s *Server) serveCancelQuery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid query ID", http.StatusBadRequest)
+		return
+	}
+	if !s.queries.cancel(id) {
+		http.Error(w, "no such query", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
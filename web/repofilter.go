@@ -0,0 +1,144 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type repoFilterKey struct{}
+
+// withRepoFilter restricts the searches of the request to the repositories
+// listed in the header s.RepoFilterHeader.
+func (s *Server) withRepoFilter(h http.Handler) http.Handler {
+	if s.RepoFilterHeader == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := NewRepoFilterContext(r.Context(), r.Header.Get(s.RepoFilterHeader))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s header: %v", s.RepoFilterHeader, err), http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// NewRepoFilterContext returns a copy of ctx which restricts the searchers
+// returned by NewRepoFilterSearcher to the comma separated repository IDs in
+// v. An empty v allows no repositories.
+func NewRepoFilterContext(ctx context.Context, v string) (context.Context, error) {
+	filter, err := parseRepoFilter(v)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, repoFilterKey{}, filter), nil
+}
+
+// NewRepoFilterSearcher returns a searcher which applies the repository
+// filter stored by NewRepoFilterContext to every call. Calls whose context
+// has no filter are passed through unchanged.
+func NewRepoFilterSearcher(s zoekt.Streamer) zoekt.Streamer {
+	return repoFilterSearcher{s}
+}
+
+// parseRepoFilter parses a comma separated list of repository IDs. An empty
+// list allows no repositories.
+func parseRepoFilter(v string) (*roaring.Bitmap, error) {
+	filter := roaring.New()
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		filter.Add(uint32(id))
+	}
+	return filter, nil
+}
+
+// repoFilterFromContext returns the repository filter of the request, or nil
+// if the request is not restricted.
+func repoFilterFromContext(ctx context.Context) *roaring.Bitmap {
+	filter, _ := ctx.Value(repoFilterKey{}).(*roaring.Bitmap)
+	return filter
+}
+
+// repoFilterSearcher applies the repository filter stored in the context to
+// every call. Calls without a filter, eg. from /healthz, are passed through
+// unchanged.
+type repoFilterSearcher struct {
+	zoekt.Streamer
+}
+
+// filterOpts returns a copy of opts restricted to the filter in ctx.
+func filterOpts(ctx context.Context, opts *zoekt.SearchOptions) *zoekt.SearchOptions {
+	filter := repoFilterFromContext(ctx)
+	if filter == nil {
+		return opts
+	}
+	o := *opts
+	if o.RepoFilter != nil {
+		o.RepoFilter = roaring.And(o.RepoFilter, filter)
+	} else {
+		o.RepoFilter = filter
+	}
+	return &o
+}
+
+// filterListQuery restricts a List query to the filter in ctx, since
+// ListOptions has no repository filter.
+func filterListQuery(ctx context.Context, q query.Q) query.Q {
+	filter := repoFilterFromContext(ctx)
+	if filter == nil {
+		return q
+	}
+	return query.NewAnd(&query.RepoIDs{Repos: filter}, q)
+}
+
+func (s repoFilterSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return s.Streamer.Search(ctx, q, filterOpts(ctx, opts))
+}
+
+func (s repoFilterSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return s.Streamer.StreamSearch(ctx, q, filterOpts(ctx, opts), sender)
+}
+
+func (s repoFilterSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	return zoekt.BatchSearch(ctx, s.Streamer, qs, filterOpts(ctx, opts))
+}
+
+func (s repoFilterSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	return zoekt.Explain(ctx, s.Streamer, q, filterOpts(ctx, opts))
+}
+
+// FetchDocument returns zoekt.ErrDocumentNotFound for documents of
+// repositories outside the filter.
+func (s repoFilterSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	doc, err := zoekt.FetchDocument(ctx, s.Streamer, req)
+	if err != nil {
+		return nil, err
+	}
+	if filter := repoFilterFromContext(ctx); filter != nil && !filter.Contains(doc.RepositoryID) {
+		return nil, zoekt.ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+func (s repoFilterSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return s.Streamer.List(ctx, filterListQuery(ctx, q), opts)
+}
+
+func (s repoFilterSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	return zoekt.StreamList(ctx, s.Streamer, filterListQuery(ctx, q), opts, sender)
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// Reranker is an EXPERIMENTAL extension point to re-rank or augment the
+// results of a search, eg. by consulting an embedding service for natural
+// language queries.
+//
+// Rerank receives the query as typed by the user together with zoekt's top
+// results, already sorted and truncated. It returns the files to show, in
+// order. It may reorder, drop or add files. If it returns an error, the
+// results are shown in zoekt's order.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, files []zoekt.FileMatch) ([]zoekt.FileMatch, error)
+}
+
+// maxSnippetBytes limits how much content of a file we send to the rerank
+// service per candidate.
+const maxSnippetBytes = 2048
+
+// NewHTTPReranker returns a Reranker which asks the service at url to score
+// the results. The service receives a POST with a JSON body of the form
+//
+//	{"Query": "...", "Candidates": [{"Repository": "...", "FileName": "...", "Language": "...", "Snippet": "..."}]}
+//
+// and must reply with one score per candidate, higher is better:
+//
+//	{"Scores": [0.3, 0.9]}
+//
+// Files are sorted by the returned scores, which replace zoekt's scores.
+func NewHTTPReranker(url string, timeout time.Duration) Reranker {
+	return &httpReranker{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+type httpReranker struct {
+	url    string
+	client *http.Client
+}
+
+type rerankCandidate struct {
+	Repository string
+	FileName   string
+	Language   string
+	Snippet    string
+}
+
+type rerankRequest struct {
+	Query      string
+	Candidates []rerankCandidate
+}
+
+type rerankReply struct {
+	Scores []float64
+}
+
+func (r *httpReranker) Rerank(ctx context.Context, query string, files []zoekt.FileMatch) ([]zoekt.FileMatch, error) {
+	if len(files) == 0 {
+		return files, nil
+	}
+
+	args := rerankRequest{
+		Query:      query,
+		Candidates: make([]rerankCandidate, 0, len(files)),
+	}
+	for _, f := range files {
+		args.Candidates = append(args.Candidates, rerankCandidate{
+			Repository: f.Repository,
+			FileName:   f.FileName,
+			Language:   f.Language,
+			Snippet:    snippet(&f),
+		})
+	}
+
+	body, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank service %s: status %s", r.url, resp.Status)
+	}
+
+	var reply rerankReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	if len(reply.Scores) != len(files) {
+		return nil, fmt.Errorf("rerank service %s: got %d scores for %d files", r.url, len(reply.Scores), len(files))
+	}
+
+	reranked := make([]zoekt.FileMatch, len(files))
+	copy(reranked, files)
+	for i := range reranked {
+		reranked[i].Score = reply.Scores[i]
+	}
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].Score > reranked[j].Score
+	})
+	return reranked, nil
+}
+
+// snippet returns the matched lines of f, truncated to maxSnippetBytes. The
+// entry which crosses the limit is cut off at a rune boundary, so a single
+// long line still yields a snippet.
+func snippet(f *zoekt.FileMatch) string {
+	var b bytes.Buffer
+	write := func(p []byte) bool {
+		if b.Len()+len(p) > maxSnippetBytes {
+			p = p[:maxSnippetBytes-b.Len()]
+			// Drop a rune the cut split in two.
+			for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+				if utf8.RuneStart(p[i]) {
+					if !utf8.FullRune(p[i:]) {
+						p = p[:i]
+					}
+					break
+				}
+			}
+			b.Write(p)
+			return false
+		}
+		b.Write(p)
+		return true
+	}
+
+	for _, l := range f.LineMatches {
+		if !write(l.Line) {
+			return b.String()
+		}
+	}
+	for _, c := range f.ChunkMatches {
+		if !write(c.Content) {
+			return b.String()
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,401 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	defaultUnindexedMaxLines = 1000
+	defaultUnindexedTimeout  = 10 * time.Second
+
+	// maxUnindexedRepos bounds the repositories searched for a commit which
+	// is not indexed. A commit usually belongs to a single repository and
+	// its forks.
+	maxUnindexedRepos = 5
+)
+
+// NewRevSearcher returns a searcher which resolves the rev: atoms of every
+// query into the indexed branches at the commit before passing it on to s.
+// Commits which are not indexed match nothing, see UnindexedSearch.
+func NewRevSearcher(s zoekt.Streamer) zoekt.Streamer {
+	return revSearcher{s}
+}
+
+type revSearcher struct {
+	zoekt.Streamer
+}
+
+// resolve replaces the rev: atoms of q with the branches whose indexed
+// commit starts with the revision.
+func (s revSearcher) resolve(ctx context.Context, q query.Q) (query.Q, error) {
+	hasRev := false
+	query.VisitAtoms(q, func(q query.Q) {
+		if _, ok := q.(*query.Rev); ok {
+			hasRev = true
+		}
+	})
+	if !hasRev {
+		return q, nil
+	}
+
+	repos, err := s.Streamer.List(ctx, &query.Const{Value: true}, &zoekt.ListOptions{Field: zoekt.RepoListFieldReposMap})
+	if err != nil {
+		return nil, err
+	}
+
+	q = query.Map(q, func(q query.Q) query.Q {
+		rev, ok := q.(*query.Rev)
+		if !ok {
+			return q
+		}
+		branches := map[string]*roaring.Bitmap{}
+		for id, r := range repos.ReposMap {
+			for _, b := range r.Branches {
+				if !revMatches(b.Version, rev.Revision) {
+					continue
+				}
+				if branches[b.Name] == nil {
+					branches[b.Name] = roaring.New()
+				}
+				branches[b.Name].Add(id)
+			}
+		}
+		var qs []query.Q
+		if len(branches) > 0 {
+			br := &query.BranchesRepos{}
+			for name, ids := range branches {
+				br.List = append(br.List, query.BranchRepos{Branch: name, Repos: ids})
+			}
+			sort.Slice(br.List, func(i, j int) bool { return br.List[i].Branch < br.List[j].Branch })
+			qs = append(qs, br)
+		}
+
+		// Repositories without an ID are listed in full, and selected by name.
+		for _, r := range repos.Repos {
+			for _, b := range r.Repository.Branches {
+				if revMatches(b.Version, rev.Revision) {
+					qs = append(qs, query.NewAnd(
+						&query.RepoRegexp{Regexp: regexp.MustCompile("^" + regexp.QuoteMeta(r.Repository.Name) + "$")},
+						&query.Branch{Pattern: b.Name, Exact: true},
+					))
+				}
+			}
+		}
+		if len(qs) == 0 {
+			return &query.Const{Value: false}
+		}
+		return query.NewOr(qs...)
+	})
+	return query.Simplify(q), nil
+}
+
+// revMatches returns true if the commit version starts with rev, which is
+// lower case.
+func revMatches(version, rev string) bool {
+	return len(version) >= len(rev) && strings.EqualFold(version[:len(rev)], rev)
+}
+
+func (s revSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	q, err := s.resolve(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s revSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	q, err := s.resolve(ctx, q)
+	if err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s revSearcher) BatchSearch(ctx context.Context, qs []query.Q, opts *zoekt.SearchOptions) ([]*zoekt.SearchResult, error) {
+	resolved := make([]query.Q, len(qs))
+	for i, q := range qs {
+		q, err := s.resolve(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = q
+	}
+	return zoekt.BatchSearch(ctx, s.Streamer, resolved, opts)
+}
+
+func (s revSearcher) Explain(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.Explanation, error) {
+	q, err := s.resolve(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.Explain(ctx, s.Streamer, q, opts)
+}
+
+func (s revSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	q, err := s.resolve(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.List(ctx, q, opts)
+}
+
+func (s revSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	q, err := s.resolve(ctx, q)
+	if err != nil {
+		return err
+	}
+	return zoekt.StreamList(ctx, s.Streamer, q, opts, sender)
+}
+
+func (s revSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Streamer, req)
+}
+
+// UnindexedSearch searches commits which are not indexed with a helper
+// command, so that rev: finds results before the indexer caught up.
+type UnindexedSearch struct {
+	// Command is the helper and its arguments. It is run with the name of
+	// the repository, the commit hash prefix and a Perl compatible regular
+	// expression appended, and prints the matching lines of the commit like
+	//
+	//	git -C "/repos/$1" grep --null --line-number -I -P -e "$3" "$2"
+	//
+	// does: the commit, a colon, the path, NUL, the line number, NUL and the
+	// line. Failures, eg. for commits of other repositories, are logged.
+	Command []string
+
+	// MaxLines bounds the matching lines read from the command, and Timeout
+	// how long it may run. Both bound the results rather than fail the
+	// search. They default to 1000 lines and 10 seconds.
+	MaxLines int
+	Timeout  time.Duration
+}
+
+// search runs the command for the commit rev of repo, and returns the
+// matches of re.
+func (u *UnindexedSearch) search(ctx context.Context, repo, rev string, re *regexp.Regexp) ([]zoekt.FileMatch, error) {
+	maxLines, timeout := u.MaxLines, u.Timeout
+	if maxLines <= 0 {
+		maxLines = defaultUnindexedMaxLines
+	}
+	if timeout <= 0 {
+		timeout = defaultUnindexedTimeout
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := append(append([]string{}, u.Command[1:]...), repo, rev, re.String())
+	cmd := exec.CommandContext(cmdCtx, u.Command[0], args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var files []zoekt.FileMatch
+	byPath := map[string]int{}
+	lines := 0
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for lines < maxLines && scanner.Scan() {
+		path, m, ok := parseUnindexedLine(scanner.Bytes(), rev, re)
+		if !ok {
+			continue
+		}
+		lines++
+		i, ok := byPath[path]
+		if !ok {
+			i = len(files)
+			byPath[path] = i
+			files = append(files, zoekt.FileMatch{
+				Repository: repo,
+				FileName:   path,
+				Branches:   []string{rev},
+				Version:    rev,
+			})
+		}
+		files[i].LineMatches = append(files[i].LineMatches, m)
+		files[i].MatchCount += max(len(m.LineFragments), 1)
+	}
+	bounded := lines >= maxLines || cmdCtx.Err() != nil
+
+	// Stop the command if we stopped reading early.
+	cancel()
+	err = cmd.Wait()
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case bounded:
+		// The bounds were hit, we keep what we found so far.
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(files) == 0:
+		// Like grep, git grep exits with 1 if nothing matched.
+	case err != nil:
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return files, nil
+}
+
+// parseUnindexedLine parses a line of the unindexed search command, see
+// UnindexedSearch.Command.
+func parseUnindexedLine(line []byte, rev string, re *regexp.Regexp) (string, zoekt.LineMatch, bool) {
+	parts := bytes.SplitN(line, []byte{0}, 3)
+	if len(parts) != 3 {
+		return "", zoekt.LineMatch{}, false
+	}
+	num, err := strconv.Atoi(string(parts[1]))
+	if err != nil {
+		return "", zoekt.LineMatch{}, false
+	}
+	path := string(parts[0])
+	if i := strings.IndexByte(path, ':'); i >= 0 && revMatches(path[:i], rev) {
+		path = path[i+1:]
+	}
+
+	text := append([]byte{}, parts[2]...)
+	m := zoekt.LineMatch{Line: text, LineNumber: num}
+	for _, loc := range re.FindAllIndex(text, -1) {
+		m.LineFragments = append(m.LineFragments, zoekt.LineFragmentMatch{
+			LineOffset:  loc[0],
+			MatchLength: loc[1] - loc[0],
+		})
+	}
+	if len(m.LineFragments) == 0 {
+		// The command's regular expression dialect matched where ours
+		// doesn't, so we show the line without highlighting.
+		m.LineFragments = []zoekt.LineFragmentMatch{{}}
+	}
+	return path, m, true
+}
+
+// unindexedQuery returns the commit, the repositories and the pattern of q if
+// it can be searched with UnindexedSearch: q must combine a single rev: atom
+// and a single content pattern with repository atoms.
+func unindexedQuery(q query.Q) (string, query.Q, *regexp.Regexp, bool) {
+	children := []query.Q{q}
+	if and, ok := q.(*query.And); ok {
+		children = and.Children
+	}
+
+	var rev, pattern string
+	var caseSensitive bool
+	var repos []query.Q
+	for _, c := range children {
+		switch c := c.(type) {
+		case *query.Rev:
+			if rev != "" {
+				return "", nil, nil, false
+			}
+			rev = c.Revision
+		case *query.Repo, *query.RepoRegexp, *query.SearchContext:
+			repos = append(repos, c)
+		case *query.Substring:
+			if pattern != "" || c.FileName {
+				return "", nil, nil, false
+			}
+			pattern, caseSensitive = regexp.QuoteMeta(c.Pattern), c.CaseSensitive
+		case *query.Regexp:
+			if pattern != "" || c.FileName {
+				return "", nil, nil, false
+			}
+			pattern, caseSensitive = c.Regexp.String(), c.CaseSensitive
+		default:
+			return "", nil, nil, false
+		}
+	}
+	if rev == "" || pattern == "" {
+		return "", nil, nil, false
+	}
+	if !caseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", nil, nil, false
+	}
+
+	var repoQ query.Q = &query.Const{Value: true}
+	if len(repos) > 0 {
+		repoQ = query.NewAnd(repos...)
+	}
+	return rev, repoQ, re, true
+}
+
+// searchUnindexed searches the commit of the rev: atom of q with
+// s.UnindexedSearch in the repositories which don't have it indexed. It
+// returns nil if q can't be searched that way, see unindexedQuery, or if
+// maxFiles are already shown.
+func (s *Server) searchUnindexed(ctx context.Context, q query.Q, maxFiles int) (*zoekt.SearchResult, error) {
+	rev, repoQ, re, ok := unindexedQuery(q)
+	if !ok || maxFiles <= 0 {
+		return nil, nil
+	}
+
+	repos, err := s.searcher.List(ctx, repoQ, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(repos.Repos, func(i, j int) bool {
+		return repos.Repos[i].Repository.Name < repos.Repos[j].Repository.Name
+	})
+
+	result := &zoekt.SearchResult{
+		RepoURLs:      map[string]string{},
+		LineFragments: map[string]string{},
+	}
+	searched := 0
+	for _, r := range repos.Repos {
+		indexed := false
+		for _, b := range r.Repository.Branches {
+			indexed = indexed || revMatches(b.Version, rev)
+		}
+		if indexed {
+			continue
+		}
+		if searched++; searched > maxUnindexedRepos {
+			break
+		}
+
+		name := r.Repository.Name
+		files, err := s.UnindexedSearch.search(ctx, name, rev, re)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("unindexed search of %s@%s: %v", name, rev, err)
+			continue
+		}
+		result.Files = append(result.Files, files...)
+		result.RepoURLs[name] = r.Repository.FileURLTemplate
+		result.LineFragments[name] = r.Repository.LineFragmentTemplate
+	}
+
+	result.Files = result.Files[:min(len(result.Files), maxFiles)]
+	for _, f := range result.Files {
+		result.Stats.FileCount++
+		result.Stats.MatchCount += f.MatchCount
+	}
+	return result, nil
+}
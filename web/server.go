@@ -28,9 +28,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	texttemplate "text/template"
 	"time"
 
+	"github.com/go-enry/go-enry/v2"
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt/index"
 	zjson "github.com/sourcegraph/zoekt/internal/json"
@@ -78,10 +80,34 @@ var Funcmap = template.FuncMap{
 	"TrimTrailingNewline": func(s string) string {
 		return strings.TrimSuffix(s, "\n")
 	},
+	"Facet": func(title string, values []Facet, last LastInput) facetInput {
+		return facetInput{Title: title, Values: values, Last: last}
+	},
+	"Ago": func(t time.Time) string {
+		return humanAgo(time.Since(t))
+	},
+}
+
+// humanAgo formats d in the largest unit which fits, e.g. "2h ago".
+func humanAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	}
 }
 
 const defaultNumResults = 50
 
+// maxSuggestions is the number of similar queries shown for queries without
+// results.
+const maxSuggestions = 3
+
 type Server struct {
 	Searcher zoekt.Streamer
 
@@ -111,6 +137,49 @@ type Server struct {
 	// "print" for the show file functionality.
 	Top *template.Template
 
+	// Reranker, if set, is consulted to re-rank the results of searches
+	// done through the HTML interface. EXPERIMENTAL.
+	Reranker Reranker
+
+	// RepoFilterHeader, if set, is the name of a request header which lists
+	// the comma separated IDs of the repositories a request may search, eg.
+	// set by an authenticating proxy. Searches, listings, explanations and
+	// repository stats done through the HTML interface, the JSON API and
+	// /debug/explain only see these repositories, and none if the header is
+	// missing. The proxy must strip the header from the requests of clients.
+	// gRPC is served outside of NewMux, see NewRepoFilterSearcher.
+	RepoFilterHeader string
+
+	// MaxEstimatedCost, if set, rejects searches done through the HTML
+	// interface and the JSON API whose estimated cost exceeds it, see
+	// zoekt.SearchOptions.MaxEstimatedCost. gRPC is served outside of NewMux,
+	// see NewCostLimitSearcher.
+	MaxEstimatedCost int64
+
+	// HighlightStyle, if set, is the name of a chroma style with which the
+	// HTML interface syntax highlights the lines of result snippets. The
+	// lexer is picked by the detected language of the file.
+	HighlightStyle string
+
+	// SearchContexts, if set, expands the context: atoms of searches done
+	// through the HTML interface, the JSON API and /debug/explain, and is
+	// listed at /api/contexts. The atom has no gRPC representation, so gRPC
+	// clients send the repositories instead.
+	SearchContexts *SearchContexts
+
+	// UnindexedSearch, if set, searches the commit of a rev: atom in the
+	// repositories which don't have it indexed, for searches done through
+	// the HTML interface and /search?format=json. Their results are flagged
+	// with FileMatch.Unindexed.
+	UnindexedSearch *UnindexedSearch
+
+	// searcher is Searcher with rev: atoms resolved and SearchContexts
+	// expanded, restricted to the repository filter of the request if
+	// RepoFilterHeader is set and limited to MaxEstimatedCost.
+	searcher zoekt.Streamer
+
+	highlighter *highlighter
+
 	repolist *template.Template
 	search   *template.Template
 	result   *template.Template
@@ -127,6 +196,24 @@ type Server struct {
 	lastStatsMu sync.Mutex
 	lastStats   *zoekt.RepoStats
 	lastStatsTS time.Time
+
+	// lameDuck is set once the server is shutting down. It keeps serving
+	// requests, but reports itself as not ready so no new traffic is routed
+	// to it.
+	lameDuck atomic.Bool
+
+	// ready is set once /readyz has seen all shards loaded. Loading only
+	// happens on startup, so from then on /readyz does not search anymore.
+	ready atomic.Bool
+
+	// queries are the searches in progress, listed at /debug/queries.
+	queries liveQueries
+}
+
+// EnterLameDuck marks the server as draining. From now on /readyz fails
+// while all other endpoints keep working.
+func (s *Server) EnterLameDuck() {
+	s.lameDuck.Store(true)
 }
 
 func (s *Server) getTemplate(str string) *template.Template {
@@ -187,24 +274,103 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	s.textTemplateCache = map[string]*texttemplate.Template{}
 	s.startTime = time.Now()
 
+	if s.HighlightStyle != "" {
+		h, err := newHighlighter(s.HighlightStyle)
+		if err != nil {
+			return nil, err
+		}
+		s.highlighter = h
+	}
+
+	// The handlers search through s.searcher, so that the repository filter
+	// does not leak into s.Searcher.
+	s.searcher = NewRevSearcher(s.Searcher)
+	if s.SearchContexts != nil {
+		s.searcher = NewSearchContextSearcher(s.searcher, s.SearchContexts)
+	}
+	if s.RepoFilterHeader != "" {
+		s.searcher = repoFilterSearcher{s.searcher}
+	}
+	if s.MaxEstimatedCost > 0 {
+		s.searcher = NewCostLimitSearcher(s.searcher, s.MaxEstimatedCost)
+	}
+	s.searcher = liveQuerySearcher{Streamer: s.searcher, queries: &s.queries}
+
 	mux := http.NewServeMux()
 
+	// handle serves the pages which search, see withRepoFilter and
+	// withQueryClient.
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, s.withRepoFilter(withQueryClient(h)))
+	}
+
 	if s.HTML {
 		mux.HandleFunc("/robots.txt", s.serveRobots)
-		mux.HandleFunc("/search", s.serveSearch)
-		mux.HandleFunc("/", s.serveSearchBox)
-		mux.HandleFunc("/about", s.serveAbout)
-		mux.HandleFunc("/print", s.servePrint)
+		mux.HandleFunc("/opensearch.xml", s.serveOpenSearch)
+		handle("/search", http.HandlerFunc(s.serveSearch))
+		handle("/", http.HandlerFunc(s.serveSearchBox))
+		handle("/about", http.HandlerFunc(s.serveAbout))
+		handle("/print", http.HandlerFunc(s.servePrint))
 	}
 	if s.RPC {
-		mux.Handle("/api/", http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.Searcher})))
+		handle("/api/", http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.searcher})))
+	}
+
+	if s.SearchContexts != nil {
+		mux.HandleFunc("GET /api/contexts", s.serveSearchContexts)
 	}
 
 	mux.HandleFunc("/healthz", s.serveHealthz)
+	mux.HandleFunc("/livez", s.serveLivez)
+	mux.HandleFunc("/readyz", s.serveReadyz)
+	handle("/debug/explain", http.HandlerFunc(s.serveExplain))
+	mux.HandleFunc("GET /debug/queries", s.serveQueries)
+	mux.HandleFunc("POST /debug/queries/{id}/cancel", s.serveCancelQuery)
 
 	return mux, nil
 }
 
+// serveLivez reports whether the process is up. Unlike /healthz it does not
+// search, so a slow or overloaded searcher does not cause restarts.
+func (s *Server) serveLivez(w http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// serveReadyz reports whether the server should receive traffic. It fails
+// while shards are still being loaded on startup and once the server has
+// entered lame duck mode.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.lameDuck.Load() {
+		http.Error(w, "not ready: shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.ready.Load() {
+		_, _ = fmt.Fprintln(w, "ok")
+		return
+	}
+
+	q := &query.Const{Value: true}
+	opts := &zoekt.SearchOptions{ShardMaxMatchCount: 1, TotalMaxMatchCount: 1, MaxDocDisplayCount: 1}
+
+	// See serveHealthz for why we use WithUnsafeContext.
+	result, err := s.Searcher.Search(systemtenant.WithUnsafeContext(r.Context()), q, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("not ready: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	// The searcher reports a crash until all shards have been loaded on
+	// startup.
+	if result.Stats.Crashes > 0 {
+		http.Error(w, fmt.Sprintf("not ready: %d crashes", result.Stats.Crashes), http.StatusServiceUnavailable)
+		return
+	}
+
+	s.ready.Store(true)
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
 func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
 	q := &query.Const{Value: true}
 	opts := &zoekt.SearchOptions{ShardMaxMatchCount: 1, TotalMaxMatchCount: 1, MaxDocDisplayCount: 1}
@@ -223,19 +389,41 @@ func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(result)
 }
 
+// serveSearch serves the results of a search as HTML, or with format=json as
+// a single JSON object. With format=ndjson it writes one JSON object per line:
+// a file match, or a repository if the query only lists repositories.
 func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
-	result, err := s.serveSearchErr(r)
+	params, err := zjson.ParseURLParams(r.URL.Query())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusTeapot)
 		return
 	}
 
-	qvals := r.URL.Query()
-	if qvals.Get("format") == "json" {
+	result, err := s.serveSearchErr(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+
+	switch params.Format {
+	case "json":
 		w.Header().Add("Content-Type", "application/json")
 		encoder := json.NewEncoder(w)
 		encoder.Encode(result)
 		return
+	case "ndjson":
+		w.Header().Add("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		if result.Repos != nil {
+			for _, repo := range result.Repos.Repos {
+				encoder.Encode(repo)
+			}
+		} else if result.Result != nil {
+			for _, f := range result.Result.FileMatches {
+				encoder.Encode(f)
+			}
+		}
+		return
 	}
 
 	var buf bytes.Buffer
@@ -251,7 +439,7 @@ func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf.Bytes())
 }
 
-func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
+func (s *Server) serveSearchErr(r *http.Request, params zjson.URLParams) (*ApiSearchResult, error) {
 	qvals := r.URL.Query()
 
 	debugScore, _ := strconv.ParseBool(qvals.Get("debug"))
@@ -268,8 +456,11 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 
 	repoOnly := true
 	query.VisitAtoms(q, func(q query.Q) {
-		_, ok := q.(*query.Repo)
-		repoOnly = repoOnly && ok
+		switch q.(type) {
+		case *query.Repo, *query.SearchContext:
+		default:
+			repoOnly = false
+		}
 	})
 	if repoOnly {
 		repos, err := s.serveListReposErr(q, queryStr, r)
@@ -287,45 +478,88 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 		return nil, err
 	}
 
-	numStr := qvals.Get("num")
-
-	num, err := strconv.Atoi(numStr)
-	if err != nil || num <= 0 {
+	num := params.Num
+	if num == 0 {
 		num = defaultNumResults
 	}
+	numCtxLines := params.Ctx
 
 	sOpts := zoekt.SearchOptions{
-		MaxWallTime: 10 * time.Second,
+		MaxWallTime:     10 * time.Second,
+		NumContextLines: numCtxLines,
 	}
 
-	numCtxLines := 0
-	if ctxLinesStr := qvals.Get("ctx"); ctxLinesStr != "" {
-		numCtxLines, err = strconv.Atoi(ctxLinesStr)
-		if err != nil || numCtxLines < 0 || numCtxLines > 10 {
-			return nil, fmt.Errorf("Number of context lines must be between 0 and 10")
-		}
-	}
-	sOpts.NumContextLines = numCtxLines
-
 	sOpts.SetDefaults()
 	sOpts.MaxDocDisplayCount = num
 	sOpts.DebugScore = debugScore
+	// Show each file once, with a selector for the branches it differs on.
+	sOpts.GroupByBranch = true
+	sOpts.MaxSuggestions = maxSuggestions
 
 	ctx := r.Context()
-	if err := zjson.CalculateDefaultSearchLimits(ctx, q, s.Searcher, &sOpts); err != nil {
+	if err := zjson.CalculateDefaultSearchLimits(ctx, q, s.searcher, &sOpts); err != nil {
 		return nil, err
 	}
 
-	result, err := s.Searcher.Search(ctx, q, &sOpts)
+	result, err := s.searcher.Search(ctx, q, &sOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	fileMatches, err := s.formatResults(result, queryStr, s.Print)
+	if s.Reranker != nil {
+		// Reranking is best effort, on failure we show zoekt's ranking.
+		if files, err := s.Reranker.Rerank(ctx, queryStr, result.Files); err != nil {
+			log.Printf("rerank %q: %v", queryStr, err)
+		} else {
+			result.Files = files
+		}
+	}
+
+	html := params.Format == "" || params.Format == "html"
+	fileMatches, err := s.formatResults(result, queryStr, s.Print, html)
 	if err != nil {
 		return nil, err
 	}
 
+	stats := result.Stats
+	if s.UnindexedSearch != nil {
+		unindexed, err := s.searchUnindexed(ctx, q, num-len(fileMatches))
+		if err != nil {
+			return nil, err
+		}
+		if unindexed != nil {
+			unindexedMatches, err := s.formatResults(unindexed, queryStr, false, html)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range unindexedMatches {
+				f.Unindexed = true
+				if unindexed.RepoURLs[f.Repo] == "" {
+					// The print page only shows indexed files.
+					f.URL = ""
+					for i := range f.Matches {
+						f.Matches[i].URL = ""
+					}
+				}
+			}
+			fileMatches = append(fileMatches, unindexedMatches...)
+			stats.Add(unindexed.Stats)
+		}
+	}
+
+	var facetRepos []string
+	if len(result.Files) > 0 {
+		// result.Files is capped at num, list all matching repos for the
+		// repository facet.
+		repos, err := s.searcher.List(ctx, q, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range repos.Repos {
+			facetRepos = append(facetRepos, r.Repository.Name)
+		}
+	}
+
 	res := ResultInput{
 		Last: LastInput{
 			Query:     queryStr,
@@ -333,10 +567,12 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 			Ctx:       numCtxLines,
 			AutoFocus: true,
 		},
-		Stats:       result.Stats,
+		Stats:       stats,
 		Query:       q.String(),
 		QueryStr:    queryStr,
 		FileMatches: fileMatches,
+		Facets:      computeFacets(queryStr, result.Files, facetRepos),
+		Suggestions: suggestedQueries(queryStr, result.Suggestions),
 	}
 	if res.Stats.Wait < res.Stats.Duration/10 {
 		// Suppress queueing stats if they are neglible.
@@ -347,6 +583,19 @@ func (s *Server) serveSearchErr(r *http.Request) (*ApiSearchResult, error) {
 	return &ApiSearchResult{Result: &res}, nil
 }
 
+// suggestedQueries applies suggestions to queryStr. Suggestions for
+// substrings which don't appear in queryStr as typed, eg. because they were
+// escaped, are dropped.
+func suggestedQueries(queryStr string, suggestions []zoekt.Suggestion) []string {
+	var queries []string
+	for _, s := range suggestions {
+		if strings.Contains(queryStr, s.Pattern) {
+			queries = append(queries, strings.ReplaceAll(queryStr, s.Pattern, s.Replacement))
+		}
+	}
+	return queries
+}
+
 func (s *Server) servePrint(w http.ResponseWriter, r *http.Request) {
 	err := s.servePrintErr(w, r)
 	if err != nil {
@@ -357,6 +606,16 @@ func (s *Server) servePrint(w http.ResponseWriter, r *http.Request) {
 const statsStaleNess = 30 * time.Second
 
 func (s *Server) fetchStats(ctx context.Context) (*zoekt.RepoStats, error) {
+	// The cached stats cover all repositories, so they can't be shared with
+	// requests restricted by a repository filter.
+	if repoFilterFromContext(ctx) != nil {
+		repos, err := s.searcher.List(ctx, &query.Const{Value: true}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &repos.Stats, nil
+	}
+
 	s.lastStatsMu.Lock()
 	stats := s.lastStats
 	if time.Since(s.lastStatsTS) > statsStaleNess {
@@ -368,7 +627,7 @@ func (s *Server) fetchStats(ctx context.Context) (*zoekt.RepoStats, error) {
 		return stats, nil
 	}
 
-	repos, err := s.Searcher.List(ctx, &query.Const{Value: true}, nil)
+	repos, err := s.searcher.List(ctx, &query.Const{Value: true}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -469,7 +728,7 @@ func (s *Server) serveRobots(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) serveListReposErr(q query.Q, qStr string, r *http.Request) (*RepoListInput, error) {
 	ctx := r.Context()
-	repos, err := s.Searcher.List(ctx, q, nil)
+	repos, err := s.searcher.List(ctx, q, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -539,12 +798,15 @@ func (s *Server) serveListReposErr(q query.Q, qStr string, r *http.Request) (*Re
 		t := s.getTextTemplate(r.Repository.CommitURLTemplate)
 
 		repo := Repository{
-			Name:       r.Repository.Name,
-			URL:        r.Repository.URL,
-			IndexTime:  r.IndexMetadata.IndexTime,
-			Size:       r.Stats.ContentBytes,
-			MemorySize: r.Stats.IndexBytes,
-			Files:      int64(r.Stats.Documents),
+			Name:        r.Repository.Name,
+			URL:         r.Repository.URL,
+			Description: r.Repository.Description,
+			Homepage:    r.Repository.Homepage,
+			IndexTime:   r.IndexMetadata.IndexTime,
+			Size:        r.Stats.ContentBytes,
+			MemorySize:  r.Stats.IndexBytes,
+			Files:       int64(r.Stats.Documents),
+			Languages:   repoLanguages(r.Stats.Languages),
 		}
 		for _, b := range r.Repository.Branches {
 			var buf bytes.Buffer
@@ -600,7 +862,7 @@ func (s *Server) servePrintErr(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	ctx := r.Context()
-	result, err := s.Searcher.Search(ctx, q, &sOpts)
+	result, err := s.searcher.Search(ctx, q, &sOpts)
 	if err != nil {
 		return err
 	}
@@ -647,3 +909,40 @@ func (s *Server) servePrintErr(w http.ResponseWriter, r *http.Request) error {
 	_, _ = w.Write(buf.Bytes())
 	return nil
 }
+
+// minLanguagePercent is the smallest share of a language in the language bar
+// of a repository. Smaller ones are shown as "Other".
+const minLanguagePercent = 1.0
+
+// repoLanguages returns the shares of the languages in the content of a
+// repository for its language bar, most bytes first.
+func repoLanguages(stats map[string]zoekt.LanguageStats) []Language {
+	var total int64
+	for _, ls := range stats {
+		total += ls.Bytes
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var languages []Language
+	other := Language{Name: "Other", Color: enry.GetColor("")}
+	for name, ls := range stats {
+		percent := 100 * float64(ls.Bytes) / float64(total)
+		if percent < minLanguagePercent {
+			other.Percent += percent
+			continue
+		}
+		languages = append(languages, Language{Name: name, Percent: percent, Color: enry.GetColor(name)})
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if languages[i].Percent != languages[j].Percent {
+			return languages[i].Percent > languages[j].Percent
+		}
+		return languages[i].Name < languages[j].Name
+	})
+	if other.Percent > 0 {
+		languages = append(languages, other)
+	}
+	return languages
+}
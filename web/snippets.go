@@ -16,16 +16,22 @@ package web
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/index"
 )
 
-func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPrint bool) ([]*FileMatch, error) {
+// formatResults converts result for the results template and the JSON API.
+// If html is set, the snippets are syntax highlighted for the results
+// template, see Server.HighlightStyle.
+func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPrint, html bool) ([]*FileMatch, error) {
 	var fmatches []*FileMatch
 
 	templateMap := map[string]*template.Template{}
@@ -42,10 +48,16 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			}
 		}
 	}
-	getFragment := func(repo string, linenum int) string {
+	// Files expanded from archives can't be linked on the code host, so they
+	// link to the print page instead.
+	printArchiveMember := func(filename string) bool {
+		_, _, ok := index.SplitArchivePath(filename)
+		return ok
+	}
+	getFragment := func(repo, filename string, linenum int) string {
 		tpl := fragmentMap[repo]
 
-		if tpl == nil || localPrint {
+		if tpl == nil || localPrint || printArchiveMember(filename) {
 			return "#l" + strconv.Itoa(linenum)
 		}
 
@@ -60,7 +72,7 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 	}
 	getURL := func(repo, filename string, branches []string, version string) string {
 		tpl := templateMap[repo]
-		if localPrint || tpl == nil {
+		if localPrint || tpl == nil || printArchiveMember(filename) {
 			v := make(url.Values)
 			v.Add("r", repo)
 			v.Add("f", filename)
@@ -88,34 +100,17 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 		return buf.String()
 	}
 
-	// hash => result-id
-	seenFiles := map[string]string{}
-	for _, f := range result.Files {
-		fMatch := FileMatch{
-			FileName:   f.FileName,
-			Repo:       f.Repository,
-			ResultID:   f.Repository + ":" + f.FileName,
-			Branches:   f.Branches,
-			Language:   f.Language,
-			Score:      f.Score,
-			ScoreDebug: f.Debug,
-		}
-
-		if dup, ok := seenFiles[string(f.Checksum)]; ok {
-			fMatch.DuplicateID = dup
-		} else {
-			seenFiles[string(f.Checksum)] = fMatch.ResultID
-		}
-
+	getFileURL := func(f zoekt.FileMatch, branches []string, version string) string {
 		if f.SubRepositoryName != "" {
-			fn := strings.TrimPrefix(fMatch.FileName[len(f.SubRepositoryPath):], "/")
-			fMatch.URL = getURL(f.SubRepositoryName, fn, f.Branches, f.Version)
-		} else {
-			fMatch.URL = getURL(f.Repository, f.FileName, f.Branches, f.Version)
+			fn := strings.TrimPrefix(f.FileName[len(f.SubRepositoryPath):], "/")
+			return getURL(f.SubRepositoryName, fn, branches, version)
 		}
-
-		for _, m := range f.LineMatches {
-			fragment := getFragment(f.Repository, m.LineNumber)
+		return getURL(f.Repository, f.FileName, branches, version)
+	}
+	getMatches := func(f zoekt.FileMatch, lineMatches []zoekt.LineMatch, fileURL string) []Match {
+		var matches []Match
+		for _, m := range lineMatches {
+			fragment := getFragment(f.Repository, f.FileName, m.LineNumber)
 			if !strings.HasPrefix(fragment, "#") && !strings.HasPrefix(fragment, ";") {
 				// TODO - remove this is backward compatibility glue.
 				fragment = "#" + fragment
@@ -123,7 +118,7 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			md := Match{
 				FileName: f.FileName,
 				LineNum:  m.LineNumber,
-				URL:      fMatch.URL + fragment,
+				URL:      fileURL + fragment,
 
 				Score:      m.Score,
 				ScoreDebug: m.DebugScore,
@@ -131,6 +126,8 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 
 			md.Before = string(m.Before)
 			md.After = string(m.After)
+			md.BeforeLines = contextLines(md.Before, m.LineNumber-strings.Count(md.Before, "\n"))
+			md.AfterLines = contextLines(md.After, m.LineNumber+1)
 			lastEnd := 0
 			line := m.Line
 			for i, f := range m.LineFragments {
@@ -138,8 +135,9 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 				e := l + f.MatchLength
 
 				frag := Fragment{
-					Pre:   string(line[lastEnd:l]),
-					Match: string(line[l:e]),
+					Pre:        string(line[lastEnd:l]),
+					Match:      string(line[l:e]),
+					SymbolInfo: f.SymbolInfo,
 				}
 				if i == len(m.LineFragments)-1 {
 					frag.Post = string(m.Line[e:])
@@ -148,9 +146,115 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 				md.Fragments = append(md.Fragments, frag)
 				lastEnd = e
 			}
-			fMatch.Matches = append(fMatch.Matches, md)
+			matches = append(matches, md)
+		}
+		matches = mergeContextLines(matches)
+		if html && s.highlighter != nil {
+			s.highlighter.highlightMatches(f.Language, f.FileName, matches)
+		}
+		return matches
+	}
+
+	// hash => result-id
+	seenFiles := map[string]string{}
+	for _, f := range result.Files {
+		fMatch := FileMatch{
+			FileName:   f.FileName,
+			Repo:       f.Repository,
+			ResultID:   f.Repository + ":" + f.FileName,
+			Branches:   f.Branches,
+			Language:   f.Language,
+			Version:    f.Version,
+			CommitDate: f.CommitDate,
+			IndexTime:  f.IndexTime,
+			MatchCount: f.MatchCount,
+			LineCount:  f.LineCount,
+			FileSize:   f.FileSize,
+			Score:      f.Score,
+			ScoreDebug: f.Debug,
+		}
+		for _, m := range f.LineMatches {
+			if !m.FileName {
+				fMatch.MatchesShown += len(m.LineFragments)
+			}
+		}
+
+		if dup, ok := seenFiles[string(f.Checksum)]; ok {
+			fMatch.DuplicateID = dup
+		} else {
+			seenFiles[string(f.Checksum)] = fMatch.ResultID
+		}
+
+		fMatch.URL = getFileURL(f, f.Branches, f.Version)
+		fMatch.Matches = getMatches(f, f.LineMatches, fMatch.URL)
+
+		for i, bm := range f.BranchMatches {
+			v := BranchVersion{
+				ID:       fmt.Sprintf("%s@%d", fMatch.ResultID, i),
+				Branches: bm.Branches,
+				URL:      getFileURL(f, bm.Branches, bm.Version),
+			}
+			v.Matches = getMatches(f, bm.LineMatches, v.URL)
+			fMatch.Versions = append(fMatch.Versions, v)
 		}
+
 		fmatches = append(fmatches, &fMatch)
 	}
 	return fmatches, nil
 }
+
+// contextLines splits text into lines, numbering them from first.
+func contextLines(text string, first int) []ContextLine {
+	if text == "" {
+		return nil
+	}
+	var lines []ContextLine
+	for i, l := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		lines = append(lines, ContextLine{LineNum: first + i, Line: l})
+	}
+	return lines
+}
+
+// mergeContextLines drops the context lines which would be shown twice
+// because the context of neighbouring matches overlaps. The matches are
+// reordered by line number, so that every line of the file is shown at most
+// once and in order, either as a match or as context.
+func mergeContextLines(matches []Match) []Match {
+	hasContext := false
+	for _, m := range matches {
+		if len(m.BeforeLines) > 0 || len(m.AfterLines) > 0 {
+			hasContext = true
+			break
+		}
+	}
+	if !hasContext {
+		return matches
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].LineNum < matches[j].LineNum
+	})
+
+	last := 0
+	for i := range matches {
+		m := &matches[i]
+		for len(m.BeforeLines) > 0 && m.BeforeLines[0].LineNum <= last {
+			m.BeforeLines = m.BeforeLines[1:]
+		}
+		next := -1
+		if i+1 < len(matches) {
+			next = matches[i+1].LineNum
+		}
+		for j, l := range m.AfterLines {
+			if next >= 0 && l.LineNum >= next {
+				m.AfterLines = m.AfterLines[:j]
+				break
+			}
+		}
+		last = m.LineNum
+		if n := len(m.AfterLines); n > 0 {
+			last = m.AfterLines[n-1].LineNum
+		}
+	}
+	return matches
+}
@@ -29,6 +29,7 @@ var TemplateText = map[string]string{
 <meta charset="utf-8">
 <meta http-equiv="X-UA-Compatible" content="IE=edge">
 <meta name="viewport" content="width=device-width, initial-scale=1">
+<link rel="search" type="application/opensearchdescription+xml" title="Zoekt" href="opensearch.xml">
 <!-- Licensed under MIT (https://github.com/twbs/bootstrap/blob/master/LICENSE) -->
 <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css" integrity="sha384-BVYiiSIFeK1dGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
 <style>
@@ -64,7 +65,12 @@ var TemplateText = map[string]string{
      overflow: unset;
   }
   :target { background-color: #ccf; }
+  .facets h6 { margin-top: 16px; font-weight: bold; }
+  .facets ul { padding-left: 0; list-style: none; }
+  .facets li { overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
   table tbody tr td { border: none !important; padding: 2px !important; }
+  .language-bar { display: flex; height: 8px; margin-top: 4px; border-radius: 4px; overflow: hidden; max-width: 400px; }
+  .language-bar span { display: block; height: 100%; }
 </style>
 </head>
   `,
@@ -117,6 +123,10 @@ var TemplateText = map[string]string{
             <div class="input-group-addon">Max Results</div>
             <input class="form-control" type="number" id="maxhits" name="num" value="{{.Num}}">
           </div>
+          <div class="input-group">
+            <div class="input-group-addon">Context Lines</div>
+            <input class="form-control" type="number" id="ctxlines" name="ctx" min="0" max="10" value="{{.Ctx}}">
+          </div>
           <button class="btn btn-primary">Search</button>
           <!--Hack: we use a hidden form field to keep track of the debug flag across searches-->
           {{if .Debug}}<input id="debug" name="debug" type="hidden" value="{{.Debug}}">{{end}}
@@ -167,6 +177,9 @@ document.onkeydown=function(e){
           <dt><a href="search?q=f:%5C.c%24">f:\.c$</a></dt><dd>search for files whose name ends with ".c"</dd>
           <dt><a href="search?q=path+-file:java">path -file:java</a></dt><dd>search for the word "path" excluding files whose name contains "java"</dd>
           <dt><a href="search?q=foo.*bar">foo.*bar</a></dt><dd>search for the regular expression "foo.*bar"</dd>
+          <dt><a href="search?q=patterntype:literal+foo%28">patterntype:literal foo(</a></dt><dd>search for the literal string "foo(" rather than a regular expression</dd>
+          <dt><a href="search?q=patterntype:structural+%22foo%28:%5Ba%5D,+:%5Bb%5D%29%22">patterntype:structural "foo(:[a], :[b])"</a></dt><dd>search for calls of "foo" with two arguments, which may contain parentheses or span lines (experimental)</dd>
+          <dt><a href="search?q=foo.*bar+multiline:yes">foo.*bar multiline:yes</a></dt><dd>search for the regular expression "foo.*bar", where a match may span several lines</dd>
           <dt><a href="search?q=-%28Path File%29 Stream">-(Path File) Stream</a></dt><dd>search "Stream", but exclude files containing both "Path" and "File"</dd>
           <dt><a href="search?q=-Path%5c+file+Stream">-Path\ file Stream</a></dt><dd>search "Stream", but exclude files containing "Path File"</dd>
           <dt><a href="search?q=sym:data">sym:data</a></span></dt><dd>search for symbol definitions containing "data"</dd>
@@ -199,6 +212,37 @@ document.onkeydown=function(e){
   </nav>
 </body>
 </html>
+`,
+	// a facet in the sidebar of the search results.
+	"facet": `
+{{if .Values}}
+<h6>{{.Title}}</h6>
+<ul>
+  {{range .Values}}
+  <li title="{{.Value}}"><a href="search?q={{.Query}}&num={{$.Last.Num}}{{if $.Last.Ctx}}&ctx={{$.Last.Ctx}}{{end}}">{{.Value}}</a>{{if .Count}} <span class="badge">{{.Count}}</span>{{end}}</li>
+  {{end}}
+</ul>
+{{end}}
+`,
+	// a matching line and its context in the search results.
+	"match": `
+{{if gt .LineNum 0}}
+{{range .BeforeLines}}
+<tr>
+  <td><pre class="inline-pre"><span class="noselect"><u>{{.LineNum}}</u>- </span>{{if .Highlighted}}{{.Highlighted}}{{else}}{{LimitPost 200 .Line}}{{end}}</pre></td>
+</tr>
+{{end}}
+<tr>
+  <td style="background-color: rgba(238, 238, 255, 0.6);">
+    <pre class="inline-pre"><span class="noselect">{{if .URL}}<a href="{{.URL}}">{{end}}<u>{{.LineNum}}</u>{{if .URL}}</a>{{end}}: </span>{{if .Highlighted}}{{.Highlighted}}{{else}}{{range .Fragments}}{{LimitPre 100 .Pre}}<b>{{.Match}}</b>{{LimitPost 100 (TrimTrailingNewline .Post)}}{{end}}{{end}}{{range .Fragments}}{{with .SymbolInfo}} <span class="label label-info"{{if .Signature}} title="{{.Signature}}"{{end}}>{{.Kind}} {{.Sym}}{{if .Parent}} in {{.ParentKind}} {{.Parent}}{{end}}</span>{{end}}{{end}} {{if .ScoreDebug}}<i>({{.ScoreDebug}})</i>{{end}}</pre>
+  </td>
+</tr>
+{{range .AfterLines}}
+<tr>
+  <td><pre class="inline-pre"><span class="noselect"><u>{{.LineNum}}</u>- </span>{{if .Highlighted}}{{.Highlighted}}{{else}}{{LimitPost 200 .Line}}{{end}}</pre></td>
+</tr>
+{{end}}
+{{end}}
 `,
 	"footerBoilerplate": `<a class="navbar-text" href="about">About</a>`,
 	"results": `
@@ -208,7 +252,14 @@ document.onkeydown=function(e){
 <script>
   function zoektAddQ(atom) {
       window.location.href = "/search?q=" + escape("{{.QueryStr}}" + " " + atom) +
-	  "&" + "num=" + {{.Last.Num}};
+	  "&" + "num=" + {{.Last.Num}} + "&" + "ctx=" + {{.Last.Ctx}};
+  }
+  // zoektShowVersion shows the matches of the version of a file selected in
+  // its branch selector.
+  function zoektShowVersion(sel) {
+      for (const opt of sel.options) {
+          document.getElementById(opt.value).style.display = opt.selected ? "" : "none";
+      }
   }
 </script>
 <body id="results">
@@ -219,9 +270,21 @@ document.onkeydown=function(e){
       {{ $fileCount := len .FileMatches }}
       Found {{.Stats.MatchCount}} results in {{.Stats.FileCount}} files{{if or (lt $fileCount .Stats.FileCount) (or (gt .Stats.ShardsSkipped 0) (gt .Stats.FilesSkipped 0)) }},
         showing top {{ $fileCount }} files (<a rel="nofollow"
-           href="search?q={{.Last.Query}}&num={{More .Last.Num}}">show more</a>).
+           href="search?q={{.Last.Query}}&num={{More .Last.Num}}{{if .Last.Ctx}}&ctx={{.Last.Ctx}}{{end}}">show more</a>).
       {{else}}.{{end}}
+      {{if .Suggestions}}<br>Did you mean
+        {{range $i, $s := .Suggestions}}{{if $i}} or {{end}}<a href="search?q={{$s}}&num={{$.Last.Num}}{{if $.Last.Ctx}}&ctx={{$.Last.Ctx}}{{end}}"><i>{{$s}}</i></a>{{end}}?
+      {{end}}
     </h5>
+    <div class="row">
+    {{if .FileMatches}}
+    <div class="col-md-2 facets">
+      {{template "facet" (Facet "Repository" .Facets.Repos .Last)}}
+      {{template "facet" (Facet "Language" .Facets.Languages .Last)}}
+      {{template "facet" (Facet "Path" .Facets.Paths .Last)}}
+    </div>
+    {{end}}
+    <div class="col-md-10">
     {{range .FileMatches}}
     <table class="table table-hover table-condensed">
       <thead>
@@ -231,29 +294,37 @@ document.onkeydown=function(e){
             <small>
               {{.Repo}}:{{.FileName}} {{if .ScoreDebug}}<i>({{.ScoreDebug}})</i>{{end}}</a>:
               <span style="font-weight: normal">[ {{if .Branches}}{{range .Branches}}<span class="label label-default">{{.}}</span>,{{end}}{{end}} ]</span>
+              {{if .Unindexed}}<span class="label label-warning" title="the commit is not indexed, so the file was searched without the index">unindexed</span>{{end}}
+              {{if not .IndexTime.IsZero}}<span style="font-weight: normal" {{if not .CommitDate.IsZero}}title="committed {{.CommitDate.Format "2006-01-02 15:04"}}"{{end}}>indexed {{Ago .IndexTime}}{{if .Version}} @ {{printf "%.7s" .Version}}{{end}}</span>{{end}}
               {{if .Language}}<button
                    title="restrict search to files written in {{.Language}}"
                    onclick="zoektAddQ('lang:&quot;{{.Language}}&quot;')" class="label label-primary">language {{.Language}}</button></span>{{end}}
+              {{if gt .MatchCount .MatchesShown}}<span style="font-weight: normal">{{.MatchCount}} matches in {{.LineCount}} lines (showing {{.MatchesShown}})</span>{{end}}
               {{if .DuplicateID}}<a class="label label-dup" href="#{{.DuplicateID}}">Duplicate result</a>{{end}}
+              {{if and .Versions (not .DuplicateID)}}<select class="branch-selector" title="the file differs between branches" onchange="zoektShowVersion(this)">
+                {{range .Versions}}<option value="{{.ID}}">{{range $j, $b := .Branches}}{{if $j}}, {{end}}{{$b}}{{end}}</option>{{end}}
+              </select>{{end}}
             </small>
           </th>
         </tr>
       </thead>
       {{if not .DuplicateID}}
+      {{if .Versions}}
+      {{range $i, $v := .Versions}}
+      <tbody id="{{$v.ID}}" class="branch-version"{{if $i}} style="display: none"{{end}}>
+        {{range $v.Matches}}{{template "match" .}}{{end}}
+      </tbody>
+      {{end}}
+      {{else}}
       <tbody>
-        {{range .Matches}}
-        {{if gt .LineNum 0}}
-        <tr>
-          <td style="background-color: rgba(238, 238, 255, 0.6);">
-            <pre class="inline-pre"><span class="noselect">{{if .URL}}<a href="{{.URL}}">{{end}}<u>{{.LineNum}}</u>{{if .URL}}</a>{{end}}: </span>{{range .Fragments}}{{LimitPre 100 .Pre}}<b>{{.Match}}</b>{{LimitPost 100 (TrimTrailingNewline .Post)}}{{end}} {{if .ScoreDebug}}<i>({{.ScoreDebug}})</i>{{end}}</pre>
-          </td>
-        </tr>
-        {{end}}
+        {{range .Matches}}{{template "match" .}}{{end}}
       </tbody>
       {{end}}
       {{end}}
     </table>
     {{end}}
+    </div>
+    </div>
 
   <nav class="navbar navbar-default navbar-bottom">
     <div class="container">
@@ -268,6 +339,9 @@ document.onkeydown=function(e){
       {{.Stats.ShardsSkippedFilter}} shards filtered
       {{- if or .Stats.FilesSkipped .Stats.ShardsSkipped -}}
         , {{.Stats.FilesSkipped}} docs skipped, {{.Stats.ShardsSkipped}} shards skipped
+      {{- end -}}
+      {{- if or .Stats.FilesSkippedTombstoned (or .Stats.FilesSkippedSize .Stats.FilesSkippedBinary) -}}
+        , {{.Stats.FilesSkippedSize}} large and {{.Stats.FilesSkippedBinary}} binary docs without indexed content, {{.Stats.FilesSkippedTombstoned}} tombstoned docs skipped
       {{- end -}}
 	  .
       </p>
@@ -302,7 +376,19 @@ document.onkeydown=function(e){
       <tbody>
 	{{range .Repos -}}
 	<tr>
-	  <td>{{if .URL}}<a href="{{.URL}}">{{end}}{{.Name}}{{if .URL}}</a>{{end}}</td>
+	  <td>
+	    {{if .URL}}<a href="{{.URL}}">{{end}}{{.Name}}{{if .URL}}</a>{{end}}
+	    {{- if .Homepage}} <small><a href="{{.Homepage}}">homepage</a></small>{{end}}
+	    {{- if .Description}}<br><small class="text-muted">{{.Description}}</small>{{end}}
+	    {{- if .Languages}}
+	    <div class="language-bar">
+	      {{- range .Languages}}<span style="width: {{printf "%.2f" .Percent}}%; background-color: {{.Color}};" title="{{.Name}} {{printf "%.1f" .Percent}}%"></span>{{end -}}
+	    </div>
+	    <small class="text-muted">
+	      {{- range $i, $l := .Languages}}{{if $i}}, {{end}}{{$l.Name}} {{printf "%.1f" $l.Percent}}%{{end -}}
+	    </small>
+	    {{- end}}
+	  </td>
 	  <td><small>{{.IndexTime.Format "Jan 02, 2006 15:04"}}</small></td>
 	  <td style="vertical-align: middle;">
 	    {{- range .Branches -}}
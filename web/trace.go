@@ -51,8 +51,32 @@ func (s traceAwareSearcher) StreamSearch(
 	return s.Searcher.StreamSearch(ctx, q, opts, sender)
 }
 
+func (s traceAwareSearcher) BatchSearch(
+	ctx context.Context,
+	qs []query.Q,
+	opts *zoekt.SearchOptions,
+) ([]*zoekt.SearchResult, error) {
+	ctx = trace.WithOpenTracingEnabled(ctx, opts.Trace)
+	spanContext := trace.SpanContextFromContext(ctx)
+	if opts.Trace && spanContext != nil {
+		var span opentracing.Span
+		span, ctx = opentracing.StartSpanFromContext(ctx, "zoekt.traceAwareSearcher.BatchSearch", opentracing.ChildOf(spanContext))
+		defer span.Finish()
+	}
+	return zoekt.BatchSearch(ctx, s.Searcher, qs, opts)
+}
+
 func (s traceAwareSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
 	return s.Searcher.List(ctx, q, opts)
 }
+
+func (s traceAwareSearcher) StreamList(ctx context.Context, q query.Q, opts *zoekt.ListOptions, sender zoekt.ListSender) error {
+	return zoekt.StreamList(ctx, s.Searcher, q, opts, sender)
+}
+
+func (s traceAwareSearcher) FetchDocument(ctx context.Context, req *zoekt.DocumentRequest) (*zoekt.Document, error) {
+	return zoekt.FetchDocument(ctx, s.Searcher, req)
+}
+
 func (s traceAwareSearcher) Close()         { s.Searcher.Close() }
 func (s traceAwareSearcher) String() string { return s.Searcher.String() }